@@ -33,12 +33,30 @@ type AzureClusterSpec struct {
 
 	ResourceGroup string `json:"resourceGroup"`
 
+	// ExternalResourceGroup is true if the resource group is managed outside of the Azure provider, which then
+	// neither creates nor deletes it.
+	// +optional
+	ExternalResourceGroup bool `json:"externalResourceGroup,omitempty"`
+
 	Location string `json:"location"`
 
 	// AdditionalTags is an optional set of tags to add to Azure resources managed by the Azure provider, in addition to the
 	// ones added by default.
 	// +optional
 	AdditionalTags Tags `json:"additionalTags,omitempty"`
+
+	// ProximityPlacementGroup places the control plane machines, or all the machines, of the cluster in a proximity
+	// placement group of the cluster to lower the network latency between them. Azure places the virtual machines of a
+	// proximity placement group in a single datacenter, so they should not be spread across availability zones.
+	// None, ControlPlane or All. Defaults to None.
+	// +kubebuilder:validation:Enum=None;ControlPlane;All
+	// +optional
+	ProximityPlacementGroup ProximityPlacementGroupScope `json:"proximityPlacementGroup,omitempty"`
+
+	// Identity is the Azure identity used to manage the resources of the cluster. Defaults to the identity
+	// configured by the environment variables of the provider.
+	// +optional
+	Identity *ClusterIdentity `json:"identity,omitempty"`
 }
 
 // AzureClusterStatus defines the observed state of AzureCluster
@@ -54,6 +72,16 @@ type AzureClusterStatus struct {
 	// APIEndpoints represents the endpoints to communicate with the control plane.
 	// +optional
 	APIEndpoints []APIEndpoint `json:"apiEndpoints,omitempty"`
+
+	// Conditions report whether the network and the load balancers of the cluster are reconciled, with the reason
+	// and the error when they are not.
+	// +optional
+	Conditions Conditions `json:"conditions,omitempty"`
+
+	// LongRunningOperationState is the state of the pending Azure operation on the VPN gateway of the cluster, which
+	// is polled until it completes.
+	// +optional
+	LongRunningOperationState *Future `json:"longRunningOperationState,omitempty"`
 }
 
 // +kubebuilder:object:root=true
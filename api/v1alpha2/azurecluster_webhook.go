@@ -0,0 +1,554 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// resourceGroupMaxLength is the maximum length of an Azure resource group name.
+	resourceGroupMaxLength = 90
+)
+
+// resourceGroupNameRegex matches the names allowed by Azure for a resource group: letters, digits, underscores,
+// parentheses, hyphens and periods, except for a period at the end.
+var resourceGroupNameRegex = regexp.MustCompile(`^[-\p{L}\p{N}_.()]*[-\p{L}\p{N}_()]$`)
+
+// vnetIDRegex matches the resource IDs of vnets.
+var vnetIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/virtualNetworks/[^/]+$`)
+
+// SetupWebhookWithManager registers the validating webhook of AzureCluster with the manager.
+func (c *AzureCluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(c).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infrastructure-cluster-x-k8s-io-v1alpha2-azurecluster,mutating=false,failurePolicy=fail,groups=infrastructure.cluster.x-k8s.io,resources=azureclusters,versions=v1alpha2,name=validation.azurecluster.infrastructure.cluster.x-k8s.io
+
+var _ webhook.Validator = &AzureCluster{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+func (c *AzureCluster) ValidateCreate() error {
+	return c.validateCluster()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+func (c *AzureCluster) ValidateUpdate(old runtime.Object) error {
+	return c.validateCluster()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+func (c *AzureCluster) ValidateDelete() error {
+	return nil
+}
+
+// validateCluster returns an Invalid error with all the errors of the spec, or nil if the spec is valid.
+func (c *AzureCluster) validateCluster() error {
+	specPath := field.NewPath("spec")
+	var allErrs field.ErrorList
+	if c.Spec.Location == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("location"), "location is required"))
+	}
+	allErrs = append(allErrs, validateResourceGroup(c.Spec.ResourceGroup, specPath.Child("resourceGroup"))...)
+	allErrs = append(allErrs, validateNetwork(c.Spec.NetworkSpec, c.Spec.ResourceGroup, specPath.Child("networkSpec"))...)
+	allErrs = append(allErrs, validateIdentity(c.Spec.Identity, specPath.Child("identity"))...)
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("AzureCluster").GroupKind(), c.Name, allErrs)
+}
+
+// validateResourceGroup validates the name of a resource group against the naming rules of Azure.
+func validateResourceGroup(resourceGroup string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	switch {
+	case resourceGroup == "":
+		allErrs = append(allErrs, field.Required(fldPath, "resource group is required"))
+	case len(resourceGroup) > resourceGroupMaxLength:
+		allErrs = append(allErrs, field.TooLong(fldPath, resourceGroup, resourceGroupMaxLength))
+	case !resourceGroupNameRegex.MatchString(resourceGroup):
+		allErrs = append(allErrs, field.Invalid(fldPath, resourceGroup,
+			"resource group names can only contain letters, digits, underscores, parentheses, hyphens and periods, and cannot end with a period"))
+	}
+	return allErrs
+}
+
+// validateIdentity validates that a service principal identity references a secret, and that a managed identity
+// does not.
+func validateIdentity(identity *ClusterIdentity, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if identity == nil {
+		return allErrs
+	}
+	switch identity.Type {
+	case "", ServicePrincipalIdentity:
+		if identity.SecretRef == nil || identity.SecretRef.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("secretRef", "name"), "the name of the identity secret is required"))
+		}
+		if identity.ClientID != "" {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("clientID"), "the client ID of a service principal is read from its secret"))
+		}
+	case ManagedIdentity:
+		if identity.SecretRef != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("secretRef"), "a managed identity needs no secret"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), identity.Type,
+			[]string{string(ServicePrincipalIdentity), string(ManagedIdentity)}))
+	}
+	return allErrs
+}
+
+// validateNetwork validates the vnet and the CIDR blocks of the subnets. The CIDR blocks of the subnets must be
+// within the CIDR blocks of the vnet when they are set, and must not overlap each other. A subnet has at most one
+// CIDR block per IP family, and a dual-stack subnet needs an IPv4 CIDR block along its IPv6 one.
+func validateNetwork(networkSpec NetworkSpec, resourceGroup string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	vnetPath := fldPath.Child("vnet")
+	vnet := networkSpec.Vnet
+
+	// a vnet of another resource group is an existing vnet, which is not created with the cluster.
+	if vnet.ResourceGroup != "" && vnet.ResourceGroup != resourceGroup && vnet.ID == "" {
+		allErrs = append(allErrs, field.Required(vnetPath.Child("id"),
+			"the ID of the vnet is required when it is in a resource group other than the cluster resource group"))
+	}
+
+	if plan := vnet.DdosProtectionPlan; plan != nil && plan.ID != "" && plan.Name != "" {
+		allErrs = append(allErrs, field.Forbidden(vnetPath.Child("ddosProtectionPlan", "name"),
+			"the name is only used by a DDoS protection plan created with the cluster, which a referenced plan is not"))
+	}
+
+	for i, server := range vnet.DNSServers {
+		if net.ParseIP(server) == nil {
+			allErrs = append(allErrs, field.Invalid(vnetPath.Child("dnsServers").Index(i), server, "invalid IP address"))
+		}
+	}
+
+	vnetCIDRs, errs := parseCIDRBlocks(vnet.CidrBlock, vnet.CIDRBlocks, vnetPath)
+	allErrs = append(allErrs, errs...)
+
+	if privateEndpoint := networkSpec.APIServerPrivateEndpoint; privateEndpoint != nil {
+		privateEndpointPath := fldPath.Child("apiServerPrivateEndpoint")
+		if privateEndpoint.SubnetID == "" {
+			allErrs = append(allErrs, field.Required(privateEndpointPath.Child("subnetID"), "the ID of the subnet of the private endpoint is required"))
+		}
+		if networkSpec.APIServerLB.SKU == SKUBasic {
+			allErrs = append(allErrs, field.Forbidden(privateEndpointPath,
+				"the private endpoint requires a private link service, which Basic load balancers do not support"))
+		}
+	}
+
+	allErrs = append(allErrs, validateFrontendZones(networkSpec.APIServerLB, fldPath.Child("apiServerLB", "frontendZones"))...)
+	allErrs = append(allErrs, validateAdditionalRules(networkSpec.APIServerLB.AdditionalRules, fldPath.Child("apiServerLB", "additionalRules"))...)
+
+	if publicIP := networkSpec.APIServerLB.PublicIP; publicIP != nil && publicIP.ExternallyManaged && publicIP.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("apiServerLB", "publicIP", "name"),
+			"the name of an externally managed public IP is required, it is not created with the cluster"))
+	}
+
+	allErrs = append(allErrs, validateVnetPeerings(networkSpec.VnetPeerings, fldPath.Child("vnetPeerings"))...)
+
+	subnetCIDRs := make([][]cidrBlock, len(networkSpec.Subnets))
+	for i, subnet := range networkSpec.Subnets {
+		if subnet == nil {
+			continue
+		}
+		subnetPath := fldPath.Child("subnets").Index(i)
+		allErrs = append(allErrs, validateServiceEndpoints(subnet.ServiceEndpoints, subnetPath.Child("serviceEndpoints"))...)
+		allErrs = append(allErrs, validateDelegations(subnet, subnetPath.Child("delegations"))...)
+		allErrs = append(allErrs, validateSecurityRules(subnet.SecurityGroup.SecurityRules, subnetPath.Child("securityGroup", "ingressRule"))...)
+		cidrs, errs := parseCIDRBlocks(subnet.CidrBlock, subnet.CIDRBlocks, subnetPath)
+		allErrs = append(allErrs, errs...)
+		allErrs = append(allErrs, validateSubnetIPFamilies(subnet, cidrs, subnetPath)...)
+		for _, block := range cidrs {
+			if len(vnetCIDRs) > 0 && !anyCIDRContains(vnetCIDRs, block.cidr) {
+				allErrs = append(allErrs, field.Invalid(block.path, block.value, "subnet CIDR block must be within "+describeCIDRBlocks(vnetCIDRs)+" of the vnet"))
+			}
+			for j, others := range subnetCIDRs[:i] {
+				for _, other := range others {
+					if cidrsOverlap(other.cidr, block.cidr) {
+						allErrs = append(allErrs, field.Invalid(block.path, block.value,
+							"subnet CIDR block overlaps with the CIDR block "+other.cidr.String()+" of subnet "+networkSpec.Subnets[j].Name))
+					}
+				}
+			}
+		}
+		subnetCIDRs[i] = cidrs
+	}
+
+	if ip := networkSpec.APIServerLB.FrontendPrivateIP; ip != "" {
+		allErrs = append(allErrs, validateFrontendPrivateIP(ip, networkSpec.Subnets, subnetCIDRs, fldPath.Child("apiServerLB", "frontendPrivateIP"))...)
+	}
+	if gateway := networkSpec.VPNGateway; gateway != nil && gateway.GatewaySubnetCIDR != "" {
+		allErrs = append(allErrs, validateGatewaySubnetCIDR(gateway.GatewaySubnetCIDR, vnetCIDRs, networkSpec.Subnets, subnetCIDRs,
+			fldPath.Child("vpnGateway", "gatewaySubnetCidrBlock"))...)
+	}
+	if zone := networkSpec.PrivateDNSZone; zone != nil && zone.Name != "" {
+		allErrs = append(allErrs, validatePrivateDNSZoneName(zone.Name, fldPath.Child("privateDnsZone", "name"))...)
+	}
+	return allErrs
+}
+
+// validatePrivateDNSZoneName validates that the name of the private DNS zone is a lowercase DNS name with at least two
+// labels, as Azure does not accept single-label private DNS zones.
+func validatePrivateDNSZoneName(value string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(value) {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, msg))
+	}
+	if len(allErrs) == 0 && !strings.Contains(value, ".") {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "private DNS zone name must have at least two labels"))
+	}
+	return allErrs
+}
+
+// validateGatewaySubnetCIDR validates that the CIDR block of the GatewaySubnet is an IPv4 CIDR block of at least /29,
+// the smallest gateway subnet Azure allows, within the vnet and without overlapping the other subnets.
+func validateGatewaySubnetCIDR(value string, vnetCIDRs []cidrBlock, subnets Subnets, subnetCIDRs [][]cidrBlock, fldPath *field.Path) field.ErrorList {
+	_, cidr, err := net.ParseCIDR(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(fldPath, value, "invalid CIDR block")}
+	}
+	if ipFamily(cidr) != "IPv4" {
+		return field.ErrorList{field.Invalid(fldPath, value, "gateway subnet CIDR block must be an IPv4 CIDR block")}
+	}
+	var allErrs field.ErrorList
+	if ones, _ := cidr.Mask.Size(); ones > 29 {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "gateway subnet CIDR block must be /29 or larger"))
+	}
+	if len(vnetCIDRs) > 0 && !anyCIDRContains(vnetCIDRs, cidr) {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "gateway subnet CIDR block must be within "+describeCIDRBlocks(vnetCIDRs)+" of the vnet"))
+	}
+	for i, blocks := range subnetCIDRs {
+		for _, block := range blocks {
+			if cidrsOverlap(block.cidr, cidr) {
+				allErrs = append(allErrs, field.Invalid(fldPath, value,
+					"gateway subnet CIDR block overlaps with the CIDR block "+block.cidr.String()+" of subnet "+subnets[i].Name))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateFrontendPrivateIP validates that the frontend private IP of the internal load balancer is an IPv4 address,
+// within the IPv4 CIDR block of the control plane subnet when the subnet has one.
+func validateFrontendPrivateIP(value string, subnets Subnets, subnetCIDRs [][]cidrBlock, fldPath *field.Path) field.ErrorList {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.To4() == nil {
+		return field.ErrorList{field.Invalid(fldPath, value, "frontend private IP must be an IPv4 address")}
+	}
+	for i, subnet := range subnets {
+		if subnet == nil || subnet.Role != SubnetControlPlane {
+			continue
+		}
+		for _, block := range subnetCIDRs[i] {
+			if ipFamily(block.cidr) == "IPv4" && !block.cidr.Contains(ip) {
+				return field.ErrorList{field.Invalid(fldPath, value, "frontend private IP must be within the CIDR block "+block.value+" of the control plane subnet "+subnet.Name)}
+			}
+		}
+		break
+	}
+	return nil
+}
+
+// validateSecurityRules validates that the application security groups of the security rules are resource IDs of
+// application security groups, and that a rule does not have both an address prefix and application security groups
+// for its source or its destination.
+func validateSecurityRules(rules SecurityRules, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		rulePath := fldPath.Index(i)
+		allErrs = append(allErrs, validateApplicationSecurityGroupIDs(rule.SourceApplicationSecurityGroups, rulePath.Child("sourceApplicationSecurityGroups"))...)
+		allErrs = append(allErrs, validateApplicationSecurityGroupIDs(rule.DestinationApplicationSecurityGroups, rulePath.Child("destinationApplicationSecurityGroups"))...)
+		if rule.Source != nil && len(rule.SourceApplicationSecurityGroups) > 0 {
+			allErrs = append(allErrs, field.Forbidden(rulePath.Child("source"), "source cannot be set with source application security groups"))
+		}
+		if rule.Destination != nil && len(rule.DestinationApplicationSecurityGroups) > 0 {
+			allErrs = append(allErrs, field.Forbidden(rulePath.Child("destination"), "destination cannot be set with destination application security groups"))
+		}
+	}
+	return allErrs
+}
+
+// validateFrontendZones validates that the frontend zones of a load balancer are unique, and that its SKU is
+// Standard, as Basic public IPs do not support availability zones.
+func validateFrontendZones(lb LoadBalancerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(lb.FrontendZones) > 0 && lb.SKU == SKUBasic {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "availability zones require a Standard load balancer"))
+	}
+	zones := make(map[string]bool, len(lb.FrontendZones))
+	for i, zone := range lb.FrontendZones {
+		switch {
+		case zone == "":
+			allErrs = append(allErrs, field.Required(fldPath.Index(i), "the availability zone is required"))
+		case zones[zone]:
+			allErrs = append(allErrs, field.Duplicate(fldPath.Index(i), zone))
+		}
+		zones[zone] = true
+	}
+	return allErrs
+}
+
+// validateAdditionalRules validates that the additional load balancing rules have unique names, and that no two of
+// them share a frontend port for the same protocol.
+func validateAdditionalRules(rules []LoadBalancingRule, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := make(map[string]bool, len(rules))
+	ports := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		rulePath := fldPath.Index(i)
+		if names[rule.Name] {
+			allErrs = append(allErrs, field.Duplicate(rulePath.Child("name"), rule.Name))
+		}
+		names[rule.Name] = true
+		protocol := rule.Protocol
+		if protocol == "" {
+			protocol = LoadBalancingRuleProtocolTCP
+		}
+		port := fmt.Sprintf("%s/%d", protocol, rule.FrontendPort)
+		if ports[port] {
+			allErrs = append(allErrs, field.Duplicate(rulePath.Child("frontendPort"), rule.FrontendPort))
+		}
+		ports[port] = true
+	}
+	return allErrs
+}
+
+// validateVnetPeerings validates the peerings of the cluster vnet. Each peering needs the resource ID of its remote
+// vnet, and the names of the peerings which are set must be unique.
+func validateVnetPeerings(peerings []VnetPeeringSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := make(map[string]bool, len(peerings))
+	for i, peering := range peerings {
+		peeringPath := fldPath.Index(i)
+		if peering.RemoteVnetID == "" {
+			allErrs = append(allErrs, field.Required(peeringPath.Child("remoteVnetID"), "the ID of the remote vnet is required"))
+		} else if !vnetIDRegex.MatchString(peering.RemoteVnetID) {
+			allErrs = append(allErrs, field.Invalid(peeringPath.Child("remoteVnetID"), peering.RemoteVnetID,
+				"remote vnet ID must be the resource ID of a vnet"))
+		}
+		if peering.Name == "" {
+			continue
+		}
+		if names[peering.Name] {
+			allErrs = append(allErrs, field.Duplicate(peeringPath.Child("name"), peering.Name))
+		}
+		names[peering.Name] = true
+	}
+	return allErrs
+}
+
+// cidrBlock is a parsed CIDR block of a vnet or a subnet, along with its field path.
+type cidrBlock struct {
+	value string
+	path  *field.Path
+	cidr  *net.IPNet
+}
+
+// parseCIDRBlocks parses the CIDR blocks of a vnet or a subnet, its cidrBlocks when set and its cidrBlock otherwise.
+// The cidrBlock has to be one of the cidrBlocks when both are set. Invalid CIDR blocks are left out of the result.
+func parseCIDRBlocks(cidrBlockValue string, cidrBlockValues []string, fldPath *field.Path) ([]cidrBlock, field.ErrorList) {
+	var allErrs field.ErrorList
+	var blocks []cidrBlock
+	if len(cidrBlockValues) == 0 {
+		if cidrBlockValue != "" {
+			blocks = append(blocks, cidrBlock{value: cidrBlockValue, path: fldPath.Child("cidrBlock")})
+		}
+	} else {
+		found := cidrBlockValue == ""
+		for i, value := range cidrBlockValues {
+			blocks = append(blocks, cidrBlock{value: value, path: fldPath.Child("cidrBlocks").Index(i)})
+			found = found || value == cidrBlockValue
+		}
+		if !found {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("cidrBlock"), cidrBlockValue, "the CIDR block must be one of the cidrBlocks"))
+		}
+	}
+
+	var parsed []cidrBlock
+	for _, block := range blocks {
+		_, cidr, err := net.ParseCIDR(block.value)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(block.path, block.value, "invalid CIDR block"))
+			continue
+		}
+		block.cidr = cidr
+		parsed = append(parsed, block)
+	}
+	return parsed, allErrs
+}
+
+// validateSubnetIPFamilies validates that a subnet has at most one CIDR block per IP family and, when it has an IPv6
+// CIDR block, that it also has an IPv4 one. Along cidrBlocks, the cidrBlock of the subnet has to be its IPv4 one.
+func validateSubnetIPFamilies(subnet *SubnetSpec, cidrs []cidrBlock, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	first := make(map[string]cidrBlock, 2)
+	for _, block := range cidrs {
+		family := ipFamily(block.cidr)
+		if other, ok := first[family]; ok {
+			allErrs = append(allErrs, field.Invalid(block.path, block.value,
+				"subnet already has the "+family+" CIDR block "+other.value+", it can have at most one CIDR block per IP family"))
+			continue
+		}
+		first[family] = block
+	}
+	_, hasIPv4 := first["IPv4"]
+	if _, hasIPv6 := first["IPv6"]; hasIPv6 && !hasIPv4 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("cidrBlocks"), "a subnet with an IPv6 CIDR block also needs an IPv4 CIDR block"))
+	}
+	if len(subnet.CIDRBlocks) > 0 {
+		if _, cidr, err := net.ParseCIDR(subnet.CidrBlock); err == nil && ipFamily(cidr) != "IPv4" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("cidrBlock"), subnet.CidrBlock, "the CIDR block must be the IPv4 CIDR block of the subnet"))
+		}
+	}
+	return allErrs
+}
+
+// ipFamily returns the IP family of a CIDR block, IPv4 or IPv6.
+func ipFamily(cidr *net.IPNet) string {
+	if cidr.IP.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// supportedServiceEndpoints are the Azure services which subnets can access through service endpoints.
+var supportedServiceEndpoints = []string{
+	"Microsoft.AzureActiveDirectory",
+	"Microsoft.AzureCosmosDB",
+	"Microsoft.CognitiveServices",
+	"Microsoft.ContainerRegistry",
+	"Microsoft.EventHub",
+	"Microsoft.KeyVault",
+	"Microsoft.ServiceBus",
+	"Microsoft.Sql",
+	"Microsoft.Storage",
+	"Microsoft.Web",
+}
+
+// validateServiceEndpoints validates that the service endpoints of a subnet are known Azure services, each listed once.
+func validateServiceEndpoints(serviceEndpoints []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := make(map[string]bool, len(serviceEndpoints))
+	for i, service := range serviceEndpoints {
+		supported := false
+		for _, s := range supportedServiceEndpoints {
+			if service == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Index(i), service, supportedServiceEndpoints))
+			continue
+		}
+		if seen[service] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Index(i), service))
+		}
+		seen[service] = true
+	}
+	return allErrs
+}
+
+// supportedDelegations are the Azure services which subnets can be delegated to.
+var supportedDelegations = []string{
+	"Microsoft.ContainerInstance/containerGroups",
+	"Microsoft.DBforMySQL/serversv2",
+	"Microsoft.DBforPostgreSQL/serversv2",
+	"Microsoft.Databricks/workspaces",
+	"Microsoft.Netapp/volumes",
+	"Microsoft.Sql/managedInstances",
+	"Microsoft.Web/serverFarms",
+}
+
+// validateDelegations validates that the delegations of a subnet are known Azure services, each listed once. The
+// control plane subnet cannot be delegated, as the control plane machines need it.
+func validateDelegations(subnet *SubnetSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(subnet.Delegations) > 0 && subnet.Role == SubnetControlPlane {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "the control plane subnet cannot be delegated"))
+	}
+	seen := make(map[string]bool, len(subnet.Delegations))
+	for i, service := range subnet.Delegations {
+		supported := false
+		for _, s := range supportedDelegations {
+			if service == s {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Index(i), service, supportedDelegations))
+			continue
+		}
+		if seen[service] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Index(i), service))
+		}
+		seen[service] = true
+	}
+	return allErrs
+}
+
+// cidrContains returns true if the CIDR block inner is within the CIDR block outer.
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// anyCIDRContains returns true if any of the CIDR blocks outer contains the CIDR block inner.
+func anyCIDRContains(outer []cidrBlock, inner *net.IPNet) bool {
+	for _, block := range outer {
+		if cidrContains(block.cidr, inner) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeCIDRBlocks describes the CIDR blocks of a vnet in validation messages.
+func describeCIDRBlocks(blocks []cidrBlock) string {
+	if len(blocks) == 1 {
+		return "the CIDR block " + blocks[0].cidr.String()
+	}
+	values := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		values = append(values, block.cidr.String())
+	}
+	return "one of the CIDR blocks " + strings.Join(values, ", ")
+}
+
+// cidrsOverlap returns true if the CIDR blocks a and b have addresses in common.
+func cidrsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
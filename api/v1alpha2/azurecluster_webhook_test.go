@@ -0,0 +1,654 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAzureCluster_ValidateCreate(t *testing.T) {
+	validSpec := func() AzureClusterSpec {
+		return AzureClusterSpec{
+			Location:      "westus2",
+			ResourceGroup: "my-rg",
+			NetworkSpec: NetworkSpec{
+				Vnet: VnetSpec{Name: "my-vnet", CidrBlock: "10.0.0.0/8"},
+				Subnets: Subnets{
+					{Role: SubnetControlPlane, Name: "cp-subnet", CidrBlock: "10.0.0.0/16"},
+					{Role: SubnetNode, Name: "node-subnet", CidrBlock: "10.1.0.0/16"},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		spec           func() AzureClusterSpec
+		expectedFields []string
+		expectedDetail string
+	}{
+		{
+			name: "valid spec",
+			spec: validSpec,
+		},
+		{
+			name: "valid spec with defaulted network",
+			spec: func() AzureClusterSpec {
+				return AzureClusterSpec{Location: "westus2", ResourceGroup: "my_rg.(test)-1"}
+			},
+		},
+		{
+			name: "valid custom vnet in another resource group",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.ResourceGroup = "network-rg"
+				spec.NetworkSpec.Vnet.ID = "/subscriptions/123/resourceGroups/network-rg/providers/Microsoft.Network/virtualNetworks/my-vnet"
+				return spec
+			},
+		},
+		{
+			name: "missing location",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.Location = ""
+				return spec
+			},
+			expectedFields: []string{"spec.location"},
+		},
+		{
+			name: "missing resource group",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.ResourceGroup = ""
+				return spec
+			},
+			expectedFields: []string{"spec.resourceGroup"},
+		},
+		{
+			name: "resource group ending with a period",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.ResourceGroup = "my-rg."
+				return spec
+			},
+			expectedFields: []string{"spec.resourceGroup"},
+			expectedDetail: "cannot end with a period",
+		},
+		{
+			name: "resource group with an invalid character",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.ResourceGroup = "my/rg"
+				return spec
+			},
+			expectedFields: []string{"spec.resourceGroup"},
+		},
+		{
+			name: "resource group name too long",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.ResourceGroup = strings.Repeat("a", 91)
+				return spec
+			},
+			expectedFields: []string{"spec.resourceGroup"},
+		},
+		{
+			name: "custom vnet in another resource group without an id",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.ResourceGroup = "network-rg"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.vnet.id"},
+		},
+		{
+			name: "invalid vnet cidr block",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CidrBlock = "10.0.0.0"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.vnet.cidrBlock"},
+		},
+		{
+			name: "invalid subnet cidr block",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].CidrBlock = "10.1.0.0/33"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlock"},
+		},
+		{
+			name: "subnet outside of the vnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].CidrBlock = "192.168.0.0/16"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlock"},
+			expectedDetail: "must be within the CIDR block 10.0.0.0/8 of the vnet",
+		},
+		{
+			name: "subnet larger than the vnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CidrBlock = "10.0.0.0/16"
+				spec.NetworkSpec.Subnets[0].CidrBlock = "10.0.0.0/8"
+				spec.NetworkSpec.Subnets[1].CidrBlock = "10.0.1.0/24"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[0].cidrBlock", "spec.networkSpec.subnets[1].cidrBlock"},
+		},
+		{
+			name: "overlapping subnets",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].CidrBlock = "10.0.128.0/24"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlock"},
+			expectedDetail: "overlaps with the CIDR block 10.0.0.0/16 of subnet cp-subnet",
+		},
+		{
+			name: "valid dual-stack subnets",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CIDRBlocks = []string{"10.0.0.0/8", "fd00::/48"}
+				spec.NetworkSpec.Subnets[0].CIDRBlocks = []string{"10.0.0.0/16", "fd00::/64"}
+				spec.NetworkSpec.Subnets[1].CidrBlock = ""
+				spec.NetworkSpec.Subnets[1].CIDRBlocks = []string{"10.1.0.0/16", "fd00:0:0:1::/64"}
+				return spec
+			},
+		},
+		{
+			name: "subnet with two IPv6 CIDR blocks",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CIDRBlocks = []string{"10.0.0.0/8", "fd00::/48"}
+				spec.NetworkSpec.Subnets[1].CIDRBlocks = []string{"10.1.0.0/16", "fd00::/64", "fd00:0:0:1::/64"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlocks[2]"},
+			expectedDetail: "subnet already has the IPv6 CIDR block fd00::/64",
+		},
+		{
+			name: "subnet with only an IPv6 CIDR block",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CIDRBlocks = []string{"10.0.0.0/8", "fd00::/48"}
+				spec.NetworkSpec.Subnets[1].CidrBlock = ""
+				spec.NetworkSpec.Subnets[1].CIDRBlocks = []string{"fd00::/64"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlocks"},
+		},
+		{
+			name: "subnet CIDR block not in its CIDR blocks",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].CIDRBlocks = []string{"10.2.0.0/16"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlock"},
+		},
+		{
+			name: "IPv6 subnet CIDR block outside of the vnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CIDRBlocks = []string{"10.0.0.0/8", "fd00::/48"}
+				spec.NetworkSpec.Subnets[1].CIDRBlocks = []string{"10.1.0.0/16", "fd01::/64"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlocks[1]"},
+			expectedDetail: "must be within one of the CIDR blocks 10.0.0.0/8, fd00::/48 of the vnet",
+		},
+		{
+			name: "overlapping IPv6 subnet CIDR blocks",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.CIDRBlocks = []string{"10.0.0.0/8", "fd00::/48"}
+				spec.NetworkSpec.Subnets[0].CIDRBlocks = []string{"10.0.0.0/16", "fd00::/64"}
+				spec.NetworkSpec.Subnets[1].CIDRBlocks = []string{"10.1.0.0/16", "fd00::/64"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].cidrBlocks[1]"},
+			expectedDetail: "overlaps with the CIDR block fd00::/64 of subnet cp-subnet",
+		},
+		{
+			name: "valid DNS servers",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.DNSServers = []string{"10.0.0.4", "10.0.0.5"}
+				return spec
+			},
+		},
+		{
+			name: "malformed DNS server",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.DNSServers = []string{"10.0.0.4", "10.0.0"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.vnet.dnsServers[1]"},
+		},
+		{
+			name: "valid referenced DDoS protection plan",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.DdosProtectionPlan = &DdosProtectionPlan{ID: "/subscriptions/123/resourceGroups/security-rg/providers/Microsoft.Network/ddosProtectionPlans/my-plan"}
+				return spec
+			},
+		},
+		{
+			name: "referenced DDoS protection plan with a name",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Vnet.DdosProtectionPlan = &DdosProtectionPlan{ID: "/subscriptions/123/resourceGroups/security-rg/providers/Microsoft.Network/ddosProtectionPlans/my-plan", Name: "my-plan"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.vnet.ddosProtectionPlan.name"},
+		},
+		{
+			name: "valid vnet peerings",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.VnetPeerings = []VnetPeeringSpec{
+					{RemoteVnetID: "/subscriptions/123/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet", AllowForwardedTraffic: true},
+					{Name: "to-shared", RemoteVnetID: "/subscriptions/123/resourceGroups/shared-rg/providers/Microsoft.Network/virtualNetworks/shared-vnet"},
+				}
+				return spec
+			},
+		},
+		{
+			name: "invalid vnet peerings",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.VnetPeerings = []VnetPeeringSpec{
+					{Name: "to-hub"},
+					{Name: "to-hub", RemoteVnetID: "/subscriptions/123/resourceGroups/hub-rg/providers/Microsoft.Network/publicIPAddresses/hub-ip"},
+				}
+				return spec
+			},
+			expectedFields: []string{
+				"spec.networkSpec.vnetPeerings[0].remoteVnetID",
+				"spec.networkSpec.vnetPeerings[1].remoteVnetID",
+				"spec.networkSpec.vnetPeerings[1].name",
+			},
+		},
+		{
+			name: "valid service endpoints",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].ServiceEndpoints = []string{"Microsoft.Storage", "Microsoft.Sql"}
+				return spec
+			},
+		},
+		{
+			name: "unknown service endpoint",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].ServiceEndpoints = []string{"Microsoft.Storage", "Microsoft.Unknown"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].serviceEndpoints[1]"},
+			expectedDetail: `"Microsoft.Unknown": supported values`,
+		},
+		{
+			name: "duplicate service endpoint",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[0].ServiceEndpoints = []string{"Microsoft.Sql", "Microsoft.Sql"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[0].serviceEndpoints[1]"},
+		},
+		{
+			name: "delegated node subnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets = append(spec.NetworkSpec.Subnets, &SubnetSpec{
+					Role:        SubnetNode,
+					Name:        "aci-subnet",
+					CidrBlock:   "10.2.0.0/16",
+					Delegations: []string{"Microsoft.ContainerInstance/containerGroups"},
+				})
+				return spec
+			},
+		},
+		{
+			name: "unknown and duplicate delegations",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].Delegations = []string{
+					"Microsoft.ContainerInstance/containerGroups",
+					"Microsoft.Unknown/things",
+					"Microsoft.ContainerInstance/containerGroups",
+				}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].delegations[1]", "spec.networkSpec.subnets[1].delegations[2]"},
+		},
+		{
+			name: "delegated control plane subnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[0].Delegations = []string{"Microsoft.ContainerInstance/containerGroups"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[0].delegations"},
+			expectedDetail: "control plane subnet cannot be delegated",
+		},
+		{
+			name: "security rule with application security groups",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].SecurityGroup.SecurityRules = SecurityRules{{
+					Name:                                 "allow_web",
+					Priority:                             200,
+					SourceApplicationSecurityGroups:      []string{"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/clients"},
+					DestinationApplicationSecurityGroups: []string{"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/web"},
+				}}
+				return spec
+			},
+		},
+		{
+			name: "security rule with a source and source application security groups",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].SecurityGroup.SecurityRules = SecurityRules{{
+					Name:                            "allow_web",
+					Priority:                        200,
+					Source:                          to.StringPtr("10.0.0.0/8"),
+					SourceApplicationSecurityGroups: []string{"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/clients"},
+				}}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].securityGroup.ingressRule[0].source"},
+		},
+		{
+			name: "security rule with an invalid destination application security group",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.Subnets[1].SecurityGroup.SecurityRules = SecurityRules{{
+					Name:                                 "allow_web",
+					Priority:                             200,
+					DestinationApplicationSecurityGroups: []string{"web"},
+				}}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.subnets[1].securityGroup.ingressRule[0].destinationApplicationSecurityGroups[0]"},
+		},
+		{
+			name: "valid private endpoint",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerPrivateEndpoint = &PrivateEndpointSpec{
+					SubnetID: "/subscriptions/123/resourceGroups/clients-rg/providers/Microsoft.Network/virtualNetworks/clients-vnet/subnets/clients",
+				}
+				return spec
+			},
+		},
+		{
+			name: "private endpoint without a subnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerPrivateEndpoint = &PrivateEndpointSpec{}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerPrivateEndpoint.subnetID"},
+		},
+		{
+			name: "private endpoint with a basic load balancer",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.SKU = SKUBasic
+				spec.NetworkSpec.APIServerPrivateEndpoint = &PrivateEndpointSpec{SubnetID: "subnet-id"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerPrivateEndpoint"},
+		},
+		{
+			name: "zone-redundant frontend",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.FrontendZones = []string{"1", "2", "3"}
+				return spec
+			},
+		},
+		{
+			name: "zonal frontend of a basic load balancer",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.SKU = SKUBasic
+				spec.NetworkSpec.APIServerLB.FrontendZones = []string{"1"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerLB.frontendZones"},
+		},
+		{
+			name: "duplicate and empty frontend zones",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.FrontendZones = []string{"1", "", "1"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerLB.frontendZones[1]", "spec.networkSpec.apiServerLB.frontendZones[2]"},
+		},
+		{
+			name: "frontend private IP in the control plane subnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.FrontendPrivateIP = "10.0.0.50"
+				return spec
+			},
+		},
+		{
+			name: "frontend private IP outside of the control plane subnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.FrontendPrivateIP = "10.1.0.50"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerLB.frontendPrivateIP"},
+			expectedDetail: "within the CIDR block 10.0.0.0/16 of the control plane subnet cp-subnet",
+		},
+		{
+			name: "invalid frontend private IP",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.FrontendPrivateIP = "fd00::50"
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerLB.frontendPrivateIP"},
+		},
+		{
+			name: "VPN gateway subnet within the vnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.VPNGateway = &VPNGatewaySpec{GatewaySubnetCIDR: "10.255.255.0/27"}
+				return spec
+			},
+		},
+		{
+			name: "VPN gateway subnet overlapping a subnet",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.VPNGateway = &VPNGatewaySpec{GatewaySubnetCIDR: "10.1.255.0/27"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.vpnGateway.gatewaySubnetCidrBlock"},
+			expectedDetail: "overlaps with the CIDR block 10.1.0.0/16 of subnet node-subnet",
+		},
+		{
+			name: "VPN gateway subnet too small",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.VPNGateway = &VPNGatewaySpec{GatewaySubnetCIDR: "10.255.255.0/30"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.vpnGateway.gatewaySubnetCidrBlock"},
+			expectedDetail: "/29 or larger",
+		},
+		{
+			name: "private DNS zone",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.PrivateDNSZone = &PrivateDNSZoneSpec{Name: "nodes.example.internal"}
+				return spec
+			},
+		},
+		{
+			name: "private DNS zone with a single label",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.PrivateDNSZone = &PrivateDNSZoneSpec{Name: "internal"}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.privateDnsZone.name"},
+			expectedDetail: "at least two labels",
+		},
+		{
+			name: "additional load balancing rules",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.AdditionalRules = []LoadBalancingRule{
+					{Name: "dns-tcp", FrontendPort: 53},
+					{Name: "dns-udp", Protocol: LoadBalancingRuleProtocolUDP, FrontendPort: 53},
+				}
+				return spec
+			},
+		},
+		{
+			name: "additional load balancing rules with a duplicate name and frontend port",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.AdditionalRules = []LoadBalancingRule{
+					{Name: "metrics", FrontendPort: 9100},
+					{Name: "metrics", Protocol: LoadBalancingRuleProtocolTCP, FrontendPort: 9100},
+				}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerLB.additionalRules[1].name", "spec.networkSpec.apiServerLB.additionalRules[1].frontendPort"},
+		},
+		{
+			name: "externally managed public ip",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.PublicIP = &PublicIPSpec{Name: "my-api-ip", ExternallyManaged: true}
+				return spec
+			},
+		},
+		{
+			name: "externally managed public ip without a name",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.NetworkSpec.APIServerLB.PublicIP = &PublicIPSpec{ExternallyManaged: true}
+				return spec
+			},
+			expectedFields: []string{"spec.networkSpec.apiServerLB.publicIP.name"},
+		},
+		{
+			name: "valid identity",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.Identity = &ClusterIdentity{SecretRef: &corev1.LocalObjectReference{Name: "my-identity"}}
+				return spec
+			},
+		},
+		{
+			name: "valid managed identity",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.Identity = &ClusterIdentity{Type: ManagedIdentity, ClientID: "my-client"}
+				return spec
+			},
+		},
+		{
+			name: "managed identity with a secret",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.Identity = &ClusterIdentity{Type: ManagedIdentity, SecretRef: &corev1.LocalObjectReference{Name: "my-identity"}}
+				return spec
+			},
+			expectedFields: []string{"spec.identity.secretRef"},
+		},
+		{
+			name: "identity without a secret name",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.Identity = &ClusterIdentity{}
+				return spec
+			},
+			expectedFields: []string{"spec.identity.secretRef.name"},
+		},
+		{
+			name: "several errors",
+			spec: func() AzureClusterSpec {
+				spec := validSpec()
+				spec.Location = ""
+				spec.NetworkSpec.Subnets[1].CidrBlock = "10.0.0.0/24"
+				return spec
+			},
+			expectedFields: []string{"spec.location", "spec.networkSpec.subnets[1].cidrBlock"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cluster := &AzureCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"},
+				Spec:       tc.spec(),
+			}
+			err := cluster.ValidateCreate()
+			if len(tc.expectedFields) == 0 {
+				if err != nil {
+					t.Fatalf("got an unexpected error: %v", err)
+				}
+				return
+			}
+
+			if fields := causeFields(t, err); !reflect.DeepEqual(fields, tc.expectedFields) {
+				t.Errorf("expected errors for the fields %v, got %v", tc.expectedFields, err)
+			}
+			if !strings.Contains(err.Error(), tc.expectedDetail) {
+				t.Errorf("expected error to contain %q, got %v", tc.expectedDetail, err)
+			}
+		})
+	}
+}
+
+func TestAzureCluster_ValidateUpdate(t *testing.T) {
+	old := &AzureCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"},
+		Spec:       AzureClusterSpec{Location: "westus2", ResourceGroup: "my-rg"},
+	}
+	cluster := old.DeepCopy()
+	if err := cluster.ValidateUpdate(old); err != nil {
+		t.Fatalf("got an unexpected error: %v", err)
+	}
+	cluster.Spec.NetworkSpec.Vnet.ResourceGroup = "network-rg"
+	if err := cluster.ValidateUpdate(old); !apierrors.IsInvalid(err) {
+		t.Fatalf("expected an invalid error, got %v", err)
+	}
+}
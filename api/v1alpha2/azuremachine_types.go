@@ -39,11 +39,46 @@ type AzureMachineSpec struct {
 
 	Image *Image `json:"image,omitempty"`
 
+	// OSType is the operating system of the machine, Linux or Windows. Windows machines are provisioned by
+	// cloudbase-init from the bootstrap data, their computer name is shortened to 15 characters and they default to the
+	// Windows Server image of the Kubernetes version. Defaults to the OS type of the OS disk, or to Linux.
+	// +kubebuilder:validation:Enum=Linux;Windows
+	// +optional
+	OSType OSType `json:"osType,omitempty"`
+
 	OSDisk OSDisk `json:"osDisk"`
 
+	// DataDisks are the data disks attached to the machine, in addition to its OS disk.
+	// +optional
+	DataDisks []DataDisk `json:"dataDisks,omitempty"`
+
 	Location string `json:"location"`
 
-	SSHPublicKey string `json:"sshPublicKey"`
+	// AdminUsername is the name of the admin user of the machine. Azure rejects common names, such as admin or root,
+	// and the names reserved by the OS. It can only contain letters, digits, hyphens and underscores, and cannot start
+	// with a digit or a hyphen. Defaults to capi.
+	// +kubebuilder:validation:MaxLength=64
+	// +optional
+	AdminUsername string `json:"adminUsername,omitempty"`
+
+	// ComputerName is the host name of the machine. It can only contain letters, digits and hyphens, and cannot start
+	// or end with a hyphen. Defaults to the name of the machine, with the characters a host name cannot contain
+	// replaced, shortened to 64 characters on Linux or 15 characters on Windows.
+	// +kubebuilder:validation:MaxLength=64
+	// +optional
+	ComputerName string `json:"computerName,omitempty"`
+
+	// SSHPublicKey is the base64 encoded SSH public key, in the authorized_keys format, which can log in to the
+	// machine as the admin user. Machines of different node pools can authorize different keys. A key pair is
+	// generated, and its private key discarded, when it is empty. Windows machines cannot authorize an SSH public key.
+	// +optional
+	SSHPublicKey string `json:"sshPublicKey,omitempty"`
+
+	// BootstrapDataSource is a remote source of the bootstrap data of the machine. The custom data of the machine is
+	// then a minimal cloud-init configuration including the remote bootstrap data, which cloud-init downloads at boot,
+	// instead of the bootstrap data of the Machine. Windows machines cannot use a remote source.
+	// +optional
+	BootstrapDataSource *BootstrapDataSource `json:"bootstrapDataSource,omitempty"`
 
 	// AdditionalTags is an optional set of tags to add to an instance, in addition to the ones added by default by the
 	// Azure provider. If both the AzureCluster and the AzureMachine specify the same tag name with different values, the
@@ -54,6 +89,67 @@ type AzureMachineSpec struct {
 	// AllocatePublicIP allows the ability to create dynamic public ips for machines where this value is true.
 	// +optional
 	AllocatePublicIP bool `json:"allocatePublicIP,omitempty"`
+
+	// NetworkInterfaces are the network interfaces of the machine, exactly one of them is primary. Secondary network
+	// interfaces can be in a subnet of any role. Defaults to a single primary network interface in the subnet of the
+	// machine.
+	// +optional
+	NetworkInterfaces []NetworkInterface `json:"networkInterfaces,omitempty"`
+
+	// SubnetName is the name of the cluster subnet the machine is placed in. The subnet must have the same role as the
+	// machine. Defaults to the control plane subnet for control plane machines and to the first node subnet otherwise.
+	// +optional
+	SubnetName string `json:"subnetName,omitempty"`
+
+	// SpotVMOptions allows the machine to run as a Spot (low priority) virtual machine, which Azure can evict at any
+	// time. Control plane machines cannot be Spot virtual machines.
+	// +optional
+	SpotVMOptions *SpotVMOptions `json:"spotVMOptions,omitempty"`
+
+	// Identity is the system-assigned identity of the virtual machine, None or SystemAssigned. Defaults to None.
+	// +kubebuilder:validation:Enum=None;SystemAssigned
+	// +optional
+	Identity VMIdentity `json:"identity,omitempty"`
+
+	// UserAssignedIdentities are the resource IDs of the user-assigned managed identities of the virtual machine, in
+	// addition to its system-assigned identity if any.
+	// +optional
+	UserAssignedIdentities []string `json:"userAssignedIdentities,omitempty"`
+
+	// AcceleratedNetworking enables accelerated networking on the network interface of the machine. The VM size of the
+	// machine must support accelerated networking.
+	// +optional
+	AcceleratedNetworking *bool `json:"acceleratedNetworking,omitempty"`
+
+	// EncryptionAtHost encrypts the temporary disk and the caches of the OS and data disks of the machine on the VM
+	// host. The VM size of the machine must support it and the Microsoft.Compute/EncryptionAtHost feature must be
+	// registered in the subscription.
+	// +optional
+	EncryptionAtHost *bool `json:"encryptionAtHost,omitempty"`
+
+	// Diagnostics configures the diagnostics of the machine. Defaults to boot diagnostics stored in a storage account
+	// managed by Azure.
+	// +optional
+	Diagnostics *Diagnostics `json:"diagnostics,omitempty"`
+
+	// VMExtensions are the extensions installed on the virtual machine once it is running, for example a custom script
+	// extension that runs a post-provisioning step of the bootstrap. Removing an extension from the list does not
+	// uninstall it.
+	// +optional
+	VMExtensions []VMExtension `json:"vmExtensions,omitempty"`
+
+	// Deallocated stops the virtual machine and releases its compute resources while it is true, for example to save
+	// the cost of a development cluster overnight. The disks and the network interfaces of the virtual machine are
+	// kept, and it is started again once Deallocated is false.
+	// +optional
+	Deallocated bool `json:"deallocated,omitempty"`
+
+	// NodeDrainTimeout is how long the deletion of the virtual machine waits for the node of the machine to be
+	// drained by Cluster API, so that its pods are evicted gracefully. The virtual machine is deleted as soon as the
+	// node is drained or removed, or once the timeout has elapsed since the AzureMachine was deleted. Defaults to 10
+	// minutes, 0s deletes the virtual machine without waiting.
+	// +optional
+	NodeDrainTimeout *metav1.Duration `json:"nodeDrainTimeout,omitempty"`
 }
 
 // AzureMachineStatus defines the observed state of AzureMachine
@@ -69,6 +165,25 @@ type AzureMachineStatus struct {
 	// +optional
 	VMState *VMState `json:"vmState,omitempty"`
 
+	// PowerState is the power state of the Azure virtual machine, such as running, stopped or deallocated.
+	// +optional
+	PowerState *VMPowerState `json:"powerState,omitempty"`
+
+	// Conditions report the provisioning of the virtual machine and whether it is running, with the reason and the
+	// error when it is not.
+	// +optional
+	Conditions Conditions `json:"conditions,omitempty"`
+
+	// LongRunningOperationState is the creation of the virtual machine while it is in progress, polled by the next
+	// reconciles until it completes.
+	// +optional
+	LongRunningOperationState *Future `json:"longRunningOperationState,omitempty"`
+
+	// SSHPort is the frontend port of the public load balancer of the cluster that the inbound NAT rule of a control
+	// plane machine forwards to its SSH port.
+	// +optional
+	SSHPort *int32 `json:"sshPort,omitempty"`
+
 	// ErrorReason will be set in the event that there is a terminal problem
 	// reconciling the Machine and will contain a succinct value suitable
 	// for machine interpretation.
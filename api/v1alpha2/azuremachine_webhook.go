@@ -0,0 +1,517 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"encoding/base64"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// DefaultOSDiskSizeGB is the default size of the OS disk of machines using the default image, which is the size
+	// of the OS disk of the default image.
+	DefaultOSDiskSizeGB = 30
+	// DefaultOSDiskStorageAccountType is the default storage account type of the OS disk, which all the VM sizes
+	// support.
+	DefaultOSDiskStorageAccountType = "StandardSSD_LRS"
+	// EphemeralOSDiskStorageAccountType is the storage account type of ephemeral OS disks.
+	EphemeralOSDiskStorageAccountType = "Standard_LRS"
+	// DefaultOSDiskCaching is the default host caching of the OS disk.
+	DefaultOSDiskCaching = "ReadWrite"
+	// EphemeralOSDiskCaching is the host caching of ephemeral OS disks, the only one they support.
+	EphemeralOSDiskCaching = "ReadOnly"
+	// ultraSSDStorageAccountType is the storage account type of Ultra SSD disks, which only support the None host
+	// caching.
+	ultraSSDStorageAccountType = "UltraSSD_LRS"
+	// premiumStorageAccountType is the storage account type of the only disks supporting write accelerator.
+	premiumStorageAccountType = "Premium_LRS"
+	// DefaultAdminUsername is the default name of the admin user of the machines.
+	DefaultAdminUsername = "capi"
+	// maxLinuxAdminUsernameLength and maxWindowsAdminUsernameLength are the maximum lengths of the admin username
+	// Azure accepts for Linux and Windows virtual machines.
+	maxLinuxAdminUsernameLength   = 64
+	maxWindowsAdminUsernameLength = 20
+	// maxLinuxComputerNameLength and maxWindowsComputerNameLength are the maximum lengths of the computer name Azure
+	// accepts for Linux and Windows virtual machines.
+	maxLinuxComputerNameLength   = 64
+	maxWindowsComputerNameLength = 15
+)
+
+// vmSizeRegex matches the names of the Azure VM sizes, for example Standard_D2s_v3 or Basic_A1.
+var vmSizeRegex = regexp.MustCompile(`^(Standard|Basic)_[A-Z][A-Za-z0-9_-]*$`)
+
+// writeAcceleratorVMSizeRegex matches the VM sizes of the M series, the only ones supporting write accelerator.
+var writeAcceleratorVMSizeRegex = regexp.MustCompile(`^Standard_M[0-9]`)
+
+// managedDiskIDRegex matches the resource IDs of managed disks.
+var managedDiskIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/disks/[^/]+$`)
+
+// adminUsernameRegex matches the admin usernames Azure accepts for both Linux and Windows virtual machines.
+var adminUsernameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// computerNameRegex matches the computer names Azure accepts for both Linux and Windows virtual machines.
+var computerNameRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+// disallowedAdminUsernames are the admin usernames Azure rejects, compared case-insensitively. They include common
+// names and the names reserved by Linux and Windows.
+var disallowedAdminUsernames = map[string]bool{
+	"1": true, "123": true, "a": true, "actuser": true, "adm": true, "admin": true, "admin1": true, "admin2": true,
+	"administrator": true, "aspnet": true, "backup": true, "console": true, "david": true, "guest": true, "john": true,
+	"owner": true, "root": true, "server": true, "sql": true, "support": true, "support_388945a0": true, "sys": true,
+	"test": true, "test1": true, "test2": true, "test3": true, "user": true, "user1": true, "user2": true, "user3": true,
+	"user4": true, "user5": true,
+}
+
+// diskEncryptionSetIDRegex matches the resource IDs of disk encryption sets.
+var diskEncryptionSetIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/diskEncryptionSets/[^/]+$`)
+
+// applicationSecurityGroupIDRegex matches the resource IDs of application security groups.
+var applicationSecurityGroupIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/applicationSecurityGroups/[^/]+$`)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of AzureMachine with the manager.
+func (m *AzureMachine) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(m).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-infrastructure-cluster-x-k8s-io-v1alpha2-azuremachine,mutating=true,failurePolicy=fail,groups=infrastructure.cluster.x-k8s.io,resources=azuremachines,versions=v1alpha2,name=default.azuremachine.infrastructure.cluster.x-k8s.io
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infrastructure-cluster-x-k8s-io-v1alpha2-azuremachine,mutating=false,failurePolicy=fail,groups=infrastructure.cluster.x-k8s.io,resources=azuremachines,versions=v1alpha2,name=validation.azuremachine.infrastructure.cluster.x-k8s.io
+
+var _ webhook.Defaulter = &AzureMachine{}
+var _ webhook.Validator = &AzureMachine{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type. Only unset fields are defaulted,
+// so defaulting a machine again doesn't change it.
+func (m *AzureMachine) Default() {
+	if m.Spec.OSType == "" {
+		m.Spec.OSType = LinuxOSType
+		if m.Spec.OSDisk.OSType != "" {
+			m.Spec.OSType = OSType(m.Spec.OSDisk.OSType)
+		}
+	}
+	if m.Spec.OSDisk.OSType == "" {
+		m.Spec.OSDisk.OSType = string(m.Spec.OSType)
+	}
+	if m.Spec.AdminUsername == "" {
+		m.Spec.AdminUsername = DefaultAdminUsername
+	}
+	if m.Spec.OSDisk.DiskSizeGB == 0 && m.Spec.Image == nil {
+		m.Spec.OSDisk.DiskSizeGB = DefaultOSDiskSizeGB
+	}
+	if m.Spec.OSDisk.ManagedDisk.StorageAccountType == "" {
+		m.Spec.OSDisk.ManagedDisk.StorageAccountType = DefaultOSDiskStorageAccountType
+		if m.Spec.OSDisk.DiffDiskSettings != nil {
+			m.Spec.OSDisk.ManagedDisk.StorageAccountType = EphemeralOSDiskStorageAccountType
+		}
+	}
+	if m.Spec.OSDisk.Caching == "" {
+		m.Spec.OSDisk.Caching = DefaultOSDiskCaching
+		if m.Spec.OSDisk.DiffDiskSettings != nil {
+			m.Spec.OSDisk.Caching = EphemeralOSDiskCaching
+		}
+	}
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+func (m *AzureMachine) ValidateCreate() error {
+	return m.toInvalidError(m.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+func (m *AzureMachine) ValidateUpdate(old runtime.Object) error {
+	allErrs := m.validateSpec()
+
+	oldMachine, ok := old.(*AzureMachine)
+	if !ok {
+		return apierrors.NewBadRequest("expected an AzureMachine")
+	}
+	// the old machine may have been created before its defaults were set.
+	oldMachine = oldMachine.DeepCopy()
+	oldMachine.Default()
+
+	// the virtual machine can't be updated in place.
+	specPath := field.NewPath("spec")
+	immutable := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{name: "vmSize", old: oldMachine.Spec.VMSize, new: m.Spec.VMSize},
+		{name: "image", old: oldMachine.Spec.Image, new: m.Spec.Image},
+		{name: "osType", old: oldMachine.Spec.OSType, new: m.Spec.OSType},
+		{name: "osDisk", old: oldMachine.Spec.OSDisk, new: m.Spec.OSDisk},
+		{name: "adminUsername", old: oldMachine.Spec.AdminUsername, new: m.Spec.AdminUsername},
+		{name: "computerName", old: oldMachine.Spec.ComputerName, new: m.Spec.ComputerName},
+		{name: "networkInterfaces", old: oldMachine.Spec.NetworkInterfaces, new: m.Spec.NetworkInterfaces},
+		{name: "location", old: oldMachine.Spec.Location, new: m.Spec.Location},
+		{name: "availabilityZone", old: oldMachine.Spec.AvailabilityZone, new: m.Spec.AvailabilityZone},
+	}
+	for _, f := range immutable {
+		if !reflect.DeepEqual(f.old, f.new) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child(f.name), "field is immutable"))
+		}
+	}
+	return m.toInvalidError(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+func (m *AzureMachine) ValidateDelete() error {
+	return nil
+}
+
+// validateSpec returns the errors of the fields of the spec which are invalid.
+func (m *AzureMachine) validateSpec() field.ErrorList {
+	specPath := field.NewPath("spec")
+	var allErrs field.ErrorList
+	if !vmSizeRegex.MatchString(m.Spec.VMSize) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("vmSize"), m.Spec.VMSize,
+			"VM size must be the name of an Azure VM size, for example Standard_D2s_v3"))
+	}
+	if m.Spec.Image != nil {
+		allErrs = append(allErrs, validateImage(m.Spec.Image, specPath.Child("image"))...)
+	}
+	if m.Spec.OSType != "" && m.Spec.OSDisk.OSType != "" && string(m.Spec.OSType) != m.Spec.OSDisk.OSType {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("osDisk", "osType"), m.Spec.OSDisk.OSType,
+			"OS type of the OS disk must be the OS type of the machine"))
+	}
+	if m.Spec.AdminUsername != "" {
+		allErrs = append(allErrs, validateAdminUsername(m.Spec.AdminUsername, m.Spec.OSType, specPath.Child("adminUsername"))...)
+	}
+	if m.Spec.ComputerName != "" {
+		allErrs = append(allErrs, validateComputerName(m.Spec.ComputerName, m.Spec.OSType, specPath.Child("computerName"))...)
+	}
+	allErrs = append(allErrs, m.validateNetworkInterfaces(specPath.Child("networkInterfaces"))...)
+	if m.Spec.OSType == WindowsOSType && m.Spec.SSHPublicKey != "" {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("sshPublicKey"),
+			"Windows machines cannot authorize an SSH public key"))
+	} else if m.Spec.SSHPublicKey != "" {
+		allErrs = append(allErrs, validateSSHPublicKey(m.Spec.SSHPublicKey, specPath.Child("sshPublicKey"))...)
+	}
+	if m.Spec.OSType == WindowsOSType && m.Spec.BootstrapDataSource != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("bootstrapDataSource"),
+			"Windows machines cannot use a remote source of bootstrap data"))
+	} else if m.Spec.BootstrapDataSource != nil {
+		allErrs = append(allErrs, validateBootstrapDataSource(m.Spec.BootstrapDataSource, specPath.Child("bootstrapDataSource"))...)
+	}
+	if _, isControlPlane := m.Labels[clusterv1.MachineControlPlaneLabelName]; isControlPlane && m.Spec.SpotVMOptions != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("spotVMOptions"), "control plane machines cannot be spot virtual machines"))
+	}
+	allErrs = append(allErrs, validateVMExtensions(m.Spec.VMExtensions, specPath.Child("vmExtensions"))...)
+	allErrs = append(allErrs, validateDiskEncryptionSet(&m.Spec.OSDisk.ManagedDisk, specPath.Child("osDisk", "managedDisk"))...)
+	allErrs = append(allErrs, validateOSDiskCaching(m.Spec.OSDisk, specPath.Child("osDisk", "caching"))...)
+	allErrs = append(allErrs, validateDataDisks(m.Spec.DataDisks, m.Spec.VMSize, specPath.Child("dataDisks"))...)
+	if m.Spec.NodeDrainTimeout != nil && m.Spec.NodeDrainTimeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("nodeDrainTimeout"), m.Spec.NodeDrainTimeout.Duration.String(),
+			"node drain timeout cannot be negative"))
+	}
+	return allErrs
+}
+
+// validateBootstrapDataSource checks that the URL of the remote bootstrap data is an HTTP or HTTPS URL without a
+// query, since the shared access signature token is appended to it as its query.
+func validateBootstrapDataSource(source *BootstrapDataSource, fldPath *field.Path) field.ErrorList {
+	u, err := url.Parse(source.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return field.ErrorList{field.Invalid(fldPath.Child("url"), source.URL, "must be an HTTP or HTTPS URL")}
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return field.ErrorList{field.Invalid(fldPath.Child("url"), source.URL,
+			"must not have a query or a fragment, the shared access signature token is referenced by sasTokenSecretRef")}
+	}
+	return nil
+}
+
+// validateAdminUsername checks that Azure accepts the admin username for a virtual machine of the OS type.
+func validateAdminUsername(username string, osType OSType, fldPath *field.Path) field.ErrorList {
+	if disallowedAdminUsernames[strings.ToLower(username)] {
+		return field.ErrorList{field.Invalid(fldPath, username, "admin username is disallowed by Azure")}
+	}
+	if !adminUsernameRegex.MatchString(username) {
+		return field.ErrorList{field.Invalid(fldPath, username,
+			"admin username can only contain letters, digits, hyphens and underscores, and cannot start with a digit or a hyphen")}
+	}
+	maxLength := maxLinuxAdminUsernameLength
+	if osType == WindowsOSType {
+		maxLength = maxWindowsAdminUsernameLength
+	}
+	if len(username) > maxLength {
+		return field.ErrorList{field.TooLong(fldPath, username, maxLength)}
+	}
+	return nil
+}
+
+// validateComputerName checks that Azure accepts the computer name for a virtual machine of the OS type.
+func validateComputerName(computerName string, osType OSType, fldPath *field.Path) field.ErrorList {
+	if !computerNameRegex.MatchString(computerName) {
+		return field.ErrorList{field.Invalid(fldPath, computerName,
+			"computer name can only contain letters, digits and hyphens, and cannot start or end with a hyphen")}
+	}
+	maxLength := maxLinuxComputerNameLength
+	if osType == WindowsOSType {
+		maxLength = maxWindowsComputerNameLength
+	}
+	if len(computerName) > maxLength {
+		return field.ErrorList{field.TooLong(fldPath, computerName, maxLength)}
+	}
+	return nil
+}
+
+// validateDataDisks checks that empty data disks have a size, that existing data disks are referenced by a
+// well-formed resource ID and leave the size to the existing disk, that the caching of the data disks is valid, and
+// that write accelerator is only enabled on premium disks of a VM size of the M series.
+func validateDataDisks(dataDisks []DataDisk, vmSize string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, disk := range dataDisks {
+		diskPath := fldPath.Index(i)
+		if disk.ManagedDiskID == "" {
+			if disk.DiskSizeGB <= 0 {
+				allErrs = append(allErrs, field.Invalid(diskPath.Child("diskSizeGB"), disk.DiskSizeGB,
+					"size of an empty data disk must be positive"))
+			}
+		} else {
+			if !managedDiskIDRegex.MatchString(disk.ManagedDiskID) {
+				allErrs = append(allErrs, field.Invalid(diskPath.Child("managedDiskID"), disk.ManagedDiskID,
+					"managed disk ID must be the resource ID of a managed disk"))
+			}
+			if disk.DiskSizeGB != 0 {
+				allErrs = append(allErrs, field.Forbidden(diskPath.Child("diskSizeGB"),
+					"size of an existing data disk is inherited from the disk"))
+			}
+		}
+		allErrs = append(allErrs, validateDiskEncryptionSet(disk.ManagedDisk, diskPath.Child("managedDisk"))...)
+		allErrs = append(allErrs, validateCaching(disk.Caching, diskPath.Child("caching"))...)
+		if disk.ManagedDisk != nil && disk.ManagedDisk.StorageAccountType == ultraSSDStorageAccountType &&
+			disk.Caching != "" && disk.Caching != "None" {
+			allErrs = append(allErrs, field.Invalid(diskPath.Child("caching"), disk.Caching,
+				"Ultra SSD data disks only support None caching"))
+		}
+		if disk.WriteAcceleratorEnabled != nil && *disk.WriteAcceleratorEnabled {
+			allErrs = append(allErrs, validateWriteAccelerator(disk, vmSize, diskPath)...)
+		}
+	}
+	return allErrs
+}
+
+// validateWriteAccelerator checks that a data disk with write accelerator is a premium disk without read-write
+// caching, attached to a VM size of the M series.
+func validateWriteAccelerator(disk DataDisk, vmSize string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if disk.ManagedDisk == nil || disk.ManagedDisk.StorageAccountType != premiumStorageAccountType {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("writeAcceleratorEnabled"),
+			"write accelerator is only supported on Premium_LRS disks"))
+	}
+	if disk.Caching == "ReadWrite" {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("caching"), disk.Caching,
+			"disks with write accelerator only support None or ReadOnly caching"))
+	}
+	if !writeAcceleratorVMSizeRegex.MatchString(vmSize) {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("writeAcceleratorEnabled"),
+			"write accelerator is only supported on VM sizes of the M series"))
+	}
+	return allErrs
+}
+
+// validateOSDiskCaching checks that the caching of the OS disk is valid, and ReadOnly for an ephemeral OS disk.
+func validateOSDiskCaching(osDisk OSDisk, fldPath *field.Path) field.ErrorList {
+	if allErrs := validateCaching(osDisk.Caching, fldPath); len(allErrs) > 0 {
+		return allErrs
+	}
+	if osDisk.DiffDiskSettings != nil && osDisk.Caching != "" && osDisk.Caching != EphemeralOSDiskCaching {
+		return field.ErrorList{field.Invalid(fldPath, osDisk.Caching, "ephemeral OS disks only support ReadOnly caching")}
+	}
+	return nil
+}
+
+// validateCaching checks that the host caching of a disk, if any, is one Azure supports.
+func validateCaching(caching string, fldPath *field.Path) field.ErrorList {
+	switch caching {
+	case "", "None", "ReadOnly", "ReadWrite":
+		return nil
+	}
+	return field.ErrorList{field.NotSupported(fldPath, caching, []string{"None", "ReadOnly", "ReadWrite"})}
+}
+
+// validateDiskEncryptionSet checks that the disk encryption set of a managed disk, if any, is referenced by a
+// well-formed resource ID.
+func validateDiskEncryptionSet(managedDisk *ManagedDisk, fldPath *field.Path) field.ErrorList {
+	if managedDisk == nil || managedDisk.DiskEncryptionSet == nil {
+		return nil
+	}
+	id := managedDisk.DiskEncryptionSet.ID
+	if !diskEncryptionSetIDRegex.MatchString(id) {
+		return field.ErrorList{field.Invalid(fldPath.Child("diskEncryptionSet", "id"), id,
+			"disk encryption set ID must be the resource ID of a disk encryption set")}
+	}
+	return nil
+}
+
+// validateVMExtensions checks that the VM extensions have unique names and a publisher, a type and a version.
+func validateVMExtensions(extensions []VMExtension, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	for i, extension := range extensions {
+		extensionPath := fldPath.Index(i)
+		if extension.Name == "" {
+			allErrs = append(allErrs, field.Required(extensionPath.Child("name"), "VM extensions require a name"))
+		} else if names[extension.Name] {
+			allErrs = append(allErrs, field.Duplicate(extensionPath.Child("name"), extension.Name))
+		}
+		names[extension.Name] = true
+		if extension.Publisher == "" {
+			allErrs = append(allErrs, field.Required(extensionPath.Child("publisher"), "VM extensions require a publisher"))
+		}
+		if extension.Type == "" {
+			allErrs = append(allErrs, field.Required(extensionPath.Child("type"), "VM extensions require a type"))
+		}
+		if extension.Version == "" {
+			allErrs = append(allErrs, field.Required(extensionPath.Child("version"), "VM extensions require a version"))
+		}
+	}
+	return allErrs
+}
+
+// validateNetworkInterfaces checks that exactly one network interface of the machine is primary, that its subnet is
+// the subnet of the machine, that the secondary network interfaces have a subnet and that the private IPs, the
+// application security group IDs and the DNS servers are valid.
+func (m *AzureMachine) validateNetworkInterfaces(fldPath *field.Path) field.ErrorList {
+	if len(m.Spec.NetworkInterfaces) == 0 {
+		return nil
+	}
+	var allErrs field.ErrorList
+	primaries := 0
+	for i, nic := range m.Spec.NetworkInterfaces {
+		nicPath := fldPath.Index(i)
+		allErrs = append(allErrs, validatePrivateIP(nic, nicPath)...)
+		allErrs = append(allErrs, validateApplicationSecurityGroupIDs(nic.ApplicationSecurityGroups, nicPath.Child("applicationSecurityGroups"))...)
+		allErrs = append(allErrs, validateDNSServers(nic.DNSServers, nicPath.Child("dnsServers"))...)
+		if !nic.Primary {
+			if nic.SubnetName == "" {
+				allErrs = append(allErrs, field.Required(nicPath.Child("subnetName"), "secondary network interfaces require a subnet"))
+			}
+			continue
+		}
+		primaries++
+		if nic.SubnetName != "" && m.Spec.SubnetName != "" && nic.SubnetName != m.Spec.SubnetName {
+			allErrs = append(allErrs, field.Invalid(nicPath.Child("subnetName"), nic.SubnetName,
+				"subnet of the primary network interface must be the subnet of the machine"))
+		}
+	}
+	if primaries != 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath, primaries, "exactly one network interface must be primary"))
+	}
+	return allErrs
+}
+
+// validateApplicationSecurityGroupIDs checks that the application security group IDs are unique resource IDs of
+// application security groups.
+func validateApplicationSecurityGroupIDs(ids []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		switch {
+		case !applicationSecurityGroupIDRegex.MatchString(id):
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), id,
+				"application security group ID must be the resource ID of an application security group"))
+		case seen[strings.ToLower(id)]:
+			allErrs = append(allErrs, field.Duplicate(fldPath.Index(i), id))
+		}
+		seen[strings.ToLower(id)] = true
+	}
+	return allErrs
+}
+
+// validateDNSServers checks that the DNS servers are IP addresses.
+func validateDNSServers(dnsServers []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, dnsServer := range dnsServers {
+		if net.ParseIP(dnsServer) == nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), dnsServer, "DNS server must be an IP address"))
+		}
+	}
+	return allErrs
+}
+
+// validatePrivateIP checks that a network interface has a valid private IP address if and only if its private IP
+// allocation is static. The reconciler checks that the address is in the subnet of the network interface.
+func validatePrivateIP(nic NetworkInterface, fldPath *field.Path) field.ErrorList {
+	switch nic.PrivateIPAllocationMethod {
+	case StaticIPAllocationMethod:
+		if nic.PrivateIPAddress == "" {
+			return field.ErrorList{field.Required(fldPath.Child("privateIPAddress"), "static private IP allocation requires a private IP address")}
+		}
+	case DynamicIPAllocationMethod:
+		if nic.PrivateIPAddress != "" {
+			return field.ErrorList{field.Forbidden(fldPath.Child("privateIPAddress"), "dynamic private IP allocation cannot have a private IP address")}
+		}
+	}
+	if nic.PrivateIPAddress != "" && net.ParseIP(nic.PrivateIPAddress).To4() == nil {
+		return field.ErrorList{field.Invalid(fldPath.Child("privateIPAddress"), nic.PrivateIPAddress, "private IP address must be an IPv4 address")}
+	}
+	return nil
+}
+
+// validateImage checks that an image is specified by exactly one of an image ID, a Shared Image Gallery image or an
+// Azure Marketplace image.
+func validateImage(image *Image, fldPath *field.Path) field.ErrorList {
+	var sources []string
+	if image.ID != nil {
+		sources = append(sources, "id")
+	}
+	if image.SubscriptionID != nil || image.ResourceGroup != nil || image.Gallery != nil || image.Name != nil {
+		sources = append(sources, "Shared Image Gallery")
+	}
+	if image.Publisher != nil || image.Offer != nil || image.SKU != nil {
+		sources = append(sources, "Azure Marketplace")
+	}
+	if len(sources) > 1 {
+		return field.ErrorList{field.Invalid(fldPath, sources,
+			"image must be specified by only one of an image ID, a Shared Image Gallery image or an Azure Marketplace image")}
+	}
+	return nil
+}
+
+// validateSSHPublicKey checks that a base64 encoded SSH public key decodes to a key in the authorized_keys format.
+func validateSSHPublicKey(sshPublicKey string, fldPath *field.Path) field.ErrorList {
+	decoded, err := base64.StdEncoding.DecodeString(sshPublicKey)
+	if err != nil {
+		return field.ErrorList{field.Invalid(fldPath, sshPublicKey, "SSH public key must be base64 encoded")}
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey(decoded); err != nil {
+		return field.ErrorList{field.Invalid(fldPath, sshPublicKey, "SSH public key must be in the authorized_keys format: "+err.Error())}
+	}
+	return nil
+}
+
+// toInvalidError returns an Invalid error with the errors, or nil if there are none.
+func (m *AzureMachine) toInvalidError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("AzureMachine").GroupKind(), m.Name, allErrs)
+}
@@ -0,0 +1,800 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testSSHPublicKey is an SSH public key in the authorized_keys format.
+const testSSHPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEQX2H+D22BLq8O54+S9vDRF4XTMWeqR4XjQqN1+MoBo"
+
+func validAzureMachine() *AzureMachine {
+	return &AzureMachine{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-machine"},
+		Spec: AzureMachineSpec{
+			VMSize:        "Standard_D2s_v3",
+			Location:      "westus2",
+			OSType:        LinuxOSType,
+			AdminUsername: "capi",
+			OSDisk: OSDisk{
+				OSType:      "Linux",
+				DiskSizeGB:  30,
+				ManagedDisk: ManagedDisk{StorageAccountType: "Premium_LRS"},
+				Caching:     "ReadWrite",
+			},
+		},
+	}
+}
+
+// causeFields returns the fields of the causes of an Invalid error.
+func causeFields(t *testing.T, err error) []string {
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok || !apierrors.IsInvalid(err) {
+		t.Fatalf("expected an invalid error, got %v", err)
+	}
+	var fields []string
+	for _, cause := range statusErr.ErrStatus.Details.Causes {
+		fields = append(fields, cause.Field)
+	}
+	return fields
+}
+
+func TestAzureMachine_Default(t *testing.T) {
+	tests := []struct {
+		name     string
+		osDisk   OSDisk
+		image    *Image
+		expected OSDisk
+	}{
+		{
+			name:     "default image",
+			osDisk:   OSDisk{OSType: "Linux"},
+			expected: OSDisk{OSType: "Linux", DiskSizeGB: 30, ManagedDisk: ManagedDisk{StorageAccountType: "StandardSSD_LRS"}, Caching: "ReadWrite"},
+		},
+		{
+			name:     "custom image keeps the OS disk size of the image",
+			osDisk:   OSDisk{OSType: "Linux"},
+			image:    &Image{ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/images/my-image")},
+			expected: OSDisk{OSType: "Linux", ManagedDisk: ManagedDisk{StorageAccountType: "StandardSSD_LRS"}, Caching: "ReadWrite"},
+		},
+		{
+			name:   "ephemeral OS disk",
+			osDisk: OSDisk{OSType: "Linux", DiffDiskSettings: &DiffDiskSettings{Option: "Local"}},
+			expected: OSDisk{
+				OSType:           "Linux",
+				DiskSizeGB:       30,
+				ManagedDisk:      ManagedDisk{StorageAccountType: "Standard_LRS"},
+				DiffDiskSettings: &DiffDiskSettings{Option: "Local"},
+				Caching:          "ReadOnly",
+			},
+		},
+		{
+			name:     "set fields are kept",
+			osDisk:   OSDisk{OSType: "Linux", DiskSizeGB: 128, ManagedDisk: ManagedDisk{StorageAccountType: "Premium_LRS"}, Caching: "None"},
+			expected: OSDisk{OSType: "Linux", DiskSizeGB: 128, ManagedDisk: ManagedDisk{StorageAccountType: "Premium_LRS"}, Caching: "None"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &AzureMachine{Spec: AzureMachineSpec{OSDisk: tc.osDisk, Image: tc.image}}
+			machine.Default()
+			if !reflect.DeepEqual(machine.Spec.OSDisk, tc.expected) {
+				t.Fatalf("expected OS disk %+v, got %+v", tc.expected, machine.Spec.OSDisk)
+			}
+			machine.Default()
+			if !reflect.DeepEqual(machine.Spec.OSDisk, tc.expected) {
+				t.Errorf("expected defaulting again to keep the OS disk %+v, got %+v", tc.expected, machine.Spec.OSDisk)
+			}
+		})
+	}
+}
+
+func TestAzureMachine_DefaultOSType(t *testing.T) {
+	tests := []struct {
+		name           string
+		osType         OSType
+		osDiskOSType   string
+		expectedOSType OSType
+	}{
+		{
+			name:           "linux by default",
+			expectedOSType: LinuxOSType,
+		},
+		{
+			name:           "OS type of the OS disk",
+			osDiskOSType:   "Windows",
+			expectedOSType: WindowsOSType,
+		},
+		{
+			name:           "OS type of the machine",
+			osType:         WindowsOSType,
+			expectedOSType: WindowsOSType,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &AzureMachine{Spec: AzureMachineSpec{OSType: tc.osType, OSDisk: OSDisk{OSType: tc.osDiskOSType}}}
+			machine.Default()
+			if machine.Spec.OSType != tc.expectedOSType || machine.Spec.OSDisk.OSType != string(tc.expectedOSType) {
+				t.Errorf("expected OS type %s for the machine and its OS disk, got %s and %s", tc.expectedOSType, machine.Spec.OSType, machine.Spec.OSDisk.OSType)
+			}
+		})
+	}
+}
+
+func TestAzureMachine_ValidateCreate(t *testing.T) {
+	tests := []struct {
+		name           string
+		machine        func() *AzureMachine
+		expectedFields []string
+	}{
+		{
+			name:    "valid machine",
+			machine: validAzureMachine,
+		},
+		{
+			name: "valid spot node",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.SpotVMOptions = &SpotVMOptions{}
+				return m
+			},
+		},
+		{
+			name: "invalid VM size",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMSize = "D2s_v3"
+				return m
+			},
+			expectedFields: []string{"spec.vmSize"},
+		},
+		{
+			name: "missing VM size",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMSize = ""
+				return m
+			},
+			expectedFields: []string{"spec.vmSize"},
+		},
+		{
+			name: "spot control plane machine",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Labels = map[string]string{"cluster.x-k8s.io/control-plane": "true"}
+				m.Spec.SpotVMOptions = &SpotVMOptions{}
+				return m
+			},
+			expectedFields: []string{"spec.spotVMOptions"},
+		},
+		{
+			name: "two network interfaces",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true}, {SubnetName: "my-appliance-subnet"}}
+				return m
+			},
+		},
+		{
+			name: "no primary network interface",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{SubnetName: "my-node-subnet"}, {SubnetName: "my-appliance-subnet"}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces"},
+		},
+		{
+			name: "two primary network interfaces",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true}, {SubnetName: "my-appliance-subnet", Primary: true}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces"},
+		},
+		{
+			name: "secondary network interface without a subnet",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true}, {}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces[1].subnetName"},
+		},
+		{
+			name: "primary network interface in another subnet than the machine",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.SubnetName = "my-node-subnet"
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true, SubnetName: "my-other-node-subnet"}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces[0].subnetName"},
+		},
+		{
+			name: "network interface with a static private IP",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true, PrivateIPAllocationMethod: StaticIPAllocationMethod, PrivateIPAddress: "10.0.0.10"}}
+				return m
+			},
+		},
+		{
+			name: "static private IP allocation without a private IP",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true, PrivateIPAllocationMethod: StaticIPAllocationMethod}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces[0].privateIPAddress"},
+		},
+		{
+			name: "dynamic private IP allocation with a private IP",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true, PrivateIPAllocationMethod: DynamicIPAllocationMethod, PrivateIPAddress: "10.0.0.10"}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces[0].privateIPAddress"},
+		},
+		{
+			name: "invalid private IP",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true}, {SubnetName: "my-appliance-subnet", PrivateIPAddress: "10.0.0.300"}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces[1].privateIPAddress"},
+		},
+		{
+			name: "network interface with IP forwarding and DNS servers",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true}, {SubnetName: "my-appliance-subnet", EnableIPForwarding: true, DNSServers: []string{"10.0.0.4", "10.0.0.5"}}}
+				return m
+			},
+		},
+		{
+			name: "invalid DNS server",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true, DNSServers: []string{"10.0.0.4", "dns.example.com"}}}
+				return m
+			},
+			expectedFields: []string{"spec.networkInterfaces[0].dnsServers[1]"},
+		},
+		{
+			name: "network interface with application security groups",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{
+					Primary:                   true,
+					ApplicationSecurityGroups: []string{"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/my-asg"},
+				}}
+				return m
+			},
+		},
+		{
+			name: "invalid and duplicate application security groups",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NetworkInterfaces = []NetworkInterface{{
+					Primary: true,
+					ApplicationSecurityGroups: []string{
+						"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/my-asg",
+						"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/networkSecurityGroups/my-nsg",
+						"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/MY-ASG",
+					},
+				}}
+				return m
+			},
+			expectedFields: []string{
+				"spec.networkInterfaces[0].applicationSecurityGroups[1]",
+				"spec.networkInterfaces[0].applicationSecurityGroups[2]",
+			},
+		},
+		{
+			name: "vm extension",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMExtensions = []VMExtension{
+					{Name: "my-script", Publisher: "Microsoft.Azure.Extensions", Type: "CustomScript", Version: "2.1"},
+				}
+				return m
+			},
+		},
+		{
+			name: "vm extensions with the same name",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMExtensions = []VMExtension{
+					{Name: "my-script", Publisher: "Microsoft.Azure.Extensions", Type: "CustomScript", Version: "2.1"},
+					{Name: "my-script", Publisher: "Microsoft.Azure.Extensions", Type: "CustomScript", Version: "2.1"},
+				}
+				return m
+			},
+			expectedFields: []string{"spec.vmExtensions[1].name"},
+		},
+		{
+			name: "vm extension without a handler",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMExtensions = []VMExtension{{Name: "my-script"}}
+				return m
+			},
+			expectedFields: []string{"spec.vmExtensions[0].publisher", "spec.vmExtensions[0].type", "spec.vmExtensions[0].version"},
+		},
+		{
+			name: "valid disk encryption sets",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				des := &DiskEncryptionSetParameters{ID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/diskEncryptionSets/my-des"}
+				m.Spec.OSDisk.ManagedDisk.DiskEncryptionSet = des
+				m.Spec.DataDisks = []DataDisk{{NameSuffix: "etcd", DiskSizeGB: 256, ManagedDisk: &ManagedDisk{DiskEncryptionSet: des}}}
+				return m
+			},
+		},
+		{
+			name: "valid existing data disk",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.DataDisks = []DataDisk{{NameSuffix: "shared", ManagedDiskID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/disks/shared"}}
+				return m
+			},
+		},
+		{
+			name: "invalid data disks",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.DataDisks = []DataDisk{
+					{NameSuffix: "etcd", Lun: 0},
+					{NameSuffix: "shared", Lun: 1, DiskSizeGB: 256, ManagedDiskID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/disks/shared"},
+					{NameSuffix: "snapshot", Lun: 2, ManagedDiskID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/snapshots/snapshot"},
+				}
+				return m
+			},
+			expectedFields: []string{"spec.dataDisks[0].diskSizeGB", "spec.dataDisks[1].diskSizeGB", "spec.dataDisks[2].managedDiskID"},
+		},
+		{
+			name: "malformed disk encryption set IDs",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSDisk.ManagedDisk.DiskEncryptionSet = &DiskEncryptionSetParameters{ID: "my-des"}
+				m.Spec.DataDisks = []DataDisk{{NameSuffix: "etcd", DiskSizeGB: 256, ManagedDisk: &ManagedDisk{
+					DiskEncryptionSet: &DiskEncryptionSetParameters{ID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.KeyVault/vaults/my-vault"},
+				}}}
+				return m
+			},
+			expectedFields: []string{"spec.osDisk.managedDisk.diskEncryptionSet.id", "spec.dataDisks[0].managedDisk.diskEncryptionSet.id"},
+		},
+		{
+			name: "valid caching",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSDisk.Caching = "ReadOnly"
+				m.Spec.DataDisks = []DataDisk{
+					{NameSuffix: "etcd", Lun: 0, DiskSizeGB: 256, Caching: "ReadWrite"},
+					{NameSuffix: "logs", Lun: 1, DiskSizeGB: 256, Caching: "None"},
+					{NameSuffix: "fast", Lun: 2, DiskSizeGB: 256, ManagedDisk: &ManagedDisk{StorageAccountType: "UltraSSD_LRS"}, Caching: "None"},
+				}
+				return m
+			},
+		},
+		{
+			name: "invalid caching",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSDisk.Caching = "WriteBack"
+				m.Spec.DataDisks = []DataDisk{
+					{NameSuffix: "etcd", Lun: 0, DiskSizeGB: 256, Caching: "readonly"},
+					{NameSuffix: "fast", Lun: 1, DiskSizeGB: 256, ManagedDisk: &ManagedDisk{StorageAccountType: "UltraSSD_LRS"}, Caching: "ReadOnly"},
+				}
+				return m
+			},
+			expectedFields: []string{"spec.osDisk.caching", "spec.dataDisks[0].caching", "spec.dataDisks[1].caching"},
+		},
+		{
+			name: "ephemeral OS disk with read-write caching",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSDisk.ManagedDisk.StorageAccountType = "Standard_LRS"
+				m.Spec.OSDisk.DiffDiskSettings = &DiffDiskSettings{Option: "Local"}
+				return m
+			},
+			expectedFields: []string{"spec.osDisk.caching"},
+		},
+		{
+			name: "valid write accelerator",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMSize = "Standard_M64s"
+				m.Spec.DataDisks = []DataDisk{{
+					NameSuffix:              "log",
+					DiskSizeGB:              512,
+					ManagedDisk:             &ManagedDisk{StorageAccountType: "Premium_LRS"},
+					WriteAcceleratorEnabled: to.BoolPtr(true),
+				}}
+				return m
+			},
+		},
+		{
+			name: "write accelerator on a standard disk",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.VMSize = "Standard_M64s"
+				m.Spec.DataDisks = []DataDisk{{
+					NameSuffix:              "log",
+					DiskSizeGB:              512,
+					ManagedDisk:             &ManagedDisk{StorageAccountType: "StandardSSD_LRS"},
+					WriteAcceleratorEnabled: to.BoolPtr(true),
+				}}
+				return m
+			},
+			expectedFields: []string{"spec.dataDisks[0].writeAcceleratorEnabled"},
+		},
+		{
+			name: "write accelerator with read-write caching outside the M series",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.DataDisks = []DataDisk{{
+					NameSuffix:              "log",
+					DiskSizeGB:              512,
+					ManagedDisk:             &ManagedDisk{StorageAccountType: "Premium_LRS"},
+					Caching:                 "ReadWrite",
+					WriteAcceleratorEnabled: to.BoolPtr(true),
+				}}
+				return m
+			},
+			expectedFields: []string{"spec.dataDisks[0].caching", "spec.dataDisks[0].writeAcceleratorEnabled"},
+		},
+		{
+			name: "valid windows machine",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSType = WindowsOSType
+				m.Spec.OSDisk.OSType = "Windows"
+				return m
+			},
+		},
+		{
+			name: "OS disk of another OS type",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSType = WindowsOSType
+				return m
+			},
+			expectedFields: []string{"spec.osDisk.osType"},
+		},
+		{
+			name: "windows machine with an ssh public key",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSType = WindowsOSType
+				m.Spec.OSDisk.OSType = "Windows"
+				m.Spec.SSHPublicKey = base64.StdEncoding.EncodeToString([]byte(testSSHPublicKey))
+				return m
+			},
+			expectedFields: []string{"spec.sshPublicKey"},
+		},
+		{
+			name: "custom admin username",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.AdminUsername = "azureuser"
+				return m
+			},
+		},
+		{
+			name: "disallowed admin username",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.AdminUsername = "Admin"
+				return m
+			},
+			expectedFields: []string{"spec.adminUsername"},
+		},
+		{
+			name: "admin username starting with a digit",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.AdminUsername = "1capi"
+				return m
+			},
+			expectedFields: []string{"spec.adminUsername"},
+		},
+		{
+			name: "windows admin username that is too long",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSType = WindowsOSType
+				m.Spec.OSDisk.OSType = "Windows"
+				m.Spec.AdminUsername = "a-very-long-admin-username"
+				return m
+			},
+			expectedFields: []string{"spec.adminUsername"},
+		},
+		{
+			name: "computer name",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.ComputerName = "my-host"
+				return m
+			},
+		},
+		{
+			name: "computer name with invalid characters",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.ComputerName = "my_host."
+				return m
+			},
+			expectedFields: []string{"spec.computerName"},
+		},
+		{
+			name: "windows computer name that is too long",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSType = WindowsOSType
+				m.Spec.OSDisk.OSType = "Windows"
+				m.Spec.ComputerName = "my-windows-host-0"
+				return m
+			},
+			expectedFields: []string{"spec.computerName"},
+		},
+		{
+			name: "valid ssh public key",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.SSHPublicKey = base64.StdEncoding.EncodeToString([]byte(testSSHPublicKey))
+				return m
+			},
+		},
+		{
+			name: "ssh public key that is not base64 encoded",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.SSHPublicKey = testSSHPublicKey
+				return m
+			},
+			expectedFields: []string{"spec.sshPublicKey"},
+		},
+		{
+			name: "base64 encoded value that is not an ssh public key",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.SSHPublicKey = base64.StdEncoding.EncodeToString([]byte("fake-key"))
+				return m
+			},
+			expectedFields: []string{"spec.sshPublicKey"},
+		},
+		{
+			name: "remote bootstrap data",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.BootstrapDataSource = &BootstrapDataSource{URL: "https://myaccount.blob.core.windows.net/bootstrap/my-machine"}
+				return m
+			},
+		},
+		{
+			name: "remote bootstrap data with a query",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.BootstrapDataSource = &BootstrapDataSource{URL: "https://myaccount.blob.core.windows.net/bootstrap/my-machine?sig=abc"}
+				return m
+			},
+			expectedFields: []string{"spec.bootstrapDataSource.url"},
+		},
+		{
+			name: "remote bootstrap data that is not an HTTP URL",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.BootstrapDataSource = &BootstrapDataSource{URL: "ftp://example.com/bootstrap"}
+				return m
+			},
+			expectedFields: []string{"spec.bootstrapDataSource.url"},
+		},
+		{
+			name: "node drain timeout",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NodeDrainTimeout = &metav1.Duration{Duration: 5 * time.Minute}
+				return m
+			},
+		},
+		{
+			name: "negative node drain timeout",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.NodeDrainTimeout = &metav1.Duration{Duration: -time.Minute}
+				return m
+			},
+			expectedFields: []string{"spec.nodeDrainTimeout"},
+		},
+		{
+			name: "windows machine with remote bootstrap data",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSType = WindowsOSType
+				m.Spec.OSDisk.OSType = "Windows"
+				m.Spec.BootstrapDataSource = &BootstrapDataSource{URL: "https://myaccount.blob.core.windows.net/bootstrap/my-machine"}
+				return m
+			},
+			expectedFields: []string{"spec.bootstrapDataSource"},
+		},
+		{
+			name: "valid marketplace image",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.Image = &Image{Publisher: to.StringPtr("cncf-upstream"), Offer: to.StringPtr("capi"), SKU: to.StringPtr("k8s-1dot16-ubuntu-1804"), Version: to.StringPtr("latest")}
+				return m
+			},
+		},
+		{
+			name: "image with an id and a marketplace image",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.Image = &Image{ID: to.StringPtr("my-image-id"), Publisher: to.StringPtr("cncf-upstream")}
+				return m
+			},
+			expectedFields: []string{"spec.image"},
+		},
+		{
+			name: "image with a shared image gallery image and a marketplace image",
+			machine: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.Image = &Image{Gallery: to.StringPtr("my-gallery"), Name: to.StringPtr("my-image"), Offer: to.StringPtr("capi")}
+				return m
+			},
+			expectedFields: []string{"spec.image"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.machine().ValidateCreate()
+			if len(tc.expectedFields) == 0 {
+				if err != nil {
+					t.Fatalf("got an unexpected error: %v", err)
+				}
+				return
+			}
+			if fields := causeFields(t, err); !reflect.DeepEqual(fields, tc.expectedFields) {
+				t.Errorf("expected errors for the fields %v, got %v", tc.expectedFields, err)
+			}
+		})
+	}
+}
+
+func TestAzureMachine_ValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name           string
+		old            func() *AzureMachine
+		update         func(m *AzureMachine)
+		expectedFields []string
+	}{
+		{
+			name: "mutable fields",
+			old:  validAzureMachine,
+			update: func(m *AzureMachine) {
+				m.Spec.ProviderID = to.StringPtr("azure:////my-vm")
+				m.Spec.AdditionalTags = Tags{"foo": "bar"}
+			},
+		},
+		{
+			name: "defaults of a machine created before defaulting",
+			old: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.OSDisk = OSDisk{OSType: "Linux"}
+				return m
+			},
+			update: func(m *AzureMachine) { m.Default() },
+		},
+		{
+			name:           "VM size",
+			old:            validAzureMachine,
+			update:         func(m *AzureMachine) { m.Spec.VMSize = "Standard_D4s_v3" },
+			expectedFields: []string{"spec.vmSize"},
+		},
+		{
+			name:           "image",
+			old:            validAzureMachine,
+			update:         func(m *AzureMachine) { m.Spec.Image = &Image{ID: to.StringPtr("my-image-id")} },
+			expectedFields: []string{"spec.image"},
+		},
+		{
+			name:           "admin username",
+			old:            validAzureMachine,
+			update:         func(m *AzureMachine) { m.Spec.AdminUsername = "azureuser" },
+			expectedFields: []string{"spec.adminUsername"},
+		},
+		{
+			name:           "computer name",
+			old:            validAzureMachine,
+			update:         func(m *AzureMachine) { m.Spec.ComputerName = "my-host" },
+			expectedFields: []string{"spec.computerName"},
+		},
+		{
+			name: "image version",
+			old: func() *AzureMachine {
+				m := validAzureMachine()
+				m.Spec.Image = &Image{Publisher: to.StringPtr("cncf-upstream"), Offer: to.StringPtr("capi"), SKU: to.StringPtr("k8s-1dot16-ubuntu-1804"), Version: to.StringPtr("2019.11.01")}
+				return m
+			},
+			update:         func(m *AzureMachine) { m.Spec.Image.Version = to.StringPtr("2019.12.01") },
+			expectedFields: []string{"spec.image"},
+		},
+		{
+			name:           "OS disk",
+			old:            validAzureMachine,
+			update:         func(m *AzureMachine) { m.Spec.OSDisk.DiskSizeGB = 64 },
+			expectedFields: []string{"spec.osDisk"},
+		},
+		{
+			name: "OS type",
+			old:  validAzureMachine,
+			update: func(m *AzureMachine) {
+				m.Spec.OSType = WindowsOSType
+				m.Spec.OSDisk.OSType = "Windows"
+			},
+			expectedFields: []string{"spec.osType", "spec.osDisk"},
+		},
+		{
+			name: "network interfaces",
+			old:  validAzureMachine,
+			update: func(m *AzureMachine) {
+				m.Spec.NetworkInterfaces = []NetworkInterface{{Primary: true}, {SubnetName: "my-appliance-subnet"}}
+			},
+			expectedFields: []string{"spec.networkInterfaces"},
+		},
+		{
+			name: "several immutable fields",
+			old:  validAzureMachine,
+			update: func(m *AzureMachine) {
+				m.Spec.VMSize = "Standard_D4s_v3"
+				m.Spec.Location = "eastus"
+				m.Spec.AvailabilityZone.ID = to.StringPtr("1")
+			},
+			expectedFields: []string{"spec.vmSize", "spec.location", "spec.availabilityZone"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			old := tc.old()
+			machine := old.DeepCopy()
+			tc.update(machine)
+			err := machine.ValidateUpdate(old)
+			if len(tc.expectedFields) == 0 {
+				if err != nil {
+					t.Fatalf("got an unexpected error: %v", err)
+				}
+				return
+			}
+			if fields := causeFields(t, err); !reflect.DeepEqual(fields, tc.expectedFields) {
+				t.Errorf("expected errors for the fields %v, got %v", tc.expectedFields, err)
+			}
+		})
+	}
+}
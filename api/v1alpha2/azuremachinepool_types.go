@@ -0,0 +1,111 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// MachinePoolFinalizer allows ReconcileAzureMachinePool to delete the VM scale set of an AzureMachinePool before
+	// removing it from the apiserver.
+	MachinePoolFinalizer = "azuremachinepool.infrastructure.cluster.x-k8s.io"
+)
+
+// AzureMachinePoolSpec defines the desired state of AzureMachinePool
+type AzureMachinePoolSpec struct {
+	// Replicas is the number of instances of the VM scale set of the pool. Defaults to 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Version is the Kubernetes version of the nodes of the pool, which selects their default image.
+	// +optional
+	Version *string `json:"version,omitempty"`
+
+	// VMSize is the size of the instances of the pool.
+	VMSize string `json:"vmSize"`
+
+	// Image is the image of the instances of the pool. Defaults to the Ubuntu image of the Kubernetes version.
+	// +optional
+	Image *Image `json:"image,omitempty"`
+
+	// SSHPublicKey is the base64 encoded SSH public key, in the authorized_keys format, which can log in to the
+	// instances of the pool as the admin user.
+	SSHPublicKey string `json:"sshPublicKey"`
+
+	// BootstrapData is the base64 encoded bootstrap data of the instances of the pool, such as the cloud-init data
+	// of a kubeadm join configuration. The scale set is created once it is set.
+	// +optional
+	BootstrapData *string `json:"bootstrapData,omitempty"`
+
+	// AdditionalTags is an optional set of tags to add to the scale set and its instances, in addition to the ones
+	// added by default by the Azure provider. If both the AzureCluster and the AzureMachinePool specify the same tag
+	// name with different values, the AzureMachinePool's value takes precedence.
+	// +optional
+	AdditionalTags Tags `json:"additionalTags,omitempty"`
+
+	// SpotVMOptions makes the instances of the pool Spot (low priority) virtual machines, which Azure can evict at any
+	// time. It cannot change once the scale set is created.
+	// +optional
+	SpotVMOptions *SpotVMOptions `json:"spotVMOptions,omitempty"`
+}
+
+// AzureMachinePoolStatus defines the observed state of AzureMachinePool
+type AzureMachinePoolStatus struct {
+	// Ready is true when the scale set of the pool is provisioned with the desired number of instances.
+	// +optional
+	Ready bool `json:"ready"`
+
+	// Replicas is the number of instances of the scale set of the pool.
+	// +optional
+	Replicas int32 `json:"replicas"`
+
+	// ProvisioningState is the provisioning state of the Azure VM scale set.
+	// +optional
+	ProvisioningState *VMState `json:"provisioningState,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=azuremachinepools,scope=Namespaced,categories=cluster-api
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas
+
+// AzureMachinePool is the Schema for the azuremachinepools API. It is experimental: Cluster API v1alpha2 has no
+// machine pools, so a pool belongs to the cluster named by its cluster.x-k8s.io/cluster-name label and is backed by a
+// VM scale set of worker nodes, reconciled when the manager runs with --enable-machine-pools.
+type AzureMachinePool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AzureMachinePoolSpec   `json:"spec,omitempty"`
+	Status AzureMachinePoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AzureMachinePoolList contains a list of AzureMachinePool
+type AzureMachinePoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AzureMachinePool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AzureMachinePool{}, &AzureMachinePoolList{})
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionType is the type of a condition of an AzureCluster or an AzureMachine.
+type ConditionType string
+
+const (
+	// NetworkReadyCondition reports whether the resource group, the virtual network and the subnets of the cluster,
+	// with their security groups, route table and NAT gateways, and the private DNS zone are reconciled.
+	NetworkReadyCondition ConditionType = "NetworkReady"
+	// LoadBalancersReadyCondition reports whether the load balancers of the cluster are reconciled.
+	LoadBalancersReadyCondition ConditionType = "LoadBalancersReady"
+	// VPNGatewayReadyCondition reports whether the VPN gateway of the cluster, with its gateway subnet and public IP,
+	// is reconciled.
+	VPNGatewayReadyCondition ConditionType = "VPNGatewayReady"
+	// VMProvisionedCondition reports whether the virtual machine of the machine is provisioned.
+	VMProvisionedCondition ConditionType = "VMProvisioned"
+	// VMRunningCondition reports whether the virtual machine of the machine is running.
+	VMRunningCondition ConditionType = "VMRunning"
+)
+
+const (
+	// NetworkReconcileFailedReason is the reason of a False NetworkReady condition when reconciling the network fails.
+	NetworkReconcileFailedReason = "NetworkReconcileFailed"
+	// LoadBalancerReconcileFailedReason is the reason of a False LoadBalancersReady condition when reconciling a load
+	// balancer fails.
+	LoadBalancerReconcileFailedReason = "LoadBalancerReconcileFailed"
+	// VPNGatewayProvisioningReason is the reason of a False VPNGatewayReady condition while Azure creates the VPN
+	// gateway, which can take more than half an hour.
+	VPNGatewayProvisioningReason = "VPNGatewayProvisioning"
+	// VPNGatewayReconcileFailedReason is the reason of a False VPNGatewayReady condition when reconciling the VPN
+	// gateway fails.
+	VPNGatewayReconcileFailedReason = "VPNGatewayReconcileFailed"
+	// WaitingForClusterInfrastructureReason is the reason of a False VMProvisioned condition while the infrastructure
+	// of the cluster is not ready.
+	WaitingForClusterInfrastructureReason = "WaitingForClusterInfrastructure"
+	// WaitingForBootstrapDataReason is the reason of a False VMProvisioned condition while the bootstrap data of the
+	// machine is not available.
+	WaitingForBootstrapDataReason = "WaitingForBootstrapData"
+	// VMProvisioningReason is the reason of a False VMProvisioned condition while Azure creates or updates the virtual
+	// machine.
+	VMProvisioningReason = "VMProvisioning"
+	// VMProvisioningFailedReason is the reason of a False VMProvisioned condition when creating the virtual machine or
+	// its resources fails, or Azure reports a failed provisioning state.
+	VMProvisioningFailedReason = "VMProvisioningFailed"
+	// VMNotRunningReason is the reason of a False VMRunning condition.
+	VMNotRunningReason = "VMNotRunning"
+)
+
+// Condition is an observation of the state of an AzureCluster or an AzureMachine.
+type Condition struct {
+	// Type is the type of the condition.
+	Type ConditionType `json:"type"`
+
+	// Status is the status of the condition, True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+
+	// LastTransitionTime is the last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason is a one-word, CamelCase reason for the status of the condition, set when it is not True.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human-readable message with the details of the status of the condition, such as the error that
+	// made it False.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Conditions are the conditions of an AzureCluster or an AzureMachine, at most one per type.
+type Conditions []Condition
+
+// Get returns the condition of the type, or nil if there is none.
+func (c Conditions) Get(conditionType ConditionType) *Condition {
+	for i := range c {
+		if c[i].Type == conditionType {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// MarkTrue sets the condition of the type to True.
+func (c *Conditions) MarkTrue(conditionType ConditionType) {
+	c.set(Condition{Type: conditionType, Status: corev1.ConditionTrue})
+}
+
+// MarkFalse sets the condition of the type to False with the reason and the message.
+func (c *Conditions) MarkFalse(conditionType ConditionType, reason, message string) {
+	c.set(Condition{Type: conditionType, Status: corev1.ConditionFalse, Reason: reason, Message: message})
+}
+
+// set adds or replaces the condition of its type. The last transition time only changes with the status, so that it
+// tells how long the condition has been in its status.
+func (c *Conditions) set(condition Condition) {
+	condition.LastTransitionTime = metav1.Now()
+	if existing := c.Get(condition.Type); existing != nil {
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = condition
+		return
+	}
+	*c = append(*c, condition)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConditions(t *testing.T) {
+	lastTransitionTime := metav1.NewTime(time.Now().Add(-time.Hour))
+	conditions := Conditions{
+		{Type: NetworkReadyCondition, Status: corev1.ConditionFalse, LastTransitionTime: lastTransitionTime, Reason: NetworkReconcileFailedReason, Message: "conflict"},
+	}
+
+	conditions.MarkFalse(NetworkReadyCondition, NetworkReconcileFailedReason, "timeout")
+	network := conditions.Get(NetworkReadyCondition)
+	if network.Message != "timeout" || !network.LastTransitionTime.Equal(&lastTransitionTime) {
+		t.Errorf("expected the message to change and the last transition time to be kept, got %+v", network)
+	}
+
+	conditions.MarkTrue(NetworkReadyCondition)
+	network = conditions.Get(NetworkReadyCondition)
+	if network.Status != corev1.ConditionTrue || network.Reason != "" || network.Message != "" || network.LastTransitionTime.Equal(&lastTransitionTime) {
+		t.Errorf("expected a True condition without a reason that just transitioned, got %+v", network)
+	}
+
+	conditions.MarkTrue(LoadBalancersReadyCondition)
+	if len(conditions) != 2 || conditions.Get(LoadBalancersReadyCondition) == nil {
+		t.Errorf("expected a condition per type, got %+v", conditions)
+	}
+	if conditions.Get(VMRunningCondition) != nil {
+		t.Errorf("expected no %s condition, got %+v", VMRunningCondition, conditions)
+	}
+}
@@ -68,6 +68,15 @@ func (t Tags) Merge(other Tags) {
 	}
 }
 
+// Merged returns a copy of these tags with the tags from other merged in, and whether other adds or changes any
+// tag. It is used to update the tags of an existing resource without removing the tags added out of band.
+func (t Tags) Merged(other Tags) (Tags, bool) {
+	res := make(Tags, len(t)+len(other))
+	res.Merge(t)
+	res.Merge(other)
+	return res, len(other.Difference(t)) > 0
+}
+
 // ResourceLifecycle configures the lifecycle of a resource
 type ResourceLifecycle string
 
@@ -87,3 +87,59 @@ func TestTags_Merge(t *testing.T) {
 	}
 
 }
+
+func TestTags_Merged(t *testing.T) {
+	tests := []struct {
+		name            string
+		other           Tags
+		expected        Tags
+		expectedChanged bool
+	}{
+		{
+			name:  "nil other",
+			other: nil,
+			expected: Tags{
+				"a": "b",
+			},
+		},
+		{
+			name: "subset",
+			other: Tags{
+				"a": "b",
+			},
+			expected: Tags{
+				"a": "b",
+			},
+		},
+		{
+			name: "changed and added tags",
+			other: Tags{
+				"a": "hello",
+				"1": "2",
+			},
+			expected: Tags{
+				"a": "hello",
+				"1": "2",
+			},
+			expectedChanged: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tags := Tags{
+				"a": "b",
+			}
+
+			merged, changed := tags.Merged(tc.other)
+			if e, a := tc.expected, merged; !reflect.DeepEqual(e, a) {
+				t.Errorf("expected %#v, got %#v", e, a)
+			}
+			if e, a := tc.expectedChanged, changed; e != a {
+				t.Errorf("expected changed %v, got %v", e, a)
+			}
+			if e, a := (Tags{"a": "b"}), tags; !reflect.DeepEqual(e, a) {
+				t.Errorf("expected the tags to be left unchanged, got %#v", a)
+			}
+		})
+	}
+}
@@ -17,9 +17,11 @@ limitations under the License.
 package v1alpha2
 
 import (
+	"net"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -86,6 +88,12 @@ type Network struct {
 
 	// APIServerIP is the Kubernetes API server public IP address.
 	APIServerIP PublicIP `json:"apiServerIp,omitempty"`
+
+	// OutboundIPs are the additional public IPs allocated for the outbound rule of the public load balancer.
+	OutboundIPs []PublicIP `json:"outboundIps,omitempty"`
+
+	// Subnets are the existing subnets of a custom vnet the cluster uses, as found in Azure.
+	Subnets Subnets `json:"subnets,omitempty"`
 }
 
 // NetworkSpec encapsulates all things related to Azure network.
@@ -97,6 +105,327 @@ type NetworkSpec struct {
 	// Subnets is the configuration for the control-plane subnet and the node subnet.
 	// +optional
 	Subnets Subnets `json:"subnets,omitempty"`
+
+	// APIServerPort is the port of the Kubernetes API server that the control plane security group allows
+	// inbound traffic to. Defaults to the API server port of the Cluster, which is 6443 unless set.
+	// +optional
+	APIServerPort *int32 `json:"apiServerPort,omitempty"`
+
+	// OutboundPublicIPCount is the number of public IPs the outbound rule of the public load balancer uses,
+	// including the API server public IP. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	OutboundPublicIPCount *int32 `json:"outboundPublicIPCount,omitempty"`
+
+	// APIServerLB configures the Kubernetes API server load balancer.
+	// +optional
+	APIServerLB LoadBalancerSpec `json:"apiServerLB,omitempty"`
+
+	// Routes are the custom routes of the node route table, for example a route to an on-premises network through a
+	// virtual appliance. Routes of the node route table which are not listed are left untouched.
+	// +optional
+	Routes []RouteSpec `json:"routes,omitempty"`
+
+	// APIServerPrivateEndpoint configures a private endpoint through which clients reach the Kubernetes API server
+	// privately, for example from another virtual network. The private endpoint connects to a private link service
+	// of the internal load balancer, which requires the Standard load balancer SKU.
+	// +optional
+	APIServerPrivateEndpoint *PrivateEndpointSpec `json:"apiServerPrivateEndpoint,omitempty"`
+
+	// VnetPeerings are the peerings from the cluster vnet to remote vnets, for example a hub vnet. Only the peering
+	// of the cluster vnet is managed, the peering from the remote vnet back to the cluster vnet must be created by
+	// the owner of the remote vnet for traffic to flow. Peerings are only managed for a vnet created with the cluster.
+	// +optional
+	VnetPeerings []VnetPeeringSpec `json:"vnetPeerings,omitempty"`
+
+	// SharedSecurityGroup configures a single network security group for the control plane and node subnets, instead
+	// of one security group per role. Its rules combine the rules of the subnets using it.
+	// +optional
+	SharedSecurityGroup *SharedSecurityGroupSpec `json:"sharedSecurityGroup,omitempty"`
+
+	// VPNGateway configures a route-based VPN gateway in the vnet, for connectivity to on-premises networks.
+	// +optional
+	VPNGateway *VPNGatewaySpec `json:"vpnGateway,omitempty"`
+
+	// PrivateDNSZone configures a private DNS zone linked to the vnet, in which the machines of the cluster resolve
+	// each other by hostname.
+	// +optional
+	PrivateDNSZone *PrivateDNSZoneSpec `json:"privateDnsZone,omitempty"`
+}
+
+// PrivateDNSZoneSpec defines the private DNS zone of the cluster vnet.
+type PrivateDNSZoneSpec struct {
+	// Name is the name of the zone. Defaults to <cluster name>.capz.io.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// RegistrationEnabled lets Azure register the virtual machines of the vnet in the zone. Otherwise the provider
+	// creates an A record for the internal IP of each machine of the cluster.
+	// +optional
+	RegistrationEnabled bool `json:"registrationEnabled,omitempty"`
+}
+
+// VPNGatewaySpec defines the VPN gateway of the cluster vnet.
+type VPNGatewaySpec struct {
+	// GatewaySubnetCIDR is the CIDR block of the GatewaySubnet, which the gateway requires. It must be within the
+	// vnet and must not overlap the other subnets. It is only required if the vnet is managed by the provider or the
+	// GatewaySubnet does not exist yet.
+	// +optional
+	GatewaySubnetCIDR string `json:"gatewaySubnetCidrBlock,omitempty"`
+
+	// SKU is the SKU of the gateway. Defaults to VpnGw1. The zone-redundant AZ SKUs use a Standard public IP, the
+	// other SKUs a Basic public IP.
+	// +kubebuilder:validation:Enum=VpnGw1;VpnGw2;VpnGw3;VpnGw1AZ;VpnGw2AZ;VpnGw3AZ
+	// +optional
+	SKU string `json:"sku,omitempty"`
+}
+
+// SharedSecurityGroupSpec defines the network security group shared by the control plane and node subnets.
+type SharedSecurityGroupSpec struct {
+	// Name is the name of the shared security group. Defaults to a name generated from the cluster. Subnets which
+	// set the name of their security group keep their own security group.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// VnetPeeringSpec defines a peering from the cluster vnet to a remote vnet.
+type VnetPeeringSpec struct {
+	// Name is the name of the peering, unique within the cluster vnet. Defaults to a name derived from the cluster
+	// name and the name of the remote vnet.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// RemoteVnetID is the ID of the remote vnet.
+	RemoteVnetID string `json:"remoteVnetID"`
+
+	// AllowForwardedTraffic allows traffic forwarded by the remote vnet, for example by a virtual appliance, into
+	// the cluster vnet.
+	// +optional
+	AllowForwardedTraffic bool `json:"allowForwardedTraffic,omitempty"`
+
+	// AllowGatewayTransit allows the remote vnet to use the gateway of the cluster vnet.
+	// +optional
+	AllowGatewayTransit bool `json:"allowGatewayTransit,omitempty"`
+}
+
+// PrivateEndpointSpec defines a private endpoint of the Kubernetes API server.
+type PrivateEndpointSpec struct {
+	// SubnetID is the ID of the subnet the private endpoint gets its private IP address from. The private endpoint
+	// network policies of the subnet must be disabled.
+	SubnetID string `json:"subnetID"`
+}
+
+// RouteNextHopType defines the type of the next hop of a route.
+type RouteNextHopType string
+
+const (
+	// RouteNextHopTypeVirtualNetworkGateway routes the traffic to the virtual network gateway.
+	RouteNextHopTypeVirtualNetworkGateway = RouteNextHopType("VirtualNetworkGateway")
+	// RouteNextHopTypeVnetLocal routes the traffic within the virtual network.
+	RouteNextHopTypeVnetLocal = RouteNextHopType("VnetLocal")
+	// RouteNextHopTypeInternet routes the traffic to the Internet.
+	RouteNextHopTypeInternet = RouteNextHopType("Internet")
+	// RouteNextHopTypeVirtualAppliance routes the traffic to the next hop IP address of a virtual appliance.
+	RouteNextHopTypeVirtualAppliance = RouteNextHopType("VirtualAppliance")
+	// RouteNextHopTypeNone drops the traffic.
+	RouteNextHopTypeNone = RouteNextHopType("None")
+)
+
+// RouteSpec defines a route of a route table.
+type RouteSpec struct {
+	// Name is the name of the route, unique within the route table.
+	Name string `json:"name"`
+
+	// AddressPrefix is the destination CIDR block of the traffic the route applies to.
+	AddressPrefix string `json:"addressPrefix"`
+
+	// NextHopType is the type of the next hop the traffic is routed to.
+	// +kubebuilder:validation:Enum=VirtualNetworkGateway;VnetLocal;Internet;VirtualAppliance;None
+	NextHopType RouteNextHopType `json:"nextHopType"`
+
+	// NextHopIPAddress is the IP address of the virtual appliance the traffic is routed to. It is required for the
+	// VirtualAppliance next hop type, and not allowed for the other types.
+	// +optional
+	NextHopIPAddress string `json:"nextHopIPAddress,omitempty"`
+}
+
+// LoadBalancerType defines the type of the Kubernetes API server load balancer.
+type LoadBalancerType string
+
+const (
+	// LoadBalancerTypePublic exposes the API server through a public load balancer and public IP.
+	LoadBalancerTypePublic = LoadBalancerType("Public")
+	// LoadBalancerTypeInternal exposes the API server only through the internal load balancer of the vnet.
+	LoadBalancerTypeInternal = LoadBalancerType("Internal")
+)
+
+// LoadBalancerSpec configures the Kubernetes API server load balancer.
+type LoadBalancerSpec struct {
+	// Type is the type of the API server load balancer, Public or Internal. Defaults to Public.
+	// +kubebuilder:validation:Enum=Public;Internal
+	// +optional
+	Type LoadBalancerType `json:"type,omitempty"`
+
+	// SKU is the SKU of the load balancers and of their public IPs, Basic or Standard. Defaults to Standard.
+	// Basic load balancers don't support availability zones or outbound rules, so they can't use additional
+	// outbound public IPs.
+	// +kubebuilder:validation:Enum=Basic;Standard
+	// +optional
+	SKU SKU `json:"sku,omitempty"`
+
+	// DNSLabel is the DNS name label of the API server public IP, which makes the API server reachable at
+	// <DNSLabel>.<location>.cloudapp.azure.com. Defaults to the name of the public IP. Ignored for an Internal load
+	// balancer.
+	// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9-]{1,61}[a-z0-9]$`
+	// +optional
+	DNSLabel string `json:"dnsLabel,omitempty"`
+
+	// HealthProbe configures the health probe of the API server load balancer. Defaults to a TCP probe of the API
+	// server port every 15 seconds, which marks a control plane machine unhealthy after 4 failed probes.
+	// +optional
+	HealthProbe *HealthProbe `json:"healthProbe,omitempty"`
+
+	// IdleTimeoutInMinutes is the idle timeout of the TCP connections to the API server through the load balancer,
+	// between 4 and 30 minutes. Defaults to 4. Long-lived connections, like those of kubectl exec or port-forward,
+	// are dropped when they are idle for longer than the timeout.
+	// +kubebuilder:validation:Minimum=4
+	// +kubebuilder:validation:Maximum=30
+	// +optional
+	IdleTimeoutInMinutes *int32 `json:"idleTimeoutInMinutes,omitempty"`
+
+	// FrontendZones are the availability zones of the frontend public IPs of a Standard Public load balancer, the API
+	// server public IP and the outbound public IPs. A frontend in all the zones of the location, for example 1, 2 and
+	// 3, is zone-redundant and survives the failure of a zone. Defaults to no zones. The zones of an existing public
+	// IP cannot be changed. Ignored for an Internal load balancer.
+	// +optional
+	FrontendZones []string `json:"frontendZones,omitempty"`
+
+	// AdditionalRules are load balancing rules of a Public load balancer in addition to the API server one, to expose
+	// other services of the machines, for example a metrics aggregator on the control plane machines. Ignored for an
+	// Internal load balancer.
+	// +optional
+	AdditionalRules []LoadBalancingRule `json:"additionalRules,omitempty"`
+
+	// PublicIP configures the API server public IP of a Public load balancer. Defaults to a public IP created with
+	// the cluster.
+	// +optional
+	PublicIP *PublicIPSpec `json:"publicIP,omitempty"`
+
+	// FrontendPrivateIP is the static private IP address of the frontend of the internal load balancer, which must
+	// be within the CIDR block of the control plane subnet. Unlike the internalLBIPAddress of the control plane
+	// subnet, which is only preferred and replaced by an available IP address when it is taken, the internal load
+	// balancer fails to reconcile when this IP address is not available. It takes precedence over internalLBIPAddress.
+	// +optional
+	FrontendPrivateIP string `json:"frontendPrivateIP,omitempty"`
+}
+
+// PublicIPSpec configures the API server public IP.
+type PublicIPSpec struct {
+	// Name is the name of the public IP in the resource group of the cluster. Defaults to a name generated from the
+	// cluster. A public IP that already exists and is not tagged as owned by the cluster is used as is, and it is kept
+	// when the cluster is deleted.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// ExternallyManaged marks the public IP as managed outside of the cluster: it must exist, and it is neither
+	// updated nor deleted with the cluster, even if it is tagged as owned by the cluster.
+	// +optional
+	ExternallyManaged bool `json:"externallyManaged,omitempty"`
+}
+
+// ProbeProtocol defines the protocol of a load balancer health probe.
+type ProbeProtocol string
+
+const (
+	// ProbeProtocolTCP probes by opening a TCP connection.
+	ProbeProtocolTCP = ProbeProtocol("Tcp")
+	// ProbeProtocolHTTP probes with an HTTP GET request, which must return 200 OK.
+	ProbeProtocolHTTP = ProbeProtocol("Http")
+	// ProbeProtocolHTTPS probes with an HTTPS GET request, which must return 200 OK. It requires a Standard load
+	// balancer.
+	ProbeProtocolHTTPS = ProbeProtocol("Https")
+)
+
+// LoadBalancingRuleProtocol defines the transport protocol of a load balancing rule.
+type LoadBalancingRuleProtocol string
+
+const (
+	// LoadBalancingRuleProtocolTCP forwards TCP traffic.
+	LoadBalancingRuleProtocolTCP = LoadBalancingRuleProtocol("Tcp")
+	// LoadBalancingRuleProtocolUDP forwards UDP traffic.
+	LoadBalancingRuleProtocolUDP = LoadBalancingRuleProtocol("Udp")
+)
+
+// BackendPoolRole defines the machines of a backend pool of the load balancer.
+type BackendPoolRole string
+
+const (
+	// BackendPoolControlPlane is the backend pool of the control plane machines.
+	BackendPoolControlPlane = BackendPoolRole("ControlPlane")
+	// BackendPoolNode is the backend pool of the nodes.
+	BackendPoolNode = BackendPoolRole("Node")
+)
+
+// LoadBalancingRule configures a load balancing rule, which forwards a port of the frontend of a load balancer to
+// the machines of one of its backend pools that its health probe reports healthy.
+type LoadBalancingRule struct {
+	// Name is the name of the rule, unique within the load balancer.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Protocol is the transport protocol of the rule, Tcp or Udp. Defaults to Tcp.
+	// +kubebuilder:validation:Enum=Tcp;Udp
+	// +optional
+	Protocol LoadBalancingRuleProtocol `json:"protocol,omitempty"`
+
+	// FrontendPort is the port of the frontend of the load balancer, unique for the protocol within the load balancer.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65534
+	FrontendPort int32 `json:"frontendPort"`
+
+	// BackendPort is the port of the machines traffic is forwarded to. Defaults to the frontend port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	BackendPort *int32 `json:"backendPort,omitempty"`
+
+	// BackendPool is the backend pool of the rule, ControlPlane or Node. Defaults to ControlPlane.
+	// +kubebuilder:validation:Enum=ControlPlane;Node
+	// +optional
+	BackendPool BackendPoolRole `json:"backendPool,omitempty"`
+
+	// Probe is the health probe of the rule. Its port defaults to the backend port.
+	Probe HealthProbe `json:"probe"`
+}
+
+// HealthProbe configures the health probe of a load balancer.
+type HealthProbe struct {
+	// Protocol is the protocol of the probe, Tcp, Http or Https. Defaults to Tcp.
+	// +kubebuilder:validation:Enum=Tcp;Http;Https
+	// +optional
+	Protocol ProbeProtocol `json:"protocol,omitempty"`
+
+	// Port is the port that is probed. Defaults to the API server port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// RequestPath is the path of the HTTP or HTTPS request of the probe, for example /healthz. It is required by Http
+	// and Https probes, and not allowed for Tcp probes.
+	// +optional
+	RequestPath string `json:"requestPath,omitempty"`
+
+	// IntervalSeconds is the interval between two probes. Defaults to 15.
+	// +kubebuilder:validation:Minimum=5
+	// +optional
+	IntervalSeconds *int32 `json:"intervalSeconds,omitempty"`
+
+	// NumberOfProbes is the number of consecutive failed probes after which a machine is considered unhealthy.
+	// Defaults to 4.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	NumberOfProbes *int32 `json:"numberOfProbes,omitempty"`
 }
 
 // VnetSpec configures an Azure virtual network.
@@ -114,15 +443,48 @@ type VnetSpec struct {
 	// CidrBlock is the CIDR block to be used when the provider creates a managed virtual network.
 	CidrBlock string `json:"cidrBlock,omitempty"`
 
+	// CIDRBlocks are the IPv4 and IPv6 CIDR blocks to be used when the provider creates a managed virtual network,
+	// for dual-stack clusters. They take precedence over CidrBlock, which has to be one of them when both are set.
+	// +optional
+	CIDRBlocks []string `json:"cidrBlocks,omitempty"`
+
+	// DdosProtectionPlan enables the standard DDoS protection of a managed virtual network, with the referenced DDoS
+	// protection plan or with a plan created along the cluster.
+	// +optional
+	DdosProtectionPlan *DdosProtectionPlan `json:"ddosProtectionPlan,omitempty"`
+
+	// DNSServers are the IP addresses of the DNS servers of a managed virtual network, which replace the
+	// Azure-provided DNS. Removing all of them leaves the DNS servers of the virtual network as they are.
+	// +optional
+	DNSServers []string `json:"dnsServers,omitempty"`
+
 	// Tags is a collection of tags describing the resource.
 	Tags Tags `json:"tags,omitempty"`
 }
 
+// DdosProtectionPlan defines the Azure DDoS protection plan of a virtual network.
+type DdosProtectionPlan struct {
+	// ID is the ID of an existing DDoS protection plan, which is only referenced and not managed by the provider.
+	// Plans can protect the vnets of several subscriptions, so a single plan is usually shared by many clusters.
+	// +optional
+	ID string `json:"id,omitempty"`
+
+	// Name is the name of the DDoS protection plan created and deleted with the cluster, when no ID is set.
+	// Defaults to the DDoS protection plan name of the cluster.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
 // IsManaged returns true if the vnet is managed.
 func (v *VnetSpec) IsManaged(clusterName string) bool {
 	return v.ID == "" || v.Tags.HasOwned(clusterName)
 }
 
+// CIDRs returns the CIDR blocks of the vnet, its CIDRBlocks when set and its CidrBlock otherwise.
+func (v *VnetSpec) CIDRs() []string {
+	return cidrs(v.CidrBlock, v.CIDRBlocks)
+}
+
 // Subnets is a slice of Subnet.
 type Subnets []*SubnetSpec
 
@@ -151,10 +513,10 @@ var (
 
 // SecurityGroup defines an Azure security group.
 type SecurityGroup struct {
-	ID           string       `json:"id,omitempty"`
-	Name         string       `json:"name,omitempty"`
-	IngressRules IngressRules `json:"ingressRule,omitempty"`
-	Tags         Tags         `json:"tags,omitempty"`
+	ID            string        `json:"id,omitempty"`
+	Name          string        `json:"name,omitempty"`
+	SecurityRules SecurityRules `json:"ingressRule,omitempty"`
+	Tags          Tags          `json:"tags,omitempty"`
 }
 
 /*
@@ -179,11 +541,46 @@ var (
 	SecurityGroupProtocolUDP = SecurityGroupProtocol("Udp")
 )
 
-// IngressRule defines an Azure ingress rule for security groups.
-type IngressRule struct {
-	Description string                `json:"description"`
+// SecurityRuleDirection defines the direction of traffic a security group rule applies to.
+type SecurityRuleDirection string
+
+var (
+	// SecurityRuleDirectionInbound defines an ingress security rule
+	SecurityRuleDirectionInbound = SecurityRuleDirection("Inbound")
+
+	// SecurityRuleDirectionOutbound defines an egress security rule
+	SecurityRuleDirectionOutbound = SecurityRuleDirection("Outbound")
+)
+
+// SecurityRuleAccess defines whether network traffic matching a security group rule is allowed or denied.
+type SecurityRuleAccess string
+
+var (
+	// SecurityRuleAccessAllow allows the matching network traffic
+	SecurityRuleAccessAllow = SecurityRuleAccess("Allow")
+
+	// SecurityRuleAccessDeny denies the matching network traffic
+	SecurityRuleAccessDeny = SecurityRuleAccess("Deny")
+)
+
+// SecurityRule defines an Azure security rule for security groups.
+type SecurityRule struct {
+	// Name - A name for the security rule, unique within the security group.
+	Name        string                `json:"name"`
+	Description string                `json:"description,omitempty"`
 	Protocol    SecurityGroupProtocol `json:"protocol"`
 
+	// Priority - A number between 100 and 4096. Each rule in a security group must have a unique priority for its direction. Rules are processed in priority order, with lower numbers processed before higher numbers.
+	Priority int32 `json:"priority"`
+
+	// Direction - The direction of the traffic the rule applies to. Defaults to Inbound.
+	// +optional
+	Direction SecurityRuleDirection `json:"direction,omitempty"`
+
+	// Access - Whether traffic matching the rule is allowed or denied. Defaults to Allow.
+	// +optional
+	Access SecurityRuleAccess `json:"access,omitempty"`
+
 	// SourcePorts - The source port or range. Integer or range between 0 and 65535. Asterix '*' can also be used to match all ports.
 	SourcePorts *string `json:"sourcePorts,omitempty"`
 
@@ -195,23 +592,33 @@ type IngressRule struct {
 
 	// Destination - The destination address prefix. CIDR or destination IP range. Asterix '*' can also be used to match all source IPs. Default tags such as 'VirtualNetwork', 'AzureLoadBalancer' and 'Internet' can also be used.
 	Destination *string `json:"destination,omitempty"`
+
+	// SourceApplicationSecurityGroups - The resource IDs of the application security groups the traffic originates
+	// from, instead of a source address prefix. Source cannot be set with them.
+	// +optional
+	SourceApplicationSecurityGroups []string `json:"sourceApplicationSecurityGroups,omitempty"`
+
+	// DestinationApplicationSecurityGroups - The resource IDs of the application security groups the traffic is
+	// destined to, instead of a destination address prefix. Destination cannot be set with them.
+	// +optional
+	DestinationApplicationSecurityGroups []string `json:"destinationApplicationSecurityGroups,omitempty"`
 }
 
 // TODO
-// String returns a string representation of the ingress rule.
+// String returns a string representation of the security rule.
 /*
-func (i *IngressRule) String() string {
+func (i *SecurityRule) String() string {
 	return fmt.Sprintf("protocol=%s/range=[%d-%d]/description=%s", i.Protocol, i.FromPort, i.ToPort, i.Description)
 }
 */
 
-// IngressRules is a slice of Azure ingress rules for security groups.
-type IngressRules []*IngressRule
+// SecurityRules is a slice of Azure security rules for security groups.
+type SecurityRules []*SecurityRule
 
 // TODO
 // Difference returns the difference between this slice and the other slice.
 /*
-func (i IngressRules) Difference(o IngressRules) (out IngressRules) {
+func (i SecurityRules) Difference(o SecurityRules) (out SecurityRules) {
 	for _, x := range i {
 		found := false
 		for _, y := range o {
@@ -282,6 +689,7 @@ var (
 )
 
 type FrontendIPConfig struct {
+	PrivateIPAddress string `json:"privateIPAddress,omitempty"`
 	// 	/*
 	// 		// FrontendIPConfigurationPropertiesFormat - Properties of the load balancer probe.
 	// 		*FrontendIPConfigurationPropertiesFormat `json:"properties,omitempty"`
@@ -353,6 +761,25 @@ var (
 	VMStateUpdating = VMState("Updating")
 )
 
+// VMPowerState describes the power state of an Azure virtual machine.
+type VMPowerState string
+
+const (
+	// VMPowerStateStarting is the power state of a virtual machine while it starts.
+	VMPowerStateStarting = VMPowerState("starting")
+	// VMPowerStateRunning is the power state of a running virtual machine.
+	VMPowerStateRunning = VMPowerState("running")
+	// VMPowerStateStopping is the power state of a virtual machine while it stops.
+	VMPowerStateStopping = VMPowerState("stopping")
+	// VMPowerStateStopped is the power state of a stopped virtual machine, whose compute resources are still
+	// allocated and billed.
+	VMPowerStateStopped = VMPowerState("stopped")
+	// VMPowerStateDeallocating is the power state of a virtual machine while its compute resources are released.
+	VMPowerStateDeallocating = VMPowerState("deallocating")
+	// VMPowerStateDeallocated is the power state of a stopped virtual machine whose compute resources are released.
+	VMPowerStateDeallocated = VMPowerState("deallocated")
+)
+
 // VM describes an Azure virtual machine.
 type VM struct {
 	ID               string `json:"id,omitempty"`
@@ -369,6 +796,9 @@ type VM struct {
 	Identity VMIdentity `json:"identity,omitempty"`
 	Tags     Tags       `json:"tags,omitempty"`
 
+	// PowerState - The power state reported in the instance view, empty while Azure reports none.
+	PowerState VMPowerState `json:"powerState,omitempty"`
+
 	// Addresses contains the Azure instance associated addresses.
 	Addresses []corev1.NodeAddress `json:"addresses,omitempty"`
 
@@ -399,7 +829,8 @@ type AvailabilityZone struct {
 // If specifying an image by ID, only the ID field needs to be set.
 // If specifying an image by publisher, the Publisher, Offer, SKU, and Version fields must be set.
 // If specifying an image from a Shared Image Gallery, the SubscriptionID, ResourceGroup,
-// Gallery, Name, and Version fields must be set.
+// Gallery, Name, and Version fields must be set, a Version of latest selects the latest image version.
+// An image can only be specified by one of these.
 type Image struct {
 	Publisher *string `json:"publisher,omitempty"`
 	Offer     *string `json:"offer,omitempty"`
@@ -413,6 +844,23 @@ type Image struct {
 	Name           *string `json:"name,omitempty"`
 
 	Version *string `json:"version,omitempty"`
+
+	// Plan is the purchase plan of a third-party Azure Marketplace image. The marketplace terms of the plan must be
+	// accepted in the subscription before machines can use the image.
+	// +optional
+	Plan *ImagePlan `json:"plan,omitempty"`
+}
+
+// ImagePlan is the purchase plan of a third-party Azure Marketplace image.
+type ImagePlan struct {
+	// Publisher is the publisher of the image.
+	Publisher string `json:"publisher"`
+
+	// Offer is the offer of the image.
+	Offer string `json:"offer"`
+
+	// Name is the name of the plan, usually the SKU of the image.
+	Name string `json:"name"`
 }
 
 // APIEndpoint represents a reachable Kubernetes API endpoint.
@@ -427,14 +875,251 @@ type APIEndpoint struct {
 // VMIdentity defines the identity of the virtual machine, if configured.
 type VMIdentity string
 
+const (
+	// VMIdentityNone means the virtual machine has no system-assigned identity.
+	VMIdentityNone = VMIdentity("None")
+	// VMIdentitySystemAssigned means Azure creates an identity tied to the lifecycle of the virtual machine.
+	VMIdentitySystemAssigned = VMIdentity("SystemAssigned")
+)
+
+// ProximityPlacementGroupScope defines the machines of a cluster placed in its proximity placement group.
+type ProximityPlacementGroupScope string
+
+const (
+	// ProximityPlacementGroupNone means the cluster has no proximity placement group.
+	ProximityPlacementGroupNone = ProximityPlacementGroupScope("None")
+	// ProximityPlacementGroupControlPlane means the control plane machines are placed in the proximity placement group.
+	ProximityPlacementGroupControlPlane = ProximityPlacementGroupScope("ControlPlane")
+	// ProximityPlacementGroupAll means all the machines are placed in the proximity placement group.
+	ProximityPlacementGroupAll = ProximityPlacementGroupScope("All")
+)
+
+// OSType is the operating system of a virtual machine.
+type OSType string
+
+const (
+	// LinuxOSType is the operating system of Linux virtual machines.
+	LinuxOSType = OSType("Linux")
+	// WindowsOSType is the operating system of Windows virtual machines.
+	WindowsOSType = OSType("Windows")
+)
+
 type OSDisk struct {
-	OSType      string      `json:"osType"`
-	DiskSizeGB  int32       `json:"diskSizeGB"`
+	OSType string `json:"osType"`
+
+	// DiskSizeGB is the size of the OS disk in GB, it cannot be smaller than the OS disk of the image. Defaults to the
+	// size of the OS disk of the image, which is 30 for the default image.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DiskSizeGB int32 `json:"diskSizeGB,omitempty"`
+
+	// ManagedDisk specifies the storage account type of the OS disk. Defaults to StandardSSD_LRS, or to Standard_LRS
+	// for an ephemeral OS disk.
+	// +optional
 	ManagedDisk ManagedDisk `json:"managedDisk"`
+
+	// DiffDiskSettings makes the OS disk ephemeral, stored on the local cache of the VM instead of a managed disk.
+	// The VM size must have a cache at least as large as the disk, and the storage account type must be Standard_LRS.
+	// +optional
+	DiffDiskSettings *DiffDiskSettings `json:"diffDiskSettings,omitempty"`
+
+	// Caching is the host caching of the OS disk, None, ReadOnly or ReadWrite. Defaults to ReadWrite, or to ReadOnly
+	// for an ephemeral OS disk, which only supports ReadOnly.
+	// +kubebuilder:validation:Enum=None;ReadOnly;ReadWrite
+	// +optional
+	Caching string `json:"caching,omitempty"`
 }
 
+// DiffDiskSettings specifies an ephemeral OS disk.
+type DiffDiskSettings struct {
+	// Option is the ephemeral disk option, only Local is supported.
+	// +kubebuilder:validation:Enum=Local
+	Option string `json:"option"`
+}
+
+// ManagedDisk specifies the storage of a managed disk.
 type ManagedDisk struct {
-	StorageAccountType string `json:"storageAccountType"`
+	// StorageAccountType is the storage account type of the disk, Standard_LRS, StandardSSD_LRS, Premium_LRS or
+	// UltraSSD_LRS. OS disks cannot be Ultra SSDs.
+	// +kubebuilder:validation:Enum=Standard_LRS;StandardSSD_LRS;Premium_LRS;UltraSSD_LRS
+	// +optional
+	StorageAccountType string `json:"storageAccountType,omitempty"`
+
+	// DiskEncryptionSet encrypts the disk with the customer-managed key of a disk encryption set.
+	// +optional
+	DiskEncryptionSet *DiskEncryptionSetParameters `json:"diskEncryptionSet,omitempty"`
+}
+
+// DiskEncryptionSetParameters references a disk encryption set.
+type DiskEncryptionSetParameters struct {
+	// ID is the resource ID of the disk encryption set, for example
+	// /subscriptions/<subscription>/resourceGroups/<group>/providers/Microsoft.Compute/diskEncryptionSets/<name>.
+	ID string `json:"id"`
+}
+
+// VMExtension specifies an extension of a virtual machine.
+type VMExtension struct {
+	// Name is the name of the extension, unique among the extensions of the machine.
+	Name string `json:"name"`
+
+	// Publisher is the publisher of the extension handler, for example Microsoft.Azure.Extensions.
+	Publisher string `json:"publisher"`
+
+	// Type is the type of the extension handler, for example CustomScript.
+	Type string `json:"type"`
+
+	// Version is the major and minor version of the extension handler, for example 2.1. Newer minor versions are
+	// installed automatically.
+	Version string `json:"version"`
+
+	// Settings are the public settings of the extension, for example the commandToExecute of a custom script
+	// extension. They are readable in the VM instance view, the settings must not hold secrets.
+	// +optional
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// NetworkInterface specifies a network interface of a machine.
+type NetworkInterface struct {
+	// SubnetName is the name of the cluster subnet of the network interface. It defaults to the subnet of the machine
+	// for the primary network interface and is required for the others.
+	// +optional
+	SubnetName string `json:"subnetName,omitempty"`
+
+	// Primary is true for the primary network interface of the machine, which is the one in the backend pools of the
+	// cluster load balancers and with the public IP of the machine, if any.
+	// +optional
+	Primary bool `json:"primary,omitempty"`
+
+	// PrivateIPAllocationMethod is the allocation method of the private IP address of the network interface, Dynamic
+	// or Static. Defaults to Static when the network interface has a private IP address, and to Dynamic otherwise.
+	// +kubebuilder:validation:Enum=Dynamic;Static
+	// +optional
+	PrivateIPAllocationMethod IPAllocationMethod `json:"privateIPAllocationMethod,omitempty"`
+
+	// PrivateIPAddress is the static private IP address of the network interface, it must be in the subnet of the
+	// network interface. A network interface with a static private IP address keeps it until it is deleted.
+	// +optional
+	PrivateIPAddress string `json:"privateIPAddress,omitempty"`
+
+	// ApplicationSecurityGroups are the resource IDs of the application security groups of the network interface,
+	// which the security rules of the cluster can use as their source or destination. The application security groups
+	// must be in the location of the machine.
+	// +optional
+	ApplicationSecurityGroups []string `json:"applicationSecurityGroups,omitempty"`
+
+	// EnableIPForwarding enables the network interface to send and receive traffic for IP addresses other than its
+	// own, as a virtual appliance does.
+	// +optional
+	EnableIPForwarding bool `json:"enableIPForwarding,omitempty"`
+
+	// DNSServers are the IP addresses of the DNS servers of the network interface, in order. They take precedence over
+	// the DNS servers of the virtual network, which the network interface uses if there are none.
+	// +optional
+	DNSServers []string `json:"dnsServers,omitempty"`
+}
+
+// IPAllocationMethod is the allocation method of a private IP address.
+type IPAllocationMethod string
+
+const (
+	// DynamicIPAllocationMethod means Azure allocates a free IP address of the subnet.
+	DynamicIPAllocationMethod = IPAllocationMethod("Dynamic")
+	// StaticIPAllocationMethod means the IP address is the configured one.
+	StaticIPAllocationMethod = IPAllocationMethod("Static")
+)
+
+// DataDisk specifies a managed data disk attached to a machine, either an empty disk created with the machine or an
+// existing disk.
+type DataDisk struct {
+	// NameSuffix is appended to the machine name to name the disk, it must be unique among the data disks of the
+	// machine.
+	NameSuffix string `json:"nameSuffix"`
+
+	// DiskSizeGB is the size of the disk in GB. It is required for an empty disk and must not be set for an existing
+	// disk, which keeps its size.
+	// +optional
+	DiskSizeGB int32 `json:"diskSizeGB,omitempty"`
+
+	// ManagedDiskID is the resource ID of an existing managed disk to attach instead of creating an empty disk. The
+	// existing disk is not deleted with the machine.
+	// +optional
+	ManagedDiskID string `json:"managedDiskID,omitempty"`
+
+	// Lun is the logical unit number of the disk, it must be unique among the data disks of the machine.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=63
+	Lun int32 `json:"lun"`
+
+	// ManagedDisk specifies the storage account type of the disk. Defaults to the Azure default for the VM size.
+	// UltraSSD_LRS disks enable Ultra SSD on the machine, which must then be placed in an availability zone.
+	// +optional
+	ManagedDisk *ManagedDisk `json:"managedDisk,omitempty"`
+
+	// Caching is the host caching of the disk, None, ReadOnly or ReadWrite. Defaults to the Azure default, None.
+	// UltraSSD_LRS disks only support None.
+	// +kubebuilder:validation:Enum=None;ReadOnly;ReadWrite
+	// +optional
+	Caching string `json:"caching,omitempty"`
+
+	// WriteAcceleratorEnabled enables write accelerator on the disk, which lowers its write latency. It requires a
+	// Premium_LRS disk with None or ReadOnly caching, and a VM size of the M series that supports it.
+	// +optional
+	WriteAcceleratorEnabled *bool `json:"writeAcceleratorEnabled,omitempty"`
+}
+
+// BootDiagnosticsStorageAccountType defines where the boot diagnostics of a virtual machine are stored.
+type BootDiagnosticsStorageAccountType string
+
+const (
+	// ManagedBootDiagnosticsStorage stores boot diagnostics in a storage account managed by Azure.
+	ManagedBootDiagnosticsStorage = BootDiagnosticsStorageAccountType("Managed")
+	// UserManagedBootDiagnosticsStorage stores boot diagnostics in a storage account provided by the user.
+	UserManagedBootDiagnosticsStorage = BootDiagnosticsStorageAccountType("UserManaged")
+	// DisabledBootDiagnosticsStorage disables boot diagnostics.
+	DisabledBootDiagnosticsStorage = BootDiagnosticsStorageAccountType("Disabled")
+)
+
+// Diagnostics configures the diagnostics of a virtual machine.
+type Diagnostics struct {
+	// Boot configures the boot diagnostics, the serial console output and screenshot of the virtual machine.
+	// +optional
+	Boot *BootDiagnostics `json:"boot,omitempty"`
+}
+
+// BootDiagnostics configures the boot diagnostics of a virtual machine.
+type BootDiagnostics struct {
+	// StorageAccountType is where boot diagnostics are stored, Managed, UserManaged or Disabled. Defaults to Managed.
+	// +kubebuilder:validation:Enum=Managed;UserManaged;Disabled
+	// +optional
+	StorageAccountType BootDiagnosticsStorageAccountType `json:"storageAccountType,omitempty"`
+
+	// StorageAccountURI is the blob endpoint of the storage account of UserManaged boot diagnostics, for example
+	// https://mystorageaccount.blob.core.windows.net/.
+	// +optional
+	StorageAccountURI string `json:"storageAccountURI,omitempty"`
+}
+
+// SpotEvictionPolicy defines what happens to a Spot virtual machine when Azure evicts it.
+type SpotEvictionPolicy string
+
+const (
+	// SpotEvictionPolicyDeallocate stops the virtual machine and keeps its disks.
+	SpotEvictionPolicyDeallocate = SpotEvictionPolicy("Deallocate")
+	// SpotEvictionPolicyDelete deletes the virtual machine and its disks.
+	SpotEvictionPolicyDelete = SpotEvictionPolicy("Delete")
+)
+
+// SpotVMOptions configures a Spot virtual machine.
+type SpotVMOptions struct {
+	// MaxPrice is the maximum price per hour in US dollars to pay for the virtual machine, -1 pays up to the
+	// on-demand price and never evicts the virtual machine for price reasons. Defaults to -1.
+	// +optional
+	MaxPrice *resource.Quantity `json:"maxPrice,omitempty"`
+
+	// EvictionPolicy is the eviction policy of the virtual machine, Deallocate or Delete. Defaults to Deallocate.
+	// +kubebuilder:validation:Enum=Deallocate;Delete
+	// +optional
+	EvictionPolicy SpotEvictionPolicy `json:"evictionPolicy,omitempty"`
 }
 
 // SubnetRole defines the unique role of a subnet.
@@ -462,12 +1147,149 @@ type SubnetSpec struct {
 	// CidrBlock is the CIDR block to be used when the provider creates a managed Vnet.
 	CidrBlock string `json:"cidrBlock,omitempty"`
 
+	// CIDRBlocks are the CIDR blocks to be used when the provider creates a managed Vnet, at most one IPv4 and one
+	// IPv6 CIDR block for a dual-stack subnet. They take precedence over CidrBlock, which has to be the IPv4 one of
+	// them when both are set.
+	// +optional
+	CIDRBlocks []string `json:"cidrBlocks,omitempty"`
+
 	// InternalLBIPAddress is the IP address that will be used as the internal LB private IP.
 	// For the control plane subnet only.
 	InternalLBIPAddress string `json:"internalLBIPAddress,omitempty"`
 
-	// SecurityGroup defines the NSG (network security group) that should be attached to this subnet.
+	// SecurityGroup defines the NSG (network security group) that should be attached to this subnet. The NSG is
+	// referenced by name and created with the cluster in a managed vnet. It defaults to the shared security group of
+	// the cluster if any, or to the security group of the subnet role otherwise.
 	SecurityGroup SecurityGroup `json:"securityGroup,omitempty"`
+
+	// RouteTable is the route table attached to the subnet, referenced by name. It defaults to the node route table
+	// of the cluster, any other route table has to exist in the resource group of the cluster.
+	// +optional
+	RouteTable RouteTable `json:"routeTable,omitempty"`
+
+	// NatGateway is the NAT gateway used for the outbound connectivity of a node subnet. The NAT gateway is
+	// created with the cluster, its name defaults to the node NAT gateway name of the cluster.
+	// +optional
+	NatGateway *NatGateway `json:"natGateway,omitempty"`
+
+	// ServiceEndpoints are the Azure services, like Microsoft.Storage or Microsoft.Sql, which the subnet accesses
+	// through service endpoints.
+	// +optional
+	ServiceEndpoints []string `json:"serviceEndpoints,omitempty"`
+
+	// Delegations are the Azure services, like Microsoft.ContainerInstance/containerGroups, which the subnet is
+	// delegated to. A delegated subnet is reserved for the resources of these services.
+	// +optional
+	Delegations []string `json:"delegations,omitempty"`
+}
+
+// CIDRs returns the CIDR blocks of the subnet, its CIDRBlocks when set and its CidrBlock otherwise.
+func (s *SubnetSpec) CIDRs() []string {
+	return cidrs(s.CidrBlock, s.CIDRBlocks)
+}
+
+// IPv4CIDR returns the IPv4 CIDR block of the subnet, or an empty string when it has none.
+func (s *SubnetSpec) IPv4CIDR() string {
+	for _, cidr := range s.CIDRs() {
+		if ip, _, err := net.ParseCIDR(cidr); err == nil && ip.To4() != nil {
+			return cidr
+		}
+	}
+	return ""
+}
+
+// cidrs returns the CIDR blocks when set, or else the single CIDR block when set.
+func cidrs(cidrBlock string, cidrBlocks []string) []string {
+	if len(cidrBlocks) > 0 {
+		return cidrBlocks
+	}
+	if cidrBlock != "" {
+		return []string{cidrBlock}
+	}
+	return nil
+}
+
+// NatGateway defines an Azure NAT gateway.
+type NatGateway struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// RouteTable defines an Azure route table.
+type RouteTable struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// ClusterIdentityType is the type of the Azure identity used to manage the resources of a cluster.
+type ClusterIdentityType string
+
+const (
+	// ServicePrincipalIdentity is an Azure service principal authenticated with a client secret.
+	ServicePrincipalIdentity = ClusterIdentityType("ServicePrincipal")
+	// ManagedIdentity is the managed identity of the Azure virtual machine the provider runs on, which needs no secret.
+	ManagedIdentity = ClusterIdentityType("ManagedIdentity")
+)
+
+// ClusterIdentity defines the Azure identity used to manage the resources of a cluster.
+type ClusterIdentity struct {
+	// Type is the type of the identity, ServicePrincipal or ManagedIdentity. Defaults to ServicePrincipal.
+	// +kubebuilder:validation:Enum=ServicePrincipal;ManagedIdentity
+	// +optional
+	Type ClusterIdentityType `json:"type,omitempty"`
+
+	// SecretRef references a secret in the namespace of the AzureCluster with the tenant ID, the client ID and the
+	// client secret of an Azure service principal, in its tenantID, clientID and clientSecret keys. The secret is
+	// read on each reconciliation, so rotating the client secret only requires updating it. Required for a
+	// ServicePrincipal identity.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// ClientID is the client ID of a user-assigned managed identity of the virtual machine. Defaults to its
+	// system-assigned managed identity. Only used by a ManagedIdentity identity.
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+}
+
+// SASTokenKey is the key of the shared access signature token in the secret referenced by a BootstrapDataSource.
+const SASTokenKey = "sasToken"
+
+// BootstrapDataSource references bootstrap data stored outside of the cluster, for example in a blob of an Azure
+// storage account. The machine downloads it at boot, so it is not limited by the size of the custom data.
+type BootstrapDataSource struct {
+	// URL is the HTTP or HTTPS URL of the bootstrap data, without a query.
+	URL string `json:"url"`
+
+	// SASTokenSecretRef references a secret in the namespace of the AzureMachine with a shared access signature
+	// token, in its sasToken key, appended to the URL as its query. The secret is read when the machine is created.
+	// +optional
+	SASTokenSecretRef *corev1.LocalObjectReference `json:"sasTokenSecretRef,omitempty"`
+}
+
+// FutureType is the type of the long-running Azure operation of a Future.
+type FutureType string
+
+const (
+	// PutFuture is the type of a Future creating or updating a resource.
+	PutFuture FutureType = "PUT"
+	// DeleteFuture is the type of a Future deleting a resource.
+	DeleteFuture FutureType = "DELETE"
+)
+
+// Future is a long-running Azure operation on a resource, persisted so that a later reconcile polls it instead of
+// blocking until it completes.
+type Future struct {
+	// Type is the type of the operation.
+	Type FutureType `json:"type"`
+
+	// ResourceGroup is the resource group of the resource.
+	ResourceGroup string `json:"resourceGroup"`
+
+	// Name is the name of the resource.
+	Name string `json:"name"`
+
+	// Data is the JSON serialized Azure SDK future of the operation, with the URL to poll it.
+	Data string `json:"data"`
 }
 
 const (
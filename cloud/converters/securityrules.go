@@ -0,0 +1,58 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package converters
+
+import (
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+)
+
+// SDKToSecurityRule converts an Azure SDK SecurityRule to the CAPZ SecurityRule type.
+func SDKToSecurityRule(r network.SecurityRule) *infrav1.SecurityRule {
+	rule := &infrav1.SecurityRule{
+		Name: to.String(r.Name),
+	}
+
+	if r.SecurityRulePropertiesFormat != nil {
+		rule.Description = to.String(r.Description)
+		rule.Protocol = infrav1.SecurityGroupProtocol(r.Protocol)
+		rule.Priority = to.Int32(r.Priority)
+		rule.Direction = infrav1.SecurityRuleDirection(r.Direction)
+		rule.Access = infrav1.SecurityRuleAccess(r.Access)
+		rule.SourcePorts = r.SourcePortRange
+		rule.DestinationPorts = r.DestinationPortRange
+		rule.Source = r.SourceAddressPrefix
+		rule.Destination = r.DestinationAddressPrefix
+		rule.SourceApplicationSecurityGroups = applicationSecurityGroupIDs(r.SourceApplicationSecurityGroups)
+		rule.DestinationApplicationSecurityGroups = applicationSecurityGroupIDs(r.DestinationApplicationSecurityGroups)
+	}
+
+	return rule
+}
+
+// applicationSecurityGroupIDs returns the IDs of the application security groups, or nil if there are none.
+func applicationSecurityGroupIDs(asgs *[]network.ApplicationSecurityGroup) []string {
+	if asgs == nil || len(*asgs) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(*asgs))
+	for _, asg := range *asgs {
+		ids = append(ids, to.String(asg.ID))
+	}
+	return ids
+}
@@ -18,6 +18,9 @@ package azure
 
 import (
 	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
 
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/blang/semver"
@@ -27,7 +30,7 @@ import (
 
 const (
 	// DefaultUserName is the default username for created vm
-	DefaultUserName = "capi"
+	DefaultUserName = infrav1.DefaultAdminUsername
 	// DefaultVnetCIDR is the default Vnet CIDR
 	DefaultVnetCIDR = "10.0.0.0/8"
 	// DefaultControlPlaneSubnetCIDR is the default Control Plane Subnet CIDR
@@ -42,13 +45,55 @@ const (
 	UserAgent = "cluster-api-azure-services"
 )
 
+const (
+	// DefaultLoadBalancerIdleTimeoutInMinutes is the default idle timeout of the load balancing rules
+	DefaultLoadBalancerIdleTimeoutInMinutes = 4
+	// MinLoadBalancerIdleTimeoutInMinutes is the minimum idle timeout that Azure allows for a load balancing rule
+	MinLoadBalancerIdleTimeoutInMinutes = 4
+	// MaxLoadBalancerIdleTimeoutInMinutes is the maximum idle timeout that Azure allows for a load balancing rule
+	MaxLoadBalancerIdleTimeoutInMinutes = 30
+	// ControlPlaneBackendPoolName is the name of the backend pool of the public load balancer for the control plane.
+	ControlPlaneBackendPoolName = "controlplane-backEndPool"
+	// ControlPlaneInternalBackendPoolName is the name of the backend pool of the internal load balancer for the
+	// control plane.
+	ControlPlaneInternalBackendPoolName = "controlplane-internal-backEndPool"
+	// NodeBackendPoolName is the name of the backend pool of the public load balancer for the nodes.
+	NodeBackendPoolName = "node-backEndPool"
+	// GatewaySubnetName is the name Azure requires for the subnet of the virtual network gateways of a vnet.
+	GatewaySubnetName = "GatewaySubnet"
+	// DefaultVPNGatewaySKU is the default SKU of the VPN gateway.
+	DefaultVPNGatewaySKU = "VpnGw1"
+	// DefaultPrivateDNSZoneDomain is the parent domain of the default private DNS zone of a cluster.
+	DefaultPrivateDNSZoneDomain = "capz.io"
+)
+
+const (
+	// maxResourceNameLength is the maximum length of the name of most Azure network and compute resources.
+	maxResourceNameLength = 80
+	// maxVnetNameLength is the maximum length of the name of a virtual network.
+	maxVnetNameLength = 64
+	// maxDNSLabelLength is the maximum length of a DNS label, which public IP names are also used as.
+	maxDNSLabelLength = 63
+	// maxWindowsComputerNameLength is the maximum length of the computer name of a Windows virtual machine.
+	maxWindowsComputerNameLength = 15
+	// maxLinuxComputerNameLength is the maximum length of the computer name of a Linux virtual machine.
+	maxLinuxComputerNameLength = 64
+)
+
+// invalidComputerNameCharsRegex matches the characters that are not allowed in the computer name of a virtual machine.
+var invalidComputerNameCharsRegex = regexp.MustCompile(`[^A-Za-z0-9-]`)
+
 const (
 	// DefaultImageOfferID is the default Azure Marketplace offer ID
 	DefaultImageOfferID = "capi"
+	// DefaultWindowsImageOfferID is the default Azure Marketplace offer ID of Windows images
+	DefaultWindowsImageOfferID = "capi-windows"
 	// DefaultImagePublisherID is the default Azure Marketplace publisher ID
 	DefaultImagePublisherID = "cncf-upstream"
 	// LatestVersion is the image version latest
 	LatestVersion = "latest"
+	// DefaultImageOSDiskSizeGB is the size of the OS disk of the default Azure Marketplace images
+	DefaultImageOSDiskSizeGB = 30
 )
 
 // SupportedAvailabilityZoneLocations is a slice of the locations where Availability Zones are supported.
@@ -72,54 +117,169 @@ var SupportedAvailabilityZoneLocations = []string{
 	"southeastasia",
 }
 
+// generateName joins the prefix and the suffix with a hyphen. If the result is longer than maxLength, the prefix is
+// shortened and followed by a hash of the full prefix, so that the name is deterministic, keeps its suffix, and stays
+// distinct from the names generated from other long prefixes.
+func generateName(prefix, suffix string, maxLength int) string {
+	name := fmt.Sprintf("%s-%s", prefix, suffix)
+	if len(name) <= maxLength {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(prefix))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	keep := maxLength - len(hash) - len(suffix) - 2
+	if keep < 0 {
+		keep = 0
+	}
+	// Avoid a doubled separator where the prefix was cut.
+	if shortened := strings.TrimRight(prefix[:keep], "-."); shortened != "" {
+		return fmt.Sprintf("%s-%s-%s", shortened, hash, suffix)
+	}
+	return fmt.Sprintf("%s-%s", hash, suffix)
+}
+
 // GenerateVnetName generates a virtual network name, based on the cluster name.
 func GenerateVnetName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "vnet")
+	return generateName(clusterName, "vnet", maxVnetNameLength)
 }
 
 // GenerateControlPlaneSecurityGroupName generates a control plane security group name, based on the cluster name.
 func GenerateControlPlaneSecurityGroupName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "controlplane-nsg")
+	return generateName(clusterName, "controlplane-nsg", maxResourceNameLength)
 }
 
 // GenerateNodeSecurityGroupName generates a node security group name, based on the cluster name.
 func GenerateNodeSecurityGroupName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "node-nsg")
+	return generateName(clusterName, "node-nsg", maxResourceNameLength)
+}
+
+// GenerateSharedSecurityGroupName generates the name of the security group shared by the control plane and node
+// subnets, based on the cluster name.
+func GenerateSharedSecurityGroupName(clusterName string) string {
+	return generateName(clusterName, "nsg", maxResourceNameLength)
 }
 
 // GenerateNodeRouteTableName generates a node route table name, based on the cluster name.
 func GenerateNodeRouteTableName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "node-routetable")
+	return generateName(clusterName, "node-routetable", maxResourceNameLength)
 }
 
 // GenerateControlPlaneSubnetName generates a node subnet name, based on the cluster name.
 func GenerateControlPlaneSubnetName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "controlplane-subnet")
+	return generateName(clusterName, "controlplane-subnet", maxResourceNameLength)
 }
 
 // GenerateNodeSubnetName generates a node subnet name, based on the cluster name.
 func GenerateNodeSubnetName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "node-subnet")
+	return generateName(clusterName, "node-subnet", maxResourceNameLength)
+}
+
+// GenerateNodeNatGatewayName generates a node NAT gateway name, based on the cluster name.
+func GenerateNodeNatGatewayName(clusterName string) string {
+	return generateName(clusterName, "node-natgw", maxResourceNameLength)
+}
+
+// GenerateNatGatewayIPName generates the name of the public IP of a NAT gateway, based on the NAT gateway name.
+func GenerateNatGatewayIPName(natGatewayName string) string {
+	return generateName(natGatewayName, "ip", maxDNSLabelLength)
+}
+
+// GenerateVPNGatewayName generates a VPN gateway name, based on the cluster name.
+func GenerateVPNGatewayName(clusterName string) string {
+	return generateName(clusterName, "vpn-gateway", maxResourceNameLength)
+}
+
+// GenerateVPNGatewayIPName generates the name of the public IP of a VPN gateway, based on the VPN gateway name.
+func GenerateVPNGatewayIPName(vpnGatewayName string) string {
+	return generateName(vpnGatewayName, "ip", maxDNSLabelLength)
+}
+
+// GeneratePrivateDNSZoneName generates a private DNS zone name, based on the cluster name. The cluster name is the
+// first label of the zone name, shortened when it is longer than a DNS label.
+func GeneratePrivateDNSZoneName(clusterName string) string {
+	label := clusterName
+	if len(label) > maxDNSLabelLength {
+		label = generateName(clusterName, "dns", maxDNSLabelLength)
+	}
+	return fmt.Sprintf("%s.%s", label, DefaultPrivateDNSZoneDomain)
+}
+
+// GenerateVnetLinkName generates the name of the link of a private DNS zone to the vnet, based on the cluster name.
+func GenerateVnetLinkName(clusterName string) string {
+	return generateName(clusterName, "vnet-link", maxResourceNameLength)
+}
+
+// GenerateDdosProtectionPlanName generates a DDoS protection plan name, based on the cluster name.
+func GenerateDdosProtectionPlanName(clusterName string) string {
+	return generateName(clusterName, "ddos-plan", maxResourceNameLength)
+}
+
+// GenerateDdosProtectionPlanID generates the ID of a DDoS protection plan.
+func GenerateDdosProtectionPlanID(subscriptionID, resourceGroup, planName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/ddosProtectionPlans/%s",
+		subscriptionID, resourceGroup, planName)
+}
+
+// GenerateVnetID generates the ID of a virtual network.
+func GenerateVnetID(subscriptionID, resourceGroup, vnetName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s",
+		subscriptionID, resourceGroup, vnetName)
+}
+
+// GenerateSubnetID generates the ID of a subnet of a virtual network.
+func GenerateSubnetID(subscriptionID, resourceGroup, vnetName, subnetName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s",
+		subscriptionID, resourceGroup, vnetName, subnetName)
+}
+
+// GenerateVnetPeeringName generates the name of a peering of the cluster vnet, based on the cluster name and the
+// name of the remote vnet.
+func GenerateVnetPeeringName(clusterName, remoteVnetName string) string {
+	return generateName(clusterName, "to-"+remoteVnetName, maxResourceNameLength)
 }
 
 // GenerateInternalLBName generates a internal load balancer name, based on the cluster name.
 func GenerateInternalLBName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "internal-lb")
+	return generateName(clusterName, "internal-lb", maxResourceNameLength)
 }
 
 // GeneratePublicLBName generates a public load balancer name, based on the cluster name.
 func GeneratePublicLBName(clusterName string) string {
-	return fmt.Sprintf("%s-%s", clusterName, "public-lb")
+	return generateName(clusterName, "public-lb", maxResourceNameLength)
+}
+
+// GeneratePrivateLinkServiceName generates the name of the private link service of the internal load balancer, based
+// on the cluster name.
+func GeneratePrivateLinkServiceName(clusterName string) string {
+	return generateName(clusterName, "apiserver-pls", maxResourceNameLength)
+}
+
+// GeneratePrivateEndpointName generates the name of the private endpoint of the API server, based on the cluster name.
+func GeneratePrivateEndpointName(clusterName string) string {
+	return generateName(clusterName, "apiserver-pe", maxResourceNameLength)
+}
+
+// GenerateBackendAddressPoolID generates the ID of a backend address pool of a load balancer.
+func GenerateBackendAddressPoolID(subscriptionID, resourceGroup, lbName, poolName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/loadBalancers/%s/backendAddressPools/%s",
+		subscriptionID, resourceGroup, lbName, poolName)
 }
 
 // GeneratePublicIPName generates a public IP name, based on the cluster name and a hash.
 func GeneratePublicIPName(clusterName, hash string) string {
-	return fmt.Sprintf("%s-%s", clusterName, hash)
+	return generateName(clusterName, hash, maxDNSLabelLength)
 }
 
-// GenerateFQDN generates a fully qualified domain name, based on the public IP name and cluster location.
-func GenerateFQDN(publicIPName, location string) string {
-	return fmt.Sprintf("%s.%s.%s", publicIPName, location, DefaultAzureDNSZone)
+// GenerateOutboundPublicIPName generates the name of an additional outbound public IP of the public load balancer,
+// based on the API server public IP name and the index of the IP.
+func GenerateOutboundPublicIPName(publicIPName string, index int) string {
+	return generateName(publicIPName, fmt.Sprintf("outbound-%d", index), maxDNSLabelLength)
+}
+
+// GenerateFQDN generates a fully qualified domain name, based on the DNS name label of a public IP and the cluster location.
+func GenerateFQDN(dnsLabel, location string) string {
+	return fmt.Sprintf("%s.%s.%s", dnsLabel, location, DefaultAzureDNSZone)
 }
 
 // GenerateNICName generates the name of a network interface based on the name of a VM.
@@ -127,18 +287,84 @@ func GenerateNICName(machineName string) string {
 	return fmt.Sprintf("%s-nic", machineName)
 }
 
+// GenerateSecondaryNICName generates the name of a secondary network interface of a VM, based on the name of the VM
+// and the index of the network interface in the machine spec.
+func GenerateSecondaryNICName(machineName string, index int) string {
+	return fmt.Sprintf("%s-nic-%d", machineName, index)
+}
+
+// GenerateControlPlaneAvailabilitySetName generates the name of the availability set of the control plane virtual
+// machines, based on the cluster name.
+func GenerateControlPlaneAvailabilitySetName(clusterName string) string {
+	return generateName(clusterName, "controlplane-as", maxResourceNameLength)
+}
+
+// GenerateWindowsComputerName generates the computer name of a Windows virtual machine based on the name of the VM.
+// Names longer than the 15 characters Windows allows are shortened and followed by a hash of the full name, so that
+// the computer names of machines sharing a long prefix stay distinct.
+func GenerateWindowsComputerName(machineName string) string {
+	return generateComputerName(machineName, maxWindowsComputerNameLength)
+}
+
+// GenerateLinuxComputerName generates the computer name of a Linux virtual machine based on the name of the VM, the
+// same way as GenerateWindowsComputerName but within the 64 characters Linux allows.
+func GenerateLinuxComputerName(machineName string) string {
+	return generateComputerName(machineName, maxLinuxComputerNameLength)
+}
+
+// generateComputerName replaces the characters a computer name cannot contain with hyphens, and shortens the name to
+// maxLength characters followed by a hash of the machine name when it is too long. The computer name only depends on
+// the machine name, so it stays the same across reconciles.
+func generateComputerName(machineName string, maxLength int) string {
+	computerName := strings.Trim(invalidComputerNameCharsRegex.ReplaceAllString(machineName, "-"), "-")
+	if computerName != "" && len(computerName) <= maxLength {
+		return computerName
+	}
+	h := fnv.New32a()
+	h.Write([]byte(machineName))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	keep := maxLength - len(hash) - 1
+	if keep > len(computerName) {
+		keep = len(computerName)
+	}
+	if shortened := strings.TrimRight(computerName[:keep], "-"); shortened != "" {
+		return fmt.Sprintf("%s-%s", shortened, hash)
+	}
+	return hash
+}
+
+// GenerateProximityPlacementGroupName generates the name of the proximity placement group of the cluster.
+func GenerateProximityPlacementGroupName(clusterName string) string {
+	return generateName(clusterName, "ppg", maxResourceNameLength)
+}
+
 // GenerateOSDiskName generates the name of an OS disk based on the name of a VM.
 func GenerateOSDiskName(machineName string) string {
 	return fmt.Sprintf("%s_OSDisk", machineName)
 }
 
+// GenerateDataDiskName generates the name of a data disk based on the name of a VM and the name suffix of the disk.
+func GenerateDataDiskName(machineName, nameSuffix string) string {
+	return fmt.Sprintf("%s_%s", machineName, nameSuffix)
+}
+
 // GetDefaultImageSKUID gets the SKU ID of the image to use for the provided version of Kubernetes.
 func getDefaultImageSKUID(k8sVersion string) (string, error) {
+	return getImageSKUID(k8sVersion, "ubuntu-1804")
+}
+
+// getDefaultWindowsImageSKUID gets the SKU ID of the Windows image to use for the provided version of Kubernetes.
+func getDefaultWindowsImageSKUID(k8sVersion string) (string, error) {
+	return getImageSKUID(k8sVersion, "windows-2019")
+}
+
+// getImageSKUID gets the SKU ID of the image of an OS, for example ubuntu-1804, for the provided version of Kubernetes.
+func getImageSKUID(k8sVersion, os string) (string, error) {
 	version, err := semver.ParseTolerant(k8sVersion)
 	if err != nil {
 		return "", errors.Wrapf(err, "unable to parse Kubernetes version \"%s\" in spec, expected valid SemVer string", k8sVersion)
 	}
-	return fmt.Sprintf("k8s-%ddot%ddot%d-ubuntu-1804", version.Major, version.Minor, version.Patch), nil
+	return fmt.Sprintf("k8s-%ddot%ddot%d-%s", version.Major, version.Minor, version.Patch, os), nil
 }
 
 // GetDefaultUbuntuImage returns the default image spec for Ubuntu.
@@ -154,3 +380,17 @@ func GetDefaultUbuntuImage(k8sVersion string) (infrav1.Image, error) {
 		Version:   to.StringPtr(LatestVersion),
 	}, nil
 }
+
+// GetDefaultWindowsImage returns the default image spec for Windows Server.
+func GetDefaultWindowsImage(k8sVersion string) (infrav1.Image, error) {
+	skuID, err := getDefaultWindowsImageSKUID(k8sVersion)
+	if err != nil {
+		return infrav1.Image{}, errors.Wrapf(err, "failed to get default Windows image")
+	}
+	return infrav1.Image{
+		Publisher: to.StringPtr(DefaultImagePublisherID),
+		Offer:     to.StringPtr(DefaultWindowsImageOfferID),
+		SKU:       to.StringPtr(skuID),
+		Version:   to.StringPtr(LatestVersion),
+	}, nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package azure
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/onsi/gomega"
@@ -83,3 +84,181 @@ func TestGetDefaultImageSKUID(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	var tests = []struct {
+		name           string
+		prefix         string
+		suffix         string
+		maxLength      int
+		expectedResult string
+	}{
+		{
+			name:           "name that fits",
+			prefix:         "my-cluster",
+			suffix:         "node-nsg",
+			maxLength:      80,
+			expectedResult: "my-cluster-node-nsg",
+		},
+		{
+			name:           "name that exactly fits",
+			prefix:         "my-cluster",
+			suffix:         "node-nsg",
+			maxLength:      19,
+			expectedResult: "my-cluster-node-nsg",
+		},
+		{
+			name:           "name that is too long",
+			prefix:         "my-very-long-cluster-name",
+			suffix:         "node-nsg",
+			maxLength:      30,
+			expectedResult: "my-very-long-5aa349ec-node-nsg",
+		},
+		{
+			name:           "prefix cut after a separator",
+			prefix:         "my-cluster-name-that-is-long",
+			suffix:         "node-nsg",
+			maxLength:      29,
+			expectedResult: "my-cluster-e73265fa-node-nsg",
+		},
+		{
+			name:           "no room left for the prefix",
+			prefix:         "my-cluster",
+			suffix:         "node-nsg",
+			maxLength:      12,
+			expectedResult: "be8edd5c-node-nsg",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g.Expect(generateName(test.prefix, test.suffix, test.maxLength)).To(gomega.Equal(test.expectedResult))
+		})
+	}
+}
+
+func TestGenerateNamesForLongClusterNames(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	clusterName := strings.Repeat("a", 90)
+	otherClusterName := strings.Repeat("a", 89) + "b"
+	generators := []struct {
+		name      string
+		generate  func(string) string
+		maxLength int
+	}{
+		{name: "vnet", generate: GenerateVnetName, maxLength: maxVnetNameLength},
+		{name: "control plane security group", generate: GenerateControlPlaneSecurityGroupName, maxLength: maxResourceNameLength},
+		{name: "node security group", generate: GenerateNodeSecurityGroupName, maxLength: maxResourceNameLength},
+		{name: "shared security group", generate: GenerateSharedSecurityGroupName, maxLength: maxResourceNameLength},
+		{name: "node route table", generate: GenerateNodeRouteTableName, maxLength: maxResourceNameLength},
+		{name: "control plane subnet", generate: GenerateControlPlaneSubnetName, maxLength: maxResourceNameLength},
+		{name: "node subnet", generate: GenerateNodeSubnetName, maxLength: maxResourceNameLength},
+		{name: "node NAT gateway", generate: GenerateNodeNatGatewayName, maxLength: maxResourceNameLength},
+		{name: "VPN gateway", generate: GenerateVPNGatewayName, maxLength: maxResourceNameLength},
+		{name: "vnet link", generate: GenerateVnetLinkName, maxLength: maxResourceNameLength},
+		{name: "DDoS protection plan", generate: GenerateDdosProtectionPlanName, maxLength: maxResourceNameLength},
+		{name: "vnet peering", generate: func(clusterName string) string { return GenerateVnetPeeringName(clusterName, "hub-vnet") }, maxLength: maxResourceNameLength},
+		{name: "internal load balancer", generate: GenerateInternalLBName, maxLength: maxResourceNameLength},
+		{name: "public load balancer", generate: GeneratePublicLBName, maxLength: maxResourceNameLength},
+		{name: "private link service", generate: GeneratePrivateLinkServiceName, maxLength: maxResourceNameLength},
+		{name: "private endpoint", generate: GeneratePrivateEndpointName, maxLength: maxResourceNameLength},
+		{name: "control plane availability set", generate: GenerateControlPlaneAvailabilitySetName, maxLength: maxResourceNameLength},
+		{name: "proximity placement group", generate: GenerateProximityPlacementGroupName, maxLength: maxResourceNameLength},
+		{name: "public IP", generate: func(clusterName string) string { return GeneratePublicIPName(clusterName, "1a2b3c4d") }, maxLength: maxDNSLabelLength},
+	}
+	for _, generator := range generators {
+		t.Run(generator.name, func(t *testing.T) {
+			name := generator.generate(clusterName)
+			g.Expect(len(name)).To(gomega.BeNumerically("<=", generator.maxLength))
+			g.Expect(generator.generate(clusterName)).To(gomega.Equal(name))
+			g.Expect(generator.generate(otherClusterName)).NotTo(gomega.Equal(name))
+			g.Expect(strings.HasPrefix(name, "aaaa")).To(gomega.BeTrue())
+		})
+	}
+
+	g.Expect(GenerateNodeSecurityGroupName(clusterName)).To(gomega.HaveSuffix("-node-nsg"))
+	g.Expect(GenerateSharedSecurityGroupName(clusterName)).To(gomega.HaveSuffix("-nsg"))
+	g.Expect(GenerateControlPlaneSecurityGroupName(clusterName)).To(gomega.HaveSuffix("-controlplane-nsg"))
+	g.Expect(len(GenerateNatGatewayIPName(GenerateNodeNatGatewayName(clusterName)))).To(gomega.BeNumerically("<=", maxDNSLabelLength))
+	g.Expect(len(GenerateVPNGatewayIPName(GenerateVPNGatewayName(clusterName)))).To(gomega.BeNumerically("<=", maxDNSLabelLength))
+	g.Expect(GeneratePrivateDNSZoneName(clusterName)).To(gomega.HaveSuffix(".capz.io"))
+	g.Expect(len(strings.Split(GeneratePrivateDNSZoneName(clusterName), ".")[0])).To(gomega.BeNumerically("<=", maxDNSLabelLength))
+	g.Expect(len(GenerateOutboundPublicIPName(GeneratePublicIPName(clusterName, "1a2b3c4d"), 10))).To(gomega.BeNumerically("<=", maxDNSLabelLength))
+}
+
+func TestGenerateWindowsComputerName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	var tests = []struct {
+		name           string
+		machineName    string
+		expectedResult string
+	}{
+		{
+			name:           "name that fits",
+			machineName:    "win-md-0-cb5b9",
+			expectedResult: "win-md-0-cb5b9",
+		},
+		{
+			name:           "name that exactly fits",
+			machineName:    "my-cluster-cp-0",
+			expectedResult: "my-cluster-cp-0",
+		},
+		{
+			name:           "name that is too long",
+			machineName:    "my-cluster-md-0-abcde",
+			expectedResult: "my-clu-69758505",
+		},
+		{
+			name:           "name cut after a separator",
+			machineName:    "my-vm-md-0-abcde",
+			expectedResult: "my-vm-83c4193c",
+		},
+		{
+			name:           "name with invalid characters",
+			machineName:    "win_md.0",
+			expectedResult: "win-md-0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g.Expect(GenerateWindowsComputerName(test.machineName)).To(gomega.Equal(test.expectedResult))
+		})
+	}
+	g.Expect(GenerateWindowsComputerName("my-cluster-md-0-abcdf")).NotTo(gomega.Equal(GenerateWindowsComputerName("my-cluster-md-0-abcde")))
+}
+
+func TestGenerateLinuxComputerName(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	var tests = []struct {
+		name           string
+		machineName    string
+		expectedResult string
+	}{
+		{
+			name:           "name that fits",
+			machineName:    "my-cluster-md-0-abcde",
+			expectedResult: "my-cluster-md-0-abcde",
+		},
+		{
+			name:           "name that is too long",
+			machineName:    "my-cluster-with-a-very-long-name-for-the-sake-of-the-test-md-0-abcde",
+			expectedResult: "my-cluster-with-a-very-long-name-for-the-sake-of-the-te-e67b3646",
+		},
+		{
+			name:           "name with invalid characters",
+			machineName:    "_my.cluster_md-0.",
+			expectedResult: "my-cluster-md-0",
+		},
+		{
+			name:           "name without valid characters",
+			machineName:    "__",
+			expectedResult: "720ba823",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g.Expect(GenerateLinuxComputerName(test.machineName)).To(gomega.Equal(test.expectedResult))
+			g.Expect(len(GenerateLinuxComputerName(test.machineName))).To(gomega.BeNumerically("<=", maxLinuxComputerNameLength))
+		})
+	}
+}
@@ -17,13 +17,146 @@ limitations under the License.
 package azure
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 )
 
 // ResourceNotFound parses the error to check if it's a resource not found
 func ResourceNotFound(err error) bool {
-	if derr, ok := err.(autorest.DetailedError); ok && derr.StatusCode == 404 {
+	code, ok := statusCode(err)
+	return ok && code == 404
+}
+
+// detailedError returns the Azure API error, looking through errors wrapped with context.
+func detailedError(err error) (autorest.DetailedError, bool) {
+	switch e := errors.Cause(err).(type) {
+	case autorest.DetailedError:
+		return e, true
+	case *autorest.DetailedError:
+		if e == nil {
+			return autorest.DetailedError{}, false
+		}
+		return *e, true
+	default:
+		return autorest.DetailedError{}, false
+	}
+}
+
+// statusCode returns the HTTP status code of an Azure API error, looking through errors wrapped with context.
+func statusCode(err error) (int, bool) {
+	derr, ok := detailedError(err)
+	if !ok {
+		return 0, false
+	}
+	if code, ok := derr.StatusCode.(int); ok && code != 0 {
+		return code, true
+	}
+	if derr.Response != nil {
+		return derr.Response.StatusCode, true
+	}
+	return 0, false
+}
+
+// OperationNotDoneError is returned when a long-running Azure operation has not completed yet. Its future is
+// persisted, so the reconcile is requeued to poll it later instead of blocking.
+type OperationNotDoneError struct {
+	Future *infrav1.Future
+}
+
+// NewOperationNotDoneError returns an OperationNotDoneError for the future.
+func NewOperationNotDoneError(future *infrav1.Future) *OperationNotDoneError {
+	return &OperationNotDoneError{Future: future}
+}
+
+func (e *OperationNotDoneError) Error() string {
+	return fmt.Sprintf("operation type %s on Azure resource %s/%s is not done", e.Future.Type, e.Future.ResourceGroup, e.Future.Name)
+}
+
+// IsOperationNotDoneError returns true if the error is an OperationNotDoneError, looking through errors wrapped with
+// context.
+func IsOperationNotDoneError(err error) bool {
+	_, ok := errors.Cause(err).(*OperationNotDoneError)
+	return ok
+}
+
+// ResourceInUseError is returned when an Azure resource cannot be deleted yet because other resources still depend on
+// it, such as a subnet with network interfaces. The deletion is retried once its dependents are gone.
+type ResourceInUseError struct {
+	Kind       string
+	Name       string
+	Dependents []string
+}
+
+// NewResourceInUseError returns a ResourceInUseError for the resource of the kind and its dependents.
+func NewResourceInUseError(kind, name string, dependents ...string) *ResourceInUseError {
+	return &ResourceInUseError{Kind: kind, Name: name, Dependents: dependents}
+}
+
+func (e *ResourceInUseError) Error() string {
+	return fmt.Sprintf("%s %s is still in use by %s", e.Kind, e.Name, strings.Join(e.Dependents, ", "))
+}
+
+// IsResourceInUseError returns true if the error is a ResourceInUseError, looking through errors wrapped with context.
+func IsResourceInUseError(err error) bool {
+	_, ok := errors.Cause(err).(*ResourceInUseError)
+	return ok
+}
+
+// TerminalError is returned when reconciling a resource cannot succeed until its spec is changed, such as when the
+// VM size of a machine is not offered in the location of the cluster. Retrying it soon would fail again.
+type TerminalError struct {
+	err error
+}
+
+// NewTerminalError returns a TerminalError for the error.
+func NewTerminalError(err error) *TerminalError {
+	return &TerminalError{err: err}
+}
+
+func (e *TerminalError) Error() string {
+	return e.err.Error()
+}
+
+// terminalErrorCodes are the codes of the Azure API errors rejecting a request that cannot succeed until the spec it
+// was built from is changed. Other errors, even bad requests such as ReferencedResourceNotProvisioned or
+// InUseNetworkSecurityGroupCannotBeDeleted, go away on their own once the resources they refer to are ready or gone.
+var terminalErrorCodes = map[string]bool{
+	"InvalidParameter":         true,
+	"InvalidRequestContent":    true,
+	"InvalidResourceName":      true,
+	"PropertyChangeNotAllowed": true,
+	"SkuNotAvailable":          true,
+}
+
+// serviceErrorCode returns the code of the service error of an Azure API error or of a failed long-running operation,
+// looking through errors wrapped with context.
+func serviceErrorCode(err error) (string, bool) {
+	cause := errors.Cause(err)
+	if derr, ok := detailedError(err); ok {
+		cause = derr.Original
+	}
+	switch e := cause.(type) {
+	case *autorestazure.RequestError:
+		if e.ServiceError != nil {
+			return e.ServiceError.Code, true
+		}
+	case *autorestazure.ServiceError:
+		return e.Code, true
+	}
+	return "", false
+}
+
+// IsTerminalError returns true if the error is a TerminalError or an Azure API error whose code is known to reject the
+// request until its spec is changed, looking through errors wrapped with context.
+func IsTerminalError(err error) bool {
+	if _, ok := errors.Cause(err).(*TerminalError); ok {
 		return true
 	}
-	return false
+	code, ok := serviceErrorCode(err)
+	return ok && terminalErrorCodes[code]
 }
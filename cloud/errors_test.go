@@ -0,0 +1,122 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+)
+
+func TestResourceNotFound(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+	var tests = []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{
+			name:     "not found",
+			err:      notFound,
+			expected: true,
+		},
+		{
+			name:     "forbidden",
+			err:      autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 403}, "Forbidden"),
+			expected: false,
+		},
+		{
+			name:     "nil error",
+			err:      nil,
+			expected: false,
+		},
+		{
+			name:     "not found wrapped with context",
+			err:      errors.Wrap(notFound, "failed to delete vnet"),
+			expected: true,
+		},
+		{
+			name:     "not found as a pointer",
+			err:      &notFound,
+			expected: true,
+		},
+		{
+			name:     "status code only set on the response",
+			err:      autorest.DetailedError{Response: &http.Response{StatusCode: 404}},
+			expected: true,
+		},
+		{
+			name:     "not an Azure API error",
+			err:      errors.New("not found"),
+			expected: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g.Expect(ResourceNotFound(tc.err)).To(gomega.Equal(tc.expected))
+		})
+	}
+}
+
+func TestIsOperationNotDoneError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	notDone := NewOperationNotDoneError(&infrav1.Future{Type: infrav1.PutFuture, ResourceGroup: "my-rg", Name: "my-vm"})
+	g.Expect(notDone.Error()).To(gomega.Equal("operation type PUT on Azure resource my-rg/my-vm is not done"))
+	g.Expect(IsOperationNotDoneError(notDone)).To(gomega.BeTrue())
+	g.Expect(IsOperationNotDoneError(errors.Wrap(notDone, "failed to create vm"))).To(gomega.BeTrue())
+	g.Expect(IsOperationNotDoneError(errors.New("not done"))).To(gomega.BeFalse())
+	g.Expect(IsOperationNotDoneError(nil)).To(gomega.BeFalse())
+}
+
+func TestIsResourceInUseError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	inUse := NewResourceInUseError("subnet", "my-subnet", "nic-1/ipconfig1", "nic-2/ipconfig1")
+	g.Expect(inUse.Error()).To(gomega.Equal("subnet my-subnet is still in use by nic-1/ipconfig1, nic-2/ipconfig1"))
+	g.Expect(IsResourceInUseError(inUse)).To(gomega.BeTrue())
+	g.Expect(IsResourceInUseError(errors.Wrap(inUse, "failed to delete subnets"))).To(gomega.BeTrue())
+	g.Expect(IsResourceInUseError(errors.New("in use"))).To(gomega.BeFalse())
+	g.Expect(IsResourceInUseError(nil)).To(gomega.BeFalse())
+}
+
+func TestIsTerminalError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	terminal := NewTerminalError(errors.New("VM size Standard_D2s_v3 is not available in location westus"))
+	invalidParameter := autorest.NewErrorWithError(&autorestazure.RequestError{
+		ServiceError: &autorestazure.ServiceError{Code: "InvalidParameter"},
+	}, "", "", &http.Response{StatusCode: 400}, "Bad Request")
+	notProvisioned := autorest.NewErrorWithError(&autorestazure.RequestError{
+		ServiceError: &autorestazure.ServiceError{Code: "ReferencedResourceNotProvisioned"},
+	}, "", "", &http.Response{StatusCode: 400}, "Bad Request")
+	g.Expect(terminal.Error()).To(gomega.Equal("VM size Standard_D2s_v3 is not available in location westus"))
+	g.Expect(IsTerminalError(terminal)).To(gomega.BeTrue())
+	g.Expect(IsTerminalError(errors.Wrap(terminal, "failed to create AzureMachine VM"))).To(gomega.BeTrue())
+	g.Expect(IsTerminalError(invalidParameter)).To(gomega.BeTrue())
+	g.Expect(IsTerminalError(errors.Wrap(invalidParameter, "failed to create vnet"))).To(gomega.BeTrue())
+	g.Expect(IsTerminalError(errors.Wrap(&autorestazure.ServiceError{Code: "SkuNotAvailable"}, "failed to create vm"))).To(gomega.BeTrue())
+	g.Expect(IsTerminalError(notProvisioned)).To(gomega.BeFalse())
+	g.Expect(IsTerminalError(&autorestazure.ServiceError{Code: "InUseNetworkSecurityGroupCannotBeDeleted"})).To(gomega.BeFalse())
+	g.Expect(IsTerminalError(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 400}, "Bad Request"))).To(gomega.BeFalse())
+	g.Expect(IsTerminalError(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 429}, "Too Many Requests"))).To(gomega.BeFalse())
+	g.Expect(IsTerminalError(errors.New("invalid"))).To(gomega.BeFalse())
+	g.Expect(IsTerminalError(nil)).To(gomega.BeFalse())
+}
@@ -35,3 +35,16 @@ type GetterService interface {
 	Reconcile(ctx context.Context, spec interface{}) error
 	Delete(ctx context.Context, spec interface{}) error
 }
+
+// PowerService is implemented by the services of resources which can be deallocated to release their compute
+// resources, and started again, such as virtual machines.
+type PowerService interface {
+	Deallocate(ctx context.Context, spec interface{}) error
+	Start(ctx context.Context, spec interface{}) error
+}
+
+// ListerService is implemented by the services of resources which can list the resources owned by the cluster, such
+// as virtual machines.
+type ListerService interface {
+	List(ctx context.Context) (interface{}, error)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	apiCallDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "capz_azure_api_call_duration_seconds",
+			Help:    "Duration of the calls to the Azure API, including retries and the wait for long-running operations.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		},
+		[]string{"service", "operation"},
+	)
+	apiCallErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "capz_azure_api_call_errors_total",
+			Help: "Number of the calls to the Azure API that failed, by HTTP status code, or unknown for errors without one.",
+		},
+		[]string{"service", "operation", "code"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiCallDuration, apiCallErrors)
+}
+
+// CallAPI calls the operation of the Azure service with fn, retrying it with RetryOnTransientError and the
+// DefaultRetryBackoff, and records its duration and error in the metrics of the calls to the Azure API.
+func CallAPI(ctx context.Context, service, operation string, fn func() error) error {
+	return ObserveAPICall(service, operation, func() error {
+		return RetryOnTransientError(ctx, DefaultRetryBackoff, fn)
+	})
+}
+
+// CallLongRunningAPI calls the long-running operation of the Azure service with start, retrying only the initial
+// request with RetryOnTransientError and the DefaultRetryBackoff, then waits for the operation to complete with
+// wait. Retrying the wait would start the operation again. It records the duration and error of both in the metrics
+// of the calls to the Azure API.
+func CallLongRunningAPI(ctx context.Context, service, operation string, start, wait func() error) error {
+	return ObserveAPICall(service, operation, func() error {
+		if err := RetryOnTransientError(ctx, DefaultRetryBackoff, start); err != nil {
+			return err
+		}
+		return wait()
+	})
+}
+
+// ObserveAPICall calls the operation of the Azure service with fn, and records its duration and error in the
+// metrics of the calls to the Azure API.
+func ObserveAPICall(service, operation string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	apiCallDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		code := "unknown"
+		if c, ok := statusCode(err); ok {
+			code = strconv.Itoa(c)
+		}
+		apiCallErrors.WithLabelValues(service, operation, code).Inc()
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gatherMetric returns the metric of the family with the given name and labels in the registry, or an empty metric
+// if there is none.
+func gatherMetric(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) *dto.Metric {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metricHasLabels(metric, labels) {
+				return metric
+			}
+		}
+	}
+	return &dto.Metric{}
+}
+
+func metricHasLabels(metric *dto.Metric, labels map[string]string) bool {
+	matches := 0
+	for _, pair := range metric.GetLabel() {
+		if value, ok := labels[pair.GetName()]; ok && value == pair.GetValue() {
+			matches++
+		}
+	}
+	return matches == len(labels)
+}
+
+func TestObserveAPICall(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	registry := prometheus.NewRegistry()
+	g.Expect(registry.Register(apiCallDuration)).To(gomega.Succeed())
+	g.Expect(registry.Register(apiCallErrors)).To(gomega.Succeed())
+
+	errors := func() float64 {
+		labels := map[string]string{"service": "test", "operation": "Get", "code": "404"}
+		return gatherMetric(t, registry, "capz_azure_api_call_errors_total", labels).GetCounter().GetValue()
+	}
+	calls := func() uint64 {
+		labels := map[string]string{"service": "test", "operation": "Get"}
+		return gatherMetric(t, registry, "capz_azure_api_call_duration_seconds", labels).GetHistogram().GetSampleCount()
+	}
+	errorsBefore, callsBefore := errors(), calls()
+
+	err := ObserveAPICall("test", "Get", func() error {
+		return newResponseError(404, "")
+	})
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(errors()).To(gomega.Equal(errorsBefore + 1))
+	g.Expect(calls()).To(gomega.Equal(callsBefore + 1))
+
+	g.Expect(ObserveAPICall("test", "Get", func() error { return nil })).To(gomega.Succeed())
+	g.Expect(errors()).To(gomega.Equal(errorsBefore+1), "successful calls are not counted as errors")
+	g.Expect(calls()).To(gomega.Equal(callsBefore + 2))
+}
+
+func TestCallAPIRetries(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	throttled := apiCallErrors.WithLabelValues("test", "CreateOrUpdate", "429")
+	before := testutil.ToFloat64(throttled)
+
+	attempts := 0
+	err := CallAPI(context.Background(), "test", "CreateOrUpdate", func() error {
+		attempts++
+		if attempts == 1 {
+			return newResponseError(429, "0")
+		}
+		return nil
+	})
+	g.Expect(err).To(gomega.Succeed())
+	g.Expect(attempts).To(gomega.Equal(2))
+	g.Expect(testutil.ToFloat64(throttled)).To(gomega.Equal(before), "calls that succeed after a retry are not counted as errors")
+}
+
+func TestCallLongRunningAPIOnlyRetriesTheInitialRequest(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	failed := apiCallErrors.WithLabelValues("test", "Delete", "503")
+	before := testutil.ToFloat64(failed)
+
+	requests, waits := 0, 0
+	err := CallLongRunningAPI(context.Background(), "test", "Delete", func() error {
+		requests++
+		if requests == 1 {
+			return newResponseError(429, "0")
+		}
+		return nil
+	}, func() error {
+		waits++
+		return newResponseError(503, "0")
+	})
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(requests).To(gomega.Equal(2))
+	g.Expect(waits).To(gomega.Equal(1), "a failure while waiting does not start the operation again")
+	g.Expect(testutil.ToFloat64(failed)).To(gomega.Equal(before+1), "failures while waiting are counted as errors")
+}
@@ -15,7 +15,7 @@ limitations under the License.
 */
 
 // Code generated by MockGen. DO NOT EDIT.
-// Source: sigs.k8s.io/cluster-api-provider-azure/cloud (interfaces: Service,GetterService)
+// Source: sigs.k8s.io/cluster-api-provider-azure/cloud (interfaces: Service,GetterService,PowerService,ListerService)
 
 // Package mocks is a generated GoMock package.
 package mocks
@@ -143,3 +143,92 @@ func (mr *MockGetterServiceMockRecorder) Reconcile(arg0, arg1 interface{}) *gomo
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconcile", reflect.TypeOf((*MockGetterService)(nil).Reconcile), arg0, arg1)
 }
+
+// MockPowerService is a mock of PowerService interface
+type MockPowerService struct {
+	ctrl     *gomock.Controller
+	recorder *MockPowerServiceMockRecorder
+}
+
+// MockPowerServiceMockRecorder is the mock recorder for MockPowerService
+type MockPowerServiceMockRecorder struct {
+	mock *MockPowerService
+}
+
+// NewMockPowerService creates a new mock instance
+func NewMockPowerService(ctrl *gomock.Controller) *MockPowerService {
+	mock := &MockPowerService{ctrl: ctrl}
+	mock.recorder = &MockPowerServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockPowerService) EXPECT() *MockPowerServiceMockRecorder {
+	return m.recorder
+}
+
+// Deallocate mocks base method
+func (m *MockPowerService) Deallocate(arg0 context.Context, arg1 interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Deallocate", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Deallocate indicates an expected call of Deallocate
+func (mr *MockPowerServiceMockRecorder) Deallocate(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deallocate", reflect.TypeOf((*MockPowerService)(nil).Deallocate), arg0, arg1)
+}
+
+// Start mocks base method
+func (m *MockPowerService) Start(arg0 context.Context, arg1 interface{}) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Start", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Start indicates an expected call of Start
+func (mr *MockPowerServiceMockRecorder) Start(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Start", reflect.TypeOf((*MockPowerService)(nil).Start), arg0, arg1)
+}
+
+// MockListerService is a mock of ListerService interface
+type MockListerService struct {
+	ctrl     *gomock.Controller
+	recorder *MockListerServiceMockRecorder
+}
+
+// MockListerServiceMockRecorder is the mock recorder for MockListerService
+type MockListerServiceMockRecorder struct {
+	mock *MockListerService
+}
+
+// NewMockListerService creates a new mock instance
+func NewMockListerService(ctrl *gomock.Controller) *MockListerService {
+	mock := &MockListerService{ctrl: ctrl}
+	mock.recorder = &MockListerServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockListerService) EXPECT() *MockListerServiceMockRecorder {
+	return m.recorder
+}
+
+// List mocks base method
+func (m *MockListerService) List(arg0 context.Context) (interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", arg0)
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (mr *MockListerServiceMockRecorder) List(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockListerService)(nil).List), arg0)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultQPS is the default rate of the requests to the Azure API of a subscription, in requests per second.
+	DefaultQPS = 10
+	// DefaultBurst is the default number of requests to the Azure API of a subscription sent without waiting for
+	// the rate.
+	DefaultBurst = 100
+)
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*RateLimiter)
+)
+
+// RateLimiter limits the rate of the requests to the Azure API. Requests beyond its burst are delayed until the
+// rate allows them rather than failed.
+type RateLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewRateLimiter returns a rate limiter allowing qps requests per second, with bursts of up to burst requests.
+func NewRateLimiter(qps float32, burst int) *RateLimiter {
+	return &RateLimiter{limiter: rate.NewLimiter(rate.Limit(qps), burst)}
+}
+
+// SubscriptionRateLimiter returns the rate limiter shared by all the clients of the subscription, so that they
+// stay under the throttling limits of Azure together. The QPS and the burst of the first call for a subscription
+// are used, or DefaultQPS and DefaultBurst if they are not positive.
+func SubscriptionRateLimiter(subscriptionID string, qps float32, burst int) *RateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	if limiter, ok := rateLimiters[subscriptionID]; ok {
+		return limiter
+	}
+	if qps <= 0 {
+		qps = DefaultQPS
+	}
+	if burst <= 0 {
+		burst = DefaultBurst
+	}
+	limiter := NewRateLimiter(qps, burst)
+	rateLimiters[subscriptionID] = limiter
+	return limiter
+}
+
+// Wait blocks until the rate limiter allows a request, or returns an error if the context is done first.
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	return errors.Wrap(r.limiter.Wait(ctx), "failed to wait for the Azure API rate limiter")
+}
+
+// WithRateLimit returns an authorizer that waits for the rate limiter before authorizing each request with the
+// given authorizer. Every request of the clients using the returned authorizer is then rate limited, including the
+// polling of long-running operations, since the clients authorize each request right before sending it.
+func WithRateLimit(authorizer autorest.Authorizer, limiter *RateLimiter) autorest.Authorizer {
+	return rateLimitedAuthorizer{authorizer: authorizer, limiter: limiter}
+}
+
+type rateLimitedAuthorizer struct {
+	authorizer autorest.Authorizer
+	limiter    *RateLimiter
+}
+
+// WithAuthorization implements autorest.Authorizer.
+func (a rateLimitedAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		authorized := a.authorizer.WithAuthorization()(p)
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			if err := a.limiter.Wait(r.Context()); err != nil {
+				return r, err
+			}
+			return authorized.Prepare(r)
+		})
+	}
+}
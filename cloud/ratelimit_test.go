@@ -0,0 +1,88 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/onsi/gomega"
+)
+
+func TestRateLimiterDelaysRequestsBeyondBurst(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	limiter := NewRateLimiter(10, 2)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		g.Expect(limiter.Wait(context.Background())).To(gomega.Succeed())
+	}
+	g.Expect(time.Since(start)).To(gomega.BeNumerically("<", 50*time.Millisecond), "requests within the burst are not delayed")
+
+	start = time.Now()
+	g.Expect(limiter.Wait(context.Background())).To(gomega.Succeed())
+	g.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 50*time.Millisecond), "requests beyond the burst are delayed")
+}
+
+func TestRateLimiterCancelledContext(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	limiter := NewRateLimiter(0.1, 1)
+	g.Expect(limiter.Wait(context.Background())).To(gomega.Succeed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- limiter.Wait(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		g.Expect(err).To(gomega.MatchError("failed to wait for the Azure API rate limiter: context canceled"))
+	case <-time.After(time.Second):
+		t.Fatal("expected the wait to be aborted by the cancelled context")
+	}
+}
+
+func TestSubscriptionRateLimiter(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	limiter := SubscriptionRateLimiter("rate-limit-test-subscription", 1, 1)
+	g.Expect(SubscriptionRateLimiter("rate-limit-test-subscription", 5, 5)).To(gomega.BeIdenticalTo(limiter))
+	g.Expect(SubscriptionRateLimiter("rate-limit-test-other-subscription", 1, 1)).NotTo(gomega.BeIdenticalTo(limiter))
+}
+
+func TestWithRateLimit(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	authorizer := WithRateLimit(autorest.NewAPIKeyAuthorizerWithHeaders(map[string]interface{}{"X-Test": "authorized"}), NewRateLimiter(0.1, 1))
+
+	req, err := http.NewRequest(http.MethodGet, "https://management.azure.com/", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	req, err = autorest.Prepare(req, authorizer.WithAuthorization())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(req.Header.Get("X-Test")).To(gomega.Equal("authorized"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err = http.NewRequest(http.MethodGet, "https://management.azure.com/", nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	_, err = autorest.Prepare(req.WithContext(ctx), authorizer.WithAuthorization())
+	g.Expect(err).To(gomega.MatchError("failed to wait for the Azure API rate limiter: context canceled"))
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultRetryBackoff is the backoff used between attempts of an Azure API call that failed with a transient error.
+// Steps is the maximum number of attempts.
+var DefaultRetryBackoff = wait.Backoff{
+	Duration: 2 * time.Second,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    5,
+	Cap:      time.Minute,
+}
+
+// IsTransientError returns true if the error is an Azure API error that is expected to succeed if retried, i.e. the
+// request was throttled or the service returned a server error.
+func IsTransientError(err error) bool {
+	code, ok := statusCode(err)
+	if !ok {
+		return false
+	}
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of the response of an Azure API error.
+// The header may hold either a number of seconds or an HTTP date. The returned bool is false if the header is
+// absent or invalid.
+func RetryAfter(err error) (time.Duration, bool) {
+	derr, ok := errors.Cause(err).(autorest.DetailedError)
+	if !ok || derr.Response == nil {
+		return 0, false
+	}
+	header := derr.Response.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// RetryOnTransientError calls fn until it succeeds, fails with an error that is not transient, or the attempts
+// allowed by the backoff are exhausted. Between attempts it waits for the duration requested by the Retry-After
+// header when present, and for the next step of the backoff otherwise, never longer than the cap of the backoff.
+// Errors that are not transient are returned as is so that callers can still inspect them, e.g. with
+// ResourceNotFound. fn should only send a single request: a long-running operation must be polled outside of it,
+// otherwise a failure while polling would start the operation again.
+func RetryOnTransientError(ctx context.Context, backoff wait.Backoff, fn func() error) error {
+	attempts := backoff.Steps
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !IsTransientError(err) {
+			return err
+		}
+		if attempt >= attempts {
+			return errors.Wrapf(err, "giving up after %d attempts", attempt)
+		}
+		delay, ok := RetryAfter(err)
+		if !ok {
+			delay = backoff.Step()
+		}
+		if backoff.Cap > 0 && delay > backoff.Cap {
+			delay = backoff.Cap
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(err, "giving up after %d attempts: %v", attempt, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
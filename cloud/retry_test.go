@@ -0,0 +1,176 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newResponseError(statusCode int, retryAfter string) error {
+	resp := &http.Response{StatusCode: statusCode, Header: http.Header{}}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return autorest.NewErrorWithResponse("", "", resp, http.StatusText(statusCode))
+}
+
+func TestRetryOnTransientError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3, Cap: 10 * time.Millisecond}
+	var tests = []struct {
+		name             string
+		responses        []error
+		expectedAttempts int
+		expectedError    string
+	}{
+		{
+			name:             "succeeds after being throttled twice",
+			responses:        []error{newResponseError(429, "0"), newResponseError(429, "0"), nil},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "succeeds after a server error without Retry-After",
+			responses:        []error{newResponseError(503, ""), nil},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "waits no longer than the cap of the backoff",
+			responses:        []error{newResponseError(429, "3600"), nil},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "does not retry errors that are not transient",
+			responses:        []error{newResponseError(404, ""), nil},
+			expectedAttempts: 1,
+			expectedError:    "#: Not Found: StatusCode=404",
+		},
+		{
+			name:             "gives up after the maximum number of attempts",
+			responses:        []error{newResponseError(500, ""), newResponseError(500, ""), newResponseError(500, ""), nil},
+			expectedAttempts: 3,
+			expectedError:    "giving up after 3 attempts: #: Internal Server Error: StatusCode=500",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			err := RetryOnTransientError(context.TODO(), backoff, func() error {
+				err := tc.responses[attempts]
+				attempts++
+				return err
+			})
+			if tc.expectedError != "" {
+				g.Expect(err).To(gomega.MatchError(tc.expectedError))
+			} else {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+			}
+			g.Expect(attempts).To(gomega.Equal(tc.expectedAttempts))
+		})
+	}
+}
+
+func TestRetryOnTransientErrorKeepsErrorType(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	err := RetryOnTransientError(context.TODO(), DefaultRetryBackoff, func() error {
+		return newResponseError(404, "")
+	})
+	g.Expect(ResourceNotFound(err)).To(gomega.BeTrue())
+}
+
+func TestRetryOnTransientErrorStopsWhenContextIsDone(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	attempts := 0
+	err := RetryOnTransientError(ctx, DefaultRetryBackoff, func() error {
+		attempts++
+		return newResponseError(429, "60")
+	})
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(strings.HasPrefix(err.Error(), "giving up after 1 attempts: context canceled")).To(gomega.BeTrue())
+	g.Expect(attempts).To(gomega.Equal(1))
+}
+
+func TestRetryAfter(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	var tests = []struct {
+		name          string
+		err           error
+		expectedDelay time.Duration
+		expectedOk    bool
+	}{
+		{
+			name:          "delay in seconds",
+			err:           newResponseError(429, "5"),
+			expectedDelay: 5 * time.Second,
+			expectedOk:    true,
+		},
+		{
+			name:          "date in the past",
+			err:           newResponseError(503, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)),
+			expectedDelay: 0,
+			expectedOk:    true,
+		},
+		{
+			name:       "no header",
+			err:        newResponseError(429, ""),
+			expectedOk: false,
+		},
+		{
+			name:       "invalid header",
+			err:        newResponseError(429, "soon"),
+			expectedOk: false,
+		},
+		{
+			name:          "wrapped error",
+			err:           errors.Wrap(newResponseError(429, "1"), "failed to get vnet"),
+			expectedDelay: time.Second,
+			expectedOk:    true,
+		},
+		{
+			name:       "not an Azure API error",
+			err:        errors.New("boom"),
+			expectedOk: false,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			delay, ok := RetryAfter(tc.err)
+			g.Expect(ok).To(gomega.Equal(tc.expectedOk))
+			g.Expect(delay).To(gomega.Equal(tc.expectedDelay))
+		})
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	g.Expect(IsTransientError(newResponseError(429, ""))).To(gomega.BeTrue())
+	g.Expect(IsTransientError(newResponseError(500, ""))).To(gomega.BeTrue())
+	g.Expect(IsTransientError(newResponseError(503, ""))).To(gomega.BeTrue())
+	g.Expect(IsTransientError(newResponseError(400, ""))).To(gomega.BeFalse())
+	g.Expect(IsTransientError(newResponseError(404, ""))).To(gomega.BeFalse())
+	g.Expect(IsTransientError(errors.New("boom"))).To(gomega.BeFalse())
+}
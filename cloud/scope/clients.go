@@ -20,17 +20,52 @@ import (
 	"os"
 
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	cloud "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// The keys of the tenant ID, the client ID and the client secret of a service principal in an identity secret.
+const (
+	TenantIDKey     = "tenantID"
+	ClientIDKey     = "clientID"
+	ClientSecretKey = "clientSecret"
 )
 
 // AzureClients contains all the Azure clients used by the scopes.
 type AzureClients struct {
 	SubscriptionID string
-	Authorizer     autorest.Authorizer
+	// Environment is the name of the Azure cloud environment, one of AzurePublicCloud, AzureUSGovernmentCloud,
+	// AzureChinaCloud or AzureGermanCloud. It defaults to the AZURE_ENVIRONMENT environment variable, or to
+	// AzurePublicCloud if that is not set.
+	Environment string
+	// ResourceManagerEndpoint is the base URI of the Azure Resource Manager of the environment.
+	ResourceManagerEndpoint string
+	// QPS is the rate of the requests to the Azure API shared by all the clients of the subscription, in requests
+	// per second. Defaults to azure.DefaultQPS.
+	QPS float32
+	// Burst is the number of requests to the Azure API of the subscription sent without waiting for the rate.
+	// Defaults to azure.DefaultBurst.
+	Burst      int
+	Authorizer autorest.Authorizer
 }
 
-func (c *AzureClients) setCredentials() error {
+// setCredentials sets the subscription ID, the environment and the authorizer that are not set yet, and rate limits
+// the authorizer with the rate limiter of the subscription. The authorizer uses the managed identity of the virtual
+// machine for a ManagedIdentity identity, the client credentials of the identity secret if it is not nil, or the
+// environment variables otherwise.
+func (c *AzureClients) setCredentials(identity *infrav1.ClusterIdentity, secret *corev1.Secret) error {
+	if err := c.setAuthorizer(identity, secret); err != nil {
+		return err
+	}
+	c.Authorizer = cloud.WithRateLimit(c.Authorizer, cloud.SubscriptionRateLimiter(c.SubscriptionID, c.QPS, c.Burst))
+	return nil
+}
+
+func (c *AzureClients) setAuthorizer(identity *infrav1.ClusterIdentity, secret *corev1.Secret) error {
 	if c.SubscriptionID == "" {
 		subID, err := getSubscriptionID()
 		if err != nil {
@@ -38,8 +73,20 @@ func (c *AzureClients) setCredentials() error {
 		}
 		c.SubscriptionID = subID
 	}
+	settings, err := getSettings(c.Environment)
+	if err != nil {
+		return err
+	}
+	c.Environment = settings.Environment.Name
+	c.ResourceManagerEndpoint = settings.Environment.ResourceManagerEndpoint
 	if c.Authorizer == nil {
-		auth, err := getAuthorizer()
+		if identity != nil && identity.Type == infrav1.ManagedIdentity {
+			return c.setAuthorizerFromMSI(identity.ClientID, settings.Environment)
+		}
+		if secret != nil {
+			return c.setAuthorizerFromSecret(secret, settings.Environment)
+		}
+		auth, err := settings.GetAuthorizer()
 		if err != nil {
 			return err
 		}
@@ -48,6 +95,58 @@ func (c *AzureClients) setCredentials() error {
 	return nil
 }
 
+// setAuthorizerFromSecret sets an authorizer using the client credentials flow with the service principal of the
+// identity secret.
+func (c *AzureClients) setAuthorizerFromSecret(identity *corev1.Secret, environment azure.Environment) error {
+	config, err := getClientCredentialsConfig(identity, environment)
+	if err != nil {
+		return err
+	}
+	auth, err := config.Authorizer()
+	if err != nil {
+		return errors.Wrapf(err, "failed to create authorizer from identity secret %s/%s", identity.Namespace, identity.Name)
+	}
+	c.Authorizer = auth
+	return nil
+}
+
+// setAuthorizerFromMSI sets an authorizer using the managed identity of the virtual machine, the user-assigned one
+// with the given client ID if it is not empty.
+func (c *AzureClients) setAuthorizerFromMSI(clientID string, environment azure.Environment) error {
+	auth, err := getMSIConfig(clientID, environment).Authorizer()
+	if err != nil {
+		return errors.Wrap(err, "failed to create authorizer from managed identity")
+	}
+	c.Authorizer = auth
+	return nil
+}
+
+// getMSIConfig returns the config of the managed identity with the given client ID, or of the system-assigned one
+// if it is empty, for the resource manager of the environment.
+func getMSIConfig(clientID string, environment azure.Environment) auth.MSIConfig {
+	config := auth.NewMSIConfig()
+	config.ClientID = clientID
+	config.Resource = environment.ResourceManagerEndpoint
+	return config
+}
+
+// getClientCredentialsConfig returns the client credentials config of the service principal of the identity secret,
+// for the Azure Active Directory and the resource manager of the environment.
+func getClientCredentialsConfig(identity *corev1.Secret, environment azure.Environment) (auth.ClientCredentialsConfig, error) {
+	values := make(map[string]string)
+	for _, key := range []string{TenantIDKey, ClientIDKey, ClientSecretKey} {
+		value := string(identity.Data[key])
+		if value == "" {
+			return auth.ClientCredentialsConfig{}, errors.Errorf("identity secret %s/%s has no %s", identity.Namespace, identity.Name, key)
+		}
+		values[key] = value
+	}
+	config := auth.NewClientCredentialsConfig(values[ClientIDKey], values[ClientSecretKey], values[TenantIDKey])
+	config.AADEndpoint = environment.ActiveDirectoryEndpoint
+	config.Resource = environment.ResourceManagerEndpoint
+	return config, nil
+}
+
 func getSubscriptionID() (string, error) {
 	subscriptionID := os.Getenv("AZURE_SUBSCRIPTION_ID")
 	if subscriptionID == "" {
@@ -56,6 +155,21 @@ func getSubscriptionID() (string, error) {
 	return subscriptionID, nil
 }
 
-func getAuthorizer() (autorest.Authorizer, error) {
-	return auth.NewAuthorizerFromEnvironment()
+// getSettings returns the authentication settings from the environment variables, for the Azure cloud environment
+// with the given name if it is not empty.
+func getSettings(environment string) (auth.EnvironmentSettings, error) {
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		return settings, err
+	}
+	if environment == "" {
+		return settings, nil
+	}
+	settings.Environment, err = azure.EnvironmentFromName(environment)
+	if err != nil {
+		return settings, errors.Wrapf(err, "invalid Azure cloud environment %s", environment)
+	}
+	// The tokens are issued for the resource manager of the selected environment, not of AZURE_ENVIRONMENT.
+	settings.Values[auth.Resource] = settings.Environment.ResourceManagerEndpoint
+	return settings, nil
 }
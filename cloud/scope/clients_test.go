@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetCredentialsEnvironment(t *testing.T) {
+	testcases := []struct {
+		name                            string
+		environment                     string
+		expectedResourceManagerEndpoint string
+		expectedError                   string
+	}{
+		{
+			name:                            "public cloud",
+			environment:                     "AzurePublicCloud",
+			expectedResourceManagerEndpoint: "https://management.azure.com/",
+		},
+		{
+			name:                            "government cloud",
+			environment:                     "AzureUSGovernmentCloud",
+			expectedResourceManagerEndpoint: "https://management.usgovcloudapi.net/",
+		},
+		{
+			name:                            "china cloud",
+			environment:                     "AzureChinaCloud",
+			expectedResourceManagerEndpoint: "https://management.chinacloudapi.cn/",
+		},
+		{
+			name:          "unknown cloud",
+			environment:   "AzureMoonCloud",
+			expectedError: `invalid Azure cloud environment AzureMoonCloud: autorest/azure: There is no cloud environment matching the name "AZUREMOONCLOUD"`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &AzureClients{
+				SubscriptionID: "123",
+				Environment:    tc.environment,
+				Authorizer:     autorest.NullAuthorizer{},
+			}
+			err := c.setCredentials(nil, nil)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if c.ResourceManagerEndpoint != tc.expectedResourceManagerEndpoint {
+				t.Errorf("expected resource manager endpoint %s, got %s", tc.expectedResourceManagerEndpoint, c.ResourceManagerEndpoint)
+			}
+			if c.Environment != tc.environment {
+				t.Errorf("expected environment %s, got %s", tc.environment, c.Environment)
+			}
+		})
+	}
+}
+
+func TestGetClientCredentialsConfig(t *testing.T) {
+	testcases := []struct {
+		name          string
+		data          map[string][]byte
+		expectedError string
+	}{
+		{
+			name: "well-formed identity secret",
+			data: map[string][]byte{
+				"tenantID":     []byte("my-tenant"),
+				"clientID":     []byte("my-client"),
+				"clientSecret": []byte("my-secret"),
+			},
+		},
+		{
+			name: "identity secret without a tenant ID",
+			data: map[string][]byte{
+				"clientID":     []byte("my-client"),
+				"clientSecret": []byte("my-secret"),
+			},
+			expectedError: "identity secret my-namespace/my-identity has no tenantID",
+		},
+		{
+			name: "identity secret with an empty client secret",
+			data: map[string][]byte{
+				"tenantID":     []byte("my-tenant"),
+				"clientID":     []byte("my-client"),
+				"clientSecret": []byte(""),
+			},
+			expectedError: "identity secret my-namespace/my-identity has no clientSecret",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			identity := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-identity", Namespace: "my-namespace"},
+				Data:       tc.data,
+			}
+			config, err := getClientCredentialsConfig(identity, azure.USGovernmentCloud)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			expected := auth.ClientCredentialsConfig{
+				TenantID:     "my-tenant",
+				ClientID:     "my-client",
+				ClientSecret: "my-secret",
+				AADEndpoint:  azure.USGovernmentCloud.ActiveDirectoryEndpoint,
+				Resource:     azure.USGovernmentCloud.ResourceManagerEndpoint,
+			}
+			if !reflect.DeepEqual(config, expected) {
+				t.Errorf("expected client credentials config %+v, got %+v", expected, config)
+			}
+			if _, err := config.Authorizer(); err != nil {
+				t.Errorf("failed to create authorizer: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetMSIConfig(t *testing.T) {
+	testcases := []struct {
+		name     string
+		clientID string
+	}{
+		{
+			name: "system-assigned managed identity",
+		},
+		{
+			name:     "user-assigned managed identity",
+			clientID: "my-client",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := getMSIConfig(tc.clientID, azure.USGovernmentCloud)
+			expected := auth.MSIConfig{
+				ClientID: tc.clientID,
+				Resource: azure.USGovernmentCloud.ResourceManagerEndpoint,
+			}
+			if !reflect.DeepEqual(config, expected) {
+				t.Errorf("expected managed identity config %+v, got %+v", expected, config)
+			}
+			if _, err := config.Authorizer(); err != nil {
+				t.Errorf("failed to create authorizer: %v", err)
+			}
+		})
+	}
+}
@@ -18,11 +18,16 @@ package scope
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/klogr"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 	"sigs.k8s.io/cluster-api/util/patch"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -36,6 +41,9 @@ type ClusterScopeParams struct {
 	Cluster      *clusterv1.Cluster
 	AzureCluster *infrav1.AzureCluster
 	Context      context.Context
+	// DryRun makes the services compute and report the changes they would make to the Azure resources of the
+	// cluster, without making them.
+	DryRun bool
 }
 
 // NewClusterScope creates a new Scope from the supplied parameters.
@@ -49,10 +57,23 @@ func NewClusterScope(params ClusterScopeParams) (*ClusterScope, error) {
 	}
 
 	if params.Logger == nil {
-		params.Logger = klogr.New()
+		params.Logger = klogr.New().WithValues("cluster", params.Cluster.Name)
 	}
 
-	err := params.AzureClients.setCredentials()
+	identity := params.AzureCluster.Spec.Identity
+	var secret *corev1.Secret
+	if identity != nil && identity.Type != infrav1.ManagedIdentity && params.Authorizer == nil {
+		if identity.SecretRef == nil {
+			return nil, errors.Errorf("service principal identity of AzureCluster %s/%s has no secret", params.AzureCluster.Namespace, params.AzureCluster.Name)
+		}
+		secret = &corev1.Secret{}
+		key := client.ObjectKey{Namespace: params.AzureCluster.Namespace, Name: identity.SecretRef.Name}
+		if err := params.Client.Get(context.TODO(), key, secret); err != nil {
+			return nil, errors.Wrapf(err, "failed to get identity secret %s", key)
+		}
+	}
+
+	err := params.AzureClients.setCredentials(identity, secret)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create Azure session")
 	}
@@ -68,6 +89,7 @@ func NewClusterScope(params ClusterScopeParams) (*ClusterScope, error) {
 		Cluster:      params.Cluster,
 		AzureCluster: params.AzureCluster,
 		patchHelper:  helper,
+		dryRun:       params.DryRun,
 		Context:      context.Background(),
 	}, nil
 }
@@ -82,6 +104,9 @@ type ClusterScope struct {
 	Cluster      *clusterv1.Cluster
 	AzureCluster *infrav1.AzureCluster
 	Context      context.Context
+
+	dryRun bool
+	plan   plan
 }
 
 // Network returns the cluster network object.
@@ -99,6 +124,16 @@ func (s *ClusterScope) Subnets() infrav1.Subnets {
 	return s.AzureCluster.Spec.NetworkSpec.Subnets
 }
 
+// Subnet returns the cluster subnet with the given name, or nil if there is none.
+func (s *ClusterScope) Subnet(name string) *infrav1.SubnetSpec {
+	for _, sn := range s.AzureCluster.Spec.NetworkSpec.Subnets {
+		if sn.Name == name {
+			return sn
+		}
+	}
+	return nil
+}
+
 // ControlPlaneSubnet returns the cluster control plane subnet.
 func (s *ClusterScope) ControlPlaneSubnet() *infrav1.SubnetSpec {
 	for _, sn := range s.AzureCluster.Spec.NetworkSpec.Subnets {
@@ -140,6 +175,12 @@ func (s *ClusterScope) Name() string {
 	return s.Cluster.Name
 }
 
+// ResourceLogger returns the logger of the scope with the resource group and the name of an Azure resource.
+// The logger of the scope already has the cluster.
+func (s *ClusterScope) ResourceLogger(name string) logr.Logger {
+	return s.WithValues("resourceGroup", s.ResourceGroup(), "name", name)
+}
+
 // Namespace returns the cluster namespace.
 func (s *ClusterScope) Namespace() string {
 	return s.Cluster.Namespace
@@ -150,6 +191,137 @@ func (s *ClusterScope) Location() string {
 	return s.AzureCluster.Spec.Location
 }
 
+// VnetName returns the name of the vnet created for the cluster.
+func (s *ClusterScope) VnetName() string {
+	return azure.GenerateVnetName(s.Name())
+}
+
+// ControlPlaneSubnetName returns the name of the control plane subnet created for the cluster.
+func (s *ClusterScope) ControlPlaneSubnetName() string {
+	return azure.GenerateControlPlaneSubnetName(s.Name())
+}
+
+// NodeSubnetName returns the name of the node subnet created for the cluster.
+func (s *ClusterScope) NodeSubnetName() string {
+	return azure.GenerateNodeSubnetName(s.Name())
+}
+
+// ControlPlaneSecurityGroupName returns the name of the control plane security group created for the cluster.
+func (s *ClusterScope) ControlPlaneSecurityGroupName() string {
+	return azure.GenerateControlPlaneSecurityGroupName(s.Name())
+}
+
+// NodeSecurityGroupName returns the name of the node security group created for the cluster.
+func (s *ClusterScope) NodeSecurityGroupName() string {
+	return azure.GenerateNodeSecurityGroupName(s.Name())
+}
+
+// SharedSecurityGroupName returns the name of the security group shared by the control plane and node subnets, the
+// one configured in the network spec if any and a name generated from the cluster otherwise. It is empty if the
+// subnets do not share a security group.
+func (s *ClusterScope) SharedSecurityGroupName() string {
+	sharedSG := s.AzureCluster.Spec.NetworkSpec.SharedSecurityGroup
+	if sharedSG == nil {
+		return ""
+	}
+	if sharedSG.Name != "" {
+		return sharedSG.Name
+	}
+	return azure.GenerateSharedSecurityGroupName(s.Name())
+}
+
+// NodeRouteTableName returns the name of the node route table created for the cluster.
+func (s *ClusterScope) NodeRouteTableName() string {
+	return azure.GenerateNodeRouteTableName(s.Name())
+}
+
+// NodeNatGatewayName returns the name of the node NAT gateway created for the cluster.
+func (s *ClusterScope) NodeNatGatewayName() string {
+	return azure.GenerateNodeNatGatewayName(s.Name())
+}
+
+// VPNGatewayName returns the name of the VPN gateway created for the cluster.
+func (s *ClusterScope) VPNGatewayName() string {
+	return azure.GenerateVPNGatewayName(s.Name())
+}
+
+// VPNGatewayIPName returns the name of the public IP of the VPN gateway.
+func (s *ClusterScope) VPNGatewayIPName() string {
+	return azure.GenerateVPNGatewayIPName(s.VPNGatewayName())
+}
+
+// InternalLBName returns the name of the internal load balancer created for the cluster.
+func (s *ClusterScope) InternalLBName() string {
+	return azure.GenerateInternalLBName(s.Name())
+}
+
+// PublicLBName returns the name of the public load balancer created for the cluster.
+func (s *ClusterScope) PublicLBName() string {
+	return azure.GeneratePublicLBName(s.Name())
+}
+
+// PrivateLinkServiceName returns the name of the private link service of the internal load balancer.
+func (s *ClusterScope) PrivateLinkServiceName() string {
+	return azure.GeneratePrivateLinkServiceName(s.Name())
+}
+
+// DdosProtectionPlanName returns the name of the DDoS protection plan created for the cluster.
+func (s *ClusterScope) DdosProtectionPlanName() string {
+	return azure.GenerateDdosProtectionPlanName(s.Name())
+}
+
+// PrivateEndpointName returns the name of the private endpoint of the API server.
+func (s *ClusterScope) PrivateEndpointName() string {
+	return azure.GeneratePrivateEndpointName(s.Name())
+}
+
+// ControlPlaneAvailabilitySetName returns the name of the availability set of the control plane machines.
+func (s *ClusterScope) ControlPlaneAvailabilitySetName() string {
+	return azure.GenerateControlPlaneAvailabilitySetName(s.Name())
+}
+
+// ProximityPlacementGroupName returns the name of the proximity placement group of the cluster.
+func (s *ClusterScope) ProximityPlacementGroupName() string {
+	return azure.GenerateProximityPlacementGroupName(s.Name())
+}
+
+// ProximityPlacementGroupScope returns the machines placed in the proximity placement group of the cluster, None by
+// default.
+func (s *ClusterScope) ProximityPlacementGroupScope() infrav1.ProximityPlacementGroupScope {
+	if s.AzureCluster.Spec.ProximityPlacementGroup == "" {
+		return infrav1.ProximityPlacementGroupNone
+	}
+	return s.AzureCluster.Spec.ProximityPlacementGroup
+}
+
+// APIServerPublicIPName returns the name of the public IP of the API server, the one configured in the load balancer
+// spec if any. The name of a public IP created for the cluster otherwise includes a hash of the subscription, resource
+// group and cluster so that its DNS label is unique within the location.
+func (s *ClusterScope) APIServerPublicIPName() string {
+	if publicIP := s.AzureCluster.Spec.NetworkSpec.APIServerLB.PublicIP; publicIP != nil && publicIP.Name != "" {
+		return publicIP.Name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%s/%s/%s", s.SubscriptionID, s.ResourceGroup(), s.Name())))
+	return azure.GeneratePublicIPName(s.Name(), fmt.Sprintf("%x", h.Sum32()))
+}
+
+// IsAPIServerPublicIPExternallyManaged returns true if the public IP of the API server is managed outside of the
+// cluster, so it must be neither updated nor deleted.
+func (s *ClusterScope) IsAPIServerPublicIPExternallyManaged() bool {
+	publicIP := s.AzureCluster.Spec.NetworkSpec.APIServerLB.PublicIP
+	return publicIP != nil && publicIP.ExternallyManaged
+}
+
+// APIServerDNSLabel returns the DNS name label of the public IP of the API server, the one configured in the load
+// balancer spec if any and the lowercase name of the public IP otherwise.
+func (s *ClusterScope) APIServerDNSLabel() string {
+	if s.AzureCluster.Spec.NetworkSpec.APIServerLB.DNSLabel != "" {
+		return s.AzureCluster.Spec.NetworkSpec.APIServerLB.DNSLabel
+	}
+	return strings.ToLower(s.Network().APIServerIP.Name)
+}
+
 // ListOptionsLabelSelector returns a ListOptions with a label selector for clusterName.
 func (s *ClusterScope) ListOptionsLabelSelector() client.ListOption {
 	return client.MatchingLabels(map[string]string{
@@ -157,11 +329,45 @@ func (s *ClusterScope) ListOptionsLabelSelector() client.ListOption {
 	})
 }
 
-// Close closes the current scope persisting the cluster configuration and status.
+// ListMachines returns the Machines of the cluster, including the ones being deleted.
+func (s *ClusterScope) ListMachines() ([]clusterv1.Machine, error) {
+	machines := &clusterv1.MachineList{}
+	if err := s.client.List(s.Context, machines, client.InNamespace(s.Cluster.Namespace), s.ListOptionsLabelSelector()); err != nil {
+		return nil, errors.Wrapf(err, "failed to list machines of cluster %s", s.Cluster.Name)
+	}
+	return machines.Items, nil
+}
+
+// Close closes the current scope persisting the cluster configuration and status. Nothing is persisted in dry-run
+// mode, where the status would describe resources which were not changed.
 func (s *ClusterScope) Close() error {
+	if s.dryRun {
+		return nil
+	}
 	return s.patchHelper.Patch(context.TODO(), s.AzureCluster)
 }
 
+// IsDryRun returns true if the services only report the changes they would make to the Azure resources.
+func (s *ClusterScope) IsDryRun() bool {
+	return s.dryRun
+}
+
+// DryRun returns true in dry-run mode, in which case the service must skip the action. The action is then logged
+// and recorded in the planned actions of the scope.
+func (s *ClusterScope) DryRun(action Action) bool {
+	if !s.dryRun {
+		return false
+	}
+	s.V(2).Info("skipping action in dry-run mode", "action", action.String())
+	s.plan.add(action)
+	return true
+}
+
+// PlannedActions returns the actions the services skipped in dry-run mode, in the order they were skipped.
+func (s *ClusterScope) PlannedActions() []Action {
+	return s.plan.list()
+}
+
 // AdditionalTags returns AdditionalTags from the scope's AzureCluster.
 func (s *ClusterScope) AdditionalTags() infrav1.Tags {
 	tags := make(infrav1.Tags)
@@ -171,6 +377,21 @@ func (s *ClusterScope) AdditionalTags() infrav1.Tags {
 	return tags
 }
 
+// ResourceTags returns the tags of a resource of the cluster: the additional tags of the AzureCluster, overridden by
+// the tags marking the resource as owned by the cluster, naming it and, unless empty, giving its role.
+func (s *ClusterScope) ResourceTags(name, role string) infrav1.Tags {
+	params := infrav1.BuildParams{
+		ClusterName: s.Name(),
+		Lifecycle:   infrav1.ResourceLifecycleOwned,
+		Name:        &name,
+		Additional:  s.AdditionalTags(),
+	}
+	if role != "" {
+		params.Role = &role
+	}
+	return infrav1.Build(params)
+}
+
 // APIServerPort returns the APIServerPort to use when creating the load balancer.
 func (s *ClusterScope) APIServerPort() int32 {
 	if s.Cluster.Spec.ClusterNetwork != nil && s.Cluster.Spec.ClusterNetwork.APIServerPort != nil {
@@ -178,3 +399,85 @@ func (s *ClusterScope) APIServerPort() int32 {
 	}
 	return 6443
 }
+
+// IsVnetManaged returns true if the vnet is managed by the cluster. A vnet in a resource group other than the cluster
+// resource group is only managed if it is known to be owned by the cluster, the resource group is not ours otherwise.
+func (s *ClusterScope) IsVnetManaged() bool {
+	vnet := s.Vnet()
+	if vnet.ResourceGroup != "" && vnet.ResourceGroup != s.ResourceGroup() {
+		return vnet.Tags.HasOwned(s.Name())
+	}
+	return vnet.IsManaged(s.Name())
+}
+
+// IsResourceGroupExternal returns true if the resource group is managed outside of the Azure provider.
+func (s *ClusterScope) IsResourceGroupExternal() bool {
+	return s.AzureCluster.Spec.ExternalResourceGroup
+}
+
+// IsAPIServerInternal returns true if the API server is only exposed through the internal load balancer.
+func (s *ClusterScope) IsAPIServerInternal() bool {
+	return s.AzureCluster.Spec.NetworkSpec.APIServerLB.Type == infrav1.LoadBalancerTypeInternal
+}
+
+// LoadBalancerSKU returns the SKU of the load balancers and their public IPs, Standard unless set.
+func (s *ClusterScope) LoadBalancerSKU() infrav1.SKU {
+	if s.AzureCluster.Spec.NetworkSpec.APIServerLB.SKU != "" {
+		return s.AzureCluster.Spec.NetworkSpec.APIServerLB.SKU
+	}
+	return infrav1.SKUStandard
+}
+
+// VPNGateway returns the VPN gateway configuration of the cluster, or nil if it has no VPN gateway.
+func (s *ClusterScope) VPNGateway() *infrav1.VPNGatewaySpec {
+	return s.AzureCluster.Spec.NetworkSpec.VPNGateway
+}
+
+// VPNGatewaySKU returns the SKU of the VPN gateway, VpnGw1 unless set.
+func (s *ClusterScope) VPNGatewaySKU() string {
+	if gateway := s.VPNGateway(); gateway != nil && gateway.SKU != "" {
+		return gateway.SKU
+	}
+	return azure.DefaultVPNGatewaySKU
+}
+
+// PrivateDNSZone returns the private DNS zone configuration of the cluster, or nil if it has no private DNS zone.
+func (s *ClusterScope) PrivateDNSZone() *infrav1.PrivateDNSZoneSpec {
+	return s.AzureCluster.Spec.NetworkSpec.PrivateDNSZone
+}
+
+// PrivateDNSZoneName returns the name of the private DNS zone of the cluster, <cluster name>.capz.io unless set.
+func (s *ClusterScope) PrivateDNSZoneName() string {
+	if zone := s.PrivateDNSZone(); zone != nil && zone.Name != "" {
+		return zone.Name
+	}
+	return azure.GeneratePrivateDNSZoneName(s.Name())
+}
+
+// VnetLinkName returns the name of the link of the private DNS zone to the vnet.
+func (s *ClusterScope) VnetLinkName() string {
+	return azure.GenerateVnetLinkName(s.Name())
+}
+
+// GetLongRunningOperationState returns the long-running operation of the AzureCluster in progress, if any.
+func (s *ClusterScope) GetLongRunningOperationState() *infrav1.Future {
+	return s.AzureCluster.Status.LongRunningOperationState
+}
+
+// SetLongRunningOperationState sets the long-running operation of the AzureCluster in progress.
+func (s *ClusterScope) SetLongRunningOperationState(future *infrav1.Future) {
+	s.AzureCluster.Status.LongRunningOperationState = future
+}
+
+// DeleteLongRunningOperationState clears the long-running operation of the AzureCluster once it has completed.
+func (s *ClusterScope) DeleteLongRunningOperationState() {
+	s.AzureCluster.Status.LongRunningOperationState = nil
+}
+
+// OutboundPublicIPCount returns the number of public IPs of the public load balancer outbound rule.
+func (s *ClusterScope) OutboundPublicIPCount() int32 {
+	if s.AzureCluster.Spec.NetworkSpec.OutboundPublicIPCount != nil {
+		return *s.AzureCluster.Spec.NetworkSpec.OutboundPublicIPCount
+	}
+	return 1
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/klogr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResourceTags(t *testing.T) {
+	testcases := []struct {
+		name           string
+		additionalTags infrav1.Tags
+		role           string
+		expected       infrav1.Tags
+	}{
+		{
+			name: "resource without a role",
+			expected: infrav1.Tags{
+				"Name": "my-resource",
+				"sigs.k8s.io_cluster-api-provider-azure_cluster_my-cluster": "owned",
+			},
+		},
+		{
+			name: "resource with a role",
+			role: infrav1.APIServerRoleTagValue,
+			expected: infrav1.Tags{
+				"Name": "my-resource",
+				"sigs.k8s.io_cluster-api-provider-azure_cluster_my-cluster": "owned",
+				"sigs.k8s.io_cluster-api-provider-azure_role":               "apiserver",
+			},
+		},
+		{
+			name:           "additional tags do not override the cluster tags",
+			additionalTags: infrav1.Tags{"custom": "value", "Name": "other"},
+			expected: infrav1.Tags{
+				"custom": "value",
+				"Name":   "my-resource",
+				"sigs.k8s.io_cluster-api-provider-azure_cluster_my-cluster": "owned",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &ClusterScope{
+				Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"}},
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{AdditionalTags: tc.additionalTags},
+				},
+			}
+			tags := s.ResourceTags("my-resource", tc.role)
+			if !reflect.DeepEqual(tags, tc.expected) {
+				t.Errorf("expected tags %v, got %v", tc.expected, tags)
+			}
+			if _, ok := s.AzureCluster.Spec.AdditionalTags["sigs.k8s.io_cluster-api-provider-azure_cluster_my-cluster"]; ok {
+				t.Errorf("expected the additional tags of the cluster to be left unchanged")
+			}
+		})
+	}
+}
+
+func TestNewClusterScopeIdentity(t *testing.T) {
+	identity := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-identity", Namespace: "my-namespace"},
+		Data: map[string][]byte{
+			"tenantID":     []byte("my-tenant"),
+			"clientID":     []byte("my-client"),
+			"clientSecret": []byte("my-secret"),
+		},
+	}
+
+	secretRef := &corev1.LocalObjectReference{Name: "my-identity"}
+
+	testcases := []struct {
+		name          string
+		identity      *infrav1.ClusterIdentity
+		objects       []runtime.Object
+		expectedError string
+	}{
+		{
+			name:     "identity secret exists",
+			identity: &infrav1.ClusterIdentity{SecretRef: secretRef},
+			objects:  []runtime.Object{identity},
+		},
+		{
+			name:          "identity secret does not exist",
+			identity:      &infrav1.ClusterIdentity{SecretRef: secretRef},
+			expectedError: `failed to get identity secret my-namespace/my-identity: secrets "my-identity" not found`,
+		},
+		{
+			name:          "service principal identity without a secret",
+			identity:      &infrav1.ClusterIdentity{Type: infrav1.ServicePrincipalIdentity},
+			expectedError: "service principal identity of AzureCluster my-namespace/my-cluster has no secret",
+		},
+		{
+			name:     "managed identity",
+			identity: &infrav1.ClusterIdentity{Type: infrav1.ManagedIdentity, ClientID: "my-client"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewClusterScope(ClusterScopeParams{
+				AzureClients: AzureClients{SubscriptionID: "123"},
+				Client:       fake.NewFakeClient(tc.objects...),
+				Cluster:      &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "my-namespace"}},
+				AzureCluster: &infrav1.AzureCluster{
+					ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "my-namespace"},
+					Spec:       infrav1.AzureClusterSpec{Identity: tc.identity},
+				},
+			})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if s.Authorizer == nil {
+				t.Errorf("expected an authorizer from the identity")
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	create := CreateOrUpdateAction("route table", "my-rg", "my-rt", "desired")
+	del := DeleteAction("vnet", "my-rg", "my-vnet")
+
+	s := &ClusterScope{Logger: klogr.New()}
+	if s.DryRun(create) {
+		t.Errorf("expected the action to be made outside of dry-run mode")
+	}
+	if actions := s.PlannedActions(); len(actions) != 0 {
+		t.Errorf("expected no planned actions outside of dry-run mode, got %v", actions)
+	}
+
+	// the scope has no patch helper, closing it would panic if it persisted the AzureCluster.
+	s = &ClusterScope{Logger: klogr.New(), dryRun: true}
+	if !s.DryRun(create) || !s.DryRun(del) {
+		t.Errorf("expected the actions to be skipped in dry-run mode")
+	}
+	if actions := s.PlannedActions(); !reflect.DeepEqual(actions, []Action{create, del}) {
+		t.Errorf("expected the planned actions %v, got %v", []Action{create, del}, actions)
+	}
+	if got := del.String(); got != "Delete vnet my-rg/my-vnet" {
+		t.Errorf("expected the action to be described as %q, got %q", "Delete vnet my-rg/my-vnet", got)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("got an unexpected error: %v", err)
+	}
+}
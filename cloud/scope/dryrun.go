@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Operation is a change a service makes to an Azure resource.
+type Operation string
+
+const (
+	// OperationCreateOrUpdate creates the resource, or updates it if it exists.
+	OperationCreateOrUpdate = Operation("CreateOrUpdate")
+	// OperationDelete deletes the resource.
+	OperationDelete = Operation("Delete")
+	// OperationDeallocate stops the resource and releases its compute resources.
+	OperationDeallocate = Operation("Deallocate")
+	// OperationStart starts the resource.
+	OperationStart = Operation("Start")
+)
+
+// Action is a change to an Azure resource that a service skipped in dry-run mode.
+type Action struct {
+	Operation     Operation
+	ResourceType  string
+	ResourceGroup string
+	Name          string
+	// Desired is the state of the resource the service would have sent to Azure, it is nil for deletions and for
+	// power operations.
+	Desired interface{}
+}
+
+// String describes the action, for example "CreateOrUpdate route table my-rg/my-cluster-node-routetable".
+func (a Action) String() string {
+	return fmt.Sprintf("%s %s %s/%s", a.Operation, a.ResourceType, a.ResourceGroup, a.Name)
+}
+
+// CreateOrUpdateAction returns the action of creating or updating a resource to its desired state.
+func CreateOrUpdateAction(resourceType, resourceGroup, name string, desired interface{}) Action {
+	return Action{Operation: OperationCreateOrUpdate, ResourceType: resourceType, ResourceGroup: resourceGroup, Name: name, Desired: desired}
+}
+
+// DeleteAction returns the action of deleting a resource.
+func DeleteAction(resourceType, resourceGroup, name string) Action {
+	return Action{Operation: OperationDelete, ResourceType: resourceType, ResourceGroup: resourceGroup, Name: name}
+}
+
+// DeallocateAction returns the action of deallocating a resource.
+func DeallocateAction(resourceType, resourceGroup, name string) Action {
+	return Action{Operation: OperationDeallocate, ResourceType: resourceType, ResourceGroup: resourceGroup, Name: name}
+}
+
+// StartAction returns the action of starting a resource.
+func StartAction(resourceType, resourceGroup, name string) Action {
+	return Action{Operation: OperationStart, ResourceType: resourceType, ResourceGroup: resourceGroup, Name: name}
+}
+
+// plan records the actions skipped in dry-run mode. Services of a cluster can run concurrently.
+type plan struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+func (p *plan) add(action Action) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = append(p.actions, action)
+}
+
+func (p *plan) list() []Action {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Action(nil), p.actions...)
+}
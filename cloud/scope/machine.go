@@ -18,7 +18,11 @@ package scope
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
 
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
@@ -99,9 +103,9 @@ func (m *MachineScope) Location() string {
 	return m.AzureCluster.Spec.Location
 }
 
-// AvailabilityZone returns the AzureMachine Availability Zone.
-func (m *MachineScope) AvailabilityZone() string {
-	return *m.AzureMachine.Spec.AvailabilityZone.ID
+// FailureDomain returns the availability zone requested for the AzureMachine, empty if it has none.
+func (m *MachineScope) FailureDomain() string {
+	return to.String(m.AzureMachine.Spec.AvailabilityZone.ID)
 }
 
 // Name returns the AzureMachine name.
@@ -149,6 +153,48 @@ func (m *MachineScope) SetProviderID(v string) {
 	m.AzureMachine.Spec.ProviderID = pointer.StringPtr(v)
 }
 
+// GetBootstrapData returns the base64 encoded bootstrap data of the Machine. Cluster API v1alpha2 machines hold it
+// inline, it moves to a secret in later versions. When the AzureMachine has a remote source of bootstrap data, it
+// returns a minimal cloud-init configuration including the remote data instead, so that the custom data of the
+// virtual machine stays small.
+func (m *MachineScope) GetBootstrapData() (string, error) {
+	if m.Machine.Spec.Bootstrap.Data == nil {
+		return "", errors.Errorf("bootstrap data of machine %s/%s is not available", m.Machine.Namespace, m.Machine.Name)
+	}
+	if source := m.AzureMachine.Spec.BootstrapDataSource; source != nil {
+		bootstrapURL, err := m.getBootstrapDataURL(source)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(GenerateRemoteBootstrapData(bootstrapURL))), nil
+	}
+	return *m.Machine.Spec.Bootstrap.Data, nil
+}
+
+// getBootstrapDataURL returns the URL of the remote bootstrap data, with the shared access signature token of the
+// referenced secret as its query.
+func (m *MachineScope) getBootstrapDataURL(source *infrav1.BootstrapDataSource) (string, error) {
+	if source.SASTokenSecretRef == nil {
+		return source.URL, nil
+	}
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: m.AzureMachine.Namespace, Name: source.SASTokenSecretRef.Name}
+	if err := m.client.Get(context.TODO(), key, secret); err != nil {
+		return "", errors.Wrapf(err, "failed to get SAS token secret %s", key)
+	}
+	token := strings.TrimPrefix(string(secret.Data[infrav1.SASTokenKey]), "?")
+	if token == "" {
+		return "", errors.Errorf("SAS token secret %s has no %s key", key, infrav1.SASTokenKey)
+	}
+	return fmt.Sprintf("%s?%s", source.URL, token), nil
+}
+
+// GenerateRemoteBootstrapData generates a cloud-init include file, which makes cloud-init download the bootstrap data
+// from the URL at boot and process it as the user data of the machine.
+func GenerateRemoteBootstrapData(bootstrapURL string) string {
+	return fmt.Sprintf("#include\n%s\n", bootstrapURL)
+}
+
 // GetVMState returns the AzureMachine VM state.
 func (m *MachineScope) GetVMState() *infrav1.VMState {
 	return m.AzureMachine.Status.VMState
@@ -159,6 +205,40 @@ func (m *MachineScope) SetVMState(v infrav1.VMState) {
 	m.AzureMachine.Status.VMState = &v
 }
 
+// GetVMPowerState returns the AzureMachine VM power state.
+func (m *MachineScope) GetVMPowerState() *infrav1.VMPowerState {
+	return m.AzureMachine.Status.PowerState
+}
+
+// SetVMPowerState sets the AzureMachine VM power state, or clears it when Azure reports none.
+func (m *MachineScope) SetVMPowerState(v infrav1.VMPowerState) {
+	if v == "" {
+		m.AzureMachine.Status.PowerState = nil
+		return
+	}
+	m.AzureMachine.Status.PowerState = &v
+}
+
+// GetLongRunningOperationState returns the long-running operation of the AzureMachine in progress, if any.
+func (m *MachineScope) GetLongRunningOperationState() *infrav1.Future {
+	return m.AzureMachine.Status.LongRunningOperationState
+}
+
+// SetLongRunningOperationState sets the long-running operation of the AzureMachine in progress.
+func (m *MachineScope) SetLongRunningOperationState(future *infrav1.Future) {
+	m.AzureMachine.Status.LongRunningOperationState = future
+}
+
+// DeleteLongRunningOperationState clears the long-running operation of the AzureMachine once it has completed.
+func (m *MachineScope) DeleteLongRunningOperationState() {
+	m.AzureMachine.Status.LongRunningOperationState = nil
+}
+
+// SetSSHPort sets the AzureMachine SSH port status.
+func (m *MachineScope) SetSSHPort(v int32) {
+	m.AzureMachine.Status.SSHPort = &v
+}
+
 // SetReady sets the AzureMachine Ready Status
 func (m *MachineScope) SetReady() {
 	m.AzureMachine.Status.Ready = true
@@ -204,3 +284,18 @@ func (m *MachineScope) AdditionalTags() infrav1.Tags {
 
 	return tags
 }
+
+// ResourceTags returns the tags of the virtual machine of the machine: the additional tags of the AzureCluster and
+// the AzureMachine, overridden by the tags marking the virtual machine as owned by the cluster for Cluster API and
+// for the Azure cloud provider, naming it and giving its role.
+func (m *MachineScope) ResourceTags() infrav1.Tags {
+	additionalTags := m.AdditionalTags()
+	additionalTags[infrav1.ClusterAzureCloudProviderTagKey(m.Name())] = string(infrav1.ResourceLifecycleOwned)
+	return infrav1.Build(infrav1.BuildParams{
+		ClusterName: m.Cluster.Name,
+		Lifecycle:   infrav1.ResourceLifecycleOwned,
+		Name:        to.StringPtr(m.Name()),
+		Role:        to.StringPtr(m.Role()),
+		Additional:  additionalTags,
+	})
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"encoding/base64"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetBootstrapData(t *testing.T) {
+	testcases := []struct {
+		name          string
+		data          *string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "bootstrap data is available",
+			data:     pointer.StringPtr("I2Nsb3VkLWNvbmZpZwo="),
+			expected: "I2Nsb3VkLWNvbmZpZwo=",
+		},
+		{
+			name:          "bootstrap data is not available yet",
+			expectedError: "bootstrap data of machine my-namespace/my-machine is not available",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &MachineScope{
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "my-machine", Namespace: "my-namespace"},
+					Spec:       clusterv1.MachineSpec{Bootstrap: clusterv1.Bootstrap{Data: tc.data}},
+				},
+				AzureMachine: &infrav1.AzureMachine{},
+			}
+			data, err := m.GetBootstrapData()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if data != tc.expected {
+				t.Errorf("expected bootstrap data %q, got %q", tc.expected, data)
+			}
+		})
+	}
+}
+
+func TestGetBootstrapDataRemoteSource(t *testing.T) {
+	sasToken := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-sas-token", Namespace: "my-namespace"},
+		Data:       map[string][]byte{"sasToken": []byte("?sv=2019-12-12&sig=abc")},
+	}
+	bootstrapURL := "https://myaccount.blob.core.windows.net/bootstrap/my-machine"
+
+	testcases := []struct {
+		name          string
+		source        *infrav1.BootstrapDataSource
+		objects       []runtime.Object
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "remote source without a SAS token",
+			source:   &infrav1.BootstrapDataSource{URL: bootstrapURL},
+			expected: "#include\nhttps://myaccount.blob.core.windows.net/bootstrap/my-machine\n",
+		},
+		{
+			name: "remote source with a SAS token",
+			source: &infrav1.BootstrapDataSource{
+				URL:               bootstrapURL,
+				SASTokenSecretRef: &corev1.LocalObjectReference{Name: "my-sas-token"},
+			},
+			objects:  []runtime.Object{sasToken},
+			expected: "#include\nhttps://myaccount.blob.core.windows.net/bootstrap/my-machine?sv=2019-12-12&sig=abc\n",
+		},
+		{
+			name: "SAS token secret does not exist",
+			source: &infrav1.BootstrapDataSource{
+				URL:               bootstrapURL,
+				SASTokenSecretRef: &corev1.LocalObjectReference{Name: "my-sas-token"},
+			},
+			expectedError: `failed to get SAS token secret my-namespace/my-sas-token: secrets "my-sas-token" not found`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &MachineScope{
+				client: fake.NewFakeClient(tc.objects...),
+				Machine: &clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "my-machine", Namespace: "my-namespace"},
+					Spec:       clusterv1.MachineSpec{Bootstrap: clusterv1.Bootstrap{Data: pointer.StringPtr("I2Nsb3VkLWNvbmZpZwo=")}},
+				},
+				AzureMachine: &infrav1.AzureMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "my-machine", Namespace: "my-namespace"},
+					Spec:       infrav1.AzureMachineSpec{BootstrapDataSource: tc.source},
+				},
+			}
+			data, err := m.GetBootstrapData()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			decoded, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				t.Fatalf("expected base64 encoded bootstrap data, got %q", data)
+			}
+			if string(decoded) != tc.expected {
+				t.Errorf("expected bootstrap data %q, got %q", tc.expected, decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scope
+
+import (
+	"context"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"k8s.io/klog/klogr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/cluster-api/util/patch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MachinePoolScopeParams defines the input parameters used to create a new MachinePoolScope.
+type MachinePoolScopeParams struct {
+	Client           client.Client
+	Logger           logr.Logger
+	Cluster          *clusterv1.Cluster
+	AzureCluster     *infrav1.AzureCluster
+	AzureMachinePool *infrav1.AzureMachinePool
+}
+
+// NewMachinePoolScope creates a new MachinePoolScope from the supplied parameters.
+// This is meant to be called for each reconcile iteration.
+func NewMachinePoolScope(params MachinePoolScopeParams) (*MachinePoolScope, error) {
+	if params.Client == nil {
+		return nil, errors.New("client is required when creating a MachinePoolScope")
+	}
+	if params.Cluster == nil {
+		return nil, errors.New("cluster is required when creating a MachinePoolScope")
+	}
+	if params.AzureCluster == nil {
+		return nil, errors.New("azure cluster is required when creating a MachinePoolScope")
+	}
+	if params.AzureMachinePool == nil {
+		return nil, errors.New("azure machine pool is required when creating a MachinePoolScope")
+	}
+
+	if params.Logger == nil {
+		params.Logger = klogr.New()
+	}
+
+	helper, err := patch.NewHelper(params.AzureMachinePool, params.Client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to init patch helper")
+	}
+	return &MachinePoolScope{
+		Cluster:          params.Cluster,
+		AzureCluster:     params.AzureCluster,
+		AzureMachinePool: params.AzureMachinePool,
+		Logger:           params.Logger,
+		patchHelper:      helper,
+	}, nil
+}
+
+// MachinePoolScope defines a scope defined around a machine pool and its cluster.
+type MachinePoolScope struct {
+	logr.Logger
+	patchHelper *patch.Helper
+
+	Cluster          *clusterv1.Cluster
+	AzureCluster     *infrav1.AzureCluster
+	AzureMachinePool *infrav1.AzureMachinePool
+}
+
+// Name returns the AzureMachinePool name, which is the name of its VM scale set.
+func (m *MachinePoolScope) Name() string {
+	return m.AzureMachinePool.Name
+}
+
+// Namespace returns the namespace name.
+func (m *MachinePoolScope) Namespace() string {
+	return m.AzureMachinePool.Namespace
+}
+
+// Replicas returns the desired number of instances of the scale set of the AzureMachinePool, 1 by default.
+func (m *MachinePoolScope) Replicas() int32 {
+	if m.AzureMachinePool.Spec.Replicas == nil {
+		return 1
+	}
+	return *m.AzureMachinePool.Spec.Replicas
+}
+
+// GetBootstrapData returns the bootstrap data of the instances of the AzureMachinePool.
+func (m *MachinePoolScope) GetBootstrapData() (string, error) {
+	if m.AzureMachinePool.Spec.BootstrapData == nil {
+		return "", errors.Errorf("bootstrap data of machine pool %s/%s is not available", m.Namespace(), m.Name())
+	}
+	return *m.AzureMachinePool.Spec.BootstrapData, nil
+}
+
+// SetReplicas sets the AzureMachinePool number of instances.
+func (m *MachinePoolScope) SetReplicas(v int32) {
+	m.AzureMachinePool.Status.Replicas = v
+}
+
+// SetProvisioningState sets the AzureMachinePool scale set provisioning state.
+func (m *MachinePoolScope) SetProvisioningState(v infrav1.VMState) {
+	m.AzureMachinePool.Status.ProvisioningState = &v
+}
+
+// SetReady sets whether the AzureMachinePool scale set is provisioned with the desired number of instances.
+func (m *MachinePoolScope) SetReady(ready bool) {
+	m.AzureMachinePool.Status.Ready = ready
+}
+
+// AdditionalTags returns the additional tags of the AzureMachinePool.
+func (m *MachinePoolScope) AdditionalTags() infrav1.Tags {
+	return m.AzureMachinePool.Spec.AdditionalTags
+}
+
+// Version returns the Kubernetes version of the nodes of the AzureMachinePool, empty if it has none.
+func (m *MachinePoolScope) Version() string {
+	return to.String(m.AzureMachinePool.Spec.Version)
+}
+
+// Close the MachinePoolScope by updating the AzureMachinePool spec and status.
+func (m *MachinePoolScope) Close() error {
+	return m.patchHelper.Patch(context.TODO(), m.AzureMachinePool)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package availabilitysets
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+const (
+	// faultDomainCount is supported in every region, some regions support up to 3.
+	faultDomainCount  = 2
+	updateDomainCount = 5
+)
+
+// Spec specification for availability set
+type Spec struct {
+	Name string
+	Role string
+	// ProximityPlacementGroupID is the ID of the proximity placement group of the availability set, which the virtual
+	// machines of the set have to be placed in as well.
+	ProximityPlacementGroupID string
+}
+
+// Get provides information about an availability set.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	asSpec, ok := spec.(*Spec)
+	if !ok {
+		return compute.AvailabilitySet{}, errors.New("invalid availability set specification")
+	}
+	availabilitySet, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), asSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "availability set %s not found", asSpec.Name)
+	} else if err != nil {
+		return availabilitySet, err
+	}
+	return availabilitySet, nil
+}
+
+// Reconcile gets/creates/updates a managed availability set.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	asSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid availability set specification")
+	}
+	log := s.Scope.ResourceLogger(asSpec.Name)
+	log.V(2).Info("creating availability set")
+	availabilitySet := compute.AvailabilitySet{
+		Location: to.StringPtr(s.Scope.Location()),
+		// The Aligned sku is required for virtual machines with managed disks.
+		Sku:  &compute.Sku{Name: to.StringPtr(string(compute.Aligned))},
+		Tags: converters.TagsToMap(s.Scope.ResourceTags(asSpec.Name, asSpec.Role)),
+		AvailabilitySetProperties: &compute.AvailabilitySetProperties{
+			PlatformFaultDomainCount:  to.Int32Ptr(faultDomainCount),
+			PlatformUpdateDomainCount: to.Int32Ptr(updateDomainCount),
+		},
+	}
+	if asSpec.ProximityPlacementGroupID != "" {
+		availabilitySet.ProximityPlacementGroup = &compute.SubResource{ID: to.StringPtr(asSpec.ProximityPlacementGroupID)}
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("availability set", s.Scope.ResourceGroup(), asSpec.Name, availabilitySet)) {
+		return nil
+	}
+
+	err := s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), asSpec.Name, availabilitySet)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create availability set %s in resource group %s", asSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully created availability set")
+	return nil
+}
+
+// Delete deletes the availability set with the provided name.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	asSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid availability set specification")
+	}
+	log := s.Scope.ResourceLogger(asSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("availability set", s.Scope.ResourceGroup(), asSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting availability set")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), asSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("availability set is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete availability set %s in resource group %s", asSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted availability set")
+	return nil
+}
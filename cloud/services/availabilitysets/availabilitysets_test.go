@@ -0,0 +1,192 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package availabilitysets
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilitysets/mock_availabilitysets"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileAvailabilitySets(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		ppgID         string
+		expect        func(m *mock_availabilitysets.MockClientMockRecorder)
+	}{
+		{
+			name: "availability set is created",
+			expect: func(m *mock_availabilitysets.MockClientMockRecorder) {
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-as", compute.AvailabilitySet{
+					Location: to.StringPtr("test-location"),
+					Sku:      &compute.Sku{Name: to.StringPtr("Aligned")},
+					Tags: map[string]*string{
+						"Name": to.StringPtr("my-as"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("control-plane"),
+					},
+					AvailabilitySetProperties: &compute.AvailabilitySetProperties{
+						PlatformFaultDomainCount:  to.Int32Ptr(2),
+						PlatformUpdateDomainCount: to.Int32Ptr(5),
+					},
+				})
+			},
+		},
+		{
+			name:  "availability set in a proximity placement group",
+			ppgID: "my-ppg-id",
+			expect: func(m *mock_availabilitysets.MockClientMockRecorder) {
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-as", gomock.AssignableToTypeOf(compute.AvailabilitySet{})).
+					Do(func(_ context.Context, _, _ string, as compute.AvailabilitySet) {
+						if as.ProximityPlacementGroup == nil || to.String(as.ProximityPlacementGroup.ID) != "my-ppg-id" {
+							t.Errorf("expected proximity placement group my-ppg-id, got %+v", as.ProximityPlacementGroup)
+						}
+					})
+			},
+		},
+		{
+			name:          "fail to create availability set",
+			expectedError: "failed to create availability set my-as in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_availabilitysets.MockClientMockRecorder) {
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-as", gomock.AssignableToTypeOf(compute.AvailabilitySet{})).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			availabilitySetsMock := mock_availabilitysets.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(availabilitySetsMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: availabilitySetsMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{Name: "my-as", Role: infrav1.ControlPlane, ProximityPlacementGroupID: tc.ppgID})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteAvailabilitySets(t *testing.T) {
+	testcases := []struct {
+		name   string
+		expect func(m *mock_availabilitysets.MockClientMockRecorder)
+	}{
+		{
+			name: "availability set exists",
+			expect: func(m *mock_availabilitysets.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-as")
+			},
+		},
+		{
+			name: "availability set already deleted",
+			expect: func(m *mock_availabilitysets.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-as").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			availabilitySetsMock := mock_availabilitysets.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(availabilitySetsMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: availabilitySetsMock,
+			}
+
+			if err := s.Delete(context.TODO(), &Spec{Name: "my-as"}); err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -19,7 +19,7 @@ package availabilityzones
 import (
 	"context"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 )
@@ -36,15 +36,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newResourceSkusClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newResourceSkusClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
 // getResourceSkusClient creates a new availability zones client from subscription ID.
-func newResourceSkusClient(subscriptionID string, authorizer autorest.Authorizer) compute.ResourceSkusClient {
-	skusClient := compute.NewResourceSkusClient(subscriptionID)
+func newResourceSkusClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) compute.ResourceSkusClient {
+	skusClient := compute.NewResourceSkusClientWithBaseURI(baseURI, subscriptionID)
 	skusClient.Authorizer = authorizer
 	skusClient.AddToUserAgent(azure.UserAgent)
 	return skusClient
@@ -52,5 +52,11 @@ func newResourceSkusClient(subscriptionID string, authorizer autorest.Authorizer
 
 // ListComplete enumerates all values, automatically crossing page boundaries as required.
 func (ac *AzureClient) ListComplete(ctx context.Context) (compute.ResourceSkusResultIterator, error) {
-	return ac.resourceSkus.ListComplete(ctx)
+	var result compute.ResourceSkusResultIterator
+	err := azure.ObserveAPICall("availabilityzones", "ListComplete", func() error {
+		var err error
+		result, err = ac.resourceSkus.ListComplete(ctx, "")
+		return err
+	})
+	return result, err
 }
@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backendpools
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for the members of a backend pool of a load balancer
+type Spec struct {
+	LoadBalancerName string
+	BackendPoolName  string
+	// NetworkInterfaceNames are the names of the network interfaces which must be in the backend pool, through their
+	// primary IP configuration. The other network interfaces of the cluster are removed from the backend pool.
+	NetworkInterfaceNames []string
+}
+
+// Reconcile adds the network interfaces of the spec which are missing from the backend pool, such as a network
+// interface which was recreated, and removes the network interfaces of the cluster which no longer belong to it.
+// Network interfaces which do not exist are skipped, the backend pool of a network interface follows its deletion.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	poolSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid backend pool specification")
+	}
+	log := s.Scope.ResourceLogger(poolSpec.BackendPoolName)
+
+	lb, err := s.LoadBalancersClient.Get(ctx, s.Scope.ResourceGroup(), poolSpec.LoadBalancerName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get load balancer %s", poolSpec.LoadBalancerName)
+	}
+	pool := backendPool(lb, poolSpec.BackendPoolName)
+	if pool == nil {
+		return errors.Errorf("load balancer %s has no backend pool %s", poolSpec.LoadBalancerName, poolSpec.BackendPoolName)
+	}
+	poolID := to.String(pool.ID)
+
+	members := make(map[string]bool, len(poolSpec.NetworkInterfaceNames))
+	for _, nicName := range poolSpec.NetworkInterfaceNames {
+		members[strings.ToLower(nicName)] = true
+		nic, err := s.NetworkInterfacesClient.Get(ctx, s.Scope.ResourceGroup(), nicName)
+		if err != nil && azure.ResourceNotFound(err) {
+			log.V(4).Info("skipping network interface which does not exist", "networkInterface", nicName)
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to get network interface %s", nicName)
+		}
+		if !addToBackendPool(nic, poolID) {
+			continue
+		}
+		log.V(2).Info("adding network interface to backend pool", "networkInterface", nicName)
+		if err := s.updateNetworkInterface(ctx, nicName, nic); err != nil {
+			return err
+		}
+	}
+
+	for _, nicName := range s.networkInterfaceNames(pool) {
+		if members[strings.ToLower(nicName)] {
+			continue
+		}
+		nic, err := s.NetworkInterfacesClient.Get(ctx, s.Scope.ResourceGroup(), nicName)
+		if err != nil && azure.ResourceNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to get network interface %s", nicName)
+		}
+		// only the network interfaces of the cluster are removed, the backend pool can have others added out of band.
+		if !converters.MapToTags(nic.Tags).HasOwned(s.Scope.Name()) || !removeFromBackendPool(nic, poolID) {
+			continue
+		}
+		log.V(2).Info("removing stale network interface from backend pool", "networkInterface", nicName)
+		if err := s.updateNetworkInterface(ctx, nicName, nic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete is a no-op, deleting a load balancer or a network interface removes it from the backend pool.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	return nil
+}
+
+// updateNetworkInterface updates the network interface with its changed backend pools.
+func (s *Service) updateNetworkInterface(ctx context.Context, nicName string, nic network.Interface) error {
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("network interface", s.Scope.ResourceGroup(), nicName, nic)) {
+		return nil
+	}
+	if err := s.NetworkInterfacesClient.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), nicName, nic); err != nil {
+		return errors.Wrapf(err, "failed to update backend pools of network interface %s", nicName)
+	}
+	return nil
+}
+
+// networkInterfaceNames returns the names of the network interfaces of the resource group of the cluster in the
+// backend pool, from the IDs of their IP configurations. The network interfaces of scale sets and of other resource
+// groups are left out.
+func (s *Service) networkInterfaceNames(pool *network.BackendAddressPool) []string {
+	if pool.BackendAddressPoolPropertiesFormat == nil || pool.BackendIPConfigurations == nil {
+		return nil
+	}
+	prefix := strings.ToLower("/resourceGroups/" + s.Scope.ResourceGroup() + "/providers/Microsoft.Network/networkInterfaces/")
+	var names []string
+	for _, ipConfig := range *pool.BackendIPConfigurations {
+		id := to.String(ipConfig.ID)
+		i := strings.Index(strings.ToLower(id), prefix)
+		if i < 0 {
+			continue
+		}
+		// the ID of an IP configuration is <network interface ID>/ipConfigurations/<name>.
+		names = append(names, strings.SplitN(id[i+len(prefix):], "/", 2)[0])
+	}
+	return names
+}
+
+// backendPool returns the backend pool of the load balancer with the name, or nil if there is none.
+func backendPool(lb network.LoadBalancer, name string) *network.BackendAddressPool {
+	if lb.LoadBalancerPropertiesFormat == nil || lb.BackendAddressPools == nil {
+		return nil
+	}
+	for i, pool := range *lb.BackendAddressPools {
+		if to.String(pool.Name) == name {
+			return &(*lb.BackendAddressPools)[i]
+		}
+	}
+	return nil
+}
+
+// primaryIPConfiguration returns the primary IP configuration of the network interface, which is its only one unless
+// the network interface has several.
+func primaryIPConfiguration(nic network.Interface) *network.InterfaceIPConfiguration {
+	if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil || len(*nic.IPConfigurations) == 0 {
+		return nil
+	}
+	ipConfigs := *nic.IPConfigurations
+	for i := range ipConfigs {
+		if ipConfigs[i].InterfaceIPConfigurationPropertiesFormat != nil && to.Bool(ipConfigs[i].Primary) {
+			return &ipConfigs[i]
+		}
+	}
+	return &ipConfigs[0]
+}
+
+// addToBackendPool adds the backend pool to the primary IP configuration of the network interface, and returns whether
+// the network interface changed.
+func addToBackendPool(nic network.Interface, poolID string) bool {
+	ipConfig := primaryIPConfiguration(nic)
+	if ipConfig == nil {
+		return false
+	}
+	if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil {
+		ipConfig.InterfaceIPConfigurationPropertiesFormat = &network.InterfaceIPConfigurationPropertiesFormat{}
+	}
+	var pools []network.BackendAddressPool
+	if ipConfig.LoadBalancerBackendAddressPools != nil {
+		pools = *ipConfig.LoadBalancerBackendAddressPools
+	}
+	for _, pool := range pools {
+		if strings.EqualFold(to.String(pool.ID), poolID) {
+			return false
+		}
+	}
+	pools = append(pools, network.BackendAddressPool{ID: to.StringPtr(poolID)})
+	ipConfig.LoadBalancerBackendAddressPools = &pools
+	return true
+}
+
+// removeFromBackendPool removes the backend pool from all the IP configurations of the network interface, and returns
+// whether the network interface changed.
+func removeFromBackendPool(nic network.Interface, poolID string) bool {
+	if nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil {
+		return false
+	}
+	changed := false
+	for _, ipConfig := range *nic.IPConfigurations {
+		if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.LoadBalancerBackendAddressPools == nil {
+			continue
+		}
+		pools := []network.BackendAddressPool{}
+		for _, pool := range *ipConfig.LoadBalancerBackendAddressPools {
+			if strings.EqualFold(to.String(pool.ID), poolID) {
+				changed = true
+				continue
+			}
+			pools = append(pools, pool)
+		}
+		*ipConfig.LoadBalancerBackendAddressPools = pools
+	}
+	return changed
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backendpools
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/networkinterfaces/mock_networkinterfaces"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers/mock_publicloadbalancers"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileBackendPool(t *testing.T) {
+	poolID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-lb/backendAddressPools/node-backEndPool"
+	otherPoolID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-lb/backendAddressPools/other-backEndPool"
+	ownedTags := map[string]*string{"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned")}
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+	ipConfigID := func(nicName string) string {
+		return "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/networkInterfaces/" + nicName + "/ipConfigurations/pipConfig"
+	}
+	lb := func(members ...string) network.LoadBalancer {
+		ipConfigs := []network.InterfaceIPConfiguration{}
+		for _, member := range members {
+			ipConfigs = append(ipConfigs, network.InterfaceIPConfiguration{ID: to.StringPtr(member)})
+		}
+		return network.LoadBalancer{
+			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+				BackendAddressPools: &[]network.BackendAddressPool{
+					{
+						ID:   to.StringPtr(poolID),
+						Name: to.StringPtr("node-backEndPool"),
+						BackendAddressPoolPropertiesFormat: &network.BackendAddressPoolPropertiesFormat{
+							BackendIPConfigurations: &ipConfigs,
+						},
+					},
+				},
+			},
+		}
+	}
+	nic := func(tags map[string]*string, poolIDs ...string) network.Interface {
+		pools := []network.BackendAddressPool{}
+		for _, id := range poolIDs {
+			pools = append(pools, network.BackendAddressPool{ID: to.StringPtr(id)})
+		}
+		return network.Interface{
+			Tags: tags,
+			InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+				IPConfigurations: &[]network.InterfaceIPConfiguration{
+					{
+						Name: to.StringPtr("pipConfig"),
+						InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+							LoadBalancerBackendAddressPools: &pools,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	testcases := []struct {
+		name          string
+		poolSpec      Spec
+		expectedError string
+		expect        func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder)
+	}{
+		{
+			name:     "members already in the backend pool",
+			poolSpec: Spec{LoadBalancerName: "my-lb", BackendPoolName: "node-backEndPool", NetworkInterfaceNames: []string{"node-0-nic"}},
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(ipConfigID("node-0-nic")), nil)
+				nicMock.Get(context.TODO(), "my-rg", "node-0-nic").Return(nic(ownedTags, poolID), nil)
+			},
+		},
+		{
+			name:     "add a missing network interface to the backend pool",
+			poolSpec: Spec{LoadBalancerName: "my-lb", BackendPoolName: "node-backEndPool", NetworkInterfaceNames: []string{"node-0-nic", "node-1-nic"}},
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(ipConfigID("node-0-nic")), nil)
+				nicMock.Get(context.TODO(), "my-rg", "node-0-nic").Return(nic(ownedTags, poolID), nil)
+				nicMock.Get(context.TODO(), "my-rg", "node-1-nic").Return(nic(ownedTags, otherPoolID), nil)
+				nicMock.CreateOrUpdate(context.TODO(), "my-rg", "node-1-nic", nic(ownedTags, otherPoolID, poolID))
+			},
+		},
+		{
+			name:     "remove a stale network interface from the backend pool",
+			poolSpec: Spec{LoadBalancerName: "my-lb", BackendPoolName: "node-backEndPool", NetworkInterfaceNames: []string{"node-0-nic"}},
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(ipConfigID("node-0-nic"), ipConfigID("old-node-nic")), nil)
+				nicMock.Get(context.TODO(), "my-rg", "node-0-nic").Return(nic(ownedTags, poolID), nil)
+				nicMock.Get(context.TODO(), "my-rg", "old-node-nic").Return(nic(ownedTags, otherPoolID, poolID), nil)
+				nicMock.CreateOrUpdate(context.TODO(), "my-rg", "old-node-nic", nic(ownedTags, otherPoolID))
+			},
+		},
+		{
+			name:     "keep a network interface which is not owned by the cluster in the backend pool",
+			poolSpec: Spec{LoadBalancerName: "my-lb", BackendPoolName: "node-backEndPool"},
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(ipConfigID("my-appliance-nic")), nil)
+				nicMock.Get(context.TODO(), "my-rg", "my-appliance-nic").Return(nic(nil, poolID), nil)
+			},
+		},
+		{
+			name:     "skip network interfaces which were deleted",
+			poolSpec: Spec{LoadBalancerName: "my-lb", BackendPoolName: "node-backEndPool", NetworkInterfaceNames: []string{"node-0-nic"}},
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(ipConfigID("old-node-nic")), nil)
+				nicMock.Get(context.TODO(), "my-rg", "node-0-nic").Return(network.Interface{}, notFound)
+				nicMock.Get(context.TODO(), "my-rg", "old-node-nic").Return(network.Interface{}, notFound)
+			},
+		},
+		{
+			name:     "ignore the network interfaces of scale sets",
+			poolSpec: Spec{LoadBalancerName: "my-lb", BackendPoolName: "node-backEndPool"},
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(
+					"/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/virtualMachineScaleSets/my-vmss/virtualMachines/0/networkInterfaces/my-vmss-nic/ipConfigurations/ipconfig1",
+				), nil)
+			},
+		},
+		{
+			name:          "backend pool which does not exist",
+			poolSpec:      Spec{LoadBalancerName: "my-lb", BackendPoolName: "controlplane-backEndPool"},
+			expectedError: "load balancer my-lb has no backend pool controlplane-backEndPool",
+			expect: func(lbMock *mock_publicloadbalancers.MockClientMockRecorder, nicMock *mock_networkinterfaces.MockClientMockRecorder) {
+				lbMock.Get(context.TODO(), "my-rg", "my-lb").Return(lb(), nil)
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			lbMock := mock_publicloadbalancers.NewMockClient(mockCtrl)
+			nicMock := mock_networkinterfaces.NewMockClient(mockCtrl)
+			tc.expect(lbMock.EXPECT(), nicMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"}}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:                   clusterScope,
+				LoadBalancersClient:     lbMock,
+				NetworkInterfacesClient: nicMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &tc.poolSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddosprotectionplans
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string) (network.DdosProtectionPlan, error)
+	CreateOrUpdate(context.Context, string, string, network.DdosProtectionPlan) error
+	Delete(context.Context, string, string) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	ddosprotectionplans network.DdosProtectionPlansClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new DDoS protection plans client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newDdosProtectionPlansClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newDdosProtectionPlansClient creates a new DDoS protection plans client from subscription ID and base URI.
+func newDdosProtectionPlansClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.DdosProtectionPlansClient {
+	ddosProtectionPlansClient := network.NewDdosProtectionPlansClientWithBaseURI(baseURI, subscriptionID)
+	ddosProtectionPlansClient.Authorizer = authorizer
+	ddosProtectionPlansClient.AddToUserAgent(azure.UserAgent)
+	return ddosProtectionPlansClient
+}
+
+// Get gets the specified DDoS protection plan.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, ddosProtectionPlanName string) (network.DdosProtectionPlan, error) {
+	var result network.DdosProtectionPlan
+	err := azure.CallAPI(ctx, "ddosprotectionplans", "Get", func() error {
+		var err error
+		result, err = ac.ddosprotectionplans.Get(ctx, resourceGroupName, ddosProtectionPlanName)
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdate creates or updates a DDoS protection plan in the specified resource group.
+func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, ddosProtectionPlanName string, ddosProtectionPlan network.DdosProtectionPlan) error {
+	var future network.DdosProtectionPlansCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "ddosprotectionplans", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.ddosprotectionplans.CreateOrUpdate(ctx, resourceGroupName, ddosProtectionPlanName, ddosProtectionPlan)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.ddosprotectionplans.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.ddosprotectionplans)
+		return err
+	})
+}
+
+// Delete deletes the specified DDoS protection plan.
+func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, ddosProtectionPlanName string) error {
+	var future network.DdosProtectionPlansDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "ddosprotectionplans", "Delete", func() error {
+		var err error
+		future, err = ac.ddosprotectionplans.Delete(ctx, resourceGroupName, ddosProtectionPlanName)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.ddosprotectionplans.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.ddosprotectionplans)
+		return err
+	})
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddosprotectionplans
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for DDoS protection plans
+type Spec struct {
+	Name string
+}
+
+// Get provides information about a DDoS protection plan.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	planSpec, ok := spec.(*Spec)
+	if !ok {
+		return network.DdosProtectionPlan{}, errors.New("invalid DDoS protection plan specification")
+	}
+	plan, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), planSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "DDoS protection plan %s not found", planSpec.Name)
+	} else if err != nil {
+		return plan, err
+	}
+	return plan, nil
+}
+
+// Reconcile gets/creates/updates a DDoS protection plan.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		s.Scope.V(4).Info("Skipping DDoS protection plan reconcile in custom vnet mode")
+		return nil
+	}
+	planSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid DDoS protection plan specification")
+	}
+	log := s.Scope.ResourceLogger(planSpec.Name)
+	log.V(4).Info("reconciling DDoS protection plan")
+
+	tags := s.Scope.ResourceTags(planSpec.Name, "")
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), planSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get DDoS protection plan %s in resource group %s", planSpec.Name, s.Scope.ResourceGroup())
+	case err == nil:
+		var tagsChanged bool
+		tags, tagsChanged = converters.MapToTags(existing.Tags).Merged(tags)
+		if !tagsChanged {
+			log.V(4).Info("DDoS protection plan is up to date")
+			return nil
+		}
+	}
+
+	plan := network.DdosProtectionPlan{
+		Name:     to.StringPtr(planSpec.Name),
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(tags),
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("DDoS protection plan", s.Scope.ResourceGroup(), planSpec.Name, plan)) {
+		return nil
+	}
+
+	log.V(2).Info("creating DDoS protection plan")
+	err = s.Client.CreateOrUpdate(
+		ctx,
+		s.Scope.ResourceGroup(),
+		planSpec.Name,
+		plan,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create DDoS protection plan %s in resource group %s", planSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully created DDoS protection plan")
+	return nil
+}
+
+// Delete deletes the DDoS protection plan, once the vnet it protects is deleted.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		s.Scope.V(4).Info("Skipping DDoS protection plan deletion in custom vnet mode")
+		return nil
+	}
+	planSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid DDoS protection plan specification")
+	}
+	log := s.Scope.ResourceLogger(planSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("DDoS protection plan", s.Scope.ResourceGroup(), planSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting DDoS protection plan")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), planSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("DDoS protection plan is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete DDoS protection plan %s in resource group %s", planSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted DDoS protection plan")
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ddosprotectionplans
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/ddosprotectionplans/mock_ddosprotectionplans"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileDdosProtectionPlan(t *testing.T) {
+	ownedTags := map[string]*string{
+		"Name": to.StringPtr("my-plan"),
+		"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+	}
+
+	testcases := []struct {
+		name          string
+		vnet          infrav1.VnetSpec
+		expectedError string
+		expect        func(m *mock_ddosprotectionplans.MockClientMockRecorder)
+	}{
+		{
+			name: "DDoS protection plan does not exist",
+			vnet: infrav1.VnetSpec{Name: "my-vnet"},
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-plan").
+					Return(network.DdosProtectionPlan{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-plan", network.DdosProtectionPlan{
+					Name:     to.StringPtr("my-plan"),
+					Location: to.StringPtr("test-location"),
+					Tags:     ownedTags,
+				})
+			},
+		},
+		{
+			name: "DDoS protection plan is up to date",
+			vnet: infrav1.VnetSpec{Name: "my-vnet"},
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-plan").Return(network.DdosProtectionPlan{
+					ID:   to.StringPtr("plan-id"),
+					Name: to.StringPtr("my-plan"),
+					Tags: ownedTags,
+				}, nil)
+			},
+		},
+		{
+			name: "DDoS protection plan is not created for a custom vnet",
+			vnet: infrav1.VnetSpec{Name: "custom-vnet", ID: "custom-vnet-id"},
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+			},
+		},
+		{
+			name:          "fail to create the DDoS protection plan",
+			vnet:          infrav1.VnetSpec{Name: "my-vnet"},
+			expectedError: "failed to create DDoS protection plan my-plan in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-plan").
+					Return(network.DdosProtectionPlan{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-plan", gomock.AssignableToTypeOf(network.DdosProtectionPlan{})).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			planMock := mock_ddosprotectionplans.NewMockClient(mockCtrl)
+			tc.expect(planMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec:   infrav1.NetworkSpec{Vnet: tc.vnet},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: planMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{Name: "my-plan"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteDdosProtectionPlan(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_ddosprotectionplans.MockClientMockRecorder)
+	}{
+		{
+			name: "DDoS protection plan exists",
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-plan")
+			},
+		},
+		{
+			name: "DDoS protection plan already deleted",
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-plan").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "DDoS protection plan deletion fails",
+			expectedError: "failed to delete DDoS protection plan my-plan in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_ddosprotectionplans.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-plan").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			planMock := mock_ddosprotectionplans.NewMockClient(mockCtrl)
+			tc.expect(planMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec:   infrav1.NetworkSpec{Vnet: infrav1.VnetSpec{Name: "my-vnet"}},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: planMock,
+			}
+
+			err = s.Delete(context.TODO(), &Spec{Name: "my-plan"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
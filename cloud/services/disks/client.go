@@ -19,13 +19,14 @@ package disks
 import (
 	"context"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 )
 
 // Client wraps go-sdk
 type Client interface {
+	Get(context.Context, string, string) (compute.Disk, error)
 	Delete(context.Context, string, string) error
 }
 
@@ -36,29 +37,43 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newDisksClient(subscriptionID, authorizer)
+// NewClient creates a new disks client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newDisksClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newDisksClient creates a new disks client from subscription ID.
-func newDisksClient(subscriptionID string, authorizer autorest.Authorizer) compute.DisksClient {
-	disksClient := compute.NewDisksClient(subscriptionID)
+// newDisksClient creates a new disks client from subscription ID and base URI.
+func newDisksClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) compute.DisksClient {
+	disksClient := compute.NewDisksClientWithBaseURI(baseURI, subscriptionID)
 	disksClient.Authorizer = authorizer
 	disksClient.AddToUserAgent(azure.UserAgent)
 	return disksClient
 }
 
+// Get retrieves information about a managed disk.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, name string) (compute.Disk, error) {
+	var result compute.Disk
+	err := azure.CallAPI(ctx, "disks", "Get", func() error {
+		var err error
+		result, err = ac.disks.Get(ctx, resourceGroupName, name)
+		return err
+	})
+	return result, err
+}
+
+// Delete deletes a managed disk.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, name string) error {
-	future, err := ac.disks.Delete(ctx, resourceGroupName, name)
-	if err != nil {
+	var future compute.DisksDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "disks", "Delete", func() error {
+		var err error
+		future, err = ac.disks.Delete(ctx, resourceGroupName, name)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.disks.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.disks.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.disks)
 		return err
-	}
-	_, err = future.Result(ac.disks)
-	return err
+	})
 }
@@ -19,9 +19,11 @@ package disks
 import (
 	"context"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for disk
@@ -29,32 +31,62 @@ type Spec struct {
 	Name string
 }
 
-// Get on disk is currently no-op. OS disks should only be deleted and will create with the VM automatically.
+// Get provides information about a managed disk.
 func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
-	return Spec{}, nil
+	diskSpec, ok := spec.(*Spec)
+	if !ok {
+		return compute.Disk{}, errors.New("invalid disk specification")
+	}
+	disk, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), diskSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "disk %s not found", diskSpec.Name)
+	} else if err != nil {
+		return disk, err
+	}
+	return disk, nil
 }
 
-// Reconcile on disk is currently no-op. OS disks should only be deleted and will create with the VM automatically.
+// Reconcile on disk is currently no-op. OS and data disks are created with the VM.
 func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	return nil
 }
 
-// Delete deletes the disk associated with a VM.
+// Delete deletes a managed disk of a VM once the VM is gone. A disk that is still attached to a VM is not deleted,
+// the returned error makes the caller retry once the VM deletion detached it.
 func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	diskSpec, ok := spec.(*Spec)
 	if !ok {
 		return errors.New("Invalid disk specification")
 	}
-	klog.V(2).Infof("deleting disk %s", diskSpec.Name)
-	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), diskSpec.Name)
+	log := s.Scope.ResourceLogger(diskSpec.Name)
+	disk, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), diskSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("disk is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get disk %s in resource group %s", diskSpec.Name, s.Scope.ResourceGroup())
+	}
+	if disk.ManagedBy != nil {
+		return errors.Errorf("disk %s is still attached to vm %s, retrying once it is detached", diskSpec.Name, to.String(disk.ManagedBy))
+	}
+
+	if s.Scope.DryRun(scope.DeleteAction("disk", s.Scope.ResourceGroup(), diskSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting disk")
+	err = s.Client.Delete(ctx, s.Scope.ResourceGroup(), diskSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("disk is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete disk %s in resource group %s", diskSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully deleted disk %s", diskSpec.Name)
+	log.V(2).Info("successfully deleted disk")
 	return nil
 }
@@ -0,0 +1,135 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disks
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/disks/mock_disks"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDeleteDisk(t *testing.T) {
+	testcases := []struct {
+		name          string
+		diskSpec      Spec
+		expectedError string
+		expect        func(m *mock_disks.MockClientMockRecorder)
+	}{
+		{
+			name:     "delete the disk",
+			diskSpec: Spec{Name: "my-vm_OSDisk"},
+			expect: func(m *mock_disks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm_OSDisk").Return(compute.Disk{Name: to.StringPtr("my-vm_OSDisk")}, nil)
+				m.Delete(context.TODO(), "my-rg", "my-vm_OSDisk")
+			},
+		},
+		{
+			name:     "disk already deleted",
+			diskSpec: Spec{Name: "my-vm_OSDisk"},
+			expect: func(m *mock_disks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm_OSDisk").
+					Return(compute.Disk{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:     "disk deleted concurrently",
+			diskSpec: Spec{Name: "my-vm_OSDisk"},
+			expect: func(m *mock_disks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm_OSDisk").Return(compute.Disk{Name: to.StringPtr("my-vm_OSDisk")}, nil)
+				m.Delete(context.TODO(), "my-rg", "my-vm_OSDisk").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "disk still attached",
+			diskSpec:      Spec{Name: "my-vm_OSDisk"},
+			expectedError: "disk my-vm_OSDisk is still attached to vm /subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/virtualMachines/my-vm, retrying once it is detached",
+			expect: func(m *mock_disks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm_OSDisk").Return(compute.Disk{
+					Name:      to.StringPtr("my-vm_OSDisk"),
+					ManagedBy: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/virtualMachines/my-vm"),
+				}, nil)
+			},
+		},
+		{
+			name:          "fail to delete the disk",
+			diskSpec:      Spec{Name: "my-vm_OSDisk"},
+			expectedError: "failed to delete disk my-vm_OSDisk in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_disks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm_OSDisk").Return(compute.Disk{Name: to.StringPtr("my-vm_OSDisk")}, nil)
+				m.Delete(context.TODO(), "my-rg", "my-vm_OSDisk").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			disksMock := mock_disks.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(disksMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{ResourceGroup: "my-rg"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: disksMock,
+			}
+
+			err = s.Delete(context.TODO(), &tc.diskSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
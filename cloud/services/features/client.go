@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2015-12-01/features"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string) (features.Result, error)
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	features features.Client
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new features client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newFeaturesClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newFeaturesClient creates a new features client from subscription ID and base URI.
+func newFeaturesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) features.Client {
+	featuresClient := features.NewClientWithBaseURI(baseURI, subscriptionID)
+	featuresClient.Authorizer = authorizer
+	featuresClient.AddToUserAgent(azure.UserAgent)
+	return featuresClient
+}
+
+// Get gets a preview feature of a resource provider.
+func (ac *AzureClient) Get(ctx context.Context, resourceProviderNamespace, featureName string) (features.Result, error) {
+	var result features.Result
+	err := azure.CallAPI(ctx, "features", "Get", func() error {
+		var err error
+		result, err = ac.features.Get(ctx, resourceProviderNamespace, featureName)
+		return err
+	})
+	return result, err
+}
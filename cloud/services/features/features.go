@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2015-12-01/features"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+)
+
+// RegisteredState is the registration state of a preview feature registered in the subscription.
+const RegisteredState = "Registered"
+
+// Spec specification for a preview feature of a resource provider
+type Spec struct {
+	Namespace string
+	Name      string
+}
+
+// Get provides a preview feature of a resource provider.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	featureSpec, ok := spec.(*Spec)
+	if !ok {
+		return features.Result{}, errors.New("invalid feature specification")
+	}
+	feature, err := s.Client.Get(ctx, featureSpec.Namespace, featureSpec.Name)
+	if err != nil {
+		return feature, errors.Wrapf(err, "failed to get feature %s/%s", featureSpec.Namespace, featureSpec.Name)
+	}
+	return feature, nil
+}
+
+// Reconcile is a no-op, preview features have to be registered by the user.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	return nil
+}
+
+// Delete is a no-op, preview features have to be registered by the user.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	return nil
+}
+
+// IsRegistered returns whether a preview feature is registered in the subscription.
+func IsRegistered(feature features.Result) bool {
+	return feature.Properties != nil && to.String(feature.Properties.State) == RegisteredState
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package features
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2015-12-01/features"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/features/mock_features"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetFeature(t *testing.T) {
+	testcases := []struct {
+		name               string
+		featureSpec        Spec
+		expectedError      string
+		expectedRegistered bool
+		expect             func(m *mock_features.MockClientMockRecorder)
+	}{
+		{
+			name:               "feature is registered",
+			featureSpec:        Spec{Namespace: "Microsoft.Compute", Name: "EncryptionAtHost"},
+			expectedRegistered: true,
+			expect: func(m *mock_features.MockClientMockRecorder) {
+				m.Get(context.TODO(), "Microsoft.Compute", "EncryptionAtHost").Return(features.Result{
+					Properties: &features.Properties{State: to.StringPtr("Registered")},
+				}, nil)
+			},
+		},
+		{
+			name:        "feature is being registered",
+			featureSpec: Spec{Namespace: "Microsoft.Compute", Name: "EncryptionAtHost"},
+			expect: func(m *mock_features.MockClientMockRecorder) {
+				m.Get(context.TODO(), "Microsoft.Compute", "EncryptionAtHost").Return(features.Result{
+					Properties: &features.Properties{State: to.StringPtr("Registering")},
+				}, nil)
+			},
+		},
+		{
+			name:          "fail to get feature",
+			featureSpec:   Spec{Namespace: "Microsoft.Compute", Name: "EncryptionAtHost"},
+			expectedError: "failed to get feature Microsoft.Compute/EncryptionAtHost: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_features.MockClientMockRecorder) {
+				m.Get(context.TODO(), "Microsoft.Compute", "EncryptionAtHost").
+					Return(features.Result{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			featuresMock := mock_features.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(featuresMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:       client,
+				Cluster:      cluster,
+				AzureCluster: &infrav1.AzureCluster{},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: featuresMock,
+			}
+
+			feature, err := s.Get(context.TODO(), &tc.featureSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if registered := IsRegistered(feature.(features.Result)); registered != tc.expectedRegistered {
+				t.Errorf("expected registered %t, got %t", tc.expectedRegistered, registered)
+			}
+		})
+	}
+}
@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newGroupsClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newGroupsClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newGroupsClient creates a new groups client from subscription ID.
-func newGroupsClient(subscriptionID string, authorizer autorest.Authorizer) resources.GroupsClient {
-	groupsClient := resources.NewGroupsClient(subscriptionID)
+// newGroupsClient creates a new groups client from subscription ID and base URI.
+func newGroupsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) resources.GroupsClient {
+	groupsClient := resources.NewGroupsClientWithBaseURI(baseURI, subscriptionID)
 	groupsClient.Authorizer = authorizer
 	groupsClient.AddToUserAgent(azure.UserAgent)
 	return groupsClient
@@ -54,24 +54,38 @@ func newGroupsClient(subscriptionID string, authorizer autorest.Authorizer) reso
 
 // Get gets a resource group.
 func (ac *AzureClient) Get(ctx context.Context, name string) (resources.Group, error) {
-	return ac.groups.Get(ctx, name)
+	var result resources.Group
+	err := azure.CallAPI(ctx, "groups", "Get", func() error {
+		var err error
+		result, err = ac.groups.Get(ctx, name)
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a resource group.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, name string, group resources.Group) (resources.Group, error) {
-	return ac.groups.CreateOrUpdate(ctx, name, group)
+	var result resources.Group
+	err := azure.CallAPI(ctx, "groups", "CreateOrUpdate", func() error {
+		var err error
+		result, err = ac.groups.CreateOrUpdate(ctx, name, group)
+		return err
+	})
+	return result, err
 }
 
 // Delete deletes a resource group. When you delete a resource group, all of its resources are also deleted.
 func (ac *AzureClient) Delete(ctx context.Context, name string) error {
-	future, err := ac.groups.Delete(ctx, name)
-	if err != nil {
+	var future resources.GroupsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "groups", "Delete", func() error {
+		var err error
+		future, err = ac.groups.Delete(ctx, name)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.groups.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.groups.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.groups)
 		return err
-	}
-	_, err = future.Result(ac.groups)
-	return err
+	})
 }
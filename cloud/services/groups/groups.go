@@ -22,42 +22,96 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-05-01/resources"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
+// Spec specification for resource group
+type Spec struct {
+	Name     string
+	Location string
+}
+
 // Get provides information about a resource group.
 func (s *Service) Get(ctx context.Context, spec interface{}) (resources.Group, error) {
-	return s.Client.Get(ctx, s.Scope.ResourceGroup())
+	groupSpec, ok := spec.(*Spec)
+	if !ok {
+		return resources.Group{}, errors.New("invalid resource group specification")
+	}
+	return s.Client.Get(ctx, groupSpec.Name)
 }
 
-// Reconcile gets/creates/updates a resource group.
+// Reconcile gets/creates/updates a resource group. The resource group is created with the ownership tag of the
+// cluster and its additional tags. The additional tags of a resource group owned by the cluster are kept up to date,
+// merged with the tags added out of band. The tags of an external resource group, or of an existing resource group
+// the cluster does not own, are never modified.
 func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
-	if _, err := s.Get(ctx, spec); err == nil {
-		// resource group already exists, skip creation
+	groupSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid resource group specification")
+	}
+	if s.Scope.IsResourceGroupExternal() {
+		s.Scope.V(4).Info("Skipping resource group reconcile in external mode")
 		return nil
 	}
-	klog.V(2).Infof("creating resource group %s", s.Scope.ResourceGroup())
+	log := s.Scope.ResourceLogger(groupSpec.Name)
+	log.V(4).Info("reconciling resource group")
 	group := resources.Group{
-		Location: to.StringPtr(s.Scope.Location()),
-		Tags: converters.TagsToMap(infrav1.Build(infrav1.BuildParams{
-			ClusterName: s.Scope.Name(),
-			Lifecycle:   infrav1.ResourceLifecycleOwned,
-			Name:        to.StringPtr(s.Scope.ResourceGroup()),
-			Role:        to.StringPtr(infrav1.CommonRoleTagValue),
-			Additional:  s.Scope.AdditionalTags(),
-		})),
-	}
-	_, err := s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), group)
-	klog.V(2).Infof("successfully created resource group %s", s.Scope.ResourceGroup())
-	return err
+		Location: to.StringPtr(groupSpec.Location),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(groupSpec.Name, infrav1.CommonRoleTagValue)),
+	}
+	existing, err := s.Get(ctx, groupSpec)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get resource group %s", groupSpec.Name)
+	case err == nil:
+		existingTags := converters.MapToTags(existing.Tags)
+		if !existingTags.HasOwned(s.Scope.Name()) {
+			log.V(4).Info("resource group already exists and is not owned by the cluster")
+			return nil
+		}
+		tags, changed := existingTags.Merged(converters.MapToTags(group.Tags))
+		if !changed {
+			log.V(4).Info("resource group already exists")
+			return nil
+		}
+		group.Tags = converters.TagsToMap(tags)
+		if existing.Location != nil {
+			group.Location = existing.Location
+		}
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("resource group", groupSpec.Name, groupSpec.Name, group)) {
+		return nil
+	}
+
+	log.V(2).Info("creating or updating resource group")
+	if _, err := s.Client.CreateOrUpdate(ctx, groupSpec.Name, group); err != nil {
+		return errors.Wrapf(err, "failed to create or update resource group %s", groupSpec.Name)
+	}
+	log.V(2).Info("successfully created or updated resource group")
+	return nil
 }
 
 // Delete deletes the resource group with the provided name.
 func (s *Service) Delete(ctx context.Context, spec interface{}) error {
-	managed, err := s.isGroupManaged(ctx, spec)
+	groupSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid resource group specification")
+	}
+	if s.Scope.IsResourceGroupExternal() {
+		s.Scope.V(4).Info("Skipping resource group deletion in external mode")
+		return nil
+	}
+	log := s.Scope.ResourceLogger(groupSpec.Name)
+
+	managed, err := s.isGroupManaged(ctx, groupSpec)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("resource group is already deleted")
+		return nil
+	}
 	if err != nil {
 		return errors.Wrap(err, "could not get resource group management state")
 	}
@@ -66,21 +120,26 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 		s.Scope.V(4).Info("Skipping resource group deletion in unmanaged mode")
 		return nil
 	}
-	klog.V(2).Infof("deleting resource group %s", s.Scope.ResourceGroup())
-	err = s.Client.Delete(ctx, s.Scope.ResourceGroup())
+	if s.Scope.DryRun(scope.DeleteAction("resource group", groupSpec.Name, groupSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting resource group")
+	err = s.Client.Delete(ctx, groupSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("resource group is already deleted")
 		return nil
 	}
 	if err != nil {
-		return errors.Wrapf(err, "failed to delete resource group %s", s.Scope.ResourceGroup())
+		return errors.Wrapf(err, "failed to delete resource group %s", groupSpec.Name)
 	}
 
-	klog.V(2).Infof("successfully deleted resource group %s", s.Scope.ResourceGroup())
+	log.V(2).Info("successfully deleted resource group")
 	return nil
 }
 
-func (s *Service) isGroupManaged(ctx context.Context, spec interface{}) (bool, error) {
+func (s *Service) isGroupManaged(ctx context.Context, spec *Spec) (bool, error) {
 	group, err := s.Get(ctx, spec)
 	if err != nil {
 		return false, err
@@ -0,0 +1,298 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package groups
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-05-01/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/groups/mock_groups"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileGroups(t *testing.T) {
+	testcases := []struct {
+		name                  string
+		externalResourceGroup bool
+		additionalTags        infrav1.Tags
+		expectedError         string
+		expect                func(m *mock_groups.MockClientMockRecorder)
+	}{
+		{
+			name: "resource group does not exist",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").
+					Return(resources.Group{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", resources.Group{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+						"Name": to.StringPtr("my-rg"),
+					},
+				})
+			},
+		},
+		{
+			name:           "resource group does not exist with additional tags",
+			additionalTags: infrav1.Tags{"cost-center": "1234"},
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").
+					Return(resources.Group{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", resources.Group{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+						"Name":        to.StringPtr("my-rg"),
+						"cost-center": to.StringPtr("1234"),
+					},
+				})
+			},
+		},
+		{
+			name: "resource group already exists",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{Name: to.StringPtr("my-rg")}, nil)
+			},
+		},
+		{
+			name:           "owned resource group is up to date",
+			additionalTags: infrav1.Tags{"cost-center": "1234"},
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{
+					Name:     to.StringPtr("my-rg"),
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+						"Name":        to.StringPtr("my-rg"),
+						"cost-center": to.StringPtr("1234"),
+						"team":        to.StringPtr("added-out-of-band"),
+					},
+				}, nil)
+			},
+		},
+		{
+			name:           "additional tags are merged with the tags of an owned resource group",
+			additionalTags: infrav1.Tags{"cost-center": "5678"},
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{
+					Name:     to.StringPtr("my-rg"),
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+						"Name":        to.StringPtr("my-rg"),
+						"cost-center": to.StringPtr("1234"),
+						"team":        to.StringPtr("added-out-of-band"),
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", resources.Group{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+						"Name":        to.StringPtr("my-rg"),
+						"cost-center": to.StringPtr("5678"),
+						"team":        to.StringPtr("added-out-of-band"),
+					},
+				})
+			},
+		},
+		{
+			name:           "tags of a resource group not owned by the cluster are untouched",
+			additionalTags: infrav1.Tags{"cost-center": "1234"},
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{
+					Name: to.StringPtr("my-rg"),
+					Tags: map[string]*string{"owner": to.StringPtr("someone-else")},
+				}, nil)
+			},
+		},
+		{
+			name:                  "external resource group",
+			externalResourceGroup: true,
+			additionalTags:        infrav1.Tags{"cost-center": "1234"},
+			expect:                func(m *mock_groups.MockClientMockRecorder) {},
+		},
+		{
+			name:          "fail to get resource group",
+			expectedError: "failed to get resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").
+					Return(resources.Group{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			groupsMock := mock_groups.NewMockClient(mockCtrl)
+
+			tc.expect(groupsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:              "test-location",
+						ResourceGroup:         "my-rg",
+						ExternalResourceGroup: tc.externalResourceGroup,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			clusterScope.AzureCluster.Spec.AdditionalTags = tc.additionalTags
+			s := &Service{
+				Scope:  clusterScope,
+				Client: groupsMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{Name: "my-rg", Location: "test-location"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteGroups(t *testing.T) {
+	testcases := []struct {
+		name                  string
+		externalResourceGroup bool
+		expectedError         string
+		expect                func(m *mock_groups.MockClientMockRecorder)
+	}{
+		{
+			name: "delete managed resource group",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+					},
+				}, nil)
+				m.Delete(context.TODO(), "my-rg")
+			},
+		},
+		{
+			name: "skip unmanaged resource group",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{}, nil)
+			},
+		},
+		{
+			name:                  "skip external resource group",
+			externalResourceGroup: true,
+			expect:                func(m *mock_groups.MockClientMockRecorder) {},
+		},
+		{
+			name: "resource group already deleted",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").
+					Return(resources.Group{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "fail to delete resource group",
+			expectedError: "failed to delete resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_groups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg").Return(resources.Group{
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+					},
+				}, nil)
+				m.Delete(context.TODO(), "my-rg").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			groupsMock := mock_groups.NewMockClient(mockCtrl)
+
+			tc.expect(groupsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:              "test-location",
+						ResourceGroup:         "my-rg",
+						ExternalResourceGroup: tc.externalResourceGroup,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: groupsMock,
+			}
+
+			err = s.Delete(context.TODO(), &Spec{Name: "my-rg", Location: "test-location"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inboundnatrules
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+const (
+	// sshPort is the backend port of the inbound NAT rules.
+	sshPort = 22
+	// firstAlternateFrontendPort is the frontend port of the second inbound NAT rule of a load balancer, the first one
+	// uses the SSH port. The frontend ports of the next rules follow it.
+	firstAlternateFrontendPort = 2201
+)
+
+// Spec specification for inbound NAT rule
+type Spec struct {
+	Name             string
+	LoadBalancerName string
+}
+
+// Get provides information about an inbound NAT rule.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	ruleSpec, ok := spec.(*Spec)
+	if !ok {
+		return network.InboundNatRule{}, errors.New("invalid inbound NAT rule specification")
+	}
+	rule, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), ruleSpec.LoadBalancerName, ruleSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "inbound NAT rule %s of load balancer %s not found", ruleSpec.Name, ruleSpec.LoadBalancerName)
+	} else if err != nil {
+		return rule, err
+	}
+	return rule, nil
+}
+
+// Reconcile creates an inbound NAT rule from a frontend port of the load balancer not used by its other rules to the
+// SSH port. The frontend port of an existing rule does not change.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	ruleSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid inbound NAT rule specification")
+	}
+	log := s.Scope.ResourceLogger(ruleSpec.Name).WithValues("loadBalancer", ruleSpec.LoadBalancerName)
+	log.V(4).Info("reconciling inbound NAT rule")
+
+	lb, err := s.LoadBalancersClient.Get(ctx, s.Scope.ResourceGroup(), ruleSpec.LoadBalancerName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get load balancer %s", ruleSpec.LoadBalancerName)
+	}
+	if lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil || len(*lb.FrontendIPConfigurations) == 0 {
+		return errors.Errorf("load balancer %s has no frontend IP configuration", ruleSpec.LoadBalancerName)
+	}
+
+	usedPorts := map[int32]bool{}
+	if lb.InboundNatRules != nil {
+		for _, rule := range *lb.InboundNatRules {
+			if to.String(rule.Name) == ruleSpec.Name {
+				log.V(4).Info("inbound NAT rule already exists")
+				return nil
+			}
+			if rule.InboundNatRulePropertiesFormat != nil {
+				usedPorts[to.Int32(rule.FrontendPort)] = true
+			}
+		}
+	}
+	frontendPort := getFreeFrontendPort(usedPorts)
+
+	rule := network.InboundNatRule{
+		Name: to.StringPtr(ruleSpec.Name),
+		InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
+			Protocol:             network.TransportProtocolTCP,
+			FrontendPort:         to.Int32Ptr(frontendPort),
+			BackendPort:          to.Int32Ptr(sshPort),
+			EnableFloatingIP:     to.BoolPtr(false),
+			IdleTimeoutInMinutes: to.Int32Ptr(4),
+			FrontendIPConfiguration: &network.SubResource{
+				ID: (*lb.FrontendIPConfigurations)[0].ID,
+			},
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("inbound NAT rule", s.Scope.ResourceGroup(), ruleSpec.Name, rule)) {
+		return nil
+	}
+
+	log.V(2).Info("creating inbound NAT rule", "frontendPort", frontendPort)
+	err = s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), ruleSpec.LoadBalancerName, ruleSpec.Name, rule)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create inbound NAT rule %s of load balancer %s", ruleSpec.Name, ruleSpec.LoadBalancerName)
+	}
+
+	log.V(2).Info("successfully created inbound NAT rule")
+	return nil
+}
+
+// Delete deletes the inbound NAT rule with the provided name.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	ruleSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid inbound NAT rule specification")
+	}
+	log := s.Scope.ResourceLogger(ruleSpec.Name).WithValues("loadBalancer", ruleSpec.LoadBalancerName)
+	if s.Scope.DryRun(scope.DeleteAction("inbound NAT rule", s.Scope.ResourceGroup(), ruleSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting inbound NAT rule")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), ruleSpec.LoadBalancerName, ruleSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("inbound NAT rule is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete inbound NAT rule %s of load balancer %s", ruleSpec.Name, ruleSpec.LoadBalancerName)
+	}
+
+	log.V(2).Info("successfully deleted inbound NAT rule")
+	return nil
+}
+
+// getFreeFrontendPort returns the SSH port if it is free, or else the lowest free port from the first alternate
+// frontend port.
+func getFreeFrontendPort(usedPorts map[int32]bool) int32 {
+	if !usedPorts[sshPort] {
+		return sshPort
+	}
+	port := int32(firstAlternateFrontendPort)
+	for usedPorts[port] {
+		port++
+	}
+	return port
+}
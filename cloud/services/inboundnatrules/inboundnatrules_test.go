@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inboundnatrules
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/inboundnatrules/mock_inboundnatrules"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers/mock_publicloadbalancers"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const frontendIPConfigID = "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-lb/frontendIPConfigurations/controlplane-lbFrontEnd"
+
+// loadBalancer returns a load balancer with inbound NAT rules with the given names and frontend ports.
+func loadBalancer(rules map[string]int32) network.LoadBalancer {
+	natRules := []network.InboundNatRule{}
+	for name, port := range rules {
+		natRules = append(natRules, network.InboundNatRule{
+			Name:                           to.StringPtr(name),
+			InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{FrontendPort: to.Int32Ptr(port)},
+		})
+	}
+	return network.LoadBalancer{
+		Name: to.StringPtr("my-lb"),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			FrontendIPConfigurations: &[]network.FrontendIPConfiguration{{ID: to.StringPtr(frontendIPConfigID)}},
+			InboundNatRules:          &natRules,
+		},
+	}
+}
+
+// natRule returns the inbound NAT rule of the machine with the given frontend port.
+func natRule(frontendPort int32) network.InboundNatRule {
+	return network.InboundNatRule{
+		Name: to.StringPtr("my-machine"),
+		InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
+			Protocol:                network.TransportProtocolTCP,
+			FrontendPort:            to.Int32Ptr(frontendPort),
+			BackendPort:             to.Int32Ptr(22),
+			EnableFloatingIP:        to.BoolPtr(false),
+			IdleTimeoutInMinutes:    to.Int32Ptr(4),
+			FrontendIPConfiguration: &network.SubResource{ID: to.StringPtr(frontendIPConfigID)},
+		},
+	}
+}
+
+func TestReconcileInboundNatRule(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder)
+	}{
+		{
+			name: "first inbound NAT rule uses the SSH port",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").Return(loadBalancer(nil), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", "my-machine", natRule(22))
+			},
+		},
+		{
+			name: "second inbound NAT rule uses the first alternate port",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").Return(loadBalancer(map[string]int32{"other-machine-0": 22}), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", "my-machine", natRule(2201))
+			},
+		},
+		{
+			name: "inbound NAT rule uses the lowest free port",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").Return(loadBalancer(map[string]int32{
+					"other-machine-0": 22,
+					"other-machine-1": 2201,
+					"other-machine-3": 2203,
+				}), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", "my-machine", natRule(2202))
+			},
+		},
+		{
+			name: "inbound NAT rule uses the SSH port after its rule is deleted",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").Return(loadBalancer(map[string]int32{"other-machine-1": 2201}), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", "my-machine", natRule(22))
+			},
+		},
+		{
+			name: "inbound NAT rule already exists",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").Return(loadBalancer(map[string]int32{"other-machine-0": 22, "my-machine": 2201}), nil)
+			},
+		},
+		{
+			name:          "load balancer does not exist",
+			expectedError: "failed to get load balancer my-lb: #: Not found: StatusCode=404",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").
+					Return(network.LoadBalancer{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "fail to create inbound NAT rule",
+			expectedError: "failed to create inbound NAT rule my-machine of load balancer my-lb: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				mLB.Get(context.TODO(), "my-rg", "my-lb").Return(loadBalancer(nil), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", "my-machine", natRule(22)).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			inboundNatRulesMock := mock_inboundnatrules.NewMockClient(mockCtrl)
+			lbMock := mock_publicloadbalancers.NewMockClient(mockCtrl)
+			tc.expect(inboundNatRulesMock.EXPECT(), lbMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:               clusterScope,
+				Client:              inboundNatRulesMock,
+				LoadBalancersClient: lbMock,
+			}
+			err = s.Reconcile(context.TODO(), &Spec{Name: "my-machine", LoadBalancerName: "my-lb"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteInboundNatRule(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_inboundnatrules.MockClientMockRecorder)
+	}{
+		{
+			name: "inbound NAT rule exists",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-lb", "my-machine")
+			},
+		},
+		{
+			name: "inbound NAT rule already deleted",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-lb", "my-machine").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "inbound NAT rule still in use",
+			expectedError: "failed to delete inbound NAT rule my-machine of load balancer my-lb: #: Conflict: StatusCode=409",
+			expect: func(m *mock_inboundnatrules.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-lb", "my-machine").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 409}, "Conflict"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			inboundNatRulesMock := mock_inboundnatrules.NewMockClient(mockCtrl)
+			tc.expect(inboundNatRulesMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: inboundNatRulesMock,
+			}
+			err = s.Delete(context.TODO(), &Spec{Name: "my-machine", LoadBalancerName: "my-lb"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
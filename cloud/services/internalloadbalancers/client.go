@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newLoadBalancersClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newLoadBalancersClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newLoadbalancersClient creates a new load balancer client from subscription ID.
-func newLoadBalancersClient(subscriptionID string, authorizer autorest.Authorizer) network.LoadBalancersClient {
-	loadBalancersClient := network.NewLoadBalancersClient(subscriptionID)
+// newLoadbalancersClient creates a new load balancer client from subscription ID and base URI.
+func newLoadBalancersClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.LoadBalancersClient {
+	loadBalancersClient := network.NewLoadBalancersClientWithBaseURI(baseURI, subscriptionID)
 	loadBalancersClient.Authorizer = authorizer
 	loadBalancersClient.AddToUserAgent(azure.UserAgent)
 	return loadBalancersClient
@@ -54,33 +54,43 @@ func newLoadBalancersClient(subscriptionID string, authorizer autorest.Authorize
 
 // Get gets the specified load balancer.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, lbName string) (network.LoadBalancer, error) {
-	return ac.loadbalancers.Get(ctx, resourceGroupName, lbName, "")
+	var result network.LoadBalancer
+	err := azure.CallAPI(ctx, "internalloadbalancers", "Get", func() error {
+		var err error
+		result, err = ac.loadbalancers.Get(ctx, resourceGroupName, lbName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a load balancer.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, lbName string, lb network.LoadBalancer) error {
-	future, err := ac.loadbalancers.CreateOrUpdate(ctx, resourceGroupName, lbName, lb)
-	if err != nil {
+	var future network.LoadBalancersCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "internalloadbalancers", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.loadbalancers.CreateOrUpdate(ctx, resourceGroupName, lbName, lb)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.loadbalancers.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.loadbalancers.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.loadbalancers)
 		return err
-	}
-	_, err = future.Result(ac.loadbalancers)
-	return err
+	})
 }
 
 // Delete deletes the specified load balancer.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, lbName string) error {
-	future, err := ac.loadbalancers.Delete(ctx, resourceGroupName, lbName)
-	if err != nil {
+	var future network.LoadBalancersDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "internalloadbalancers", "Delete", func() error {
+		var err error
+		future, err = ac.loadbalancers.Delete(ctx, resourceGroupName, lbName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.loadbalancers.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.loadbalancers.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.loadbalancers)
 		return err
-	}
-	_, err = future.Result(ac.loadbalancers)
-	return err
+	})
 }
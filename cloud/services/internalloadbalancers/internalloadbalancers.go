@@ -19,12 +19,15 @@ package internalloadbalancers
 import (
 	"context"
 	"fmt"
+	"net"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for internal load balancer
@@ -33,7 +36,18 @@ type Spec struct {
 	SubnetName string
 	SubnetCidr string
 	VnetName   string
-	IPAddress  string
+	// IPAddress is the preferred private IP address of the frontend of a new load balancer, which gets another
+	// available IP address of the subnet when it is taken.
+	IPAddress string
+	// StaticIPAddress is the private IP address of the frontend of the load balancer, which must be within the subnet
+	// CIDR. It takes precedence over IPAddress and over the private IP address of an existing load balancer.
+	StaticIPAddress string
+	// SKU is the SKU of the load balancer. Defaults to Standard.
+	SKU network.LoadBalancerSkuName
+	// Probe is the health probe of the load balancer. Defaults to a TCP probe of the API server port.
+	Probe *network.ProbePropertiesFormat
+	// IdleTimeoutInMinutes is the idle timeout of the load balancing rule, between 4 and 30 minutes. Defaults to 4.
+	IdleTimeoutInMinutes *int32
 }
 
 // Get provides information about an internal load balancer.
@@ -51,103 +65,148 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid internal load balancer specification")
 	}
-	klog.V(2).Infof("creating internal load balancer %s", internalLBSpec.Name)
+	log := s.Scope.ResourceLogger(internalLBSpec.Name)
+	log.V(2).Info("creating internal load balancer")
 	probeName := "tcpHTTPSProbe"
 	frontEndIPConfigName := "controlplane-internal-lbFrontEnd"
-	backEndAddressPoolName := "controlplane-internal-backEndPool"
+	backEndAddressPoolName := azure.ControlPlaneInternalBackendPoolName
 	idPrefix := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/loadBalancers", s.Scope.SubscriptionID, s.Scope.ResourceGroup())
 	lbName := internalLBSpec.Name
+	sku := internalLBSpec.SKU
+	if sku == "" {
+		sku = network.LoadBalancerSkuNameStandard
+	}
+	idleTimeout := int32(azure.DefaultLoadBalancerIdleTimeoutInMinutes)
+	if internalLBSpec.IdleTimeoutInMinutes != nil {
+		idleTimeout = *internalLBSpec.IdleTimeoutInMinutes
+	}
+	if idleTimeout < azure.MinLoadBalancerIdleTimeoutInMinutes || idleTimeout > azure.MaxLoadBalancerIdleTimeoutInMinutes {
+		return errors.Errorf("invalid idle timeout of %d minutes for load balancer %s, must be between %d and %d minutes",
+			idleTimeout, lbName, azure.MinLoadBalancerIdleTimeoutInMinutes, azure.MaxLoadBalancerIdleTimeoutInMinutes)
+	}
+	probe := internalLBSpec.Probe
+	if probe == nil {
+		probe = &network.ProbePropertiesFormat{
+			Protocol:          network.ProbeProtocolTCP,
+			Port:              to.Int32Ptr(s.Scope.APIServerPort()),
+			IntervalInSeconds: to.Int32Ptr(15),
+			NumberOfProbes:    to.Int32Ptr(4),
+		}
+	}
+	if internalLBSpec.StaticIPAddress != "" {
+		if err := validateStaticIPAddress(internalLBSpec); err != nil {
+			return err
+		}
+	}
 	var privateIP string
+	tags := s.Scope.ResourceTags(lbName, infrav1.APIServerRoleTagValue)
 
 	internalLB, err := s.Get(ctx, internalLBSpec)
 	if err == nil {
+		// Keep the tags added to the load balancer out of band.
+		existingTags := converters.MapToTags(internalLB.Tags)
+		existingTags.Merge(tags)
+		tags = existingTags
 		ipConfigs := internalLB.LoadBalancerPropertiesFormat.FrontendIPConfigurations
 		if ipConfigs != nil && len(*ipConfigs) > 0 {
 			privateIP = to.String((*ipConfigs)[0].FrontendIPConfigurationPropertiesFormat.PrivateIPAddress)
 		}
 	} else if azure.ResourceNotFound(err) {
-		klog.V(2).Infof("internalLB %s not found in RG %s", internalLBSpec.Name, s.Scope.ResourceGroup())
-		privateIP, err = s.getAvailablePrivateIP(ctx, s.Scope.Vnet().ResourceGroup, internalLBSpec.VnetName, internalLBSpec.SubnetCidr, internalLBSpec.IPAddress)
-		if err != nil {
-			return err
+		log.V(2).Info("internal load balancer not found")
+		if internalLBSpec.StaticIPAddress == "" {
+			privateIP, err = s.getAvailablePrivateIP(ctx, s.Scope.Vnet().ResourceGroup, internalLBSpec.VnetName, internalLBSpec.SubnetCidr, internalLBSpec.IPAddress)
+			if err != nil {
+				return err
+			}
 		}
-		klog.V(2).Infof("setting internal load balancer IP to %s", privateIP)
 	} else {
 		return errors.Wrap(err, "failed to look for existing internal LB")
 	}
+	if internalLBSpec.StaticIPAddress != "" {
+		privateIP = internalLBSpec.StaticIPAddress
+	}
+	log.V(2).Info("setting internal load balancer IP", "privateIP", privateIP)
 
-	klog.V(2).Infof("getting subnet %s", internalLBSpec.SubnetName)
+	log.V(4).Info("getting subnet", "subnet", internalLBSpec.SubnetName)
 	subnet, err := s.SubnetsClient.Get(ctx, s.Scope.Vnet().ResourceGroup, internalLBSpec.VnetName, internalLBSpec.SubnetName)
 	if err != nil {
 		return errors.Wrap(err, "failed to get subnet")
 	}
 
-	klog.V(2).Infof("successfully got subnet %s", internalLBSpec.SubnetName)
+	log.V(4).Info("successfully got subnet", "subnet", internalLBSpec.SubnetName)
 
-	// https://docs.microsoft.com/en-us/azure/load-balancer/load-balancer-standard-availability-zones#zone-redundant-by-default
-	err = s.Client.CreateOrUpdate(ctx,
-		s.Scope.ResourceGroup(),
-		lbName,
-		network.LoadBalancer{
-			Sku:      &network.LoadBalancerSku{Name: network.LoadBalancerSkuNameStandard},
-			Location: to.StringPtr(s.Scope.Location()),
-			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
-				FrontendIPConfigurations: &[]network.FrontendIPConfiguration{
-					{
-						Name: &frontEndIPConfigName,
-						FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
-							PrivateIPAllocationMethod: network.Static,
-							Subnet:                    &subnet,
-							PrivateIPAddress:          to.StringPtr(privateIP),
-						},
+	loadBalancer := network.LoadBalancer{
+		Sku:      &network.LoadBalancerSku{Name: sku},
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(tags),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			FrontendIPConfigurations: &[]network.FrontendIPConfiguration{
+				{
+					Name: &frontEndIPConfigName,
+					FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+						PrivateIPAllocationMethod: network.Static,
+						Subnet:                    &subnet,
+						PrivateIPAddress:          to.StringPtr(privateIP),
 					},
 				},
-				BackendAddressPools: &[]network.BackendAddressPool{
-					{
-						Name: &backEndAddressPoolName,
-					},
+			},
+			BackendAddressPools: &[]network.BackendAddressPool{
+				{
+					Name: &backEndAddressPoolName,
 				},
-				Probes: &[]network.Probe{
-					{
-						Name: &probeName,
-						ProbePropertiesFormat: &network.ProbePropertiesFormat{
-							Protocol:          network.ProbeProtocolTCP,
-							Port:              to.Int32Ptr(s.Scope.APIServerPort()),
-							IntervalInSeconds: to.Int32Ptr(15),
-							NumberOfProbes:    to.Int32Ptr(4),
-						},
-					},
+			},
+			Probes: &[]network.Probe{
+				{
+					Name:                  &probeName,
+					ProbePropertiesFormat: probe,
 				},
-				LoadBalancingRules: &[]network.LoadBalancingRule{
-					{
-						Name: to.StringPtr("LBRuleHTTPS"),
-						LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
-							Protocol:             network.TransportProtocolTCP,
-							FrontendPort:         to.Int32Ptr(s.Scope.APIServerPort()),
-							BackendPort:          to.Int32Ptr(s.Scope.APIServerPort()),
-							IdleTimeoutInMinutes: to.Int32Ptr(4),
-							EnableFloatingIP:     to.BoolPtr(false),
-							LoadDistribution:     network.LoadDistributionDefault,
-							FrontendIPConfiguration: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
-							},
-							BackendAddressPool: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, backEndAddressPoolName)),
-							},
-							Probe: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/probes/%s", idPrefix, lbName, probeName)),
-							},
+			},
+			LoadBalancingRules: &[]network.LoadBalancingRule{
+				{
+					Name: to.StringPtr("LBRuleHTTPS"),
+					LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
+						Protocol:             network.TransportProtocolTCP,
+						FrontendPort:         to.Int32Ptr(s.Scope.APIServerPort()),
+						BackendPort:          to.Int32Ptr(s.Scope.APIServerPort()),
+						IdleTimeoutInMinutes: to.Int32Ptr(idleTimeout),
+						EnableFloatingIP:     to.BoolPtr(false),
+						LoadDistribution:     network.LoadDistributionDefault,
+						FrontendIPConfiguration: &network.SubResource{
+							ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
+						},
+						BackendAddressPool: &network.SubResource{
+							ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, backEndAddressPoolName)),
+						},
+						Probe: &network.SubResource{
+							ID: to.StringPtr(fmt.Sprintf("/%s/%s/probes/%s", idPrefix, lbName, probeName)),
 						},
 					},
 				},
 			},
-		})
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("internal load balancer", s.Scope.ResourceGroup(), lbName, loadBalancer)) {
+		return nil
+	}
+
+	// https://docs.microsoft.com/en-us/azure/load-balancer/load-balancer-standard-availability-zones#zone-redundant-by-default
+	err = s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), lbName, loadBalancer)
 
 	if err != nil {
 		return errors.Wrap(err, "cannot create load balancer")
 	}
 
-	klog.V(2).Infof("successfully created internal load balancer %s", internalLBSpec.Name)
+	if s.Scope.IsAPIServerInternal() {
+		s.Scope.Network().APIServerLB = infrav1.LoadBalancer{
+			Name: lbName,
+			SKU:  infrav1.SKU(sku),
+			FrontendIPConfig: infrav1.FrontendIPConfig{
+				PrivateIPAddress: privateIP,
+			},
+		}
+	}
+
+	log.V(2).Info("successfully created internal load balancer")
 	return err
 }
 
@@ -157,16 +216,40 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid internal load balancer specification")
 	}
-	klog.V(2).Infof("deleting internal load balancer %s", internalLBSpec.Name)
+	log := s.Scope.ResourceLogger(internalLBSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("internal load balancer", s.Scope.ResourceGroup(), internalLBSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting internal load balancer")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), internalLBSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("internal load balancer is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete internal load balancer %s in resource group %s", internalLBSpec.Name, s.Scope.ResourceGroup())
 	}
-	klog.V(2).Infof("successfully deleted internal load balancer %s", internalLBSpec.Name)
+	log.V(2).Info("successfully deleted internal load balancer")
+	return nil
+}
+
+// validateStaticIPAddress returns an error if the static private IP address of the load balancer is not an IPv4
+// address within the subnet CIDR.
+func validateStaticIPAddress(internalLBSpec *Spec) error {
+	ip := net.ParseIP(internalLBSpec.StaticIPAddress)
+	if ip == nil || ip.To4() == nil {
+		return errors.Errorf("static private IP %s of internal load balancer %s is not an IPv4 address", internalLBSpec.StaticIPAddress, internalLBSpec.Name)
+	}
+	_, subnetCIDR, err := net.ParseCIDR(internalLBSpec.SubnetCidr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse CIDR of subnet %s", internalLBSpec.SubnetName)
+	}
+	if !subnetCIDR.Contains(ip) {
+		return errors.Errorf("static private IP %s of internal load balancer %s is not within the CIDR %s of subnet %s",
+			internalLBSpec.StaticIPAddress, internalLBSpec.Name, internalLBSpec.SubnetCidr, internalLBSpec.SubnetName)
+	}
 	return nil
 }
 
@@ -18,7 +18,9 @@ package internalloadbalancers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/Azure/go-autorest/autorest/to"
@@ -40,10 +42,12 @@ import (
 
 func TestReconcileInternalLoadBalancer(t *testing.T) {
 	testcases := []struct {
-		name           string
-		internalLBSpec Spec
-		expectedError  string
-		expect         func(m *mock_internalloadbalancers.MockClientMockRecorder,
+		name                string
+		internalLBSpec      Spec
+		apiServerLBType     infrav1.LoadBalancerType
+		expectedError       string
+		expectedAPIServerLB infrav1.LoadBalancer
+		expect              func(m *mock_internalloadbalancers.MockClientMockRecorder,
 			mVnet *mock_virtualnetworks.MockClientMockRecorder,
 			mSubnet *mock_subnets.MockClientMockRecorder)
 	}{
@@ -57,6 +61,31 @@ func TestReconcileInternalLoadBalancer(t *testing.T) {
 				IPAddress:  "10.0.0.10",
 			},
 			expectedError: "",
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				mVnet.CheckIPAddressAvailability(context.TODO(), "my-rg", "my-vnet", "10.0.0.10").Return(network.IPAddressAvailabilityResult{Available: to.BoolPtr(true)}, nil)
+				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbSKUMatcher(network.LoadBalancerSkuNameStandard))
+			},
+		},
+		{
+			name: "internal load balancer of an internal API server",
+			internalLBSpec: Spec{
+				Name:       "my-lb",
+				SubnetCidr: "10.0.0.0/16",
+				SubnetName: "my-subnet",
+				VnetName:   "my-vnet",
+				IPAddress:  "10.0.0.10",
+			},
+			apiServerLBType: infrav1.LoadBalancerTypeInternal,
+			expectedError:   "",
+			expectedAPIServerLB: infrav1.LoadBalancer{
+				Name:             "my-lb",
+				SKU:              infrav1.SKUStandard,
+				FrontendIPConfig: infrav1.FrontendIPConfig{PrivateIPAddress: "10.0.0.10"},
+			},
 			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
 				mVnet *mock_virtualnetworks.MockClientMockRecorder,
 				mSubnet *mock_subnets.MockClientMockRecorder) {
@@ -66,6 +95,31 @@ func TestReconcileInternalLoadBalancer(t *testing.T) {
 				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", gomock.AssignableToTypeOf(network.LoadBalancer{}))
 			},
 		},
+		{
+			name: "basic internal load balancer of an internal API server",
+			internalLBSpec: Spec{
+				Name:       "my-lb",
+				SubnetCidr: "10.0.0.0/16",
+				SubnetName: "my-subnet",
+				VnetName:   "my-vnet",
+				IPAddress:  "10.0.0.10",
+				SKU:        network.LoadBalancerSkuNameBasic,
+			},
+			apiServerLBType: infrav1.LoadBalancerTypeInternal,
+			expectedAPIServerLB: infrav1.LoadBalancer{
+				Name:             "my-lb",
+				SKU:              infrav1.SKUBasic,
+				FrontendIPConfig: infrav1.FrontendIPConfig{PrivateIPAddress: "10.0.0.10"},
+			},
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				mVnet.CheckIPAddressAvailability(context.TODO(), "my-rg", "my-vnet", "10.0.0.10").Return(network.IPAddressAvailabilityResult{Available: to.BoolPtr(true)}, nil)
+				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbSKUMatcher(network.LoadBalancerSkuNameBasic))
+			},
+		},
 		{
 			name: "internal load balancer retrieval fails",
 			internalLBSpec: Spec{
@@ -102,11 +156,118 @@ func TestReconcileInternalLoadBalancer(t *testing.T) {
 								FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{},
 							},
 						}}}, nil)
-				mVnet.CheckIPAddressAvailability(context.TODO(), "my-rg", "my-vnet", "10.0.0.10").Return(network.IPAddressAvailabilityResult{Available: to.BoolPtr(true)}, nil)
 				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
 				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", gomock.AssignableToTypeOf(network.LoadBalancer{}))
 			},
 		},
+		{
+			name: "internal load balancer with tags added out of band",
+			internalLBSpec: Spec{
+				Name:       "my-lb",
+				SubnetCidr: "10.0.0.0/16",
+				SubnetName: "my-subnet",
+				VnetName:   "my-vnet",
+				IPAddress:  "10.0.0.10",
+			},
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{
+					Tags: map[string]*string{"foo": to.StringPtr("bar")},
+					LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+						FrontendIPConfigurations: &[]network.FrontendIPConfiguration{
+							{
+								FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{},
+							},
+						}}}, nil)
+				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbTagsMatcher{
+					"foo":  "bar",
+					"Name": "my-lb",
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+					"sigs.k8s.io_cluster-api-provider-azure_role":                 "apiserver",
+				})
+			},
+		},
+		{
+			name: "internal load balancer with an available private IP",
+			internalLBSpec: Spec{
+				Name:       "my-lb",
+				SubnetCidr: "10.0.0.0/16",
+				SubnetName: "my-subnet",
+				VnetName:   "my-vnet",
+				IPAddress:  "10.0.0.10",
+			},
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				mVnet.CheckIPAddressAvailability(context.TODO(), "my-rg", "my-vnet", "10.0.0.10").Return(network.IPAddressAvailabilityResult{
+					Available:            to.BoolPtr(false),
+					AvailableIPAddresses: &[]string{"10.0.0.11"},
+				}, nil)
+				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", frontendIPMatcher("10.0.0.11"))
+			},
+		},
+		{
+			name: "internal load balancer with a static private IP",
+			internalLBSpec: Spec{
+				Name:            "my-lb",
+				SubnetCidr:      "10.0.0.0/16",
+				SubnetName:      "my-subnet",
+				VnetName:        "my-vnet",
+				IPAddress:       "10.0.0.10",
+				StaticIPAddress: "10.0.0.50",
+			},
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", frontendIPMatcher("10.0.0.50"))
+			},
+		},
+		{
+			name: "static private IP replaces the private IP of an existing internal load balancer",
+			internalLBSpec: Spec{
+				Name:            "my-lb",
+				SubnetCidr:      "10.0.0.0/16",
+				SubnetName:      "my-subnet",
+				VnetName:        "my-vnet",
+				StaticIPAddress: "10.0.0.50",
+			},
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{
+					LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+						FrontendIPConfigurations: &[]network.FrontendIPConfiguration{
+							{
+								FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+									PrivateIPAddress: to.StringPtr("10.0.0.10"),
+								},
+							},
+						}}}, nil)
+				mSubnet.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", frontendIPMatcher("10.0.0.50"))
+			},
+		},
+		{
+			name: "static private IP outside of the subnet",
+			internalLBSpec: Spec{
+				Name:            "my-lb",
+				SubnetCidr:      "10.0.0.0/16",
+				SubnetName:      "my-subnet",
+				VnetName:        "my-vnet",
+				StaticIPAddress: "10.1.0.50",
+			},
+			expectedError: "static private IP 10.1.0.50 of internal load balancer my-lb is not within the CIDR 10.0.0.0/16 of subnet my-subnet",
+			expect: func(m *mock_internalloadbalancers.MockClientMockRecorder,
+				mVnet *mock_virtualnetworks.MockClientMockRecorder,
+				mSubnet *mock_subnets.MockClientMockRecorder) {
+			},
+		},
 		{
 			name: "internal load balancer does not exist and IP is not available",
 			internalLBSpec: Spec{
@@ -147,6 +308,7 @@ func TestReconcileInternalLoadBalancer(t *testing.T) {
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			internalLBMock := mock_internalloadbalancers.NewMockClient(mockCtrl)
 			subnetMock := mock_subnets.NewMockClient(mockCtrl)
 			vnetMock := mock_virtualnetworks.NewMockClient(mockCtrl)
@@ -176,6 +338,7 @@ func TestReconcileInternalLoadBalancer(t *testing.T) {
 								Name: "my-subnet",
 								Role: infrav1.SubnetNode,
 							}},
+							APIServerLB: infrav1.LoadBalancerSpec{Type: tc.apiServerLBType},
 						},
 					},
 				},
@@ -201,10 +364,61 @@ func TestReconcileInternalLoadBalancer(t *testing.T) {
 
 				}
 			}
+			if !reflect.DeepEqual(clusterScope.Network().APIServerLB, tc.expectedAPIServerLB) {
+				t.Errorf("expected api server load balancer %+v, got %+v", tc.expectedAPIServerLB, clusterScope.Network().APIServerLB)
+			}
 		})
 	}
 }
 
+// lbSKUMatcher matches a load balancer with the given SKU.
+type lbSKUMatcher network.LoadBalancerSkuName
+
+func (m lbSKUMatcher) Matches(x interface{}) bool {
+	lb, ok := x.(network.LoadBalancer)
+	return ok && lb.Sku != nil && lb.Sku.Name == network.LoadBalancerSkuName(m)
+}
+
+func (m lbSKUMatcher) String() string {
+	return fmt.Sprintf("is a load balancer with SKU %s", string(m))
+}
+
+// frontendIPMatcher matches a load balancer with a single frontend with the given static private IP.
+type frontendIPMatcher string
+
+func (m frontendIPMatcher) Matches(x interface{}) bool {
+	lb, ok := x.(network.LoadBalancer)
+	if !ok || lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil || len(*lb.FrontendIPConfigurations) != 1 {
+		return false
+	}
+	frontend := (*lb.FrontendIPConfigurations)[0].FrontendIPConfigurationPropertiesFormat
+	return frontend != nil && frontend.PrivateIPAllocationMethod == network.Static && to.String(frontend.PrivateIPAddress) == string(m)
+}
+
+func (m frontendIPMatcher) String() string {
+	return fmt.Sprintf("is a load balancer with the static frontend private IP %s", string(m))
+}
+
+// lbTagsMatcher matches a load balancer with exactly the given tags.
+type lbTagsMatcher map[string]string
+
+func (m lbTagsMatcher) Matches(x interface{}) bool {
+	lb, ok := x.(network.LoadBalancer)
+	if !ok || len(lb.Tags) != len(m) {
+		return false
+	}
+	for k, v := range m {
+		if value, ok := lb.Tags[k]; !ok || to.String(value) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (m lbTagsMatcher) String() string {
+	return fmt.Sprintf("is a load balancer with tags %v", map[string]string(m))
+}
+
 func TestDeleteInternalLB(t *testing.T) {
 	testcases := []struct {
 		name           string
@@ -261,6 +475,7 @@ func TestDeleteInternalLB(t *testing.T) {
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			internalLBMock := mock_internalloadbalancers.NewMockClient(mockCtrl)
 
 			cluster := &clusterv1.Cluster{
@@ -34,8 +34,8 @@ type Service struct {
 func NewService(scope *scope.ClusterScope) *Service {
 	return &Service{
 		Scope:                 scope,
-		Client:                NewClient(scope.SubscriptionID, scope.Authorizer),
-		SubnetsClient:         subnets.NewClient(scope.SubscriptionID, scope.Authorizer),
-		VirtualNetworksClient: virtualnetworks.NewClient(scope.SubscriptionID, scope.Authorizer),
+		Client:                NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		SubnetsClient:         subnets.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		VirtualNetworksClient: virtualnetworks.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
 	}
 }
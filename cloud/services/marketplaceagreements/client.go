@@ -0,0 +1,62 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package marketplaceagreements
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/marketplaceordering/mgmt/2015-06-01/marketplaceordering"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string, string) (marketplaceordering.AgreementTerms, error)
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	agreements marketplaceordering.MarketplaceAgreementsClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new marketplace agreements client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newMarketplaceAgreementsClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newMarketplaceAgreementsClient creates a new marketplace agreements client from subscription ID and base URI.
+func newMarketplaceAgreementsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) marketplaceordering.MarketplaceAgreementsClient {
+	agreementsClient := marketplaceordering.NewMarketplaceAgreementsClientWithBaseURI(baseURI, subscriptionID)
+	agreementsClient.Authorizer = authorizer
+	agreementsClient.AddToUserAgent(azure.UserAgent)
+	return agreementsClient
+}
+
+// Get gets the marketplace terms of an image plan.
+func (ac *AzureClient) Get(ctx context.Context, publisherID, offerID, planID string) (marketplaceordering.AgreementTerms, error) {
+	var result marketplaceordering.AgreementTerms
+	err := azure.CallAPI(ctx, "marketplaceagreements", "Get", func() error {
+		var err error
+		result, err = ac.agreements.Get(ctx, publisherID, offerID, planID)
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package marketplaceagreements
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/marketplaceordering/mgmt/2015-06-01/marketplaceordering"
+	"github.com/pkg/errors"
+)
+
+// Spec specification for the marketplace terms of an image plan
+type Spec struct {
+	Publisher string
+	Offer     string
+	Plan      string
+}
+
+// Get provides the marketplace terms of an image plan.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	agreementSpec, ok := spec.(*Spec)
+	if !ok {
+		return marketplaceordering.AgreementTerms{}, errors.New("invalid marketplace agreement specification")
+	}
+	terms, err := s.Client.Get(ctx, agreementSpec.Publisher, agreementSpec.Offer, agreementSpec.Plan)
+	if err != nil {
+		return terms, errors.Wrapf(err, "failed to get marketplace terms of plan %s of offer %s by publisher %s", agreementSpec.Plan, agreementSpec.Offer, agreementSpec.Publisher)
+	}
+	return terms, nil
+}
+
+// Reconcile is a no-op, marketplace terms have to be accepted by the user.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	return nil
+}
+
+// Delete is a no-op, marketplace terms have to be accepted by the user.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package marketplaceagreements
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/marketplaceordering/mgmt/2015-06-01/marketplaceordering"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/marketplaceagreements/mock_marketplaceagreements"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetMarketplaceAgreement(t *testing.T) {
+	testcases := []struct {
+		name          string
+		agreementSpec Spec
+		expectedError string
+		expect        func(m *mock_marketplaceagreements.MockClientMockRecorder)
+	}{
+		{
+			name:          "marketplace terms exist",
+			agreementSpec: Spec{Publisher: "test-publisher", Offer: "test-offer", Plan: "test-sku"},
+			expect: func(m *mock_marketplaceagreements.MockClientMockRecorder) {
+				m.Get(context.TODO(), "test-publisher", "test-offer", "test-sku").Return(marketplaceordering.AgreementTerms{
+					AgreementProperties: &marketplaceordering.AgreementProperties{Accepted: to.BoolPtr(true)},
+				}, nil)
+			},
+		},
+		{
+			name:          "fail to get marketplace terms",
+			agreementSpec: Spec{Publisher: "test-publisher", Offer: "test-offer", Plan: "test-sku"},
+			expectedError: "failed to get marketplace terms of plan test-sku of offer test-offer by publisher test-publisher: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_marketplaceagreements.MockClientMockRecorder) {
+				m.Get(context.TODO(), "test-publisher", "test-offer", "test-sku").
+					Return(marketplaceordering.AgreementTerms{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			agreementsMock := mock_marketplaceagreements.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(agreementsMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:       client,
+				Cluster:      cluster,
+				AzureCluster: &infrav1.AzureCluster{},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: agreementsMock,
+			}
+
+			_, err = s.Get(context.TODO(), &tc.agreementSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
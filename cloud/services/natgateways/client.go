@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natgateways
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string) (network.NatGateway, error)
+	CreateOrUpdate(context.Context, string, string, network.NatGateway) error
+	Delete(context.Context, string, string) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	natgateways network.NatGatewaysClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new NAT gateways client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newNatGatewaysClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newNatGatewaysClient creates a new NAT gateways client from subscription ID and base URI.
+func newNatGatewaysClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.NatGatewaysClient {
+	natGatewaysClient := network.NewNatGatewaysClientWithBaseURI(baseURI, subscriptionID)
+	natGatewaysClient.Authorizer = authorizer
+	natGatewaysClient.AddToUserAgent(azure.UserAgent)
+	return natGatewaysClient
+}
+
+// Get gets the specified NAT gateway.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, natGatewayName string) (network.NatGateway, error) {
+	var result network.NatGateway
+	err := azure.CallAPI(ctx, "natgateways", "Get", func() error {
+		var err error
+		result, err = ac.natgateways.Get(ctx, resourceGroupName, natGatewayName, "")
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdate creates or updates a NAT gateway in the specified resource group.
+func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, natGatewayName string, natGateway network.NatGateway) error {
+	var future network.NatGatewaysCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "natgateways", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.natgateways.CreateOrUpdate(ctx, resourceGroupName, natGatewayName, natGateway)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.natgateways.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.natgateways)
+		return err
+	})
+}
+
+// Delete deletes the specified NAT gateway.
+func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, natGatewayName string) error {
+	var future network.NatGatewaysDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "natgateways", "Delete", func() error {
+		var err error
+		future, err = ac.natgateways.Delete(ctx, resourceGroupName, natGatewayName)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.natgateways.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.natgateways)
+		return err
+	})
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natgateways
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for NAT gateways
+type Spec struct {
+	Name string
+	// PublicIPName is the name of the public IP the NAT gateway uses for outbound traffic.
+	// The public IP is created and deleted with the NAT gateway.
+	PublicIPName string
+}
+
+// Get provides information about a NAT gateway.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	natGatewaySpec, ok := spec.(*Spec)
+	if !ok {
+		return network.NatGateway{}, errors.New("invalid NAT gateway specification")
+	}
+	natGateway, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), natGatewaySpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "NAT gateway %s not found", natGatewaySpec.Name)
+	} else if err != nil {
+		return natGateway, err
+	}
+	return natGateway, nil
+}
+
+// Reconcile gets/creates/updates a NAT gateway and its public IP.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		s.Scope.V(4).Info("Skipping NAT gateway reconcile in custom vnet mode")
+		return nil
+	}
+	natGatewaySpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid NAT gateway specification")
+	}
+	log := s.Scope.ResourceLogger(natGatewaySpec.Name)
+	log.V(4).Info("reconciling NAT gateway")
+
+	publicIP, err := s.reconcilePublicIP(ctx, natGatewaySpec.PublicIPName)
+	if err != nil {
+		return err
+	}
+
+	tags := s.Scope.ResourceTags(natGatewaySpec.Name, "")
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), natGatewaySpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get NAT gateway %s in resource group %s", natGatewaySpec.Name, s.Scope.ResourceGroup())
+	case err == nil:
+		var tagsChanged bool
+		tags, tagsChanged = converters.MapToTags(existing.Tags).Merged(tags)
+		if hasPublicIP(existing, to.String(publicIP.ID)) && !tagsChanged {
+			log.V(4).Info("NAT gateway is up to date")
+			return nil
+		}
+	}
+
+	natGateway := network.NatGateway{
+		Name:     to.StringPtr(natGatewaySpec.Name),
+		Location: to.StringPtr(s.Scope.Location()),
+		Sku:      &network.NatGatewaySku{Name: network.Standard},
+		Tags:     converters.TagsToMap(tags),
+		NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{
+			PublicIPAddresses: &[]network.SubResource{{ID: publicIP.ID}},
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("NAT gateway", s.Scope.ResourceGroup(), natGatewaySpec.Name, natGateway)) {
+		return nil
+	}
+
+	log.V(2).Info("creating NAT gateway")
+	err = s.Client.CreateOrUpdate(
+		ctx,
+		s.Scope.ResourceGroup(),
+		natGatewaySpec.Name,
+		natGateway,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create NAT gateway %s in resource group %s", natGatewaySpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully created NAT gateway")
+	return nil
+}
+
+// Delete deletes the NAT gateway and its public IP.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		s.Scope.V(4).Info("Skipping NAT gateway deletion in custom vnet mode")
+		return nil
+	}
+	natGatewaySpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid NAT gateway specification")
+	}
+	log := s.Scope.ResourceLogger(natGatewaySpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("NAT gateway", s.Scope.ResourceGroup(), natGatewaySpec.Name)) &&
+		s.Scope.DryRun(scope.DeleteAction("public ip", s.Scope.ResourceGroup(), natGatewaySpec.PublicIPName)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting NAT gateway")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), natGatewaySpec.Name)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete NAT gateway %s in resource group %s", natGatewaySpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("deleting NAT gateway public ip", "publicIP", natGatewaySpec.PublicIPName)
+	err = s.PublicIPsClient.Delete(ctx, s.Scope.ResourceGroup(), natGatewaySpec.PublicIPName)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete public ip %s in resource group %s", natGatewaySpec.PublicIPName, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted NAT gateway")
+	return nil
+}
+
+// reconcilePublicIP gets or creates the public IP of a NAT gateway.
+func (s *Service) reconcilePublicIP(ctx context.Context, name string) (network.PublicIPAddress, error) {
+	log := s.Scope.ResourceLogger(name)
+	publicIP, err := s.PublicIPsClient.Get(ctx, s.Scope.ResourceGroup(), name)
+	if err == nil {
+		return publicIP, nil
+	}
+	if !azure.ResourceNotFound(err) {
+		return publicIP, errors.Wrapf(err, "failed to get public ip %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+
+	desired := network.PublicIPAddress{
+		Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard},
+		Name:     to.StringPtr(name),
+		Location: to.StringPtr(s.Scope.Location()),
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAddressVersion:   network.IPv4,
+			PublicIPAllocationMethod: network.Static,
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("public ip", s.Scope.ResourceGroup(), name, desired)) {
+		return desired, nil
+	}
+
+	log.V(2).Info("creating NAT gateway public ip")
+	// NAT gateways only support standard SKU public IPs with static allocation.
+	err = s.PublicIPsClient.CreateOrUpdate(
+		ctx,
+		s.Scope.ResourceGroup(),
+		name,
+		desired,
+	)
+	if err != nil {
+		return publicIP, errors.Wrapf(err, "failed to create public ip %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+
+	publicIP, err = s.PublicIPsClient.Get(ctx, s.Scope.ResourceGroup(), name)
+	if err != nil {
+		return publicIP, errors.Wrapf(err, "failed to get public ip %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+	return publicIP, nil
+}
+
+// hasPublicIP returns true if the NAT gateway uses the public IP with the given ID.
+func hasPublicIP(natGateway network.NatGateway, publicIPID string) bool {
+	if natGateway.NatGatewayPropertiesFormat == nil || natGateway.PublicIPAddresses == nil {
+		return false
+	}
+	for _, ip := range *natGateway.PublicIPAddresses {
+		if strings.EqualFold(to.String(ip.ID), publicIPID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package natgateways
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/natgateways/mock_natgateways"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips/mock_publicips"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileNatGateways(t *testing.T) {
+	publicIP := network.PublicIPAddress{
+		ID:   to.StringPtr("my-natgw-ip-id"),
+		Name: to.StringPtr("my-natgw-ip"),
+	}
+
+	testcases := []struct {
+		name          string
+		vnetSpec      *infrav1.VnetSpec
+		expectedError string
+		expect        func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name:     "NAT gateway does not exist",
+			vnetSpec: &infrav1.VnetSpec{},
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				gomock.InOrder(
+					mip.Get(context.TODO(), "my-rg", "my-natgw-ip").
+						Return(network.PublicIPAddress{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")),
+					mip.CreateOrUpdate(context.TODO(), "my-rg", "my-natgw-ip", gomock.AssignableToTypeOf(network.PublicIPAddress{})),
+					mip.Get(context.TODO(), "my-rg", "my-natgw-ip").Return(publicIP, nil),
+				)
+				m.Get(context.TODO(), "my-rg", "my-natgw").
+					Return(network.NatGateway{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-natgw", gomock.AssignableToTypeOf(network.NatGateway{}))
+			},
+		}, {
+			name:     "NAT gateway exists and is up to date",
+			vnetSpec: &infrav1.VnetSpec{},
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				mip.Get(context.TODO(), "my-rg", "my-natgw-ip").Return(publicIP, nil)
+				m.Get(context.TODO(), "my-rg", "my-natgw").
+					Return(network.NatGateway{
+						ID:   to.StringPtr("my-natgw-id"),
+						Name: to.StringPtr("my-natgw"),
+						Tags: map[string]*string{
+							"Name": to.StringPtr("my-natgw"),
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+							"foo": to.StringPtr("bar"),
+						},
+						NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{
+							PublicIPAddresses: &[]network.SubResource{{ID: to.StringPtr("my-natgw-ip-id")}},
+						},
+					}, nil)
+			},
+		}, {
+			name:     "NAT gateway exists without the cluster tags",
+			vnetSpec: &infrav1.VnetSpec{},
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				mip.Get(context.TODO(), "my-rg", "my-natgw-ip").Return(publicIP, nil)
+				m.Get(context.TODO(), "my-rg", "my-natgw").
+					Return(network.NatGateway{
+						ID:   to.StringPtr("my-natgw-id"),
+						Name: to.StringPtr("my-natgw"),
+						Tags: map[string]*string{"foo": to.StringPtr("bar")},
+						NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{
+							PublicIPAddresses: &[]network.SubResource{{ID: to.StringPtr("my-natgw-ip-id")}},
+						},
+					}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-natgw", network.NatGateway{
+					Name:     to.StringPtr("my-natgw"),
+					Location: to.StringPtr("test-location"),
+					Sku:      &network.NatGatewaySku{Name: network.Standard},
+					Tags: map[string]*string{
+						"Name": to.StringPtr("my-natgw"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"foo": to.StringPtr("bar"),
+					},
+					NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{
+						PublicIPAddresses: &[]network.SubResource{{ID: to.StringPtr("my-natgw-ip-id")}},
+					},
+				})
+			},
+		}, {
+			name:     "NAT gateway exists without its public ip",
+			vnetSpec: &infrav1.VnetSpec{},
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				mip.Get(context.TODO(), "my-rg", "my-natgw-ip").Return(publicIP, nil)
+				m.Get(context.TODO(), "my-rg", "my-natgw").
+					Return(network.NatGateway{
+						ID:                         to.StringPtr("my-natgw-id"),
+						Name:                       to.StringPtr("my-natgw"),
+						NatGatewayPropertiesFormat: &network.NatGatewayPropertiesFormat{},
+					}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-natgw", gomock.AssignableToTypeOf(network.NatGateway{}))
+			},
+		}, {
+			name:          "fail to get NAT gateway",
+			vnetSpec:      &infrav1.VnetSpec{},
+			expectedError: "failed to get NAT gateway my-natgw in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				mip.Get(context.TODO(), "my-rg", "my-natgw-ip").Return(publicIP, nil)
+				m.Get(context.TODO(), "my-rg", "my-natgw").
+					Return(network.NatGateway{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		}, {
+			name:     "skipping NAT gateway reconcile in custom vnet mode",
+			vnetSpec: &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", ID: "id1"},
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			natGatewayMock := mock_natgateways.NewMockClient(mockCtrl)
+			publicIPMock := mock_publicips.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(natGatewayMock.EXPECT(), publicIPMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							Vnet: *tc.vnetSpec,
+						},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:           clusterScope,
+				Client:          natGatewayMock,
+				PublicIPsClient: publicIPMock,
+			}
+
+			natGatewaySpec := &Spec{
+				Name:         "my-natgw",
+				PublicIPName: "my-natgw-ip",
+			}
+			err = s.Reconcile(context.TODO(), natGatewaySpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteNatGateways(t *testing.T) {
+	testcases := []struct {
+		name   string
+		expect func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name: "NAT gateway exists",
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				gomock.InOrder(
+					m.Delete(context.TODO(), "my-rg", "my-natgw"),
+					mip.Delete(context.TODO(), "my-rg", "my-natgw-ip"),
+				)
+			},
+		},
+		{
+			name: "NAT gateway already deleted",
+			expect: func(m *mock_natgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-natgw").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				mip.Delete(context.TODO(), "my-rg", "my-natgw-ip").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			natGatewayMock := mock_natgateways.NewMockClient(mockCtrl)
+			publicIPMock := mock_publicips.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(natGatewayMock.EXPECT(), publicIPMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:           clusterScope,
+				Client:          natGatewayMock,
+				PublicIPsClient: publicIPMock,
+			}
+
+			natGatewaySpec := &Spec{
+				Name:         "my-natgw",
+				PublicIPName: "my-natgw-ip",
+			}
+			if err := s.Delete(context.TODO(), natGatewaySpec); err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newInterfacesClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newInterfacesClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newInterfacesClient creates a new network interfaces client from subscription ID.
-func newInterfacesClient(subscriptionID string, authorizer autorest.Authorizer) network.InterfacesClient {
-	nicClient := network.NewInterfacesClient(subscriptionID)
+// newInterfacesClient creates a new network interfaces client from subscription ID and base URI.
+func newInterfacesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.InterfacesClient {
+	nicClient := network.NewInterfacesClientWithBaseURI(baseURI, subscriptionID)
 	nicClient.Authorizer = authorizer
 	nicClient.AddToUserAgent(azure.UserAgent)
 	return nicClient
@@ -54,33 +54,43 @@ func newInterfacesClient(subscriptionID string, authorizer autorest.Authorizer)
 
 // Get gets information about the specified network interface.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, nicName string) (network.Interface, error) {
-	return ac.interfaces.Get(ctx, resourceGroupName, nicName, "")
+	var result network.Interface
+	err := azure.CallAPI(ctx, "networkinterfaces", "Get", func() error {
+		var err error
+		result, err = ac.interfaces.Get(ctx, resourceGroupName, nicName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a network interface.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, nicName string, nic network.Interface) error {
-	future, err := ac.interfaces.CreateOrUpdate(ctx, resourceGroupName, nicName, nic)
-	if err != nil {
+	var future network.InterfacesCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "networkinterfaces", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.interfaces.CreateOrUpdate(ctx, resourceGroupName, nicName, nic)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.interfaces.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.interfaces.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.interfaces)
 		return err
-	}
-	_, err = future.Result(ac.interfaces)
-	return err
+	})
 }
 
 // Delete deletes the specified network interface.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, nicName string) error {
-	future, err := ac.interfaces.Delete(ctx, resourceGroupName, nicName)
-	if err != nil {
+	var future network.InterfacesDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "networkinterfaces", "Delete", func() error {
+		var err error
+		future, err = ac.interfaces.Delete(ctx, resourceGroupName, nicName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.interfaces.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.interfaces.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.interfaces)
 		return err
-	}
-	_, err = future.Result(ac.interfaces)
-	return err
+	})
 }
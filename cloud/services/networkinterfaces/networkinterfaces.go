@@ -18,24 +18,40 @@ package networkinterfaces
 
 import (
 	"context"
+	"net"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for routetable
 type Spec struct {
-	Name                     string
-	SubnetName               string
-	VnetName                 string
-	StaticIPAddress          string
-	PublicLoadBalancerName   string
-	InternalLoadBalancerName string
-	PublicIPName             string
-	NatRule                  int
+	Name                      string
+	SubnetName                string
+	VnetName                  string
+	PrivateIPAllocationMethod network.IPAllocationMethod
+	StaticIPAddress           string
+	PublicLoadBalancerName    string
+	InternalLoadBalancerName  string
+	PublicIPName              string
+	InboundNatRuleName        string
+	AcceleratedNetworking     *bool
+	// BackendAddressPoolID is the ID of a load balancer backend pool of the network interface other than the
+	// ones of the public and internal load balancers of the API server.
+	BackendAddressPoolID string
+	// ApplicationSecurityGroups are the resource IDs of the application security groups of the IP configurations of
+	// the network interface.
+	ApplicationSecurityGroups []string
+	// EnableIPForwarding enables IP forwarding on the network interface.
+	EnableIPForwarding bool
+	// DNSServers are the DNS servers of the network interface. The network interface uses the DNS servers of the
+	// virtual network if there are none.
+	DNSServers []string
 }
 
 // Get provides information about a network interface.
@@ -59,6 +75,8 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid network interface specification")
 	}
+	log := s.Scope.ResourceLogger(nicSpec.Name)
+	log.V(4).Info("reconciling network interface")
 
 	nicConfig := &network.InterfaceIPConfigurationPropertiesFormat{}
 
@@ -68,10 +86,25 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	}
 
 	nicConfig.Subnet = &network.Subnet{ID: subnet.ID}
-	nicConfig.PrivateIPAllocationMethod = network.Dynamic
-	if nicSpec.StaticIPAddress != "" {
-		nicConfig.PrivateIPAllocationMethod = network.Static
+	nicConfig.PrivateIPAllocationMethod = nicSpec.PrivateIPAllocationMethod
+	if nicConfig.PrivateIPAllocationMethod == "" {
+		nicConfig.PrivateIPAllocationMethod = network.Dynamic
+		if nicSpec.StaticIPAddress != "" {
+			nicConfig.PrivateIPAllocationMethod = network.Static
+		}
+	}
+	switch nicConfig.PrivateIPAllocationMethod {
+	case network.Static:
+		if err := validateStaticIPAddress(nicSpec, subnet); err != nil {
+			return err
+		}
 		nicConfig.PrivateIPAddress = to.StringPtr(nicSpec.StaticIPAddress)
+	case network.Dynamic:
+		if nicSpec.StaticIPAddress != "" {
+			return errors.Errorf("network interface %s with dynamic private IP allocation cannot have the static private IP %s", nicSpec.Name, nicSpec.StaticIPAddress)
+		}
+	default:
+		return errors.Errorf("unknown private IP allocation method %s of network interface %s", nicConfig.PrivateIPAllocationMethod, nicSpec.Name)
 	}
 
 	backendAddressPools := []network.BackendAddressPool{}
@@ -86,10 +119,16 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 				ID: (*lb.BackendAddressPools)[0].ID,
 			})
 
-		nicConfig.LoadBalancerInboundNatRules = &[]network.InboundNatRule{
-			{
-				ID: (*lb.InboundNatRules)[nicSpec.NatRule].ID,
-			},
+		if nicSpec.InboundNatRuleName != "" {
+			natRuleID, err := getInboundNatRuleID(lb, nicSpec.InboundNatRuleName)
+			if err != nil {
+				return err
+			}
+			nicConfig.LoadBalancerInboundNatRules = &[]network.InboundNatRule{
+				{
+					ID: natRuleID,
+				},
+			}
 		}
 	}
 	if nicSpec.InternalLoadBalancerName != "" {
@@ -103,6 +142,12 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 				ID: (*internalLB.BackendAddressPools)[0].ID,
 			})
 	}
+	if nicSpec.BackendAddressPoolID != "" {
+		backendAddressPools = append(backendAddressPools,
+			network.BackendAddressPool{
+				ID: to.StringPtr(nicSpec.BackendAddressPoolID),
+			})
+	}
 	nicConfig.LoadBalancerBackendAddressPools = &backendAddressPools
 
 	if nicSpec.PublicIPName != "" {
@@ -113,45 +158,155 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 		nicConfig.PublicIPAddress = &publicIP
 	}
 
-	err = s.Client.CreateOrUpdate(ctx,
-		s.Scope.ResourceGroup(),
-		nicSpec.Name,
-		network.Interface{
-			Location: to.StringPtr(s.Scope.Location()),
-			InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
-				IPConfigurations: &[]network.InterfaceIPConfiguration{
-					{
-						Name:                                     to.StringPtr("pipConfig"),
-						InterfaceIPConfigurationPropertiesFormat: nicConfig,
-					},
-				},
+	asgs := applicationSecurityGroups(nicSpec.ApplicationSecurityGroups)
+	nicConfig.ApplicationSecurityGroups = asgs
+
+	ipConfigs := []network.InterfaceIPConfiguration{
+		{
+			Name:                                     to.StringPtr("pipConfig"),
+			InterfaceIPConfigurationPropertiesFormat: nicConfig,
+		},
+	}
+	// a network interface of a dual-stack subnet also gets a private IPv6 address, from a secondary IP configuration.
+	if hasIPv6Prefix(subnet) {
+		nicConfig.Primary = to.BoolPtr(true)
+		ipConfigs = append(ipConfigs, network.InterfaceIPConfiguration{
+			Name: to.StringPtr("ipv6Config"),
+			InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+				Subnet:                    &network.Subnet{ID: subnet.ID},
+				PrivateIPAllocationMethod: network.Dynamic,
+				PrivateIPAddressVersion:   network.IPv6,
+				ApplicationSecurityGroups: asgs,
 			},
 		})
+	}
+
+	nic := network.Interface{
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(nicSpec.Name, "")),
+		InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+			EnableAcceleratedNetworking: nicSpec.AcceleratedNetworking,
+			IPConfigurations:            &ipConfigs,
+		},
+	}
+	if nicSpec.EnableIPForwarding {
+		nic.EnableIPForwarding = to.BoolPtr(true)
+	}
+	if len(nicSpec.DNSServers) > 0 {
+		dnsServers := append([]string{}, nicSpec.DNSServers...)
+		nic.DNSSettings = &network.InterfaceDNSSettings{DNSServers: &dnsServers}
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("network interface", s.Scope.ResourceGroup(), nicSpec.Name, nic)) {
+		return nil
+	}
+
+	log.V(2).Info("creating network interface")
+	err = s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), nicSpec.Name, nic)
 
 	if err != nil {
 		return errors.Wrapf(err, "failed to create network interface %s in resource group %s", nicSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully created network interface %s", nicSpec.Name)
+	log.V(2).Info("successfully created network interface")
 	return nil
 }
 
+// applicationSecurityGroups returns the references to the application security groups with the IDs, or nil if there
+// are none.
+func applicationSecurityGroups(ids []string) *[]network.ApplicationSecurityGroup {
+	if len(ids) == 0 {
+		return nil
+	}
+	asgs := make([]network.ApplicationSecurityGroup, 0, len(ids))
+	for _, id := range ids {
+		asgs = append(asgs, network.ApplicationSecurityGroup{ID: to.StringPtr(id)})
+	}
+	return &asgs
+}
+
+// getInboundNatRuleID returns the ID of the inbound NAT rule of the load balancer with the given name.
+func getInboundNatRuleID(lb network.LoadBalancer, ruleName string) (*string, error) {
+	if lb.LoadBalancerPropertiesFormat != nil && lb.InboundNatRules != nil {
+		for _, rule := range *lb.InboundNatRules {
+			if to.String(rule.Name) == ruleName {
+				return rule.ID, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("inbound NAT rule %s not found in load balancer %s", ruleName, to.String(lb.Name))
+}
+
+// validateStaticIPAddress checks that the static private IP of a network interface is in the CIDR of its subnet.
+func validateStaticIPAddress(nicSpec *Spec, subnet network.Subnet) error {
+	if nicSpec.StaticIPAddress == "" {
+		return errors.Errorf("network interface %s with static private IP allocation requires a static private IP", nicSpec.Name)
+	}
+	ip := net.ParseIP(nicSpec.StaticIPAddress)
+	if ip == nil {
+		return errors.Errorf("invalid static private IP %s of network interface %s", nicSpec.StaticIPAddress, nicSpec.Name)
+	}
+	prefixes := subnetPrefixes(subnet)
+	if len(prefixes) == 0 {
+		return errors.Errorf("failed to get the CIDR of subnet %s of network interface %s", nicSpec.SubnetName, nicSpec.Name)
+	}
+	for _, prefix := range prefixes {
+		_, cidr, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse the CIDR of subnet %s", nicSpec.SubnetName)
+		}
+		if cidr.Contains(ip) {
+			return nil
+		}
+	}
+	return errors.Errorf("static private IP %s of network interface %s is not in the CIDR %s of subnet %s", nicSpec.StaticIPAddress, nicSpec.Name, strings.Join(prefixes, ", "), nicSpec.SubnetName)
+}
+
+// subnetPrefixes returns the address prefixes of a subnet, which dual-stack subnets have in AddressPrefixes.
+func subnetPrefixes(subnet network.Subnet) []string {
+	if subnet.SubnetPropertiesFormat == nil {
+		return nil
+	}
+	if prefixes := to.StringSlice(subnet.AddressPrefixes); len(prefixes) > 0 {
+		return prefixes
+	}
+	if subnet.AddressPrefix != nil {
+		return []string{*subnet.AddressPrefix}
+	}
+	return nil
+}
+
+// hasIPv6Prefix returns true if the subnet has an IPv6 address prefix, which makes it a dual-stack subnet.
+func hasIPv6Prefix(subnet network.Subnet) bool {
+	for _, prefix := range subnetPrefixes(subnet) {
+		if ip, _, err := net.ParseCIDR(prefix); err == nil && ip.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Delete deletes the network interface with the provided name.
 func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	nicSpec, ok := spec.(*Spec)
 	if !ok {
 		return errors.New("invalid network interface Specification")
 	}
-	klog.V(2).Infof("deleting nic %s", nicSpec.Name)
+	log := s.Scope.ResourceLogger(nicSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("network interface", s.Scope.ResourceGroup(), nicSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting network interface")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), nicSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("network interface is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete network interface %s in resource group %s", nicSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully deleted nic %s", nicSpec.Name)
+	log.V(2).Info("successfully deleted network interface")
 	return nil
 }
@@ -0,0 +1,478 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package networkinterfaces
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/networkinterfaces/mock_networkinterfaces"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers/mock_publicloadbalancers"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/subnets/mock_subnets"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// backendPoolsMatcher matches a network interface in exactly the backend pools with the given IDs.
+type backendPoolsMatcher []string
+
+func (m backendPoolsMatcher) Matches(x interface{}) bool {
+	nic, ok := x.(network.Interface)
+	if !ok || nic.InterfacePropertiesFormat == nil || nic.IPConfigurations == nil || len(*nic.IPConfigurations) != 1 {
+		return false
+	}
+	ipConfig := (*nic.IPConfigurations)[0]
+	if ipConfig.InterfaceIPConfigurationPropertiesFormat == nil || ipConfig.LoadBalancerBackendAddressPools == nil {
+		return false
+	}
+	pools := *ipConfig.LoadBalancerBackendAddressPools
+	if len(pools) != len(m) {
+		return false
+	}
+	for i, pool := range pools {
+		if to.String(pool.ID) != m[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m backendPoolsMatcher) String() string {
+	return fmt.Sprintf("is a network interface in the backend pools %v", []string(m))
+}
+
+func TestReconcileNetworkInterface(t *testing.T) {
+	subnetID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/virtualNetworks/my-vnet/subnets/my-subnet"
+	controlPlaneBackendPoolID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-public-lb/backendAddressPools/controlplane-backEndPool"
+	nodeBackendPoolID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-public-lb/backendAddressPools/node-backEndPool"
+	asgID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/my-asg"
+	nicTags := map[string]*string{
+		"Name": to.StringPtr("my-nic"),
+		"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+	}
+
+	testcases := []struct {
+		name          string
+		nicSpec       Spec
+		expectedError string
+		expect        func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder)
+	}{
+		{
+			name: "network interface without accelerated networking",
+			nicSpec: Spec{
+				Name:       "my-nic",
+				SubnetName: "my-subnet",
+				VnetName:   "my-vnet",
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", gomock.AssignableToTypeOf(network.Interface{}))
+			},
+		},
+		{
+			name: "network interface with accelerated networking",
+			nicSpec: Spec{
+				Name:                  "my-nic",
+				SubnetName:            "my-subnet",
+				VnetName:              "my-vnet",
+				AcceleratedNetworking: to.BoolPtr(true),
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						EnableAcceleratedNetworking: to.BoolPtr(true),
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Dynamic,
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{},
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "network interface with a static private IP",
+			nicSpec: Spec{
+				Name:            "my-nic",
+				SubnetName:      "my-subnet",
+				VnetName:        "my-vnet",
+				StaticIPAddress: "10.0.0.10",
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{
+					ID:                     to.StringPtr(subnetID),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefix: to.StringPtr("10.0.0.0/16")},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Static,
+									PrivateIPAddress:                to.StringPtr("10.0.0.10"),
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{},
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "network interface with dynamic private IP allocation",
+			nicSpec: Spec{
+				Name:                      "my-nic",
+				SubnetName:                "my-subnet",
+				VnetName:                  "my-vnet",
+				PrivateIPAllocationMethod: network.Dynamic,
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Dynamic,
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{},
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "network interface with IP forwarding and DNS servers",
+			nicSpec: Spec{
+				Name:               "my-nic",
+				SubnetName:         "my-subnet",
+				VnetName:           "my-vnet",
+				EnableIPForwarding: true,
+				DNSServers:         []string{"10.0.0.4", "10.0.0.5"},
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						EnableIPForwarding: to.BoolPtr(true),
+						DNSSettings:        &network.InterfaceDNSSettings{DNSServers: &[]string{"10.0.0.4", "10.0.0.5"}},
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Dynamic,
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{},
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "network interface with application security groups",
+			nicSpec: Spec{
+				Name:                      "my-nic",
+				SubnetName:                "my-subnet",
+				VnetName:                  "my-vnet",
+				ApplicationSecurityGroups: []string{asgID},
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Dynamic,
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{},
+									ApplicationSecurityGroups:       &[]network.ApplicationSecurityGroup{{ID: to.StringPtr(asgID)}},
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "network interface of a dual-stack subnet",
+			nicSpec: Spec{
+				Name:       "my-nic",
+				SubnetName: "my-subnet",
+				VnetName:   "my-vnet",
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{
+					ID:                     to.StringPtr(subnetID),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefixes: &[]string{"10.0.0.0/16", "fd00::/64"}},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Dynamic,
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{},
+									Primary:                         to.BoolPtr(true),
+								},
+							},
+							{
+								Name: to.StringPtr("ipv6Config"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                    &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod: network.Dynamic,
+									PrivateIPAddressVersion:   network.IPv6,
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "static private IP outside of the subnet",
+			nicSpec: Spec{
+				Name:                      "my-nic",
+				SubnetName:                "my-subnet",
+				VnetName:                  "my-vnet",
+				PrivateIPAllocationMethod: network.Static,
+				StaticIPAddress:           "10.1.0.10",
+			},
+			expectedError: "static private IP 10.1.0.10 of network interface my-nic is not in the CIDR 10.0.0.0/16 of subnet my-subnet",
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{
+					ID:                     to.StringPtr(subnetID),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{AddressPrefix: to.StringPtr("10.0.0.0/16")},
+				}, nil)
+			},
+		},
+		{
+			name: "static private IP allocation without a static private IP",
+			nicSpec: Spec{
+				Name:                      "my-nic",
+				SubnetName:                "my-subnet",
+				VnetName:                  "my-vnet",
+				PrivateIPAllocationMethod: network.Static,
+			},
+			expectedError: "network interface my-nic with static private IP allocation requires a static private IP",
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+			},
+		},
+		{
+			name: "node network interface in the node backend pool",
+			nicSpec: Spec{
+				Name:                 "my-nic",
+				SubnetName:           "my-subnet",
+				VnetName:             "my-vnet",
+				BackendAddressPoolID: nodeBackendPoolID,
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", backendPoolsMatcher{nodeBackendPoolID})
+			},
+		},
+		{
+			name: "control plane network interface outside of the node backend pool",
+			nicSpec: Spec{
+				Name:                   "my-nic",
+				SubnetName:             "my-subnet",
+				VnetName:               "my-vnet",
+				PublicLoadBalancerName: "my-public-lb",
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				mLB.Get(context.TODO(), "my-rg", "my-public-lb").Return(network.LoadBalancer{
+					LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+						BackendAddressPools: &[]network.BackendAddressPool{
+							{ID: to.StringPtr(controlPlaneBackendPoolID)},
+							{ID: to.StringPtr(nodeBackendPoolID)},
+						},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", backendPoolsMatcher{controlPlaneBackendPoolID})
+			},
+		},
+		{
+			name: "control plane network interface with an inbound NAT rule",
+			nicSpec: Spec{
+				Name:                   "my-nic",
+				SubnetName:             "my-subnet",
+				VnetName:               "my-vnet",
+				PublicLoadBalancerName: "my-public-lb",
+				InboundNatRuleName:     "my-machine",
+			},
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				mLB.Get(context.TODO(), "my-rg", "my-public-lb").Return(network.LoadBalancer{
+					LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+						BackendAddressPools: &[]network.BackendAddressPool{{ID: to.StringPtr(controlPlaneBackendPoolID)}},
+						InboundNatRules: &[]network.InboundNatRule{
+							{Name: to.StringPtr("my-other-machine"), ID: to.StringPtr("my-other-nat-rule-id")},
+							{Name: to.StringPtr("my-machine"), ID: to.StringPtr("my-nat-rule-id")},
+						},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", network.Interface{
+					Location: to.StringPtr("test-location"),
+					Tags:     nicTags,
+					InterfacePropertiesFormat: &network.InterfacePropertiesFormat{
+						IPConfigurations: &[]network.InterfaceIPConfiguration{
+							{
+								Name: to.StringPtr("pipConfig"),
+								InterfaceIPConfigurationPropertiesFormat: &network.InterfaceIPConfigurationPropertiesFormat{
+									Subnet:                          &network.Subnet{ID: to.StringPtr(subnetID)},
+									PrivateIPAllocationMethod:       network.Dynamic,
+									LoadBalancerBackendAddressPools: &[]network.BackendAddressPool{{ID: to.StringPtr(controlPlaneBackendPoolID)}},
+									LoadBalancerInboundNatRules:     &[]network.InboundNatRule{{ID: to.StringPtr("my-nat-rule-id")}},
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "inbound NAT rule that does not exist",
+			nicSpec: Spec{
+				Name:                   "my-nic",
+				SubnetName:             "my-subnet",
+				VnetName:               "my-vnet",
+				PublicLoadBalancerName: "my-public-lb",
+				InboundNatRuleName:     "my-machine",
+			},
+			expectedError: "inbound NAT rule my-machine not found in load balancer my-public-lb",
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				mLB.Get(context.TODO(), "my-rg", "my-public-lb").Return(network.LoadBalancer{
+					Name: to.StringPtr("my-public-lb"),
+					LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+						BackendAddressPools: &[]network.BackendAddressPool{{ID: to.StringPtr(controlPlaneBackendPoolID)}},
+						InboundNatRules:     &[]network.InboundNatRule{},
+					},
+				}, nil)
+			},
+		},
+		{
+			name: "fail to create network interface",
+			nicSpec: Spec{
+				Name:                  "my-nic",
+				SubnetName:            "my-subnet",
+				VnetName:              "my-vnet",
+				AcceleratedNetworking: to.BoolPtr(true),
+			},
+			expectedError: "failed to create network interface my-nic in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_networkinterfaces.MockClientMockRecorder, m1 *mock_subnets.MockClientMockRecorder, mLB *mock_publicloadbalancers.MockClientMockRecorder) {
+				m1.Get(context.TODO(), "my-rg", "my-vnet", "my-subnet").Return(network.Subnet{ID: to.StringPtr(subnetID)}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-nic", gomock.AssignableToTypeOf(network.Interface{})).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			nicMock := mock_networkinterfaces.NewMockClient(mockCtrl)
+			subnetMock := mock_subnets.NewMockClient(mockCtrl)
+			lbMock := mock_publicloadbalancers.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(nicMock.EXPECT(), subnetMock.EXPECT(), lbMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+						},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:               clusterScope,
+				Client:              nicMock,
+				SubnetsClient:       subnetMock,
+				LoadBalancersClient: lbMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &tc.nicSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
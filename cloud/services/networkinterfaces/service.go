@@ -36,9 +36,9 @@ type Service struct {
 func NewService(scope *scope.ClusterScope) *Service {
 	return &Service{
 		Scope:               scope,
-		Client:              NewClient(scope.SubscriptionID, scope.Authorizer),
-		SubnetsClient:       subnets.NewClient(scope.SubscriptionID, scope.Authorizer),
-		LoadBalancersClient: publicloadbalancers.NewClient(scope.SubscriptionID, scope.Authorizer),
-		PublicIPsClient:     publicips.NewClient(scope.SubscriptionID, scope.Authorizer),
+		Client:              NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		SubnetsClient:       subnets.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		LoadBalancersClient: publicloadbalancers.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		PublicIPsClient:     publicips.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
 	}
 }
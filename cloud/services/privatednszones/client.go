@@ -0,0 +1,204 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privatednszones
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	GetZone(context.Context, string, string) (privatedns.PrivateZone, error)
+	CreateOrUpdateZone(context.Context, string, string, privatedns.PrivateZone) error
+	DeleteZone(context.Context, string, string) error
+	GetLink(context.Context, string, string, string) (privatedns.VirtualNetworkLink, error)
+	CreateOrUpdateLink(context.Context, string, string, string, privatedns.VirtualNetworkLink) error
+	DeleteLink(context.Context, string, string, string) error
+	ListRecordSets(context.Context, string, string) ([]privatedns.RecordSet, error)
+	CreateOrUpdateRecordSet(context.Context, string, string, string, privatedns.RecordSet) error
+	DeleteRecordSet(context.Context, string, string, string) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	privatezones privatedns.PrivateZonesClient
+	vnetlinks    privatedns.VirtualNetworkLinksClient
+	recordsets   privatedns.RecordSetsClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new private DNS client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	return &AzureClient{
+		privatezones: newPrivateZonesClient(subscriptionID, baseURI, authorizer),
+		vnetlinks:    newVirtualNetworkLinksClient(subscriptionID, baseURI, authorizer),
+		recordsets:   newRecordSetsClient(subscriptionID, baseURI, authorizer),
+	}
+}
+
+// newPrivateZonesClient creates a new private zones client from subscription ID and base URI.
+func newPrivateZonesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) privatedns.PrivateZonesClient {
+	zonesClient := privatedns.NewPrivateZonesClientWithBaseURI(baseURI, subscriptionID)
+	zonesClient.Authorizer = authorizer
+	zonesClient.AddToUserAgent(azure.UserAgent)
+	return zonesClient
+}
+
+// newVirtualNetworkLinksClient creates a new vnet links client from subscription ID and base URI.
+func newVirtualNetworkLinksClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) privatedns.VirtualNetworkLinksClient {
+	linksClient := privatedns.NewVirtualNetworkLinksClientWithBaseURI(baseURI, subscriptionID)
+	linksClient.Authorizer = authorizer
+	linksClient.AddToUserAgent(azure.UserAgent)
+	return linksClient
+}
+
+// newRecordSetsClient creates a new record sets client from subscription ID and base URI.
+func newRecordSetsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) privatedns.RecordSetsClient {
+	recordSetsClient := privatedns.NewRecordSetsClientWithBaseURI(baseURI, subscriptionID)
+	recordSetsClient.Authorizer = authorizer
+	recordSetsClient.AddToUserAgent(azure.UserAgent)
+	return recordSetsClient
+}
+
+// GetZone gets the specified private DNS zone.
+func (ac *AzureClient) GetZone(ctx context.Context, resourceGroupName, zoneName string) (privatedns.PrivateZone, error) {
+	var result privatedns.PrivateZone
+	err := azure.CallAPI(ctx, "privatednszones", "GetZone", func() error {
+		var err error
+		result, err = ac.privatezones.Get(ctx, resourceGroupName, zoneName)
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdateZone creates or updates a private DNS zone in the specified resource group.
+func (ac *AzureClient) CreateOrUpdateZone(ctx context.Context, resourceGroupName, zoneName string, zone privatedns.PrivateZone) error {
+	var future privatedns.PrivateZonesCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "privatednszones", "CreateOrUpdateZone", func() error {
+		var err error
+		future, err = ac.privatezones.CreateOrUpdate(ctx, resourceGroupName, zoneName, zone, "", "")
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.privatezones.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.privatezones)
+		return err
+	})
+}
+
+// DeleteZone deletes the specified private DNS zone, which must not have vnet links anymore.
+func (ac *AzureClient) DeleteZone(ctx context.Context, resourceGroupName, zoneName string) error {
+	var future privatedns.PrivateZonesDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "privatednszones", "DeleteZone", func() error {
+		var err error
+		future, err = ac.privatezones.Delete(ctx, resourceGroupName, zoneName, "")
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.privatezones.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.privatezones)
+		return err
+	})
+}
+
+// GetLink gets the specified vnet link of a private DNS zone.
+func (ac *AzureClient) GetLink(ctx context.Context, resourceGroupName, zoneName, linkName string) (privatedns.VirtualNetworkLink, error) {
+	var result privatedns.VirtualNetworkLink
+	err := azure.CallAPI(ctx, "privatednszones", "GetLink", func() error {
+		var err error
+		result, err = ac.vnetlinks.Get(ctx, resourceGroupName, zoneName, linkName)
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdateLink creates or updates a vnet link of a private DNS zone.
+func (ac *AzureClient) CreateOrUpdateLink(ctx context.Context, resourceGroupName, zoneName, linkName string, link privatedns.VirtualNetworkLink) error {
+	var future privatedns.VirtualNetworkLinksCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "privatednszones", "CreateOrUpdateLink", func() error {
+		var err error
+		future, err = ac.vnetlinks.CreateOrUpdate(ctx, resourceGroupName, zoneName, linkName, link, "", "")
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.vnetlinks.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.vnetlinks)
+		return err
+	})
+}
+
+// DeleteLink deletes the specified vnet link of a private DNS zone.
+func (ac *AzureClient) DeleteLink(ctx context.Context, resourceGroupName, zoneName, linkName string) error {
+	var future privatedns.VirtualNetworkLinksDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "privatednszones", "DeleteLink", func() error {
+		var err error
+		future, err = ac.vnetlinks.Delete(ctx, resourceGroupName, zoneName, linkName, "")
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.vnetlinks.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.vnetlinks)
+		return err
+	})
+}
+
+// ListRecordSets lists the A record sets of a private DNS zone.
+func (ac *AzureClient) ListRecordSets(ctx context.Context, resourceGroupName, zoneName string) ([]privatedns.RecordSet, error) {
+	var recordSets []privatedns.RecordSet
+	err := azure.CallAPI(ctx, "privatednszones", "ListRecordSets", func() error {
+		iter, err := ac.recordsets.ListByTypeComplete(ctx, resourceGroupName, zoneName, privatedns.A, nil, "")
+		if err != nil {
+			return err
+		}
+		for iter.NotDone() {
+			recordSets = append(recordSets, iter.Value())
+			if err := iter.NextWithContext(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recordSets, nil
+}
+
+// CreateOrUpdateRecordSet creates or updates an A record set of a private DNS zone.
+func (ac *AzureClient) CreateOrUpdateRecordSet(ctx context.Context, resourceGroupName, zoneName, name string, recordSet privatedns.RecordSet) error {
+	return azure.CallAPI(ctx, "privatednszones", "CreateOrUpdateRecordSet", func() error {
+		_, err := ac.recordsets.CreateOrUpdate(ctx, resourceGroupName, zoneName, privatedns.A, name, recordSet, "", "")
+		return err
+	})
+}
+
+// DeleteRecordSet deletes an A record set of a private DNS zone.
+func (ac *AzureClient) DeleteRecordSet(ctx context.Context, resourceGroupName, zoneName, name string) error {
+	return azure.CallAPI(ctx, "privatednszones", "DeleteRecordSet", func() error {
+		_, err := ac.recordsets.Delete(ctx, resourceGroupName, zoneName, privatedns.A, name, "")
+		return err
+	})
+}
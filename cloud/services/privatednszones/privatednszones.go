@@ -0,0 +1,238 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privatednszones
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+const (
+	// location is the location of private DNS zones and of their vnet links, which are global resources.
+	location = "global"
+	// recordTTL is the time-to-live in seconds of the A records of the machines.
+	recordTTL = 300
+)
+
+// Record is an A record of a machine in a private DNS zone.
+type Record struct {
+	Hostname string
+	IP       string
+}
+
+// Spec specification for a private DNS zone
+type Spec struct {
+	Name     string
+	LinkName string
+	VnetID   string
+	// RegistrationEnabled lets Azure register the virtual machines of the vnet in the zone.
+	RegistrationEnabled bool
+	// Records are the A records of the machines, which are only created when registration is not enabled.
+	Records []Record
+}
+
+// Get provides information about a private DNS zone.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	zoneSpec, ok := spec.(*Spec)
+	if !ok {
+		return privatedns.PrivateZone{}, errors.New("invalid private DNS zone specification")
+	}
+	zone, err := s.Client.GetZone(ctx, s.Scope.ResourceGroup(), zoneSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "private DNS zone %s not found", zoneSpec.Name)
+	} else if err != nil {
+		return zone, err
+	}
+	return zone, nil
+}
+
+// Reconcile gets/creates/updates a private DNS zone, then its link to the vnet and the A records of the machines.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	zoneSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid private DNS zone specification")
+	}
+	if err := s.reconcileZone(ctx, zoneSpec); err != nil {
+		return err
+	}
+	if err := s.reconcileLink(ctx, zoneSpec); err != nil {
+		return err
+	}
+	if zoneSpec.RegistrationEnabled {
+		return nil
+	}
+	return s.reconcileRecords(ctx, zoneSpec)
+}
+
+// reconcileZone creates the private DNS zone, or updates its tags. A zone which the cluster does not own is used as is.
+func (s *Service) reconcileZone(ctx context.Context, zoneSpec *Spec) error {
+	log := s.Scope.ResourceLogger(zoneSpec.Name)
+	log.V(4).Info("reconciling private DNS zone")
+
+	tags := s.Scope.ResourceTags(zoneSpec.Name, "")
+	existing, err := s.Client.GetZone(ctx, s.Scope.ResourceGroup(), zoneSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get private DNS zone %s in resource group %s", zoneSpec.Name, s.Scope.ResourceGroup())
+	case err == nil:
+		existingTags := converters.MapToTags(existing.Tags)
+		if !existingTags.HasOwned(s.Scope.Name()) {
+			log.V(4).Info("private DNS zone is not owned by the cluster, skipping update")
+			return nil
+		}
+		var changed bool
+		if tags, changed = existingTags.Merged(tags); !changed {
+			log.V(4).Info("private DNS zone is up to date")
+			return nil
+		}
+	}
+
+	zone := privatedns.PrivateZone{
+		Location: to.StringPtr(location),
+		Tags:     converters.TagsToMap(tags),
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("private DNS zone", s.Scope.ResourceGroup(), zoneSpec.Name, zone)) {
+		return nil
+	}
+
+	log.V(2).Info("creating private DNS zone")
+	if err := s.Client.CreateOrUpdateZone(ctx, s.Scope.ResourceGroup(), zoneSpec.Name, zone); err != nil {
+		return errors.Wrapf(err, "failed to create private DNS zone %s in resource group %s", zoneSpec.Name, s.Scope.ResourceGroup())
+	}
+	log.V(2).Info("successfully created private DNS zone")
+	return nil
+}
+
+// reconcileLink creates the link of the private DNS zone to the vnet, or updates whether it registers the virtual
+// machines of the vnet.
+func (s *Service) reconcileLink(ctx context.Context, zoneSpec *Spec) error {
+	log := s.Scope.ResourceLogger(zoneSpec.LinkName).WithValues("zone", zoneSpec.Name)
+	log.V(4).Info("reconciling private DNS zone vnet link")
+
+	existing, err := s.Client.GetLink(ctx, s.Scope.ResourceGroup(), zoneSpec.Name, zoneSpec.LinkName)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get vnet link %s of private DNS zone %s", zoneSpec.LinkName, zoneSpec.Name)
+	case err == nil && existing.VirtualNetworkLinkProperties != nil &&
+		to.Bool(existing.RegistrationEnabled) == zoneSpec.RegistrationEnabled:
+		log.V(4).Info("private DNS zone vnet link is up to date")
+		return nil
+	}
+
+	link := privatedns.VirtualNetworkLink{
+		Location: to.StringPtr(location),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(zoneSpec.LinkName, "")),
+		VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+			VirtualNetwork:      &privatedns.SubResource{ID: to.StringPtr(zoneSpec.VnetID)},
+			RegistrationEnabled: to.BoolPtr(zoneSpec.RegistrationEnabled),
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("private DNS zone vnet link", s.Scope.ResourceGroup(), zoneSpec.LinkName, link)) {
+		return nil
+	}
+
+	log.V(2).Info("creating private DNS zone vnet link", "registrationEnabled", zoneSpec.RegistrationEnabled)
+	if err := s.Client.CreateOrUpdateLink(ctx, s.Scope.ResourceGroup(), zoneSpec.Name, zoneSpec.LinkName, link); err != nil {
+		return errors.Wrapf(err, "failed to create vnet link %s of private DNS zone %s", zoneSpec.LinkName, zoneSpec.Name)
+	}
+	log.V(2).Info("successfully created private DNS zone vnet link")
+	return nil
+}
+
+// reconcileRecords creates the A records of the machines which are missing from the private DNS zone, and updates the
+// ones with another IP. The records of the machines which no longer exist are deleted with the zone.
+func (s *Service) reconcileRecords(ctx context.Context, zoneSpec *Spec) error {
+	recordSets, err := s.Client.ListRecordSets(ctx, s.Scope.ResourceGroup(), zoneSpec.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list record sets of private DNS zone %s", zoneSpec.Name)
+	}
+	existing := make(map[string]string, len(recordSets))
+	for _, recordSet := range recordSets {
+		if recordSet.RecordSetProperties == nil || recordSet.ARecords == nil || len(*recordSet.ARecords) != 1 {
+			continue
+		}
+		existing[to.String(recordSet.Name)] = to.String((*recordSet.ARecords)[0].Ipv4Address)
+	}
+
+	for _, record := range zoneSpec.Records {
+		if ip, ok := existing[record.Hostname]; ok && ip == record.IP {
+			continue
+		}
+		recordSet := privatedns.RecordSet{
+			RecordSetProperties: &privatedns.RecordSetProperties{
+				TTL:      to.Int64Ptr(recordTTL),
+				ARecords: &[]privatedns.ARecord{{Ipv4Address: to.StringPtr(record.IP)}},
+			},
+		}
+		if s.Scope.DryRun(scope.CreateOrUpdateAction("private DNS record set", s.Scope.ResourceGroup(), record.Hostname, recordSet)) {
+			continue
+		}
+		s.Scope.ResourceLogger(record.Hostname).V(2).Info("creating private DNS record", "zone", zoneSpec.Name, "ip", record.IP)
+		if err := s.Client.CreateOrUpdateRecordSet(ctx, s.Scope.ResourceGroup(), zoneSpec.Name, record.Hostname, recordSet); err != nil {
+			return errors.Wrapf(err, "failed to create record %s of private DNS zone %s", record.Hostname, zoneSpec.Name)
+		}
+	}
+	return nil
+}
+
+// Delete deletes the link of the private DNS zone to the vnet, then the zone with its records. A zone which the
+// cluster does not own is kept.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	zoneSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid private DNS zone specification")
+	}
+	log := s.Scope.ResourceLogger(zoneSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("private DNS zone vnet link", s.Scope.ResourceGroup(), zoneSpec.LinkName)) &&
+		s.Scope.DryRun(scope.DeleteAction("private DNS zone", s.Scope.ResourceGroup(), zoneSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting private DNS zone vnet link", "link", zoneSpec.LinkName)
+	err := s.Client.DeleteLink(ctx, s.Scope.ResourceGroup(), zoneSpec.Name, zoneSpec.LinkName)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete vnet link %s of private DNS zone %s", zoneSpec.LinkName, zoneSpec.Name)
+	}
+
+	existing, err := s.Client.GetZone(ctx, s.Scope.ResourceGroup(), zoneSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		log.V(2).Info("private DNS zone already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get private DNS zone %s in resource group %s", zoneSpec.Name, s.Scope.ResourceGroup())
+	}
+	if !converters.MapToTags(existing.Tags).HasOwned(s.Scope.Name()) {
+		log.V(2).Info("private DNS zone is not owned by the cluster, skipping deletion")
+		return nil
+	}
+
+	log.V(2).Info("deleting private DNS zone")
+	err = s.Client.DeleteZone(ctx, s.Scope.ResourceGroup(), zoneSpec.Name)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete private DNS zone %s in resource group %s", zoneSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted private DNS zone")
+	return nil
+}
@@ -0,0 +1,331 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privatednszones
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/privatednszones/mock_privatednszones"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const vnetID = "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/virtualNetworks/my-vnet"
+
+var ownedZoneTags = map[string]*string{
+	"Name": to.StringPtr("test-cluster.capz.io"),
+	"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+}
+
+func TestReconcilePrivateDNSZone(t *testing.T) {
+	upToDateLink := privatedns.VirtualNetworkLink{
+		VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+			VirtualNetwork:      &privatedns.SubResource{ID: to.StringPtr(vnetID)},
+			RegistrationEnabled: to.BoolPtr(true),
+		},
+	}
+
+	testcases := []struct {
+		name                string
+		registrationEnabled bool
+		records             []Record
+		expectedError       string
+		expect              func(m *mock_privatednszones.MockClientMockRecorder)
+	}{
+		{
+			name:                "zone and vnet link do not exist",
+			registrationEnabled: true,
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				gomock.InOrder(
+					m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").
+						Return(privatedns.PrivateZone{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")),
+					m.CreateOrUpdateZone(context.TODO(), "my-rg", "test-cluster.capz.io", privatedns.PrivateZone{
+						Location: to.StringPtr("global"),
+						Tags:     ownedZoneTags,
+					}),
+					m.GetLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").
+						Return(privatedns.VirtualNetworkLink{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")),
+					m.CreateOrUpdateLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link", privatedns.VirtualNetworkLink{
+						Location: to.StringPtr("global"),
+						Tags: map[string]*string{
+							"Name": to.StringPtr("test-cluster-vnet-link"),
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						},
+						VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+							VirtualNetwork:      &privatedns.SubResource{ID: to.StringPtr(vnetID)},
+							RegistrationEnabled: to.BoolPtr(true),
+						},
+					}),
+				)
+			},
+		},
+		{
+			name:                "zone and vnet link are up to date",
+			registrationEnabled: true,
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").Return(privatedns.PrivateZone{Tags: ownedZoneTags}, nil)
+				m.GetLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").Return(upToDateLink, nil)
+			},
+		},
+		{
+			name:                "zone is not owned by the cluster",
+			registrationEnabled: true,
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").
+					Return(privatedns.PrivateZone{Tags: map[string]*string{"foo": to.StringPtr("bar")}}, nil)
+				m.GetLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").Return(upToDateLink, nil)
+			},
+		},
+		{
+			name:                "zone exists without the additional tags",
+			registrationEnabled: true,
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").Return(privatedns.PrivateZone{
+					Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"foo": to.StringPtr("bar"),
+					},
+				}, nil)
+				m.CreateOrUpdateZone(context.TODO(), "my-rg", "test-cluster.capz.io", privatedns.PrivateZone{
+					Location: to.StringPtr("global"),
+					Tags: map[string]*string{
+						"Name": to.StringPtr("test-cluster.capz.io"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"foo": to.StringPtr("bar"),
+					},
+				})
+				m.GetLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").Return(upToDateLink, nil)
+			},
+		},
+		{
+			name:                "vnet link without registration",
+			registrationEnabled: true,
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").Return(privatedns.PrivateZone{Tags: ownedZoneTags}, nil)
+				m.GetLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").
+					Return(privatedns.VirtualNetworkLink{
+						VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+							VirtualNetwork:      &privatedns.SubResource{ID: to.StringPtr(vnetID)},
+							RegistrationEnabled: to.BoolPtr(false),
+						},
+					}, nil)
+				m.CreateOrUpdateLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link", gomock.AssignableToTypeOf(privatedns.VirtualNetworkLink{}))
+			},
+		},
+		{
+			name: "A records of the machines",
+			records: []Record{
+				{Hostname: "machine-0", IP: "10.0.0.4"},
+				{Hostname: "machine-1", IP: "10.1.0.4"},
+				{Hostname: "machine-2", IP: "10.1.0.5"},
+			},
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").Return(privatedns.PrivateZone{Tags: ownedZoneTags}, nil)
+				m.GetLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").
+					Return(privatedns.VirtualNetworkLink{
+						VirtualNetworkLinkProperties: &privatedns.VirtualNetworkLinkProperties{
+							VirtualNetwork:      &privatedns.SubResource{ID: to.StringPtr(vnetID)},
+							RegistrationEnabled: to.BoolPtr(false),
+						},
+					}, nil)
+				m.ListRecordSets(context.TODO(), "my-rg", "test-cluster.capz.io").Return([]privatedns.RecordSet{
+					{
+						Name: to.StringPtr("machine-0"),
+						RecordSetProperties: &privatedns.RecordSetProperties{
+							ARecords: &[]privatedns.ARecord{{Ipv4Address: to.StringPtr("10.0.0.4")}},
+						},
+					},
+					{
+						Name: to.StringPtr("machine-1"),
+						RecordSetProperties: &privatedns.RecordSetProperties{
+							ARecords: &[]privatedns.ARecord{{Ipv4Address: to.StringPtr("10.1.0.9")}},
+						},
+					},
+				}, nil)
+				m.CreateOrUpdateRecordSet(context.TODO(), "my-rg", "test-cluster.capz.io", "machine-1", privatedns.RecordSet{
+					RecordSetProperties: &privatedns.RecordSetProperties{
+						TTL:      to.Int64Ptr(300),
+						ARecords: &[]privatedns.ARecord{{Ipv4Address: to.StringPtr("10.1.0.4")}},
+					},
+				})
+				m.CreateOrUpdateRecordSet(context.TODO(), "my-rg", "test-cluster.capz.io", "machine-2", privatedns.RecordSet{
+					RecordSetProperties: &privatedns.RecordSetProperties{
+						TTL:      to.Int64Ptr(300),
+						ARecords: &[]privatedns.ARecord{{Ipv4Address: to.StringPtr("10.1.0.5")}},
+					},
+				})
+			},
+		},
+		{
+			name:                "fail to get zone",
+			registrationEnabled: true,
+			expectedError:       "failed to get private DNS zone test-cluster.capz.io in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").
+					Return(privatedns.PrivateZone{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			privateDNSMock := mock_privatednszones.NewMockClient(mockCtrl)
+
+			tc.expect(privateDNSMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: privateDNSMock,
+			}
+
+			zoneSpec := &Spec{
+				Name:                "test-cluster.capz.io",
+				LinkName:            "test-cluster-vnet-link",
+				VnetID:              vnetID,
+				RegistrationEnabled: tc.registrationEnabled,
+				Records:             tc.records,
+			}
+			err = s.Reconcile(context.TODO(), zoneSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeletePrivateDNSZone(t *testing.T) {
+	testcases := []struct {
+		name   string
+		expect func(m *mock_privatednszones.MockClientMockRecorder)
+	}{
+		{
+			name: "zone and vnet link exist",
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				gomock.InOrder(
+					m.DeleteLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link"),
+					m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").Return(privatedns.PrivateZone{Tags: ownedZoneTags}, nil),
+					m.DeleteZone(context.TODO(), "my-rg", "test-cluster.capz.io"),
+				)
+			},
+		},
+		{
+			name: "zone and vnet link already deleted",
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.DeleteLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").
+					Return(privatedns.PrivateZone{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name: "zone deleted concurrently",
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.DeleteLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link")
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").Return(privatedns.PrivateZone{Tags: ownedZoneTags}, nil)
+				m.DeleteZone(context.TODO(), "my-rg", "test-cluster.capz.io").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name: "zone is not owned by the cluster",
+			expect: func(m *mock_privatednszones.MockClientMockRecorder) {
+				m.DeleteLink(context.TODO(), "my-rg", "test-cluster.capz.io", "test-cluster-vnet-link")
+				m.GetZone(context.TODO(), "my-rg", "test-cluster.capz.io").
+					Return(privatedns.PrivateZone{Tags: map[string]*string{"foo": to.StringPtr("bar")}}, nil)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			privateDNSMock := mock_privatednszones.NewMockClient(mockCtrl)
+
+			tc.expect(privateDNSMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: privateDNSMock,
+			}
+
+			zoneSpec := &Spec{
+				Name:     "test-cluster.capz.io",
+				LinkName: "test-cluster-vnet-link",
+				VnetID:   vnetID,
+			}
+			if err := s.Delete(context.TODO(), zoneSpec); err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privateendpoints
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string) (network.PrivateEndpoint, error)
+	CreateOrUpdate(context.Context, string, string, network.PrivateEndpoint) error
+	Delete(context.Context, string, string) error
+	GetPrivateLinkService(context.Context, string, string) (network.PrivateLinkService, error)
+	CreateOrUpdatePrivateLinkService(context.Context, string, string, network.PrivateLinkService) error
+	DeletePrivateLinkService(context.Context, string, string) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	privateendpoints    network.PrivateEndpointsClient
+	privatelinkservices network.PrivateLinkServicesClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new private endpoints client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	e := newPrivateEndpointsClient(subscriptionID, baseURI, authorizer)
+	s := newPrivateLinkServicesClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{e, s}
+}
+
+// newPrivateEndpointsClient creates a new private endpoints client from subscription ID and base URI.
+func newPrivateEndpointsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.PrivateEndpointsClient {
+	privateEndpointsClient := network.NewPrivateEndpointsClientWithBaseURI(baseURI, subscriptionID)
+	privateEndpointsClient.Authorizer = authorizer
+	privateEndpointsClient.AddToUserAgent(azure.UserAgent)
+	return privateEndpointsClient
+}
+
+// newPrivateLinkServicesClient creates a new private link services client from subscription ID and base URI.
+func newPrivateLinkServicesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.PrivateLinkServicesClient {
+	privateLinkServicesClient := network.NewPrivateLinkServicesClientWithBaseURI(baseURI, subscriptionID)
+	privateLinkServicesClient.Authorizer = authorizer
+	privateLinkServicesClient.AddToUserAgent(azure.UserAgent)
+	return privateLinkServicesClient
+}
+
+// Get gets the specified private endpoint.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, name string) (network.PrivateEndpoint, error) {
+	var result network.PrivateEndpoint
+	err := azure.CallAPI(ctx, "privateendpoints", "Get", func() error {
+		var err error
+		result, err = ac.privateendpoints.Get(ctx, resourceGroupName, name, "")
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdate creates or updates a private endpoint in the specified resource group.
+func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, name string, privateEndpoint network.PrivateEndpoint) error {
+	var future network.PrivateEndpointsCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "privateendpoints", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.privateendpoints.CreateOrUpdate(ctx, resourceGroupName, name, privateEndpoint)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.privateendpoints.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.privateendpoints)
+		return err
+	})
+}
+
+// Delete deletes the specified private endpoint.
+func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, name string) error {
+	var future network.PrivateEndpointsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "privateendpoints", "Delete", func() error {
+		var err error
+		future, err = ac.privateendpoints.Delete(ctx, resourceGroupName, name)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.privateendpoints.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.privateendpoints)
+		return err
+	})
+}
+
+// GetPrivateLinkService gets the specified private link service.
+func (ac *AzureClient) GetPrivateLinkService(ctx context.Context, resourceGroupName, name string) (network.PrivateLinkService, error) {
+	var result network.PrivateLinkService
+	err := azure.CallAPI(ctx, "privateendpoints", "GetPrivateLinkService", func() error {
+		var err error
+		result, err = ac.privatelinkservices.Get(ctx, resourceGroupName, name, "")
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdatePrivateLinkService creates or updates a private link service in the specified resource group.
+func (ac *AzureClient) CreateOrUpdatePrivateLinkService(ctx context.Context, resourceGroupName, name string, privateLinkService network.PrivateLinkService) error {
+	var future network.PrivateLinkServicesCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "privateendpoints", "CreateOrUpdatePrivateLinkService", func() error {
+		var err error
+		future, err = ac.privatelinkservices.CreateOrUpdate(ctx, resourceGroupName, name, privateLinkService)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.privatelinkservices.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.privatelinkservices)
+		return err
+	})
+}
+
+// DeletePrivateLinkService deletes the specified private link service.
+func (ac *AzureClient) DeletePrivateLinkService(ctx context.Context, resourceGroupName, name string) error {
+	var future network.PrivateLinkServicesDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "privateendpoints", "DeletePrivateLinkService", func() error {
+		var err error
+		future, err = ac.privatelinkservices.Delete(ctx, resourceGroupName, name)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.privatelinkservices.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.privatelinkservices)
+		return err
+	})
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privateendpoints
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for the private endpoint of the API server.
+type Spec struct {
+	Name     string
+	SubnetID string
+	// PrivateLinkServiceName is the name of the private link service which the private endpoint connects to. The
+	// private link service is created on the frontend IP configuration of the load balancer LoadBalancerName.
+	PrivateLinkServiceName string
+	LoadBalancerName       string
+}
+
+// Get provides information about a private endpoint.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	peSpec, ok := spec.(*Spec)
+	if !ok {
+		return network.PrivateEndpoint{}, errors.New("invalid private endpoint specification")
+	}
+	privateEndpoint, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), peSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "private endpoint %s not found", peSpec.Name)
+	} else if err != nil {
+		return privateEndpoint, err
+	}
+	return privateEndpoint, nil
+}
+
+// Reconcile gets/creates/updates a private endpoint and the private link service it connects to.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	peSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid private endpoint specification")
+	}
+	log := s.Scope.ResourceLogger(peSpec.Name)
+	log.V(4).Info("reconciling private endpoint")
+
+	privateLinkService, err := s.reconcilePrivateLinkService(ctx, peSpec)
+	if err != nil {
+		return err
+	}
+
+	tags := s.Scope.ResourceTags(peSpec.Name, "")
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), peSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get private endpoint %s in resource group %s", peSpec.Name, s.Scope.ResourceGroup())
+	case err == nil:
+		var tagsChanged bool
+		tags, tagsChanged = converters.MapToTags(existing.Tags).Merged(tags)
+		if isConnectedTo(existing, to.String(privateLinkService.ID)) && !tagsChanged {
+			log.V(4).Info("private endpoint is up to date")
+			return nil
+		}
+	}
+
+	privateEndpoint := network.PrivateEndpoint{
+		Name:     to.StringPtr(peSpec.Name),
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(tags),
+		PrivateEndpointProperties: &network.PrivateEndpointProperties{
+			Subnet: &network.Subnet{ID: to.StringPtr(peSpec.SubnetID)},
+			PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{
+				{
+					Name: to.StringPtr(peSpec.PrivateLinkServiceName),
+					PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+						PrivateLinkServiceID: privateLinkService.ID,
+					},
+				},
+			},
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("private endpoint", s.Scope.ResourceGroup(), peSpec.Name, privateEndpoint)) {
+		return nil
+	}
+
+	log.V(2).Info("creating private endpoint")
+	err = s.Client.CreateOrUpdate(
+		ctx,
+		s.Scope.ResourceGroup(),
+		peSpec.Name,
+		privateEndpoint,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create private endpoint %s in resource group %s", peSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully created private endpoint")
+	return nil
+}
+
+// Delete deletes the private endpoint and then the private link service it connects to.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	peSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid private endpoint specification")
+	}
+	log := s.Scope.ResourceLogger(peSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("private endpoint", s.Scope.ResourceGroup(), peSpec.Name)) &&
+		s.Scope.DryRun(scope.DeleteAction("private link service", s.Scope.ResourceGroup(), peSpec.PrivateLinkServiceName)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting private endpoint")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), peSpec.Name)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete private endpoint %s in resource group %s", peSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	// the private link service cannot be deleted while a private endpoint is connected to it.
+	log.V(2).Info("deleting private link service", "privateLinkService", peSpec.PrivateLinkServiceName)
+	err = s.Client.DeletePrivateLinkService(ctx, s.Scope.ResourceGroup(), peSpec.PrivateLinkServiceName)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete private link service %s in resource group %s", peSpec.PrivateLinkServiceName, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted private endpoint")
+	return nil
+}
+
+// reconcilePrivateLinkService gets or creates the private link service on the frontend IP configuration of the load
+// balancer. Its NAT IP configuration is in the subnet of the frontend IP configuration.
+func (s *Service) reconcilePrivateLinkService(ctx context.Context, peSpec *Spec) (network.PrivateLinkService, error) {
+	log := s.Scope.ResourceLogger(peSpec.PrivateLinkServiceName)
+
+	lb, err := s.LoadBalancersClient.Get(ctx, s.Scope.ResourceGroup(), peSpec.LoadBalancerName)
+	if err != nil {
+		return network.PrivateLinkService{}, errors.Wrapf(err, "failed to get load balancer %s", peSpec.LoadBalancerName)
+	}
+	if lb.LoadBalancerPropertiesFormat == nil || lb.FrontendIPConfigurations == nil || len(*lb.FrontendIPConfigurations) == 0 {
+		return network.PrivateLinkService{}, errors.Errorf("load balancer %s has no frontend IP configuration", peSpec.LoadBalancerName)
+	}
+	frontend := (*lb.FrontendIPConfigurations)[0]
+	if frontend.FrontendIPConfigurationPropertiesFormat == nil || frontend.Subnet == nil {
+		return network.PrivateLinkService{}, errors.Errorf("frontend IP configuration of load balancer %s has no subnet", peSpec.LoadBalancerName)
+	}
+
+	privateLinkService, err := s.Client.GetPrivateLinkService(ctx, s.Scope.ResourceGroup(), peSpec.PrivateLinkServiceName)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return privateLinkService, errors.Wrapf(err, "failed to get private link service %s in resource group %s", peSpec.PrivateLinkServiceName, s.Scope.ResourceGroup())
+	case err == nil && usesFrontendIPConfiguration(privateLinkService, to.String(frontend.ID)):
+		log.V(4).Info("private link service is up to date")
+		return privateLinkService, nil
+	}
+
+	desired := network.PrivateLinkService{
+		Name:     to.StringPtr(peSpec.PrivateLinkServiceName),
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(peSpec.PrivateLinkServiceName, "")),
+		PrivateLinkServiceProperties: &network.PrivateLinkServiceProperties{
+			LoadBalancerFrontendIPConfigurations: &[]network.FrontendIPConfiguration{{ID: frontend.ID}},
+			IPConfigurations: &[]network.PrivateLinkServiceIPConfiguration{
+				{
+					Name: to.StringPtr("nat-ipconfig"),
+					PrivateLinkServiceIPConfigurationProperties: &network.PrivateLinkServiceIPConfigurationProperties{
+						Subnet:                    &network.Subnet{ID: frontend.Subnet.ID},
+						PrivateIPAllocationMethod: network.Dynamic,
+						Primary:                   to.BoolPtr(true),
+					},
+				},
+			},
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("private link service", s.Scope.ResourceGroup(), peSpec.PrivateLinkServiceName, desired)) {
+		return privateLinkService, nil
+	}
+
+	log.V(2).Info("creating private link service")
+	err = s.Client.CreateOrUpdatePrivateLinkService(
+		ctx,
+		s.Scope.ResourceGroup(),
+		peSpec.PrivateLinkServiceName,
+		desired,
+	)
+	if err != nil {
+		return privateLinkService, errors.Wrapf(err, "failed to create private link service %s in resource group %s", peSpec.PrivateLinkServiceName, s.Scope.ResourceGroup())
+	}
+
+	privateLinkService, err = s.Client.GetPrivateLinkService(ctx, s.Scope.ResourceGroup(), peSpec.PrivateLinkServiceName)
+	if err != nil {
+		return privateLinkService, errors.Wrapf(err, "failed to get private link service %s in resource group %s", peSpec.PrivateLinkServiceName, s.Scope.ResourceGroup())
+	}
+	log.V(2).Info("successfully created private link service")
+	return privateLinkService, nil
+}
+
+// usesFrontendIPConfiguration returns true if the private link service is on the frontend IP configuration with the
+// given ID.
+func usesFrontendIPConfiguration(privateLinkService network.PrivateLinkService, frontendID string) bool {
+	if privateLinkService.PrivateLinkServiceProperties == nil || privateLinkService.LoadBalancerFrontendIPConfigurations == nil {
+		return false
+	}
+	for _, frontend := range *privateLinkService.LoadBalancerFrontendIPConfigurations {
+		if to.String(frontend.ID) == frontendID {
+			return true
+		}
+	}
+	return false
+}
+
+// isConnectedTo returns true if the private endpoint connects to the private link service with the given ID.
+func isConnectedTo(privateEndpoint network.PrivateEndpoint, privateLinkServiceID string) bool {
+	if privateEndpoint.PrivateEndpointProperties == nil || privateEndpoint.PrivateLinkServiceConnections == nil {
+		return false
+	}
+	for _, connection := range *privateEndpoint.PrivateLinkServiceConnections {
+		if connection.PrivateLinkServiceConnectionProperties != nil && to.String(connection.PrivateLinkServiceID) == privateLinkServiceID {
+			return true
+		}
+	}
+	return false
+}
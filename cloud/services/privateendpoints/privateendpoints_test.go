@@ -0,0 +1,297 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privateendpoints
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/internalloadbalancers/mock_internalloadbalancers"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/privateendpoints/mock_privateendpoints"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcilePrivateEndpoint(t *testing.T) {
+	internalLB := network.LoadBalancer{
+		Name: to.StringPtr("my-lb"),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			FrontendIPConfigurations: &[]network.FrontendIPConfiguration{
+				{
+					ID: to.StringPtr("frontend-id"),
+					FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+						Subnet: &network.Subnet{ID: to.StringPtr("cp-subnet-id")},
+					},
+				},
+			},
+		},
+	}
+	privateLinkService := network.PrivateLinkService{
+		ID:   to.StringPtr("my-pls-id"),
+		Name: to.StringPtr("my-pls"),
+		PrivateLinkServiceProperties: &network.PrivateLinkServiceProperties{
+			LoadBalancerFrontendIPConfigurations: &[]network.FrontendIPConfiguration{{ID: to.StringPtr("frontend-id")}},
+		},
+	}
+	ownedTags := func(name string) map[string]*string {
+		return map[string]*string{
+			"Name": to.StringPtr(name),
+			"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+		}
+	}
+	desiredPrivateEndpoint := network.PrivateEndpoint{
+		Name:     to.StringPtr("my-pe"),
+		Location: to.StringPtr("test-location"),
+		Tags:     ownedTags("my-pe"),
+		PrivateEndpointProperties: &network.PrivateEndpointProperties{
+			Subnet: &network.Subnet{ID: to.StringPtr("clients-subnet-id")},
+			PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{
+				{
+					Name: to.StringPtr("my-pls"),
+					PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+						PrivateLinkServiceID: to.StringPtr("my-pls-id"),
+					},
+				},
+			},
+		},
+	}
+
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_privateendpoints.MockClientMockRecorder, mlb *mock_internalloadbalancers.MockClientMockRecorder)
+	}{
+		{
+			name: "private link service and private endpoint do not exist",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder, mlb *mock_internalloadbalancers.MockClientMockRecorder) {
+				gomock.InOrder(
+					mlb.Get(context.TODO(), "my-rg", "my-lb").Return(internalLB, nil),
+					m.GetPrivateLinkService(context.TODO(), "my-rg", "my-pls").
+						Return(network.PrivateLinkService{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")),
+					m.CreateOrUpdatePrivateLinkService(context.TODO(), "my-rg", "my-pls", network.PrivateLinkService{
+						Name:     to.StringPtr("my-pls"),
+						Location: to.StringPtr("test-location"),
+						Tags:     ownedTags("my-pls"),
+						PrivateLinkServiceProperties: &network.PrivateLinkServiceProperties{
+							LoadBalancerFrontendIPConfigurations: &[]network.FrontendIPConfiguration{{ID: to.StringPtr("frontend-id")}},
+							IPConfigurations: &[]network.PrivateLinkServiceIPConfiguration{
+								{
+									Name: to.StringPtr("nat-ipconfig"),
+									PrivateLinkServiceIPConfigurationProperties: &network.PrivateLinkServiceIPConfigurationProperties{
+										Subnet:                    &network.Subnet{ID: to.StringPtr("cp-subnet-id")},
+										PrivateIPAllocationMethod: network.Dynamic,
+										Primary:                   to.BoolPtr(true),
+									},
+								},
+							},
+						},
+					}),
+					m.GetPrivateLinkService(context.TODO(), "my-rg", "my-pls").Return(privateLinkService, nil),
+					m.Get(context.TODO(), "my-rg", "my-pe").
+						Return(network.PrivateEndpoint{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")),
+					m.CreateOrUpdate(context.TODO(), "my-rg", "my-pe", desiredPrivateEndpoint),
+				)
+			},
+		},
+		{
+			name: "private link service and private endpoint are up to date",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder, mlb *mock_internalloadbalancers.MockClientMockRecorder) {
+				existing := desiredPrivateEndpoint
+				existing.ID = to.StringPtr("my-pe-id")
+				mlb.Get(context.TODO(), "my-rg", "my-lb").Return(internalLB, nil)
+				m.GetPrivateLinkService(context.TODO(), "my-rg", "my-pls").Return(privateLinkService, nil)
+				m.Get(context.TODO(), "my-rg", "my-pe").Return(existing, nil)
+			},
+		},
+		{
+			name: "private endpoint connected to another private link service is updated",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder, mlb *mock_internalloadbalancers.MockClientMockRecorder) {
+				mlb.Get(context.TODO(), "my-rg", "my-lb").Return(internalLB, nil)
+				m.GetPrivateLinkService(context.TODO(), "my-rg", "my-pls").Return(privateLinkService, nil)
+				m.Get(context.TODO(), "my-rg", "my-pe").Return(network.PrivateEndpoint{
+					Name: to.StringPtr("my-pe"),
+					Tags: ownedTags("my-pe"),
+					PrivateEndpointProperties: &network.PrivateEndpointProperties{
+						PrivateLinkServiceConnections: &[]network.PrivateLinkServiceConnection{
+							{
+								PrivateLinkServiceConnectionProperties: &network.PrivateLinkServiceConnectionProperties{
+									PrivateLinkServiceID: to.StringPtr("other-pls-id"),
+								},
+							},
+						},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-pe", desiredPrivateEndpoint)
+			},
+		},
+		{
+			name:          "internal load balancer without frontend IP configuration",
+			expectedError: "load balancer my-lb has no frontend IP configuration",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder, mlb *mock_internalloadbalancers.MockClientMockRecorder) {
+				mlb.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{Name: to.StringPtr("my-lb")}, nil)
+			},
+		},
+		{
+			name:          "fail to create the private endpoint",
+			expectedError: "failed to create private endpoint my-pe in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder, mlb *mock_internalloadbalancers.MockClientMockRecorder) {
+				mlb.Get(context.TODO(), "my-rg", "my-lb").Return(internalLB, nil)
+				m.GetPrivateLinkService(context.TODO(), "my-rg", "my-pls").Return(privateLinkService, nil)
+				m.Get(context.TODO(), "my-rg", "my-pe").
+					Return(network.PrivateEndpoint{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-pe", desiredPrivateEndpoint).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			privateEndpointMock := mock_privateendpoints.NewMockClient(mockCtrl)
+			loadBalancerMock := mock_internalloadbalancers.NewMockClient(mockCtrl)
+			tc.expect(privateEndpointMock.EXPECT(), loadBalancerMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:               clusterScope,
+				Client:              privateEndpointMock,
+				LoadBalancersClient: loadBalancerMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{
+				Name:                   "my-pe",
+				SubnetID:               "clients-subnet-id",
+				PrivateLinkServiceName: "my-pls",
+				LoadBalancerName:       "my-lb",
+			})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeletePrivateEndpoint(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_privateendpoints.MockClientMockRecorder)
+	}{
+		{
+			name: "private endpoint is deleted before the private link service",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder) {
+				gomock.InOrder(
+					m.Delete(context.TODO(), "my-rg", "my-pe"),
+					m.DeletePrivateLinkService(context.TODO(), "my-rg", "my-pls"),
+				)
+			},
+		},
+		{
+			name: "private endpoint and private link service already deleted",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-pe").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.DeletePrivateLinkService(context.TODO(), "my-rg", "my-pls").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "private link service is kept when the private endpoint cannot be deleted",
+			expectedError: "failed to delete private endpoint my-pe in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_privateendpoints.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-pe").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			privateEndpointMock := mock_privateendpoints.NewMockClient(mockCtrl)
+			tc.expect(privateEndpointMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: privateEndpointMock,
+			}
+
+			err = s.Delete(context.TODO(), &Spec{Name: "my-pe", PrivateLinkServiceName: "my-pls"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proximityplacementgroups
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for proximity placement group
+type Spec struct {
+	Name string
+}
+
+// Get provides information about a proximity placement group.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	ppgSpec, ok := spec.(*Spec)
+	if !ok {
+		return compute.ProximityPlacementGroup{}, errors.New("invalid proximity placement group specification")
+	}
+	proximityPlacementGroup, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), ppgSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "proximity placement group %s not found", ppgSpec.Name)
+	} else if err != nil {
+		return proximityPlacementGroup, err
+	}
+	return proximityPlacementGroup, nil
+}
+
+// Reconcile gets/creates/updates a proximity placement group.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	ppgSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid proximity placement group specification")
+	}
+	log := s.Scope.ResourceLogger(ppgSpec.Name)
+	ppg := compute.ProximityPlacementGroup{
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(ppgSpec.Name, "")),
+		ProximityPlacementGroupProperties: &compute.ProximityPlacementGroupProperties{
+			ProximityPlacementGroupType: compute.Standard,
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("proximity placement group", s.Scope.ResourceGroup(), ppgSpec.Name, ppg)) {
+		return nil
+	}
+
+	log.V(2).Info("creating proximity placement group")
+	err := s.Client.CreateOrUpdate(
+		ctx,
+		s.Scope.ResourceGroup(),
+		ppgSpec.Name,
+		ppg,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create proximity placement group %s in resource group %s", ppgSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully created proximity placement group")
+	return nil
+}
+
+// Delete deletes the proximity placement group with the provided name. Azure only deletes a proximity placement group
+// once its virtual machines and availability sets are gone.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	ppgSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid proximity placement group specification")
+	}
+	log := s.Scope.ResourceLogger(ppgSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("proximity placement group", s.Scope.ResourceGroup(), ppgSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting proximity placement group")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), ppgSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("proximity placement group is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete proximity placement group %s in resource group %s", ppgSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted proximity placement group")
+	return nil
+}
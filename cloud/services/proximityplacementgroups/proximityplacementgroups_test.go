@@ -0,0 +1,180 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proximityplacementgroups
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/proximityplacementgroups/mock_proximityplacementgroups"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileProximityPlacementGroups(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_proximityplacementgroups.MockClientMockRecorder)
+	}{
+		{
+			name: "proximity placement group is created",
+			expect: func(m *mock_proximityplacementgroups.MockClientMockRecorder) {
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-ppg", compute.ProximityPlacementGroup{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"Name": to.StringPtr("my-ppg"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+					},
+					ProximityPlacementGroupProperties: &compute.ProximityPlacementGroupProperties{
+						ProximityPlacementGroupType: compute.Standard,
+					},
+				})
+			},
+		},
+		{
+			name:          "fail to create proximity placement group",
+			expectedError: "failed to create proximity placement group my-ppg in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_proximityplacementgroups.MockClientMockRecorder) {
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-ppg", gomock.AssignableToTypeOf(compute.ProximityPlacementGroup{})).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			proximityPlacementGroupsMock := mock_proximityplacementgroups.NewMockClient(mockCtrl)
+			tc.expect(proximityPlacementGroupsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: proximityPlacementGroupsMock,
+			}
+			err = s.Reconcile(context.TODO(), &Spec{Name: "my-ppg"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteProximityPlacementGroups(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_proximityplacementgroups.MockClientMockRecorder)
+	}{
+		{
+			name: "proximity placement group exists",
+			expect: func(m *mock_proximityplacementgroups.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-ppg")
+			},
+		},
+		{
+			name: "proximity placement group already deleted",
+			expect: func(m *mock_proximityplacementgroups.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-ppg").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "proximity placement group still in use",
+			expectedError: "failed to delete proximity placement group my-ppg in resource group my-rg: #: Conflict: StatusCode=409",
+			expect: func(m *mock_proximityplacementgroups.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-ppg").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 409}, "Conflict"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			proximityPlacementGroupsMock := mock_proximityplacementgroups.NewMockClient(mockCtrl)
+			tc.expect(proximityPlacementGroupsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: proximityPlacementGroupsMock,
+			}
+			err = s.Delete(context.TODO(), &Spec{Name: "my-ppg"})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new public IP client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newPublicIPAddressesClient(subscriptionID, authorizer)
+// NewClient creates a new public IP client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newPublicIPAddressesClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newPublicIPAddressesClient creates a new public IP client from subscription ID.
-func newPublicIPAddressesClient(subscriptionID string, authorizer autorest.Authorizer) network.PublicIPAddressesClient {
-	publicIPsClient := network.NewPublicIPAddressesClient(subscriptionID)
+// newPublicIPAddressesClient creates a new public IP client from subscription ID and base URI.
+func newPublicIPAddressesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.PublicIPAddressesClient {
+	publicIPsClient := network.NewPublicIPAddressesClientWithBaseURI(baseURI, subscriptionID)
 	publicIPsClient.Authorizer = authorizer
 	publicIPsClient.AddToUserAgent(azure.UserAgent)
 	return publicIPsClient
@@ -54,33 +54,43 @@ func newPublicIPAddressesClient(subscriptionID string, authorizer autorest.Autho
 
 // Get gets the specified public IP address in a specified resource group.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, ipName string) (network.PublicIPAddress, error) {
-	return ac.publicips.Get(ctx, resourceGroupName, ipName, "")
+	var result network.PublicIPAddress
+	err := azure.CallAPI(ctx, "publicips", "Get", func() error {
+		var err error
+		result, err = ac.publicips.Get(ctx, resourceGroupName, ipName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a static or dynamic public IP address.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, ipName string, ip network.PublicIPAddress) error {
-	future, err := ac.publicips.CreateOrUpdate(ctx, resourceGroupName, ipName, ip)
-	if err != nil {
+	var future network.PublicIPAddressesCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "publicips", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.publicips.CreateOrUpdate(ctx, resourceGroupName, ipName, ip)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.publicips.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.publicips.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.publicips)
 		return err
-	}
-	_, err = future.Result(ac.publicips)
-	return err
+	})
 }
 
 // Delete deletes the specified public IP address.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, ipName string) error {
-	future, err := ac.publicips.Delete(ctx, resourceGroupName, ipName)
-	if err != nil {
+	var future network.PublicIPAddressesDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "publicips", "Delete", func() error {
+		var err error
+		future, err = ac.publicips.Delete(ctx, resourceGroupName, ipName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.publicips.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.publicips.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.publicips)
 		return err
-	}
-	_, err = future.Result(ac.publicips)
-	return err
+	})
 }
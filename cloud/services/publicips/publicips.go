@@ -18,18 +18,31 @@ package publicips
 
 import (
 	"context"
-	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for public ip
 type Spec struct {
 	Name string
+	// DNSName is the DNS name label of the public IP. The public IP has no DNS name if it's empty.
+	DNSName string
+	// SKU is the SKU of the public IP. Defaults to Standard, the SKU the load balancers use.
+	SKU network.PublicIPAddressSkuName
+	// AllocationMethod is Static or Dynamic. Defaults to Static so that the address survives restarts of the resources
+	// that use it. Standard public IPs only support Static.
+	AllocationMethod network.IPAllocationMethod
+	// Zones are the availability zones of the public IP, all the zones of the location for a zone-redundant public IP.
+	// Only Standard public IPs support availability zones.
+	Zones []string
+	// ExternallyManaged marks a public IP managed outside of the cluster, which must exist and is neither updated nor
+	// deleted.
+	ExternallyManaged bool
 }
 
 // Get provides information about a public ip.
@@ -53,34 +66,70 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid PublicIP Specification")
 	}
+	log := s.Scope.ResourceLogger(publicIPSpec.Name)
 	ipName := publicIPSpec.Name
-	klog.V(2).Infof("creating public ip %s", ipName)
+
+	sku := publicIPSpec.SKU
+	if sku == "" {
+		sku = network.PublicIPAddressSkuNameStandard
+	}
+	allocationMethod := publicIPSpec.AllocationMethod
+	if allocationMethod == "" {
+		allocationMethod = network.Static
+	}
+	switch {
+	case allocationMethod != network.Static && allocationMethod != network.Dynamic:
+		return errors.Errorf("invalid allocation method %s for public ip %s, must be %s or %s", allocationMethod, ipName, network.Static, network.Dynamic)
+	case sku == network.PublicIPAddressSkuNameStandard && allocationMethod == network.Dynamic:
+		return errors.Errorf("public ip %s cannot use %s allocation, %s public ips only support %s allocation", ipName, network.Dynamic, sku, network.Static)
+	case sku != network.PublicIPAddressSkuNameStandard && len(publicIPSpec.Zones) > 0:
+		return errors.Errorf("public ip %s cannot be in availability zones, %s public ips do not support them", ipName, sku)
+	}
+
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), ipName)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get public ip %s", ipName)
+	case err != nil && publicIPSpec.ExternallyManaged:
+		return errors.Wrapf(err, "externally managed public ip %s not found", ipName)
+	case err == nil && !s.isManaged(existing, publicIPSpec):
+		log.V(2).Info("public ip is not managed by the cluster, skipping update")
+		return nil
+	}
+
+	log.V(2).Info("creating public ip")
 
 	// https://docs.microsoft.com/en-us/azure/load-balancer/load-balancer-standard-availability-zones#zone-redundant-by-default
-	err := s.Client.CreateOrUpdate(
-		ctx,
-		s.Scope.ResourceGroup(),
-		ipName,
-		network.PublicIPAddress{
-			Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard},
-			Name:     to.StringPtr(ipName),
-			Location: to.StringPtr(s.Scope.Location()),
-			PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
-				PublicIPAddressVersion:   network.IPv4,
-				PublicIPAllocationMethod: network.Static,
-				DNSSettings: &network.PublicIPAddressDNSSettings{
-					DomainNameLabel: to.StringPtr(strings.ToLower(ipName)),
-					Fqdn:            to.StringPtr(s.Scope.Network().APIServerIP.DNSName),
-				},
-			},
+	publicIP := network.PublicIPAddress{
+		Sku:      &network.PublicIPAddressSku{Name: sku},
+		Name:     to.StringPtr(ipName),
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(ipName, "")),
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAddressVersion:   network.IPv4,
+			PublicIPAllocationMethod: allocationMethod,
 		},
-	)
+	}
+	if len(publicIPSpec.Zones) > 0 {
+		zones := publicIPSpec.Zones
+		publicIP.Zones = &zones
+	}
+	// The public IP is updated in place, so a DNS name label that differs from the existing one replaces it.
+	if publicIPSpec.DNSName != "" {
+		publicIP.DNSSettings = &network.PublicIPAddressDNSSettings{
+			DomainNameLabel: to.StringPtr(publicIPSpec.DNSName),
+		}
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("public ip", s.Scope.ResourceGroup(), ipName, publicIP)) {
+		return nil
+	}
 
+	err = s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), ipName, publicIP)
 	if err != nil {
 		return errors.Wrap(err, "cannot create public ip")
 	}
 
-	klog.V(2).Infof("successfully created public ip %s", ipName)
+	log.V(2).Info("successfully created public ip")
 	return nil
 }
 
@@ -90,16 +139,45 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid PublicIP Specification")
 	}
-	klog.V(2).Infof("deleting public ip %s", publicIPSpec.Name)
-	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), publicIPSpec.Name)
+	log := s.Scope.ResourceLogger(publicIPSpec.Name)
+
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), publicIPSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		log.V(4).Info("public ip is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get public ip %s in resource group %s", publicIPSpec.Name, s.Scope.ResourceGroup())
+	}
+	if !s.isManaged(existing, publicIPSpec) {
+		log.V(2).Info("public ip is not managed by the cluster, skipping deletion")
+		return nil
+	}
+
+	if s.Scope.DryRun(scope.DeleteAction("public ip", s.Scope.ResourceGroup(), publicIPSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting public ip")
+	err = s.Client.Delete(ctx, s.Scope.ResourceGroup(), publicIPSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("public ip is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete public ip %s in resource group %s", publicIPSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("deleted public ip %s", publicIPSpec.Name)
-	return err
+	log.V(2).Info("successfully deleted public ip")
+	return nil
+}
+
+// isManaged returns true if the public IP was created for the cluster, i.e. it is tagged as owned by the cluster, and
+// it is not externally managed.
+func (s *Service) isManaged(publicIP network.PublicIPAddress, publicIPSpec *Spec) bool {
+	if publicIPSpec.ExternallyManaged {
+		return false
+	}
+	return converters.MapToTags(publicIP.Tags).HasOwned(s.Scope.Name())
 }
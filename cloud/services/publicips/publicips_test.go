@@ -0,0 +1,352 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package publicips
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips/mock_publicips"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// notFound is the error of the Azure API for a public IP which does not exist.
+var notFound = autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+// ownedIP and foreignIP are existing public IPs, the first created for the cluster and the second provided by a user.
+var (
+	ownedIP = network.PublicIPAddress{
+		Name: to.StringPtr("my-publicip"),
+		Tags: map[string]*string{"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned")},
+	}
+	foreignIP = network.PublicIPAddress{
+		Name: to.StringPtr("my-publicip"),
+		Tags: map[string]*string{"team": to.StringPtr("networking")},
+	}
+)
+
+func TestReconcilePublicIP(t *testing.T) {
+	ipTags := map[string]*string{
+		"Name": to.StringPtr("my-publicip"),
+		"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+	}
+	testcases := []struct {
+		name          string
+		spec          *Spec
+		expectedError string
+		expect        func(m *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name: "public ip with a dns name label",
+			spec: &Spec{Name: "my-publicip", DNSName: "my-api"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-publicip", network.PublicIPAddress{
+					Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard},
+					Name:     to.StringPtr("my-publicip"),
+					Location: to.StringPtr("test-location"),
+					Tags:     ipTags,
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						PublicIPAddressVersion:   network.IPv4,
+						PublicIPAllocationMethod: network.Static,
+						DNSSettings: &network.PublicIPAddressDNSSettings{
+							DomainNameLabel: to.StringPtr("my-api"),
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "public ip without a dns name label",
+			spec: &Spec{Name: "my-publicip"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-publicip", network.PublicIPAddress{
+					Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard},
+					Name:     to.StringPtr("my-publicip"),
+					Location: to.StringPtr("test-location"),
+					Tags:     ipTags,
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						PublicIPAddressVersion:   network.IPv4,
+						PublicIPAllocationMethod: network.Static,
+					},
+				})
+			},
+		},
+		{
+			name: "basic public ip with dynamic allocation",
+			spec: &Spec{Name: "my-publicip", SKU: network.PublicIPAddressSkuNameBasic, AllocationMethod: network.Dynamic},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-publicip", network.PublicIPAddress{
+					Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameBasic},
+					Name:     to.StringPtr("my-publicip"),
+					Location: to.StringPtr("test-location"),
+					Tags:     ipTags,
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						PublicIPAddressVersion:   network.IPv4,
+						PublicIPAllocationMethod: network.Dynamic,
+					},
+				})
+			},
+		},
+		{
+			name: "basic public ip with static allocation",
+			spec: &Spec{Name: "my-publicip", SKU: network.PublicIPAddressSkuNameBasic, AllocationMethod: network.Static},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-publicip", network.PublicIPAddress{
+					Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameBasic},
+					Name:     to.StringPtr("my-publicip"),
+					Location: to.StringPtr("test-location"),
+					Tags:     ipTags,
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						PublicIPAddressVersion:   network.IPv4,
+						PublicIPAllocationMethod: network.Static,
+					},
+				})
+			},
+		},
+		{
+			name: "zone-redundant public ip",
+			spec: &Spec{Name: "my-publicip", Zones: []string{"1", "2", "3"}},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-publicip", network.PublicIPAddress{
+					Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameStandard},
+					Name:     to.StringPtr("my-publicip"),
+					Location: to.StringPtr("test-location"),
+					Tags:     ipTags,
+					Zones:    &[]string{"1", "2", "3"},
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						PublicIPAddressVersion:   network.IPv4,
+						PublicIPAllocationMethod: network.Static,
+					},
+				})
+			},
+		},
+		{
+			name:          "basic public ip in availability zones",
+			spec:          &Spec{Name: "my-publicip", SKU: network.PublicIPAddressSkuNameBasic, Zones: []string{"1"}},
+			expectedError: "public ip my-publicip cannot be in availability zones, Basic public ips do not support them",
+			expect:        func(m *mock_publicips.MockClientMockRecorder) {},
+		},
+		{
+			name: "public ip owned by the cluster is updated",
+			spec: &Spec{Name: "my-publicip"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(ownedIP, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-publicip", gomock.Any())
+			},
+		},
+		{
+			name: "public ip provided by a user is left alone",
+			spec: &Spec{Name: "my-publicip", DNSName: "my-api"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(foreignIP, nil)
+			},
+		},
+		{
+			name: "externally managed public ip is left alone",
+			spec: &Spec{Name: "my-publicip", ExternallyManaged: true},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(ownedIP, nil)
+			},
+		},
+		{
+			name:          "externally managed public ip does not exist",
+			spec:          &Spec{Name: "my-publicip", ExternallyManaged: true},
+			expectedError: "externally managed public ip my-publicip not found: #: Not found: StatusCode=404",
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+			},
+		},
+		{
+			name:          "standard public ip with dynamic allocation",
+			spec:          &Spec{Name: "my-publicip", AllocationMethod: network.Dynamic},
+			expectedError: "public ip my-publicip cannot use Dynamic allocation, Standard public ips only support Static allocation",
+			expect:        func(m *mock_publicips.MockClientMockRecorder) {},
+		},
+		{
+			name:          "invalid allocation method",
+			spec:          &Spec{Name: "my-publicip", AllocationMethod: "Sticky"},
+			expectedError: "invalid allocation method Sticky for public ip my-publicip, must be Static or Dynamic",
+			expect:        func(m *mock_publicips.MockClientMockRecorder) {},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			publicIPsMock := mock_publicips.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(publicIPsMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: publicIPsMock,
+			}
+
+			err = s.Reconcile(context.TODO(), tc.spec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeletePublicIP(t *testing.T) {
+	testcases := []struct {
+		name          string
+		spec          *Spec
+		expectedError string
+		expect        func(m *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name: "public ip owned by the cluster",
+			spec: &Spec{Name: "my-publicip"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(ownedIP, nil)
+				m.Delete(context.TODO(), "my-rg", "my-publicip")
+			},
+		},
+		{
+			name: "public ip already deleted",
+			spec: &Spec{Name: "my-publicip"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(network.PublicIPAddress{}, notFound)
+			},
+		},
+		{
+			name: "public ip deleted concurrently",
+			spec: &Spec{Name: "my-publicip"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(ownedIP, nil)
+				m.Delete(context.TODO(), "my-rg", "my-publicip").Return(notFound)
+			},
+		},
+		{
+			name:          "public ip deletion is forbidden",
+			spec:          &Spec{Name: "my-publicip"},
+			expectedError: "failed to delete public ip my-publicip in resource group my-rg: #: Forbidden: StatusCode=403",
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(ownedIP, nil)
+				m.Delete(context.TODO(), "my-rg", "my-publicip").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 403}, "Forbidden"))
+			},
+		},
+		{
+			name: "public ip provided by a user is kept",
+			spec: &Spec{Name: "my-publicip"},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(foreignIP, nil)
+			},
+		},
+		{
+			name: "externally managed public ip is kept",
+			spec: &Spec{Name: "my-publicip", ExternallyManaged: true},
+			expect: func(m *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-publicip").Return(ownedIP, nil)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			publicIPsMock := mock_publicips.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(publicIPsMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: publicIPsMock,
+			}
+
+			err = s.Delete(context.TODO(), tc.spec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -23,16 +23,37 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for public load balancer
 type Spec struct {
-	Name         string
-	PublicIPName string
+	Name                  string
+	PublicIPName          string
+	OutboundPublicIPNames []string
+	// SKU is the SKU of the load balancer, which its public IPs must have as well. Defaults to Standard.
+	SKU network.LoadBalancerSkuName
+	// Probe is the health probe of the load balancer. Defaults to a TCP probe of the API server port.
+	Probe *network.ProbePropertiesFormat
+	// IdleTimeoutInMinutes is the idle timeout of the load balancing rules, between 4 and 30 minutes. Defaults to 4.
+	IdleTimeoutInMinutes *int32
+	// AdditionalRules are the load balancing rules of the load balancer in addition to the API server one.
+	AdditionalRules []Rule
+}
+
+// Rule is a load balancing rule of the load balancer, with its own health probe.
+type Rule struct {
+	Name string
+	// Protocol is the transport protocol of the rule. Defaults to Tcp.
+	Protocol     network.TransportProtocol
+	FrontendPort int32
+	BackendPort  int32
+	// BackendPoolName is the name of the backend pool of the rule. Defaults to the control plane backend pool.
+	BackendPoolName string
+	Probe           *network.ProbePropertiesFormat
 }
 
 // Get provides information about a public load balancer.
@@ -56,131 +77,236 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid public loadbalancer specification")
 	}
+	log := s.Scope.ResourceLogger(publicLBSpec.Name)
 	probeName := "tcpHTTPSProbe"
 	frontEndIPConfigName := "controlplane-lbFrontEnd"
-	backEndAddressPoolName := "controlplane-backEndPool"
+	backEndAddressPoolName := azure.ControlPlaneBackendPoolName
 	idPrefix := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/loadBalancers", s.Scope.SubscriptionID, s.Scope.ResourceGroup())
 	lbName := publicLBSpec.Name
-	klog.V(2).Infof("creating public load balancer %s", lbName)
+	sku := publicLBSpec.SKU
+	if sku == "" {
+		sku = network.LoadBalancerSkuNameStandard
+	}
+	idleTimeout := int32(azure.DefaultLoadBalancerIdleTimeoutInMinutes)
+	if publicLBSpec.IdleTimeoutInMinutes != nil {
+		idleTimeout = *publicLBSpec.IdleTimeoutInMinutes
+	}
+	if idleTimeout < azure.MinLoadBalancerIdleTimeoutInMinutes || idleTimeout > azure.MaxLoadBalancerIdleTimeoutInMinutes {
+		return errors.Errorf("invalid idle timeout of %d minutes for load balancer %s, must be between %d and %d minutes",
+			idleTimeout, lbName, azure.MinLoadBalancerIdleTimeoutInMinutes, azure.MaxLoadBalancerIdleTimeoutInMinutes)
+	}
+	probe := publicLBSpec.Probe
+	if probe == nil {
+		probe = &network.ProbePropertiesFormat{
+			Protocol:          network.ProbeProtocolTCP,
+			Port:              to.Int32Ptr(s.Scope.APIServerPort()),
+			IntervalInSeconds: to.Int32Ptr(15),
+			NumberOfProbes:    to.Int32Ptr(4),
+		}
+	}
+	if sku != network.LoadBalancerSkuNameStandard && len(publicLBSpec.OutboundPublicIPNames) > 0 {
+		return errors.Errorf("%s load balancer %s cannot use outbound public ips, outbound rules require a %s load balancer", sku, lbName, network.LoadBalancerSkuNameStandard)
+	}
+	if err := validateRules(publicLBSpec, s.Scope.APIServerPort()); err != nil {
+		return err
+	}
+	log.V(2).Info("creating public load balancer")
 
-	klog.V(2).Infof("getting public ip %s", publicLBSpec.PublicIPName)
+	log.V(4).Info("getting public ip", "publicIP", publicLBSpec.PublicIPName)
 	publicIP, err := s.PublicIPsClient.Get(ctx, s.Scope.ResourceGroup(), publicLBSpec.PublicIPName)
 	if err != nil {
 		return err
 	}
+	if err := validatePublicIPSKU(publicIP, sku); err != nil {
+		return err
+	}
 
-	klog.V(2).Infof("successfully got public ip %s", publicLBSpec.PublicIPName)
+	log.V(4).Info("successfully got public ip", "publicIP", publicLBSpec.PublicIPName)
 
-	// https://docs.microsoft.com/en-us/azure/load-balancer/load-balancer-standard-availability-zones#zone-redundant-by-default
-	err = s.Client.CreateOrUpdate(ctx,
-		s.Scope.ResourceGroup(),
-		lbName,
-		network.LoadBalancer{
-			Tags: converters.TagsToMap(infrav1.Build(infrav1.BuildParams{
-				ClusterName: s.Scope.Name(),
-				Lifecycle:   infrav1.ResourceLifecycleOwned,
-				Role:        to.StringPtr(infrav1.APIServerRoleTagValue),
-				Additional:  s.Scope.AdditionalTags(),
-			})),
-			Sku:      &network.LoadBalancerSku{Name: network.LoadBalancerSkuNameStandard},
-			Location: to.StringPtr(s.Scope.Location()),
-			LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
-				FrontendIPConfigurations: &[]network.FrontendIPConfiguration{
-					{
-						Name: &frontEndIPConfigName,
-						FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
-							PrivateIPAllocationMethod: network.Dynamic,
-							PublicIPAddress:           &publicIP,
-						},
+	frontEndIPConfigs := []network.FrontendIPConfiguration{
+		{
+			Name: &frontEndIPConfigName,
+			FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+				PrivateIPAllocationMethod: network.Dynamic,
+				PublicIPAddress:           &publicIP,
+			},
+		},
+	}
+	outboundFrontEndIPConfigs := []network.SubResource{
+		{ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName))},
+	}
+	for _, ipName := range publicLBSpec.OutboundPublicIPNames {
+		log.V(4).Info("getting outbound public ip", "publicIP", ipName)
+		outboundIP, err := s.PublicIPsClient.Get(ctx, s.Scope.ResourceGroup(), ipName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get outbound public ip %s", ipName)
+		}
+		if err := validatePublicIPSKU(outboundIP, sku); err != nil {
+			return err
+		}
+		outboundFrontEndIPConfigName := fmt.Sprintf("%s-lbFrontEnd", ipName)
+		frontEndIPConfigs = append(frontEndIPConfigs, network.FrontendIPConfiguration{
+			Name: to.StringPtr(outboundFrontEndIPConfigName),
+			FrontendIPConfigurationPropertiesFormat: &network.FrontendIPConfigurationPropertiesFormat{
+				PrivateIPAllocationMethod: network.Dynamic,
+				PublicIPAddress:           &outboundIP,
+			},
+		})
+		outboundFrontEndIPConfigs = append(outboundFrontEndIPConfigs, network.SubResource{
+			ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, outboundFrontEndIPConfigName)),
+		})
+	}
+
+	// The outbound rules provide SNAT for the backend pools through all the frontend IPs,
+	// so the load balancing rule must not use its frontend IP for SNAT as well.
+	// Basic load balancers don't support outbound rules and provide SNAT through the load balancing rule instead.
+	var outboundRules *[]network.OutboundRule
+	disableOutboundSnat := false
+	if sku == network.LoadBalancerSkuNameStandard {
+		outboundRules = &[]network.OutboundRule{
+			{
+				Name: to.StringPtr("OutboundNATAllProtocols"),
+				OutboundRulePropertiesFormat: &network.OutboundRulePropertiesFormat{
+					Protocol:                 network.LoadBalancerOutboundRuleProtocolAll,
+					IdleTimeoutInMinutes:     to.Int32Ptr(4),
+					FrontendIPConfigurations: &outboundFrontEndIPConfigs,
+					BackendAddressPool: &network.SubResource{
+						ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, backEndAddressPoolName)),
 					},
 				},
-				BackendAddressPools: &[]network.BackendAddressPool{
-					{
-						Name: &backEndAddressPoolName,
+			},
+			{
+				Name: to.StringPtr("NodeOutboundNATAllProtocols"),
+				OutboundRulePropertiesFormat: &network.OutboundRulePropertiesFormat{
+					Protocol:                 network.LoadBalancerOutboundRuleProtocolAll,
+					IdleTimeoutInMinutes:     to.Int32Ptr(4),
+					FrontendIPConfigurations: &outboundFrontEndIPConfigs,
+					BackendAddressPool: &network.SubResource{
+						ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, azure.NodeBackendPoolName)),
 					},
 				},
-				Probes: &[]network.Probe{
-					{
-						Name: &probeName,
-						ProbePropertiesFormat: &network.ProbePropertiesFormat{
-							Protocol:          network.ProbeProtocolTCP,
-							Port:              to.Int32Ptr(s.Scope.APIServerPort()),
-							IntervalInSeconds: to.Int32Ptr(15),
-							NumberOfProbes:    to.Int32Ptr(4),
-						},
-					},
+			},
+		}
+		disableOutboundSnat = true
+	}
+
+	// The machine reconciler creates the inbound NAT rules of the control plane machines, keep them, and the tags
+	// added out of band.
+	inboundNatRules := []network.InboundNatRule{}
+	tags := s.Scope.ResourceTags(lbName, infrav1.APIServerRoleTagValue)
+	existingLB, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), lbName)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get public load balancer %s", lbName)
+	case err == nil:
+		if existingLB.LoadBalancerPropertiesFormat != nil && existingLB.InboundNatRules != nil {
+			inboundNatRules = *existingLB.InboundNatRules
+		}
+		existingTags := converters.MapToTags(existingLB.Tags)
+		existingTags.Merge(tags)
+		tags = existingTags
+	}
+
+	probes := []network.Probe{
+		{
+			Name:                  &probeName,
+			ProbePropertiesFormat: probe,
+		},
+	}
+	rules := []network.LoadBalancingRule{
+		{
+			Name: to.StringPtr("LBRuleHTTPS"),
+			LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
+				Protocol:             network.TransportProtocolTCP,
+				FrontendPort:         to.Int32Ptr(s.Scope.APIServerPort()),
+				BackendPort:          to.Int32Ptr(s.Scope.APIServerPort()),
+				IdleTimeoutInMinutes: to.Int32Ptr(idleTimeout),
+				EnableFloatingIP:     to.BoolPtr(false),
+				DisableOutboundSnat:  to.BoolPtr(disableOutboundSnat),
+				LoadDistribution:     network.LoadDistributionDefault,
+				FrontendIPConfiguration: &network.SubResource{
+					ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
 				},
-				LoadBalancingRules: &[]network.LoadBalancingRule{
-					{
-						Name: to.StringPtr("LBRuleHTTPS"),
-						LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
-							Protocol:             network.TransportProtocolTCP,
-							FrontendPort:         to.Int32Ptr(s.Scope.APIServerPort()),
-							BackendPort:          to.Int32Ptr(s.Scope.APIServerPort()),
-							IdleTimeoutInMinutes: to.Int32Ptr(4),
-							EnableFloatingIP:     to.BoolPtr(false),
-							LoadDistribution:     network.LoadDistributionDefault,
-							FrontendIPConfiguration: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
-							},
-							BackendAddressPool: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, backEndAddressPoolName)),
-							},
-							Probe: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/probes/%s", idPrefix, lbName, probeName)),
-							},
-						},
-					},
+				BackendAddressPool: &network.SubResource{
+					ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, backEndAddressPoolName)),
 				},
-				InboundNatRules: &[]network.InboundNatRule{
-					{
-						Name: to.StringPtr("natRule1"),
-						InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
-							Protocol:             network.TransportProtocolTCP,
-							FrontendPort:         to.Int32Ptr(22),
-							BackendPort:          to.Int32Ptr(22),
-							EnableFloatingIP:     to.BoolPtr(false),
-							IdleTimeoutInMinutes: to.Int32Ptr(4),
-							FrontendIPConfiguration: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
-							},
-						},
-					},
-					{
-						Name: to.StringPtr("natRule2"),
-						InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
-							Protocol:             network.TransportProtocolTCP,
-							FrontendPort:         to.Int32Ptr(2201),
-							BackendPort:          to.Int32Ptr(22),
-							EnableFloatingIP:     to.BoolPtr(false),
-							IdleTimeoutInMinutes: to.Int32Ptr(4),
-							FrontendIPConfiguration: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
-							},
-						},
-					},
-					{
-						Name: to.StringPtr("natRule3"),
-						InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{
-							Protocol:             network.TransportProtocolTCP,
-							FrontendPort:         to.Int32Ptr(2202),
-							BackendPort:          to.Int32Ptr(22),
-							EnableFloatingIP:     to.BoolPtr(false),
-							IdleTimeoutInMinutes: to.Int32Ptr(4),
-							FrontendIPConfiguration: &network.SubResource{
-								ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
-							},
-						},
-					},
+				Probe: &network.SubResource{
+					ID: to.StringPtr(fmt.Sprintf("/%s/%s/probes/%s", idPrefix, lbName, probeName)),
+				},
+			},
+		},
+	}
+	for _, rule := range publicLBSpec.AdditionalRules {
+		ruleProbeName := fmt.Sprintf("%s-probe", rule.Name)
+		probes = append(probes, network.Probe{
+			Name:                  to.StringPtr(ruleProbeName),
+			ProbePropertiesFormat: rule.Probe,
+		})
+		protocol := rule.Protocol
+		if protocol == "" {
+			protocol = network.TransportProtocolTCP
+		}
+		backendPoolName := rule.BackendPoolName
+		if backendPoolName == "" {
+			backendPoolName = backEndAddressPoolName
+		}
+		rules = append(rules, network.LoadBalancingRule{
+			Name: to.StringPtr(rule.Name),
+			LoadBalancingRulePropertiesFormat: &network.LoadBalancingRulePropertiesFormat{
+				Protocol:             protocol,
+				FrontendPort:         to.Int32Ptr(rule.FrontendPort),
+				BackendPort:          to.Int32Ptr(rule.BackendPort),
+				IdleTimeoutInMinutes: to.Int32Ptr(idleTimeout),
+				EnableFloatingIP:     to.BoolPtr(false),
+				DisableOutboundSnat:  to.BoolPtr(disableOutboundSnat),
+				LoadDistribution:     network.LoadDistributionDefault,
+				FrontendIPConfiguration: &network.SubResource{
+					ID: to.StringPtr(fmt.Sprintf("/%s/%s/frontendIPConfigurations/%s", idPrefix, lbName, frontEndIPConfigName)),
+				},
+				BackendAddressPool: &network.SubResource{
+					ID: to.StringPtr(fmt.Sprintf("/%s/%s/backendAddressPools/%s", idPrefix, lbName, backendPoolName)),
+				},
+				Probe: &network.SubResource{
+					ID: to.StringPtr(fmt.Sprintf("/%s/%s/probes/%s", idPrefix, lbName, ruleProbeName)),
 				},
 			},
 		})
+	}
+
+	loadBalancer := network.LoadBalancer{
+		Tags:     converters.TagsToMap(tags),
+		Sku:      &network.LoadBalancerSku{Name: sku},
+		Location: to.StringPtr(s.Scope.Location()),
+		LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+			FrontendIPConfigurations: &frontEndIPConfigs,
+			// The network interfaces of the control plane machines use the first backend pool,
+			// the ones of the nodes use the second.
+			BackendAddressPools: &[]network.BackendAddressPool{
+				{
+					Name: &backEndAddressPoolName,
+				},
+				{
+					Name: to.StringPtr(azure.NodeBackendPoolName),
+				},
+			},
+			Probes:             &probes,
+			LoadBalancingRules: &rules,
+			OutboundRules:      outboundRules,
+			InboundNatRules:    &inboundNatRules,
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("public load balancer", s.Scope.ResourceGroup(), lbName, loadBalancer)) {
+		return nil
+	}
+
+	// https://docs.microsoft.com/en-us/azure/load-balancer/load-balancer-standard-availability-zones#zone-redundant-by-default
+	err = s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), lbName, loadBalancer)
 
 	if err != nil {
 		return errors.Wrap(err, "cannot create public load balancer")
 	}
 
-	klog.V(2).Infof("successfully created public load balancer %s", lbName)
+	log.V(2).Info("successfully created public load balancer")
 	return nil
 }
 
@@ -190,16 +316,60 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid public loadbalancer specification")
 	}
-	klog.V(2).Infof("deleting public load balancer %s", publicLBSpec.Name)
+	log := s.Scope.ResourceLogger(publicLBSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("public load balancer", s.Scope.ResourceGroup(), publicLBSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting public load balancer")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), publicLBSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("public load balancer is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete public load balancer %s in resource group %s", publicLBSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("deleted public load balancer %s", publicLBSpec.Name)
+	log.V(2).Info("successfully deleted public load balancer")
+	return nil
+}
+
+// validatePublicIPSKU returns an error if the SKU of the public IP doesn't match the SKU of the load balancer using it.
+func validatePublicIPSKU(publicIP network.PublicIPAddress, sku network.LoadBalancerSkuName) error {
+	ipSKU := network.PublicIPAddressSkuNameBasic
+	if publicIP.Sku != nil && publicIP.Sku.Name != "" {
+		ipSKU = publicIP.Sku.Name
+	}
+	if string(ipSKU) != string(sku) {
+		return errors.Errorf("public ip %s has SKU %s, it must have the SKU %s of the load balancer", to.String(publicIP.Name), ipSKU, sku)
+	}
+	return nil
+}
+
+// validateRules returns an error if an additional rule has no health probe, or if it has the name of another rule or
+// the frontend port of another rule with the same protocol, including the API server rule.
+func validateRules(publicLBSpec *Spec, apiServerPort int32) error {
+	names := map[string]bool{"LBRuleHTTPS": true}
+	frontendPorts := map[string]bool{fmt.Sprintf("%s/%d", network.TransportProtocolTCP, apiServerPort): true}
+	for _, rule := range publicLBSpec.AdditionalRules {
+		if rule.Probe == nil {
+			return errors.Errorf("load balancing rule %s of load balancer %s requires a health probe", rule.Name, publicLBSpec.Name)
+		}
+		if names[rule.Name] {
+			return errors.Errorf("load balancing rule name %s of load balancer %s is not unique", rule.Name, publicLBSpec.Name)
+		}
+		names[rule.Name] = true
+		protocol := rule.Protocol
+		if protocol == "" {
+			protocol = network.TransportProtocolTCP
+		}
+		frontendPort := fmt.Sprintf("%s/%d", protocol, rule.FrontendPort)
+		if frontendPorts[frontendPort] {
+			return errors.Errorf("frontend port %d of load balancing rule %s of load balancer %s is already used by another rule", rule.FrontendPort, rule.Name, publicLBSpec.Name)
+		}
+		frontendPorts[frontendPort] = true
+	}
 	return nil
 }
@@ -0,0 +1,359 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package publicloadbalancers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips/mock_publicips"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers/mock_publicloadbalancers"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// lbMatcher matches a load balancer with the given SKU, probe protocol, idle timeout and number of inbound NAT rules
+// and additional load balancing rules, with or without outbound rules, with the control plane and node backend pools,
+// and with at least the given tags.
+type lbMatcher struct {
+	sku             network.LoadBalancerSkuName
+	outboundRules   bool
+	probeProtocol   network.ProbeProtocol
+	idleTimeout     int32
+	inboundNatRules int
+	additionalRules int
+	tags            map[string]string
+}
+
+func (m lbMatcher) Matches(x interface{}) bool {
+	lb, ok := x.(network.LoadBalancer)
+	if !ok || lb.Sku == nil || lb.Sku.Name != m.sku || lb.LoadBalancerPropertiesFormat == nil {
+		return false
+	}
+	probeProtocol := m.probeProtocol
+	if probeProtocol == "" {
+		probeProtocol = network.ProbeProtocolTCP
+	}
+	if lb.BackendAddressPools == nil || len(*lb.BackendAddressPools) != 2 || to.String((*lb.BackendAddressPools)[1].Name) != "node-backEndPool" {
+		return false
+	}
+	if lb.Probes == nil || len(*lb.Probes) != 1+m.additionalRules || (*lb.Probes)[0].Protocol != probeProtocol {
+		return false
+	}
+	idleTimeout := m.idleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = 4
+	}
+	if lb.LoadBalancingRules == nil || len(*lb.LoadBalancingRules) != 1+m.additionalRules || to.Int32((*lb.LoadBalancingRules)[0].IdleTimeoutInMinutes) != idleTimeout {
+		return false
+	}
+	if lb.InboundNatRules == nil || len(*lb.InboundNatRules) != m.inboundNatRules {
+		return false
+	}
+	for k, v := range m.tags {
+		if value, ok := lb.Tags[k]; !ok || to.String(value) != v {
+			return false
+		}
+	}
+	return (lb.OutboundRules != nil) == m.outboundRules
+}
+
+func (m lbMatcher) String() string {
+	return fmt.Sprintf("is a load balancer with SKU %s, probe protocol %s, idle timeout %d, %d inbound NAT rules, %d additional rules, outbound rules %t and tags %v",
+		m.sku, m.probeProtocol, m.idleTimeout, m.inboundNatRules, m.additionalRules, m.outboundRules, m.tags)
+}
+
+func publicIP(name string, sku network.PublicIPAddressSkuName) network.PublicIPAddress {
+	return network.PublicIPAddress{
+		Name: to.StringPtr(name),
+		Sku:  &network.PublicIPAddressSku{Name: sku},
+	}
+}
+
+func TestReconcilePublicLoadBalancer(t *testing.T) {
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not Found")
+
+	testcases := []struct {
+		name          string
+		spec          *Spec
+		expectedError string
+		expect        func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name: "standard load balancer",
+			spec: &Spec{Name: "my-lb", PublicIPName: "my-ip", OutboundPublicIPNames: []string{"my-ip-outbound-1"}},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip-outbound-1").Return(publicIP("my-ip-outbound-1", network.PublicIPAddressSkuNameStandard), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameStandard, outboundRules: true})
+			},
+		},
+		{
+			name: "load balancer with tags added out of band",
+			spec: &Spec{Name: "my-lb", PublicIPName: "my-ip"},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{
+					Tags: map[string]*string{"foo": to.StringPtr("bar")},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameStandard, outboundRules: true, tags: map[string]string{
+					"foo":  "bar",
+					"Name": "my-lb",
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+					"sigs.k8s.io_cluster-api-provider-azure_role":                 "apiserver",
+				}})
+			},
+		},
+		{
+			name: "load balancer with inbound NAT rules",
+			spec: &Spec{Name: "my-lb", PublicIPName: "my-ip"},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{
+					LoadBalancerPropertiesFormat: &network.LoadBalancerPropertiesFormat{
+						InboundNatRules: &[]network.InboundNatRule{{Name: to.StringPtr("machine-0")}, {Name: to.StringPtr("machine-1")}},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameStandard, outboundRules: true, inboundNatRules: 2})
+			},
+		},
+		{
+			name: "basic load balancer",
+			spec: &Spec{Name: "my-lb", PublicIPName: "my-ip", SKU: network.LoadBalancerSkuNameBasic},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameBasic), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameBasic, outboundRules: false})
+			},
+		},
+		{
+			name: "load balancer with an https probe",
+			spec: &Spec{
+				Name:         "my-lb",
+				PublicIPName: "my-ip",
+				Probe: &network.ProbePropertiesFormat{
+					Protocol:          network.ProbeProtocolHTTPS,
+					Port:              to.Int32Ptr(6443),
+					RequestPath:       to.StringPtr("/healthz"),
+					IntervalInSeconds: to.Int32Ptr(5),
+					NumberOfProbes:    to.Int32Ptr(2),
+				},
+			},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameStandard, outboundRules: true, probeProtocol: network.ProbeProtocolHTTPS})
+			},
+		},
+		{
+			name: "load balancer with a custom idle timeout",
+			spec: &Spec{Name: "my-lb", PublicIPName: "my-ip", IdleTimeoutInMinutes: to.Int32Ptr(30)},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameStandard, outboundRules: true, idleTimeout: 30})
+			},
+		},
+		{
+			name: "load balancer with additional rules",
+			spec: &Spec{
+				Name:         "my-lb",
+				PublicIPName: "my-ip",
+				AdditionalRules: []Rule{
+					{
+						Name:            "metrics",
+						FrontendPort:    9090,
+						BackendPort:     9091,
+						BackendPoolName: "node-backEndPool",
+						Probe:           &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolTCP, Port: to.Int32Ptr(9091)},
+					},
+					{
+						Name:         "syslog",
+						Protocol:     network.TransportProtocolUDP,
+						FrontendPort: 514,
+						BackendPort:  514,
+						Probe:        &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolTCP, Port: to.Int32Ptr(601)},
+					},
+				},
+			},
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				m.Get(context.TODO(), "my-rg", "my-lb").Return(network.LoadBalancer{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-lb", lbMatcher{sku: network.LoadBalancerSkuNameStandard, outboundRules: true, additionalRules: 2}).
+					Do(func(_ context.Context, _, _ string, lb network.LoadBalancer) {
+						idPrefix := "//subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-lb"
+						expected := []struct {
+							name, backendPool, probe  string
+							protocol                  network.TransportProtocol
+							frontendPort, backendPort int32
+						}{
+							{"LBRuleHTTPS", "controlplane-backEndPool", "tcpHTTPSProbe", network.TransportProtocolTCP, 6443, 6443},
+							{"metrics", "node-backEndPool", "metrics-probe", network.TransportProtocolTCP, 9090, 9091},
+							{"syslog", "controlplane-backEndPool", "syslog-probe", network.TransportProtocolUDP, 514, 514},
+						}
+						for i, e := range expected {
+							rule := (*lb.LoadBalancingRules)[i]
+							if to.String(rule.Name) != e.name || rule.Protocol != e.protocol ||
+								to.Int32(rule.FrontendPort) != e.frontendPort || to.Int32(rule.BackendPort) != e.backendPort ||
+								to.String(rule.BackendAddressPool.ID) != idPrefix+"/backendAddressPools/"+e.backendPool ||
+								to.String(rule.Probe.ID) != idPrefix+"/probes/"+e.probe {
+								t.Errorf("expected rule %+v, got %s %+v", e, to.String(rule.Name), *rule.LoadBalancingRulePropertiesFormat)
+							}
+							if probe := (*lb.Probes)[i]; to.String(probe.Name) != e.probe {
+								t.Errorf("expected probe %s, got %s", e.probe, to.String(probe.Name))
+							}
+						}
+					})
+			},
+		},
+		{
+			name: "load balancer with an additional rule without a probe",
+			spec: &Spec{
+				Name:            "my-lb",
+				PublicIPName:    "my-ip",
+				AdditionalRules: []Rule{{Name: "metrics", FrontendPort: 9090, BackendPort: 9090}},
+			},
+			expectedError: "load balancing rule metrics of load balancer my-lb requires a health probe",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+		{
+			name: "load balancer with an additional rule on the API server port",
+			spec: &Spec{
+				Name:         "my-lb",
+				PublicIPName: "my-ip",
+				AdditionalRules: []Rule{{Name: "apiserver", FrontendPort: 6443, BackendPort: 6444,
+					Probe: &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolTCP, Port: to.Int32Ptr(6444)}}},
+			},
+			expectedError: "frontend port 6443 of load balancing rule apiserver of load balancer my-lb is already used by another rule",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+		{
+			name: "load balancer with additional rules with the same name",
+			spec: &Spec{
+				Name:         "my-lb",
+				PublicIPName: "my-ip",
+				AdditionalRules: []Rule{
+					{Name: "metrics", FrontendPort: 9090, BackendPort: 9090, Probe: &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolTCP, Port: to.Int32Ptr(9090)}},
+					{Name: "metrics", FrontendPort: 9091, BackendPort: 9091, Probe: &network.ProbePropertiesFormat{Protocol: network.ProbeProtocolTCP, Port: to.Int32Ptr(9091)}},
+				},
+			},
+			expectedError: "load balancing rule name metrics of load balancer my-lb is not unique",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+		{
+			name:          "load balancer with an idle timeout that is too long",
+			spec:          &Spec{Name: "my-lb", PublicIPName: "my-ip", IdleTimeoutInMinutes: to.Int32Ptr(31)},
+			expectedError: "invalid idle timeout of 31 minutes for load balancer my-lb, must be between 4 and 30 minutes",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+		{
+			name:          "load balancer with an idle timeout that is too short",
+			spec:          &Spec{Name: "my-lb", PublicIPName: "my-ip", IdleTimeoutInMinutes: to.Int32Ptr(3)},
+			expectedError: "invalid idle timeout of 3 minutes for load balancer my-lb, must be between 4 and 30 minutes",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+		{
+			name:          "basic load balancer with a standard public ip",
+			spec:          &Spec{Name: "my-lb", PublicIPName: "my-ip", SKU: network.LoadBalancerSkuNameBasic},
+			expectedError: "public ip my-ip has SKU Standard, it must have the SKU Basic of the load balancer",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+			},
+		},
+		{
+			name:          "standard load balancer with a basic outbound public ip",
+			spec:          &Spec{Name: "my-lb", PublicIPName: "my-ip", OutboundPublicIPNames: []string{"my-ip-outbound-1"}},
+			expectedError: "public ip my-ip-outbound-1 has SKU Basic, it must have the SKU Standard of the load balancer",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip").Return(publicIP("my-ip", network.PublicIPAddressSkuNameStandard), nil)
+				mPublicIP.Get(context.TODO(), "my-rg", "my-ip-outbound-1").Return(publicIP("my-ip-outbound-1", network.PublicIPAddressSkuNameBasic), nil)
+			},
+		},
+		{
+			name:          "basic load balancer with outbound public ips",
+			spec:          &Spec{Name: "my-lb", PublicIPName: "my-ip", OutboundPublicIPNames: []string{"my-ip-outbound-1"}, SKU: network.LoadBalancerSkuNameBasic},
+			expectedError: "Basic load balancer my-lb cannot use outbound public ips, outbound rules require a Standard load balancer",
+			expect: func(m *mock_publicloadbalancers.MockClientMockRecorder, mPublicIP *mock_publicips.MockClientMockRecorder) {
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			publicLBMock := mock_publicloadbalancers.NewMockClient(mockCtrl)
+			publicIPsMock := mock_publicips.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(publicLBMock.EXPECT(), publicIPsMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:           clusterScope,
+				Client:          publicLBMock,
+				PublicIPsClient: publicIPsMock,
+			}
+
+			err = s.Reconcile(context.TODO(), tc.spec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourceskus
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+const (
+	// AcceleratedNetworking is the capability of a VM size that supports accelerated networking.
+	AcceleratedNetworking = "AcceleratedNetworkingEnabled"
+	// CachedDiskBytes is the capability of a VM size with the size of its cache in bytes.
+	CachedDiskBytes = "CachedDiskBytes"
+	// EncryptionAtHost is the capability of a VM size that supports encryption at host.
+	EncryptionAtHost = "EncryptionAtHostSupported"
+	// MaxWriteAcceleratorDisksAllowed is the capability of a VM size with the maximum number of data disks with write
+	// accelerator, absent for the VM sizes that do not support it.
+	MaxWriteAcceleratorDisksAllowed = "MaxWriteAcceleratorDisksAllowed"
+)
+
+const (
+	// virtualMachinesResourceType is the resource type of the resource SKUs of the VM sizes.
+	virtualMachinesResourceType = "virtualMachines"
+	// maxAlternativeVMSizes is the maximum number of VM sizes suggested when a VM size is not available.
+	maxAlternativeVMSizes = 5
+)
+
+// Spec specification for a virtual machine resource SKU
+type Spec struct {
+	VMSize string
+}
+
+// Get returns the resource SKU of a VM size in the cluster location. When the VM size is not offered in the location,
+// or is restricted for the subscription, the error suggests a few of the VM sizes that are available there.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	skuSpec, ok := spec.(*Spec)
+	if !ok {
+		return compute.ResourceSku{}, errors.New("invalid resource sku specification")
+	}
+	location := s.Scope.Location()
+	res, err := s.Client.ListComplete(ctx)
+	if err != nil {
+		return compute.ResourceSku{}, err
+	}
+	var available []string
+	var restriction *compute.ResourceSkuRestrictions
+	for res.NotDone() {
+		resSku := res.Value()
+		if resSku.Name != nil && hasLocation(resSku, location) {
+			skuRestriction := locationRestriction(resSku, location)
+			switch {
+			case !strings.EqualFold(*resSku.Name, skuSpec.VMSize):
+				if skuRestriction == nil && strings.EqualFold(to.String(resSku.ResourceType), virtualMachinesResourceType) {
+					available = append(available, *resSku.Name)
+				}
+			case skuRestriction == nil:
+				return resSku, nil
+			default:
+				// Keep listing the available VM sizes to suggest some.
+				restriction = skuRestriction
+			}
+		}
+		err = res.NextWithContext(ctx)
+		if err != nil {
+			return compute.ResourceSku{}, errors.Wrap(err, "could not iterate resource skus")
+		}
+	}
+	if restriction != nil {
+		return compute.ResourceSku{}, azure.NewTerminalError(errors.Errorf("VM size %s is restricted in location %s: %s%s",
+			skuSpec.VMSize, location, restriction.ReasonCode, suggestVMSizes(skuSpec.VMSize, available)))
+	}
+	return compute.ResourceSku{}, azure.NewTerminalError(errors.Errorf("VM size %s is not available in location %s%s", skuSpec.VMSize, location, suggestVMSizes(skuSpec.VMSize, available)))
+}
+
+// Reconcile is a no-op, resource SKUs are read-only.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	return nil
+}
+
+// Delete is a no-op, resource SKUs are read-only.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	return nil
+}
+
+// HasCapability returns true if the resource SKU has the named capability enabled.
+func HasCapability(sku compute.ResourceSku, name string) bool {
+	value, ok := GetCapability(sku, name)
+	return ok && strings.EqualFold(value, "True")
+}
+
+// GetCapability returns the value of the named capability of the resource SKU, and false if the SKU does not have
+// the capability.
+func GetCapability(sku compute.ResourceSku, name string) (string, bool) {
+	if sku.Capabilities == nil {
+		return "", false
+	}
+	for _, capability := range *sku.Capabilities {
+		if capability.Name != nil && *capability.Name == name {
+			return to.String(capability.Value), true
+		}
+	}
+	return "", false
+}
+
+// HasZone returns true if the resource SKU is offered in the availability zone of the location, and is not restricted
+// there for the subscription.
+func HasZone(sku compute.ResourceSku, location, zone string) bool {
+	if sku.Restrictions != nil {
+		for _, restriction := range *sku.Restrictions {
+			if restriction.Type == compute.Zone && restriction.RestrictionInfo != nil &&
+				containsFold(restriction.RestrictionInfo.Zones, zone) {
+				return false
+			}
+		}
+	}
+	if sku.LocationInfo == nil {
+		return false
+	}
+	for _, info := range *sku.LocationInfo {
+		if strings.EqualFold(to.String(info.Location), location) && containsFold(info.Zones, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// locationRestriction returns the restriction of the resource SKU in the location, if any.
+func locationRestriction(sku compute.ResourceSku, location string) *compute.ResourceSkuRestrictions {
+	if sku.Restrictions == nil {
+		return nil
+	}
+	for _, restriction := range *sku.Restrictions {
+		if restriction.Type == compute.Location && containsFold(restriction.Values, location) {
+			restriction := restriction
+			return &restriction
+		}
+	}
+	return nil
+}
+
+// suggestVMSizes returns a sentence listing the available VM sizes closest to the VM size, those sharing the longest
+// prefix with its name, or an empty string when none is available.
+func suggestVMSizes(vmSize string, available []string) string {
+	if len(available) == 0 {
+		return ""
+	}
+	sort.SliceStable(available, func(i, j int) bool {
+		pi, pj := commonPrefixLength(vmSize, available[i]), commonPrefixLength(vmSize, available[j])
+		if pi != pj {
+			return pi > pj
+		}
+		return available[i] < available[j]
+	})
+	if len(available) > maxAlternativeVMSizes {
+		available = available[:maxAlternativeVMSizes]
+	}
+	return ", available VM sizes include " + strings.Join(available, ", ")
+}
+
+func commonPrefixLength(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+func containsFold(values *[]string, value string) bool {
+	if values == nil {
+		return false
+	}
+	for _, v := range *values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLocation(sku compute.ResourceSku, location string) bool {
+	if sku.Locations == nil {
+		return false
+	}
+	for _, skuLocation := range *sku.Locations {
+		if strings.EqualFold(skuLocation, location) {
+			return true
+		}
+	}
+	return false
+}
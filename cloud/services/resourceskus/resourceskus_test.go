@@ -0,0 +1,213 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resourceskus
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/resourceskus/mock_resourceskus"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// resourceSkusIterator returns an iterator over a single page of resource SKUs.
+func resourceSkusIterator(t *testing.T, skus ...compute.ResourceSku) compute.ResourceSkusResultIterator {
+	page := compute.NewResourceSkusResultPage(func(_ context.Context, last compute.ResourceSkusResult) (compute.ResourceSkusResult, error) {
+		if last.Value != nil {
+			return compute.ResourceSkusResult{}, nil
+		}
+		return compute.ResourceSkusResult{Value: &skus}, nil
+	})
+	iterator := compute.NewResourceSkusResultIterator(page)
+	if err := iterator.NextWithContext(context.TODO()); err != nil {
+		t.Fatalf("failed to load the resource skus: %v", err)
+	}
+	return iterator
+}
+
+// vmSku returns the resource SKU of a VM size offered in the location.
+func vmSku(name, location string, restrictions ...compute.ResourceSkuRestrictions) compute.ResourceSku {
+	return compute.ResourceSku{
+		Name:         to.StringPtr(name),
+		ResourceType: to.StringPtr("virtualMachines"),
+		Locations:    &[]string{location},
+		Restrictions: &restrictions,
+	}
+}
+
+func TestGetResourceSku(t *testing.T) {
+	notAvailableForSubscription := compute.ResourceSkuRestrictions{
+		Type:       compute.Location,
+		Values:     &[]string{"test-location"},
+		ReasonCode: compute.NotAvailableForSubscription,
+	}
+	skus := []compute.ResourceSku{
+		vmSku("Standard_D2s_v3", "other-location"),
+		vmSku("Standard_B2s", "test-location"),
+		vmSku("Standard_D4s_v3", "test-location"),
+		vmSku("Standard_D2s_v4", "test-location"),
+		vmSku("Standard_D2_v3", "test-location", notAvailableForSubscription),
+		vmSku("Standard_E2s_v3", "test-location"),
+		vmSku("Standard_D8s_v3", "test-location"),
+		vmSku("Standard_A1", "test-location"),
+		vmSku("Standard_F2s_v2", "test-location"),
+	}
+
+	testcases := []struct {
+		name          string
+		skuSpec       Spec
+		expectedError string
+		expect        func(m *mock_resourceskus.MockClientMockRecorder)
+	}{
+		{
+			name:          "VM size not available",
+			skuSpec:       Spec{VMSize: "Standard_D2s_v3"},
+			expectedError: "VM size Standard_D2s_v3 is not available in location test-location",
+			expect: func(m *mock_resourceskus.MockClientMockRecorder) {
+				m.ListComplete(context.TODO()).Return(compute.ResourceSkusResultIterator{}, nil)
+			},
+		},
+		{
+			name:    "VM size available",
+			skuSpec: Spec{VMSize: "standard_d4s_v3"},
+			expect: func(m *mock_resourceskus.MockClientMockRecorder) {
+				m.ListComplete(context.TODO()).Return(resourceSkusIterator(t, skus...), nil)
+			},
+		},
+		{
+			name:          "VM size not available in the location",
+			skuSpec:       Spec{VMSize: "Standard_D2s_v3"},
+			expectedError: "VM size Standard_D2s_v3 is not available in location test-location, available VM sizes include Standard_D2s_v4, Standard_D4s_v3, Standard_D8s_v3, Standard_A1, Standard_B2s",
+			expect: func(m *mock_resourceskus.MockClientMockRecorder) {
+				m.ListComplete(context.TODO()).Return(resourceSkusIterator(t, skus...), nil)
+			},
+		},
+		{
+			name:          "VM size restricted for the subscription",
+			skuSpec:       Spec{VMSize: "Standard_D2_v3"},
+			expectedError: "VM size Standard_D2_v3 is restricted in location test-location: NotAvailableForSubscription, available VM sizes include Standard_D2s_v4, Standard_D4s_v3, Standard_D8s_v3, Standard_A1, Standard_B2s",
+			expect: func(m *mock_resourceskus.MockClientMockRecorder) {
+				m.ListComplete(context.TODO()).Return(resourceSkusIterator(t, skus...), nil)
+			},
+		},
+		{
+			name:          "fail to list resource skus",
+			skuSpec:       Spec{VMSize: "Standard_D2s_v3"},
+			expectedError: "#: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_resourceskus.MockClientMockRecorder) {
+				m.ListComplete(context.TODO()).Return(compute.ResourceSkusResultIterator{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			skusMock := mock_resourceskus.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(skusMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location: "test-location",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: skusMock,
+			}
+
+			_, err = s.Get(context.TODO(), &tc.skuSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHasCapability(t *testing.T) {
+	testcases := []struct {
+		name         string
+		capabilities *[]compute.ResourceSkuCapabilities
+		expected     bool
+	}{
+		{
+			name: "no capabilities",
+		},
+		{
+			name: "capability enabled",
+			capabilities: &[]compute.ResourceSkuCapabilities{
+				{Name: to.StringPtr("vCPUs"), Value: to.StringPtr("2")},
+				{Name: to.StringPtr(AcceleratedNetworking), Value: to.StringPtr("True")},
+			},
+			expected: true,
+		},
+		{
+			name: "capability disabled",
+			capabilities: &[]compute.ResourceSkuCapabilities{
+				{Name: to.StringPtr(AcceleratedNetworking), Value: to.StringPtr("False")},
+			},
+		},
+		{
+			name: "capability missing",
+			capabilities: &[]compute.ResourceSkuCapabilities{
+				{Name: to.StringPtr("vCPUs"), Value: to.StringPtr("2")},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := HasCapability(compute.ResourceSku{Capabilities: tc.capabilities}, AcceleratedNetworking)
+			if actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newRouteTablesClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newRouteTablesClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newRouteTablesClient creates a new route tables client from subscription ID.
-func newRouteTablesClient(subscriptionID string, authorizer autorest.Authorizer) network.RouteTablesClient {
-	routeTablesClient := network.NewRouteTablesClient(subscriptionID)
+// newRouteTablesClient creates a new route tables client from subscription ID and base URI.
+func newRouteTablesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.RouteTablesClient {
+	routeTablesClient := network.NewRouteTablesClientWithBaseURI(baseURI, subscriptionID)
 	routeTablesClient.Authorizer = authorizer
 	routeTablesClient.AddToUserAgent(azure.UserAgent)
 	return routeTablesClient
@@ -54,33 +54,43 @@ func newRouteTablesClient(subscriptionID string, authorizer autorest.Authorizer)
 
 // Get gets the specified route table.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, rtName string) (network.RouteTable, error) {
-	return ac.routetables.Get(ctx, resourceGroupName, rtName, "")
+	var result network.RouteTable
+	err := azure.CallAPI(ctx, "routetables", "Get", func() error {
+		var err error
+		result, err = ac.routetables.Get(ctx, resourceGroupName, rtName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate create or updates a route table in a specified resource group.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, rtName string, rt network.RouteTable) error {
-	future, err := ac.routetables.CreateOrUpdate(ctx, resourceGroupName, rtName, rt)
-	if err != nil {
+	var future network.RouteTablesCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "routetables", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.routetables.CreateOrUpdate(ctx, resourceGroupName, rtName, rt)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.routetables.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.routetables.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.routetables)
 		return err
-	}
-	_, err = future.Result(ac.routetables)
-	return err
+	})
 }
 
 // Delete deletes the specified route table.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, rtName string) error {
-	future, err := ac.routetables.Delete(ctx, resourceGroupName, rtName)
-	if err != nil {
+	var future network.RouteTablesDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "routetables", "Delete", func() error {
+		var err error
+		future, err = ac.routetables.Delete(ctx, resourceGroupName, rtName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.routetables.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.routetables.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.routetables)
 		return err
-	}
-	_, err = future.Result(ac.routetables)
-	return err
+	})
 }
@@ -18,17 +18,25 @@ package routetables
 
 import (
 	"context"
+	"net"
+	"sort"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for route table.
 type Spec struct {
 	Name string
+	// Routes are the routes managed by the cluster. Routes of the route table which are not in Routes, like the
+	// routes added by a CNI plugin, are left untouched.
+	Routes []infrav1.RouteSpec
 }
 
 // Get provides information about a route table.
@@ -56,21 +64,69 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid Route Table Specification")
 	}
-	klog.V(2).Infof("creating route table %s", routeTableSpec.Name)
-	err := s.Client.CreateOrUpdate(
+	log := s.Scope.ResourceLogger(routeTableSpec.Name)
+	log.V(4).Info("reconciling route table")
+
+	routeTable := network.RouteTable{
+		Location:                   to.StringPtr(s.Scope.Location()),
+		RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{},
+	}
+	desiredRoutes, err := convertRoutes(routeTableSpec.Routes)
+	if err != nil {
+		return errors.Wrapf(err, "invalid routes for route table %s", routeTableSpec.Name)
+	}
+	routes := desiredRoutes
+	tags := s.Scope.ResourceTags(routeTableSpec.Name, "")
+
+	existingRouteTable, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), routeTableSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get route table %s in resource group %s", routeTableSpec.Name, s.Scope.ResourceGroup())
+	case err == nil:
+		var existingRoutes []network.Route
+		if existingRouteTable.RouteTablePropertiesFormat != nil {
+			if existingRouteTable.Routes != nil {
+				existingRoutes = *existingRouteTable.Routes
+			}
+			// the properties set out of band, like the BGP route propagation, are kept.
+			routeTable.DisableBgpRoutePropagation = existingRouteTable.DisableBgpRoutePropagation
+		}
+
+		var tagsChanged bool
+		tags, tagsChanged = converters.MapToTags(existingRouteTable.Tags).Merged(tags)
+
+		var changed []string
+		routes, changed = mergeRoutes(existingRoutes, desiredRoutes)
+		if len(changed) == 0 && !tagsChanged {
+			log.V(4).Info("route table is up to date")
+			return nil
+		}
+		if len(changed) > 0 {
+			log.V(2).Info("routes of route table have changed", "routes", strings.Join(changed, ", "))
+		}
+		if tagsChanged {
+			log.V(2).Info("tags of route table have changed")
+		}
+	}
+	routeTable.Routes = &routes
+	routeTable.Tags = converters.TagsToMap(tags)
+
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("route table", s.Scope.ResourceGroup(), routeTableSpec.Name, routeTable)) {
+		return nil
+	}
+
+	log.V(2).Info("creating route table")
+	err = s.Client.CreateOrUpdate(
 		ctx,
 		s.Scope.ResourceGroup(),
 		routeTableSpec.Name,
-		network.RouteTable{
-			Location:                   to.StringPtr(s.Scope.Location()),
-			RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{},
-		},
+		routeTable,
 	)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create route table %s in resource group %s", routeTableSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully created route table %s", routeTableSpec.Name)
+	log.V(2).Info("successfully created route table")
 	return nil
 }
 
@@ -84,16 +140,116 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid Route Table Specification")
 	}
-	klog.V(2).Infof("deleting route table %s", routeTableSpec.Name)
+	log := s.Scope.ResourceLogger(routeTableSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("route table", s.Scope.ResourceGroup(), routeTableSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting route table")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), routeTableSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("route table is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete route table %s in resource group %s", routeTableSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully deleted route table %s", routeTableSpec.Name)
+	log.V(2).Info("successfully deleted route table")
 	return nil
 }
+
+// convertRoutes validates the route specs and converts them into Azure SDK routes.
+func convertRoutes(specs []infrav1.RouteSpec) ([]network.Route, error) {
+	names := make(map[string]bool, len(specs))
+	routes := make([]network.Route, 0, len(specs))
+	for _, spec := range specs {
+		if spec.Name == "" {
+			return nil, errors.New("route name cannot be empty")
+		}
+		if names[spec.Name] {
+			return nil, errors.Errorf("route %s is defined more than once", spec.Name)
+		}
+		names[spec.Name] = true
+		if _, _, err := net.ParseCIDR(spec.AddressPrefix); err != nil {
+			return nil, errors.Errorf("route %s has an invalid address prefix %q, it must be a CIDR block", spec.Name, spec.AddressPrefix)
+		}
+		switch spec.NextHopType {
+		case infrav1.RouteNextHopTypeVirtualAppliance:
+			if spec.NextHopIPAddress == "" {
+				return nil, errors.Errorf("route %s requires a next hop IP address for the next hop type %s", spec.Name, spec.NextHopType)
+			}
+			if net.ParseIP(spec.NextHopIPAddress) == nil {
+				return nil, errors.Errorf("route %s has an invalid next hop IP address %q", spec.Name, spec.NextHopIPAddress)
+			}
+		case infrav1.RouteNextHopTypeVirtualNetworkGateway, infrav1.RouteNextHopTypeVnetLocal, infrav1.RouteNextHopTypeInternet, infrav1.RouteNextHopTypeNone:
+			if spec.NextHopIPAddress != "" {
+				return nil, errors.Errorf("route %s cannot have a next hop IP address for the next hop type %s, only the next hop type %s allows it",
+					spec.Name, spec.NextHopType, infrav1.RouteNextHopTypeVirtualAppliance)
+			}
+		default:
+			return nil, errors.Errorf("route %s has an invalid next hop type %q", spec.Name, spec.NextHopType)
+		}
+
+		route := network.Route{
+			Name: to.StringPtr(spec.Name),
+			RoutePropertiesFormat: &network.RoutePropertiesFormat{
+				AddressPrefix: to.StringPtr(spec.AddressPrefix),
+				NextHopType:   network.RouteNextHopType(spec.NextHopType),
+			},
+		}
+		if spec.NextHopIPAddress != "" {
+			route.NextHopIPAddress = to.StringPtr(spec.NextHopIPAddress)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// mergeRoutes merges the desired routes into the existing routes of a route table. A desired route replaces the
+// existing route with the same name, and the existing routes which are not desired are kept. It also returns the
+// sorted names of the desired routes which are missing from the existing routes or whose properties differ.
+func mergeRoutes(existing, desired []network.Route) ([]network.Route, []string) {
+	desiredByName := make(map[string]network.Route, len(desired))
+	for _, route := range desired {
+		desiredByName[to.String(route.Name)] = route
+	}
+
+	merged := make([]network.Route, 0, len(existing)+len(desired))
+	var changed []string
+	for _, route := range existing {
+		name := to.String(route.Name)
+		desiredRoute, ok := desiredByName[name]
+		if !ok {
+			merged = append(merged, route)
+			continue
+		}
+		if !routeEqual(route, desiredRoute) {
+			changed = append(changed, name)
+		}
+		merged = append(merged, desiredRoute)
+		delete(desiredByName, name)
+	}
+	for _, route := range desired {
+		name := to.String(route.Name)
+		if _, ok := desiredByName[name]; ok {
+			changed = append(changed, name)
+			merged = append(merged, route)
+		}
+	}
+
+	sort.Strings(changed)
+	return merged, changed
+}
+
+// routeEqual returns true if both routes have the same properties. Read-only properties like the ID, etag and
+// provisioning state are ignored.
+func routeEqual(a, b network.Route) bool {
+	if a.RoutePropertiesFormat == nil || b.RoutePropertiesFormat == nil {
+		return a.RoutePropertiesFormat == b.RoutePropertiesFormat
+	}
+	return to.String(a.AddressPrefix) == to.String(b.AddressPrefix) &&
+		strings.EqualFold(string(a.NextHopType), string(b.NextHopType)) &&
+		to.String(a.NextHopIPAddress) == to.String(b.NextHopIPAddress)
+}
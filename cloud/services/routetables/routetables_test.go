@@ -0,0 +1,512 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package routetables
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/go-logr/logr"
+	"github.com/golang/mock/gomock"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/routetables/mock_routetables"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func route(name, prefix, nextHop string) network.Route {
+	return network.Route{
+		Name: to.StringPtr(name),
+		RoutePropertiesFormat: &network.RoutePropertiesFormat{
+			AddressPrefix:    to.StringPtr(prefix),
+			NextHopType:      network.RouteNextHopTypeVirtualAppliance,
+			NextHopIPAddress: to.StringPtr(nextHop),
+		},
+	}
+}
+
+func TestReconcileRouteTables(t *testing.T) {
+	capzRoute := route("capz-route", "10.2.0.0/16", "10.0.0.4")
+	cniRoute := route("cni-route", "192.168.1.0/24", "10.1.0.5")
+	cniRoute.ID = to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/routeTables/my-rt/routes/cni-route")
+	capzRouteSpec := infrav1.RouteSpec{
+		Name:             "capz-route",
+		AddressPrefix:    "10.2.0.0/16",
+		NextHopType:      infrav1.RouteNextHopTypeVirtualAppliance,
+		NextHopIPAddress: "10.0.0.4",
+	}
+	ownedTags := func(extra map[string]*string) map[string]*string {
+		tags := map[string]*string{
+			"Name": to.StringPtr("my-rt"),
+			"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+		}
+		for k, v := range extra {
+			tags[k] = v
+		}
+		return tags
+	}
+
+	testcases := []struct {
+		name          string
+		routes        []infrav1.RouteSpec
+		expectedError string
+		expect        func(m *mock_routetables.MockClientMockRecorder)
+	}{
+		{
+			name:   "route table does not exist",
+			routes: []infrav1.RouteSpec{capzRouteSpec},
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").
+					Return(network.RouteTable{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-rt", network.RouteTable{
+					Location: to.StringPtr("test-location"),
+					Tags:     ownedTags(nil),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{capzRoute},
+					},
+				})
+			},
+		},
+		{
+			name: "route table is up to date",
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name: to.StringPtr("my-rt"),
+					Tags: ownedTags(nil),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{cniRoute},
+					},
+				}, nil)
+			},
+		},
+		{
+			name:   "route table with the cluster routes and foreign routes is up to date",
+			routes: []infrav1.RouteSpec{capzRouteSpec},
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name: to.StringPtr("my-rt"),
+					Tags: ownedTags(map[string]*string{"foo": to.StringPtr("bar")}),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{cniRoute, capzRoute},
+					},
+				}, nil)
+			},
+		},
+		{
+			name: "missing cluster tags are added and foreign tags are kept",
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name: to.StringPtr("my-rt"),
+					Tags: map[string]*string{"foo": to.StringPtr("bar")},
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{cniRoute},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-rt", network.RouteTable{
+					Location: to.StringPtr("test-location"),
+					Tags:     ownedTags(map[string]*string{"foo": to.StringPtr("bar")}),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{cniRoute},
+					},
+				})
+			},
+		},
+		{
+			name:   "removed cluster route is added back and foreign routes are kept",
+			routes: []infrav1.RouteSpec{capzRouteSpec},
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name: to.StringPtr("my-rt"),
+					Tags: map[string]*string{"foo": to.StringPtr("bar")},
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes:                     &[]network.Route{cniRoute},
+						DisableBgpRoutePropagation: to.BoolPtr(true),
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-rt", network.RouteTable{
+					Location: to.StringPtr("test-location"),
+					Tags:     ownedTags(map[string]*string{"foo": to.StringPtr("bar")}),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes:                     &[]network.Route{cniRoute, capzRoute},
+						DisableBgpRoutePropagation: to.BoolPtr(true),
+					},
+				})
+			},
+		},
+		{
+			name:   "changed cluster route is corrected",
+			routes: []infrav1.RouteSpec{capzRouteSpec},
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name: to.StringPtr("my-rt"),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{route("capz-route", "10.2.0.0/16", "10.0.0.5"), cniRoute},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-rt", network.RouteTable{
+					Location: to.StringPtr("test-location"),
+					Tags:     ownedTags(nil),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{capzRoute, cniRoute},
+					},
+				})
+			},
+		},
+		{
+			name: "route to the internet",
+			routes: []infrav1.RouteSpec{
+				{Name: "internet-route", AddressPrefix: "0.0.0.0/0", NextHopType: infrav1.RouteNextHopTypeInternet},
+			},
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name:                       to.StringPtr("my-rt"),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-rt", network.RouteTable{
+					Location: to.StringPtr("test-location"),
+					Tags:     ownedTags(nil),
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+						Routes: &[]network.Route{
+							{
+								Name: to.StringPtr("internet-route"),
+								RoutePropertiesFormat: &network.RoutePropertiesFormat{
+									AddressPrefix: to.StringPtr("0.0.0.0/0"),
+									NextHopType:   network.RouteNextHopTypeInternet,
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "virtual appliance route without a next hop ip address",
+			routes: []infrav1.RouteSpec{
+				{Name: "onprem", AddressPrefix: "172.16.0.0/12", NextHopType: infrav1.RouteNextHopTypeVirtualAppliance},
+			},
+			expectedError: "invalid routes for route table my-rt: route onprem requires a next hop IP address for the next hop type VirtualAppliance",
+			expect:        func(m *mock_routetables.MockClientMockRecorder) {},
+		},
+		{
+			name: "internet route with a next hop ip address",
+			routes: []infrav1.RouteSpec{
+				{Name: "internet-route", AddressPrefix: "0.0.0.0/0", NextHopType: infrav1.RouteNextHopTypeInternet, NextHopIPAddress: "10.0.0.4"},
+			},
+			expectedError: "invalid routes for route table my-rt: route internet-route cannot have a next hop IP address for the next hop type Internet, only the next hop type VirtualAppliance allows it",
+			expect:        func(m *mock_routetables.MockClientMockRecorder) {},
+		},
+		{
+			name: "route with an invalid address prefix",
+			routes: []infrav1.RouteSpec{
+				{Name: "onprem", AddressPrefix: "172.16.0.0", NextHopType: infrav1.RouteNextHopTypeVirtualAppliance, NextHopIPAddress: "10.0.0.4"},
+			},
+			expectedError: "invalid routes for route table my-rt: route onprem has an invalid address prefix \"172.16.0.0\", it must be a CIDR block",
+			expect:        func(m *mock_routetables.MockClientMockRecorder) {},
+		},
+		{
+			name:          "route defined more than once",
+			routes:        []infrav1.RouteSpec{capzRouteSpec, capzRouteSpec},
+			expectedError: "invalid routes for route table my-rt: route capz-route is defined more than once",
+			expect:        func(m *mock_routetables.MockClientMockRecorder) {},
+		},
+		{
+			name:          "fail to get the route table",
+			routes:        []infrav1.RouteSpec{capzRouteSpec},
+			expectedError: "failed to get route table my-rt in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").
+					Return(network.RouteTable{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			rtMock := mock_routetables.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(rtMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: rtMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{Name: "my-rt", Routes: tc.routes})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// logEntry is a log line captured by testLogger.
+type logEntry struct {
+	level  int
+	msg    string
+	values map[string]interface{}
+}
+
+// testLogger is a logr.Logger which captures the log lines with their verbosity and key-value pairs.
+type testLogger struct {
+	entries *[]logEntry
+	level   int
+	values  []interface{}
+}
+
+func (l *testLogger) Info(msg string, keysAndValues ...interface{}) {
+	values := map[string]interface{}{}
+	kvs := append(append([]interface{}{}, l.values...), keysAndValues...)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		values[kvs[i].(string)] = kvs[i+1]
+	}
+	*l.entries = append(*l.entries, logEntry{level: l.level, msg: msg, values: values})
+}
+
+func (l *testLogger) Enabled() bool { return true }
+
+func (l *testLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.Info(msg, append(keysAndValues, "error", err)...)
+}
+
+func (l *testLogger) V(level int) logr.InfoLogger {
+	return &testLogger{entries: l.entries, level: level, values: l.values}
+}
+
+func (l *testLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return &testLogger{entries: l.entries, level: l.level, values: append(append([]interface{}{}, l.values...), keysAndValues...)}
+}
+
+func (l *testLogger) WithName(name string) logr.Logger { return l }
+
+func TestReconcileRouteTablesLogging(t *testing.T) {
+	testcases := []struct {
+		name          string
+		existing      network.RouteTable
+		expectedLevel int
+		expectedMsg   string
+	}{
+		{
+			name:          "created route table is logged as a mutation",
+			expectedLevel: 2,
+			expectedMsg:   "successfully created route table",
+		},
+		{
+			name: "up to date route table is logged at a higher verbosity",
+			existing: network.RouteTable{
+				Name: to.StringPtr("my-rt"),
+				Tags: map[string]*string{
+					"Name": to.StringPtr("my-rt"),
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+				},
+				RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{},
+			},
+			expectedLevel: 4,
+			expectedMsg:   "route table is up to date",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			rtMock := mock_routetables.NewMockClient(mockCtrl)
+			if tc.existing.Name != nil {
+				rtMock.EXPECT().Get(context.TODO(), "my-rg", "my-rt").Return(tc.existing, nil)
+			} else {
+				rtMock.EXPECT().Get(context.TODO(), "my-rg", "my-rt").
+					Return(network.RouteTable{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				rtMock.EXPECT().CreateOrUpdate(context.TODO(), "my-rg", "my-rt", gomock.Any())
+			}
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			entries := []logEntry{}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Logger:  (&testLogger{entries: &entries}).WithValues("cluster", cluster.Name),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: rtMock,
+			}
+			if err := s.Reconcile(context.TODO(), &Spec{Name: "my-rt"}); err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if len(entries) == 0 || entries[0].msg != "reconciling route table" {
+				t.Fatalf("expected the reconcile to start with %q, got %+v", "reconciling route table", entries)
+			}
+			last := entries[len(entries)-1]
+			if last.msg != tc.expectedMsg || last.level != tc.expectedLevel {
+				t.Fatalf("expected the reconcile to end with %q at level %d, got %q at level %d", tc.expectedMsg, tc.expectedLevel, last.msg, last.level)
+			}
+			expectedValues := map[string]interface{}{"cluster": "test-cluster", "resourceGroup": "my-rg", "name": "my-rt"}
+			for _, entry := range entries {
+				for key, value := range expectedValues {
+					if entry.values[key] != value {
+						t.Errorf("expected %s=%v in log line %q, got %v", key, value, entry.msg, entry.values)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRouteTablesDryRun(t *testing.T) {
+	desired := network.RouteTable{
+		Location: to.StringPtr("test-location"),
+		Tags: map[string]*string{
+			"Name": to.StringPtr("my-rt"),
+			"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+		},
+		RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{
+			Routes: &[]network.Route{},
+		},
+	}
+
+	testcases := []struct {
+		name            string
+		delete          bool
+		expect          func(m *mock_routetables.MockClientMockRecorder)
+		expectedActions []scope.Action
+	}{
+		{
+			name: "missing route table is reported instead of created",
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").
+					Return(network.RouteTable{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+			expectedActions: []scope.Action{scope.CreateOrUpdateAction("route table", "my-rg", "my-rt", desired)},
+		},
+		{
+			name: "up to date route table is not reported",
+			expect: func(m *mock_routetables.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-rt").Return(network.RouteTable{
+					Name:                       to.StringPtr("my-rt"),
+					Tags:                       desired.Tags,
+					RouteTablePropertiesFormat: &network.RouteTablePropertiesFormat{},
+				}, nil)
+			},
+		},
+		{
+			name:            "route table is reported instead of deleted",
+			delete:          true,
+			expect:          func(m *mock_routetables.MockClientMockRecorder) {},
+			expectedActions: []scope.Action{scope.DeleteAction("route table", "my-rg", "my-rt")},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			// any call of CreateOrUpdate or Delete fails the test, as it is not expected.
+			rtMock := mock_routetables.NewMockClient(mockCtrl)
+			tc.expect(rtMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+				DryRun: true,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: rtMock,
+			}
+			if tc.delete {
+				err = s.Delete(context.TODO(), &Spec{Name: "my-rt"})
+			} else {
+				err = s.Reconcile(context.TODO(), &Spec{Name: "my-rt"})
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if actions := clusterScope.PlannedActions(); !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Fatalf("expected the planned actions %+v, got %+v", tc.expectedActions, actions)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scalesets
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string) (compute.VirtualMachineScaleSet, error)
+	CreateOrUpdate(context.Context, string, string, compute.VirtualMachineScaleSet) error
+	Update(context.Context, string, string, compute.VirtualMachineScaleSetUpdate) error
+	Delete(context.Context, string, string) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	scalesets compute.VirtualMachineScaleSetsClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new VM scale sets client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newVirtualMachineScaleSetsClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newVirtualMachineScaleSetsClient creates a new VM scale sets client from subscription ID and base URI.
+func newVirtualMachineScaleSetsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) compute.VirtualMachineScaleSetsClient {
+	scaleSetsClient := compute.NewVirtualMachineScaleSetsClientWithBaseURI(baseURI, subscriptionID)
+	scaleSetsClient.Authorizer = authorizer
+	scaleSetsClient.AddToUserAgent(azure.UserAgent)
+	return scaleSetsClient
+}
+
+// Get retrieves information about a VM scale set.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, name string) (compute.VirtualMachineScaleSet, error) {
+	var result compute.VirtualMachineScaleSet
+	err := azure.CallAPI(ctx, "scalesets", "Get", func() error {
+		var err error
+		result, err = ac.scalesets.Get(ctx, resourceGroupName, name)
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdate creates or updates a VM scale set.
+func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, name string, scaleSet compute.VirtualMachineScaleSet) error {
+	var future compute.VirtualMachineScaleSetsCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "scalesets", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.scalesets.CreateOrUpdate(ctx, resourceGroupName, name, scaleSet)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.scalesets.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.scalesets)
+		return err
+	})
+}
+
+// Update patches a VM scale set, such as its capacity, leaving the properties which are not set as they are.
+func (ac *AzureClient) Update(ctx context.Context, resourceGroupName, name string, update compute.VirtualMachineScaleSetUpdate) error {
+	var future compute.VirtualMachineScaleSetsUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "scalesets", "Update", func() error {
+		var err error
+		future, err = ac.scalesets.Update(ctx, resourceGroupName, name, update)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.scalesets.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.scalesets)
+		return err
+	})
+}
+
+// Delete deletes a VM scale set and its instances.
+func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, name string) error {
+	var future compute.VirtualMachineScaleSetsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "scalesets", "Delete", func() error {
+		var err error
+		future, err = ac.scalesets.Delete(ctx, resourceGroupName, name)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.scalesets.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.scalesets)
+		return err
+	})
+}
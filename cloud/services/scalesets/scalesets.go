@@ -0,0 +1,263 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scalesets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachines"
+)
+
+// Spec specification for a VM scale set of nodes.
+type Spec struct {
+	Name string
+	// Capacity is the number of instances of the scale set.
+	Capacity int64
+	Size     string
+	Image    infrav1.Image
+	// SubnetID is the ID of the subnet of the network interfaces of the instances.
+	SubnetID   string
+	SSHKeyData string
+	// CustomData is the base64 encoded bootstrap data of the instances.
+	CustomData string
+	// Role is the role of the nodes of the scale set, it defaults to node.
+	Role string
+	// AdditionalTags are the tags of the scale set and its instances, in addition to the additional tags of the
+	// cluster.
+	AdditionalTags infrav1.Tags
+	// SpotVMOptions makes the instances Spot virtual machines. Only node scale sets can have Spot instances.
+	SpotVMOptions *infrav1.SpotVMOptions
+}
+
+// Get provides information about a VM scale set.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	ssSpec, ok := spec.(*Spec)
+	if !ok {
+		return compute.VirtualMachineScaleSet{}, errors.New("invalid scale set specification")
+	}
+	scaleSet, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), ssSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "scale set %s not found", ssSpec.Name)
+	} else if err != nil {
+		return scaleSet, err
+	}
+	return scaleSet, nil
+}
+
+// Reconcile creates a VM scale set, or updates the capacity and the tags of an existing one. Only the capacity and the
+// tags of an existing scale set are updated, so that a scale set which already has them is left untouched. The
+// priority of the instances cannot change once the scale set is created.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	ssSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid scale set specification")
+	}
+	log := s.Scope.ResourceLogger(ssSpec.Name)
+
+	if ssSpec.role() == infrav1.ControlPlane && ssSpec.SpotVMOptions != nil {
+		return azure.NewTerminalError(errors.Errorf("control plane scale set %s cannot have spot instances", ssSpec.Name))
+	}
+
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), ssSpec.Name)
+	switch {
+	case err == nil:
+		return s.update(ctx, ssSpec, existing)
+	case !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get scale set %s in resource group %s", ssSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	scaleSet, err := s.generateScaleSet(ssSpec)
+	if err != nil {
+		return err
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("scale set", s.Scope.ResourceGroup(), ssSpec.Name, scaleSet)) {
+		return nil
+	}
+	log.V(2).Info("creating scale set", "capacity", ssSpec.Capacity)
+	if err := s.Client.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), ssSpec.Name, scaleSet); err != nil {
+		return errors.Wrapf(err, "failed to create scale set %s in resource group %s", ssSpec.Name, s.Scope.ResourceGroup())
+	}
+	log.V(2).Info("successfully created scale set")
+	return nil
+}
+
+// update updates the capacity of an existing VM scale set when it differs from the capacity of the spec, and adds the
+// tags it is missing. The tags of the scale set which are not desired are kept.
+func (s *Service) update(ctx context.Context, ssSpec *Spec, existing compute.VirtualMachineScaleSet) error {
+	log := s.Scope.ResourceLogger(ssSpec.Name)
+	update := compute.VirtualMachineScaleSetUpdate{}
+	if existing.Sku == nil || to.Int64(existing.Sku.Capacity) != ssSpec.Capacity {
+		update.Sku = &compute.Sku{Name: to.StringPtr(ssSpec.Size), Capacity: to.Int64Ptr(ssSpec.Capacity)}
+		if existing.Sku != nil {
+			update.Sku.Name = existing.Sku.Name
+			update.Sku.Tier = existing.Sku.Tier
+		}
+	}
+	if tags, changed := converters.MapToTags(existing.Tags).Merged(s.tags(ssSpec)); changed {
+		update.Tags = converters.TagsToMap(tags)
+	}
+	if update.Sku == nil && update.Tags == nil {
+		log.V(4).Info("scale set already has the desired capacity and tags", "capacity", ssSpec.Capacity)
+		return nil
+	}
+
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("scale set", s.Scope.ResourceGroup(), ssSpec.Name, update)) {
+		return nil
+	}
+	log.V(2).Info("updating scale set", "capacity", ssSpec.Capacity)
+	if err := s.Client.Update(ctx, s.Scope.ResourceGroup(), ssSpec.Name, update); err != nil {
+		return errors.Wrapf(err, "failed to update scale set %s in resource group %s with %d instances", ssSpec.Name, s.Scope.ResourceGroup(), ssSpec.Capacity)
+	}
+	log.V(2).Info("successfully updated scale set")
+	return nil
+}
+
+// Delete deletes the VM scale set with the provided name, with all its instances.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	ssSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid scale set specification")
+	}
+	log := s.Scope.ResourceLogger(ssSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("scale set", s.Scope.ResourceGroup(), ssSpec.Name)) {
+		return nil
+	}
+	log.V(2).Info("deleting scale set")
+	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), ssSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("scale set is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete scale set %s in resource group %s", ssSpec.Name, s.Scope.ResourceGroup())
+	}
+	log.V(2).Info("successfully deleted scale set")
+	return nil
+}
+
+// role returns the role of the nodes of a scale set.
+func (ssSpec *Spec) role() string {
+	if ssSpec.Role == "" {
+		return infrav1.Node
+	}
+	return ssSpec.Role
+}
+
+// tags returns the tags of a scale set, which Azure propagates to its instances: the additional tags of the cluster and
+// of the spec, overridden by the tags marking the scale set as owned by the cluster, naming it and giving its role.
+func (s *Service) tags(ssSpec *Spec) infrav1.Tags {
+	additionalTags := s.Scope.AdditionalTags()
+	additionalTags.Merge(ssSpec.AdditionalTags)
+	return infrav1.Build(infrav1.BuildParams{
+		ClusterName: s.Scope.Name(),
+		Lifecycle:   infrav1.ResourceLifecycleOwned,
+		Name:        to.StringPtr(ssSpec.Name),
+		Role:        to.StringPtr(ssSpec.role()),
+		Additional:  additionalTags,
+	})
+}
+
+// generateScaleSet generates a VM scale set of Linux nodes. The upgrade policy is manual, so that changes to the model
+// of the scale set do not restart its instances.
+func (s *Service) generateScaleSet(ssSpec *Spec) (compute.VirtualMachineScaleSet, error) {
+	if ssSpec.SSHKeyData == "" {
+		return compute.VirtualMachineScaleSet{}, errors.Errorf("scale set %s has no SSH public key", ssSpec.Name)
+	}
+	imageRef, err := virtualmachines.GenerateImageReference(ssSpec.Image)
+	if err != nil {
+		return compute.VirtualMachineScaleSet{}, errors.Wrapf(err, "failed to generate image reference of scale set %s", ssSpec.Name)
+	}
+
+	scaleSet := compute.VirtualMachineScaleSet{
+		Location: to.StringPtr(s.Scope.Location()),
+		Sku: &compute.Sku{
+			Name:     to.StringPtr(ssSpec.Size),
+			Tier:     to.StringPtr("Standard"),
+			Capacity: to.Int64Ptr(ssSpec.Capacity),
+		},
+		Plan: virtualmachines.GenerateImagePlanReference(ssSpec.Image),
+		Tags: converters.TagsToMap(s.tags(ssSpec)),
+		VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{
+			UpgradePolicy: &compute.UpgradePolicy{Mode: compute.UpgradeModeManual},
+			VirtualMachineProfile: &compute.VirtualMachineScaleSetVMProfile{
+				OsProfile: &compute.VirtualMachineScaleSetOSProfile{
+					ComputerNamePrefix: to.StringPtr(ssSpec.Name),
+					AdminUsername:      to.StringPtr(azure.DefaultUserName),
+					CustomData:         to.StringPtr(ssSpec.CustomData),
+					LinuxConfiguration: &compute.LinuxConfiguration{
+						DisablePasswordAuthentication: to.BoolPtr(true),
+						SSH: &compute.SSHConfiguration{
+							PublicKeys: &[]compute.SSHPublicKey{
+								{
+									Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", azure.DefaultUserName)),
+									KeyData: to.StringPtr(ssSpec.SSHKeyData),
+								},
+							},
+						},
+					},
+				},
+				StorageProfile: &compute.VirtualMachineScaleSetStorageProfile{
+					ImageReference: imageRef,
+					OsDisk: &compute.VirtualMachineScaleSetOSDisk{
+						CreateOption: compute.DiskCreateOptionTypesFromImage,
+						Caching:      compute.CachingTypesReadWrite,
+						OsType:       compute.Linux,
+					},
+				},
+				NetworkProfile: &compute.VirtualMachineScaleSetNetworkProfile{
+					NetworkInterfaceConfigurations: &[]compute.VirtualMachineScaleSetNetworkConfiguration{
+						{
+							Name: to.StringPtr(azure.GenerateNICName(ssSpec.Name)),
+							VirtualMachineScaleSetNetworkConfigurationProperties: &compute.VirtualMachineScaleSetNetworkConfigurationProperties{
+								Primary: to.BoolPtr(true),
+								IPConfigurations: &[]compute.VirtualMachineScaleSetIPConfiguration{
+									{
+										Name: to.StringPtr("ipconfig1"),
+										VirtualMachineScaleSetIPConfigurationProperties: &compute.VirtualMachineScaleSetIPConfigurationProperties{
+											Subnet: &compute.APIEntityReference{ID: to.StringPtr(ssSpec.SubnetID)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	spotSettings, err := virtualmachines.GenerateSpotVMSettings(ssSpec.SpotVMOptions)
+	if err != nil {
+		return compute.VirtualMachineScaleSet{}, errors.Wrapf(err, "invalid spot options of scale set %s", ssSpec.Name)
+	}
+	if spotSettings != nil {
+		profile := scaleSet.VirtualMachineProfile
+		profile.Priority = spotSettings.Priority
+		profile.EvictionPolicy = spotSettings.EvictionPolicy
+		profile.BillingProfile = spotSettings.BillingProfile
+	}
+	return scaleSet, nil
+}
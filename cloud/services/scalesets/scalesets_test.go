@@ -0,0 +1,338 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scalesets
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/scalesets/mock_scalesets"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileScaleSets(t *testing.T) {
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+	tags := map[string]*string{
+		"cluster-tag": to.StringPtr("cluster-value"),
+		"Name":        to.StringPtr("my-vmss"),
+		"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+		"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("node"),
+	}
+	existing := func(capacity int64) compute.VirtualMachineScaleSet {
+		return compute.VirtualMachineScaleSet{
+			Name: to.StringPtr("my-vmss"),
+			Sku:  &compute.Sku{Name: to.StringPtr("Standard_D2s_v3"), Tier: to.StringPtr("Standard"), Capacity: to.Int64Ptr(capacity)},
+			Tags: tags,
+		}
+	}
+	maxPrice := resource.MustParse("0.05")
+
+	testcases := []struct {
+		name           string
+		capacity       int64
+		sshKeyData     string
+		role           string
+		additionalTags infrav1.Tags
+		spotVMOptions  *infrav1.SpotVMOptions
+		expectedError  string
+		expect         func(m *mock_scalesets.MockClientMockRecorder)
+	}{
+		{
+			name:       "scale set is created",
+			capacity:   3,
+			sshKeyData: "my-ssh-key",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(compute.VirtualMachineScaleSet{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vmss", gomock.AssignableToTypeOf(compute.VirtualMachineScaleSet{})).
+					Do(func(_ context.Context, _, _ string, vmss compute.VirtualMachineScaleSet) {
+						if to.Int64(vmss.Sku.Capacity) != 3 || to.String(vmss.Sku.Name) != "Standard_D2s_v3" {
+							t.Errorf("expected 3 Standard_D2s_v3 instances, got %+v", vmss.Sku)
+						}
+						if vmss.UpgradePolicy.Mode != compute.UpgradeModeManual {
+							t.Errorf("expected manual upgrade policy, got %s", vmss.UpgradePolicy.Mode)
+						}
+						profile := vmss.VirtualMachineProfile
+						if id := to.String(profile.StorageProfile.ImageReference.ID); id != "my-image-id" {
+							t.Errorf("expected image my-image-id, got %s", id)
+						}
+						ipConfig := (*(*profile.NetworkProfile.NetworkInterfaceConfigurations)[0].IPConfigurations)[0]
+						if id := to.String(ipConfig.Subnet.ID); id != "my-subnet-id" {
+							t.Errorf("expected subnet my-subnet-id, got %s", id)
+						}
+						if key := to.String((*profile.OsProfile.LinuxConfiguration.SSH.PublicKeys)[0].KeyData); key != "my-ssh-key" {
+							t.Errorf("expected ssh key my-ssh-key, got %s", key)
+						}
+						if profile.Priority != "" || profile.EvictionPolicy != "" || profile.BillingProfile != nil {
+							t.Errorf("expected on-demand instances, got priority %q, eviction policy %q and billing profile %+v",
+								profile.Priority, profile.EvictionPolicy, profile.BillingProfile)
+						}
+						if !reflect.DeepEqual(vmss.Tags, tags) {
+							t.Errorf("expected tags %v, got %v", converters.MapToTags(tags), converters.MapToTags(vmss.Tags))
+						}
+					})
+			},
+		},
+		{
+			name:           "scale set is tagged with the tags of the cluster and of the spec",
+			capacity:       3,
+			sshKeyData:     "my-ssh-key",
+			additionalTags: infrav1.Tags{"pool-tag": "pool-value", "cluster-tag": "pool-override"},
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(compute.VirtualMachineScaleSet{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vmss", gomock.AssignableToTypeOf(compute.VirtualMachineScaleSet{})).
+					Do(func(_ context.Context, _, _ string, vmss compute.VirtualMachineScaleSet) {
+						expected := infrav1.Tags{
+							"cluster-tag": "pool-override",
+							"pool-tag":    "pool-value",
+							"Name":        "my-vmss",
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+							"sigs.k8s.io_cluster-api-provider-azure_role":                 "node",
+						}
+						if actual := converters.MapToTags(vmss.Tags); !actual.Equals(expected) {
+							t.Errorf("expected tags %v, got %v", expected, actual)
+						}
+					})
+			},
+		},
+		{
+			name:          "scale set has spot instances",
+			capacity:      3,
+			sshKeyData:    "my-ssh-key",
+			spotVMOptions: &infrav1.SpotVMOptions{MaxPrice: &maxPrice, EvictionPolicy: infrav1.SpotEvictionPolicyDelete},
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(compute.VirtualMachineScaleSet{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vmss", gomock.AssignableToTypeOf(compute.VirtualMachineScaleSet{})).
+					Do(func(_ context.Context, _, _ string, vmss compute.VirtualMachineScaleSet) {
+						profile := vmss.VirtualMachineProfile
+						if profile.Priority != compute.Low || profile.EvictionPolicy != compute.Delete {
+							t.Errorf("expected low priority instances evicted by deletion, got priority %q and eviction policy %q",
+								profile.Priority, profile.EvictionPolicy)
+						}
+						if profile.BillingProfile == nil || to.Float64(profile.BillingProfile.MaxPrice) != 0.05 {
+							t.Errorf("expected max price 0.05, got %+v", profile.BillingProfile)
+						}
+					})
+			},
+		},
+		{
+			name:          "control plane scale set cannot have spot instances",
+			capacity:      3,
+			sshKeyData:    "my-ssh-key",
+			role:          infrav1.ControlPlane,
+			spotVMOptions: &infrav1.SpotVMOptions{},
+			expectedError: "control plane scale set my-vmss cannot have spot instances",
+			expect:        func(m *mock_scalesets.MockClientMockRecorder) {},
+		},
+		{
+			name:       "scale set is scaled up",
+			capacity:   5,
+			sshKeyData: "my-ssh-key",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(existing(3), nil)
+				m.Update(context.TODO(), "my-rg", "my-vmss", compute.VirtualMachineScaleSetUpdate{
+					Sku: &compute.Sku{Name: to.StringPtr("Standard_D2s_v3"), Tier: to.StringPtr("Standard"), Capacity: to.Int64Ptr(5)},
+				})
+			},
+		},
+		{
+			name:       "scale set is scaled down",
+			capacity:   1,
+			sshKeyData: "my-ssh-key",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(existing(3), nil)
+				m.Update(context.TODO(), "my-rg", "my-vmss", compute.VirtualMachineScaleSetUpdate{
+					Sku: &compute.Sku{Name: to.StringPtr("Standard_D2s_v3"), Tier: to.StringPtr("Standard"), Capacity: to.Int64Ptr(1)},
+				})
+			},
+		},
+		{
+			name:       "scale set already has the desired capacity and tags",
+			capacity:   3,
+			sshKeyData: "my-ssh-key",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(existing(3), nil)
+			},
+		},
+		{
+			name:           "missing tags are added to the scale set",
+			capacity:       3,
+			sshKeyData:     "my-ssh-key",
+			additionalTags: infrav1.Tags{"pool-tag": "pool-value"},
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				vmss := existing(3)
+				vmss.Tags = map[string]*string{"unmanaged-tag": to.StringPtr("unmanaged-value")}
+				for k, v := range tags {
+					vmss.Tags[k] = v
+				}
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(vmss, nil)
+				m.Update(context.TODO(), "my-rg", "my-vmss", gomock.AssignableToTypeOf(compute.VirtualMachineScaleSetUpdate{})).
+					Do(func(_ context.Context, _, _ string, update compute.VirtualMachineScaleSetUpdate) {
+						if update.Sku != nil {
+							t.Errorf("expected the capacity to be left as is, got %+v", update.Sku)
+						}
+						actual := converters.MapToTags(update.Tags)
+						if actual["pool-tag"] != "pool-value" || actual["unmanaged-tag"] != "unmanaged-value" || actual["cluster-tag"] != "cluster-value" {
+							t.Errorf("expected the pool tag to be added to the existing tags, got %v", actual)
+						}
+					})
+			},
+		},
+		{
+			name:          "scale set without an ssh key",
+			capacity:      3,
+			expectedError: "scale set my-vmss has no SSH public key",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(compute.VirtualMachineScaleSet{}, notFound)
+			},
+		},
+		{
+			name:          "fail to update scale set",
+			capacity:      5,
+			sshKeyData:    "my-ssh-key",
+			expectedError: "failed to update scale set my-vmss in resource group my-rg with 5 instances: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vmss").Return(existing(3), nil)
+				m.Update(context.TODO(), "my-rg", "my-vmss", gomock.AssignableToTypeOf(compute.VirtualMachineScaleSetUpdate{})).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			scaleSetsMock := mock_scalesets.NewMockClient(mockCtrl)
+			tc.expect(scaleSetsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:       "test-location",
+						ResourceGroup:  "my-rg",
+						AdditionalTags: infrav1.Tags{"cluster-tag": "cluster-value"},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: scaleSetsMock,
+			}
+			err = s.Reconcile(context.TODO(), &Spec{
+				Name:           "my-vmss",
+				Capacity:       tc.capacity,
+				Size:           "Standard_D2s_v3",
+				Image:          infrav1.Image{ID: to.StringPtr("my-image-id")},
+				SubnetID:       "my-subnet-id",
+				SSHKeyData:     tc.sshKeyData,
+				Role:           tc.role,
+				AdditionalTags: tc.additionalTags,
+				SpotVMOptions:  tc.spotVMOptions,
+			})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteScaleSets(t *testing.T) {
+	testcases := []struct {
+		name   string
+		expect func(m *mock_scalesets.MockClientMockRecorder)
+	}{
+		{
+			name: "scale set exists",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vmss")
+			},
+		},
+		{
+			name: "scale set already deleted",
+			expect: func(m *mock_scalesets.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vmss").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			scaleSetsMock := mock_scalesets.NewMockClient(mockCtrl)
+			tc.expect(scaleSetsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:       "test-location",
+						ResourceGroup:  "my-rg",
+						AdditionalTags: infrav1.Tags{"cluster-tag": "cluster-value"},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: scaleSetsMock,
+			}
+			if err := s.Delete(context.TODO(), &Spec{Name: "my-vmss"}); err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -29,58 +29,101 @@ type Client interface {
 	Get(context.Context, string, string) (network.SecurityGroup, error)
 	CreateOrUpdate(context.Context, string, string, network.SecurityGroup) error
 	Delete(context.Context, string, string) error
+	List(context.Context, string, string) ([]network.SecurityRule, error)
 }
 
 // AzureClient contains the Azure go-sdk Client
 type AzureClient struct {
 	securitygroups network.SecurityGroupsClient
+	securityrules  network.SecurityRulesClient
 }
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newSecurityGroupsClient(subscriptionID, authorizer)
-	return &AzureClient{c}
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newSecurityGroupsClient(subscriptionID, baseURI, authorizer)
+	r := newSecurityRulesClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c, r}
 }
 
-// newSecurityGroupsClient creates a new security groups client from subscription ID.
-func newSecurityGroupsClient(subscriptionID string, authorizer autorest.Authorizer) network.SecurityGroupsClient {
-	securityGroupsClient := network.NewSecurityGroupsClient(subscriptionID)
+// newSecurityGroupsClient creates a new security groups client from subscription ID and base URI.
+func newSecurityGroupsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.SecurityGroupsClient {
+	securityGroupsClient := network.NewSecurityGroupsClientWithBaseURI(baseURI, subscriptionID)
 	securityGroupsClient.Authorizer = authorizer
 	securityGroupsClient.AddToUserAgent(azure.UserAgent)
 	return securityGroupsClient
 }
 
+// newSecurityRulesClient creates a new security rules client from subscription ID and base URI.
+func newSecurityRulesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.SecurityRulesClient {
+	securityRulesClient := network.NewSecurityRulesClientWithBaseURI(baseURI, subscriptionID)
+	securityRulesClient.Authorizer = authorizer
+	securityRulesClient.AddToUserAgent(azure.UserAgent)
+	return securityRulesClient
+}
+
 // Get gets the specified network security group.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, sgName string) (network.SecurityGroup, error) {
-	return ac.securitygroups.Get(ctx, resourceGroupName, sgName, "")
+	var result network.SecurityGroup
+	err := azure.CallAPI(ctx, "securitygroups", "Get", func() error {
+		var err error
+		result, err = ac.securitygroups.Get(ctx, resourceGroupName, sgName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a network security group in the specified resource group.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName string, sgName string, sg network.SecurityGroup) error {
-	future, err := ac.securitygroups.CreateOrUpdate(ctx, resourceGroupName, sgName, sg)
-	if err != nil {
+	var future network.SecurityGroupsCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "securitygroups", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.securitygroups.CreateOrUpdate(ctx, resourceGroupName, sgName, sg)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.securitygroups.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.securitygroups.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.securitygroups)
 		return err
-	}
-	_, err = future.Result(ac.securitygroups)
-	return err
+	})
 }
 
 // Delete deletes the specified network security group.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, sgName string) error {
-	future, err := ac.securitygroups.Delete(ctx, resourceGroupName, sgName)
-	if err != nil {
+	var future network.SecurityGroupsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "securitygroups", "Delete", func() error {
+		var err error
+		future, err = ac.securitygroups.Delete(ctx, resourceGroupName, sgName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.securitygroups.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.securitygroups.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.securitygroups)
 		return err
+	})
+}
+
+// List lists all the security rules of the specified network security group.
+func (ac *AzureClient) List(ctx context.Context, resourceGroupName, sgName string) ([]network.SecurityRule, error) {
+	var rules []network.SecurityRule
+	err := azure.ObserveAPICall("securitygroups", "List", func() error {
+		iter, err := ac.securityrules.ListComplete(ctx, resourceGroupName, sgName)
+		if err != nil {
+			return err
+		}
+		for iter.NotDone() {
+			rules = append(rules, iter.Value())
+			if err := iter.NextWithContext(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	_, err = future.Result(ac.securitygroups)
-	return err
+	return rules, nil
 }
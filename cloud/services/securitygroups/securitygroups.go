@@ -18,19 +18,39 @@ package securitygroups
 
 import (
 	"context"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
 	"k8s.io/klog"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec specification for network security groups
 type Spec struct {
 	Name           string
 	IsControlPlane bool
+	// APIServerPort is the port the control plane rule allows inbound traffic to.
+	// Defaults to the API server port of the cluster when zero.
+	APIServerPort int32
+	// APIServerSource is the source address prefix the control plane rule allows inbound traffic from.
+	// Defaults to any source when empty.
+	APIServerSource string
+	// SecurityRules are added to the default rules of the security group.
+	// A rule with the same name or priority as a default rule replaces it.
+	SecurityRules infrav1.SecurityRules
+	// NodeSecurityRules are the rules of the node subnets of a control plane security group shared with them. They
+	// are combined with SecurityRules, a rule of the nodes must not have the priority of a control plane rule.
+	NodeSecurityRules infrav1.SecurityRules
+	// Tags are applied to the security group in addition to the cluster tags.
+	Tags infrav1.Tags
 }
 
 // Get provides information about a network security group.
@@ -48,6 +68,23 @@ func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error
 	return securityGroup, nil
 }
 
+// ListSecurityRules returns the security rules currently applied to the named network security group.
+// A missing security group has no rules.
+func (s *Service) ListSecurityRules(ctx context.Context, name string) (infrav1.SecurityRules, error) {
+	rules, err := s.Client.List(ctx, s.Scope.ResourceGroup(), name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return infrav1.SecurityRules{}, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to list security rules of security group %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+
+	securityRules := make(infrav1.SecurityRules, 0, len(rules))
+	for _, rule := range rules {
+		securityRules = append(securityRules, converters.SDKToSecurityRule(rule))
+	}
+	return securityRules, nil
+}
+
 // Reconcile gets/creates/updates a network security group.
 func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
@@ -58,12 +95,18 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid security groups specification")
 	}
+	log := s.Scope.ResourceLogger(nsgSpec.Name)
+	log.V(4).Info("reconciling security group")
 
-	securityRules := &[]network.SecurityRule{}
+	defaultRules := []network.SecurityRule{}
 
 	if nsgSpec.IsControlPlane {
-		klog.V(2).Infof("using additional rules for control plane %s", nsgSpec.Name)
-		securityRules = &[]network.SecurityRule{
+		log.V(4).Info("using additional rules for control plane")
+		apiServerPort := nsgSpec.APIServerPort
+		if apiServerPort == 0 {
+			apiServerPort = s.Scope.APIServerPort()
+		}
+		defaultRules = []network.SecurityRule{
 			{
 				Name: to.StringPtr("allow_ssh"),
 				SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
@@ -81,10 +124,10 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 				Name: to.StringPtr("allow_6443"),
 				SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
 					Protocol:                 network.SecurityRuleProtocolTCP,
-					SourceAddressPrefix:      to.StringPtr("*"),
+					SourceAddressPrefix:      stringOrWildcard(to.StringPtr(nsgSpec.APIServerSource)),
 					SourcePortRange:          to.StringPtr("*"),
 					DestinationAddressPrefix: to.StringPtr("*"),
-					DestinationPortRange:     to.StringPtr(strconv.Itoa(int(s.Scope.APIServerPort()))),
+					DestinationPortRange:     to.StringPtr(strconv.Itoa(int(apiServerPort))),
 					Access:                   network.SecurityRuleAccessAllow,
 					Direction:                network.SecurityRuleDirectionInbound,
 					Priority:                 to.Int32Ptr(101),
@@ -93,23 +136,73 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 		}
 	}
 
-	klog.V(2).Infof("creating security group %s", nsgSpec.Name)
-	err := s.Client.CreateOrUpdate(
+	rules, err := combineSecurityRules(nsgSpec.SecurityRules, nsgSpec.NodeSecurityRules)
+	if err != nil {
+		return errors.Wrapf(err, "invalid security rules for shared security group %s", nsgSpec.Name)
+	}
+	securityRules, err := mergeSecurityRules(defaultRules, rules)
+	if err != nil {
+		return errors.Wrapf(err, "invalid security rules for security group %s", nsgSpec.Name)
+	}
+
+	additionalTags := s.Scope.AdditionalTags()
+	additionalTags.Merge(nsgSpec.Tags)
+	tags := infrav1.Build(infrav1.BuildParams{
+		ClusterName: s.Scope.Name(),
+		Lifecycle:   infrav1.ResourceLifecycleOwned,
+		Name:        to.StringPtr(nsgSpec.Name),
+		Additional:  additionalTags,
+	})
+
+	existingSG, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), nsgSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get security group %s in resource group %s", nsgSpec.Name, s.Scope.ResourceGroup())
+	case err == nil:
+		var existingRules []network.SecurityRule
+		if existingSG.SecurityGroupPropertiesFormat != nil && existingSG.SecurityRules != nil {
+			existingRules = *existingSG.SecurityRules
+		}
+		changed := diffSecurityRules(existingRules, securityRules)
+
+		var tagsChanged bool
+		tags, tagsChanged = converters.MapToTags(existingSG.Tags).Merged(tags)
+
+		if len(changed) == 0 && !tagsChanged {
+			log.V(4).Info("security group is up to date")
+			return nil
+		}
+		if len(changed) > 0 {
+			log.V(2).Info("security rules of security group have changed", "securityRules", strings.Join(changed, ", "))
+		}
+		if tagsChanged {
+			log.V(2).Info("tags of security group have changed")
+		}
+	}
+
+	securityGroup := network.SecurityGroup{
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(tags),
+		SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+			SecurityRules: &securityRules,
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("security group", s.Scope.ResourceGroup(), nsgSpec.Name, securityGroup)) {
+		return nil
+	}
+
+	log.V(2).Info("creating security group")
+	err = s.Client.CreateOrUpdate(
 		ctx,
 		s.Scope.ResourceGroup(),
 		nsgSpec.Name,
-		network.SecurityGroup{
-			Location: to.StringPtr(s.Scope.Location()),
-			SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
-				SecurityRules: securityRules,
-			},
-		},
+		securityGroup,
 	)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create security group %s in resource group %s", nsgSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("created security group %s", nsgSpec.Name)
+	log.V(2).Info("successfully created security group")
 	return err
 }
 
@@ -119,16 +212,235 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid security groups specification")
 	}
-	klog.V(2).Infof("deleting security group %s", nsgSpec.Name)
+	log := s.Scope.ResourceLogger(nsgSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("security group", s.Scope.ResourceGroup(), nsgSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting security group")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), nsgSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("security group is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete security group %s in resource group %s", nsgSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("deleted security group %s", nsgSpec.Name)
+	log.V(2).Info("successfully deleted security group")
 	return nil
 }
+
+// rulePriority identifies a security rule priority, which must be unique per direction.
+type rulePriority struct {
+	direction network.SecurityRuleDirection
+	priority  int32
+}
+
+// combineSecurityRules combines the rules of the control plane and of the nodes of a shared security group. A rule of
+// the nodes with the name of a control plane rule must be the same rule, it is only added once. Otherwise a rule of
+// the nodes cannot have the direction and priority of a control plane rule, as only one of them would apply.
+func combineSecurityRules(controlPlane, node infrav1.SecurityRules) (infrav1.SecurityRules, error) {
+	if len(node) == 0 {
+		return controlPlane, nil
+	}
+	byName := make(map[string]*infrav1.SecurityRule, len(controlPlane))
+	byPriority := make(map[rulePriority]string, len(controlPlane))
+	for _, rule := range controlPlane {
+		byName[rule.Name] = rule
+		byPriority[rulePriority{direction: convertRule(rule).Direction, priority: rule.Priority}] = rule.Name
+	}
+
+	combined := append(infrav1.SecurityRules{}, controlPlane...)
+	for _, rule := range node {
+		if existing, ok := byName[rule.Name]; ok {
+			if !reflect.DeepEqual(existing, rule) {
+				return nil, errors.Errorf("security rule %s is defined differently for the control plane and the nodes", rule.Name)
+			}
+			continue
+		}
+		key := rulePriority{direction: convertRule(rule).Direction, priority: rule.Priority}
+		if other, ok := byPriority[key]; ok {
+			return nil, errors.Errorf("security rule %s of the control plane and %s of the nodes have the same priority %d", other, rule.Name, rule.Priority)
+		}
+		combined = append(combined, rule)
+	}
+	return combined, nil
+}
+
+// mergeSecurityRules merges the user provided rules into the default rules. A user provided rule takes precedence
+// over a default rule with the same name, or with the same direction and priority. The merged rules are sorted by
+// direction and priority so that the same input always produces the same security group.
+func mergeSecurityRules(defaults []network.SecurityRule, rules infrav1.SecurityRules) ([]network.SecurityRule, error) {
+	names := make(map[string]bool, len(rules))
+	priorities := make(map[rulePriority]string, len(rules))
+	merged := make([]network.SecurityRule, 0, len(defaults)+len(rules))
+
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, errors.New("security rule name cannot be empty")
+		}
+		if names[rule.Name] {
+			return nil, errors.Errorf("security rule %s is defined more than once", rule.Name)
+		}
+		if rule.Priority < 100 || rule.Priority > 4096 {
+			return nil, errors.Errorf("security rule %s has priority %d, priority must be between 100 and 4096", rule.Name, rule.Priority)
+		}
+		sgRule := convertRule(rule)
+		key := rulePriority{direction: sgRule.Direction, priority: rule.Priority}
+		if other, ok := priorities[key]; ok {
+			return nil, errors.Errorf("security rules %s and %s have the same priority %d", other, rule.Name, rule.Priority)
+		}
+		names[rule.Name] = true
+		priorities[key] = rule.Name
+		merged = append(merged, sgRule)
+	}
+
+	for _, rule := range defaults {
+		key := rulePriority{direction: rule.Direction, priority: to.Int32(rule.Priority)}
+		if _, ok := priorities[key]; ok || names[to.String(rule.Name)] {
+			klog.V(2).Infof("security rule %s is overridden by a user provided rule", to.String(rule.Name))
+			continue
+		}
+		merged = append(merged, rule)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Direction != merged[j].Direction {
+			return merged[i].Direction == network.SecurityRuleDirectionInbound
+		}
+		return to.Int32(merged[i].Priority) < to.Int32(merged[j].Priority)
+	})
+	return merged, nil
+}
+
+// diffSecurityRules returns the sorted names of the rules which are only in one of existing and desired, or whose
+// properties differ between the two. The order of the rules is ignored.
+func diffSecurityRules(existing, desired []network.SecurityRule) []string {
+	existingByName := make(map[string]network.SecurityRule, len(existing))
+	for _, rule := range existing {
+		existingByName[to.String(rule.Name)] = rule
+	}
+
+	var changed []string
+	for _, rule := range desired {
+		name := to.String(rule.Name)
+		if existingRule, ok := existingByName[name]; !ok || !securityRuleEqual(existingRule, rule) {
+			changed = append(changed, name)
+		}
+		delete(existingByName, name)
+	}
+	for name := range existingByName {
+		changed = append(changed, name)
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// securityRuleEqual returns true if both rules have the same properties. Read-only properties like the ID, etag
+// and provisioning state are ignored.
+func securityRuleEqual(a, b network.SecurityRule) bool {
+	if a.SecurityRulePropertiesFormat == nil || b.SecurityRulePropertiesFormat == nil {
+		return a.SecurityRulePropertiesFormat == b.SecurityRulePropertiesFormat
+	}
+	return to.String(a.Description) == to.String(b.Description) &&
+		strings.EqualFold(string(a.Protocol), string(b.Protocol)) &&
+		to.String(a.SourcePortRange) == to.String(b.SourcePortRange) &&
+		to.String(a.DestinationPortRange) == to.String(b.DestinationPortRange) &&
+		to.String(a.SourceAddressPrefix) == to.String(b.SourceAddressPrefix) &&
+		to.String(a.DestinationAddressPrefix) == to.String(b.DestinationAddressPrefix) &&
+		applicationSecurityGroupsEqual(a.SourceApplicationSecurityGroups, b.SourceApplicationSecurityGroups) &&
+		applicationSecurityGroupsEqual(a.DestinationApplicationSecurityGroups, b.DestinationApplicationSecurityGroups) &&
+		a.Access == b.Access &&
+		a.Direction == b.Direction &&
+		to.Int32(a.Priority) == to.Int32(b.Priority)
+}
+
+// convertRule converts an infrav1.SecurityRule into an Azure SDK security rule.
+func convertRule(rule *infrav1.SecurityRule) network.SecurityRule {
+	protocol := network.SecurityRuleProtocolAsterisk
+	if rule.Protocol != "" {
+		protocol = network.SecurityRuleProtocol(rule.Protocol)
+	}
+	direction := network.SecurityRuleDirectionInbound
+	if rule.Direction != "" {
+		direction = network.SecurityRuleDirection(rule.Direction)
+	}
+	access := network.SecurityRuleAccessAllow
+	if rule.Access != "" {
+		access = network.SecurityRuleAccess(rule.Access)
+	}
+	sgRule := network.SecurityRule{
+		Name: to.StringPtr(rule.Name),
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Protocol:                 protocol,
+			SourceAddressPrefix:      stringOrWildcard(rule.Source),
+			SourcePortRange:          stringOrWildcard(rule.SourcePorts),
+			DestinationAddressPrefix: stringOrWildcard(rule.Destination),
+			DestinationPortRange:     stringOrWildcard(rule.DestinationPorts),
+			Access:                   access,
+			Direction:                direction,
+			Priority:                 to.Int32Ptr(rule.Priority),
+		},
+	}
+	// an application security group takes the place of the address prefix of the source or the destination.
+	if len(rule.SourceApplicationSecurityGroups) > 0 {
+		sgRule.SourceAddressPrefix = nil
+		sgRule.SourceApplicationSecurityGroups = applicationSecurityGroups(rule.SourceApplicationSecurityGroups)
+	}
+	if len(rule.DestinationApplicationSecurityGroups) > 0 {
+		sgRule.DestinationAddressPrefix = nil
+		sgRule.DestinationApplicationSecurityGroups = applicationSecurityGroups(rule.DestinationApplicationSecurityGroups)
+	}
+	if rule.Description != "" {
+		sgRule.Description = to.StringPtr(rule.Description)
+	}
+	return sgRule
+}
+
+// applicationSecurityGroups returns the references to the application security groups with the IDs.
+func applicationSecurityGroups(ids []string) *[]network.ApplicationSecurityGroup {
+	asgs := make([]network.ApplicationSecurityGroup, 0, len(ids))
+	for _, id := range ids {
+		asgs = append(asgs, network.ApplicationSecurityGroup{ID: to.StringPtr(id)})
+	}
+	return &asgs
+}
+
+// applicationSecurityGroupsEqual returns whether a and b reference the same application security groups, in any order.
+// Resource IDs are case-insensitive.
+func applicationSecurityGroupsEqual(a, b *[]network.ApplicationSecurityGroup) bool {
+	idsA, idsB := applicationSecurityGroupIDs(a), applicationSecurityGroupIDs(b)
+	if len(idsA) != len(idsB) {
+		return false
+	}
+	for i := range idsA {
+		if idsA[i] != idsB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applicationSecurityGroupIDs returns the sorted, lowercase IDs of the application security groups.
+func applicationSecurityGroupIDs(asgs *[]network.ApplicationSecurityGroup) []string {
+	if asgs == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(*asgs))
+	for _, asg := range *asgs {
+		ids = append(ids, strings.ToLower(to.String(asg.ID)))
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// stringOrWildcard returns the value of s, or the wildcard '*' if s is nil or empty.
+func stringOrWildcard(s *string) *string {
+	if to.String(s) == "" {
+		return to.StringPtr("*")
+	}
+	return s
+}
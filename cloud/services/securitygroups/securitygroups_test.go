@@ -18,15 +18,20 @@ package securitygroups
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/mock/gomock"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/securitygroups/mock_securitygroups"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
@@ -34,11 +39,22 @@ import (
 )
 
 func TestReconcileSecurityGroups(t *testing.T) {
+	ownedTags := map[string]*string{
+		"Name": to.StringPtr("my-sg"),
+		"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+	}
+
 	testcases := []struct {
 		name           string
 		sgName         string
 		isControlPlane bool
+		apiServerPort  int32
+		apiServerSrc   string
 		vnetSpec       *infrav1.VnetSpec
+		securityRules  infrav1.SecurityRules
+		nodeRules      infrav1.SecurityRules
+		tags           infrav1.Tags
+		expectedError  string
 		expect         func(m *mock_securitygroups.MockClientMockRecorder)
 	}{
 		{
@@ -47,6 +63,8 @@ func TestReconcileSecurityGroups(t *testing.T) {
 			isControlPlane: true,
 			vnetSpec:       &infrav1.VnetSpec{},
 			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
 				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", gomock.AssignableToTypeOf(network.SecurityGroup{}))
 			},
 		}, {
@@ -55,6 +73,8 @@ func TestReconcileSecurityGroups(t *testing.T) {
 			isControlPlane: false,
 			vnetSpec:       &infrav1.VnetSpec{},
 			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
 				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", gomock.AssignableToTypeOf(network.SecurityGroup{}))
 			},
 		}, {
@@ -65,11 +85,294 @@ func TestReconcileSecurityGroups(t *testing.T) {
 			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
 
 			},
+		}, {
+			name:           "security group with additional rules",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			securityRules: infrav1.SecurityRules{
+				{Name: "allow_nodeports", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 200, DestinationPorts: to.StringPtr("30000-32767")},
+			},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", gomock.AssignableToTypeOf(network.SecurityGroup{}))
+			},
+		}, {
+			name:           "security group with duplicate rule priorities",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			securityRules: infrav1.SecurityRules{
+				{Name: "allow_80", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 200, DestinationPorts: to.StringPtr("80")},
+				{Name: "allow_443", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 200, DestinationPorts: to.StringPtr("443")},
+			},
+			expectedError: "invalid security rules for security group my-sg: security rules allow_80 and allow_443 have the same priority 200",
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+
+			},
+		}, {
+			name:           "shared security group combines the rules of the control plane and the nodes",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			securityRules: infrav1.SecurityRules{
+				{Name: "allow_etcd", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 200, DestinationPorts: to.StringPtr("2379-2380")},
+				{Name: "allow_kubelet", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 210, DestinationPorts: to.StringPtr("10250")},
+			},
+			nodeRules: infrav1.SecurityRules{
+				{Name: "allow_kubelet", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 210, DestinationPorts: to.StringPtr("10250")},
+				{Name: "allow_nodeports", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 220, DestinationPorts: to.StringPtr("30000-32767")},
+			},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg",
+					matchRuleNames("allow_ssh", "allow_6443", "allow_etcd", "allow_kubelet", "allow_nodeports"))
+			},
+		}, {
+			name:           "shared security group with a node rule at the priority of a control plane rule",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			securityRules: infrav1.SecurityRules{
+				{Name: "allow_etcd", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 200, DestinationPorts: to.StringPtr("2379-2380")},
+			},
+			nodeRules: infrav1.SecurityRules{
+				{Name: "allow_nodeports", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 200, DestinationPorts: to.StringPtr("30000-32767")},
+			},
+			expectedError: "invalid security rules for shared security group my-sg: " +
+				"security rule allow_etcd of the control plane and allow_nodeports of the nodes have the same priority 200",
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {},
+		}, {
+			name:           "shared security group with a rule defined differently for the nodes",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			securityRules: infrav1.SecurityRules{
+				{Name: "allow_kubelet", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 210, DestinationPorts: to.StringPtr("10250")},
+			},
+			nodeRules: infrav1.SecurityRules{
+				{Name: "allow_kubelet", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 211, DestinationPorts: to.StringPtr("10250")},
+			},
+			expectedError: "invalid security rules for shared security group my-sg: " +
+				"security rule allow_kubelet is defined differently for the control plane and the nodes",
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {},
+		}, {
+			name:           "security group with an outbound rule",
+			sgName:         "my-sg",
+			isControlPlane: false,
+			vnetSpec:       &infrav1.VnetSpec{},
+			securityRules: infrav1.SecurityRules{
+				{
+					Name:        "deny_internet",
+					Protocol:    infrav1.SecurityGroupProtocolAll,
+					Priority:    4000,
+					Direction:   infrav1.SecurityRuleDirectionOutbound,
+					Access:      infrav1.SecurityRuleAccessDeny,
+					Destination: to.StringPtr("Internet"),
+				},
+			},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", matchSecurityRules([]network.SecurityRule{
+					{
+						Name: to.StringPtr("deny_internet"),
+						SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+							Protocol:                 network.SecurityRuleProtocolAsterisk,
+							SourceAddressPrefix:      to.StringPtr("*"),
+							SourcePortRange:          to.StringPtr("*"),
+							DestinationAddressPrefix: to.StringPtr("Internet"),
+							DestinationPortRange:     to.StringPtr("*"),
+							Access:                   network.SecurityRuleAccessDeny,
+							Direction:                network.SecurityRuleDirectionOutbound,
+							Priority:                 to.Int32Ptr(4000),
+						},
+					},
+				}))
+			},
+		}, {
+			name:           "security group for a control plane with a custom API server port",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			apiServerPort:  8443,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", matchSecurityRules([]network.SecurityRule{
+					newRule("allow_ssh", "22", 100),
+					newRule("allow_6443", "8443", 101),
+				}))
+			},
+		}, {
+			name:           "security group for an internal control plane",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			apiServerSrc:   "VirtualNetwork",
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				apiServerRule := newRule("allow_6443", "6443", 101)
+				apiServerRule.SourceAddressPrefix = to.StringPtr("VirtualNetwork")
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", matchSecurityRules([]network.SecurityRule{
+					newRule("allow_ssh", "22", 100),
+					apiServerRule,
+				}))
+			},
+		}, {
+			name:           "security group exists with the same rules in a different order",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{
+						ID:   to.StringPtr("my-sg-id"),
+						Name: to.StringPtr("my-sg"),
+						Tags: ownedTags,
+						SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+							SecurityRules: &[]network.SecurityRule{
+								existingRule("allow_6443", "6443", 101),
+								existingRule("allow_ssh", "22", 100),
+							},
+						},
+					}, nil)
+			},
+		}, {
+			name:           "security group exists with an empty rule list",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{
+						ID:   to.StringPtr("my-sg-id"),
+						Name: to.StringPtr("my-sg"),
+						Tags: ownedTags,
+						SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+							SecurityRules: &[]network.SecurityRule{},
+						},
+					}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", gomock.AssignableToTypeOf(network.SecurityGroup{}))
+			},
+		}, {
+			name:           "security group exists and it's not for a control plane with no rules",
+			sgName:         "my-sg",
+			isControlPlane: false,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{
+						ID:   to.StringPtr("my-sg-id"),
+						Name: to.StringPtr("my-sg"),
+						Tags: ownedTags,
+						SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+							SecurityRules: &[]network.SecurityRule{},
+						},
+					}, nil)
+			},
+		}, {
+			name:           "security group exists with a modified rule",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{
+						ID:   to.StringPtr("my-sg-id"),
+						Name: to.StringPtr("my-sg"),
+						Tags: ownedTags,
+						SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+							SecurityRules: &[]network.SecurityRule{
+								existingRule("allow_ssh", "2222", 100),
+								existingRule("allow_6443", "6443", 101),
+							},
+						},
+					}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", gomock.AssignableToTypeOf(network.SecurityGroup{}))
+			},
+		}, {
+			name:           "security group exists with an extra rule",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{
+						ID:   to.StringPtr("my-sg-id"),
+						Name: to.StringPtr("my-sg"),
+						Tags: ownedTags,
+						SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+							SecurityRules: &[]network.SecurityRule{
+								existingRule("allow_ssh", "22", 100),
+								existingRule("allow_6443", "6443", 101),
+								existingRule("allow_80", "80", 200),
+							},
+						},
+					}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", gomock.AssignableToTypeOf(network.SecurityGroup{}))
+			},
+		}, {
+			name:           "security group with custom tags",
+			sgName:         "my-sg",
+			isControlPlane: false,
+			vnetSpec:       &infrav1.VnetSpec{},
+			tags:           infrav1.Tags{"team": "networking"},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", matchTags(infrav1.Tags{
+					"Name": "my-sg",
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+					"team": "networking",
+				}))
+			},
+		}, {
+			name:           "security group exists with tags added out of band",
+			sgName:         "my-sg",
+			isControlPlane: false,
+			vnetSpec:       &infrav1.VnetSpec{},
+			tags:           infrav1.Tags{"team": "networking"},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{
+						ID:   to.StringPtr("my-sg-id"),
+						Name: to.StringPtr("my-sg"),
+						Tags: map[string]*string{
+							"Name": to.StringPtr("my-sg"),
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+							"cost-center": to.StringPtr("1234"),
+						},
+						SecurityGroupPropertiesFormat: &network.SecurityGroupPropertiesFormat{
+							SecurityRules: &[]network.SecurityRule{},
+						},
+					}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-sg", matchTags(infrav1.Tags{
+					"Name": "my-sg",
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+					"cost-center": "1234",
+					"team":        "networking",
+				}))
+			},
+		}, {
+			name:           "fail to get security group",
+			sgName:         "my-sg",
+			isControlPlane: true,
+			vnetSpec:       &infrav1.VnetSpec{},
+			expectedError:  "failed to get security group my-sg in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
 		},
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
 
 			cluster := &clusterv1.Cluster{
@@ -107,16 +410,325 @@ func TestReconcileSecurityGroups(t *testing.T) {
 			}
 
 			sgSpec := &Spec{
-				Name:           tc.sgName,
-				IsControlPlane: tc.isControlPlane,
+				Name:              tc.sgName,
+				IsControlPlane:    tc.isControlPlane,
+				APIServerPort:     tc.apiServerPort,
+				APIServerSource:   tc.apiServerSrc,
+				SecurityRules:     tc.securityRules,
+				NodeSecurityRules: tc.nodeRules,
+				Tags:              tc.tags,
 			}
-			if err := s.Reconcile(context.TODO(), sgSpec); err != nil {
+			err = s.Reconcile(context.TODO(), sgSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
 				t.Fatalf("got an unexpected error: %v", err)
 			}
 		})
 	}
 }
 
+func TestMergeSecurityRules(t *testing.T) {
+	asgID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/applicationSecurityGroups/my-asg"
+	sshRule := network.SecurityRule{
+		Name: to.StringPtr("allow_ssh"),
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Protocol:                 network.SecurityRuleProtocolTCP,
+			SourceAddressPrefix:      to.StringPtr("*"),
+			SourcePortRange:          to.StringPtr("*"),
+			DestinationAddressPrefix: to.StringPtr("*"),
+			DestinationPortRange:     to.StringPtr("22"),
+			Access:                   network.SecurityRuleAccessAllow,
+			Direction:                network.SecurityRuleDirectionInbound,
+			Priority:                 to.Int32Ptr(100),
+		},
+	}
+	apiRule := network.SecurityRule{
+		Name: to.StringPtr("allow_6443"),
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Protocol:                 network.SecurityRuleProtocolTCP,
+			SourceAddressPrefix:      to.StringPtr("*"),
+			SourcePortRange:          to.StringPtr("*"),
+			DestinationAddressPrefix: to.StringPtr("*"),
+			DestinationPortRange:     to.StringPtr("6443"),
+			Access:                   network.SecurityRuleAccessAllow,
+			Direction:                network.SecurityRuleDirectionInbound,
+			Priority:                 to.Int32Ptr(101),
+		},
+	}
+	nodePortRule := network.SecurityRule{
+		Name: to.StringPtr("allow_nodeports"),
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Description:              to.StringPtr("NodePort services"),
+			Protocol:                 network.SecurityRuleProtocolTCP,
+			SourceAddressPrefix:      to.StringPtr("*"),
+			SourcePortRange:          to.StringPtr("*"),
+			DestinationAddressPrefix: to.StringPtr("*"),
+			DestinationPortRange:     to.StringPtr("30000-32767"),
+			Access:                   network.SecurityRuleAccessAllow,
+			Direction:                network.SecurityRuleDirectionInbound,
+			Priority:                 to.Int32Ptr(100),
+		},
+	}
+
+	testcases := []struct {
+		name          string
+		defaults      []network.SecurityRule
+		rules         infrav1.SecurityRules
+		expected      []network.SecurityRule
+		expectedError string
+	}{
+		{
+			name:     "no user provided rules",
+			defaults: []network.SecurityRule{sshRule, apiRule},
+			expected: []network.SecurityRule{sshRule, apiRule},
+		},
+		{
+			name:     "no default rules",
+			defaults: []network.SecurityRule{},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_nodeports", Description: "NodePort services", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 100, DestinationPorts: to.StringPtr("30000-32767")},
+			},
+			expected: []network.SecurityRule{nodePortRule},
+		},
+		{
+			name:     "user provided rule replaces default rule with the same priority",
+			defaults: []network.SecurityRule{sshRule, apiRule},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_nodeports", Description: "NodePort services", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 100, DestinationPorts: to.StringPtr("30000-32767")},
+			},
+			expected: []network.SecurityRule{nodePortRule, apiRule},
+		},
+		{
+			name:     "user provided rule replaces default rule with the same name",
+			defaults: []network.SecurityRule{sshRule, apiRule},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_ssh", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 102, Source: to.StringPtr("10.0.0.0/8"), DestinationPorts: to.StringPtr("22")},
+			},
+			expected: []network.SecurityRule{
+				apiRule,
+				{
+					Name: to.StringPtr("allow_ssh"),
+					SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+						Protocol:                 network.SecurityRuleProtocolTCP,
+						SourceAddressPrefix:      to.StringPtr("10.0.0.0/8"),
+						SourcePortRange:          to.StringPtr("*"),
+						DestinationAddressPrefix: to.StringPtr("*"),
+						DestinationPortRange:     to.StringPtr("22"),
+						Access:                   network.SecurityRuleAccessAllow,
+						Direction:                network.SecurityRuleDirectionInbound,
+						Priority:                 to.Int32Ptr(102),
+					},
+				},
+			},
+		},
+		{
+			name:     "user provided rule with an application security group as the destination",
+			defaults: []network.SecurityRule{sshRule},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_web", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 110, DestinationPorts: to.StringPtr("443"), DestinationApplicationSecurityGroups: []string{asgID}},
+			},
+			expected: []network.SecurityRule{
+				sshRule,
+				{
+					Name: to.StringPtr("allow_web"),
+					SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+						Protocol:                             network.SecurityRuleProtocolTCP,
+						SourceAddressPrefix:                  to.StringPtr("*"),
+						SourcePortRange:                      to.StringPtr("*"),
+						DestinationPortRange:                 to.StringPtr("443"),
+						DestinationApplicationSecurityGroups: &[]network.ApplicationSecurityGroup{{ID: to.StringPtr(asgID)}},
+						Access:                               network.SecurityRuleAccessAllow,
+						Direction:                            network.SecurityRuleDirectionInbound,
+						Priority:                             to.Int32Ptr(110),
+					},
+				},
+			},
+		},
+		{
+			name:     "merged rules are sorted by priority",
+			defaults: []network.SecurityRule{apiRule, sshRule},
+			expected: []network.SecurityRule{sshRule, apiRule},
+		},
+		{
+			name:     "inbound and outbound rules can share a priority",
+			defaults: []network.SecurityRule{sshRule, apiRule},
+			rules: infrav1.SecurityRules{
+				{Name: "deny_outbound_ssh", Priority: 100, Direction: infrav1.SecurityRuleDirectionOutbound, Access: infrav1.SecurityRuleAccessDeny, DestinationPorts: to.StringPtr("22")},
+			},
+			expected: []network.SecurityRule{
+				sshRule,
+				apiRule,
+				{
+					Name: to.StringPtr("deny_outbound_ssh"),
+					SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+						Protocol:                 network.SecurityRuleProtocolAsterisk,
+						SourceAddressPrefix:      to.StringPtr("*"),
+						SourcePortRange:          to.StringPtr("*"),
+						DestinationAddressPrefix: to.StringPtr("*"),
+						DestinationPortRange:     to.StringPtr("22"),
+						Access:                   network.SecurityRuleAccessDeny,
+						Direction:                network.SecurityRuleDirectionOutbound,
+						Priority:                 to.Int32Ptr(100),
+					},
+				},
+			},
+		},
+		{
+			name:     "duplicate priorities",
+			defaults: []network.SecurityRule{sshRule, apiRule},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_80", Priority: 200},
+				{Name: "allow_443", Priority: 200},
+			},
+			expectedError: "security rules allow_80 and allow_443 have the same priority 200",
+		},
+		{
+			name:     "duplicate names",
+			defaults: []network.SecurityRule{},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_80", Priority: 200},
+				{Name: "allow_80", Priority: 201},
+			},
+			expectedError: "security rule allow_80 is defined more than once",
+		},
+		{
+			name:     "priority out of range",
+			defaults: []network.SecurityRule{},
+			rules: infrav1.SecurityRules{
+				{Name: "allow_80", Priority: 50},
+			},
+			expectedError: "security rule allow_80 has priority 50, priority must be between 100 and 4096",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := mergeSecurityRules(tc.defaults, tc.rules)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(merged, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, merged)
+			}
+		})
+	}
+}
+
+// securityRulesMatcher matches a network.SecurityGroup which has exactly the expected security rules.
+type securityRulesMatcher struct {
+	rules []network.SecurityRule
+}
+
+func matchSecurityRules(rules []network.SecurityRule) gomock.Matcher {
+	return securityRulesMatcher{rules: rules}
+}
+
+func (m securityRulesMatcher) Matches(x interface{}) bool {
+	sg, ok := x.(network.SecurityGroup)
+	if !ok || sg.SecurityGroupPropertiesFormat == nil || sg.SecurityRules == nil {
+		return false
+	}
+	return reflect.DeepEqual(*sg.SecurityRules, m.rules)
+}
+
+func (m securityRulesMatcher) String() string {
+	return fmt.Sprintf("has security rules %+v", m.rules)
+}
+
+type tagsMatcher struct {
+	tags infrav1.Tags
+}
+
+// matchTags returns a matcher for a security group with exactly the given tags.
+func matchTags(tags infrav1.Tags) gomock.Matcher {
+	return tagsMatcher{tags: tags}
+}
+
+func (m tagsMatcher) Matches(x interface{}) bool {
+	sg, ok := x.(network.SecurityGroup)
+	if !ok {
+		return false
+	}
+	return converters.MapToTags(sg.Tags).Equals(m.tags)
+}
+
+func (m tagsMatcher) String() string {
+	return fmt.Sprintf("has tags %v", m.tags)
+}
+
+type ruleNamesMatcher struct {
+	names []string
+}
+
+// matchRuleNames returns a matcher for a security group with exactly the given rules, in any order.
+func matchRuleNames(names ...string) gomock.Matcher {
+	return ruleNamesMatcher{names: names}
+}
+
+func (m ruleNamesMatcher) Matches(x interface{}) bool {
+	sg, ok := x.(network.SecurityGroup)
+	if !ok || sg.SecurityGroupPropertiesFormat == nil || sg.SecurityRules == nil {
+		return false
+	}
+	names := make([]string, 0, len(*sg.SecurityRules))
+	for _, rule := range *sg.SecurityRules {
+		names = append(names, to.String(rule.Name))
+	}
+	expected := append([]string{}, m.names...)
+	sort.Strings(names)
+	sort.Strings(expected)
+	return reflect.DeepEqual(names, expected)
+}
+
+func (m ruleNamesMatcher) String() string {
+	return fmt.Sprintf("has security rules %v", m.names)
+}
+
+// newRule returns an inbound TCP rule allowing traffic to the given port from anywhere.
+func newRule(name, port string, priority int32) network.SecurityRule {
+	return network.SecurityRule{
+		Name: to.StringPtr(name),
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Protocol:                 network.SecurityRuleProtocolTCP,
+			SourceAddressPrefix:      to.StringPtr("*"),
+			SourcePortRange:          to.StringPtr("*"),
+			DestinationAddressPrefix: to.StringPtr("*"),
+			DestinationPortRange:     to.StringPtr(port),
+			Access:                   network.SecurityRuleAccessAllow,
+			Direction:                network.SecurityRuleDirectionInbound,
+			Priority:                 to.Int32Ptr(priority),
+		},
+	}
+}
+
+// existingRule returns an inbound TCP rule the way Azure returns it, including read-only properties.
+func existingRule(name, port string, priority int32) network.SecurityRule {
+	return network.SecurityRule{
+		ID:   to.StringPtr("my-sg-id/securityRules/" + name),
+		Name: to.StringPtr(name),
+		Etag: to.StringPtr("etag"),
+		SecurityRulePropertiesFormat: &network.SecurityRulePropertiesFormat{
+			Protocol:                 network.SecurityRuleProtocolTCP,
+			SourceAddressPrefix:      to.StringPtr("*"),
+			SourcePortRange:          to.StringPtr("*"),
+			DestinationAddressPrefix: to.StringPtr("*"),
+			DestinationPortRange:     to.StringPtr(port),
+			Access:                   network.SecurityRuleAccessAllow,
+			Direction:                network.SecurityRuleDirectionInbound,
+			Priority:                 to.Int32Ptr(priority),
+			ProvisioningState:        to.StringPtr("Succeeded"),
+		},
+	}
+}
+
 func TestDeleteSecurityGroups(t *testing.T) {
 	testcases := []struct {
 		name   string
@@ -142,6 +754,7 @@ func TestDeleteSecurityGroups(t *testing.T) {
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
 
 			cluster := &clusterv1.Cluster{
@@ -186,3 +799,103 @@ func TestDeleteSecurityGroups(t *testing.T) {
 		})
 	}
 }
+
+func TestListSecurityRules(t *testing.T) {
+	testcases := []struct {
+		name          string
+		sgName        string
+		expectedRules infrav1.SecurityRules
+		expectedError string
+		expect        func(m *mock_securitygroups.MockClientMockRecorder)
+	}{
+		{
+			name:   "security group has rules",
+			sgName: "my-sg",
+			expectedRules: infrav1.SecurityRules{
+				{
+					Name:             "allow_ssh",
+					Protocol:         infrav1.SecurityGroupProtocolTCP,
+					Priority:         100,
+					Direction:        infrav1.SecurityRuleDirectionInbound,
+					Access:           infrav1.SecurityRuleAccessAllow,
+					SourcePorts:      to.StringPtr("*"),
+					DestinationPorts: to.StringPtr("22"),
+					Source:           to.StringPtr("*"),
+					Destination:      to.StringPtr("*"),
+				},
+			},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.List(context.TODO(), "my-rg", "my-sg").
+					Return([]network.SecurityRule{existingRule("allow_ssh", "22", 100)}, nil)
+			},
+		}, {
+			name:          "security group does not exist",
+			sgName:        "my-sg",
+			expectedRules: infrav1.SecurityRules{},
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.List(context.TODO(), "my-rg", "my-sg").
+					Return(nil, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		}, {
+			name:          "fail to list security rules",
+			sgName:        "my-sg",
+			expectedError: "failed to list security rules of security group my-sg in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_securitygroups.MockClientMockRecorder) {
+				m.List(context.TODO(), "my-rg", "my-sg").
+					Return(nil, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+
+			client := fake.NewFakeClient(cluster)
+
+			tc.expect(sgMock.EXPECT())
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  client,
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: sgMock,
+			}
+
+			rules, err := s.ListSecurityRules(context.TODO(), tc.sgName)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rules, tc.expectedRules) {
+				t.Errorf("expected rules %+v, got %+v", tc.expectedRules, rules)
+			}
+		})
+	}
+}
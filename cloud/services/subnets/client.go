@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new subnets client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newSubnetsClient(subscriptionID, authorizer)
+// NewClient creates a new subnets client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newSubnetsClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newSubnetsClient creates a new subnets client from subscription ID.
-func newSubnetsClient(subscriptionID string, authorizer autorest.Authorizer) network.SubnetsClient {
-	subnetsClient := network.NewSubnetsClient(subscriptionID)
+// newSubnetsClient creates a new subnets client from subscription ID and base URI.
+func newSubnetsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.SubnetsClient {
+	subnetsClient := network.NewSubnetsClientWithBaseURI(baseURI, subscriptionID)
 	subnetsClient.Authorizer = authorizer
 	subnetsClient.AddToUserAgent(azure.UserAgent)
 	return subnetsClient
@@ -54,33 +54,43 @@ func newSubnetsClient(subscriptionID string, authorizer autorest.Authorizer) net
 
 // Get gets the specified subnet by virtual network and resource group.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, vnetName, snName string) (network.Subnet, error) {
-	return ac.subnets.Get(ctx, resourceGroupName, vnetName, snName, "")
+	var result network.Subnet
+	err := azure.CallAPI(ctx, "subnets", "Get", func() error {
+		var err error
+		result, err = ac.subnets.Get(ctx, resourceGroupName, vnetName, snName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a subnet in the specified virtual network.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, vnetName, snName string, sn network.Subnet) error {
-	future, err := ac.subnets.CreateOrUpdate(ctx, resourceGroupName, vnetName, snName, sn)
-	if err != nil {
+	var future network.SubnetsCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "subnets", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.subnets.CreateOrUpdate(ctx, resourceGroupName, vnetName, snName, sn)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.subnets.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.subnets.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.subnets)
 		return err
-	}
-	_, err = future.Result(ac.subnets)
-	return err
+	})
 }
 
 // Delete deletes the specified subnet.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, vnetName, snName string) error {
-	future, err := ac.subnets.Delete(ctx, resourceGroupName, vnetName, snName)
-	if err != nil {
+	var future network.SubnetsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "subnets", "Delete", func() error {
+		var err error
+		future, err = ac.subnets.Delete(ctx, resourceGroupName, vnetName, snName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.subnets.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.subnets.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.subnets)
 		return err
-	}
-	_, err = future.Result(ac.subnets)
-	return err
+	})
 }
@@ -18,6 +18,7 @@ package subnets
 
 import (
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/natgateways"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/routetables"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/securitygroups"
 )
@@ -28,14 +29,16 @@ type Service struct {
 	Client
 	SecurityGroupsClient securitygroups.Client
 	RouteTablesClient    routetables.Client
+	NatGatewaysClient    natgateways.Client
 }
 
 // NewService creates a new service.
 func NewService(scope *scope.ClusterScope) *Service {
 	return &Service{
 		Scope:                scope,
-		Client:               NewClient(scope.SubscriptionID, scope.Authorizer),
-		SecurityGroupsClient: securitygroups.NewClient(scope.SubscriptionID, scope.Authorizer),
-		RouteTablesClient:    routetables.NewClient(scope.SubscriptionID, scope.Authorizer),
+		Client:               NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		SecurityGroupsClient: securitygroups.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		RouteTablesClient:    routetables.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
+		NatGatewaysClient:    natgateways.NewClient(scope.SubscriptionID, scope.ResourceManagerEndpoint, scope.Authorizer),
 	}
 }
@@ -18,26 +18,35 @@ package subnets
 
 import (
 	"context"
-	"fmt"
+	"net"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec input specification for Get/CreateOrUpdate/Delete calls
 type Spec struct {
 	Name                string
-	CIDR                string
+	CIDRs               []string
 	VnetName            string
 	RouteTableName      string
 	SecurityGroupName   string
 	Role                infrav1.SubnetRole
 	InternalLBIPAddress string
+	NatGatewayName      string
+	ServiceEndpoints    []string
+	// Delegations are the Azure services the subnet is delegated to, such as
+	// Microsoft.ContainerInstance/containerGroups.
+	Delegations []string
+	// PrivateLinkService disables the private link service network policies of the subnet, which a private link
+	// service requires for its NAT IP configuration. They are only disabled when the subnet is created.
+	PrivateLinkService bool
 }
 
 // Get provides information about a subnet.
@@ -50,22 +59,64 @@ func (s *Service) Get(ctx context.Context, spec interface{}) (*infrav1.SubnetSpe
 	if err != nil {
 		return nil, err
 	}
+	var cidrs []string
 	var sg infrav1.SecurityGroup
-	if subnet.SubnetPropertiesFormat != nil && subnet.SubnetPropertiesFormat.NetworkSecurityGroup != nil {
-		sg = infrav1.SecurityGroup{
-			Name: to.String(subnet.SubnetPropertiesFormat.NetworkSecurityGroup.Name),
-			ID:   to.String(subnet.SubnetPropertiesFormat.NetworkSecurityGroup.ID),
-			Tags: converters.MapToTags(subnet.SubnetPropertiesFormat.NetworkSecurityGroup.Tags),
+	var rt infrav1.RouteTable
+	var natGateway *infrav1.NatGateway
+	var serviceEndpoints []string
+	var delegations []string
+	if subnet.SubnetPropertiesFormat != nil {
+		// dual-stack subnets have their address prefixes in AddressPrefixes instead of AddressPrefix.
+		cidrs = to.StringSlice(subnet.SubnetPropertiesFormat.AddressPrefixes)
+		if len(cidrs) == 0 && subnet.SubnetPropertiesFormat.AddressPrefix != nil {
+			cidrs = []string{*subnet.SubnetPropertiesFormat.AddressPrefix}
+		}
+		// Azure only returns the ID of the security group and the route table of a subnet.
+		if nsg := subnet.SubnetPropertiesFormat.NetworkSecurityGroup; nsg != nil {
+			sg = infrav1.SecurityGroup{
+				Name: resourceName(to.String(nsg.Name), to.String(nsg.ID)),
+				ID:   to.String(nsg.ID),
+				Tags: converters.MapToTags(nsg.Tags),
+			}
+		}
+		if routeTable := subnet.SubnetPropertiesFormat.RouteTable; routeTable != nil {
+			rt = infrav1.RouteTable{
+				Name: resourceName(to.String(routeTable.Name), to.String(routeTable.ID)),
+				ID:   to.String(routeTable.ID),
+			}
+		}
+		if subnet.SubnetPropertiesFormat.NatGateway != nil {
+			natGateway = &infrav1.NatGateway{
+				ID: to.String(subnet.SubnetPropertiesFormat.NatGateway.ID),
+			}
+		}
+		if subnet.SubnetPropertiesFormat.ServiceEndpoints != nil {
+			for _, endpoint := range *subnet.SubnetPropertiesFormat.ServiceEndpoints {
+				serviceEndpoints = append(serviceEndpoints, to.String(endpoint.Service))
+			}
+		}
+		if subnet.SubnetPropertiesFormat.Delegations != nil {
+			for _, delegation := range *subnet.SubnetPropertiesFormat.Delegations {
+				if delegation.ServiceDelegationPropertiesFormat != nil {
+					delegations = append(delegations, to.String(delegation.ServiceName))
+				}
+			}
 		}
 	}
-	return &infrav1.SubnetSpec{
+	subnetStatus := &infrav1.SubnetSpec{
 		Role:                subnetSpec.Role,
 		InternalLBIPAddress: subnetSpec.InternalLBIPAddress,
 		Name:                to.String(subnet.Name),
 		ID:                  to.String(subnet.ID),
-		CidrBlock:           to.String(subnet.SubnetPropertiesFormat.AddressPrefix),
+		CIDRBlocks:          cidrs,
 		SecurityGroup:       sg,
-	}, nil
+		RouteTable:          rt,
+		NatGateway:          natGateway,
+		ServiceEndpoints:    serviceEndpoints,
+		Delegations:         delegations,
+	}
+	subnetStatus.CidrBlock = subnetStatus.IPv4CIDR()
+	return subnetStatus, nil
 }
 
 // Reconcile gets/creates/updates a subnet.
@@ -74,64 +125,303 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid Subnet Specification")
 	}
-	if subnet, err := s.Get(ctx, subnetSpec); err == nil {
+	// the vnet of the cluster can be in another resource group.
+	log := s.Scope.WithValues("resourceGroup", s.Scope.Vnet().ResourceGroup, "vnet", subnetSpec.VnetName, "name", subnetSpec.Name)
+	log.V(4).Info("reconciling subnet")
+	if err := s.validateCIDR(subnetSpec); err != nil {
+		return err
+	}
+	if subnetSpec.Name == azure.GatewaySubnetName {
+		return s.reconcileGatewaySubnet(ctx, subnetSpec)
+	}
+	subnet, err := s.Get(ctx, subnetSpec)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to get subnet %s in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
+	}
+	if err == nil {
 		// TODO: add validation on existing subnet
-		// subnet already exists, skip creation
-		if subnetSpec.Role == infrav1.SubnetControlPlane {
-			subnet.DeepCopyInto(s.Scope.ControlPlaneSubnet())
-		} else if subnetSpec.Role == infrav1.SubnetNode {
-			subnet.DeepCopyInto(s.Scope.NodeSubnet())
+		// subnet already exists, skip creation unless it is missing its NAT gateway or service endpoints
+		natGatewayMissing := subnetSpec.NatGatewayName != "" && subnet.NatGateway == nil
+		serviceEndpointsMissing := !containsAll(subnet.ServiceEndpoints, subnetSpec.ServiceEndpoints)
+		delegationsMissing := !containsAll(subnet.Delegations, subnetSpec.Delegations)
+		// the subnet is associated with another security group or route table when its references change, such as
+		// when the subnets start sharing a security group.
+		securityGroupChanged := subnetSpec.SecurityGroupName != "" &&
+			!strings.EqualFold(subnet.SecurityGroup.Name, subnetSpec.SecurityGroupName)
+		routeTableChanged := subnetSpec.RouteTableName != "" &&
+			!strings.EqualFold(subnet.RouteTable.Name, subnetSpec.RouteTableName)
+		// the security rules, NAT gateway name, service endpoints and delegations are user provided and not part of
+		// the subnet, so they are kept as is. So are the security group and route table references of a subnet of a
+		// managed vnet, which the subnet is about to be associated with.
+		if existing := s.Scope.Subnet(subnetSpec.Name); existing != nil {
+			if s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+				if subnetSpec.SecurityGroupName != "" {
+					subnet.SecurityGroup.Name = subnetSpec.SecurityGroupName
+				}
+				if subnetSpec.RouteTableName != "" {
+					subnet.RouteTable.Name = subnetSpec.RouteTableName
+				}
+			}
+			subnet.SecurityGroup.SecurityRules = existing.SecurityGroup.SecurityRules
+			subnet.ServiceEndpoints = existing.ServiceEndpoints
+			subnet.Delegations = existing.Delegations
+			if existing.NatGateway != nil {
+				natGateway := existing.NatGateway.DeepCopy()
+				if subnet.NatGateway != nil {
+					natGateway.ID = subnet.NatGateway.ID
+				}
+				subnet.NatGateway = natGateway
+			}
+			subnet.DeepCopyInto(existing)
+		}
+		if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+			log.V(4).Info("using existing subnet of custom vnet")
+			s.setSubnetStatus(subnet)
+			return nil
+		}
+		if !natGatewayMissing && !serviceEndpointsMissing && !delegationsMissing && !securityGroupChanged && !routeTableChanged {
+			log.V(4).Info("subnet already exists")
+			return nil
+		}
+		if natGatewayMissing {
+			log.V(2).Info("associating NAT gateway with existing subnet", "natGateway", subnetSpec.NatGatewayName)
+		}
+		if serviceEndpointsMissing {
+			log.V(2).Info("adding service endpoints to existing subnet", "serviceEndpoints", subnetSpec.ServiceEndpoints)
+		}
+		if delegationsMissing {
+			log.V(2).Info("delegating existing subnet", "delegations", subnetSpec.Delegations)
+		}
+		if securityGroupChanged {
+			log.V(2).Info("associating security group with existing subnet", "securityGroup", subnetSpec.SecurityGroupName)
+		}
+		if routeTableChanged {
+			log.V(2).Info("associating route table with existing subnet", "routeTable", subnetSpec.RouteTableName)
 		}
-		return nil
 	}
 	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
 		// if vnet is unmanaged, we expect all subnets to be created as well
-		return fmt.Errorf("vnet was provided but subnet %s is missing", subnetSpec.Name)
+		return errors.Errorf("subnet %s does not exist in custom vnet %s of resource group %s, it has to be created before the cluster",
+			subnetSpec.Name, subnetSpec.VnetName, s.Scope.Vnet().ResourceGroup)
 	}
 
-	subnetProperties := network.SubnetPropertiesFormat{
-		AddressPrefix: to.StringPtr(subnetSpec.CIDR),
+	subnetProperties := network.SubnetPropertiesFormat{}
+	if len(subnetSpec.CIDRs) == 1 {
+		subnetProperties.AddressPrefix = to.StringPtr(subnetSpec.CIDRs[0])
+	} else {
+		subnetProperties.AddressPrefixes = &subnetSpec.CIDRs
 	}
 	if subnetSpec.RouteTableName != "" {
-		klog.V(2).Infof("getting route table %s", subnetSpec.RouteTableName)
+		log.V(4).Info("getting route table", "routeTable", subnetSpec.RouteTableName)
 		rt, err := s.RouteTablesClient.Get(ctx, s.Scope.ResourceGroup(), subnetSpec.RouteTableName)
+		if azure.ResourceNotFound(err) {
+			return azure.NewTerminalError(errors.Errorf("route table %s of subnet %s does not exist in resource group %s",
+				subnetSpec.RouteTableName, subnetSpec.Name, s.Scope.ResourceGroup()))
+		}
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "failed to get route table %s", subnetSpec.RouteTableName)
 		}
-		klog.V(2).Infof("successfully got route table %s", subnetSpec.RouteTableName)
+		log.V(4).Info("successfully got route table", "routeTable", subnetSpec.RouteTableName)
 		subnetProperties.RouteTable = &rt
 	}
 
-	klog.V(2).Infof("getting nsg %s", subnetSpec.SecurityGroupName)
+	if subnetSpec.NatGatewayName != "" {
+		log.V(4).Info("getting NAT gateway", "natGateway", subnetSpec.NatGatewayName)
+		natGateway, err := s.NatGatewaysClient.Get(ctx, s.Scope.ResourceGroup(), subnetSpec.NatGatewayName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get NAT gateway %s", subnetSpec.NatGatewayName)
+		}
+		log.V(4).Info("successfully got NAT gateway", "natGateway", subnetSpec.NatGatewayName)
+		subnetProperties.NatGateway = &network.SubResource{ID: natGateway.ID}
+	}
+
+	if len(subnetSpec.ServiceEndpoints) > 0 {
+		serviceEndpoints := make([]network.ServiceEndpointPropertiesFormat, 0, len(subnetSpec.ServiceEndpoints))
+		for _, service := range subnetSpec.ServiceEndpoints {
+			serviceEndpoints = append(serviceEndpoints, network.ServiceEndpointPropertiesFormat{Service: to.StringPtr(service)})
+		}
+		subnetProperties.ServiceEndpoints = &serviceEndpoints
+	}
+
+	if len(subnetSpec.Delegations) > 0 {
+		delegations := make([]network.Delegation, 0, len(subnetSpec.Delegations))
+		for _, service := range subnetSpec.Delegations {
+			delegations = append(delegations, network.Delegation{
+				// the name of a delegation only has to be unique within the subnet.
+				Name:                              to.StringPtr(strings.ReplaceAll(service, "/", ".")),
+				ServiceDelegationPropertiesFormat: &network.ServiceDelegationPropertiesFormat{ServiceName: to.StringPtr(service)},
+			})
+		}
+		subnetProperties.Delegations = &delegations
+	}
+
+	if subnetSpec.PrivateLinkService {
+		subnetProperties.PrivateLinkServiceNetworkPolicies = to.StringPtr("Disabled")
+	}
+
+	log.V(4).Info("getting nsg", "securityGroup", subnetSpec.SecurityGroupName)
 	nsg, err := s.SecurityGroupsClient.Get(ctx, s.Scope.ResourceGroup(), subnetSpec.SecurityGroupName)
+	if azure.ResourceNotFound(err) {
+		return azure.NewTerminalError(errors.Errorf("security group %s of subnet %s does not exist in resource group %s",
+			subnetSpec.SecurityGroupName, subnetSpec.Name, s.Scope.ResourceGroup()))
+	}
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "failed to get security group %s", subnetSpec.SecurityGroupName)
 	}
-	klog.V(2).Infof("got nsg %s", subnetSpec.SecurityGroupName)
+	log.V(4).Info("successfully got nsg", "securityGroup", subnetSpec.SecurityGroupName)
 	subnetProperties.NetworkSecurityGroup = &nsg
 
-	klog.V(2).Infof("creating subnet %s in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
+	desired := network.Subnet{
+		Name:                   to.StringPtr(subnetSpec.Name),
+		SubnetPropertiesFormat: &subnetProperties,
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("subnet", s.Scope.Vnet().ResourceGroup, subnetSpec.Name, desired)) {
+		return nil
+	}
+
+	log.V(2).Info("creating subnet")
 	err = s.Client.CreateOrUpdate(
 		ctx,
 		s.Scope.Vnet().ResourceGroup,
 		subnetSpec.VnetName,
 		subnetSpec.Name,
-		network.Subnet{
-			Name:                   to.StringPtr(subnetSpec.Name),
-			SubnetPropertiesFormat: &subnetProperties,
-		},
+		desired,
 	)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create subnet %s in resource group %s", subnetSpec.Name, s.Scope.Vnet().ResourceGroup)
 	}
 
-	klog.V(2).Infof("successfully created subnet %s in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
+	log.V(2).Info("successfully created subnet")
 	return nil
 }
 
-// Delete deletes the subnet with the provided name.
-func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+// reconcileGatewaySubnet creates the GatewaySubnet of the VPN gateway in a managed vnet. Azure does not allow a
+// security group, a route table or a NAT gateway on it, it only has its address prefix. The GatewaySubnet is not one
+// of the cluster subnets, so it is not recorded in the network status.
+func (s *Service) reconcileGatewaySubnet(ctx context.Context, subnetSpec *Spec) error {
+	log := s.Scope.WithValues("resourceGroup", s.Scope.Vnet().ResourceGroup, "vnet", subnetSpec.VnetName, "name", subnetSpec.Name)
+	_, err := s.Client.Get(ctx, s.Scope.Vnet().ResourceGroup, subnetSpec.VnetName, subnetSpec.Name)
+	if err == nil {
+		log.V(4).Info("gateway subnet already exists")
+		return nil
+	}
+	if !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to get subnet %s in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
+	}
 	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		return errors.Errorf("subnet %s does not exist in custom vnet %s of resource group %s, it has to be created before the VPN gateway",
+			subnetSpec.Name, subnetSpec.VnetName, s.Scope.Vnet().ResourceGroup)
+	}
+	if len(subnetSpec.CIDRs) != 1 {
+		return errors.Errorf("subnet %s needs a single CIDR block to be created in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
+	}
+
+	desired := network.Subnet{
+		Name: to.StringPtr(subnetSpec.Name),
+		SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+			AddressPrefix: to.StringPtr(subnetSpec.CIDRs[0]),
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("subnet", s.Scope.Vnet().ResourceGroup, subnetSpec.Name, desired)) {
+		return nil
+	}
+
+	log.V(2).Info("creating gateway subnet")
+	if err := s.Client.CreateOrUpdate(ctx, s.Scope.Vnet().ResourceGroup, subnetSpec.VnetName, subnetSpec.Name, desired); err != nil {
+		return errors.Wrapf(err, "failed to create subnet %s in resource group %s", subnetSpec.Name, s.Scope.Vnet().ResourceGroup)
+	}
+	log.V(2).Info("successfully created gateway subnet")
+	return nil
+}
+
+// containsAll returns true if the existing service endpoints or delegations of a subnet include all the desired ones.
+func containsAll(existing, desired []string) bool {
+	for _, service := range desired {
+		found := false
+		for _, e := range existing {
+			if e == service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// resourceName returns the name of a resource referenced by a subnet, the last segment of its ID when the name is
+// unknown.
+func resourceName(name, id string) string {
+	if name != "" {
+		return name
+	}
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+// setSubnetStatus records the existing subnet in the cluster network status, replacing any previous record of it.
+func (s *Service) setSubnetStatus(subnet *infrav1.SubnetSpec) {
+	status := s.Scope.Network()
+	for i, sn := range status.Subnets {
+		if sn.Name == subnet.Name {
+			status.Subnets[i] = subnet
+			return
+		}
+	}
+	status.Subnets = append(status.Subnets, subnet)
+}
+
+// validateCIDR checks that the subnet CIDR blocks are valid and, for managed vnets, that they are within the vnet
+// CIDR blocks.
+func (s *Service) validateCIDR(subnetSpec *Spec) error {
+	subnetNets := make([]*net.IPNet, 0, len(subnetSpec.CIDRs))
+	for _, cidr := range subnetSpec.CIDRs {
+		_, subnetNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "invalid CidrBlock %q for subnet %s", cidr, subnetSpec.Name)
+		}
+		subnetNets = append(subnetNets, subnetNet)
+	}
+
+	// custom vnets and their subnets already exist, their ranges are not ours to check.
+	vnetCIDRs := s.Scope.Vnet().CIDRs()
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) || len(vnetCIDRs) == 0 {
+		return nil
+	}
+	vnetNets := make([]*net.IPNet, 0, len(vnetCIDRs))
+	for _, cidr := range vnetCIDRs {
+		_, vnetNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "invalid CidrBlock %q for vnet %s", cidr, s.Scope.Vnet().Name)
+		}
+		vnetNets = append(vnetNets, vnetNet)
+	}
+	for i, subnetNet := range subnetNets {
+		if !withinAny(vnetNets, subnetNet) {
+			return errors.Errorf("CidrBlock %s of subnet %s is not within CidrBlock %s of vnet %s",
+				subnetSpec.CIDRs[i], subnetSpec.Name, strings.Join(vnetCIDRs, ", "), s.Scope.Vnet().Name)
+		}
+	}
+	return nil
+}
+
+// withinAny returns true if the subnet range is within any of the vnet ranges of the same IP family.
+func withinAny(vnetNets []*net.IPNet, subnetNet *net.IPNet) bool {
+	subnetOnes, subnetBits := subnetNet.Mask.Size()
+	for _, vnetNet := range vnetNets {
+		vnetOnes, vnetBits := vnetNet.Mask.Size()
+		if vnetBits == subnetBits && vnetNet.Contains(subnetNet.IP) && subnetOnes >= vnetOnes {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete deletes the subnet with the provided name, after dissociating its security group and route table. A subnet
+// which network interfaces still use is not deleted, a ResourceInUseError is returned instead.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	if !s.Scope.IsVnetManaged() {
 		s.Scope.V(4).Info("Skipping subnets deletion in custom vnet mode")
 		return nil
 	}
@@ -139,16 +429,57 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid Subnet Specification")
 	}
-	klog.V(2).Infof("deleting subnet %s in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
-	err := s.Client.Delete(ctx, s.Scope.Vnet().ResourceGroup, subnetSpec.VnetName, subnetSpec.Name)
+	log := s.Scope.WithValues("resourceGroup", s.Scope.Vnet().ResourceGroup, "vnet", subnetSpec.VnetName, "name", subnetSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("subnet", s.Scope.Vnet().ResourceGroup, subnetSpec.Name)) {
+		return nil
+	}
+
+	subnet, err := s.Client.Get(ctx, s.Scope.Vnet().ResourceGroup, subnetSpec.VnetName, subnetSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("subnet is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get subnet %s in resource group %s", subnetSpec.Name, s.Scope.Vnet().ResourceGroup)
+	}
+	if subnet.SubnetPropertiesFormat == nil {
+		subnet.SubnetPropertiesFormat = &network.SubnetPropertiesFormat{}
+	}
+
+	// the network interfaces of the machines are deleted with them, the subnet is deleted once they are gone.
+	if subnet.IPConfigurations != nil && len(*subnet.IPConfigurations) > 0 {
+		dependents := make([]string, 0, len(*subnet.IPConfigurations))
+		for _, ipConfig := range *subnet.IPConfigurations {
+			dependents = append(dependents, to.String(ipConfig.ID))
+		}
+		return azure.NewResourceInUseError("subnet", subnetSpec.Name, dependents...)
+	}
+
+	// the security group and the route table are dissociated first, so that they can be deleted even if the
+	// deletion of the subnet fails.
+	if subnet.NetworkSecurityGroup != nil || subnet.RouteTable != nil {
+		log.V(2).Info("dissociating security group and route table from subnet")
+		subnet.NetworkSecurityGroup = nil
+		subnet.RouteTable = nil
+		err = s.Client.CreateOrUpdate(ctx, s.Scope.Vnet().ResourceGroup, subnetSpec.VnetName, subnetSpec.Name, subnet)
+		if err != nil && !azure.ResourceNotFound(err) {
+			return errors.Wrapf(err, "failed to dissociate security group and route table from subnet %s in resource group %s",
+				subnetSpec.Name, s.Scope.Vnet().ResourceGroup)
+		}
+	}
+
+	log.V(2).Info("deleting subnet")
+	err = s.Client.Delete(ctx, s.Scope.Vnet().ResourceGroup, subnetSpec.VnetName, subnetSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("subnet is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete subnet %s in resource group %s", subnetSpec.Name, s.Scope.Vnet().ResourceGroup)
 	}
 
-	klog.V(2).Infof("successfully deleted subnet %s in vnet %s", subnetSpec.Name, subnetSpec.VnetName)
+	log.V(2).Info("successfully deleted subnet")
 	return nil
 }
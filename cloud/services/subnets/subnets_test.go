@@ -19,6 +19,7 @@ package subnets
 import (
 	"context"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
@@ -37,18 +38,19 @@ import (
 
 func TestReconcileSubnets(t *testing.T) {
 	testcases := []struct {
-		name          string
-		subnetSpec    Spec
-		vnetSpec      *infrav1.VnetSpec
-		subnets       []*infrav1.SubnetSpec
-		expectedError string
-		expect        func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder)
+		name           string
+		subnetSpec     Spec
+		vnetSpec       *infrav1.VnetSpec
+		subnets        []*infrav1.SubnetSpec
+		expectedError  string
+		expectedStatus infrav1.Subnets
+		expect         func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder)
 	}{
 		{
 			name: "subnet does not exist",
 			subnetSpec: Spec{
 				Name:                "my-subnet",
-				CIDR:                "10.0.0.0/16",
+				CIDRs:               []string{"10.0.0.0/16"},
 				VnetName:            "my-vnet",
 				RouteTableName:      "my-subent_route_table",
 				SecurityGroupName:   "my-sg",
@@ -71,11 +73,239 @@ func TestReconcileSubnets(t *testing.T) {
 				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", gomock.AssignableToTypeOf(network.Subnet{}))
 			},
 		},
+		{
+			name: "subnet does not exist with service endpoints",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+				ServiceEndpoints:  []string{"Microsoft.Storage", "Microsoft.Sql"},
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets:  []*infrav1.SubnetSpec{},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m1.Get(context.TODO(), "my-rg", "my-subent_route_table").
+					Return(network.RouteTable{}, nil)
+				m2.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, nil)
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+						RouteTable:           &network.RouteTable{},
+						NetworkSecurityGroup: &network.SecurityGroup{},
+						ServiceEndpoints: &[]network.ServiceEndpointPropertiesFormat{
+							{Service: to.StringPtr("Microsoft.Storage")},
+							{Service: to.StringPtr("Microsoft.Sql")},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "subnet does not exist with delegations",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+				Delegations:       []string{"Microsoft.ContainerInstance/containerGroups", "Microsoft.Netapp/volumes"},
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets:  []*infrav1.SubnetSpec{},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m1.Get(context.TODO(), "my-rg", "my-subent_route_table").
+					Return(network.RouteTable{}, nil)
+				m2.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, nil)
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+						RouteTable:           &network.RouteTable{},
+						NetworkSecurityGroup: &network.SecurityGroup{},
+						Delegations: &[]network.Delegation{
+							{
+								Name: to.StringPtr("Microsoft.ContainerInstance.containerGroups"),
+								ServiceDelegationPropertiesFormat: &network.ServiceDelegationPropertiesFormat{
+									ServiceName: to.StringPtr("Microsoft.ContainerInstance/containerGroups"),
+								},
+							},
+							{
+								Name: to.StringPtr("Microsoft.Netapp.volumes"),
+								ServiceDelegationPropertiesFormat: &network.ServiceDelegationPropertiesFormat{
+									ServiceName: to.StringPtr("Microsoft.Netapp/volumes"),
+								},
+							},
+						},
+					},
+				})
+			},
+		},
+		{
+			name: "existing subnet is already delegated",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+				Delegations:       []string{"Microsoft.ContainerInstance/containerGroups"},
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets:  []*infrav1.SubnetSpec{},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+							NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/networkSecurityGroups/my-sg")},
+							RouteTable:           &network.RouteTable{ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/routeTables/my-subent_route_table")},
+							Delegations: &[]network.Delegation{
+								{
+									Name: to.StringPtr("aci"),
+									ServiceDelegationPropertiesFormat: &network.ServiceDelegationPropertiesFormat{
+										ServiceName: to.StringPtr("Microsoft.ContainerInstance/containerGroups"),
+									},
+								},
+							},
+						},
+					}, nil)
+			},
+		},
+		{
+			name: "dual-stack subnet does not exist",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16", "fd00::/64"},
+				VnetName:          "my-vnet",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet", CIDRBlocks: []string{"10.0.0.0/8", "fd00::/48"}},
+			subnets:  []*infrav1.SubnetSpec{},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m2.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, nil)
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefixes:      &[]string{"10.0.0.0/16", "fd00::/64"},
+						NetworkSecurityGroup: &network.SecurityGroup{},
+					},
+				})
+			},
+		},
+		{
+			name: "dual-stack subnet IPv6 CIDR block is not within the vnet CIDR blocks",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16", "fd01::/64"},
+				VnetName:          "my-vnet",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{Name: "my-vnet", CIDRBlocks: []string{"10.0.0.0/8", "fd00::/48"}},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "CidrBlock fd01::/64 of subnet my-subnet is not within CidrBlock 10.0.0.0/8, fd00::/48 of vnet my-vnet",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+			},
+		},
+		{
+			name: "dual-stack subnets of a custom vnet are recorded with both CIDR blocks",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.1.0/24", "fd00::/64"},
+				VnetName:          "custom-vnet",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec: &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", ID: "id1"},
+			subnets:  []*infrav1.SubnetSpec{},
+			expectedStatus: infrav1.Subnets{{
+				Name:       "my-subnet",
+				ID:         "subnet-id",
+				Role:       infrav1.SubnetNode,
+				CidrBlock:  "10.0.1.0/24",
+				CIDRBlocks: []string{"fd00::/64", "10.0.1.0/24"},
+			}},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "custom-vnet-rg", "custom-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefixes: &[]string{"fd00::/64", "10.0.1.0/24"},
+						},
+					}, nil)
+			},
+		},
+		{
+			name: "existing subnet is missing a service endpoint",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+				ServiceEndpoints:  []string{"Microsoft.Storage", "Microsoft.Sql"},
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets: []*infrav1.SubnetSpec{{
+				Name:             "my-subnet",
+				Role:             infrav1.SubnetNode,
+				ServiceEndpoints: []string{"Microsoft.Storage", "Microsoft.Sql"},
+			}},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix: to.StringPtr("10.0.0.0/16"),
+							ServiceEndpoints: &[]network.ServiceEndpointPropertiesFormat{
+								{Service: to.StringPtr("Microsoft.Storage")},
+							},
+						},
+					}, nil)
+				m1.Get(context.TODO(), "my-rg", "my-subent_route_table").
+					Return(network.RouteTable{}, nil)
+				m2.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, nil)
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+						RouteTable:           &network.RouteTable{},
+						NetworkSecurityGroup: &network.SecurityGroup{},
+						ServiceEndpoints: &[]network.ServiceEndpointPropertiesFormat{
+							{Service: to.StringPtr("Microsoft.Storage")},
+							{Service: to.StringPtr("Microsoft.Sql")},
+						},
+					},
+				})
+			},
+		},
 		{
 			name: "vnet was provided but subnet is missing",
 			subnetSpec: Spec{
 				Name:                "my-subnet",
-				CIDR:                "10.0.0.0/16",
+				CIDRs:               []string{"10.0.0.0/16"},
 				VnetName:            "custom-vnet",
 				RouteTableName:      "my-subent_route_table",
 				SecurityGroupName:   "my-sg",
@@ -84,17 +314,70 @@ func TestReconcileSubnets(t *testing.T) {
 			},
 			vnetSpec:      &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", ID: "id1"},
 			subnets:       []*infrav1.SubnetSpec{},
-			expectedError: "vnet was provided but subnet my-subnet is missing",
+			expectedError: "subnet my-subnet does not exist in custom vnet custom-vnet of resource group custom-vnet-rg, it has to be created before the cluster",
 			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
 				m.Get(context.TODO(), "custom-vnet-rg", "custom-vnet", "my-subnet").
 					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
 			},
 		},
+		{
+			name: "gateway subnet does not exist",
+			subnetSpec: Spec{
+				Name:     "GatewaySubnet",
+				CIDRs:    []string{"10.255.255.0/27"},
+				VnetName: "my-vnet",
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet", CidrBlock: "10.0.0.0/8"},
+			subnets:  []*infrav1.SubnetSpec{},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "GatewaySubnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "GatewaySubnet", network.Subnet{
+					Name: to.StringPtr("GatewaySubnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix: to.StringPtr("10.255.255.0/27"),
+					},
+				})
+			},
+		},
+		{
+			name: "gateway subnet exists",
+			subnetSpec: Spec{
+				Name:     "GatewaySubnet",
+				CIDRs:    []string{"10.255.255.0/27"},
+				VnetName: "my-vnet",
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet", CidrBlock: "10.0.0.0/8"},
+			subnets:  []*infrav1.SubnetSpec{},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "GatewaySubnet").
+					Return(network.Subnet{
+						Name: to.StringPtr("GatewaySubnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix: to.StringPtr("10.255.255.0/27"),
+						},
+					}, nil)
+			},
+		},
+		{
+			name: "gateway subnet is missing in custom vnet",
+			subnetSpec: Spec{
+				Name:     "GatewaySubnet",
+				VnetName: "custom-vnet",
+			},
+			vnetSpec:      &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", ID: "id1"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "subnet GatewaySubnet does not exist in custom vnet custom-vnet of resource group custom-vnet-rg, it has to be created before the VPN gateway",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "custom-vnet-rg", "custom-vnet", "GatewaySubnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
 		{
 			name: "vnet was provided and subnet exists",
 			subnetSpec: Spec{
 				Name:                "my-subnet",
-				CIDR:                "10.0.0.0/16",
+				CIDRs:               []string{"10.0.0.0/16"},
 				VnetName:            "my-vnet",
 				RouteTableName:      "my-subent_route_table",
 				SecurityGroupName:   "my-sg",
@@ -126,11 +409,274 @@ func TestReconcileSubnets(t *testing.T) {
 					}, nil)
 			},
 		},
+		{
+			name: "existing subnet is associated with the security group it shares",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				SecurityGroupName: "my-cluster-nsg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets: []*infrav1.SubnetSpec{{
+				Name: "my-subnet",
+				Role: infrav1.SubnetNode,
+			}},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix: to.StringPtr("10.0.0.0/16"),
+							NetworkSecurityGroup: &network.SecurityGroup{
+								ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/networkSecurityGroups/my-cluster-node-nsg"),
+							},
+						},
+					}, nil)
+				m2.Get(context.TODO(), "my-rg", "my-cluster-nsg").
+					Return(network.SecurityGroup{ID: to.StringPtr("shared-sg-id")}, nil)
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+						NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr("shared-sg-id")},
+					},
+				})
+			},
+		},
+		{
+			name: "existing subnet is already associated with its security group by ID",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				SecurityGroupName: "my-cluster-nsg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets: []*infrav1.SubnetSpec{{
+				Name: "my-subnet",
+				Role: infrav1.SubnetNode,
+			}},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix: to.StringPtr("10.0.0.0/16"),
+							NetworkSecurityGroup: &network.SecurityGroup{
+								ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/networkSecurityGroups/my-cluster-nsg"),
+							},
+						},
+					}, nil)
+			},
+		},
+		{
+			name: "existing subnet is associated with the route table it references",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-custom-rt",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetControlPlane,
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets: []*infrav1.SubnetSpec{{
+				Name:          "my-subnet",
+				Role:          infrav1.SubnetControlPlane,
+				SecurityGroup: infrav1.SecurityGroup{Name: "my-sg"},
+				RouteTable:    infrav1.RouteTable{Name: "my-custom-rt"},
+			}},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+							NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/networkSecurityGroups/my-sg")},
+							RouteTable:           &network.RouteTable{ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/routeTables/my-cluster-node-routetable")},
+						},
+					}, nil)
+				m1.Get(context.TODO(), "my-rg", "my-custom-rt").
+					Return(network.RouteTable{ID: to.StringPtr("custom-rt-id")}, nil)
+				m2.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{ID: to.StringPtr("sg-id")}, nil)
+				m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+						RouteTable:           &network.RouteTable{ID: to.StringPtr("custom-rt-id")},
+						NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr("sg-id")},
+					},
+				})
+			},
+		},
+		{
+			name: "referenced route table does not exist",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-custom-rt",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "route table my-custom-rt of subnet my-subnet does not exist in resource group my-rg",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m1.Get(context.TODO(), "my-rg", "my-custom-rt").
+					Return(network.RouteTable{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name: "referenced security group does not exist",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "my-vnet",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{Name: "my-vnet"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "security group my-sg of subnet my-subnet does not exist in resource group my-rg",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m2.Get(context.TODO(), "my-rg", "my-sg").
+					Return(network.SecurityGroup{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name: "vnet was provided and subnet exists in custom vnet",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "custom-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec: &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", ID: "id1"},
+			subnets: []*infrav1.SubnetSpec{{
+				Name: "my-subnet",
+				Role: infrav1.SubnetNode,
+			}},
+			expectedStatus: infrav1.Subnets{{
+				Name:       "my-subnet",
+				ID:         "subnet-id",
+				Role:       infrav1.SubnetNode,
+				CidrBlock:  "10.0.1.0/24",
+				CIDRBlocks: []string{"10.0.1.0/24"},
+				SecurityGroup: infrav1.SecurityGroup{
+					ID:   "sg-id",
+					Name: "custom-sg",
+					Tags: infrav1.Tags{},
+				},
+				RouteTable: infrav1.RouteTable{
+					ID:   "rt-id",
+					Name: "custom-route-table",
+				},
+			}},
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "custom-vnet-rg", "custom-vnet", "my-subnet").
+					Return(network.Subnet{
+						ID:   to.StringPtr("subnet-id"),
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix: to.StringPtr("10.0.1.0/24"),
+							RouteTable: &network.RouteTable{
+								ID:   to.StringPtr("rt-id"),
+								Name: to.StringPtr("custom-route-table"),
+							},
+							NetworkSecurityGroup: &network.SecurityGroup{
+								ID:   to.StringPtr("sg-id"),
+								Name: to.StringPtr("custom-sg"),
+							},
+						},
+					}, nil)
+			},
+		},
+		{
+			name: "fail to get subnet",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/16"},
+				VnetName:          "custom-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", ID: "id1"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "failed to get subnet my-subnet in vnet custom-vnet: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+				m.Get(context.TODO(), "custom-vnet-rg", "custom-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+		{
+			name: "subnet CIDR block is not within the vnet CIDR block",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.1.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{Name: "my-vnet", CidrBlock: "10.0.0.0/16"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "CidrBlock 10.1.0.0/16 of subnet my-subnet is not within CidrBlock 10.0.0.0/16 of vnet my-vnet",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+			},
+		},
+		{
+			name: "subnet CIDR block is larger than the vnet CIDR block",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0.0/8"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{Name: "my-vnet", CidrBlock: "10.0.0.0/16"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: "CidrBlock 10.0.0.0/8 of subnet my-subnet is not within CidrBlock 10.0.0.0/16 of vnet my-vnet",
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+			},
+		},
+		{
+			name: "malformed subnet CIDR block",
+			subnetSpec: Spec{
+				Name:              "my-subnet",
+				CIDRs:             []string{"10.0.0/16"},
+				VnetName:          "my-vnet",
+				RouteTableName:    "my-subent_route_table",
+				SecurityGroupName: "my-sg",
+				Role:              infrav1.SubnetNode,
+			},
+			vnetSpec:      &infrav1.VnetSpec{Name: "my-vnet", CidrBlock: "10.0.0.0/16"},
+			subnets:       []*infrav1.SubnetSpec{},
+			expectedError: `invalid CidrBlock "10.0.0/16" for subnet my-subnet: invalid CIDR address: 10.0.0/16`,
+			expect: func(m *mock_subnets.MockClientMockRecorder, m1 *mock_routetables.MockClientMockRecorder, m2 *mock_securitygroups.MockClientMockRecorder) {
+			},
+		},
 	}
 
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			subnetMock := mock_subnets.NewMockClient(mockCtrl)
 			rtMock := mock_routetables.NewMockClient(mockCtrl)
 			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
@@ -152,7 +698,7 @@ func TestReconcileSubnets(t *testing.T) {
 				Cluster: cluster,
 				AzureCluster: &infrav1.AzureCluster{
 					Spec: infrav1.AzureClusterSpec{
-						Location: "test-location",
+						Location:      "test-location",
 						ResourceGroup: "my-rg",
 						NetworkSpec: infrav1.NetworkSpec{
 							Vnet:    *tc.vnetSpec,
@@ -172,10 +718,17 @@ func TestReconcileSubnets(t *testing.T) {
 				RouteTablesClient:    rtMock,
 			}
 
-			if err := s.Reconcile(context.TODO(), &tc.subnetSpec); err != nil {
-				if tc.expectedError != "" && err.Error() != tc.expectedError {
-					t.Fatalf("got an unexpected error: %v", err)
+			err = s.Reconcile(context.TODO(), &tc.subnetSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
 				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if tc.expectedStatus != nil && !reflect.DeepEqual(clusterScope.Network().Subnets, tc.expectedStatus) {
+				t.Errorf("expected subnets status %+v, got %+v", tc.expectedStatus, clusterScope.Network().Subnets)
 			}
 		})
 	}
@@ -183,16 +736,17 @@ func TestReconcileSubnets(t *testing.T) {
 
 func TestDeleteSubnets(t *testing.T) {
 	testcases := []struct {
-		name       string
-		subnetSpec Spec
-		vnetSpec   *infrav1.VnetSpec
-		expect     func(m *mock_subnets.MockClientMockRecorder)
+		name          string
+		subnetSpec    Spec
+		vnetSpec      *infrav1.VnetSpec
+		expect        func(m *mock_subnets.MockClientMockRecorder)
+		expectedError string
 	}{
 		{
 			name: "subnet exists",
 			subnetSpec: Spec{
 				Name:                "my-subnet",
-				CIDR:                "10.0.0.0/16",
+				CIDRs:               []string{"10.0.0.0/16"},
 				VnetName:            "my-vnet",
 				RouteTableName:      "my-subent_route_table",
 				SecurityGroupName:   "my-sg",
@@ -201,14 +755,60 @@ func TestDeleteSubnets(t *testing.T) {
 			},
 			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
 			expect: func(m *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").Return(network.Subnet{Name: to.StringPtr("my-subnet")}, nil)
 				m.Delete(context.TODO(), "", "my-vnet", "my-subnet")
 			},
 		},
+		{
+			name: "security group and route table are dissociated before the subnet is deleted",
+			subnetSpec: Spec{
+				Name:     "my-subnet",
+				VnetName: "my-vnet",
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			expect: func(m *mock_subnets.MockClientMockRecorder) {
+				gomock.InOrder(
+					m.Get(context.TODO(), "", "my-vnet", "my-subnet").Return(network.Subnet{
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix:        to.StringPtr("10.0.0.0/16"),
+							NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr("my-sg-id")},
+							RouteTable:           &network.RouteTable{ID: to.StringPtr("my-rt-id")},
+						},
+					}, nil),
+					m.CreateOrUpdate(context.TODO(), "", "my-vnet", "my-subnet", network.Subnet{
+						Name: to.StringPtr("my-subnet"),
+						SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+							AddressPrefix: to.StringPtr("10.0.0.0/16"),
+						},
+					}),
+					m.Delete(context.TODO(), "", "my-vnet", "my-subnet"),
+				)
+			},
+		},
+		{
+			name: "subnet in use by network interfaces is not deleted",
+			subnetSpec: Spec{
+				Name:     "my-subnet",
+				VnetName: "my-vnet",
+			},
+			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
+			expect: func(m *mock_subnets.MockClientMockRecorder) {
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").Return(network.Subnet{
+					Name: to.StringPtr("my-subnet"),
+					SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+						NetworkSecurityGroup: &network.SecurityGroup{ID: to.StringPtr("my-sg-id")},
+						IPConfigurations:     &[]network.IPConfiguration{{ID: to.StringPtr("my-nic-ipconfig")}},
+					},
+				}, nil)
+			},
+			expectedError: "subnet my-subnet is still in use by my-nic-ipconfig",
+		},
 		{
 			name: "subnet already deleted",
 			subnetSpec: Spec{
 				Name:                "my-subnet",
-				CIDR:                "10.0.0.0/16",
+				CIDRs:               []string{"10.0.0.0/16"},
 				VnetName:            "my-vnet",
 				RouteTableName:      "my-subent_route_table",
 				SecurityGroupName:   "my-sg",
@@ -217,15 +817,15 @@ func TestDeleteSubnets(t *testing.T) {
 			},
 			vnetSpec: &infrav1.VnetSpec{Name: "my-vnet"},
 			expect: func(m *mock_subnets.MockClientMockRecorder) {
-				m.Delete(context.TODO(), "", "my-vnet", "my-subnet").
-					Return(autorest.NewErrorWithResponse("", "my-vnet", &http.Response{StatusCode: 404}, "Not found"))
+				m.Get(context.TODO(), "", "my-vnet", "my-subnet").
+					Return(network.Subnet{}, autorest.NewErrorWithResponse("", "my-vnet", &http.Response{StatusCode: 404}, "Not found"))
 			},
 		},
 		{
 			name: "skip delete if vnet is managed",
 			subnetSpec: Spec{
 				Name:                "my-subnet",
-				CIDR:                "10.0.0.0/16",
+				CIDRs:               []string{"10.0.0.0/16"},
 				VnetName:            "custom-vnet",
 				RouteTableName:      "my-subent_route_table",
 				SecurityGroupName:   "my-sg",
@@ -239,6 +839,7 @@ func TestDeleteSubnets(t *testing.T) {
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			subnetMock := mock_subnets.NewMockClient(mockCtrl)
 
 			cluster := &clusterv1.Cluster{
@@ -258,7 +859,7 @@ func TestDeleteSubnets(t *testing.T) {
 				Cluster: cluster,
 				AzureCluster: &infrav1.AzureCluster{
 					Spec: infrav1.AzureClusterSpec{
-						Location: "test-location",
+						Location:      "test-location",
 						ResourceGroup: "my-rg",
 						NetworkSpec: infrav1.NetworkSpec{
 							Vnet: *tc.vnetSpec,
@@ -275,7 +876,12 @@ func TestDeleteSubnets(t *testing.T) {
 				Client: subnetMock,
 			}
 
-			if err := s.Delete(context.TODO(), &tc.subnetSpec); err != nil {
+			err = s.Delete(context.TODO(), &tc.subnetSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
 				t.Fatalf("got an unexpected error: %v", err)
 			}
 		})
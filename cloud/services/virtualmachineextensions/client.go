@@ -19,7 +19,7 @@ package virtualmachineextensions
 import (
 	"context"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 )
@@ -38,15 +38,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newVirtualMachineExtensionsClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newVirtualMachineExtensionsClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newVirtualMachineExtensionsClient creates a new VM extension client from subscription ID.
-func newVirtualMachineExtensionsClient(subscriptionID string, authorizer autorest.Authorizer) compute.VirtualMachineExtensionsClient {
-	vmExtClient := compute.NewVirtualMachineExtensionsClient(subscriptionID)
+// newVirtualMachineExtensionsClient creates a new VM extension client from subscription ID and base URI.
+func newVirtualMachineExtensionsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) compute.VirtualMachineExtensionsClient {
+	vmExtClient := compute.NewVirtualMachineExtensionsClientWithBaseURI(baseURI, subscriptionID)
 	vmExtClient.Authorizer = authorizer
 	vmExtClient.AddToUserAgent(azure.UserAgent)
 	return vmExtClient
@@ -54,33 +54,43 @@ func newVirtualMachineExtensionsClient(subscriptionID string, authorizer autores
 
 // Get the operation to get the extension.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, vmName, extName string) (compute.VirtualMachineExtension, error) {
-	return ac.vmextensions.Get(ctx, resourceGroupName, vmName, extName, "")
+	var result compute.VirtualMachineExtension
+	err := azure.CallAPI(ctx, "virtualmachineextensions", "Get", func() error {
+		var err error
+		result, err = ac.vmextensions.Get(ctx, resourceGroupName, vmName, extName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate the operation to create or update the extension.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, vmName, extName string, ext compute.VirtualMachineExtension) error {
-	future, err := ac.vmextensions.CreateOrUpdate(ctx, resourceGroupName, vmName, extName, ext)
-	if err != nil {
+	var future compute.VirtualMachineExtensionsCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "virtualmachineextensions", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.vmextensions.CreateOrUpdate(ctx, resourceGroupName, vmName, extName, ext)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.vmextensions.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.vmextensions.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.vmextensions)
 		return err
-	}
-	_, err = future.Result(ac.vmextensions)
-	return err
+	})
 }
 
 // Delete the operation to delete the extension.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, vmName, extName string) error {
-	future, err := ac.vmextensions.Delete(ctx, resourceGroupName, vmName, extName)
-	if err != nil {
+	var future compute.VirtualMachineExtensionsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "virtualmachineextensions", "Delete", func() error {
+		var err error
+		future, err = ac.vmextensions.Delete(ctx, resourceGroupName, vmName, extName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.vmextensions.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.vmextensions.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.vmextensions)
 		return err
-	}
-	_, err = future.Result(ac.vmextensions)
-	return err
+	})
 }
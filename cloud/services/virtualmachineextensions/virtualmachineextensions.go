@@ -19,25 +19,33 @@ package virtualmachineextensions
 import (
 	"context"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec input specification for Get/CreateOrUpdate/Delete calls
 type Spec struct {
-	Name       string
-	VMName     string
-	ScriptData string
+	Name      string
+	VMName    string
+	Publisher string
+	Type      string
+	// Version is the major and minor version of the extension handler, newer minor versions are installed
+	// automatically.
+	Version  string
+	Settings map[string]string
+	// ProtectedSettings are encrypted and only readable on the virtual machine. Azure does not return them, so
+	// changing only the protected settings of an existing extension does not update it.
+	ProtectedSettings map[string]string
 }
 
 // Get provides information about a virtual machine extension.
 func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
 	vmExtSpec, ok := spec.(*Spec)
 	if !ok {
-		return compute.VirtualMachineExtension{}, errors.New("invalid vm specification")
+		return compute.VirtualMachineExtension{}, errors.New("invalid vm extension specification")
 	}
 	vmExt, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), vmExtSpec.VMName, vmExtSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
@@ -48,61 +56,106 @@ func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error
 	return vmExt, nil
 }
 
-// Reconcile gets/creates/updates a virtual machine extension.
+// Reconcile creates a virtual machine extension, or updates it if it differs from the spec or failed to provision.
 func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	vmExtSpec, ok := spec.(*Spec)
 	if !ok {
-		return errors.New("invalid vm specification")
+		return errors.New("invalid vm extension specification")
 	}
+	log := s.Scope.ResourceLogger(vmExtSpec.Name).WithValues("vm", vmExtSpec.VMName)
+	log.V(4).Info("reconciling vm extension")
 
-	klog.V(2).Infof("creating vm extension %s ", vmExtSpec.Name)
+	existing, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), vmExtSpec.VMName, vmExtSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get vm extension %s of vm %s", vmExtSpec.Name, vmExtSpec.VMName)
+	case err == nil && isUpToDate(existing, vmExtSpec):
+		log.V(4).Info("vm extension is up to date")
+		return nil
+	}
+
+	log.V(2).Info("creating vm extension")
+	properties := &compute.VirtualMachineExtensionProperties{
+		Publisher:               to.StringPtr(vmExtSpec.Publisher),
+		Type:                    to.StringPtr(vmExtSpec.Type),
+		TypeHandlerVersion:      to.StringPtr(vmExtSpec.Version),
+		AutoUpgradeMinorVersion: to.BoolPtr(true),
+	}
+	if len(vmExtSpec.Settings) > 0 {
+		properties.Settings = vmExtSpec.Settings
+	}
+	if len(vmExtSpec.ProtectedSettings) > 0 {
+		properties.ProtectedSettings = vmExtSpec.ProtectedSettings
+	}
+	extension := compute.VirtualMachineExtension{
+		Name:                              to.StringPtr(vmExtSpec.Name),
+		Location:                          to.StringPtr(s.Scope.Location()),
+		VirtualMachineExtensionProperties: properties,
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("vm extension", s.Scope.ResourceGroup(), vmExtSpec.Name, extension)) {
+		return nil
+	}
 
-	err := s.Client.CreateOrUpdate(
+	err = s.Client.CreateOrUpdate(
 		ctx,
 		s.Scope.ResourceGroup(),
 		vmExtSpec.VMName,
 		vmExtSpec.Name,
-		compute.VirtualMachineExtension{
-			Name:     to.StringPtr(vmExtSpec.Name),
-			Location: to.StringPtr(s.Scope.Location()),
-			VirtualMachineExtensionProperties: &compute.VirtualMachineExtensionProperties{
-				Type:                    to.StringPtr("CustomScript"),
-				TypeHandlerVersion:      to.StringPtr("2.0"),
-				AutoUpgradeMinorVersion: to.BoolPtr(true),
-				Settings:                map[string]bool{"skipDos2Unix": true},
-				Publisher:               to.StringPtr("Microsoft.Azure.Extensions"),
-				ProtectedSettings:       map[string]string{"script": vmExtSpec.ScriptData},
-			},
-		})
+		extension)
 	if err != nil {
-		return errors.Wrapf(err, "cannot create vm extension")
+		return errors.Wrapf(err, "failed to create vm extension %s of vm %s", vmExtSpec.Name, vmExtSpec.VMName)
 	}
 
-	// if *vmExt.ProvisioningState != string(compute.ProvisioningStateSucceeded) {
-	// 	// If the script failed delete it so it can be retried
-	// 	s.Delete(ctx, vmExtSpec)
-	// }
-
-	klog.V(2).Infof("successfully created vm extension %s ", vmExtSpec.Name)
+	log.V(2).Info("successfully created vm extension")
 	return nil
 }
 
+// isUpToDate returns true if an existing virtual machine extension provisioned successfully with the handler and the
+// settings of the spec.
+func isUpToDate(existing compute.VirtualMachineExtension, vmExtSpec *Spec) bool {
+	properties := existing.VirtualMachineExtensionProperties
+	if properties == nil || to.String(properties.ProvisioningState) != string(compute.ProvisioningStateSucceeded) {
+		return false
+	}
+	if to.String(properties.Publisher) != vmExtSpec.Publisher || to.String(properties.Type) != vmExtSpec.Type ||
+		to.String(properties.TypeHandlerVersion) != vmExtSpec.Version {
+		return false
+	}
+	// Azure returns the settings as a JSON object.
+	settings, _ := properties.Settings.(map[string]interface{})
+	if len(settings) != len(vmExtSpec.Settings) {
+		return false
+	}
+	for key, value := range vmExtSpec.Settings {
+		if existingValue, ok := settings[key].(string); !ok || existingValue != value {
+			return false
+		}
+	}
+	return true
+}
+
 // Delete deletes the virtual machine extension with the provided name.
 func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	vmExtSpec, ok := spec.(*Spec)
 	if !ok {
-		return errors.New("Invalid VNET Specification")
+		return errors.New("invalid vm extension specification")
+	}
+	log := s.Scope.ResourceLogger(vmExtSpec.Name).WithValues("vm", vmExtSpec.VMName)
+	if s.Scope.DryRun(scope.DeleteAction("vm extension", s.Scope.ResourceGroup(), vmExtSpec.Name)) {
+		return nil
 	}
-	klog.V(2).Infof("deleting vm extension %s ", vmExtSpec.Name)
+
+	log.V(2).Info("deleting vm extension")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), vmExtSpec.VMName, vmExtSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("vm extension is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete vm extension %s in resource group %s", vmExtSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully deleted vm %s ", vmExtSpec.Name)
+	log.V(2).Info("successfully deleted vm extension")
 	return nil
 }
@@ -0,0 +1,244 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtualmachineextensions
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachineextensions/mock_virtualmachineextensions"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testSpec() *Spec {
+	return &Spec{
+		Name:              "my-extension",
+		VMName:            "my-vm",
+		Publisher:         "Microsoft.Azure.Extensions",
+		Type:              "CustomScript",
+		Version:           "2.1",
+		Settings:          map[string]string{"commandToExecute": "echo bootstrapped"},
+		ProtectedSettings: map[string]string{"storageAccountKey": "my-key"},
+	}
+}
+
+// existingExtension returns the extension of the test spec as Azure returns it, without its protected settings.
+func existingExtension(provisioningState string, settings interface{}) compute.VirtualMachineExtension {
+	return compute.VirtualMachineExtension{
+		Name: to.StringPtr("my-extension"),
+		VirtualMachineExtensionProperties: &compute.VirtualMachineExtensionProperties{
+			Publisher:          to.StringPtr("Microsoft.Azure.Extensions"),
+			Type:               to.StringPtr("CustomScript"),
+			TypeHandlerVersion: to.StringPtr("2.1"),
+			Settings:           settings,
+			ProvisioningState:  to.StringPtr(provisioningState),
+		},
+	}
+}
+
+func TestReconcileVMExtension(t *testing.T) {
+	expectedExtension := compute.VirtualMachineExtension{
+		Name:     to.StringPtr("my-extension"),
+		Location: to.StringPtr("test-location"),
+		VirtualMachineExtensionProperties: &compute.VirtualMachineExtensionProperties{
+			Publisher:               to.StringPtr("Microsoft.Azure.Extensions"),
+			Type:                    to.StringPtr("CustomScript"),
+			TypeHandlerVersion:      to.StringPtr("2.1"),
+			AutoUpgradeMinorVersion: to.BoolPtr(true),
+			Settings:                map[string]string{"commandToExecute": "echo bootstrapped"},
+			ProtectedSettings:       map[string]string{"storageAccountKey": "my-key"},
+		},
+	}
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_virtualmachineextensions.MockClientMockRecorder)
+	}{
+		{
+			name: "vm extension is created",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm", "my-extension").Return(compute.VirtualMachineExtension{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vm", "my-extension", expectedExtension)
+			},
+		},
+		{
+			name: "vm extension is already up to date",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm", "my-extension").
+					Return(existingExtension("Succeeded", map[string]interface{}{"commandToExecute": "echo bootstrapped"}), nil)
+			},
+		},
+		{
+			name: "vm extension with other settings is updated",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm", "my-extension").
+					Return(existingExtension("Succeeded", map[string]interface{}{"commandToExecute": "echo"}), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vm", "my-extension", expectedExtension)
+			},
+		},
+		{
+			name: "vm extension that failed to provision is applied again",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm", "my-extension").
+					Return(existingExtension("Failed", map[string]interface{}{"commandToExecute": "echo bootstrapped"}), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vm", "my-extension", expectedExtension)
+			},
+		},
+		{
+			name:          "fail to get vm extension",
+			expectedError: "failed to get vm extension my-extension of vm my-vm: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm", "my-extension").
+					Return(compute.VirtualMachineExtension{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+		{
+			name:          "fail to create vm extension",
+			expectedError: "failed to create vm extension my-extension of vm my-vm: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vm", "my-extension").Return(compute.VirtualMachineExtension{}, notFound)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vm", "my-extension", expectedExtension).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			vmExtensionsMock := mock_virtualmachineextensions.NewMockClient(mockCtrl)
+			tc.expect(vmExtensionsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: vmExtensionsMock,
+			}
+			err = s.Reconcile(context.TODO(), testSpec())
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteVMExtension(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_virtualmachineextensions.MockClientMockRecorder)
+	}{
+		{
+			name: "vm extension exists",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vm", "my-extension")
+			},
+		},
+		{
+			name: "vm extension already deleted",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vm", "my-extension").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "fail to delete vm extension",
+			expectedError: "failed to delete vm extension my-extension in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_virtualmachineextensions.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vm", "my-extension").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			vmExtensionsMock := mock_virtualmachineextensions.NewMockClient(mockCtrl)
+			tc.expect(vmExtensionsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: vmExtensionsMock,
+			}
+			err = s.Delete(context.TODO(), testSpec())
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -18,17 +18,28 @@ package virtualmachines
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 )
 
 // Client wraps go-sdk
 type Client interface {
 	Get(context.Context, string, string) (compute.VirtualMachine, error)
+	ListStatus(context.Context, string) ([]compute.VirtualMachine, error)
 	CreateOrUpdate(context.Context, string, string, compute.VirtualMachine) error
+	CreateOrUpdateAsync(context.Context, string, string, compute.VirtualMachine) (*infrav1.Future, error)
+	GetResultIfDone(context.Context, *infrav1.Future) (compute.VirtualMachine, error)
 	Delete(context.Context, string, string) error
+	Deallocate(context.Context, string, string) error
+	Start(context.Context, string, string) error
 }
 
 // AzureClient contains the Azure go-sdk Client
@@ -38,49 +49,179 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newVirtualMachinesClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newVirtualMachinesClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newVirtualMachinesClient creates a new VM client from subscription ID.
-func newVirtualMachinesClient(subscriptionID string, authorizer autorest.Authorizer) compute.VirtualMachinesClient {
-	vmClient := compute.NewVirtualMachinesClient(subscriptionID)
+// newVirtualMachinesClient creates a new VM client from subscription ID and base URI.
+func newVirtualMachinesClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) compute.VirtualMachinesClient {
+	vmClient := compute.NewVirtualMachinesClientWithBaseURI(baseURI, subscriptionID)
 	vmClient.Authorizer = authorizer
 	vmClient.AddToUserAgent(azure.UserAgent)
 	return vmClient
 }
 
-// Get retrieves information about the model view or the instance view of a virtual machine.
+// Get retrieves the model view of a virtual machine together with its instance view, which reports its run-time
+// state such as its power state.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, vmName string) (compute.VirtualMachine, error) {
-	return ac.virtualmachines.Get(ctx, resourceGroupName, vmName, "")
+	var result compute.VirtualMachine
+	err := azure.CallAPI(ctx, "virtualmachines", "Get", func() error {
+		var err error
+		result, err = ac.virtualmachines.Get(ctx, resourceGroupName, vmName, compute.InstanceView)
+		return err
+	})
+	return result, err
+}
+
+// ListStatus lists the virtual machines of a resource group with their instance view, such as their power state.
+// The run-time status of virtual machines is only listed for a whole subscription, with a single paged request rather
+// than with a request per virtual machine, so the virtual machines of other resource groups are left out.
+func (ac *AzureClient) ListStatus(ctx context.Context, resourceGroupName string) ([]compute.VirtualMachine, error) {
+	var vms []compute.VirtualMachine
+	err := azure.CallAPI(ctx, "virtualmachines", "ListStatus", func() error {
+		vms = nil
+		iter, err := ac.virtualmachines.ListAllComplete(ctx, "true")
+		if err != nil {
+			return err
+		}
+		for iter.NotDone() {
+			if vm := iter.Value(); inResourceGroup(vm, resourceGroupName) {
+				vms = append(vms, vm)
+			}
+			if err := iter.NextWithContext(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+// inResourceGroup returns true if the virtual machine is in the resource group.
+func inResourceGroup(vm compute.VirtualMachine, resourceGroupName string) bool {
+	resource, err := autorestazure.ParseResourceID(to.String(vm.ID))
+	return err == nil && strings.EqualFold(resource.ResourceGroup, resourceGroupName)
 }
 
 // CreateOrUpdate the operation to create or update a virtual machine.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, vmName string, vm compute.VirtualMachine) error {
-	future, err := ac.virtualmachines.CreateOrUpdate(ctx, resourceGroupName, vmName, vm)
-	if err != nil {
+	var future compute.VirtualMachinesCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "virtualmachines", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.virtualmachines.CreateOrUpdate(ctx, resourceGroupName, vmName, vm)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.virtualmachines.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.virtualmachines)
 		return err
+	})
+}
+
+// CreateOrUpdateAsync starts the operation to create or update a virtual machine, and returns its future without
+// waiting for it to complete.
+func (ac *AzureClient) CreateOrUpdateAsync(ctx context.Context, resourceGroupName, vmName string, vm compute.VirtualMachine) (*infrav1.Future, error) {
+	var future compute.VirtualMachinesCreateOrUpdateFuture
+	err := azure.CallAPI(ctx, "virtualmachines", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.virtualmachines.CreateOrUpdate(ctx, resourceGroupName, vmName, vm)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	err = future.WaitForCompletionRef(ctx, ac.virtualmachines.Client)
+	data, err := json.Marshal(future.Future)
 	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal the future of vm %s", vmName)
+	}
+	return &infrav1.Future{
+		Type:          infrav1.PutFuture,
+		ResourceGroup: resourceGroupName,
+		Name:          vmName,
+		Data:          string(data),
+	}, nil
+}
+
+// GetResultIfDone polls the operation of the future to create or update a virtual machine, and returns the virtual
+// machine when it has completed or an azure.OperationNotDoneError otherwise.
+func (ac *AzureClient) GetResultIfDone(ctx context.Context, future *infrav1.Future) (compute.VirtualMachine, error) {
+	var createFuture compute.VirtualMachinesCreateOrUpdateFuture
+	if err := json.Unmarshal([]byte(future.Data), &createFuture.Future); err != nil {
+		return compute.VirtualMachine{}, errors.Wrapf(err, "failed to unmarshal the future of vm %s", future.Name)
+	}
+
+	var done bool
+	err := azure.CallAPI(ctx, "virtualmachines", "PollCreateOrUpdate", func() error {
+		var err error
+		done, err = createFuture.DoneWithContext(ctx, ac.virtualmachines)
 		return err
+	})
+	if err != nil {
+		return compute.VirtualMachine{}, err
+	}
+	if !done {
+		return compute.VirtualMachine{}, azure.NewOperationNotDoneError(future)
 	}
-	_, err = future.Result(ac.virtualmachines)
-	return err
+
+	var result compute.VirtualMachine
+	err = azure.CallAPI(ctx, "virtualmachines", "CreateOrUpdateResult", func() error {
+		var err error
+		result, err = createFuture.Result(ac.virtualmachines)
+		return err
+	})
+	return result, err
 }
 
 // Delete the operation to delete a virtual machine.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, vmName string) error {
-	future, err := ac.virtualmachines.Delete(ctx, resourceGroupName, vmName)
-	if err != nil {
+	var future compute.VirtualMachinesDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "virtualmachines", "Delete", func() error {
+		var err error
+		future, err = ac.virtualmachines.Delete(ctx, resourceGroupName, vmName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.virtualmachines.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.virtualmachines.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.virtualmachines)
 		return err
-	}
-	_, err = future.Result(ac.virtualmachines)
-	return err
+	})
+}
+
+// Deallocate shuts down a virtual machine and releases its compute resources, which are no longer billed.
+func (ac *AzureClient) Deallocate(ctx context.Context, resourceGroupName, vmName string) error {
+	var future compute.VirtualMachinesDeallocateFuture
+	return azure.CallLongRunningAPI(ctx, "virtualmachines", "Deallocate", func() error {
+		var err error
+		future, err = ac.virtualmachines.Deallocate(ctx, resourceGroupName, vmName)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.virtualmachines.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.virtualmachines)
+		return err
+	})
+}
+
+// Start starts a stopped or deallocated virtual machine.
+func (ac *AzureClient) Start(ctx context.Context, resourceGroupName, vmName string) error {
+	var future compute.VirtualMachinesStartFuture
+	return azure.CallLongRunningAPI(ctx, "virtualmachines", "Start", func() error {
+		var err error
+		future, err = ac.virtualmachines.Start(ctx, resourceGroupName, vmName)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.virtualmachines.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.virtualmachines)
+		return err
+	})
 }
@@ -17,14 +17,17 @@ limitations under the License.
 package virtualmachines
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2019-07-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
@@ -33,18 +36,54 @@ import (
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
+// maxCustomDataLength is the maximum length of the base64 encoded custom data of a virtual machine.
+const maxCustomDataLength = 64 * 1024
+
+// powerStatePrefix is the prefix of the code of the instance view status which reports the power state of a virtual
+// machine, such as PowerState/running.
+const powerStatePrefix = "PowerState/"
+
+// ClusterVM is a virtual machine owned by the cluster, with its power state.
+type ClusterVM struct {
+	Name string
+	// PowerState is the power state of the virtual machine, such as running, stopped or deallocated. It is empty
+	// while Azure does not report one, for example while the virtual machine is created.
+	PowerState infrav1.VMPowerState
+}
+
 // Spec input specification for Get/CreateOrUpdate/Delete calls
 type Spec struct {
 	Name       string
 	NICName    string
 	SSHKeyData string
 	Size       string
-	Zone       string
-	Image      infrav1.Image
-	OSDisk     infrav1.OSDisk
+	// AdminUsername is the name of the admin user of the virtual machine, it defaults to capi.
+	AdminUsername string
+	// ComputerName is the host name of the virtual machine, it defaults to one generated from the name of the VM.
+	ComputerName string
+	// OSType is the operating system of the virtual machine, it defaults to Linux.
+	OSType    infrav1.OSType
+	Zone      string
+	Image     infrav1.Image
+	OSDisk    infrav1.OSDisk
+	DataDisks []infrav1.DataDisk
+	// CustomData is the base64 encoded bootstrap data of the virtual machine.
 	CustomData string
+	// SecondaryNICNames are the names of the network interfaces of the virtual machine other than its primary one.
+	SecondaryNICNames []string
+	// AvailabilitySetID is the ID of the availability set of a virtual machine that is not placed in a zone.
+	AvailabilitySetID string
+	// ProximityPlacementGroupID is the ID of the proximity placement group of the virtual machine, if any.
+	ProximityPlacementGroupID string
+	SpotVMOptions             *infrav1.SpotVMOptions
+	// Identity and UserAssignedIdentities are the managed identities of the virtual machine.
+	Identity               infrav1.VMIdentity
+	UserAssignedIdentities []string
+	Diagnostics            *infrav1.Diagnostics
+	EncryptionAtHost       *bool
 }
 
 // Get provides information about a virtual machine.
@@ -72,6 +111,10 @@ func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error
 		return convertedVM, err
 	}
 	convertedVM.Addresses = addresses
+
+	if vm.VirtualMachineProperties != nil && vm.InstanceView != nil {
+		convertedVM.PowerState = powerState(*vm.InstanceView)
+	}
 	return convertedVM, nil
 }
 
@@ -81,96 +124,120 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid vm specification")
 	}
+	log := s.Scope.ResourceLogger(vmSpec.Name)
+	log.V(4).Info("reconciling vm")
+
+	if future := s.MachineScope.GetLongRunningOperationState(); future != nil && future.Type == infrav1.PutFuture && future.Name == vmSpec.Name {
+		return s.pollCreateOrUpdate(ctx, future)
+	}
 
 	storageProfile, err := generateStorageProfile(*vmSpec)
 	if err != nil {
 		return err
 	}
 
-	klog.V(2).Infof("getting nic %s", vmSpec.NICName)
+	if vmSpec.SSHKeyData != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(vmSpec.SSHKeyData)); err != nil {
+			return errors.Wrapf(err, "invalid ssh public key of vm %s", vmSpec.Name)
+		}
+	}
+
+	log.V(4).Info("getting nic", "nic", vmSpec.NICName)
 	nic, err := s.InterfacesClient.Get(ctx, s.Scope.ResourceGroup(), vmSpec.NICName)
 	if err != nil {
 		return err
 	}
-	klog.V(2).Infof("got nic %s", vmSpec.NICName)
-
-	klog.V(2).Infof("creating vm %s ", vmSpec.Name)
-
-	sshKeyData := vmSpec.SSHKeyData
-	if sshKeyData == "" {
-		privateKey, perr := rsa.GenerateKey(rand.Reader, 2048)
-		if perr != nil {
-			return errors.Wrap(perr, "Failed to generate private key")
-		}
+	log.V(4).Info("successfully got nic", "nic", vmSpec.NICName)
 
-		publicRsaKey, perr := ssh.NewPublicKey(&privateKey.PublicKey)
-		if perr != nil {
-			return errors.Wrap(perr, "Failed to generate public key")
+	nicRefs := []compute.NetworkInterfaceReference{
+		{
+			ID: nic.ID,
+			NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
+				Primary: to.BoolPtr(true),
+			},
+		},
+	}
+	for _, nicName := range vmSpec.SecondaryNICNames {
+		log.V(4).Info("getting nic", "nic", nicName)
+		secondaryNIC, err := s.InterfacesClient.Get(ctx, s.Scope.ResourceGroup(), nicName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get secondary nic %s of vm %s", nicName, vmSpec.Name)
 		}
-		sshKeyData = string(ssh.MarshalAuthorizedKey(publicRsaKey))
+		nicRefs = append(nicRefs, compute.NetworkInterfaceReference{
+			ID: secondaryNIC.ID,
+			NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
+				Primary: to.BoolPtr(false),
+			},
+		})
 	}
 
-	randomPassword, err := GenerateRandomString(32)
+	log.V(2).Info("creating vm")
+
+	osProfile, err := generateOSProfile(*vmSpec)
 	if err != nil {
-		return errors.Wrapf(err, "failed to generate random string")
+		return err
 	}
 
 	// Make sure to use the MachineScope here to get the merger of AzureCluster and AzureMachine tags
-	additionalTags := s.MachineScope.AdditionalTags()
-	// Set the cloud provider tag
-	additionalTags[infrav1.ClusterAzureCloudProviderTagKey(s.MachineScope.Name())] = string(infrav1.ResourceLifecycleOwned)
-
 	virtualMachine := compute.VirtualMachine{
 		Location: to.StringPtr(s.Scope.Location()),
-		Tags: converters.TagsToMap(infrav1.Build(infrav1.BuildParams{
-			ClusterName: s.Scope.Name(),
-			Lifecycle:   infrav1.ResourceLifecycleOwned,
-			Name:        to.StringPtr(s.MachineScope.Name()),
-			Role:        to.StringPtr(s.MachineScope.Role()),
-			Additional:  additionalTags,
-		})),
+		Tags:     converters.TagsToMap(s.MachineScope.ResourceTags()),
 		VirtualMachineProperties: &compute.VirtualMachineProperties{
 			HardwareProfile: &compute.HardwareProfile{
 				VMSize: compute.VirtualMachineSizeTypes(vmSpec.Size),
 			},
 			StorageProfile: storageProfile,
-			OsProfile: &compute.OSProfile{
-				ComputerName:  to.StringPtr(vmSpec.Name),
-				AdminUsername: to.StringPtr(azure.DefaultUserName),
-				AdminPassword: to.StringPtr(randomPassword),
-				CustomData:    to.StringPtr(vmSpec.CustomData),
-				LinuxConfiguration: &compute.LinuxConfiguration{
-					SSH: &compute.SSHConfiguration{
-						PublicKeys: &[]compute.SSHPublicKey{
-							{
-								Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", azure.DefaultUserName)),
-								KeyData: to.StringPtr(sshKeyData),
-							},
-						},
-					},
-				},
-			},
+			OsProfile:      osProfile,
 			NetworkProfile: &compute.NetworkProfile{
-				NetworkInterfaces: &[]compute.NetworkInterfaceReference{
-					{
-						ID: nic.ID,
-						NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{
-							Primary: to.BoolPtr(true),
-						},
-					},
-				},
+				NetworkInterfaces: &nicRefs,
 			},
 		},
 	}
 
-	klog.V(2).Infof("Setting zone %s ", vmSpec.Zone)
+	log.V(2).Info("setting zone", "zone", vmSpec.Zone)
 
 	if vmSpec.Zone != "" {
 		zones := []string{vmSpec.Zone}
 		virtualMachine.Zones = &zones
 	}
 
-	err = s.Client.CreateOrUpdate(
+	virtualMachine.Identity = generateIdentity(vmSpec.Identity, vmSpec.UserAssignedIdentities)
+
+	diagnosticsProfile, err := generateDiagnosticsProfile(vmSpec.Diagnostics)
+	if err != nil {
+		return errors.Wrapf(err, "invalid diagnostics of vm %s", vmSpec.Name)
+	}
+	virtualMachine.DiagnosticsProfile = diagnosticsProfile
+
+	additionalCapabilities, err := generateAdditionalCapabilities(*vmSpec)
+	if err != nil {
+		return err
+	}
+	virtualMachine.AdditionalCapabilities = additionalCapabilities
+
+	virtualMachine.SecurityProfile = generateSecurityProfile(*vmSpec)
+
+	if err := applySpotVMOptions(virtualMachine.VirtualMachineProperties, vmSpec.SpotVMOptions); err != nil {
+		return err
+	}
+
+	virtualMachine.Plan = GenerateImagePlanReference(vmSpec.Image)
+
+	if vmSpec.AvailabilitySetID != "" {
+		log.V(2).Info("setting availability set", "availabilitySet", vmSpec.AvailabilitySetID)
+		virtualMachine.AvailabilitySet = &compute.SubResource{ID: to.StringPtr(vmSpec.AvailabilitySetID)}
+	}
+
+	if vmSpec.ProximityPlacementGroupID != "" {
+		log.V(2).Info("setting proximity placement group", "proximityPlacementGroup", vmSpec.ProximityPlacementGroupID)
+		virtualMachine.ProximityPlacementGroup = &compute.SubResource{ID: to.StringPtr(vmSpec.ProximityPlacementGroupID)}
+	}
+
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("vm", s.Scope.ResourceGroup(), vmSpec.Name, virtualMachine)) {
+		return nil
+	}
+
+	future, err := s.Client.CreateOrUpdateAsync(
 		ctx,
 		s.Scope.ResourceGroup(),
 		vmSpec.Name,
@@ -179,7 +246,27 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 		return errors.Wrapf(err, "cannot create vm")
 	}
 
-	klog.V(2).Infof("successfully created vm %s ", vmSpec.Name)
+	// The creation is polled by the next reconciles rather than blocking this one until it completes.
+	s.MachineScope.SetLongRunningOperationState(future)
+	log.V(2).Info("started creating vm")
+	return azure.NewOperationNotDoneError(future)
+}
+
+// pollCreateOrUpdate polls the creation of a virtual machine started by a previous reconcile. It returns an
+// azure.OperationNotDoneError while the creation is in progress, and forgets the future once it has completed.
+func (s *Service) pollCreateOrUpdate(ctx context.Context, future *infrav1.Future) error {
+	log := s.Scope.ResourceLogger(future.Name)
+	_, err := s.Client.GetResultIfDone(ctx, future)
+	if azure.IsOperationNotDoneError(err) {
+		log.V(2).Info("vm creation is in progress")
+		return err
+	}
+	s.MachineScope.DeleteLongRunningOperationState()
+	if err != nil {
+		return errors.Wrapf(err, "cannot create vm")
+	}
+
+	log.V(2).Info("successfully created vm")
 	return nil
 }
 
@@ -189,20 +276,108 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("invalid vm Specification")
 	}
-	klog.V(2).Infof("deleting vm %s ", vmSpec.Name)
+	log := s.Scope.ResourceLogger(vmSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("vm", s.Scope.ResourceGroup(), vmSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting vm")
 	err := s.Client.Delete(ctx, s.Scope.ResourceGroup(), vmSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("vm is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete vm %s in resource group %s", vmSpec.Name, s.Scope.ResourceGroup())
 	}
 
-	klog.V(2).Infof("successfully deleted vm %s ", vmSpec.Name)
+	log.V(2).Info("successfully deleted vm")
+	return nil
+}
+
+// Deallocate shuts down the virtual machine with the provided name and releases its compute resources. Its disks and
+// network interfaces are kept, so that it can be started again.
+func (s *Service) Deallocate(ctx context.Context, spec interface{}) error {
+	vmSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid vm Specification")
+	}
+	log := s.Scope.ResourceLogger(vmSpec.Name)
+	if s.Scope.DryRun(scope.DeallocateAction("vm", s.Scope.ResourceGroup(), vmSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deallocating vm")
+	if err := s.Client.Deallocate(ctx, s.Scope.ResourceGroup(), vmSpec.Name); err != nil {
+		return errors.Wrapf(err, "failed to deallocate vm %s in resource group %s", vmSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deallocated vm")
+	return nil
+}
+
+// Start starts the stopped or deallocated virtual machine with the provided name.
+func (s *Service) Start(ctx context.Context, spec interface{}) error {
+	vmSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid vm Specification")
+	}
+	log := s.Scope.ResourceLogger(vmSpec.Name)
+	if s.Scope.DryRun(scope.StartAction("vm", s.Scope.ResourceGroup(), vmSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("starting vm")
+	if err := s.Client.Start(ctx, s.Scope.ResourceGroup(), vmSpec.Name); err != nil {
+		return errors.Wrapf(err, "failed to start vm %s in resource group %s", vmSpec.Name, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully started vm")
 	return nil
 }
 
+// ListClusterVMs returns the virtual machines of the resource group of the cluster which are tagged as owned by the
+// cluster, with their power state. It lets the virtual machines which no AzureMachine refers to anymore be found.
+func (s *Service) ListClusterVMs(ctx context.Context) ([]ClusterVM, error) {
+	vms, err := s.Client.ListStatus(ctx, s.Scope.ResourceGroup())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list vms in resource group %s", s.Scope.ResourceGroup())
+	}
+
+	var clusterVMs []ClusterVM
+	for _, vm := range vms {
+		if !converters.MapToTags(vm.Tags).HasOwned(s.Scope.Name()) {
+			continue
+		}
+		clusterVM := ClusterVM{Name: to.String(vm.Name)}
+		if vm.VirtualMachineProperties != nil && vm.InstanceView != nil {
+			clusterVM.PowerState = powerState(*vm.InstanceView)
+		}
+		clusterVMs = append(clusterVMs, clusterVM)
+	}
+	return clusterVMs, nil
+}
+
+// List returns the virtual machines owned by the cluster, as the []ClusterVM returned by ListClusterVMs.
+func (s *Service) List(ctx context.Context) (interface{}, error) {
+	return s.ListClusterVMs(ctx)
+}
+
+// powerState returns the power state reported in the instance view of a virtual machine, or an empty string if it
+// reports none.
+func powerState(instanceView compute.VirtualMachineInstanceView) infrav1.VMPowerState {
+	if instanceView.Statuses == nil {
+		return ""
+	}
+	for _, status := range *instanceView.Statuses {
+		if code := to.String(status.Code); strings.HasPrefix(code, powerStatePrefix) {
+			return infrav1.VMPowerState(strings.TrimPrefix(code, powerStatePrefix))
+		}
+	}
+	return ""
+}
+
 func (s *Service) getAddresses(ctx context.Context, vm compute.VirtualMachine) ([]corev1.NodeAddress, error) {
 
 	addresses := []corev1.NodeAddress{}
@@ -278,22 +453,207 @@ func getResourceNameByID(resourceID string) string {
 	return resourceName
 }
 
+// generateCustomData returns the base64 encoded custom data of a virtual machine, which Azure limits to
+// maxCustomDataLength bytes. cloud-init decompresses gzip custom data, so the bootstrap data of a Linux virtual machine
+// is compressed when it is too long otherwise.
+func generateCustomData(vmSpec Spec) (string, error) {
+	if len(vmSpec.CustomData) <= maxCustomDataLength {
+		return vmSpec.CustomData, nil
+	}
+	if vmSpec.OSType == infrav1.WindowsOSType {
+		return "", errors.Errorf("custom data of vm %s is %d bytes base64 encoded, more than the limit of %d bytes",
+			vmSpec.Name, len(vmSpec.CustomData), maxCustomDataLength)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(vmSpec.CustomData)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to decode custom data of vm %s", vmSpec.Name)
+	}
+	var compressed bytes.Buffer
+	w, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to compress custom data of vm %s", vmSpec.Name)
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", errors.Wrapf(err, "failed to compress custom data of vm %s", vmSpec.Name)
+	}
+	if err := w.Close(); err != nil {
+		return "", errors.Wrapf(err, "failed to compress custom data of vm %s", vmSpec.Name)
+	}
+	customData := base64.StdEncoding.EncodeToString(compressed.Bytes())
+	if len(customData) > maxCustomDataLength {
+		return "", errors.Errorf("custom data of vm %s is %d bytes compressed and base64 encoded, more than the limit of %d bytes",
+			vmSpec.Name, len(customData), maxCustomDataLength)
+	}
+	klog.V(2).Infof("compressed custom data of vm %s from %d to %d bytes base64 encoded", vmSpec.Name, len(vmSpec.CustomData), len(customData))
+	return customData, nil
+}
+
+// generateOSProfile generates the OS profile of a virtual machine. Linux virtual machines authorize the SSH public key
+// of the spec, or a generated one, for the admin user. Windows virtual machines have a random admin password instead,
+// and are provisioned by cloudbase-init from the custom data. The computer name of the spec is used as is, otherwise
+// one is generated from the name of the VM within the limits of the OS.
+func generateOSProfile(vmSpec Spec) (*compute.OSProfile, error) {
+	// A 32 bytes password is base64 encoded with padding, so it also meets the complexity requirements of Windows.
+	randomPassword, err := GenerateRandomString(32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to generate random string")
+	}
+
+	customData, err := generateCustomData(vmSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	adminUsername := vmSpec.AdminUsername
+	if adminUsername == "" {
+		adminUsername = azure.DefaultUserName
+	}
+
+	osProfile := &compute.OSProfile{
+		ComputerName:  to.StringPtr(vmSpec.ComputerName),
+		AdminUsername: to.StringPtr(adminUsername),
+		AdminPassword: to.StringPtr(randomPassword),
+		CustomData:    to.StringPtr(customData),
+	}
+
+	switch vmSpec.OSType {
+	case infrav1.WindowsOSType:
+		if vmSpec.ComputerName == "" {
+			osProfile.ComputerName = to.StringPtr(azure.GenerateWindowsComputerName(vmSpec.Name))
+		}
+		osProfile.WindowsConfiguration = &compute.WindowsConfiguration{
+			// The VM agent runs the extensions, such as the one starting cloudbase-init.
+			ProvisionVMAgent: to.BoolPtr(true),
+			// Updates that restart the node are left to the cluster operator.
+			EnableAutomaticUpdates: to.BoolPtr(false),
+		}
+		return osProfile, nil
+	case "", infrav1.LinuxOSType:
+		if vmSpec.ComputerName == "" {
+			osProfile.ComputerName = to.StringPtr(azure.GenerateLinuxComputerName(vmSpec.Name))
+		}
+	default:
+		return nil, errors.Errorf("unknown OS type %s of vm %s", vmSpec.OSType, vmSpec.Name)
+	}
+
+	sshKeyData := vmSpec.SSHKeyData
+	if sshKeyData == "" {
+		privateKey, perr := rsa.GenerateKey(rand.Reader, 2048)
+		if perr != nil {
+			return nil, errors.Wrap(perr, "Failed to generate private key")
+		}
+
+		publicRsaKey, perr := ssh.NewPublicKey(&privateKey.PublicKey)
+		if perr != nil {
+			return nil, errors.Wrap(perr, "Failed to generate public key")
+		}
+		sshKeyData = string(ssh.MarshalAuthorizedKey(publicRsaKey))
+	}
+
+	osProfile.LinuxConfiguration = &compute.LinuxConfiguration{
+		SSH: &compute.SSHConfiguration{
+			PublicKeys: &[]compute.SSHPublicKey{
+				{
+					Path:    to.StringPtr(fmt.Sprintf("/home/%s/.ssh/authorized_keys", adminUsername)),
+					KeyData: to.StringPtr(sshKeyData),
+				},
+			},
+		},
+	}
+	return osProfile, nil
+}
+
 // generateStorageProfile generates a pointer to a compute.StorageProfile which can utilized for VM creation.
 func generateStorageProfile(vmSpec Spec) (*compute.StorageProfile, error) {
 	// TODO: Validate parameters before building storage profile
+	if err := validateOSDiskStorageAccountType(vmSpec.OSDisk.ManagedDisk.StorageAccountType); err != nil {
+		return nil, errors.Wrapf(err, "invalid OS disk of vm %s", vmSpec.Name)
+	}
+	if err := validateCaching(vmSpec.OSDisk.Caching); err != nil {
+		return nil, errors.Wrapf(err, "invalid OS disk of vm %s", vmSpec.Name)
+	}
+	for _, disk := range vmSpec.DataDisks {
+		if err := validateCaching(disk.Caching); err != nil {
+			return nil, errors.Wrapf(err, "invalid data disk %s of vm %s", disk.NameSuffix, vmSpec.Name)
+		}
+		if err := validateWriteAccelerator(disk); err != nil {
+			return nil, errors.Wrapf(err, "invalid data disk %s of vm %s", disk.NameSuffix, vmSpec.Name)
+		}
+	}
+
 	storageProfile := &compute.StorageProfile{
 		OsDisk: &compute.OSDisk{
 			Name:         to.StringPtr(azure.GenerateOSDiskName(vmSpec.Name)),
 			OsType:       compute.OperatingSystemTypes(vmSpec.OSDisk.OSType),
 			CreateOption: compute.DiskCreateOptionTypesFromImage,
-			DiskSizeGB:   to.Int32Ptr(vmSpec.OSDisk.DiskSizeGB),
 			ManagedDisk: &compute.ManagedDiskParameters{
 				StorageAccountType: compute.StorageAccountTypes(vmSpec.OSDisk.ManagedDisk.StorageAccountType),
+				DiskEncryptionSet:  generateDiskEncryptionSet(&vmSpec.OSDisk.ManagedDisk),
 			},
+			Caching: compute.CachingTypesReadWrite,
 		},
 	}
 
-	imageRef, err := generateImageReference(vmSpec.Image)
+	if vmSpec.OSDisk.Caching != "" {
+		storageProfile.OsDisk.Caching = compute.CachingTypes(vmSpec.OSDisk.Caching)
+	}
+
+	// Without a size, the OS disk is as large as the OS disk of the image.
+	if vmSpec.OSDisk.DiskSizeGB > 0 {
+		storageProfile.OsDisk.DiskSizeGB = to.Int32Ptr(vmSpec.OSDisk.DiskSizeGB)
+	}
+
+	if len(vmSpec.DataDisks) > 0 {
+		dataDisks := make([]compute.DataDisk, 0, len(vmSpec.DataDisks))
+		for _, disk := range vmSpec.DataDisks {
+			dataDisk := compute.DataDisk{
+				Name:         to.StringPtr(azure.GenerateDataDiskName(vmSpec.Name, disk.NameSuffix)),
+				Lun:          to.Int32Ptr(disk.Lun),
+				CreateOption: compute.DiskCreateOptionTypesEmpty,
+				DiskSizeGB:   to.Int32Ptr(disk.DiskSizeGB),
+				Caching:      compute.CachingTypes(disk.Caching),
+			}
+			if to.Bool(disk.WriteAcceleratorEnabled) {
+				dataDisk.WriteAcceleratorEnabled = to.BoolPtr(true)
+			}
+			if disk.ManagedDisk != nil {
+				dataDisk.ManagedDisk = &compute.ManagedDiskParameters{
+					StorageAccountType: compute.StorageAccountTypes(disk.ManagedDisk.StorageAccountType),
+					DiskEncryptionSet:  generateDiskEncryptionSet(disk.ManagedDisk),
+				}
+			}
+			// An existing disk keeps its name and size.
+			if disk.ManagedDiskID != "" {
+				dataDisk.Name = nil
+				dataDisk.DiskSizeGB = nil
+				dataDisk.CreateOption = compute.DiskCreateOptionTypesAttach
+				if dataDisk.ManagedDisk == nil {
+					dataDisk.ManagedDisk = &compute.ManagedDiskParameters{}
+				}
+				dataDisk.ManagedDisk.ID = to.StringPtr(disk.ManagedDiskID)
+			}
+			dataDisks = append(dataDisks, dataDisk)
+		}
+		storageProfile.DataDisks = &dataDisks
+	}
+
+	if vmSpec.OSDisk.DiffDiskSettings != nil {
+		storageAccountType := compute.StorageAccountTypes(vmSpec.OSDisk.ManagedDisk.StorageAccountType)
+		if storageAccountType != compute.StorageAccountTypesStandardLRS {
+			return nil, errors.Errorf("ephemeral OS disk of vm %s requires storage account type %s, got %s", vmSpec.Name, compute.StorageAccountTypesStandardLRS, storageAccountType)
+		}
+		// Ephemeral OS disks only support read-only caching.
+		if vmSpec.OSDisk.Caching != "" && compute.CachingTypes(vmSpec.OSDisk.Caching) != compute.CachingTypesReadOnly {
+			return nil, errors.Errorf("ephemeral OS disk of vm %s requires caching %s, got %s", vmSpec.Name, compute.CachingTypesReadOnly, vmSpec.OSDisk.Caching)
+		}
+		storageProfile.OsDisk.Caching = compute.CachingTypesReadOnly
+		storageProfile.OsDisk.DiffDiskSettings = &compute.DiffDiskSettings{
+			Option: compute.DiffDiskOptions(vmSpec.OSDisk.DiffDiskSettings.Option),
+		}
+	}
+
+	imageRef, err := GenerateImageReference(vmSpec.Image)
 	if err != nil {
 		return nil, err
 	}
@@ -303,18 +663,218 @@ func generateStorageProfile(vmSpec Spec) (*compute.StorageProfile, error) {
 	return storageProfile, nil
 }
 
-// generateImageReference generates a pointer to a compute.ImageReference which can utilized for VM creation.
-func generateImageReference(image infrav1.Image) (*compute.ImageReference, error) {
+// validateOSDiskStorageAccountType checks that a storage account type is known and supported for OS disks, which
+// cannot be Ultra SSDs. An empty storage account type leaves the choice to Azure.
+func validateOSDiskStorageAccountType(storageAccountType string) error {
+	if storageAccountType == "" {
+		return nil
+	}
+	for _, known := range compute.PossibleStorageAccountTypesValues() {
+		if string(known) != storageAccountType {
+			continue
+		}
+		if known == compute.StorageAccountTypesUltraSSDLRS {
+			return errors.Errorf("storage account type %s is not supported for OS disks", storageAccountType)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown storage account type %q", storageAccountType)
+}
+
+// validateCaching checks that the host caching of a disk, if any, is one of the compute API.
+func validateCaching(caching string) error {
+	if caching == "" {
+		return nil
+	}
+	for _, known := range compute.PossibleCachingTypesValues() {
+		if string(known) == caching {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown caching %q", caching)
+}
+
+// validateWriteAccelerator checks that write accelerator, if enabled on a data disk, is enabled on a premium disk
+// without read-write caching, the only disks Azure supports it on.
+func validateWriteAccelerator(disk infrav1.DataDisk) error {
+	if !to.Bool(disk.WriteAcceleratorEnabled) {
+		return nil
+	}
+	if disk.ManagedDisk == nil || compute.StorageAccountTypes(disk.ManagedDisk.StorageAccountType) != compute.StorageAccountTypesPremiumLRS {
+		return errors.Errorf("write accelerator requires storage account type %s", compute.StorageAccountTypesPremiumLRS)
+	}
+	if compute.CachingTypes(disk.Caching) == compute.CachingTypesReadWrite {
+		return errors.Errorf("write accelerator does not support caching %s", compute.CachingTypesReadWrite)
+	}
+	return nil
+}
+
+// generateDiskEncryptionSet returns the disk encryption set that encrypts a managed disk with a customer-managed
+// key, or nil if the disk is encrypted with a platform-managed key.
+func generateDiskEncryptionSet(managedDisk *infrav1.ManagedDisk) *compute.DiskEncryptionSetParameters {
+	if managedDisk == nil || managedDisk.DiskEncryptionSet == nil {
+		return nil
+	}
+	return &compute.DiskEncryptionSetParameters{ID: to.StringPtr(managedDisk.DiskEncryptionSet.ID)}
+}
+
+// generateSecurityProfile enables encryption at host on a virtual machine that requests it. It returns nil for other
+// virtual machines, which leaves encryption at host disabled.
+func generateSecurityProfile(vmSpec Spec) *compute.SecurityProfile {
+	if !to.Bool(vmSpec.EncryptionAtHost) {
+		return nil
+	}
+	return &compute.SecurityProfile{EncryptionAtHost: to.BoolPtr(true)}
+}
+
+// generateAdditionalCapabilities enables Ultra SSD on a virtual machine with Ultra SSD data disks, which Azure only
+// supports for virtual machines in an availability zone. It returns nil for other virtual machines.
+func generateAdditionalCapabilities(vmSpec Spec) (*compute.AdditionalCapabilities, error) {
+	for _, disk := range vmSpec.DataDisks {
+		if disk.ManagedDisk == nil || compute.StorageAccountTypes(disk.ManagedDisk.StorageAccountType) != compute.StorageAccountTypesUltraSSDLRS {
+			continue
+		}
+		if vmSpec.Zone == "" {
+			return nil, errors.Errorf("data disk %s of vm %s is an Ultra SSD, which requires an availability zone", disk.NameSuffix, vmSpec.Name)
+		}
+		return &compute.AdditionalCapabilities{UltraSSDEnabled: to.BoolPtr(true)}, nil
+	}
+	return nil, nil
+}
+
+// generateDiagnosticsProfile generates the diagnostics profile of a virtual machine. Boot diagnostics are stored in
+// a storage account managed by Azure unless they are disabled or a user-managed storage account is provided.
+func generateDiagnosticsProfile(diagnostics *infrav1.Diagnostics) (*compute.DiagnosticsProfile, error) {
+	bootDiagnostics := &infrav1.BootDiagnostics{}
+	if diagnostics != nil && diagnostics.Boot != nil {
+		bootDiagnostics = diagnostics.Boot
+	}
+
+	switch bootDiagnostics.StorageAccountType {
+	case infrav1.DisabledBootDiagnosticsStorage:
+		return &compute.DiagnosticsProfile{
+			BootDiagnostics: &compute.BootDiagnostics{Enabled: to.BoolPtr(false)},
+		}, nil
+	case infrav1.UserManagedBootDiagnosticsStorage:
+		if bootDiagnostics.StorageAccountURI == "" {
+			return nil, errors.New("user-managed boot diagnostics require a storage account URI")
+		}
+		return &compute.DiagnosticsProfile{
+			BootDiagnostics: &compute.BootDiagnostics{
+				Enabled:    to.BoolPtr(true),
+				StorageURI: to.StringPtr(bootDiagnostics.StorageAccountURI),
+			},
+		}, nil
+	case "", infrav1.ManagedBootDiagnosticsStorage:
+		if bootDiagnostics.StorageAccountURI != "" {
+			return nil, errors.New("managed boot diagnostics cannot have a storage account URI")
+		}
+		// Without a storage URI, Azure stores boot diagnostics in a managed storage account.
+		return &compute.DiagnosticsProfile{
+			BootDiagnostics: &compute.BootDiagnostics{Enabled: to.BoolPtr(true)},
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown boot diagnostics storage account type %s", bootDiagnostics.StorageAccountType)
+	}
+}
+
+// generateIdentity generates the identity block of a virtual machine from its system-assigned identity and the IDs of
+// its user-assigned identities. It returns nil for a virtual machine without identities.
+func generateIdentity(identity infrav1.VMIdentity, userAssignedIdentities []string) *compute.VirtualMachineIdentity {
+	systemAssigned := identity == infrav1.VMIdentitySystemAssigned
+	if !systemAssigned && len(userAssignedIdentities) == 0 {
+		return nil
+	}
+
+	if len(userAssignedIdentities) == 0 {
+		return &compute.VirtualMachineIdentity{Type: compute.ResourceIdentityTypeSystemAssigned}
+	}
+
+	vmIdentity := &compute.VirtualMachineIdentity{
+		Type:                   compute.ResourceIdentityTypeUserAssigned,
+		UserAssignedIdentities: make(map[string]*compute.VirtualMachineIdentityUserAssignedIdentitiesValue, len(userAssignedIdentities)),
+	}
+	if systemAssigned {
+		vmIdentity.Type = compute.ResourceIdentityTypeSystemAssignedUserAssigned
+	}
+	for _, id := range userAssignedIdentities {
+		vmIdentity.UserAssignedIdentities[id] = &compute.VirtualMachineIdentityUserAssignedIdentitiesValue{}
+	}
+	return vmIdentity
+}
+
+// applySpotVMOptions sets the priority, eviction policy and billing profile of a Spot virtual machine.
+// Virtual machines without Spot options keep the regular priority and no billing profile.
+func applySpotVMOptions(props *compute.VirtualMachineProperties, options *infrav1.SpotVMOptions) error {
+	settings, err := GenerateSpotVMSettings(options)
+	if err != nil || settings == nil {
+		return err
+	}
+	props.Priority = settings.Priority
+	props.EvictionPolicy = settings.EvictionPolicy
+	props.BillingProfile = settings.BillingProfile
+	return nil
+}
+
+// SpotVMSettings are the priority, eviction policy and billing profile of Spot virtual machines.
+type SpotVMSettings struct {
+	Priority       compute.VirtualMachinePriorityTypes
+	EvictionPolicy compute.VirtualMachineEvictionPolicyTypes
+	BillingProfile *compute.BillingProfile
+}
+
+// GenerateSpotVMSettings generates the settings of Spot virtual machines, or of the instances of a Spot scale set,
+// from their Spot options. It returns nil without Spot options.
+func GenerateSpotVMSettings(options *infrav1.SpotVMOptions) (*SpotVMSettings, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	// -1 pays up to the on-demand price, it is also the Azure default.
+	maxPrice := -1.0
+	if options.MaxPrice != nil {
+		var err error
+		maxPrice, err = strconv.ParseFloat(options.MaxPrice.AsDec().String(), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid max price %s of spot vm", options.MaxPrice.String())
+		}
+		if maxPrice <= 0 && maxPrice != -1 {
+			return nil, errors.Errorf("invalid max price %s of spot vm, it has to be greater than 0 or -1", options.MaxPrice.String())
+		}
+	}
+
+	evictionPolicy := compute.Deallocate
+	if options.EvictionPolicy != "" {
+		evictionPolicy = compute.VirtualMachineEvictionPolicyTypes(options.EvictionPolicy)
+	}
+
+	return &SpotVMSettings{
+		Priority:       compute.Low,
+		EvictionPolicy: evictionPolicy,
+		BillingProfile: &compute.BillingProfile{MaxPrice: to.Float64Ptr(maxPrice)},
+	}, nil
+}
+
+// GenerateImageReference generates a pointer to a compute.ImageReference which can utilized for VM or scale set
+// creation.
+func GenerateImageReference(image infrav1.Image) (*compute.ImageReference, error) {
 	imageRef := &compute.ImageReference{}
 
+	if err := validateImageSource(image); err != nil {
+		return nil, err
+	}
+
 	if image.ID != nil {
 		imageRef.ID = image.ID
 		// return early if an image ID is provided
 		return imageRef, nil
 	}
 
-	imageID, err := generateSIGImageID(image)
-	if err == nil {
+	if isSIGImage(image) {
+		imageID, err := generateSIGImageID(image)
+		if err != nil {
+			return nil, err
+		}
 		imageRef.ID = to.StringPtr(imageID)
 		// return early if an image in a shared image gallery is provided
 		return imageRef, nil
@@ -324,7 +884,32 @@ func generateImageReference(image infrav1.Image) (*compute.ImageReference, error
 	return generateImagePlan(image)
 }
 
+// validateImageSource checks that an image is specified by exactly one of an image ID, a Shared Image Gallery image
+// or an Azure Marketplace image.
+func validateImageSource(image infrav1.Image) error {
+	sources := 0
+	if image.ID != nil {
+		sources++
+	}
+	if isSIGImage(image) {
+		sources++
+	}
+	if image.Publisher != nil || image.Offer != nil || image.SKU != nil {
+		sources++
+	}
+	if sources > 1 {
+		return errors.New("Image must be specified by only one of an image ID, a Shared Image Gallery image or an Azure Marketplace image")
+	}
+	return nil
+}
+
+// isSIGImage returns true if any of the Shared Image Gallery fields of an image is set.
+func isSIGImage(image infrav1.Image) bool {
+	return image.SubscriptionID != nil || image.ResourceGroup != nil || image.Gallery != nil || image.Name != nil
+}
+
 // generateSIGImageID generates the resource ID for an image stored in an Azure Shared Image Gallery.
+// The ID of the latest version of an image is the ID of the image definition.
 func generateSIGImageID(image infrav1.Image) (string, error) {
 	if image.SubscriptionID == nil {
 		return "", errors.New("Image subscription ID cannot be nil when specifying an image from an Azure Shared Image Gallery")
@@ -342,7 +927,24 @@ func generateSIGImageID(image infrav1.Image) (string, error) {
 		return "", errors.New("Image version cannot be nil when specifying an image from an Azure Shared Image Gallery")
 	}
 
-	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s/versions/%s", *image.SubscriptionID, *image.ResourceGroup, *image.Gallery, *image.Name, *image.Version), nil
+	imageID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/galleries/%s/images/%s", *image.SubscriptionID, *image.ResourceGroup, *image.Gallery, *image.Name)
+	if *image.Version == azure.LatestVersion {
+		return imageID, nil
+	}
+	return fmt.Sprintf("%s/versions/%s", imageID, *image.Version), nil
+}
+
+// GenerateImagePlanReference generates the purchase plan of a virtual machine or scale set using a third-party Azure
+// Marketplace image. It returns nil for images without a purchase plan.
+func GenerateImagePlanReference(image infrav1.Image) *compute.Plan {
+	if image.Plan == nil {
+		return nil
+	}
+	return &compute.Plan{
+		Publisher: to.StringPtr(image.Plan.Publisher),
+		Product:   to.StringPtr(image.Plan.Offer),
+		Name:      to.StringPtr(image.Plan.Name),
+	}
 }
 
 // generateImagePlan generates an image reference based on the image spec's Publisher, Offer, SKU and Version
@@ -17,16 +17,30 @@ limitations under the License.
 package virtualmachines
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/networkinterfaces/mock_networkinterfaces"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips/mock_publicips"
@@ -35,6 +49,17 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
+// testSSHPublicKey is an SSH public key in the authorized_keys format.
+const testSSHPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIEQX2H+D22BLq8O54+S9vDRF4XTMWeqR4XjQqN1+MoBo"
+
+// testFuture is the future of the creation of the virtual machine of the tests.
+var testFuture = &infrav1.Future{
+	Type:          infrav1.PutFuture,
+	ResourceGroup: "my-rg",
+	Name:          "azure-test1",
+	Data:          `{"method":"PUT"}`,
+}
+
 func TestCreateVM(t *testing.T) {
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -49,6 +74,9 @@ func TestCreateVM(t *testing.T) {
 		name          string
 		machine       clusterv1.Machine
 		machineConfig *infrav1.AzureMachineSpec
+		sshKeyData    string
+		ppgID         string
+		secondaryNICs []string
 		azureCluster  *infrav1.AzureCluster
 		expect        func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder)
 		checkError    func(err error)
@@ -105,11 +133,311 @@ func TestCreateVM(t *testing.T) {
 			},
 			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
 				mnic.Get(gomock.Any(), gomock.Any(), gomock.Any())
-				m.CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+				m.CreateOrUpdateAsync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(testFuture, nil)
 			},
 			checkError: func(err error) {
-				if err != nil {
-					t.Fatalf("did not expect error: %v", err)
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+		},
+		{
+			name: "with additional tags of the cluster",
+			machine: clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"set": "node"},
+				},
+				Spec: clusterv1.MachineSpec{
+					Bootstrap: clusterv1.Bootstrap{
+						Data: to.StringPtr("bootstrap-data"),
+					},
+					Version: to.StringPtr("1.15.7"),
+				},
+			},
+			machineConfig: &infrav1.AzureMachineSpec{
+				VMSize:   "Standard_B2ms",
+				Location: "eastus",
+				Image: &infrav1.Image{
+					Publisher: to.StringPtr("test-publisher"),
+					Offer:     to.StringPtr("test-offer"),
+					SKU:       to.StringPtr("test-sku"),
+					Version:   to.StringPtr("1.0.0"),
+				},
+			},
+			azureCluster: &infrav1.AzureCluster{
+				Spec: infrav1.AzureClusterSpec{
+					AdditionalTags: infrav1.Tags{"custom": "value"},
+					NetworkSpec: infrav1.NetworkSpec{
+						Subnets: infrav1.Subnets{
+							&infrav1.SubnetSpec{
+								Name: "subnet-1",
+							},
+							&infrav1.SubnetSpec{},
+						},
+					},
+				},
+				Status: infrav1.AzureClusterStatus{
+					Network: infrav1.Network{
+						SecurityGroups: map[infrav1.SecurityGroupRole]infrav1.SecurityGroup{
+							infrav1.SecurityGroupControlPlane: {
+								ID: "1",
+							},
+							infrav1.SecurityGroupNode: {
+								ID: "2",
+							},
+						},
+						APIServerIP: infrav1.PublicIP{
+							DNSName: "azure-test-dns",
+						},
+					},
+				},
+			},
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
+				mnic.Get(gomock.Any(), gomock.Any(), gomock.Any())
+				m.CreateOrUpdateAsync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, _, _ string, vm compute.VirtualMachine) {
+						expected := map[string]string{
+							"custom": "value",
+							"Name":   "azure-test1",
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test1": "owned",
+							"sigs.k8s.io_cluster-api-provider-azure_role":          "node",
+						}
+						for k, v := range expected {
+							if value, ok := vm.Tags[k]; !ok || to.String(value) != v {
+								t.Errorf("expected tag %s=%s, got tags %v", k, v, converters.MapToTags(vm.Tags))
+							}
+						}
+					}).Return(testFuture, nil)
+			},
+			checkError: func(err error) {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+		},
+		{
+			name: "with image plan",
+			machine: clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"set": "node"},
+				},
+				Spec: clusterv1.MachineSpec{
+					Bootstrap: clusterv1.Bootstrap{
+						Data: to.StringPtr("bootstrap-data"),
+					},
+					Version: to.StringPtr("1.15.7"),
+				},
+			},
+			machineConfig: &infrav1.AzureMachineSpec{
+				VMSize:   "Standard_B2ms",
+				Location: "eastus",
+				Image: &infrav1.Image{
+					Publisher: to.StringPtr("test-publisher"),
+					Offer:     to.StringPtr("test-offer"),
+					SKU:       to.StringPtr("test-sku"),
+					Version:   to.StringPtr("1.0.0"),
+					Plan: &infrav1.ImagePlan{
+						Publisher: "test-publisher",
+						Offer:     "test-offer",
+						Name:      "test-sku",
+					},
+				},
+			},
+			azureCluster: &infrav1.AzureCluster{
+				Spec: infrav1.AzureClusterSpec{
+					NetworkSpec: infrav1.NetworkSpec{
+						Subnets: infrav1.Subnets{
+							&infrav1.SubnetSpec{
+								Name: "subnet-1",
+							},
+							&infrav1.SubnetSpec{},
+						},
+					},
+				},
+				Status: infrav1.AzureClusterStatus{
+					Network: infrav1.Network{
+						SecurityGroups: map[infrav1.SecurityGroupRole]infrav1.SecurityGroup{
+							infrav1.SecurityGroupControlPlane: {
+								ID: "1",
+							},
+							infrav1.SecurityGroupNode: {
+								ID: "2",
+							},
+						},
+						APIServerIP: infrav1.PublicIP{
+							DNSName: "azure-test-dns",
+						},
+					},
+				},
+			},
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
+				mnic.Get(gomock.Any(), gomock.Any(), gomock.Any())
+				m.CreateOrUpdateAsync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, _, _ string, vm compute.VirtualMachine) {
+						expected := &compute.Plan{
+							Publisher: to.StringPtr("test-publisher"),
+							Product:   to.StringPtr("test-offer"),
+							Name:      to.StringPtr("test-sku"),
+						}
+						if !reflect.DeepEqual(vm.Plan, expected) {
+							t.Errorf("expected plan %+v, got %+v", expected, vm.Plan)
+						}
+					}).Return(testFuture, nil)
+			},
+			checkError: func(err error) {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+		},
+		{
+			name: "with an ssh public key",
+			machine: clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"set": "node"},
+				},
+				Spec: clusterv1.MachineSpec{
+					Bootstrap: clusterv1.Bootstrap{
+						Data: to.StringPtr("bootstrap-data"),
+					},
+					Version: to.StringPtr("1.15.7"),
+				},
+			},
+			machineConfig: &infrav1.AzureMachineSpec{
+				VMSize:   "Standard_B2ms",
+				Location: "eastus",
+				Image:    &infrav1.Image{ID: to.StringPtr("my-image-id")},
+			},
+			sshKeyData:   testSSHPublicKey,
+			azureCluster: &infrav1.AzureCluster{},
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
+				mnic.Get(gomock.Any(), gomock.Any(), gomock.Any())
+				m.CreateOrUpdateAsync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, _, _ string, vm compute.VirtualMachine) {
+						expected := &[]compute.SSHPublicKey{
+							{
+								Path:    to.StringPtr("/home/capi/.ssh/authorized_keys"),
+								KeyData: to.StringPtr(testSSHPublicKey),
+							},
+						}
+						if !reflect.DeepEqual(vm.OsProfile.LinuxConfiguration.SSH.PublicKeys, expected) {
+							t.Errorf("expected ssh public keys %+v, got %+v", expected, vm.OsProfile.LinuxConfiguration.SSH.PublicKeys)
+						}
+					}).Return(testFuture, nil)
+			},
+			checkError: func(err error) {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+		},
+		{
+			name: "in a proximity placement group",
+			machine: clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"set": "node"},
+				},
+				Spec: clusterv1.MachineSpec{
+					Bootstrap: clusterv1.Bootstrap{
+						Data: to.StringPtr("bootstrap-data"),
+					},
+					Version: to.StringPtr("1.15.7"),
+				},
+			},
+			machineConfig: &infrav1.AzureMachineSpec{
+				VMSize:   "Standard_B2ms",
+				Location: "eastus",
+				Image:    &infrav1.Image{ID: to.StringPtr("my-image-id")},
+			},
+			ppgID:        "my-ppg-id",
+			azureCluster: &infrav1.AzureCluster{},
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
+				mnic.Get(gomock.Any(), gomock.Any(), gomock.Any())
+				m.CreateOrUpdateAsync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, _, _ string, vm compute.VirtualMachine) {
+						expected := &compute.SubResource{ID: to.StringPtr("my-ppg-id")}
+						if !reflect.DeepEqual(vm.ProximityPlacementGroup, expected) {
+							t.Errorf("expected proximity placement group %+v, got %+v", expected, vm.ProximityPlacementGroup)
+						}
+					}).Return(testFuture, nil)
+			},
+			checkError: func(err error) {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+		},
+		{
+			name: "with a secondary network interface",
+			machine: clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"set": "node"},
+				},
+				Spec: clusterv1.MachineSpec{
+					Bootstrap: clusterv1.Bootstrap{
+						Data: to.StringPtr("bootstrap-data"),
+					},
+					Version: to.StringPtr("1.15.7"),
+				},
+			},
+			machineConfig: &infrav1.AzureMachineSpec{
+				VMSize:   "Standard_B2ms",
+				Location: "eastus",
+				Image:    &infrav1.Image{ID: to.StringPtr("my-image-id")},
+			},
+			secondaryNICs: []string{"test-nic-1"},
+			azureCluster:  &infrav1.AzureCluster{},
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
+				mnic.Get(gomock.Any(), gomock.Any(), "test-nic").Return(network.Interface{ID: to.StringPtr("test-nic-id")}, nil)
+				mnic.Get(gomock.Any(), gomock.Any(), "test-nic-1").Return(network.Interface{ID: to.StringPtr("test-nic-1-id")}, nil)
+				m.CreateOrUpdateAsync(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Do(func(_ context.Context, _, _ string, vm compute.VirtualMachine) {
+						expected := &[]compute.NetworkInterfaceReference{
+							{
+								ID:                                  to.StringPtr("test-nic-id"),
+								NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{Primary: to.BoolPtr(true)},
+							},
+							{
+								ID:                                  to.StringPtr("test-nic-1-id"),
+								NetworkInterfaceReferenceProperties: &compute.NetworkInterfaceReferenceProperties{Primary: to.BoolPtr(false)},
+							},
+						}
+						if !reflect.DeepEqual(vm.NetworkProfile.NetworkInterfaces, expected) {
+							t.Errorf("expected network interfaces %+v, got %+v", expected, vm.NetworkProfile.NetworkInterfaces)
+						}
+					}).Return(testFuture, nil)
+			},
+			checkError: func(err error) {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+		},
+		{
+			name: "with an invalid ssh public key",
+			machine: clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"set": "node"},
+				},
+				Spec: clusterv1.MachineSpec{
+					Bootstrap: clusterv1.Bootstrap{
+						Data: to.StringPtr("bootstrap-data"),
+					},
+					Version: to.StringPtr("1.15.7"),
+				},
+			},
+			machineConfig: &infrav1.AzureMachineSpec{
+				VMSize:   "Standard_B2ms",
+				Location: "eastus",
+				Image:    &infrav1.Image{ID: to.StringPtr("my-image-id")},
+			},
+			sshKeyData:   "fake-key",
+			azureCluster: &infrav1.AzureCluster{},
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder, mnic *mock_networkinterfaces.MockClientMockRecorder, mpip *mock_publicips.MockClientMockRecorder) {
+			},
+			checkError: func(err error) {
+				if err == nil || err.Error() != "invalid ssh public key of vm azure-test1: ssh: no key found" {
+					t.Fatalf("expected an invalid ssh public key error, got %v", err)
 				}
 			},
 		},
@@ -118,6 +446,7 @@ func TestCreateVM(t *testing.T) {
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			vmMock := mock_virtualmachines.NewMockClient(mockCtrl)
 			interfaceMock := mock_networkinterfaces.NewMockClient(mockCtrl)
 			publicIPMock := mock_publicips.NewMockClient(mockCtrl)
@@ -192,17 +521,1395 @@ func TestCreateVM(t *testing.T) {
 				PublicIPsClient:  publicIPMock,
 			}
 
+			sshKeyData := tc.sshKeyData
+			if sshKeyData == "" {
+				sshKeyData = testSSHPublicKey
+			}
 			vmSpec := &Spec{
 				Name:       machineScope.Name(),
 				NICName:    "test-nic",
-				SSHKeyData: "fake-key",
+				SSHKeyData: sshKeyData,
 				Size:       machineScope.AzureMachine.Spec.VMSize,
+				OSType:     machineScope.AzureMachine.Spec.OSType,
 				OSDisk:     machineScope.AzureMachine.Spec.OSDisk,
 				Image:      *machineScope.AzureMachine.Spec.Image,
 				CustomData: *machineScope.Machine.Spec.Bootstrap.Data,
+
+				SecondaryNICNames:         tc.secondaryNICs,
+				ProximityPlacementGroupID: tc.ppgID,
 			}
 			err = s.Reconcile(context.TODO(), vmSpec)
 			tc.checkError(err)
+			if azure.IsOperationNotDoneError(err) && !reflect.DeepEqual(machineScope.GetLongRunningOperationState(), testFuture) {
+				t.Errorf("expected the future of the creation of the vm to be persisted, got %+v", machineScope.GetLongRunningOperationState())
+			}
 		})
 	}
 }
+
+func TestReconcileVMPollsFuture(t *testing.T) {
+	testcases := []struct {
+		name           string
+		expect         func(m *mock_virtualmachines.MockClientMockRecorder)
+		checkError     func(err error)
+		expectedFuture *infrav1.Future
+	}{
+		{
+			name: "creation in progress",
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.GetResultIfDone(gomock.Any(), testFuture).Return(compute.VirtualMachine{}, azure.NewOperationNotDoneError(testFuture))
+			},
+			checkError: func(err error) {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected the creation of the vm to be in progress, got %v", err)
+				}
+			},
+			expectedFuture: testFuture,
+		},
+		{
+			name: "creation completed",
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.GetResultIfDone(gomock.Any(), testFuture).Return(compute.VirtualMachine{Name: to.StringPtr("azure-test1")}, nil)
+			},
+			checkError: func(err error) {
+				if err != nil {
+					t.Fatalf("did not expect error: %v", err)
+				}
+			},
+		},
+		{
+			name: "creation failed",
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.GetResultIfDone(gomock.Any(), testFuture).Return(compute.VirtualMachine{}, errors.New("OSProvisioningTimedOut"))
+			},
+			checkError: func(err error) {
+				if err == nil || err.Error() != "cannot create vm: OSProvisioningTimedOut" {
+					t.Fatalf("expected the creation of the vm to fail, got %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			// The virtual machine is not built again, so no other call is expected.
+			vmMock := mock_virtualmachines.NewMockClient(mockCtrl)
+			tc.expect(vmMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test1"}}
+			machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "test1"}}
+			azureCluster := &infrav1.AzureCluster{Spec: infrav1.AzureClusterSpec{ResourceGroup: "my-rg"}}
+			azureMachine := &infrav1.AzureMachine{
+				ObjectMeta: metav1.ObjectMeta{Name: "azure-test1"},
+				Status:     infrav1.AzureMachineStatus{LongRunningOperationState: testFuture},
+			}
+			client := fake.NewFakeClient(cluster, machine)
+
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:       client,
+				Cluster:      cluster,
+				AzureCluster: azureCluster,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+			machineScope, err := scope.NewMachineScope(scope.MachineScopeParams{
+				Client:       client,
+				Cluster:      cluster,
+				Machine:      machine,
+				AzureCluster: azureCluster,
+				AzureMachine: azureMachine,
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:        clusterScope,
+				MachineScope: machineScope,
+				Client:       vmMock,
+			}
+			err = s.Reconcile(context.TODO(), &Spec{Name: "azure-test1"})
+			tc.checkError(err)
+			if got := machineScope.GetLongRunningOperationState(); !reflect.DeepEqual(got, tc.expectedFuture) {
+				t.Errorf("expected future %+v, got %+v", tc.expectedFuture, got)
+			}
+		})
+	}
+}
+
+func TestListClusterVMs(t *testing.T) {
+	owned := map[string]*string{"sigs.k8s.io_cluster-api-provider-azure_cluster_test1": to.StringPtr("owned")}
+	running := &compute.VirtualMachineProperties{
+		InstanceView: &compute.VirtualMachineInstanceView{
+			Statuses: &[]compute.InstanceViewStatus{
+				{Code: to.StringPtr("ProvisioningState/succeeded")},
+				{Code: to.StringPtr("PowerState/running")},
+			},
+		},
+	}
+
+	testcases := []struct {
+		name          string
+		expect        func(m *mock_virtualmachines.MockClientMockRecorder)
+		expectedVMs   []ClusterVM
+		expectedError string
+	}{
+		{
+			name: "only the vms owned by the cluster are returned",
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.ListStatus(gomock.Any(), "my-rg").Return([]compute.VirtualMachine{
+					{Name: to.StringPtr("owned-running"), Tags: owned, VirtualMachineProperties: running},
+					{Name: to.StringPtr("unowned"), Tags: map[string]*string{"foo": to.StringPtr("bar")}, VirtualMachineProperties: running},
+					{Name: to.StringPtr("other-cluster"), Tags: map[string]*string{
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test2": to.StringPtr("owned"),
+					}},
+					{Name: to.StringPtr("owned-creating"), Tags: owned, VirtualMachineProperties: &compute.VirtualMachineProperties{
+						InstanceView: &compute.VirtualMachineInstanceView{
+							Statuses: &[]compute.InstanceViewStatus{{Code: to.StringPtr("ProvisioningState/creating")}},
+						},
+					}},
+				}, nil)
+			},
+			expectedVMs: []ClusterVM{
+				{Name: "owned-running", PowerState: "running"},
+				{Name: "owned-creating"},
+			},
+		},
+		{
+			name: "vm without instance view",
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.ListStatus(gomock.Any(), "my-rg").Return([]compute.VirtualMachine{
+					{Name: to.StringPtr("owned-no-status"), Tags: owned},
+				}, nil)
+			},
+			expectedVMs: []ClusterVM{
+				{Name: "owned-no-status"},
+			},
+		},
+		{
+			name: "fail to list vms",
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.ListStatus(gomock.Any(), "my-rg").
+					Return(nil, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+			expectedError: "failed to list vms in resource group my-rg: #: Internal Server Error: StatusCode=500",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			vmMock := mock_virtualmachines.NewMockClient(mockCtrl)
+			tc.expect(vmMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test1"}}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:       fake.NewFakeClient(cluster),
+				Cluster:      cluster,
+				AzureCluster: &infrav1.AzureCluster{Spec: infrav1.AzureClusterSpec{ResourceGroup: "my-rg"}},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: vmMock,
+			}
+			vms, err := s.ListClusterVMs(context.TODO())
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(vms, tc.expectedVMs) {
+				t.Errorf("expected vms %+v, got %+v", tc.expectedVMs, vms)
+			}
+		})
+	}
+}
+
+func TestDeallocateAndStartVM(t *testing.T) {
+	testcases := []struct {
+		name          string
+		call          func(s *Service) error
+		expect        func(m *mock_virtualmachines.MockClientMockRecorder)
+		expectedError string
+	}{
+		{
+			name:   "deallocate vm",
+			call:   func(s *Service) error { return s.Deallocate(context.TODO(), &Spec{Name: "my-vm"}) },
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) { m.Deallocate(gomock.Any(), "my-rg", "my-vm") },
+		},
+		{
+			name: "fail to deallocate vm",
+			call: func(s *Service) error { return s.Deallocate(context.TODO(), &Spec{Name: "my-vm"}) },
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.Deallocate(gomock.Any(), "my-rg", "my-vm").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+			expectedError: "failed to deallocate vm my-vm in resource group my-rg: #: Internal Server Error: StatusCode=500",
+		},
+		{
+			name:   "start vm",
+			call:   func(s *Service) error { return s.Start(context.TODO(), &Spec{Name: "my-vm"}) },
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) { m.Start(gomock.Any(), "my-rg", "my-vm") },
+		},
+		{
+			name: "fail to start vm",
+			call: func(s *Service) error { return s.Start(context.TODO(), &Spec{Name: "my-vm"}) },
+			expect: func(m *mock_virtualmachines.MockClientMockRecorder) {
+				m.Start(gomock.Any(), "my-rg", "my-vm").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+			expectedError: "failed to start vm my-vm in resource group my-rg: #: Internal Server Error: StatusCode=500",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			vmMock := mock_virtualmachines.NewMockClient(mockCtrl)
+			tc.expect(vmMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test1"}}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:       fake.NewFakeClient(cluster),
+				Cluster:      cluster,
+				AzureCluster: &infrav1.AzureCluster{Spec: infrav1.AzureClusterSpec{ResourceGroup: "my-rg"}},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: vmMock,
+			}
+			err = tc.call(s)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPowerState(t *testing.T) {
+	testcases := []struct {
+		name     string
+		statuses *[]compute.InstanceViewStatus
+		expected infrav1.VMPowerState
+	}{
+		{
+			name: "running",
+			statuses: &[]compute.InstanceViewStatus{
+				{Code: to.StringPtr("ProvisioningState/succeeded")},
+				{Code: to.StringPtr("PowerState/running")},
+			},
+			expected: infrav1.VMPowerStateRunning,
+		},
+		{
+			name: "deallocated",
+			statuses: &[]compute.InstanceViewStatus{
+				{Code: to.StringPtr("ProvisioningState/succeeded")},
+				{Code: to.StringPtr("PowerState/deallocated")},
+			},
+			expected: infrav1.VMPowerStateDeallocated,
+		},
+		{
+			name: "stopped",
+			statuses: &[]compute.InstanceViewStatus{
+				{Code: to.StringPtr("PowerState/stopped")},
+			},
+			expected: infrav1.VMPowerStateStopped,
+		},
+		{
+			name: "no power state while the vm is created",
+			statuses: &[]compute.InstanceViewStatus{
+				{Code: to.StringPtr("ProvisioningState/creating")},
+			},
+		},
+		{
+			name: "no statuses",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := powerState(compute.VirtualMachineInstanceView{Statuses: tc.statuses}); got != tc.expected {
+				t.Errorf("expected power state %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGenerateOSProfile(t *testing.T) {
+	testcases := []struct {
+		name                  string
+		vmSpec                Spec
+		expectedComputerName  string
+		expectedAdminUsername string
+		expectedError         string
+	}{
+		{
+			name:                 "linux",
+			vmSpec:               Spec{Name: "my-cluster-md-0-abcde", OSType: infrav1.LinuxOSType, SSHKeyData: testSSHPublicKey},
+			expectedComputerName: "my-cluster-md-0-abcde",
+		},
+		{
+			name:                  "linux with a custom admin username",
+			vmSpec:                Spec{Name: "my-cluster-md-0-abcde", AdminUsername: "azureuser", SSHKeyData: testSSHPublicKey},
+			expectedComputerName:  "my-cluster-md-0-abcde",
+			expectedAdminUsername: "azureuser",
+		},
+		{
+			name:                 "linux by default",
+			vmSpec:               Spec{Name: "my-cluster-md-0-abcde", SSHKeyData: testSSHPublicKey},
+			expectedComputerName: "my-cluster-md-0-abcde",
+		},
+		{
+			name:                 "linux with a sanitized computer name",
+			vmSpec:               Spec{Name: "my_cluster.md-0-abcde", SSHKeyData: testSSHPublicKey},
+			expectedComputerName: "my-cluster-md-0-abcde",
+		},
+		{
+			name:                 "linux with a truncated computer name",
+			vmSpec:               Spec{Name: "my-cluster-with-a-very-long-name-for-the-sake-of-the-test-md-0-abcde", SSHKeyData: testSSHPublicKey},
+			expectedComputerName: "my-cluster-with-a-very-long-name-for-the-sake-of-the-te-e67b3646",
+		},
+		{
+			name:                 "linux with a computer name override",
+			vmSpec:               Spec{Name: "my-cluster-md-0-abcde", ComputerName: "my-host", SSHKeyData: testSSHPublicKey},
+			expectedComputerName: "my-host",
+		},
+		{
+			name:                 "windows with a truncated computer name",
+			vmSpec:               Spec{Name: "my-cluster-md-0-abcde", OSType: infrav1.WindowsOSType},
+			expectedComputerName: "my-clu-69758505",
+		},
+		{
+			name:                 "windows with a computer name override",
+			vmSpec:               Spec{Name: "my-cluster-md-0-abcde", ComputerName: "win-host", OSType: infrav1.WindowsOSType},
+			expectedComputerName: "win-host",
+		},
+		{
+			name:          "unknown os type",
+			vmSpec:        Spec{Name: "my-cluster-md-0-abcde", OSType: "Plan9"},
+			expectedError: "unknown OS type Plan9 of vm my-cluster-md-0-abcde",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			osProfile, err := generateOSProfile(tc.vmSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if to.String(osProfile.ComputerName) != tc.expectedComputerName {
+				t.Errorf("expected computer name %s, got %s", tc.expectedComputerName, to.String(osProfile.ComputerName))
+			}
+			expectedAdminUsername := tc.expectedAdminUsername
+			if expectedAdminUsername == "" {
+				expectedAdminUsername = "capi"
+			}
+			if to.String(osProfile.AdminUsername) != expectedAdminUsername || to.String(osProfile.AdminPassword) == "" {
+				t.Errorf("expected the %s admin user with a password, got %+v", expectedAdminUsername, osProfile)
+			}
+			if tc.vmSpec.OSType == infrav1.WindowsOSType {
+				expected := &compute.WindowsConfiguration{
+					ProvisionVMAgent:       to.BoolPtr(true),
+					EnableAutomaticUpdates: to.BoolPtr(false),
+				}
+				if !reflect.DeepEqual(osProfile.WindowsConfiguration, expected) {
+					t.Errorf("expected windows configuration %+v, got %+v", expected, osProfile.WindowsConfiguration)
+				}
+				if osProfile.LinuxConfiguration != nil {
+					t.Errorf("expected no linux configuration, got %+v", osProfile.LinuxConfiguration)
+				}
+				return
+			}
+			if osProfile.WindowsConfiguration != nil {
+				t.Errorf("expected no windows configuration, got %+v", osProfile.WindowsConfiguration)
+			}
+			if osProfile.LinuxConfiguration == nil || to.String((*osProfile.LinuxConfiguration.SSH.PublicKeys)[0].KeyData) != testSSHPublicKey {
+				t.Errorf("expected a linux configuration authorizing the ssh public key, got %+v", osProfile.LinuxConfiguration)
+			}
+			expectedPath := "/home/" + expectedAdminUsername + "/.ssh/authorized_keys"
+			if path := to.String((*osProfile.LinuxConfiguration.SSH.PublicKeys)[0].Path); path != expectedPath {
+				t.Errorf("expected the ssh public key to be authorized at %s, got %s", expectedPath, path)
+			}
+		})
+	}
+}
+
+func TestGenerateCustomData(t *testing.T) {
+	// The bootstrap data of a control plane machine is mostly certificates and manifests, which compress well.
+	bootstrapData := []byte("#cloud-config\n" + strings.Repeat("write_files:\n- path: /etc/kubernetes/manifest.yaml\n", 2000))
+	// Random data does not compress.
+	randomData := make([]byte, 60*1024)
+	rand.New(rand.NewSource(0)).Read(randomData)
+
+	testcases := []struct {
+		name                string
+		vmSpec              Spec
+		expectCompressed    bool
+		expectedErrorPrefix string
+	}{
+		{
+			name:   "custom data under the limit is unchanged",
+			vmSpec: Spec{Name: "my-vm", CustomData: base64.StdEncoding.EncodeToString([]byte("#cloud-config\n"))},
+		},
+		{
+			name:             "custom data over the limit is compressed",
+			vmSpec:           Spec{Name: "my-vm", CustomData: base64.StdEncoding.EncodeToString(bootstrapData)},
+			expectCompressed: true,
+		},
+		{
+			name:                "custom data over the limit even when compressed",
+			vmSpec:              Spec{Name: "my-vm", CustomData: base64.StdEncoding.EncodeToString(randomData)},
+			expectedErrorPrefix: "custom data of vm my-vm is ",
+		},
+		{
+			name:                "custom data of a windows vm over the limit",
+			vmSpec:              Spec{Name: "my-vm", OSType: infrav1.WindowsOSType, CustomData: base64.StdEncoding.EncodeToString(bootstrapData)},
+			expectedErrorPrefix: "custom data of vm my-vm is 136020 bytes base64 encoded, more than the limit of 65536 bytes",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			customData, err := generateCustomData(tc.vmSpec)
+			if tc.expectedErrorPrefix != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.expectedErrorPrefix) {
+					t.Fatalf("expected error starting with %q, got %v", tc.expectedErrorPrefix, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if len(customData) > maxCustomDataLength {
+				t.Fatalf("expected custom data of at most %d bytes, got %d bytes", maxCustomDataLength, len(customData))
+			}
+			if !tc.expectCompressed {
+				if customData != tc.vmSpec.CustomData {
+					t.Errorf("expected custom data %q, got %q", tc.vmSpec.CustomData, customData)
+				}
+				return
+			}
+			compressed, err := base64.StdEncoding.DecodeString(customData)
+			if err != nil {
+				t.Fatalf("failed to decode custom data: %v", err)
+			}
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("expected gzip custom data: %v", err)
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("failed to decompress custom data: %v", err)
+			}
+			if !bytes.Equal(data, bootstrapData) {
+				t.Errorf("expected the decompressed custom data to be the bootstrap data")
+			}
+		})
+	}
+}
+
+func TestGenerateStorageProfile(t *testing.T) {
+	vmSpec := Spec{
+		Name: "my-vm",
+		Image: infrav1.Image{
+			Publisher: to.StringPtr("test-publisher"),
+			Offer:     to.StringPtr("test-offer"),
+			SKU:       to.StringPtr("test-sku"),
+			Version:   to.StringPtr("1.0.0"),
+		},
+		OSDisk: infrav1.OSDisk{
+			OSType:      "Linux",
+			DiskSizeGB:  30,
+			ManagedDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+		},
+		DataDisks: []infrav1.DataDisk{
+			{
+				NameSuffix:  "etcd",
+				DiskSizeGB:  256,
+				Lun:         0,
+				ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+			},
+			{
+				NameSuffix:  "data",
+				DiskSizeGB:  1024,
+				Lun:         1,
+				ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Standard_LRS"},
+			},
+		},
+	}
+
+	storageProfile, err := generateStorageProfile(vmSpec)
+	if err != nil {
+		t.Fatalf("got an unexpected error: %v", err)
+	}
+
+	expected := []compute.DataDisk{
+		{
+			Name:         to.StringPtr("my-vm_etcd"),
+			Lun:          to.Int32Ptr(0),
+			CreateOption: compute.DiskCreateOptionTypesEmpty,
+			DiskSizeGB:   to.Int32Ptr(256),
+			ManagedDisk:  &compute.ManagedDiskParameters{StorageAccountType: compute.StorageAccountTypesPremiumLRS},
+		},
+		{
+			Name:         to.StringPtr("my-vm_data"),
+			Lun:          to.Int32Ptr(1),
+			CreateOption: compute.DiskCreateOptionTypesEmpty,
+			DiskSizeGB:   to.Int32Ptr(1024),
+			ManagedDisk:  &compute.ManagedDiskParameters{StorageAccountType: compute.StorageAccountTypesStandardLRS},
+		},
+	}
+	if storageProfile.DataDisks == nil || !reflect.DeepEqual(*storageProfile.DataDisks, expected) {
+		t.Errorf("expected data disks %+v, got %+v", expected, storageProfile.DataDisks)
+	}
+}
+
+func TestGenerateStorageProfileExistingDataDisk(t *testing.T) {
+	diskID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/disks/shared"
+	vmSpec := Spec{
+		Name:   "my-vm",
+		Image:  infrav1.Image{ID: to.StringPtr("my-image")},
+		OSDisk: infrav1.OSDisk{OSType: "Linux"},
+		DataDisks: []infrav1.DataDisk{
+			{
+				NameSuffix: "etcd",
+				DiskSizeGB: 256,
+				Lun:        0,
+			},
+			{
+				NameSuffix:    "shared",
+				Lun:           1,
+				ManagedDiskID: diskID,
+			},
+			{
+				NameSuffix:    "premium",
+				Lun:           2,
+				ManagedDiskID: diskID + "-premium",
+				ManagedDisk:   &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+			},
+		},
+	}
+
+	storageProfile, err := generateStorageProfile(vmSpec)
+	if err != nil {
+		t.Fatalf("got an unexpected error: %v", err)
+	}
+
+	expected := []compute.DataDisk{
+		{
+			Name:         to.StringPtr("my-vm_etcd"),
+			Lun:          to.Int32Ptr(0),
+			CreateOption: compute.DiskCreateOptionTypesEmpty,
+			DiskSizeGB:   to.Int32Ptr(256),
+		},
+		{
+			Lun:          to.Int32Ptr(1),
+			CreateOption: compute.DiskCreateOptionTypesAttach,
+			ManagedDisk:  &compute.ManagedDiskParameters{ID: to.StringPtr(diskID)},
+		},
+		{
+			Lun:          to.Int32Ptr(2),
+			CreateOption: compute.DiskCreateOptionTypesAttach,
+			ManagedDisk: &compute.ManagedDiskParameters{
+				StorageAccountType: compute.StorageAccountTypesPremiumLRS,
+				ID:                 to.StringPtr(diskID + "-premium"),
+			},
+		},
+	}
+	if storageProfile.DataDisks == nil || !reflect.DeepEqual(*storageProfile.DataDisks, expected) {
+		t.Errorf("expected data disks %+v, got %+v", expected, storageProfile.DataDisks)
+	}
+}
+
+func TestGenerateStorageProfileOSDiskSize(t *testing.T) {
+	testcases := []struct {
+		name       string
+		diskSizeGB int32
+		expected   *int32
+	}{
+		{
+			name:       "custom OS disk size",
+			diskSizeGB: 128,
+			expected:   to.Int32Ptr(128),
+		},
+		{
+			name: "default OS disk size",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmSpec := Spec{
+				Name: "my-vm",
+				Image: infrav1.Image{
+					Publisher: to.StringPtr("test-publisher"),
+					Offer:     to.StringPtr("test-offer"),
+					SKU:       to.StringPtr("test-sku"),
+					Version:   to.StringPtr("1.0.0"),
+				},
+				OSDisk: infrav1.OSDisk{
+					OSType:      "Linux",
+					DiskSizeGB:  tc.diskSizeGB,
+					ManagedDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+				},
+			}
+			storageProfile, err := generateStorageProfile(vmSpec)
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(storageProfile.OsDisk.DiskSizeGB, tc.expected) {
+				t.Errorf("expected OS disk size %v, got %v", to.Int32(tc.expected), to.Int32(storageProfile.OsDisk.DiskSizeGB))
+			}
+		})
+	}
+}
+
+func TestGenerateStorageProfileEphemeralOSDisk(t *testing.T) {
+	testcases := []struct {
+		name                     string
+		osDisk                   infrav1.OSDisk
+		expectedDiffDiskSettings *compute.DiffDiskSettings
+		expectedCaching          compute.CachingTypes
+		expectedError            string
+	}{
+		{
+			name: "managed OS disk",
+			osDisk: infrav1.OSDisk{
+				OSType:      "Linux",
+				DiskSizeGB:  30,
+				ManagedDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+			},
+			expectedCaching: compute.CachingTypesReadWrite,
+		},
+		{
+			name: "ephemeral OS disk",
+			osDisk: infrav1.OSDisk{
+				OSType:           "Linux",
+				DiskSizeGB:       30,
+				ManagedDisk:      infrav1.ManagedDisk{StorageAccountType: "Standard_LRS"},
+				DiffDiskSettings: &infrav1.DiffDiskSettings{Option: "Local"},
+			},
+			expectedDiffDiskSettings: &compute.DiffDiskSettings{Option: compute.Local},
+			expectedCaching:          compute.CachingTypesReadOnly,
+		},
+		{
+			name: "ephemeral OS disk with premium storage",
+			osDisk: infrav1.OSDisk{
+				OSType:           "Linux",
+				DiskSizeGB:       30,
+				ManagedDisk:      infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+				DiffDiskSettings: &infrav1.DiffDiskSettings{Option: "Local"},
+			},
+			expectedError: "ephemeral OS disk of vm my-vm requires storage account type Standard_LRS, got Premium_LRS",
+		},
+		{
+			name: "ephemeral OS disk with read-write caching",
+			osDisk: infrav1.OSDisk{
+				OSType:           "Linux",
+				DiskSizeGB:       30,
+				ManagedDisk:      infrav1.ManagedDisk{StorageAccountType: "Standard_LRS"},
+				DiffDiskSettings: &infrav1.DiffDiskSettings{Option: "Local"},
+				Caching:          "ReadWrite",
+			},
+			expectedError: "ephemeral OS disk of vm my-vm requires caching ReadOnly, got ReadWrite",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmSpec := Spec{
+				Name: "my-vm",
+				Image: infrav1.Image{
+					Publisher: to.StringPtr("test-publisher"),
+					Offer:     to.StringPtr("test-offer"),
+					SKU:       to.StringPtr("test-sku"),
+					Version:   to.StringPtr("1.0.0"),
+				},
+				OSDisk: tc.osDisk,
+			}
+			storageProfile, err := generateStorageProfile(vmSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(storageProfile.OsDisk.DiffDiskSettings, tc.expectedDiffDiskSettings) {
+				t.Errorf("expected diff disk settings %+v, got %+v", tc.expectedDiffDiskSettings, storageProfile.OsDisk.DiffDiskSettings)
+			}
+			if storageProfile.OsDisk.Caching != tc.expectedCaching {
+				t.Errorf("expected caching %q, got %q", tc.expectedCaching, storageProfile.OsDisk.Caching)
+			}
+		})
+	}
+}
+
+func TestGenerateStorageProfileCaching(t *testing.T) {
+	testcases := []struct {
+		name                    string
+		caching                 string
+		expectedOSDiskCaching   compute.CachingTypes
+		expectedDataDiskCaching compute.CachingTypes
+		expectedError           string
+	}{
+		{
+			name:                  "default caching",
+			expectedOSDiskCaching: compute.CachingTypesReadWrite,
+		},
+		{
+			name:                    "no caching",
+			caching:                 "None",
+			expectedOSDiskCaching:   compute.CachingTypesNone,
+			expectedDataDiskCaching: compute.CachingTypesNone,
+		},
+		{
+			name:                    "read-only caching",
+			caching:                 "ReadOnly",
+			expectedOSDiskCaching:   compute.CachingTypesReadOnly,
+			expectedDataDiskCaching: compute.CachingTypesReadOnly,
+		},
+		{
+			name:                    "read-write caching",
+			caching:                 "ReadWrite",
+			expectedOSDiskCaching:   compute.CachingTypesReadWrite,
+			expectedDataDiskCaching: compute.CachingTypesReadWrite,
+		},
+		{
+			name:          "unknown caching",
+			caching:       "WriteBack",
+			expectedError: `invalid OS disk of vm my-vm: unknown caching "WriteBack"`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmSpec := Spec{
+				Name: "my-vm",
+				Image: infrav1.Image{
+					Publisher: to.StringPtr("test-publisher"),
+					Offer:     to.StringPtr("test-offer"),
+					SKU:       to.StringPtr("test-sku"),
+					Version:   to.StringPtr("1.0.0"),
+				},
+				OSDisk: infrav1.OSDisk{
+					OSType:      "Linux",
+					DiskSizeGB:  30,
+					ManagedDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+					Caching:     tc.caching,
+				},
+				DataDisks: []infrav1.DataDisk{{NameSuffix: "etcd", DiskSizeGB: 256, Caching: tc.caching}},
+			}
+			storageProfile, err := generateStorageProfile(vmSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if storageProfile.OsDisk.Caching != tc.expectedOSDiskCaching {
+				t.Errorf("expected OS disk caching %q, got %q", tc.expectedOSDiskCaching, storageProfile.OsDisk.Caching)
+			}
+			if caching := (*storageProfile.DataDisks)[0].Caching; caching != tc.expectedDataDiskCaching {
+				t.Errorf("expected data disk caching %q, got %q", tc.expectedDataDiskCaching, caching)
+			}
+		})
+	}
+}
+
+func TestGenerateStorageProfileWriteAccelerator(t *testing.T) {
+	testcases := []struct {
+		name                     string
+		dataDisk                 infrav1.DataDisk
+		expectedWriteAccelerator *bool
+		expectedError            string
+	}{
+		{
+			name:     "write accelerator disabled",
+			dataDisk: infrav1.DataDisk{NameSuffix: "log", DiskSizeGB: 512, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"}},
+		},
+		{
+			name: "write accelerator on a premium disk",
+			dataDisk: infrav1.DataDisk{
+				NameSuffix:              "log",
+				DiskSizeGB:              512,
+				ManagedDisk:             &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+				WriteAcceleratorEnabled: to.BoolPtr(true),
+			},
+			expectedWriteAccelerator: to.BoolPtr(true),
+		},
+		{
+			name: "write accelerator on a standard disk",
+			dataDisk: infrav1.DataDisk{
+				NameSuffix:              "log",
+				DiskSizeGB:              512,
+				ManagedDisk:             &infrav1.ManagedDisk{StorageAccountType: "StandardSSD_LRS"},
+				WriteAcceleratorEnabled: to.BoolPtr(true),
+			},
+			expectedError: "invalid data disk log of vm my-vm: write accelerator requires storage account type Premium_LRS",
+		},
+		{
+			name: "write accelerator with read-write caching",
+			dataDisk: infrav1.DataDisk{
+				NameSuffix:              "log",
+				DiskSizeGB:              512,
+				ManagedDisk:             &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+				Caching:                 "ReadWrite",
+				WriteAcceleratorEnabled: to.BoolPtr(true),
+			},
+			expectedError: "invalid data disk log of vm my-vm: write accelerator does not support caching ReadWrite",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmSpec := Spec{
+				Name: "my-vm",
+				Image: infrav1.Image{
+					Publisher: to.StringPtr("test-publisher"),
+					Offer:     to.StringPtr("test-offer"),
+					SKU:       to.StringPtr("test-sku"),
+					Version:   to.StringPtr("1.0.0"),
+				},
+				OSDisk: infrav1.OSDisk{
+					OSType:      "Linux",
+					DiskSizeGB:  30,
+					ManagedDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+				},
+				DataDisks: []infrav1.DataDisk{tc.dataDisk},
+			}
+			storageProfile, err := generateStorageProfile(vmSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if writeAccelerator := (*storageProfile.DataDisks)[0].WriteAcceleratorEnabled; !reflect.DeepEqual(writeAccelerator, tc.expectedWriteAccelerator) {
+				t.Errorf("expected write accelerator %v, got %v", to.Bool(tc.expectedWriteAccelerator), to.Bool(writeAccelerator))
+			}
+		})
+	}
+}
+
+func TestValidateOSDiskStorageAccountType(t *testing.T) {
+	testcases := []struct {
+		storageAccountType string
+		expectedError      string
+	}{
+		{storageAccountType: "Standard_LRS"},
+		{storageAccountType: "StandardSSD_LRS"},
+		{storageAccountType: "Premium_LRS"},
+		{
+			storageAccountType: "UltraSSD_LRS",
+			expectedError:      "storage account type UltraSSD_LRS is not supported for OS disks",
+		},
+		{
+			storageAccountType: "Premium_ZRS",
+			expectedError:      `unknown storage account type "Premium_ZRS"`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.storageAccountType, func(t *testing.T) {
+			err := validateOSDiskStorageAccountType(tc.storageAccountType)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateStorageProfileDiskEncryptionSet(t *testing.T) {
+	desID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/diskEncryptionSets/my-des"
+	testcases := []struct {
+		name             string
+		osDisk           infrav1.ManagedDisk
+		dataDisks        []infrav1.DataDisk
+		expectedOSDisk   *compute.DiskEncryptionSetParameters
+		expectedDataDisk *compute.DiskEncryptionSetParameters
+	}{
+		{
+			name:   "no disk encryption set",
+			osDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "etcd", DiskSizeGB: 256, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"}},
+			},
+		},
+		{
+			name:   "OS disk with a disk encryption set",
+			osDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS", DiskEncryptionSet: &infrav1.DiskEncryptionSetParameters{ID: desID}},
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "etcd", DiskSizeGB: 256, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"}},
+			},
+			expectedOSDisk: &compute.DiskEncryptionSetParameters{ID: to.StringPtr(desID)},
+		},
+		{
+			name:   "data disk with a disk encryption set",
+			osDisk: infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"},
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "etcd", DiskSizeGB: 256, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS", DiskEncryptionSet: &infrav1.DiskEncryptionSetParameters{ID: desID}}},
+			},
+			expectedDataDisk: &compute.DiskEncryptionSetParameters{ID: to.StringPtr(desID)},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			vmSpec := Spec{
+				Name:      "my-vm",
+				Image:     infrav1.Image{ID: to.StringPtr("my-image")},
+				OSDisk:    infrav1.OSDisk{OSType: "Linux", ManagedDisk: tc.osDisk},
+				DataDisks: tc.dataDisks,
+			}
+			storageProfile, err := generateStorageProfile(vmSpec)
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if got := storageProfile.OsDisk.ManagedDisk.DiskEncryptionSet; !reflect.DeepEqual(got, tc.expectedOSDisk) {
+				t.Errorf("expected OS disk encryption set %+v, got %+v", tc.expectedOSDisk, got)
+			}
+			if got := (*storageProfile.DataDisks)[0].ManagedDisk.DiskEncryptionSet; !reflect.DeepEqual(got, tc.expectedDataDisk) {
+				t.Errorf("expected data disk encryption set %+v, got %+v", tc.expectedDataDisk, got)
+			}
+		})
+	}
+}
+
+func TestGenerateSecurityProfile(t *testing.T) {
+	testcases := []struct {
+		name             string
+		encryptionAtHost *bool
+		expected         *compute.SecurityProfile
+	}{
+		{name: "encryption at host not set"},
+		{name: "encryption at host disabled", encryptionAtHost: to.BoolPtr(false)},
+		{
+			name:             "encryption at host enabled",
+			encryptionAtHost: to.BoolPtr(true),
+			expected:         &compute.SecurityProfile{EncryptionAtHost: to.BoolPtr(true)},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := generateSecurityProfile(Spec{Name: "my-vm", EncryptionAtHost: tc.encryptionAtHost})
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected security profile %+v, got %+v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestGenerateImageReference(t *testing.T) {
+	testcases := []struct {
+		name          string
+		image         infrav1.Image
+		expected      *compute.ImageReference
+		expectedError string
+	}{
+		{
+			name:  "image ID",
+			image: infrav1.Image{ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/images/my-image")},
+			expected: &compute.ImageReference{
+				ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/images/my-image"),
+			},
+		},
+		{
+			name: "image ID and marketplace image",
+			image: infrav1.Image{
+				ID:        to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/images/my-image"),
+				Publisher: to.StringPtr("test-publisher"),
+				Offer:     to.StringPtr("test-offer"),
+				SKU:       to.StringPtr("test-sku"),
+				Version:   to.StringPtr("latest"),
+			},
+			expectedError: "Image must be specified by only one of an image ID, a Shared Image Gallery image or an Azure Marketplace image",
+		},
+		{
+			name: "shared image gallery image version",
+			image: infrav1.Image{
+				SubscriptionID: to.StringPtr("123"),
+				ResourceGroup:  to.StringPtr("my-rg"),
+				Gallery:        to.StringPtr("my-gallery"),
+				Name:           to.StringPtr("my-image"),
+				Version:        to.StringPtr("1.0.0"),
+			},
+			expected: &compute.ImageReference{
+				ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/galleries/my-gallery/images/my-image/versions/1.0.0"),
+			},
+		},
+		{
+			name: "latest shared image gallery image version",
+			image: infrav1.Image{
+				SubscriptionID: to.StringPtr("123"),
+				ResourceGroup:  to.StringPtr("my-rg"),
+				Gallery:        to.StringPtr("my-gallery"),
+				Name:           to.StringPtr("my-image"),
+				Version:        to.StringPtr("latest"),
+			},
+			expected: &compute.ImageReference{
+				ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/galleries/my-gallery/images/my-image"),
+			},
+		},
+		{
+			name: "shared image gallery image without a gallery",
+			image: infrav1.Image{
+				SubscriptionID: to.StringPtr("123"),
+				ResourceGroup:  to.StringPtr("my-rg"),
+				Name:           to.StringPtr("my-image"),
+				Version:        to.StringPtr("1.0.0"),
+			},
+			expectedError: "Image gallery cannot be nil when specifying an image from an Azure Shared Image Gallery",
+		},
+		{
+			name: "marketplace image",
+			image: infrav1.Image{
+				Publisher: to.StringPtr("test-publisher"),
+				Offer:     to.StringPtr("test-offer"),
+				SKU:       to.StringPtr("test-sku"),
+				Version:   to.StringPtr("latest"),
+			},
+			expected: &compute.ImageReference{
+				Publisher: to.StringPtr("test-publisher"),
+				Offer:     to.StringPtr("test-offer"),
+				Sku:       to.StringPtr("test-sku"),
+				Version:   to.StringPtr("latest"),
+			},
+		},
+		{
+			name: "marketplace and shared image gallery image",
+			image: infrav1.Image{
+				Publisher:      to.StringPtr("test-publisher"),
+				Offer:          to.StringPtr("test-offer"),
+				SKU:            to.StringPtr("test-sku"),
+				SubscriptionID: to.StringPtr("123"),
+				ResourceGroup:  to.StringPtr("my-rg"),
+				Gallery:        to.StringPtr("my-gallery"),
+				Name:           to.StringPtr("my-image"),
+				Version:        to.StringPtr("1.0.0"),
+			},
+			expectedError: "Image must be specified by only one of an image ID, a Shared Image Gallery image or an Azure Marketplace image",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := GenerateImageReference(tc.image)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected image reference %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGenerateAdditionalCapabilities(t *testing.T) {
+	testcases := []struct {
+		name          string
+		zone          string
+		dataDisks     []infrav1.DataDisk
+		expected      *compute.AdditionalCapabilities
+		expectedError string
+	}{
+		{
+			name: "no data disks",
+			zone: "1",
+		},
+		{
+			name: "premium data disk",
+			zone: "1",
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "data", DiskSizeGB: 1024, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"}},
+			},
+		},
+		{
+			name: "ultra SSD data disk in a zone",
+			zone: "1",
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "data", DiskSizeGB: 1024, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "Premium_LRS"}},
+				{NameSuffix: "ultra", DiskSizeGB: 1024, Lun: 1, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "UltraSSD_LRS"}},
+			},
+			expected: &compute.AdditionalCapabilities{UltraSSDEnabled: to.BoolPtr(true)},
+		},
+		{
+			name: "ultra SSD data disk without a zone",
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "ultra", DiskSizeGB: 1024, ManagedDisk: &infrav1.ManagedDisk{StorageAccountType: "UltraSSD_LRS"}},
+			},
+			expectedError: "data disk ultra of vm my-vm is an Ultra SSD, which requires an availability zone",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := generateAdditionalCapabilities(Spec{Name: "my-vm", Zone: tc.zone, DataDisks: tc.dataDisks})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected additional capabilities %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestApplySpotVMOptions(t *testing.T) {
+	testcases := []struct {
+		name                   string
+		options                *infrav1.SpotVMOptions
+		expectedPriority       compute.VirtualMachinePriorityTypes
+		expectedEvictionPolicy compute.VirtualMachineEvictionPolicyTypes
+		expectedBillingProfile *compute.BillingProfile
+		expectedError          string
+	}{
+		{
+			name: "regular vm",
+		},
+		{
+			name:                   "spot vm with default options",
+			options:                &infrav1.SpotVMOptions{},
+			expectedPriority:       compute.Low,
+			expectedEvictionPolicy: compute.Deallocate,
+			expectedBillingProfile: &compute.BillingProfile{MaxPrice: to.Float64Ptr(-1)},
+		},
+		{
+			name:                   "spot vm paying up to the on-demand price",
+			options:                &infrav1.SpotVMOptions{MaxPrice: resource.NewQuantity(-1, resource.DecimalSI)},
+			expectedPriority:       compute.Low,
+			expectedEvictionPolicy: compute.Deallocate,
+			expectedBillingProfile: &compute.BillingProfile{MaxPrice: to.Float64Ptr(-1)},
+		},
+		{
+			name: "spot vm with a max price and eviction policy",
+			options: &infrav1.SpotVMOptions{
+				MaxPrice:       quantityPtr(resource.MustParse("0.01538")),
+				EvictionPolicy: infrav1.SpotEvictionPolicyDelete,
+			},
+			expectedPriority:       compute.Low,
+			expectedEvictionPolicy: compute.Delete,
+			expectedBillingProfile: &compute.BillingProfile{MaxPrice: to.Float64Ptr(0.01538)},
+		},
+		{
+			name:          "spot vm with an invalid max price",
+			options:       &infrav1.SpotVMOptions{MaxPrice: resource.NewQuantity(0, resource.DecimalSI)},
+			expectedError: "invalid max price 0 of spot vm, it has to be greater than 0 or -1",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			props := &compute.VirtualMachineProperties{}
+			err := applySpotVMOptions(props, tc.options)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if props.Priority != tc.expectedPriority {
+				t.Errorf("expected priority %q, got %q", tc.expectedPriority, props.Priority)
+			}
+			if props.EvictionPolicy != tc.expectedEvictionPolicy {
+				t.Errorf("expected eviction policy %q, got %q", tc.expectedEvictionPolicy, props.EvictionPolicy)
+			}
+			if !reflect.DeepEqual(props.BillingProfile, tc.expectedBillingProfile) {
+				t.Errorf("expected billing profile %+v, got %+v", tc.expectedBillingProfile, props.BillingProfile)
+			}
+		})
+	}
+}
+
+func TestGenerateDiagnosticsProfile(t *testing.T) {
+	testcases := []struct {
+		name          string
+		diagnostics   *infrav1.Diagnostics
+		expected      *compute.DiagnosticsProfile
+		expectedError string
+	}{
+		{
+			name: "default boot diagnostics",
+			expected: &compute.DiagnosticsProfile{
+				BootDiagnostics: &compute.BootDiagnostics{Enabled: to.BoolPtr(true)},
+			},
+		},
+		{
+			name: "managed boot diagnostics",
+			diagnostics: &infrav1.Diagnostics{
+				Boot: &infrav1.BootDiagnostics{StorageAccountType: infrav1.ManagedBootDiagnosticsStorage},
+			},
+			expected: &compute.DiagnosticsProfile{
+				BootDiagnostics: &compute.BootDiagnostics{Enabled: to.BoolPtr(true)},
+			},
+		},
+		{
+			name: "user-managed boot diagnostics",
+			diagnostics: &infrav1.Diagnostics{
+				Boot: &infrav1.BootDiagnostics{
+					StorageAccountType: infrav1.UserManagedBootDiagnosticsStorage,
+					StorageAccountURI:  "https://mystorageaccount.blob.core.windows.net/",
+				},
+			},
+			expected: &compute.DiagnosticsProfile{
+				BootDiagnostics: &compute.BootDiagnostics{
+					Enabled:    to.BoolPtr(true),
+					StorageURI: to.StringPtr("https://mystorageaccount.blob.core.windows.net/"),
+				},
+			},
+		},
+		{
+			name: "user-managed boot diagnostics without a storage account",
+			diagnostics: &infrav1.Diagnostics{
+				Boot: &infrav1.BootDiagnostics{StorageAccountType: infrav1.UserManagedBootDiagnosticsStorage},
+			},
+			expectedError: "user-managed boot diagnostics require a storage account URI",
+		},
+		{
+			name: "disabled boot diagnostics",
+			diagnostics: &infrav1.Diagnostics{
+				Boot: &infrav1.BootDiagnostics{StorageAccountType: infrav1.DisabledBootDiagnosticsStorage},
+			},
+			expected: &compute.DiagnosticsProfile{
+				BootDiagnostics: &compute.BootDiagnostics{Enabled: to.BoolPtr(false)},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := generateDiagnosticsProfile(tc.diagnostics)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected diagnostics profile %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGenerateIdentity(t *testing.T) {
+	identityID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/my-identity"
+	otherIdentityID := "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/other-identity"
+
+	testcases := []struct {
+		name                   string
+		identity               infrav1.VMIdentity
+		userAssignedIdentities []string
+		expected               *compute.VirtualMachineIdentity
+	}{
+		{
+			name: "no identity",
+		},
+		{
+			name:     "no system-assigned identity",
+			identity: infrav1.VMIdentityNone,
+		},
+		{
+			name:     "system-assigned identity",
+			identity: infrav1.VMIdentitySystemAssigned,
+			expected: &compute.VirtualMachineIdentity{Type: compute.ResourceIdentityTypeSystemAssigned},
+		},
+		{
+			name:                   "user-assigned identities",
+			userAssignedIdentities: []string{identityID, otherIdentityID},
+			expected: &compute.VirtualMachineIdentity{
+				Type: compute.ResourceIdentityTypeUserAssigned,
+				UserAssignedIdentities: map[string]*compute.VirtualMachineIdentityUserAssignedIdentitiesValue{
+					identityID:      {},
+					otherIdentityID: {},
+				},
+			},
+		},
+		{
+			name:                   "system-assigned and user-assigned identities",
+			identity:               infrav1.VMIdentitySystemAssigned,
+			userAssignedIdentities: []string{identityID},
+			expected: &compute.VirtualMachineIdentity{
+				Type: compute.ResourceIdentityTypeSystemAssignedUserAssigned,
+				UserAssignedIdentities: map[string]*compute.VirtualMachineIdentityUserAssignedIdentitiesValue{
+					identityID: {},
+				},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := generateIdentity(tc.identity, tc.userAssignedIdentities)
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected identity %+v, got %+v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func quantityPtr(q resource.Quantity) *resource.Quantity {
+	return &q
+}
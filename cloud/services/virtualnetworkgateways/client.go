@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtualnetworkgateways
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string) (network.VirtualNetworkGateway, error)
+	CreateOrUpdateAsync(context.Context, string, string, network.VirtualNetworkGateway) (*infrav1.Future, error)
+	DeleteAsync(context.Context, string, string) (*infrav1.Future, error)
+	GetResultIfDone(context.Context, *infrav1.Future) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	virtualnetworkgateways network.VirtualNetworkGatewaysClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new virtual network gateways client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newVirtualNetworkGatewaysClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newVirtualNetworkGatewaysClient creates a new virtual network gateways client from subscription ID and base URI.
+func newVirtualNetworkGatewaysClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.VirtualNetworkGatewaysClient {
+	gatewaysClient := network.NewVirtualNetworkGatewaysClientWithBaseURI(baseURI, subscriptionID)
+	gatewaysClient.Authorizer = authorizer
+	gatewaysClient.AddToUserAgent(azure.UserAgent)
+	return gatewaysClient
+}
+
+// Get gets the specified virtual network gateway.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, gatewayName string) (network.VirtualNetworkGateway, error) {
+	var result network.VirtualNetworkGateway
+	err := azure.CallAPI(ctx, "virtualnetworkgateways", "Get", func() error {
+		var err error
+		result, err = ac.virtualnetworkgateways.Get(ctx, resourceGroupName, gatewayName)
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdateAsync starts the operation to create or update a virtual network gateway, and returns its future
+// without waiting for it to complete.
+func (ac *AzureClient) CreateOrUpdateAsync(ctx context.Context, resourceGroupName, gatewayName string, gateway network.VirtualNetworkGateway) (*infrav1.Future, error) {
+	var future network.VirtualNetworkGatewaysCreateOrUpdateFuture
+	err := azure.CallAPI(ctx, "virtualnetworkgateways", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.virtualnetworkgateways.CreateOrUpdate(ctx, resourceGroupName, gatewayName, gateway)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newFuture(infrav1.PutFuture, resourceGroupName, gatewayName, future.Future)
+}
+
+// DeleteAsync starts the operation to delete a virtual network gateway, and returns its future without waiting for
+// it to complete.
+func (ac *AzureClient) DeleteAsync(ctx context.Context, resourceGroupName, gatewayName string) (*infrav1.Future, error) {
+	var future network.VirtualNetworkGatewaysDeleteFuture
+	err := azure.CallAPI(ctx, "virtualnetworkgateways", "Delete", func() error {
+		var err error
+		future, err = ac.virtualnetworkgateways.Delete(ctx, resourceGroupName, gatewayName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newFuture(infrav1.DeleteFuture, resourceGroupName, gatewayName, future.Future)
+}
+
+// GetResultIfDone polls the operation of the future to create, update or delete a virtual network gateway. It returns
+// nil when the operation has succeeded, an azure.OperationNotDoneError while it is in progress, and the error of the
+// operation when it has failed.
+func (ac *AzureClient) GetResultIfDone(ctx context.Context, future *infrav1.Future) error {
+	switch future.Type {
+	case infrav1.PutFuture:
+		var createFuture network.VirtualNetworkGatewaysCreateOrUpdateFuture
+		if err := json.Unmarshal([]byte(future.Data), &createFuture.Future); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal the future of virtual network gateway %s", future.Name)
+		}
+		if err := ac.pollFuture(ctx, future, "PollCreateOrUpdate", createFuture.DoneWithContext); err != nil {
+			return err
+		}
+		return azure.CallAPI(ctx, "virtualnetworkgateways", "CreateOrUpdateResult", func() error {
+			_, err := createFuture.Result(ac.virtualnetworkgateways)
+			return err
+		})
+	case infrav1.DeleteFuture:
+		var deleteFuture network.VirtualNetworkGatewaysDeleteFuture
+		if err := json.Unmarshal([]byte(future.Data), &deleteFuture.Future); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal the future of virtual network gateway %s", future.Name)
+		}
+		if err := ac.pollFuture(ctx, future, "PollDelete", deleteFuture.DoneWithContext); err != nil {
+			return err
+		}
+		return azure.CallAPI(ctx, "virtualnetworkgateways", "DeleteResult", func() error {
+			_, err := deleteFuture.Result(ac.virtualnetworkgateways)
+			return err
+		})
+	default:
+		return errors.Errorf("unknown future type %q of virtual network gateway %s", future.Type, future.Name)
+	}
+}
+
+// pollFuture checks once whether the operation of a future is done, and returns an azure.OperationNotDoneError if it
+// is not.
+func (ac *AzureClient) pollFuture(ctx context.Context, future *infrav1.Future, operation string,
+	doneWithContext func(context.Context, autorest.Sender) (bool, error)) error {
+	var done bool
+	err := azure.CallAPI(ctx, "virtualnetworkgateways", operation, func() error {
+		var err error
+		done, err = doneWithContext(ctx, ac.virtualnetworkgateways)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !done {
+		return azure.NewOperationNotDoneError(future)
+	}
+	return nil
+}
+
+// newFuture serializes the Azure SDK future of an operation on a virtual network gateway, so that the operation can
+// be polled by the next reconciles.
+func newFuture(futureType infrav1.FutureType, resourceGroupName, gatewayName string, future interface{}) (*infrav1.Future, error) {
+	data, err := json.Marshal(future)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal the future of virtual network gateway %s", gatewayName)
+	}
+	return &infrav1.Future{
+		Type:          futureType,
+		ResourceGroup: resourceGroupName,
+		Name:          gatewayName,
+		Data:          string(data),
+	}, nil
+}
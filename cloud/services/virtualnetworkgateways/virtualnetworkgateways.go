@@ -0,0 +1,234 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtualnetworkgateways
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for virtual network gateways
+type Spec struct {
+	Name     string
+	VnetName string
+	// PublicIPName is the name of the public IP of the gateway. The public IP is created and deleted with the
+	// gateway.
+	PublicIPName string
+	// SKU is the SKU of the VPN gateway, such as VpnGw1 or the zone-redundant VpnGw1AZ.
+	SKU string
+}
+
+// Reconcile creates the route-based VPN gateway and its public IP. Creating a gateway takes up to 45 minutes, so
+// the creation is started without waiting for it: an azure.OperationNotDoneError is returned and the creation is
+// polled by the next reconciles until it completes. An existing gateway is not updated.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	gatewaySpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid virtual network gateway specification")
+	}
+	log := s.Scope.ResourceLogger(gatewaySpec.Name)
+	log.V(4).Info("reconciling virtual network gateway")
+
+	if future := s.Scope.GetLongRunningOperationState(); future != nil && future.Type == infrav1.PutFuture && future.Name == gatewaySpec.Name {
+		return s.pollFuture(ctx, future)
+	}
+
+	_, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), gatewaySpec.Name)
+	if err == nil {
+		log.V(4).Info("virtual network gateway already exists")
+		return nil
+	}
+	if !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to get virtual network gateway %s in resource group %s", gatewaySpec.Name, s.Scope.ResourceGroup())
+	}
+
+	subnet, err := s.SubnetsClient.Get(ctx, s.Scope.Vnet().ResourceGroup, gatewaySpec.VnetName, azure.GatewaySubnetName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get subnet %s in vnet %s", azure.GatewaySubnetName, gatewaySpec.VnetName)
+	}
+
+	publicIP, err := s.reconcilePublicIP(ctx, gatewaySpec)
+	if err != nil {
+		return err
+	}
+
+	gateway := s.buildGateway(gatewaySpec, to.String(subnet.ID), to.String(publicIP.ID))
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("virtual network gateway", s.Scope.ResourceGroup(), gatewaySpec.Name, gateway)) {
+		return nil
+	}
+
+	log.V(2).Info("creating virtual network gateway")
+	future, err := s.Client.CreateOrUpdateAsync(ctx, s.Scope.ResourceGroup(), gatewaySpec.Name, gateway)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create virtual network gateway %s in resource group %s", gatewaySpec.Name, s.Scope.ResourceGroup())
+	}
+	s.Scope.SetLongRunningOperationState(future)
+	log.V(2).Info("started creating virtual network gateway")
+	return azure.NewOperationNotDoneError(future)
+}
+
+// Delete deletes the virtual network gateway and, once it is gone, its public IP. Like its creation, the deletion
+// of the gateway is polled by the next reconciles, an azure.OperationNotDoneError is returned until it completes.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	gatewaySpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid virtual network gateway specification")
+	}
+	log := s.Scope.ResourceLogger(gatewaySpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("virtual network gateway", s.Scope.ResourceGroup(), gatewaySpec.Name)) &&
+		s.Scope.DryRun(scope.DeleteAction("public ip", s.Scope.ResourceGroup(), gatewaySpec.PublicIPName)) {
+		return nil
+	}
+
+	future := s.Scope.GetLongRunningOperationState()
+	switch {
+	case future != nil && future.Name == gatewaySpec.Name && future.Type == infrav1.DeleteFuture:
+		if err := s.pollFuture(ctx, future); err != nil {
+			return err
+		}
+	default:
+		// a creation in progress is abandoned, the gateway is deleted once Azure accepts the deletion.
+		_, err := s.Client.Get(ctx, s.Scope.ResourceGroup(), gatewaySpec.Name)
+		if err != nil && !azure.ResourceNotFound(err) {
+			return errors.Wrapf(err, "failed to get virtual network gateway %s in resource group %s", gatewaySpec.Name, s.Scope.ResourceGroup())
+		}
+		if err == nil {
+			log.V(2).Info("deleting virtual network gateway")
+			future, err := s.Client.DeleteAsync(ctx, s.Scope.ResourceGroup(), gatewaySpec.Name)
+			if err != nil && !azure.ResourceNotFound(err) {
+				return errors.Wrapf(err, "failed to delete virtual network gateway %s in resource group %s", gatewaySpec.Name, s.Scope.ResourceGroup())
+			}
+			if err == nil {
+				s.Scope.SetLongRunningOperationState(future)
+				log.V(2).Info("started deleting virtual network gateway")
+				return azure.NewOperationNotDoneError(future)
+			}
+		}
+		s.Scope.DeleteLongRunningOperationState()
+	}
+
+	log.V(2).Info("deleting virtual network gateway public ip", "publicIP", gatewaySpec.PublicIPName)
+	err := s.PublicIPsClient.Delete(ctx, s.Scope.ResourceGroup(), gatewaySpec.PublicIPName)
+	if err != nil && !azure.ResourceNotFound(err) {
+		return errors.Wrapf(err, "failed to delete public ip %s in resource group %s", gatewaySpec.PublicIPName, s.Scope.ResourceGroup())
+	}
+
+	log.V(2).Info("successfully deleted virtual network gateway")
+	return nil
+}
+
+// pollFuture polls the creation or the deletion of the gateway started by a previous reconcile. It returns an
+// azure.OperationNotDoneError while the operation is in progress, and forgets the future once it has completed.
+func (s *Service) pollFuture(ctx context.Context, future *infrav1.Future) error {
+	log := s.Scope.ResourceLogger(future.Name)
+	err := s.Client.GetResultIfDone(ctx, future)
+	if azure.IsOperationNotDoneError(err) {
+		log.V(2).Info("virtual network gateway operation is in progress", "operation", future.Type)
+		return err
+	}
+	s.Scope.DeleteLongRunningOperationState()
+	if err != nil && !(future.Type == infrav1.DeleteFuture && azure.ResourceNotFound(err)) {
+		return errors.Wrapf(err, "virtual network gateway operation %s failed", future.Type)
+	}
+
+	log.V(2).Info("virtual network gateway operation completed", "operation", future.Type)
+	return nil
+}
+
+// buildGateway returns the route-based VPN gateway of the spec, in the GatewaySubnet and with the public IP.
+func (s *Service) buildGateway(gatewaySpec *Spec, subnetID, publicIPID string) network.VirtualNetworkGateway {
+	return network.VirtualNetworkGateway{
+		Name:     to.StringPtr(gatewaySpec.Name),
+		Location: to.StringPtr(s.Scope.Location()),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(gatewaySpec.Name, "")),
+		VirtualNetworkGatewayPropertiesFormat: &network.VirtualNetworkGatewayPropertiesFormat{
+			IPConfigurations: &[]network.VirtualNetworkGatewayIPConfiguration{
+				{
+					Name: to.StringPtr("default"),
+					VirtualNetworkGatewayIPConfigurationPropertiesFormat: &network.VirtualNetworkGatewayIPConfigurationPropertiesFormat{
+						PrivateIPAllocationMethod: network.Dynamic,
+						Subnet:                    &network.SubResource{ID: to.StringPtr(subnetID)},
+						PublicIPAddress:           &network.SubResource{ID: to.StringPtr(publicIPID)},
+					},
+				},
+			},
+			GatewayType: network.VirtualNetworkGatewayTypeVpn,
+			VpnType:     network.RouteBased,
+			EnableBgp:   to.BoolPtr(false),
+			Sku: &network.VirtualNetworkGatewaySku{
+				Name: network.VirtualNetworkGatewaySkuName(gatewaySpec.SKU),
+				Tier: network.VirtualNetworkGatewaySkuTier(gatewaySpec.SKU),
+			},
+		},
+	}
+}
+
+// reconcilePublicIP gets or creates the public IP of the gateway. The zone-redundant AZ SKUs require a Standard public
+// IP with static allocation, the other SKUs a Basic public IP with dynamic allocation.
+func (s *Service) reconcilePublicIP(ctx context.Context, gatewaySpec *Spec) (network.PublicIPAddress, error) {
+	name := gatewaySpec.PublicIPName
+	log := s.Scope.ResourceLogger(name)
+	publicIP, err := s.PublicIPsClient.Get(ctx, s.Scope.ResourceGroup(), name)
+	if err == nil {
+		return publicIP, nil
+	}
+	if !azure.ResourceNotFound(err) {
+		return publicIP, errors.Wrapf(err, "failed to get public ip %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+
+	desired := network.PublicIPAddress{
+		Sku:      &network.PublicIPAddressSku{Name: network.PublicIPAddressSkuNameBasic},
+		Name:     to.StringPtr(name),
+		Location: to.StringPtr(s.Scope.Location()),
+		PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAddressVersion:   network.IPv4,
+			PublicIPAllocationMethod: network.Dynamic,
+		},
+	}
+	if isZoneRedundantSKU(gatewaySpec.SKU) {
+		desired.Sku.Name = network.PublicIPAddressSkuNameStandard
+		desired.PublicIPAllocationMethod = network.Static
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("public ip", s.Scope.ResourceGroup(), name, desired)) {
+		return desired, nil
+	}
+
+	log.V(2).Info("creating virtual network gateway public ip")
+	err = s.PublicIPsClient.CreateOrUpdate(ctx, s.Scope.ResourceGroup(), name, desired)
+	if err != nil {
+		return publicIP, errors.Wrapf(err, "failed to create public ip %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+
+	publicIP, err = s.PublicIPsClient.Get(ctx, s.Scope.ResourceGroup(), name)
+	if err != nil {
+		return publicIP, errors.Wrapf(err, "failed to get public ip %s in resource group %s", name, s.Scope.ResourceGroup())
+	}
+	return publicIP, nil
+}
+
+// isZoneRedundantSKU returns true for the zone-redundant gateway SKUs, such as VpnGw1AZ.
+func isZoneRedundantSKU(sku string) bool {
+	return strings.HasSuffix(sku, "AZ")
+}
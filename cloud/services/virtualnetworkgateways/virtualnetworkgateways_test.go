@@ -0,0 +1,391 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package virtualnetworkgateways
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips/mock_publicips"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/subnets/mock_subnets"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualnetworkgateways/mock_virtualnetworkgateways"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var (
+	notFound = autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+	createFuture = &infrav1.Future{Type: infrav1.PutFuture, ResourceGroup: "my-rg", Name: "my-vpn-gateway", Data: "{}"}
+	deleteFuture = &infrav1.Future{Type: infrav1.DeleteFuture, ResourceGroup: "my-rg", Name: "my-vpn-gateway", Data: "{}"}
+)
+
+func TestBuildGateway(t *testing.T) {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+	}
+	clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+		AzureClients: scope.AzureClients{
+			SubscriptionID: "123",
+			Authorizer:     autorest.NullAuthorizer{},
+		},
+		Client:  fake.NewFakeClient(cluster),
+		Cluster: cluster,
+		AzureCluster: &infrav1.AzureCluster{
+			Spec: infrav1.AzureClusterSpec{
+				Location:      "test-location",
+				ResourceGroup: "my-rg",
+				NetworkSpec: infrav1.NetworkSpec{
+					Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test context: %v", err)
+	}
+
+	s := &Service{Scope: clusterScope}
+	gateway := s.buildGateway(&Spec{Name: "my-vpn-gateway", SKU: "VpnGw2AZ"}, "gateway-subnet-id", "gateway-ip-id")
+
+	expected := network.VirtualNetworkGatewayPropertiesFormat{
+		IPConfigurations: &[]network.VirtualNetworkGatewayIPConfiguration{
+			{
+				Name: to.StringPtr("default"),
+				VirtualNetworkGatewayIPConfigurationPropertiesFormat: &network.VirtualNetworkGatewayIPConfigurationPropertiesFormat{
+					PrivateIPAllocationMethod: network.Dynamic,
+					Subnet:                    &network.SubResource{ID: to.StringPtr("gateway-subnet-id")},
+					PublicIPAddress:           &network.SubResource{ID: to.StringPtr("gateway-ip-id")},
+				},
+			},
+		},
+		GatewayType: network.VirtualNetworkGatewayTypeVpn,
+		VpnType:     network.RouteBased,
+		EnableBgp:   to.BoolPtr(false),
+		Sku: &network.VirtualNetworkGatewaySku{
+			Name: network.VirtualNetworkGatewaySkuNameVpnGw2AZ,
+			Tier: network.VirtualNetworkGatewaySkuTierVpnGw2AZ,
+		},
+	}
+	if !reflect.DeepEqual(*gateway.VirtualNetworkGatewayPropertiesFormat, expected) {
+		t.Errorf("expected gateway properties %+v, got %+v", expected, *gateway.VirtualNetworkGatewayPropertiesFormat)
+	}
+	if to.String(gateway.Location) != "test-location" {
+		t.Errorf("expected gateway in location test-location, got %s", to.String(gateway.Location))
+	}
+	if to.String(gateway.Tags["sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster"]) != string(infrav1.ResourceLifecycleOwned) {
+		t.Errorf("expected gateway to be owned by the cluster, got tags %v", gateway.Tags)
+	}
+}
+
+func TestReconcileVirtualNetworkGateways(t *testing.T) {
+	subnet := network.Subnet{ID: to.StringPtr("gateway-subnet-id"), Name: to.StringPtr("GatewaySubnet")}
+	publicIP := network.PublicIPAddress{ID: to.StringPtr("gateway-ip-id"), Name: to.StringPtr("my-vpn-gateway-ip")}
+
+	testcases := []struct {
+		name           string
+		sku            string
+		future         *infrav1.Future
+		expectedError  string
+		expectNotDone  bool
+		expectedFuture *infrav1.Future
+		expect         func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name:           "gateway does not exist",
+			sku:            "VpnGw1",
+			expectNotDone:  true,
+			expectedFuture: createFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{}, notFound)
+				msn.Get(context.TODO(), "my-rg", "my-vnet", "GatewaySubnet").Return(subnet, nil)
+				gomock.InOrder(
+					mip.Get(context.TODO(), "my-rg", "my-vpn-gateway-ip").Return(network.PublicIPAddress{}, notFound),
+					mip.CreateOrUpdate(context.TODO(), "my-rg", "my-vpn-gateway-ip", gomock.AssignableToTypeOf(network.PublicIPAddress{})).
+						Do(func(_ context.Context, _, _ string, ip network.PublicIPAddress) {
+							if ip.Sku.Name != network.PublicIPAddressSkuNameBasic || ip.PublicIPAllocationMethod != network.Dynamic {
+								t.Errorf("expected a Basic dynamic public ip, got %s %s", ip.Sku.Name, ip.PublicIPAllocationMethod)
+							}
+						}),
+					mip.Get(context.TODO(), "my-rg", "my-vpn-gateway-ip").Return(publicIP, nil),
+				)
+				m.CreateOrUpdateAsync(context.TODO(), "my-rg", "my-vpn-gateway", gomock.AssignableToTypeOf(network.VirtualNetworkGateway{})).
+					Return(createFuture, nil)
+			},
+		},
+		{
+			name:           "zone-redundant gateway does not exist",
+			sku:            "VpnGw1AZ",
+			expectNotDone:  true,
+			expectedFuture: createFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{}, notFound)
+				msn.Get(context.TODO(), "my-rg", "my-vnet", "GatewaySubnet").Return(subnet, nil)
+				gomock.InOrder(
+					mip.Get(context.TODO(), "my-rg", "my-vpn-gateway-ip").Return(network.PublicIPAddress{}, notFound),
+					mip.CreateOrUpdate(context.TODO(), "my-rg", "my-vpn-gateway-ip", gomock.AssignableToTypeOf(network.PublicIPAddress{})).
+						Do(func(_ context.Context, _, _ string, ip network.PublicIPAddress) {
+							if ip.Sku.Name != network.PublicIPAddressSkuNameStandard || ip.PublicIPAllocationMethod != network.Static {
+								t.Errorf("expected a Standard static public ip, got %s %s", ip.Sku.Name, ip.PublicIPAllocationMethod)
+							}
+						}),
+					mip.Get(context.TODO(), "my-rg", "my-vpn-gateway-ip").Return(publicIP, nil),
+				)
+				m.CreateOrUpdateAsync(context.TODO(), "my-rg", "my-vpn-gateway", gomock.AssignableToTypeOf(network.VirtualNetworkGateway{})).
+					Return(createFuture, nil)
+			},
+		},
+		{
+			name: "gateway already exists",
+			sku:  "VpnGw1",
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{Name: to.StringPtr("my-vpn-gateway")}, nil)
+			},
+		},
+		{
+			name:          "gateway subnet is missing",
+			sku:           "VpnGw1",
+			expectedError: "failed to get subnet GatewaySubnet in vnet my-vnet: #: Not found: StatusCode=404",
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{}, notFound)
+				msn.Get(context.TODO(), "my-rg", "my-vnet", "GatewaySubnet").Return(network.Subnet{}, notFound)
+			},
+		},
+		{
+			name:           "gateway creation is in progress",
+			sku:            "VpnGw1",
+			future:         createFuture,
+			expectNotDone:  true,
+			expectedFuture: createFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.GetResultIfDone(context.TODO(), createFuture).Return(azure.NewOperationNotDoneError(createFuture))
+			},
+		},
+		{
+			name:   "gateway creation has completed",
+			sku:    "VpnGw1",
+			future: createFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.GetResultIfDone(context.TODO(), createFuture).Return(nil)
+			},
+		},
+		{
+			name:          "gateway creation has failed",
+			sku:           "VpnGw1",
+			future:        createFuture,
+			expectedError: "virtual network gateway operation PUT failed: quota exceeded",
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, msn *mock_subnets.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.GetResultIfDone(context.TODO(), createFuture).Return(errors.New("quota exceeded"))
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			gatewayMock := mock_virtualnetworkgateways.NewMockClient(mockCtrl)
+			subnetMock := mock_subnets.NewMockClient(mockCtrl)
+			publicIPMock := mock_publicips.NewMockClient(mockCtrl)
+
+			tc.expect(gatewayMock.EXPECT(), subnetMock.EXPECT(), publicIPMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+						},
+					},
+					Status: infrav1.AzureClusterStatus{
+						LongRunningOperationState: tc.future,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:           clusterScope,
+				Client:          gatewayMock,
+				SubnetsClient:   subnetMock,
+				PublicIPsClient: publicIPMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{
+				Name:         "my-vpn-gateway",
+				VnetName:     "my-vnet",
+				PublicIPName: "my-vpn-gateway-ip",
+				SKU:          tc.sku,
+			})
+			switch {
+			case tc.expectNotDone:
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected an operation not done error, got %v", err)
+				}
+			case tc.expectedError != "":
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			case err != nil:
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if future := clusterScope.GetLongRunningOperationState(); !reflect.DeepEqual(future, tc.expectedFuture) {
+				t.Errorf("expected long-running operation state %+v, got %+v", tc.expectedFuture, future)
+			}
+		})
+	}
+}
+
+func TestDeleteVirtualNetworkGateways(t *testing.T) {
+	testcases := []struct {
+		name           string
+		future         *infrav1.Future
+		expectNotDone  bool
+		expectedFuture *infrav1.Future
+		expect         func(m *mock_virtualnetworkgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder)
+	}{
+		{
+			name:           "gateway exists",
+			expectNotDone:  true,
+			expectedFuture: deleteFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{Name: to.StringPtr("my-vpn-gateway")}, nil)
+				m.DeleteAsync(context.TODO(), "my-rg", "my-vpn-gateway").Return(deleteFuture, nil)
+			},
+		},
+		{
+			name:           "gateway creation in progress is abandoned",
+			future:         createFuture,
+			expectNotDone:  true,
+			expectedFuture: deleteFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{Name: to.StringPtr("my-vpn-gateway")}, nil)
+				m.DeleteAsync(context.TODO(), "my-rg", "my-vpn-gateway").Return(deleteFuture, nil)
+			},
+		},
+		{
+			name:           "gateway deletion is in progress",
+			future:         deleteFuture,
+			expectNotDone:  true,
+			expectedFuture: deleteFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.GetResultIfDone(context.TODO(), deleteFuture).Return(azure.NewOperationNotDoneError(deleteFuture))
+			},
+		},
+		{
+			name:   "gateway deletion has completed",
+			future: deleteFuture,
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.GetResultIfDone(context.TODO(), deleteFuture).Return(nil)
+				mip.Delete(context.TODO(), "my-rg", "my-vpn-gateway-ip")
+			},
+		},
+		{
+			name: "gateway already deleted",
+			expect: func(m *mock_virtualnetworkgateways.MockClientMockRecorder, mip *mock_publicips.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vpn-gateway").Return(network.VirtualNetworkGateway{}, notFound)
+				mip.Delete(context.TODO(), "my-rg", "my-vpn-gateway-ip").Return(notFound)
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			gatewayMock := mock_virtualnetworkgateways.NewMockClient(mockCtrl)
+			publicIPMock := mock_publicips.NewMockClient(mockCtrl)
+
+			tc.expect(gatewayMock.EXPECT(), publicIPMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+						},
+					},
+					Status: infrav1.AzureClusterStatus{
+						LongRunningOperationState: tc.future,
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:           clusterScope,
+				Client:          gatewayMock,
+				PublicIPsClient: publicIPMock,
+			}
+
+			err = s.Delete(context.TODO(), &Spec{
+				Name:         "my-vpn-gateway",
+				VnetName:     "my-vnet",
+				PublicIPName: "my-vpn-gateway-ip",
+			})
+			if tc.expectNotDone {
+				if !azure.IsOperationNotDoneError(err) {
+					t.Fatalf("expected an operation not done error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+
+			if future := clusterScope.GetLongRunningOperationState(); !reflect.DeepEqual(future, tc.expectedFuture) {
+				t.Errorf("expected long-running operation state %+v, got %+v", tc.expectedFuture, future)
+			}
+		})
+	}
+}
@@ -39,15 +39,15 @@ type AzureClient struct {
 
 var _ Client = &AzureClient{}
 
-// NewClient creates a new VM client from subscription ID.
-func NewClient(subscriptionID string, authorizer autorest.Authorizer) *AzureClient {
-	c := newVirtualNetworksClient(subscriptionID, authorizer)
+// NewClient creates a new VM client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newVirtualNetworksClient(subscriptionID, baseURI, authorizer)
 	return &AzureClient{c}
 }
 
-// newVirtualNetworksClient creates a new vnet client from subscription ID.
-func newVirtualNetworksClient(subscriptionID string, authorizer autorest.Authorizer) network.VirtualNetworksClient {
-	vnetsClient := network.NewVirtualNetworksClient(subscriptionID)
+// newVirtualNetworksClient creates a new vnet client from subscription ID and base URI.
+func newVirtualNetworksClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.VirtualNetworksClient {
+	vnetsClient := network.NewVirtualNetworksClientWithBaseURI(baseURI, subscriptionID)
 	vnetsClient.Authorizer = authorizer
 	vnetsClient.AddToUserAgent(azure.UserAgent)
 	return vnetsClient
@@ -55,38 +55,54 @@ func newVirtualNetworksClient(subscriptionID string, authorizer autorest.Authori
 
 // Get gets the specified virtual network by resource group.
 func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, vnetName string) (network.VirtualNetwork, error) {
-	return ac.virtualnetworks.Get(ctx, resourceGroupName, vnetName, "")
+	var result network.VirtualNetwork
+	err := azure.CallAPI(ctx, "virtualnetworks", "Get", func() error {
+		var err error
+		result, err = ac.virtualnetworks.Get(ctx, resourceGroupName, vnetName, "")
+		return err
+	})
+	return result, err
 }
 
 // CreateOrUpdate creates or updates a virtual network in the specified resource group.
 func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, vnetName string, vn network.VirtualNetwork) error {
-	future, err := ac.virtualnetworks.CreateOrUpdate(ctx, resourceGroupName, vnetName, vn)
-	if err != nil {
+	var future network.VirtualNetworksCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "virtualnetworks", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.virtualnetworks.CreateOrUpdate(ctx, resourceGroupName, vnetName, vn)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.virtualnetworks.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.virtualnetworks.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.virtualnetworks)
 		return err
-	}
-	_, err = future.Result(ac.virtualnetworks)
-	return err
+	})
 }
 
 // Delete deletes the specified virtual network.
 func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, vnetName string) error {
-	future, err := ac.virtualnetworks.Delete(ctx, resourceGroupName, vnetName)
-	if err != nil {
+	var future network.VirtualNetworksDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "virtualnetworks", "Delete", func() error {
+		var err error
+		future, err = ac.virtualnetworks.Delete(ctx, resourceGroupName, vnetName)
 		return err
-	}
-	err = future.WaitForCompletionRef(ctx, ac.virtualnetworks.Client)
-	if err != nil {
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.virtualnetworks.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.virtualnetworks)
 		return err
-	}
-	_, err = future.Result(ac.virtualnetworks)
-	return err
+	})
 }
 
 // CheckIPAddressAvailability checks whether a private IP address is available for use.
 func (ac *AzureClient) CheckIPAddressAvailability(ctx context.Context, resourceGroupName, vnetName, ip string) (network.IPAddressAvailabilityResult, error) {
-	return ac.virtualnetworks.CheckIPAddressAvailability(ctx, resourceGroupName, vnetName, ip)
+	var result network.IPAddressAvailabilityResult
+	err := azure.ObserveAPICall("virtualnetworks", "CheckIPAddressAvailability", func() error {
+		var err error
+		result, err = ac.virtualnetworks.CheckIPAddressAvailability(ctx, resourceGroupName, vnetName, ip)
+		return err
+	})
+	return result, err
 }
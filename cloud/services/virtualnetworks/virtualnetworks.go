@@ -18,21 +18,27 @@ package virtualnetworks
 
 import (
 	"context"
+	"net"
 
 	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
-	"k8s.io/klog"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/converters"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 )
 
 // Spec input specification for Get/CreateOrUpdate/Delete calls
 type Spec struct {
 	ResourceGroup string
 	Name          string
-	CIDR          string
+	CIDRs         []string
+	// DdosProtectionPlanID is the ID of the DDoS protection plan which enables the standard DDoS protection of the
+	// vnet, it is only set when the vnet is created.
+	DdosProtectionPlanID string
+	// DNSServers are the DNS servers of the vnet, they are updated when they change.
+	DNSServers []string
 }
 
 // Get provides information about a virtual network.
@@ -48,20 +54,33 @@ func (s *Service) Get(ctx context.Context, spec interface{}) (*infrav1.VnetSpec,
 		}
 		return nil, errors.Wrapf(err, "failed to get vnet %s", vnetSpec.Name)
 	}
-	cidr := ""
-	if vnet.VirtualNetworkPropertiesFormat != nil && vnet.VirtualNetworkPropertiesFormat.AddressSpace != nil {
-		prefixes := to.StringSlice(vnet.VirtualNetworkPropertiesFormat.AddressSpace.AddressPrefixes)
-		if prefixes != nil && len(prefixes) > 0 {
-			cidr = prefixes[0]
+	return toVnetSpec(vnetSpec.ResourceGroup, vnet), nil
+}
+
+// toVnetSpec converts an Azure virtual network of the given resource group to a vnet spec.
+func toVnetSpec(resourceGroup string, vnet network.VirtualNetwork) *infrav1.VnetSpec {
+	var prefixes, dnsServers []string
+	if vnet.VirtualNetworkPropertiesFormat != nil {
+		if vnet.VirtualNetworkPropertiesFormat.AddressSpace != nil {
+			prefixes = to.StringSlice(vnet.VirtualNetworkPropertiesFormat.AddressSpace.AddressPrefixes)
 		}
+		if vnet.VirtualNetworkPropertiesFormat.DhcpOptions != nil {
+			dnsServers = to.StringSlice(vnet.VirtualNetworkPropertiesFormat.DhcpOptions.DNSServers)
+		}
+	}
+	cidr := ""
+	if len(prefixes) > 0 {
+		cidr = prefixes[0]
 	}
 	return &infrav1.VnetSpec{
-		ResourceGroup: vnetSpec.ResourceGroup,
+		ResourceGroup: resourceGroup,
 		ID:            to.String(vnet.ID),
 		Name:          to.String(vnet.Name),
 		CidrBlock:     cidr,
+		CIDRBlocks:    prefixes,
+		DNSServers:    dnsServers,
 		Tags:          converters.MapToTags(vnet.Tags),
-	}, nil
+	}
 }
 
 // Reconcile gets/creates/updates a virtual network.
@@ -78,49 +97,99 @@ func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid VNET Specification")
 	}
+	// the vnet of the cluster can be in another resource group.
+	log := s.Scope.WithValues("resourceGroup", vnetSpec.ResourceGroup, "name", vnetSpec.Name)
+	log.V(4).Info("reconciling vnet")
+	for _, cidr := range vnetSpec.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.Wrapf(err, "invalid CidrBlock %q for vnet %s", cidr, vnetSpec.Name)
+		}
+	}
+	for _, server := range vnetSpec.DNSServers {
+		if net.ParseIP(server) == nil {
+			return errors.Errorf("invalid DNS server %q for vnet %s, it must be an IP address", server, vnetSpec.Name)
+		}
+	}
 
-	vnet, err := s.Get(ctx, vnetSpec)
+	existing, err := s.Client.Get(ctx, vnetSpec.ResourceGroup, vnetSpec.Name)
 	if !azure.ResourceNotFound(err) {
 		if err != nil {
-			return errors.Wrap(err, "failed to get vnet")
+			return errors.Wrapf(err, "failed to get vnet %s", vnetSpec.Name)
 		}
 
+		vnet := toVnetSpec(vnetSpec.ResourceGroup, existing)
 		if !vnet.IsManaged(s.Scope.Name()) {
 			s.Scope.V(2).Info("Working on custom vnet", "vnet-id", vnet.ID)
+		} else if len(vnetSpec.DNSServers) > 0 && !equalDNSServers(vnet.DNSServers, vnetSpec.DNSServers) {
+			// the vnet is updated as it is, along its subnets, only its DNS servers change.
+			log.V(2).Info("updating vnet DNS servers", "dnsServers", vnetSpec.DNSServers)
+			if existing.VirtualNetworkPropertiesFormat == nil {
+				existing.VirtualNetworkPropertiesFormat = &network.VirtualNetworkPropertiesFormat{}
+			}
+			existing.DhcpOptions = &network.DhcpOptions{DNSServers: &vnetSpec.DNSServers}
+			if !s.Scope.DryRun(scope.CreateOrUpdateAction("vnet", vnetSpec.ResourceGroup, vnetSpec.Name, existing)) {
+				if err := s.Client.CreateOrUpdate(ctx, vnetSpec.ResourceGroup, vnetSpec.Name, existing); err != nil {
+					return errors.Wrapf(err, "failed to update DNS servers of vnet %s in resource group %s", vnetSpec.Name, vnetSpec.ResourceGroup)
+				}
+				vnet.DNSServers = vnetSpec.DNSServers
+				log.V(2).Info("successfully updated vnet DNS servers")
+			}
 		}
 		// vnet already exists, cannot update since it's immutable
 		// TODO: ensure tags & other managed vnet attributes
+		// the DDoS protection plan is user provided and not part of the vnet, so it is kept as is.
+		vnet.DdosProtectionPlan = s.Scope.Vnet().DdosProtectionPlan
 		vnet.DeepCopyInto(s.Scope.Vnet())
+		log.V(4).Info("vnet already exists")
 		return nil
 	}
-	klog.V(2).Infof("creating vnet %s ", vnetSpec.Name)
+	log.V(2).Info("creating vnet")
 	vnetProperties := network.VirtualNetwork{
-		Tags: converters.TagsToMap(infrav1.Build(infrav1.BuildParams{
-			ClusterName: s.Scope.Name(),
-			Lifecycle:   infrav1.ResourceLifecycleOwned,
-			Name:        to.StringPtr(vnetSpec.Name),
-			Role:        to.StringPtr(infrav1.CommonRoleTagValue),
-			Additional:  s.Scope.AdditionalTags(),
-		})),
+		Tags:     converters.TagsToMap(s.Scope.ResourceTags(vnetSpec.Name, infrav1.CommonRoleTagValue)),
 		Location: to.StringPtr(s.Scope.Location()),
 		VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
 			AddressSpace: &network.AddressSpace{
-				AddressPrefixes: &[]string{vnetSpec.CIDR},
+				AddressPrefixes: &vnetSpec.CIDRs,
 			},
 		},
 	}
+	if vnetSpec.DdosProtectionPlanID != "" {
+		vnetProperties.EnableDdosProtection = to.BoolPtr(true)
+		vnetProperties.DdosProtectionPlan = &network.SubResource{ID: to.StringPtr(vnetSpec.DdosProtectionPlanID)}
+	}
+	if len(vnetSpec.DNSServers) > 0 {
+		vnetProperties.DhcpOptions = &network.DhcpOptions{DNSServers: &vnetSpec.DNSServers}
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("vnet", vnetSpec.ResourceGroup, vnetSpec.Name, vnetProperties)) {
+		return nil
+	}
+
 	err = s.Client.CreateOrUpdate(ctx, vnetSpec.ResourceGroup, vnetSpec.Name, vnetProperties)
 	if err != nil {
 		return err
 	}
 
-	klog.V(2).Infof("successfully created vnet %s ", vnetSpec.Name)
+	log.V(2).Info("successfully created vnet")
 	return nil
 }
 
-// Delete deletes the virtual network with the provided name.
+// equalDNSServers returns true if the DNS servers are the same, in the same order since the first one is the primary.
+func equalDNSServers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete deletes the virtual network with the provided name. A vnet with subnets which network interfaces still use
+// is not deleted, a ResourceInUseError is returned instead.
 func (s *Service) Delete(ctx context.Context, spec interface{}) error {
-	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+	if !s.Scope.IsVnetManaged() {
 		s.Scope.V(4).Info("Skipping vnet deletion in custom vnet mode")
 		return nil
 	}
@@ -128,16 +197,54 @@ func (s *Service) Delete(ctx context.Context, spec interface{}) error {
 	if !ok {
 		return errors.New("Invalid VNET Specification")
 	}
-	klog.V(2).Infof("deleting vnet %s ", vnetSpec.Name)
-	err := s.Client.Delete(ctx, vnetSpec.ResourceGroup, vnetSpec.Name)
+	log := s.Scope.WithValues("resourceGroup", vnetSpec.ResourceGroup, "name", vnetSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("vnet", vnetSpec.ResourceGroup, vnetSpec.Name)) {
+		return nil
+	}
+
+	vnet, err := s.Client.Get(ctx, vnetSpec.ResourceGroup, vnetSpec.Name)
 	if err != nil && azure.ResourceNotFound(err) {
 		// already deleted
+		log.V(4).Info("vnet is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get vnet %s in resource group %s", vnetSpec.Name, vnetSpec.ResourceGroup)
+	}
+	// deleting the vnet deletes its subnets, which Azure rejects while network interfaces still use them.
+	if dependents := subnetIPConfigurations(vnet); len(dependents) > 0 {
+		return azure.NewResourceInUseError("vnet", vnetSpec.Name, dependents...)
+	}
+
+	log.V(2).Info("deleting vnet")
+	err = s.Client.Delete(ctx, vnetSpec.ResourceGroup, vnetSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("vnet is already deleted")
 		return nil
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to delete vnet %s in resource group %s", vnetSpec.Name, vnetSpec.ResourceGroup)
 	}
 
-	klog.V(2).Infof("successfully deleted vnet %s ", vnetSpec.Name)
+	log.V(2).Info("successfully deleted vnet")
 	return nil
 }
+
+// subnetIPConfigurations returns the IDs of the IP configurations in the subnets of the vnet, such as the ones of
+// network interfaces and load balancer frontends.
+func subnetIPConfigurations(vnet network.VirtualNetwork) []string {
+	var ids []string
+	if vnet.VirtualNetworkPropertiesFormat == nil || vnet.Subnets == nil {
+		return ids
+	}
+	for _, subnet := range *vnet.Subnets {
+		if subnet.SubnetPropertiesFormat == nil || subnet.IPConfigurations == nil {
+			continue
+		}
+		for _, ipConfig := range *subnet.IPConfigurations {
+			ids = append(ids, to.String(ipConfig.ID))
+		}
+	}
+	return ids
+}
@@ -38,15 +38,17 @@ import (
 
 func TestReconcileVnet(t *testing.T) {
 	testcases := []struct {
-		name   string
-		input  *infrav1.VnetSpec
-		output *infrav1.VnetSpec
-		expect func(m *mock_virtualnetworks.MockClientMockRecorder)
+		name                 string
+		input                *infrav1.VnetSpec
+		output               *infrav1.VnetSpec
+		ddosProtectionPlanID string
+		expectedError        string
+		expect               func(m *mock_virtualnetworks.MockClientMockRecorder)
 	}{
 		{
 			name:  "managed vnet exists",
-			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists"},
-			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", ID: "azure/fake/id", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", Tags: infrav1.Tags{
+			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists", CidrBlock: "10.0.0.0/8"},
+			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", ID: "azure/fake/id", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", CIDRBlocks: []string{"10.0.0.0/8"}, Tags: infrav1.Tags{
 				"Name": "vnet-exists",
 				"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
 				"sigs.k8s.io_cluster-api-provider-azure_role":                 "common",
@@ -80,10 +82,173 @@ func TestReconcileVnet(t *testing.T) {
 				m.CreateOrUpdate(context.TODO(), "my-rg", "vnet-new", gomock.AssignableToTypeOf(network.VirtualNetwork{}))
 			},
 		},
+		{
+			name:   "managed dual-stack vnet does not exist",
+			input:  &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CIDRBlocks: []string{"10.0.0.0/8", "fd00::/48"}},
+			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CIDRBlocks: []string{"10.0.0.0/8", "fd00::/48"}},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-new").
+					Return(network.VirtualNetwork{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+
+				m.CreateOrUpdate(context.TODO(), "my-rg", "vnet-new", network.VirtualNetwork{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"Name": to.StringPtr("vnet-new"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+					},
+					VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+						AddressSpace: &network.AddressSpace{
+							AddressPrefixes: &[]string{"10.0.0.0/8", "fd00::/48"},
+						},
+					},
+				})
+			},
+		},
+		{
+			name:                 "managed vnet with a DDoS protection plan does not exist",
+			input:                &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/8", DdosProtectionPlan: &infrav1.DdosProtectionPlan{Name: "my-plan"}},
+			output:               &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/8", DdosProtectionPlan: &infrav1.DdosProtectionPlan{Name: "my-plan"}},
+			ddosProtectionPlanID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/ddosProtectionPlans/my-plan",
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-new").
+					Return(network.VirtualNetwork{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+
+				m.CreateOrUpdate(context.TODO(), "my-rg", "vnet-new", network.VirtualNetwork{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"Name": to.StringPtr("vnet-new"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+					},
+					VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+						AddressSpace: &network.AddressSpace{
+							AddressPrefixes: &[]string{"10.0.0.0/8"},
+						},
+						EnableDdosProtection: to.BoolPtr(true),
+						DdosProtectionPlan: &network.SubResource{
+							ID: to.StringPtr("/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/ddosProtectionPlans/my-plan"),
+						},
+					},
+				})
+			},
+		},
+		{
+			name:  "managed vnet with a DDoS protection plan exists",
+			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", DdosProtectionPlan: &infrav1.DdosProtectionPlan{ID: "plan-id"}},
+			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", ID: "azure/fake/id", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", CIDRBlocks: []string{"10.0.0.0/8"},
+				DdosProtectionPlan: &infrav1.DdosProtectionPlan{ID: "plan-id"}, Tags: infrav1.Tags{"Name": "vnet-exists"}},
+			ddosProtectionPlanID: "plan-id",
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-exists").
+					Return(network.VirtualNetwork{
+						ID:   to.StringPtr("azure/fake/id"),
+						Name: to.StringPtr("vnet-exists"),
+						VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+							AddressSpace: &network.AddressSpace{
+								AddressPrefixes: to.StringSlicePtr([]string{"10.0.0.0/8"}),
+							},
+							EnableDdosProtection: to.BoolPtr(true),
+							DdosProtectionPlan:   &network.SubResource{ID: to.StringPtr("plan-id")},
+						},
+						Tags: map[string]*string{"Name": to.StringPtr("vnet-exists")},
+					}, nil)
+			},
+		},
+		{
+			name:   "managed vnet with two DNS servers does not exist",
+			input:  &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/8", DNSServers: []string{"10.0.0.4", "10.0.0.5"}},
+			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/8", DNSServers: []string{"10.0.0.4", "10.0.0.5"}},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-new").
+					Return(network.VirtualNetwork{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+
+				m.CreateOrUpdate(context.TODO(), "my-rg", "vnet-new", network.VirtualNetwork{
+					Location: to.StringPtr("test-location"),
+					Tags: map[string]*string{
+						"Name": to.StringPtr("vnet-new"),
+						"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						"sigs.k8s.io_cluster-api-provider-azure_role":                 to.StringPtr("common"),
+					},
+					VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+						AddressSpace: &network.AddressSpace{
+							AddressPrefixes: &[]string{"10.0.0.0/8"},
+						},
+						DhcpOptions: &network.DhcpOptions{
+							DNSServers: &[]string{"10.0.0.4", "10.0.0.5"},
+						},
+					},
+				})
+			},
+		},
+		{
+			name:  "managed vnet DNS servers are updated",
+			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", DNSServers: []string{"10.0.0.5", "10.0.0.6"}},
+			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", ID: "azure/fake/id", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", CIDRBlocks: []string{"10.0.0.0/8"},
+				DNSServers: []string{"10.0.0.5", "10.0.0.6"}, Tags: infrav1.Tags{
+					"Name": "vnet-exists",
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+				}},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				existing := func(dnsServers ...string) network.VirtualNetwork {
+					return network.VirtualNetwork{
+						ID:   to.StringPtr("azure/fake/id"),
+						Name: to.StringPtr("vnet-exists"),
+						VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+							AddressSpace: &network.AddressSpace{
+								AddressPrefixes: to.StringSlicePtr([]string{"10.0.0.0/8"}),
+							},
+							DhcpOptions: &network.DhcpOptions{DNSServers: &dnsServers},
+							Subnets:     &[]network.Subnet{{ID: to.StringPtr("subnet-id"), Name: to.StringPtr("my-subnet")}},
+						},
+						Tags: map[string]*string{
+							"Name": to.StringPtr("vnet-exists"),
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						},
+					}
+				}
+				m.Get(context.TODO(), "my-rg", "vnet-exists").Return(existing("10.0.0.4", "10.0.0.5"), nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "vnet-exists", existing("10.0.0.5", "10.0.0.6"))
+			},
+		},
+		{
+			name:  "managed vnet DNS servers are up to date",
+			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", DNSServers: []string{"10.0.0.4", "10.0.0.5"}},
+			output: &infrav1.VnetSpec{ResourceGroup: "my-rg", ID: "azure/fake/id", Name: "vnet-exists", CidrBlock: "10.0.0.0/8", CIDRBlocks: []string{"10.0.0.0/8"},
+				DNSServers: []string{"10.0.0.4", "10.0.0.5"}, Tags: infrav1.Tags{
+					"Name": "vnet-exists",
+					"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+				}},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-exists").
+					Return(network.VirtualNetwork{
+						ID:   to.StringPtr("azure/fake/id"),
+						Name: to.StringPtr("vnet-exists"),
+						VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+							AddressSpace: &network.AddressSpace{
+								AddressPrefixes: to.StringSlicePtr([]string{"10.0.0.0/8"}),
+							},
+							DhcpOptions: &network.DhcpOptions{DNSServers: &[]string{"10.0.0.4", "10.0.0.5"}},
+						},
+						Tags: map[string]*string{
+							"Name": to.StringPtr("vnet-exists"),
+							"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": to.StringPtr("owned"),
+						},
+					}, nil)
+			},
+		},
+		{
+			name:          "malformed DNS server",
+			input:         &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/8", DNSServers: []string{"10.0.0.4", "10.0.0"}},
+			output:        &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/8", DNSServers: []string{"10.0.0.4", "10.0.0"}},
+			expectedError: `invalid DNS server "10.0.0" for vnet vnet-new, it must be an IP address`,
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+			},
+		},
 		{
 			name:   "unmanaged vnet exists",
 			input:  &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", Name: "custom-vnet", CidrBlock: "10.0.0.0/16"},
-			output: &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", ID: "azure/custom-vnet/id", Name: "custom-vnet", CidrBlock: "10.0.0.0/16", Tags: infrav1.Tags{"Name": "my-custom-vnet"}},
+			output: &infrav1.VnetSpec{ResourceGroup: "custom-vnet-rg", ID: "azure/custom-vnet/id", Name: "custom-vnet", CidrBlock: "10.0.0.0/16", CIDRBlocks: []string{"10.0.0.0/16"}, Tags: infrav1.Tags{"Name": "my-custom-vnet"}},
 			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
 				m.Get(context.TODO(), "custom-vnet-rg", "custom-vnet").
 					Return(network.VirtualNetwork{
@@ -111,11 +276,20 @@ func TestReconcileVnet(t *testing.T) {
 				m.CreateOrUpdate(context.TODO(), "custom-vnet-rg", "custom-vnet", gomock.AssignableToTypeOf(network.VirtualNetwork{}))
 			},
 		},
+		{
+			name:          "malformed vnet CIDR block",
+			input:         &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/33"},
+			output:        &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-new", CidrBlock: "10.0.0.0/33"},
+			expectedError: `invalid CidrBlock "10.0.0.0/33" for vnet vnet-new: invalid CIDR address: 10.0.0.0/33`,
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+			},
+		},
 	}
 
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			vnetMock := mock_virtualnetworks.NewMockClient(mockCtrl)
 
 			cluster := &clusterv1.Cluster{
@@ -135,7 +309,7 @@ func TestReconcileVnet(t *testing.T) {
 				Cluster: cluster,
 				AzureCluster: &infrav1.AzureCluster{
 					Spec: infrav1.AzureClusterSpec{
-						Location: "test-location",
+						Location: "test-location",
 						NetworkSpec: infrav1.NetworkSpec{
 							Vnet: *tc.input,
 						},
@@ -152,11 +326,18 @@ func TestReconcileVnet(t *testing.T) {
 			}
 
 			vnetSpec := &Spec{
-				Name:          clusterScope.Vnet().Name,
-				ResourceGroup: clusterScope.Vnet().ResourceGroup,
-				CIDR:          clusterScope.Vnet().CidrBlock,
+				Name:                 clusterScope.Vnet().Name,
+				ResourceGroup:        clusterScope.Vnet().ResourceGroup,
+				CIDRs:                clusterScope.Vnet().CIDRs(),
+				DdosProtectionPlanID: tc.ddosProtectionPlanID,
+				DNSServers:           clusterScope.Vnet().DNSServers,
 			}
-			if err := s.Reconcile(context.TODO(), vnetSpec); err != nil {
+			err = s.Reconcile(context.TODO(), vnetSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
 				t.Fatalf("got an unexpected error: %v", err)
 			}
 
@@ -171,9 +352,10 @@ func TestReconcileVnet(t *testing.T) {
 
 func TestDeleteVnet(t *testing.T) {
 	testcases := []struct {
-		name   string
-		input  *infrav1.VnetSpec
-		expect func(m *mock_virtualnetworks.MockClientMockRecorder)
+		name          string
+		input         *infrav1.VnetSpec
+		expect        func(m *mock_virtualnetworks.MockClientMockRecorder)
+		expectedError string
 	}{
 		{
 			name: "managed vnet exists",
@@ -183,9 +365,35 @@ func TestDeleteVnet(t *testing.T) {
 				"sigs.k8s.io_cluster-api-provider-azure_role":                 "common",
 			}},
 			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-exists").Return(network.VirtualNetwork{Name: to.StringPtr("vnet-exists")}, nil)
 				m.Delete(context.TODO(), "my-rg", "vnet-exists")
 			},
 		},
+		{
+			name: "managed vnet is not deleted while network interfaces use its subnets",
+			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists", ID: "azure/vnet/id", Tags: infrav1.Tags{
+				"Name": "vnet-exists",
+				"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+				"sigs.k8s.io_cluster-api-provider-azure_role":                 "common",
+			}},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "vnet-exists").Return(network.VirtualNetwork{
+					Name: to.StringPtr("vnet-exists"),
+					VirtualNetworkPropertiesFormat: &network.VirtualNetworkPropertiesFormat{
+						Subnets: &[]network.Subnet{
+							{Name: to.StringPtr("empty-subnet"), SubnetPropertiesFormat: &network.SubnetPropertiesFormat{}},
+							{
+								Name: to.StringPtr("node-subnet"),
+								SubnetPropertiesFormat: &network.SubnetPropertiesFormat{
+									IPConfigurations: &[]network.IPConfiguration{{ID: to.StringPtr("my-nic-ipconfig")}},
+								},
+							},
+						},
+					},
+				}, nil)
+			},
+			expectedError: "vnet vnet-exists is still in use by my-nic-ipconfig",
+		},
 		{
 			name: "managed vnet already deleted",
 			input: &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "vnet-exists", ID: "azure/vnet/id", Tags: infrav1.Tags{
@@ -194,8 +402,8 @@ func TestDeleteVnet(t *testing.T) {
 				"sigs.k8s.io_cluster-api-provider-azure_role":                 "common",
 			}},
 			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
-				m.Delete(context.TODO(), "my-rg", "vnet-exists").
-					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.Get(context.TODO(), "my-rg", "vnet-exists").
+					Return(network.VirtualNetwork{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
 			},
 		},
 		{
@@ -203,10 +411,28 @@ func TestDeleteVnet(t *testing.T) {
 			input:  &infrav1.VnetSpec{ResourceGroup: "my-rg", Name: "my-vnet", ID: "azure/custom-vnet/id"},
 			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {},
 		},
+		{
+			name:   "vnet in a separate resource group without status",
+			input:  &infrav1.VnetSpec{ResourceGroup: "network-rg", Name: "shared-vnet"},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {},
+		},
+		{
+			name: "managed vnet in a separate resource group",
+			input: &infrav1.VnetSpec{ResourceGroup: "network-rg", Name: "vnet-exists", ID: "azure/vnet/id", Tags: infrav1.Tags{
+				"Name": "vnet-exists",
+				"sigs.k8s.io_cluster-api-provider-azure_cluster_test-cluster": "owned",
+				"sigs.k8s.io_cluster-api-provider-azure_role":                 "common",
+			}},
+			expect: func(m *mock_virtualnetworks.MockClientMockRecorder) {
+				m.Get(context.TODO(), "network-rg", "vnet-exists").Return(network.VirtualNetwork{Name: to.StringPtr("vnet-exists")}, nil)
+				m.Delete(context.TODO(), "network-rg", "vnet-exists")
+			},
+		},
 	}
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
 			vnetMock := mock_virtualnetworks.NewMockClient(mockCtrl)
 
 			cluster := &clusterv1.Cluster{
@@ -226,7 +452,8 @@ func TestDeleteVnet(t *testing.T) {
 				Cluster: cluster,
 				AzureCluster: &infrav1.AzureCluster{
 					Spec: infrav1.AzureClusterSpec{
-						Location: "test-location",
+						ResourceGroup: "my-rg",
+						Location:      "test-location",
 						NetworkSpec: infrav1.NetworkSpec{
 							Vnet: *tc.input,
 						},
@@ -245,9 +472,14 @@ func TestDeleteVnet(t *testing.T) {
 			vnetSpec := &Spec{
 				Name:          clusterScope.Vnet().Name,
 				ResourceGroup: clusterScope.Vnet().ResourceGroup,
-				CIDR:          clusterScope.Vnet().CidrBlock,
+				CIDRs:         clusterScope.Vnet().CIDRs(),
 			}
-			if err := s.Delete(context.TODO(), vnetSpec); err != nil {
+			err = s.Delete(context.TODO(), vnetSpec)
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
 				t.Fatalf("got an unexpected error: %v", err)
 			}
 		})
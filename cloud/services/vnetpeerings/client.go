@@ -0,0 +1,96 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vnetpeerings
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+)
+
+// Client wraps go-sdk
+type Client interface {
+	Get(context.Context, string, string, string) (network.VirtualNetworkPeering, error)
+	CreateOrUpdate(context.Context, string, string, string, network.VirtualNetworkPeering) error
+	Delete(context.Context, string, string, string) error
+}
+
+// AzureClient contains the Azure go-sdk Client
+type AzureClient struct {
+	peerings network.VirtualNetworkPeeringsClient
+}
+
+var _ Client = &AzureClient{}
+
+// NewClient creates a new vnet peerings client from subscription ID and base URI.
+func NewClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) *AzureClient {
+	c := newVirtualNetworkPeeringsClient(subscriptionID, baseURI, authorizer)
+	return &AzureClient{c}
+}
+
+// newVirtualNetworkPeeringsClient creates a new vnet peerings client from subscription ID and base URI.
+func newVirtualNetworkPeeringsClient(subscriptionID, baseURI string, authorizer autorest.Authorizer) network.VirtualNetworkPeeringsClient {
+	peeringsClient := network.NewVirtualNetworkPeeringsClientWithBaseURI(baseURI, subscriptionID)
+	peeringsClient.Authorizer = authorizer
+	peeringsClient.AddToUserAgent(azure.UserAgent)
+	return peeringsClient
+}
+
+// Get gets the specified peering of a vnet.
+func (ac *AzureClient) Get(ctx context.Context, resourceGroupName, vnetName, peeringName string) (network.VirtualNetworkPeering, error) {
+	var result network.VirtualNetworkPeering
+	err := azure.CallAPI(ctx, "vnetpeerings", "Get", func() error {
+		var err error
+		result, err = ac.peerings.Get(ctx, resourceGroupName, vnetName, peeringName)
+		return err
+	})
+	return result, err
+}
+
+// CreateOrUpdate creates or updates a peering of a vnet in the specified resource group.
+func (ac *AzureClient) CreateOrUpdate(ctx context.Context, resourceGroupName, vnetName, peeringName string, peering network.VirtualNetworkPeering) error {
+	var future network.VirtualNetworkPeeringsCreateOrUpdateFuture
+	return azure.CallLongRunningAPI(ctx, "vnetpeerings", "CreateOrUpdate", func() error {
+		var err error
+		future, err = ac.peerings.CreateOrUpdate(ctx, resourceGroupName, vnetName, peeringName, peering)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.peerings.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.peerings)
+		return err
+	})
+}
+
+// Delete deletes the specified peering of a vnet.
+func (ac *AzureClient) Delete(ctx context.Context, resourceGroupName, vnetName, peeringName string) error {
+	var future network.VirtualNetworkPeeringsDeleteFuture
+	return azure.CallLongRunningAPI(ctx, "vnetpeerings", "Delete", func() error {
+		var err error
+		future, err = ac.peerings.Delete(ctx, resourceGroupName, vnetName, peeringName)
+		return err
+	}, func() error {
+		if err := future.WaitForCompletionRef(ctx, ac.peerings.Client); err != nil {
+			return err
+		}
+		_, err := future.Result(ac.peerings)
+		return err
+	})
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vnetpeerings
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+)
+
+// Spec specification for vnet peerings
+type Spec struct {
+	Name                  string
+	VnetName              string
+	RemoteVnetID          string
+	AllowForwardedTraffic bool
+	AllowGatewayTransit   bool
+}
+
+// Get provides information about a vnet peering.
+func (s *Service) Get(ctx context.Context, spec interface{}) (interface{}, error) {
+	peeringSpec, ok := spec.(*Spec)
+	if !ok {
+		return network.VirtualNetworkPeering{}, errors.New("invalid vnet peering specification")
+	}
+	peering, err := s.Client.Get(ctx, s.Scope.Vnet().ResourceGroup, peeringSpec.VnetName, peeringSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		return nil, errors.Wrapf(err, "vnet peering %s not found", peeringSpec.Name)
+	} else if err != nil {
+		return peering, err
+	}
+	return peering, nil
+}
+
+// Reconcile gets/creates/updates the peering from the cluster vnet to a remote vnet. The peering from the remote
+// vnet back to the cluster vnet is not managed.
+func (s *Service) Reconcile(ctx context.Context, spec interface{}) error {
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		s.Scope.V(4).Info("Skipping vnet peering reconcile in custom vnet mode")
+		return nil
+	}
+	peeringSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid vnet peering specification")
+	}
+	log := s.Scope.WithValues("resourceGroup", s.Scope.Vnet().ResourceGroup, "vnet", peeringSpec.VnetName, "name", peeringSpec.Name)
+	log.V(4).Info("reconciling vnet peering")
+
+	existing, err := s.Client.Get(ctx, s.Scope.Vnet().ResourceGroup, peeringSpec.VnetName, peeringSpec.Name)
+	switch {
+	case err != nil && !azure.ResourceNotFound(err):
+		return errors.Wrapf(err, "failed to get vnet peering %s of vnet %s in resource group %s",
+			peeringSpec.Name, peeringSpec.VnetName, s.Scope.Vnet().ResourceGroup)
+	case err == nil && isUpToDate(existing, peeringSpec):
+		log.V(4).Info("vnet peering is up to date")
+		return nil
+	}
+
+	peering := network.VirtualNetworkPeering{
+		VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+			AllowVirtualNetworkAccess: to.BoolPtr(true),
+			AllowForwardedTraffic:     to.BoolPtr(peeringSpec.AllowForwardedTraffic),
+			AllowGatewayTransit:       to.BoolPtr(peeringSpec.AllowGatewayTransit),
+			RemoteVirtualNetwork:      &network.SubResource{ID: to.StringPtr(peeringSpec.RemoteVnetID)},
+		},
+	}
+	if s.Scope.DryRun(scope.CreateOrUpdateAction("vnet peering", s.Scope.Vnet().ResourceGroup, peeringSpec.Name, peering)) {
+		return nil
+	}
+
+	log.V(2).Info("creating vnet peering", "remoteVnet", peeringSpec.RemoteVnetID)
+	err = s.Client.CreateOrUpdate(
+		ctx,
+		s.Scope.Vnet().ResourceGroup,
+		peeringSpec.VnetName,
+		peeringSpec.Name,
+		peering,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create vnet peering %s of vnet %s in resource group %s",
+			peeringSpec.Name, peeringSpec.VnetName, s.Scope.Vnet().ResourceGroup)
+	}
+
+	log.V(2).Info("successfully created vnet peering")
+	return nil
+}
+
+// Delete deletes the peering from the cluster vnet to a remote vnet.
+func (s *Service) Delete(ctx context.Context, spec interface{}) error {
+	if !s.Scope.Vnet().IsManaged(s.Scope.Name()) {
+		s.Scope.V(4).Info("Skipping vnet peering deletion in custom vnet mode")
+		return nil
+	}
+	peeringSpec, ok := spec.(*Spec)
+	if !ok {
+		return errors.New("invalid vnet peering specification")
+	}
+	log := s.Scope.WithValues("resourceGroup", s.Scope.Vnet().ResourceGroup, "vnet", peeringSpec.VnetName, "name", peeringSpec.Name)
+	if s.Scope.DryRun(scope.DeleteAction("vnet peering", s.Scope.Vnet().ResourceGroup, peeringSpec.Name)) {
+		return nil
+	}
+
+	log.V(2).Info("deleting vnet peering")
+	err := s.Client.Delete(ctx, s.Scope.Vnet().ResourceGroup, peeringSpec.VnetName, peeringSpec.Name)
+	if err != nil && azure.ResourceNotFound(err) {
+		// already deleted
+		log.V(4).Info("vnet peering is already deleted")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete vnet peering %s of vnet %s in resource group %s",
+			peeringSpec.Name, peeringSpec.VnetName, s.Scope.Vnet().ResourceGroup)
+	}
+
+	log.V(2).Info("successfully deleted vnet peering")
+	return nil
+}
+
+// isUpToDate returns true if the existing peering connects to the remote vnet of the spec with the same settings.
+// Resource IDs are case insensitive.
+func isUpToDate(existing network.VirtualNetworkPeering, peeringSpec *Spec) bool {
+	props := existing.VirtualNetworkPeeringPropertiesFormat
+	if props == nil || props.RemoteVirtualNetwork == nil {
+		return false
+	}
+	return strings.EqualFold(to.String(props.RemoteVirtualNetwork.ID), peeringSpec.RemoteVnetID) &&
+		to.Bool(props.AllowVirtualNetworkAccess) &&
+		to.Bool(props.AllowForwardedTraffic) == peeringSpec.AllowForwardedTraffic &&
+		to.Bool(props.AllowGatewayTransit) == peeringSpec.AllowGatewayTransit
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vnetpeerings
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/vnetpeerings/mock_vnetpeerings"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const hubVnetID = "/subscriptions/123/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet"
+
+func TestReconcileVnetPeering(t *testing.T) {
+	testcases := []struct {
+		name          string
+		vnet          infrav1.VnetSpec
+		expectedError string
+		expect        func(m *mock_vnetpeerings.MockClientMockRecorder)
+	}{
+		{
+			name: "vnet peering does not exist",
+			vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vnet", "my-peering").
+					Return(network.VirtualNetworkPeering{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vnet", "my-peering", network.VirtualNetworkPeering{
+					VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+						AllowVirtualNetworkAccess: to.BoolPtr(true),
+						AllowForwardedTraffic:     to.BoolPtr(true),
+						AllowGatewayTransit:       to.BoolPtr(false),
+						RemoteVirtualNetwork:      &network.SubResource{ID: to.StringPtr(hubVnetID)},
+					},
+				})
+			},
+		},
+		{
+			name: "vnet peering already exists",
+			vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vnet", "my-peering").Return(network.VirtualNetworkPeering{
+					ID:   to.StringPtr("peering-id"),
+					Name: to.StringPtr("my-peering"),
+					VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+						AllowVirtualNetworkAccess: to.BoolPtr(true),
+						AllowForwardedTraffic:     to.BoolPtr(true),
+						AllowGatewayTransit:       to.BoolPtr(false),
+						// Azure may return the resource ID in another case.
+						RemoteVirtualNetwork: &network.SubResource{ID: to.StringPtr("/subscriptions/123/resourcegroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet")},
+						PeeringState:         network.VirtualNetworkPeeringStateInitiated,
+					},
+				}, nil)
+			},
+		},
+		{
+			name: "vnet peering exists with other settings",
+			vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vnet", "my-peering").Return(network.VirtualNetworkPeering{
+					ID:   to.StringPtr("peering-id"),
+					Name: to.StringPtr("my-peering"),
+					VirtualNetworkPeeringPropertiesFormat: &network.VirtualNetworkPeeringPropertiesFormat{
+						AllowVirtualNetworkAccess: to.BoolPtr(true),
+						AllowForwardedTraffic:     to.BoolPtr(false),
+						AllowGatewayTransit:       to.BoolPtr(false),
+						RemoteVirtualNetwork:      &network.SubResource{ID: to.StringPtr(hubVnetID)},
+					},
+				}, nil)
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vnet", "my-peering", gomock.AssignableToTypeOf(network.VirtualNetworkPeering{}))
+			},
+		},
+		{
+			name: "vnet peering is not created for a custom vnet",
+			vnet: infrav1.VnetSpec{Name: "custom-vnet", ResourceGroup: "custom-rg", ID: "custom-vnet-id"},
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+			},
+		},
+		{
+			name:          "fail to create the vnet peering",
+			vnet:          infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+			expectedError: "failed to create vnet peering my-peering of vnet my-vnet in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Get(context.TODO(), "my-rg", "my-vnet", "my-peering").
+					Return(network.VirtualNetworkPeering{}, autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+				m.CreateOrUpdate(context.TODO(), "my-rg", "my-vnet", "my-peering", gomock.AssignableToTypeOf(network.VirtualNetworkPeering{})).
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			peeringsMock := mock_vnetpeerings.NewMockClient(mockCtrl)
+			tc.expect(peeringsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec:   infrav1.NetworkSpec{Vnet: tc.vnet},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: peeringsMock,
+			}
+
+			err = s.Reconcile(context.TODO(), &Spec{
+				Name:                  "my-peering",
+				VnetName:              tc.vnet.Name,
+				RemoteVnetID:          hubVnetID,
+				AllowForwardedTraffic: true,
+			})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteVnetPeering(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expectedError string
+		expect        func(m *mock_vnetpeerings.MockClientMockRecorder)
+	}{
+		{
+			name: "vnet peering exists",
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vnet", "my-peering")
+			},
+		},
+		{
+			name: "vnet peering already deleted",
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vnet", "my-peering").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found"))
+			},
+		},
+		{
+			name:          "vnet peering deletion fails",
+			expectedError: "failed to delete vnet peering my-peering of vnet my-vnet in resource group my-rg: #: Internal Server Error: StatusCode=500",
+			expect: func(m *mock_vnetpeerings.MockClientMockRecorder) {
+				m.Delete(context.TODO(), "my-rg", "my-vnet", "my-peering").
+					Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error"))
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			peeringsMock := mock_vnetpeerings.NewMockClient(mockCtrl)
+			tc.expect(peeringsMock.EXPECT())
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster"},
+			}
+			clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+				AzureClients: scope.AzureClients{
+					SubscriptionID: "123",
+					Authorizer:     autorest.NullAuthorizer{},
+				},
+				Client:  fake.NewFakeClient(cluster),
+				Cluster: cluster,
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						Location:      "test-location",
+						ResourceGroup: "my-rg",
+						NetworkSpec:   infrav1.NetworkSpec{Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"}},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test context: %v", err)
+			}
+
+			s := &Service{
+				Scope:  clusterScope,
+				Client: peeringsMock,
+			}
+
+			err = s.Delete(context.TODO(), &Spec{Name: "my-peering", VnetName: "my-vnet", RemoteVnetID: hubVnetID})
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -24,7 +24,9 @@ import (
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 	"sigs.k8s.io/cluster-api/util"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -32,10 +34,22 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
+// deletionRetryInterval is how long the deletion of a cluster waits for its machines, or for the resources which still
+// use its network, to be deleted before it is retried.
+const deletionRetryInterval = 15 * time.Second
+
+// vpnGatewayPollInterval is the delay before a reconcile polls the creation or the deletion of the VPN gateway in
+// progress again. Both take tens of minutes.
+const vpnGatewayPollInterval = time.Minute
+
 // AzureClusterReconciler reconciles a AzureCluster object
 type AzureClusterReconciler struct {
 	client.Client
 	Log logr.Logger
+	// AzureClients holds the rate limit of the requests to the Azure API.
+	AzureClients scope.AzureClients
+	// DryRun makes the reconciler log the changes it would make to the Azure resources, without making them.
+	DryRun bool
 }
 
 func (r *AzureClusterReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
@@ -48,6 +62,8 @@ func (r *AzureClusterReconciler) SetupWithManager(mgr ctrl.Manager, options cont
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azureclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azureclusters/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 func (r *AzureClusterReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
 	ctx := context.TODO()
@@ -77,10 +93,12 @@ func (r *AzureClusterReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, ret
 
 	// Create the scope.
 	clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+		AzureClients: r.AzureClients,
 		Client:       r.Client,
 		Logger:       log,
 		Cluster:      cluster,
 		AzureCluster: azureCluster,
+		DryRun:       r.DryRun,
 	})
 	if err != nil {
 		return reconcile.Result{}, errors.Errorf("failed to create scope: %+v", err)
@@ -88,6 +106,7 @@ func (r *AzureClusterReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, ret
 
 	// Always close the scope when exiting this function so we can persist any AzureMachine changes.
 	defer func() {
+		logPlannedActions(clusterScope)
 		if err := clusterScope.Close(); err != nil && reterr == nil {
 			reterr = err
 		}
@@ -95,11 +114,13 @@ func (r *AzureClusterReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, ret
 
 	// Handle deleted clusters
 	if !azureCluster.DeletionTimestamp.IsZero() {
-		return r.reconcileDelete(clusterScope)
+		result, err := r.reconcileDelete(clusterScope)
+		return requeueOnError(log, result, err)
 	}
 
 	// Handle non-deleted clusters
-	return r.reconcileNormal(clusterScope)
+	result, err := r.reconcileNormal(clusterScope)
+	return requeueOnError(log, result, err)
 }
 
 func (r *AzureClusterReconciler) reconcileNormal(clusterScope *scope.ClusterScope) (reconcile.Result, error) {
@@ -112,14 +133,15 @@ func (r *AzureClusterReconciler) reconcileNormal(clusterScope *scope.ClusterScop
 		azureCluster.Finalizers = append(azureCluster.Finalizers, infrav1.ClusterFinalizer)
 	}
 
+	// the VPN gateway is reconciled last and is not needed by the machines, the cluster is ready while it is created.
 	err := newAzureClusterReconciler(clusterScope).Reconcile()
-	if err != nil {
+	vpnGatewayPending := azure.IsOperationNotDoneError(err)
+	if err != nil && !vpnGatewayPending {
 		return reconcile.Result{}, errors.Wrap(err, "failed to reconcile cluster services")
 	}
 
-	// TODO: We may need to use azureCluster.Status.Network.APIServerIP.IPAddress
-	//       instead when we look at configuring private clusters.
-	if azureCluster.Status.Network.APIServerIP.DNSName == "" {
+	host := apiServerHost(clusterScope)
+	if host == "" {
 		clusterScope.Info("Waiting for API server endpoint to exist")
 		return reconcile.Result{RequeueAfter: 15 * time.Second}, nil
 	}
@@ -127,7 +149,7 @@ func (r *AzureClusterReconciler) reconcileNormal(clusterScope *scope.ClusterScop
 	// Set APIEndpoints so the Cluster API Cluster Controller can pull them
 	azureCluster.Status.APIEndpoints = []infrav1.APIEndpoint{
 		{
-			Host: azureCluster.Status.Network.APIServerIP.DNSName,
+			Host: host,
 			Port: int(clusterScope.APIServerPort()),
 		},
 	}
@@ -135,15 +157,49 @@ func (r *AzureClusterReconciler) reconcileNormal(clusterScope *scope.ClusterScop
 	// No errors, so mark us ready so the Cluster API Cluster Controller can pull it
 	azureCluster.Status.Ready = true
 
+	if vpnGatewayPending {
+		clusterScope.Info("Waiting for VPN gateway operation to complete", "reason", err.Error())
+		return reconcile.Result{RequeueAfter: vpnGatewayPollInterval}, nil
+	}
 	return reconcile.Result{}, nil
 }
 
+// apiServerHost returns the host of the API server endpoint, the private IP of the internal load balancer for an
+// internal API server. Otherwise it's the DNS name of the public IP, or its address if the public IP has no DNS name.
+func apiServerHost(clusterScope *scope.ClusterScope) string {
+	if clusterScope.IsAPIServerInternal() {
+		return clusterScope.Network().APIServerLB.FrontendIPConfig.PrivateIPAddress
+	}
+	if clusterScope.Network().APIServerIP.DNSName != "" {
+		return clusterScope.Network().APIServerIP.DNSName
+	}
+	return clusterScope.Network().APIServerIP.IPAddress
+}
+
 func (r *AzureClusterReconciler) reconcileDelete(clusterScope *scope.ClusterScope) (reconcile.Result, error) {
 	clusterScope.Info("Reconciling AzureCluster delete")
 
 	azureCluster := clusterScope.AzureCluster
 
+	// the virtual machines are deleted before the network they are in.
+	machines := &clusterv1.MachineList{}
+	if err := r.List(clusterScope.Context, machines, client.InNamespace(azureCluster.Namespace), clusterScope.ListOptionsLabelSelector()); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "failed to list machines of AzureCluster %s/%s", azureCluster.Namespace, azureCluster.Name)
+	}
+	if len(machines.Items) > 0 {
+		clusterScope.Info("Waiting for machines to be deleted", "machines", len(machines.Items))
+		return reconcile.Result{RequeueAfter: deletionRetryInterval}, nil
+	}
+
 	if err := newAzureClusterReconciler(clusterScope).Delete(); err != nil {
+		if azure.IsResourceInUseError(err) {
+			clusterScope.Info("Waiting for dependent resources to be deleted", "reason", err.Error())
+			return reconcile.Result{RequeueAfter: deletionRetryInterval}, nil
+		}
+		if azure.IsOperationNotDoneError(err) {
+			clusterScope.Info("Waiting for VPN gateway to be deleted", "reason", err.Error())
+			return reconcile.Result{RequeueAfter: vpnGatewayPollInterval}, nil
+		}
 		return reconcile.Result{}, errors.Wrapf(err, "error deleting AzureCluster %s/%s", azureCluster.Namespace, azureCluster.Name)
 	}
 
@@ -152,3 +208,13 @@ func (r *AzureClusterReconciler) reconcileDelete(clusterScope *scope.ClusterScop
 
 	return reconcile.Result{}, nil
 }
+
+// logPlannedActions logs the changes to the Azure resources the services skipped in dry-run mode.
+func logPlannedActions(clusterScope *scope.ClusterScope) {
+	for _, action := range clusterScope.PlannedActions() {
+		clusterScope.Info("Planned action", "action", action.String())
+		if action.Desired != nil {
+			clusterScope.V(4).Info("Desired state of planned action", "action", action.String(), "desired", action.Desired)
+		}
+	}
+}
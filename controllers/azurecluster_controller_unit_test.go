@@ -0,0 +1,277 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/klogr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestAzureClusterReconciler_APIServerHost(t *testing.T) {
+	testcases := []struct {
+		name         string
+		network      infrav1.NetworkSpec
+		apiServerIP  infrav1.PublicIP
+		apiServerLB  infrav1.LoadBalancer
+		expectedHost string
+	}{
+		{
+			name:         "public ip with a dns name",
+			apiServerIP:  infrav1.PublicIP{Name: "my-ip", IPAddress: "20.0.0.1", DNSName: "my-ip.eastus.cloudapp.azure.com"},
+			expectedHost: "my-ip.eastus.cloudapp.azure.com",
+		},
+		{
+			name:         "public ip without a dns name",
+			apiServerIP:  infrav1.PublicIP{Name: "my-ip", IPAddress: "20.0.0.1"},
+			expectedHost: "20.0.0.1",
+		},
+		{
+			name:         "public ip not allocated yet",
+			apiServerIP:  infrav1.PublicIP{Name: "my-ip"},
+			expectedHost: "",
+		},
+		{
+			name: "internal api server",
+			network: infrav1.NetworkSpec{
+				APIServerLB: infrav1.LoadBalancerSpec{Type: infrav1.LoadBalancerTypeInternal},
+			},
+			apiServerLB: infrav1.LoadBalancer{
+				FrontendIPConfig: infrav1.FrontendIPConfig{PrivateIPAddress: "10.0.0.100"},
+			},
+			expectedHost: "10.0.0.100",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			clusterScope := &scope.ClusterScope{
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{NetworkSpec: tc.network},
+					Status: infrav1.AzureClusterStatus{
+						Network: infrav1.Network{APIServerIP: tc.apiServerIP, APIServerLB: tc.apiServerLB},
+					},
+				},
+			}
+			if host := apiServerHost(clusterScope); host != tc.expectedHost {
+				t.Errorf("expected host %q, got %q", tc.expectedHost, host)
+			}
+		})
+	}
+}
+
+func TestAzureClusterReconciler_APIServerProbe(t *testing.T) {
+	testcases := []struct {
+		name          string
+		sku           infrav1.SKU
+		healthProbe   *infrav1.HealthProbe
+		expectedProbe *network.ProbePropertiesFormat
+		expectedError string
+	}{
+		{
+			name:          "default probe",
+			expectedProbe: nil,
+		},
+		{
+			name:        "tcp probe with a custom interval",
+			healthProbe: &infrav1.HealthProbe{IntervalSeconds: to.Int32Ptr(5), NumberOfProbes: to.Int32Ptr(2)},
+			expectedProbe: &network.ProbePropertiesFormat{
+				Protocol:          network.ProbeProtocolTCP,
+				Port:              to.Int32Ptr(6443),
+				IntervalInSeconds: to.Int32Ptr(5),
+				NumberOfProbes:    to.Int32Ptr(2),
+			},
+		},
+		{
+			name: "https probe of healthz",
+			healthProbe: &infrav1.HealthProbe{
+				Protocol:        infrav1.ProbeProtocolHTTPS,
+				Port:            to.Int32Ptr(443),
+				RequestPath:     "/healthz",
+				IntervalSeconds: to.Int32Ptr(10),
+				NumberOfProbes:  to.Int32Ptr(3),
+			},
+			expectedProbe: &network.ProbePropertiesFormat{
+				Protocol:          network.ProbeProtocolHTTPS,
+				Port:              to.Int32Ptr(443),
+				RequestPath:       to.StringPtr("/healthz"),
+				IntervalInSeconds: to.Int32Ptr(10),
+				NumberOfProbes:    to.Int32Ptr(3),
+			},
+		},
+		{
+			name:        "http probe with default interval",
+			sku:         infrav1.SKUBasic,
+			healthProbe: &infrav1.HealthProbe{Protocol: infrav1.ProbeProtocolHTTP, RequestPath: "/healthz"},
+			expectedProbe: &network.ProbePropertiesFormat{
+				Protocol:          network.ProbeProtocolHTTP,
+				Port:              to.Int32Ptr(6443),
+				RequestPath:       to.StringPtr("/healthz"),
+				IntervalInSeconds: to.Int32Ptr(15),
+				NumberOfProbes:    to.Int32Ptr(4),
+			},
+		},
+		{
+			name:          "https probe without a request path",
+			healthProbe:   &infrav1.HealthProbe{Protocol: infrav1.ProbeProtocolHTTPS},
+			expectedError: "Https health probe of cluster my-cluster requires a request path",
+		},
+		{
+			name:          "tcp probe with a request path",
+			healthProbe:   &infrav1.HealthProbe{RequestPath: "/healthz"},
+			expectedError: "Tcp health probe of cluster my-cluster cannot have a request path",
+		},
+		{
+			name:          "https probe of a basic load balancer",
+			sku:           infrav1.SKUBasic,
+			healthProbe:   &infrav1.HealthProbe{Protocol: infrav1.ProbeProtocolHTTPS, RequestPath: "/healthz"},
+			expectedError: "Basic load balancers cannot use Https health probes, only Standard load balancers support them",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							NetworkSpec: infrav1.NetworkSpec{
+								APIServerLB: infrav1.LoadBalancerSpec{SKU: tc.sku, HealthProbe: tc.healthProbe},
+							},
+						},
+					},
+				},
+			}
+			probe, err := r.apiServerProbe()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			g.Expect(probe).To(gomega.Equal(tc.expectedProbe))
+		})
+	}
+}
+
+func TestAdditionalRules(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	r := &azureClusterReconciler{
+		scope: &scope.ClusterScope{
+			Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"}},
+			AzureCluster: &infrav1.AzureCluster{
+				Spec: infrav1.AzureClusterSpec{
+					NetworkSpec: infrav1.NetworkSpec{
+						APIServerLB: infrav1.LoadBalancerSpec{
+							AdditionalRules: []infrav1.LoadBalancingRule{
+								{
+									Name:         "metrics",
+									FrontendPort: 9090,
+									BackendPool:  infrav1.BackendPoolNode,
+									Probe:        infrav1.HealthProbe{Protocol: infrav1.ProbeProtocolHTTP, RequestPath: "/metrics"},
+								},
+								{
+									Name:         "syslog",
+									Protocol:     infrav1.LoadBalancingRuleProtocolUDP,
+									FrontendPort: 514,
+									BackendPort:  to.Int32Ptr(1514),
+									Probe:        infrav1.HealthProbe{Port: to.Int32Ptr(1601)},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	rules, err := r.additionalRules()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(rules).To(gomega.Equal([]publicloadbalancers.Rule{
+		{
+			Name:            "metrics",
+			FrontendPort:    9090,
+			BackendPort:     9090,
+			BackendPoolName: "node-backEndPool",
+			Probe: &network.ProbePropertiesFormat{
+				Protocol:          network.ProbeProtocolHTTP,
+				Port:              to.Int32Ptr(9090),
+				RequestPath:       to.StringPtr("/metrics"),
+				IntervalInSeconds: to.Int32Ptr(15),
+				NumberOfProbes:    to.Int32Ptr(4),
+			},
+		},
+		{
+			Name:         "syslog",
+			Protocol:     network.TransportProtocolUDP,
+			FrontendPort: 514,
+			BackendPort:  1514,
+			Probe: &network.ProbePropertiesFormat{
+				Protocol:          network.ProbeProtocolTCP,
+				Port:              to.Int32Ptr(1601),
+				IntervalInSeconds: to.Int32Ptr(15),
+				NumberOfProbes:    to.Int32Ptr(4),
+			},
+		},
+	}))
+
+	r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.AdditionalRules[0].Probe.RequestPath = ""
+	_, err = r.additionalRules()
+	g.Expect(err).To(gomega.MatchError("Http health probe of load balancing rule metrics of cluster my-cluster requires a request path"))
+}
+
+func TestAzureClusterReconciler_ReconcileDeleteWaitsForMachines(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme, err := setupScheme()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	cluster := newCluster("my-cluster")
+	azureCluster := &infrav1.AzureCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "my-cluster",
+			Namespace:  "default",
+			Finalizers: []string{infrav1.ClusterFinalizer},
+		},
+	}
+	r := &AzureClusterReconciler{
+		Client: fake.NewFakeClientWithScheme(scheme, cluster, azureCluster, newMachine("my-cluster", "my-machine")),
+	}
+	clusterScope := &scope.ClusterScope{
+		Logger:       klogr.New(),
+		Cluster:      cluster,
+		AzureCluster: azureCluster,
+		Context:      context.TODO(),
+	}
+
+	// the network is not touched while the machines of the cluster exist, no Azure service is set up.
+	result, err := r.reconcileDelete(clusterScope)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.Equal(deletionRetryInterval))
+	g.Expect(azureCluster.Finalizers).To(gomega.ConsistOf(infrav1.ClusterFinalizer))
+}
@@ -18,57 +18,163 @@ package controllers
 
 import (
 	"fmt"
-	"hash/fnv"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilitysets"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/backendpools"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/ddosprotectionplans"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/groups"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/internalloadbalancers"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/natgateways"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/privatednszones"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/privateendpoints"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/proximityplacementgroups"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/routetables"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/securitygroups"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/subnets"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualnetworkgateways"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualnetworks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/vnetpeerings"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/cluster-api/util"
 )
 
 // azureClusterReconciler are list of services required by cluster controller
 type azureClusterReconciler struct {
-	scope            *scope.ClusterScope
-	groupsSvc        azure.Service
-	vnetSvc          azure.Service
-	securityGroupSvc azure.Service
-	routeTableSvc    azure.Service
-	subnetsSvc       azure.Service
-	internalLBSvc    azure.Service
-	publicIPSvc      azure.Service
-	publicLBSvc      azure.Service
+	scope              *scope.ClusterScope
+	groupsSvc          azure.Service
+	vnetSvc            azure.Service
+	securityGroupSvc   *securitygroups.Service
+	routeTableSvc      azure.Service
+	natGatewaySvc      azure.Service
+	ddosPlanSvc        azure.Service
+	subnetsSvc         azure.Service
+	internalLBSvc      azure.Service
+	publicIPSvc        azure.GetterService
+	publicLBSvc        azure.Service
+	backendPoolsSvc    azure.Service
+	availabilitySetSvc azure.Service
+	ppgSvc             azure.Service
+	privateEndpointSvc azure.Service
+	vnetPeeringSvc     azure.Service
+	vpnGatewaySvc      azure.Service
+	privateDNSSvc      azure.Service
 }
 
 // newAzureClusterReconciler populates all the services based on input scope
 func newAzureClusterReconciler(scope *scope.ClusterScope) *azureClusterReconciler {
 	return &azureClusterReconciler{
-		scope:            scope,
-		groupsSvc:        groups.NewService(scope),
-		vnetSvc:          virtualnetworks.NewService(scope),
-		securityGroupSvc: securitygroups.NewService(scope),
-		routeTableSvc:    routetables.NewService(scope),
-		subnetsSvc:       subnets.NewService(scope),
-		internalLBSvc:    internalloadbalancers.NewService(scope),
-		publicIPSvc:      publicips.NewService(scope),
-		publicLBSvc:      publicloadbalancers.NewService(scope),
+		scope:              scope,
+		groupsSvc:          groups.NewService(scope),
+		vnetSvc:            virtualnetworks.NewService(scope),
+		securityGroupSvc:   securitygroups.NewService(scope),
+		routeTableSvc:      routetables.NewService(scope),
+		natGatewaySvc:      natgateways.NewService(scope),
+		ddosPlanSvc:        ddosprotectionplans.NewService(scope),
+		subnetsSvc:         subnets.NewService(scope),
+		internalLBSvc:      internalloadbalancers.NewService(scope),
+		publicIPSvc:        publicips.NewService(scope),
+		publicLBSvc:        publicloadbalancers.NewService(scope),
+		backendPoolsSvc:    backendpools.NewService(scope),
+		availabilitySetSvc: availabilitysets.NewService(scope),
+		ppgSvc:             proximityplacementgroups.NewService(scope),
+		privateEndpointSvc: privateendpoints.NewService(scope),
+		vnetPeeringSvc:     vnetpeerings.NewService(scope),
+		vpnGatewaySvc:      virtualnetworkgateways.NewService(scope),
+		privateDNSSvc:      privatednszones.NewService(scope),
 	}
 }
 
-// Reconcile reconciles all the services in pre determined order
+// Reconcile reconciles all the services in pre determined order, the services which do not depend on each other run
+// concurrently. It reports whether the network, the load balancers and the VPN gateway are reconciled in the
+// conditions of the AzureCluster. While the VPN gateway is created, an azure.OperationNotDoneError is returned.
 func (r *azureClusterReconciler) Reconcile() error {
 	klog.V(2).Infof("reconciling cluster %s", r.scope.Name())
-	r.createOrUpdateNetworkAPIServerIP()
+	if !r.scope.IsAPIServerInternal() {
+		r.createOrUpdateNetworkAPIServerIP()
+	}
+
+	conditions := &r.scope.AzureCluster.Status.Conditions
+	if err := r.reconcileGroupAndNetwork(); err != nil {
+		conditions.MarkFalse(infrav1.NetworkReadyCondition, infrav1.NetworkReconcileFailedReason, err.Error())
+		return err
+	}
+	conditions.MarkTrue(infrav1.NetworkReadyCondition)
+
+	if err := r.reconcileLoadBalancers(); err != nil {
+		conditions.MarkFalse(infrav1.LoadBalancersReadyCondition, infrav1.LoadBalancerReconcileFailedReason, err.Error())
+		return err
+	}
+	if err := r.reconcileBackendPools(); err != nil {
+		conditions.MarkFalse(infrav1.LoadBalancersReadyCondition, infrav1.LoadBalancerReconcileFailedReason, err.Error())
+		return err
+	}
+	conditions.MarkTrue(infrav1.LoadBalancersReadyCondition)
+
+	if r.scope.VPNGateway() == nil {
+		return nil
+	}
+	if err := r.reconcileVPNGateway(); err != nil {
+		if azure.IsOperationNotDoneError(err) {
+			conditions.MarkFalse(infrav1.VPNGatewayReadyCondition, infrav1.VPNGatewayProvisioningReason, err.Error())
+		} else {
+			conditions.MarkFalse(infrav1.VPNGatewayReadyCondition, infrav1.VPNGatewayReconcileFailedReason, err.Error())
+		}
+		return err
+	}
+	conditions.MarkTrue(infrav1.VPNGatewayReadyCondition)
+	return nil
+}
+
+// reconcileVPNGateway reconciles the GatewaySubnet of the vnet, then the VPN gateway with its public IP.
+func (r *azureClusterReconciler) reconcileVPNGateway() error {
+	subnetSpec := &subnets.Spec{
+		Name:     azure.GatewaySubnetName,
+		VnetName: r.scope.Vnet().Name,
+	}
+	if cidr := r.scope.VPNGateway().GatewaySubnetCIDR; cidr != "" {
+		subnetSpec.CIDRs = []string{cidr}
+	}
+	if err := r.subnetsSvc.Reconcile(r.scope.Context, subnetSpec); err != nil {
+		return errors.Wrapf(err, "failed to reconcile gateway subnet for cluster %s", r.scope.Name())
+	}
+
+	gatewaySpec := r.vpnGatewaySpec()
+	if err := r.vpnGatewaySvc.Reconcile(r.scope.Context, gatewaySpec); err != nil {
+		return errors.Wrapf(err, "failed to reconcile VPN gateway %s for cluster %s", gatewaySpec.Name, r.scope.Name())
+	}
+	return nil
+}
+
+// vpnGatewaySpec returns the spec of the VPN gateway of the cluster.
+func (r *azureClusterReconciler) vpnGatewaySpec() *virtualnetworkgateways.Spec {
+	return &virtualnetworkgateways.Spec{
+		Name:         r.scope.VPNGatewayName(),
+		VnetName:     r.scope.Vnet().Name,
+		PublicIPName: r.scope.VPNGatewayIPName(),
+		SKU:          r.scope.VPNGatewaySKU(),
+	}
+}
 
-	if err := r.groupsSvc.Reconcile(r.scope.Context, nil); err != nil {
+// reconcileGroupAndNetwork reconciles the resource group of the cluster, then its proximity placement group and its
+// network.
+func (r *azureClusterReconciler) reconcileGroupAndNetwork() error {
+	groupSpec := &groups.Spec{
+		Name:     r.scope.ResourceGroup(),
+		Location: r.scope.Location(),
+	}
+	if err := r.groupsSvc.Reconcile(r.scope.Context, groupSpec); err != nil {
 		return errors.Wrapf(err, "failed to reconcile resource group for cluster %s", r.scope.Name())
 	}
 
@@ -76,166 +182,658 @@ func (r *azureClusterReconciler) Reconcile() error {
 		r.scope.Vnet().ResourceGroup = r.scope.ResourceGroup()
 	}
 	if r.scope.Vnet().Name == "" {
-		r.scope.Vnet().Name = azure.GenerateVnetName(r.scope.Name())
+		r.scope.Vnet().Name = r.scope.VnetName()
 	}
-	if r.scope.Vnet().CidrBlock == "" {
+	if len(r.scope.Vnet().CIDRs()) == 0 {
 		r.scope.Vnet().CidrBlock = azure.DefaultVnetCIDR
 	}
 
-	if len(r.scope.Subnets()) == 0 {
-		r.scope.AzureCluster.Spec.NetworkSpec.Subnets = infrav1.Subnets{&infrav1.SubnetSpec{}, &infrav1.SubnetSpec{}}
+	if err := r.setSubnetDefaults(); err != nil {
+		return errors.Wrapf(err, "invalid subnets for cluster %s", r.scope.Name())
+	}
+
+	// the proximity placement group and the network do not depend on each other, the internal load balancer needs
+	// the subnets.
+	if err := reconcileConcurrently(r.reconcileProximityPlacementGroup, r.reconcileNetwork); err != nil {
+		return err
+	}
+	return r.reconcilePrivateDNSZone()
+}
+
+// reconcilePrivateDNSZone reconciles the private DNS zone of the cluster, when it has one, with its link to the vnet
+// and the A records of the machines.
+func (r *azureClusterReconciler) reconcilePrivateDNSZone() error {
+	if r.scope.PrivateDNSZone() == nil {
+		return nil
+	}
+	zoneSpec := r.privateDNSZoneSpec()
+	if !zoneSpec.RegistrationEnabled {
+		machines, err := r.scope.ListMachines()
+		if err != nil {
+			return err
+		}
+		zoneSpec.Records = privateDNSRecords(machines)
+	}
+	if err := r.privateDNSSvc.Reconcile(r.scope.Context, zoneSpec); err != nil {
+		return errors.Wrapf(err, "failed to reconcile private DNS zone %s for cluster %s", zoneSpec.Name, r.scope.Name())
+	}
+	return nil
+}
+
+// privateDNSZoneSpec returns the spec of the private DNS zone of the cluster, without the records of the machines.
+func (r *azureClusterReconciler) privateDNSZoneSpec() *privatednszones.Spec {
+	return &privatednszones.Spec{
+		Name:                r.scope.PrivateDNSZoneName(),
+		LinkName:            r.scope.VnetLinkName(),
+		VnetID:              azure.GenerateVnetID(r.scope.SubscriptionID, r.scope.Vnet().ResourceGroup, r.scope.Vnet().Name),
+		RegistrationEnabled: r.scope.PrivateDNSZone().RegistrationEnabled,
+	}
+}
+
+// privateDNSRecords returns an A record for the internal IP of each machine with a node, named after the node, which
+// is the host name of the machine.
+func privateDNSRecords(machines []clusterv1.Machine) []privatednszones.Record {
+	var records []privatednszones.Record
+	for i := range machines {
+		if machines[i].Status.NodeRef == nil {
+			continue
+		}
+		for _, address := range machines[i].Status.Addresses {
+			if address.Type == clusterv1.MachineInternalIP {
+				records = append(records, privatednszones.Record{Hostname: machines[i].Status.NodeRef.Name, IP: address.Address})
+				break
+			}
+		}
 	}
+	return records
+}
 
+// reconcileProximityPlacementGroup reconciles the proximity placement group of the cluster, when it has one.
+func (r *azureClusterReconciler) reconcileProximityPlacementGroup() error {
+	if r.scope.ProximityPlacementGroupScope() == infrav1.ProximityPlacementGroupNone {
+		return nil
+	}
+	ppgSpec := &proximityplacementgroups.Spec{
+		Name: r.scope.ProximityPlacementGroupName(),
+	}
+	if err := r.ppgSvc.Reconcile(r.scope.Context, ppgSpec); err != nil {
+		return errors.Wrapf(err, "failed to reconcile proximity placement group for cluster %s", r.scope.Name())
+	}
+	return nil
+}
+
+// reconcileNetwork reconciles the vnet, then its peerings, the security groups, the route table and the NAT gateways
+// concurrently, and finally the subnets associated with them. The security groups, the route table and the NAT
+// gateways wait for the vnet since they are skipped in custom vnet mode, which the vnet reconcile determines.
+func (r *azureClusterReconciler) reconcileNetwork() error {
 	vnetSpec := &virtualnetworks.Spec{
 		ResourceGroup: r.scope.Vnet().ResourceGroup,
 		Name:          r.scope.Vnet().Name,
-		CIDR:          r.scope.Vnet().CidrBlock,
+		CIDRs:         r.scope.Vnet().CIDRs(),
+		DNSServers:    r.scope.Vnet().DNSServers,
+	}
+	if plan := r.scope.Vnet().DdosProtectionPlan; plan != nil {
+		vnetSpec.DdosProtectionPlanID = plan.ID
+		// without an ID, the DDoS protection plan is created with the cluster.
+		if plan.ID == "" {
+			if plan.Name == "" {
+				plan.Name = r.scope.DdosProtectionPlanName()
+			}
+			if err := r.ddosPlanSvc.Reconcile(r.scope.Context, &ddosprotectionplans.Spec{Name: plan.Name}); err != nil {
+				return errors.Wrapf(err, "failed to reconcile DDoS protection plan %s for cluster %s", plan.Name, r.scope.Name())
+			}
+			vnetSpec.DdosProtectionPlanID = azure.GenerateDdosProtectionPlanID(r.scope.SubscriptionID, r.scope.ResourceGroup(), plan.Name)
+		}
 	}
 	if err := r.vnetSvc.Reconcile(r.scope.Context, vnetSpec); err != nil {
 		return errors.Wrapf(err, "failed to reconcile virtual network for cluster %s", r.scope.Name())
 	}
-	sgName := azure.GenerateControlPlaneSecurityGroupName(r.scope.Name())
-	if r.scope.ControlPlaneSubnet() != nil && r.scope.ControlPlaneSubnet().SecurityGroup.Name != "" {
-		sgName = r.scope.ControlPlaneSubnet().SecurityGroup.Name
+
+	if err := reconcileConcurrently(r.reconcileVnetPeerings, r.reconcileSecurityGroups, r.reconcileRouteTable, r.reconcileNatGateways); err != nil {
+		return err
 	}
-	sgSpec := &securitygroups.Spec{
-		Name:           sgName,
-		IsControlPlane: true,
+
+	// subnets are reconciled one at a time, Azure rejects concurrent changes to the subnets of a vnet.
+	for _, subnet := range r.scope.Subnets() {
+		var natGatewayName string
+		if subnet.NatGateway != nil {
+			natGatewayName = subnet.NatGateway.Name
+		}
+		subnetSpec := &subnets.Spec{
+			Name:                subnet.Name,
+			CIDRs:               subnet.CIDRs(),
+			VnetName:            r.scope.Vnet().Name,
+			SecurityGroupName:   subnet.SecurityGroup.Name,
+			RouteTableName:      subnet.RouteTable.Name,
+			Role:                subnet.Role,
+			InternalLBIPAddress: subnet.InternalLBIPAddress,
+			NatGatewayName:      natGatewayName,
+			ServiceEndpoints:    subnet.ServiceEndpoints,
+			Delegations:         subnet.Delegations,
+			PrivateLinkService:  subnet.Role == infrav1.SubnetControlPlane && r.scope.AzureCluster.Spec.NetworkSpec.APIServerPrivateEndpoint != nil,
+		}
+		if err := r.subnetsSvc.Reconcile(r.scope.Context, subnetSpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile %s subnet %s for cluster %s", subnet.Role, subnet.Name, r.scope.Name())
+		}
 	}
-	if err := r.securityGroupSvc.Reconcile(r.scope.Context, sgSpec); err != nil {
-		return errors.Wrapf(err, "failed to reconcile control plane network security group for cluster %s", r.scope.Name())
+
+	return nil
+}
+
+func (r *azureClusterReconciler) reconcileVnetPeerings() error {
+	for _, peeringSpec := range r.vnetPeeringSpecs() {
+		if err := r.vnetPeeringSvc.Reconcile(r.scope.Context, peeringSpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile vnet peering %s for cluster %s", peeringSpec.Name, r.scope.Name())
+		}
 	}
+	return nil
+}
 
-	sgName = azure.GenerateNodeSecurityGroupName(r.scope.Name())
-	if r.scope.NodeSubnet() != nil && r.scope.NodeSubnet().SecurityGroup.Name != "" {
-		sgName = r.scope.NodeSubnet().SecurityGroup.Name
+// reconcileSecurityGroups reconciles the security groups of the subnets and reports their rules in the cluster status.
+func (r *azureClusterReconciler) reconcileSecurityGroups() error {
+	// an internal API server is only reachable from within the vnet.
+	apiServerSource := ""
+	if r.scope.IsAPIServerInternal() {
+		apiServerSource = "VirtualNetwork"
 	}
-	sgSpec = &securitygroups.Spec{
-		Name:           sgName,
-		IsControlPlane: false,
+
+	// subnets can share a security group, the first subnet using it defines its rules and tags. The rules and tags of
+	// all the subnets using the shared security group of the cluster are combined.
+	sharedSGName := r.scope.SharedSecurityGroupName()
+	var sgSpecs []*securitygroups.Spec
+	sgSpecsByName := make(map[string]*securitygroups.Spec)
+	for _, subnet := range r.scope.Subnets() {
+		sgSpec, ok := sgSpecsByName[subnet.SecurityGroup.Name]
+		if !ok {
+			sgSpec = &securitygroups.Spec{
+				Name:            subnet.SecurityGroup.Name,
+				APIServerPort:   to.Int32(r.scope.AzureCluster.Spec.NetworkSpec.APIServerPort),
+				APIServerSource: apiServerSource,
+				Tags:            infrav1.Tags{},
+			}
+			sgSpecsByName[sgSpec.Name] = sgSpec
+			sgSpecs = append(sgSpecs, sgSpec)
+		} else if sgSpec.Name != sharedSGName {
+			continue
+		}
+		if subnet.Role == infrav1.SubnetControlPlane {
+			sgSpec.IsControlPlane = true
+			sgSpec.SecurityRules = appendSecurityRules(sgSpec.SecurityRules, subnet.SecurityGroup.SecurityRules)
+		} else {
+			sgSpec.NodeSecurityRules = appendSecurityRules(sgSpec.NodeSecurityRules, subnet.SecurityGroup.SecurityRules)
+		}
+		sgSpec.Tags.Merge(subnet.SecurityGroup.Tags)
 	}
-	if err := r.securityGroupSvc.Reconcile(r.scope.Context, sgSpec); err != nil {
-		return errors.Wrapf(err, "failed to reconcile node network security group for cluster %s", r.scope.Name())
+	for _, sgSpec := range sgSpecs {
+		// only a control plane security group shared with the nodes combines the rules of both roles.
+		if !sgSpec.IsControlPlane {
+			sgSpec.SecurityRules, sgSpec.NodeSecurityRules = sgSpec.NodeSecurityRules, nil
+		}
+		if err := r.securityGroupSvc.Reconcile(r.scope.Context, sgSpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile network security group %s for cluster %s", sgSpec.Name, r.scope.Name())
+		}
 	}
 
+	if err := r.updateSecurityGroupsStatus(r.scope.ControlPlaneSubnet().SecurityGroup.Name, r.scope.NodeSubnet().SecurityGroup.Name); err != nil {
+		return errors.Wrapf(err, "failed to update network security groups status for cluster %s", r.scope.Name())
+	}
+	return nil
+}
+
+// appendSecurityRules appends the rules which are not already in rules by name, the first subnet defining a rule of a
+// shared security group wins.
+func appendSecurityRules(rules, more infrav1.SecurityRules) infrav1.SecurityRules {
+	for _, rule := range more {
+		found := false
+		for _, existing := range rules {
+			if existing.Name == rule.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (r *azureClusterReconciler) reconcileRouteTable() error {
 	rtSpec := &routetables.Spec{
-		Name: azure.GenerateNodeRouteTableName(r.scope.Name()),
+		Name:   r.scope.NodeRouteTableName(),
+		Routes: r.scope.AzureCluster.Spec.NetworkSpec.Routes,
 	}
 	if err := r.routeTableSvc.Reconcile(r.scope.Context, rtSpec); err != nil {
 		return errors.Wrapf(err, "failed to reconcile node route table for cluster %s", r.scope.Name())
 	}
+	return nil
+}
 
-	cpSubnet := r.scope.ControlPlaneSubnet()
-	if cpSubnet == nil {
-		cpSubnet = &infrav1.SubnetSpec{}
-	}
-	if cpSubnet.Role == "" {
-		cpSubnet.Role = infrav1.SubnetControlPlane
-	}
-	if cpSubnet.Name == "" {
-		cpSubnet.Name = azure.GenerateControlPlaneSubnetName(r.scope.Name())
-	}
-	if cpSubnet.CidrBlock == "" {
-		cpSubnet.CidrBlock = azure.DefaultControlPlaneSubnetCIDR
-	}
-	if cpSubnet.SecurityGroup.Name == "" {
-		cpSubnet.SecurityGroup.Name = azure.GenerateControlPlaneSecurityGroupName(r.scope.Name())
+func (r *azureClusterReconciler) reconcileNatGateways() error {
+	for _, natGatewaySpec := range r.natGatewaySpecs() {
+		if err := r.natGatewaySvc.Reconcile(r.scope.Context, natGatewaySpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile NAT gateway %s for cluster %s", natGatewaySpec.Name, r.scope.Name())
+		}
 	}
+	return nil
+}
 
-	subnetSpec := &subnets.Spec{
-		Name:                cpSubnet.Name,
-		CIDR:                cpSubnet.CidrBlock,
-		VnetName:            r.scope.Vnet().Name,
-		SecurityGroupName:   cpSubnet.SecurityGroup.Name,
-		RouteTableName:      azure.GenerateNodeRouteTableName(r.scope.Name()),
-		Role:                cpSubnet.Role,
-		InternalLBIPAddress: cpSubnet.InternalLBIPAddress,
+// reconcileLoadBalancers reconciles the internal load balancer of the control plane and, unless the API server is
+// internal, the public load balancer with its public IPs.
+func (r *azureClusterReconciler) reconcileLoadBalancers() error {
+	if r.scope.LoadBalancerSKU() != infrav1.SKUStandard && r.scope.OutboundPublicIPCount() > 1 {
+		return errors.Errorf("%s load balancers cannot use %d outbound public ips, only %s load balancers support additional outbound public ips",
+			r.scope.LoadBalancerSKU(), r.scope.OutboundPublicIPCount(), infrav1.SKUStandard)
 	}
-	if err := r.subnetsSvc.Reconcile(r.scope.Context, subnetSpec); err != nil {
-		return errors.Wrapf(err, "failed to reconcile control plane subnet for cluster %s", r.scope.Name())
+	probe, err := r.apiServerProbe()
+	if err != nil {
+		return err
 	}
 
-	nodeSubnet := r.scope.NodeSubnet()
-	if nodeSubnet == nil {
-		nodeSubnet = &infrav1.SubnetSpec{}
-	}
-	if nodeSubnet.Role == "" {
-		nodeSubnet.Role = infrav1.SubnetNode
-	}
-	if nodeSubnet.Name == "" {
-		nodeSubnet.Name = azure.GenerateNodeSubnetName(r.scope.Name())
+	return reconcileConcurrently(
+		func() error { return r.reconcileInternalLB(probe) },
+		func() error { return r.reconcilePublicLB(probe) },
+	)
+}
+
+// reconcileBackendPools puts the primary network interfaces of the machines of the cluster back in the backend pools of
+// their role when they are missing from them, such as after they were recreated, and removes the network interfaces of
+// the machines which no longer exist.
+func (r *azureClusterReconciler) reconcileBackendPools() error {
+	machines, err := r.scope.ListMachines()
+	if err != nil {
+		return err
 	}
-	if nodeSubnet.CidrBlock == "" {
-		nodeSubnet.CidrBlock = azure.DefaultNodeSubnetCIDR
+	for _, poolSpec := range r.backendPoolSpecs(machines) {
+		if err := r.backendPoolsSvc.Reconcile(r.scope.Context, poolSpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile backend pool %s of load balancer %s for cluster %s", poolSpec.BackendPoolName, poolSpec.LoadBalancerName, r.scope.Name())
+		}
 	}
-	if nodeSubnet.SecurityGroup.Name == "" {
-		nodeSubnet.SecurityGroup.Name = azure.GenerateNodeSecurityGroupName(r.scope.Name())
+	return nil
+}
+
+// backendPoolSpecs returns the backend pools of the load balancers of the cluster with the primary network interfaces
+// of the machines which belong to them: the control plane machines are in the backend pools of the internal load
+// balancer and of the public load balancer, the nodes are in the node backend pool of the public load balancer.
+func (r *azureClusterReconciler) backendPoolSpecs(machines []clusterv1.Machine) []*backendpools.Spec {
+	var controlPlaneNICs, nodeNICs []string
+	for i := range machines {
+		infraRef := machines[i].Spec.InfrastructureRef
+		if infraRef.Kind != "AzureMachine" || infraRef.Name == "" {
+			continue
+		}
+		nicName := azure.GenerateNICName(infraRef.Name)
+		if util.IsControlPlaneMachine(&machines[i]) {
+			controlPlaneNICs = append(controlPlaneNICs, nicName)
+		} else {
+			nodeNICs = append(nodeNICs, nicName)
+		}
 	}
 
-	subnetSpec = &subnets.Spec{
-		Name:              nodeSubnet.Name,
-		CIDR:              nodeSubnet.CidrBlock,
-		VnetName:          r.scope.Vnet().Name,
-		SecurityGroupName: nodeSubnet.SecurityGroup.Name,
-		RouteTableName:    azure.GenerateNodeRouteTableName(r.scope.Name()),
-		Role:              nodeSubnet.Role,
+	specs := []*backendpools.Spec{
+		{
+			LoadBalancerName:      r.scope.InternalLBName(),
+			BackendPoolName:       azure.ControlPlaneInternalBackendPoolName,
+			NetworkInterfaceNames: controlPlaneNICs,
+		},
 	}
-	if err := r.subnetsSvc.Reconcile(r.scope.Context, subnetSpec); err != nil {
-		return errors.Wrapf(err, "failed to reconcile node subnet for cluster %s", r.scope.Name())
+	if !r.scope.IsAPIServerInternal() {
+		specs = append(specs,
+			&backendpools.Spec{
+				LoadBalancerName:      r.scope.PublicLBName(),
+				BackendPoolName:       azure.ControlPlaneBackendPoolName,
+				NetworkInterfaceNames: controlPlaneNICs,
+			},
+			&backendpools.Spec{
+				LoadBalancerName:      r.scope.PublicLBName(),
+				BackendPoolName:       azure.NodeBackendPoolName,
+				NetworkInterfaceNames: nodeNICs,
+			})
 	}
+	return specs
+}
 
+// reconcileInternalLB reconciles the internal load balancer of the control plane, then the private endpoint connected
+// to it.
+func (r *azureClusterReconciler) reconcileInternalLB(probe *network.ProbePropertiesFormat) error {
 	internalLBSpec := &internalloadbalancers.Spec{
-		Name:       azure.GenerateInternalLBName(r.scope.Name()),
-		SubnetName: r.scope.ControlPlaneSubnet().Name,
-		SubnetCidr: r.scope.ControlPlaneSubnet().CidrBlock,
-		VnetName:   r.scope.Vnet().Name,
-		IPAddress:  r.scope.ControlPlaneSubnet().InternalLBIPAddress,
+		Name:                 r.scope.InternalLBName(),
+		SubnetName:           r.scope.ControlPlaneSubnet().Name,
+		SubnetCidr:           r.scope.ControlPlaneSubnet().IPv4CIDR(),
+		VnetName:             r.scope.Vnet().Name,
+		IPAddress:            r.scope.ControlPlaneSubnet().InternalLBIPAddress,
+		StaticIPAddress:      r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.FrontendPrivateIP,
+		SKU:                  network.LoadBalancerSkuName(r.scope.LoadBalancerSKU()),
+		Probe:                probe,
+		IdleTimeoutInMinutes: r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.IdleTimeoutInMinutes,
 	}
 	if err := r.internalLBSvc.Reconcile(r.scope.Context, internalLBSpec); err != nil {
 		return errors.Wrapf(err, "failed to reconcile control plane internal load balancer for cluster %s", r.scope.Name())
 	}
 
+	if privateEndpoint := r.scope.AzureCluster.Spec.NetworkSpec.APIServerPrivateEndpoint; privateEndpoint != nil {
+		privateEndpointSpec := &privateendpoints.Spec{
+			Name:                   r.scope.PrivateEndpointName(),
+			SubnetID:               privateEndpoint.SubnetID,
+			PrivateLinkServiceName: r.scope.PrivateLinkServiceName(),
+			LoadBalancerName:       r.scope.InternalLBName(),
+		}
+		if err := r.privateEndpointSvc.Reconcile(r.scope.Context, privateEndpointSpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile API server private endpoint for cluster %s", r.scope.Name())
+		}
+	}
+	return nil
+}
+
+// reconcilePublicLB reconciles the public IPs, then the public load balancer using them, unless the API server is
+// internal. The outbound public IPs the public load balancer stopped using are released afterwards.
+func (r *azureClusterReconciler) reconcilePublicLB(probe *network.ProbePropertiesFormat) error {
+	if r.scope.IsAPIServerInternal() {
+		return nil
+	}
+
 	publicIPSpec := &publicips.Spec{
-		Name: r.scope.Network().APIServerIP.Name,
+		Name:              r.scope.Network().APIServerIP.Name,
+		DNSName:           r.scope.APIServerDNSLabel(),
+		SKU:               network.PublicIPAddressSkuName(r.scope.LoadBalancerSKU()),
+		Zones:             r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.FrontendZones,
+		ExternallyManaged: r.scope.IsAPIServerPublicIPExternallyManaged(),
 	}
 	if err := r.publicIPSvc.Reconcile(r.scope.Context, publicIPSpec); err != nil {
 		return errors.Wrapf(err, "failed to reconcile control plane public ip for cluster %s", r.scope.Name())
 	}
+	if err := r.updateAPIServerIPStatus(publicIPSpec); err != nil {
+		return errors.Wrapf(err, "failed to get control plane public ip for cluster %s", r.scope.Name())
+	}
+
+	outboundIPNames, err := r.reconcileOutboundIPs()
+	if err != nil {
+		return errors.Wrapf(err, "failed to reconcile outbound public ips for cluster %s", r.scope.Name())
+	}
+
+	additionalRules, err := r.additionalRules()
+	if err != nil {
+		return err
+	}
 
 	publicLBSpec := &publicloadbalancers.Spec{
-		Name:         azure.GeneratePublicLBName(r.scope.Name()),
-		PublicIPName: r.scope.Network().APIServerIP.Name,
+		Name:                  r.scope.PublicLBName(),
+		PublicIPName:          r.scope.Network().APIServerIP.Name,
+		OutboundPublicIPNames: outboundIPNames,
+		SKU:                   network.LoadBalancerSkuName(r.scope.LoadBalancerSKU()),
+		Probe:                 probe,
+		IdleTimeoutInMinutes:  r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.IdleTimeoutInMinutes,
+		AdditionalRules:       additionalRules,
 	}
 	if err := r.publicLBSvc.Reconcile(r.scope.Context, publicLBSpec); err != nil {
 		return errors.Wrapf(err, "failed to reconcile control plane public load balancer for cluster %s", r.scope.Name())
 	}
 
+	if err := r.releaseOutboundIPs(outboundIPNames); err != nil {
+		return errors.Wrapf(err, "failed to release outbound public ips for cluster %s", r.scope.Name())
+	}
+
+	return nil
+}
+
+// apiServerProbe returns the health probe of the API server load balancers, or nil to use the default TCP probe of
+// the API server port.
+func (r *azureClusterReconciler) apiServerProbe() (*network.ProbePropertiesFormat, error) {
+	healthProbe := r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.HealthProbe
+	if healthProbe == nil {
+		return nil, nil
+	}
+	return r.probe(*healthProbe, r.scope.APIServerPort(), fmt.Sprintf("cluster %s", r.scope.Name()))
+}
+
+// additionalRules returns the load balancing rules of the public load balancer in addition to the API server one,
+// each with its health probe.
+func (r *azureClusterReconciler) additionalRules() ([]publicloadbalancers.Rule, error) {
+	var rules []publicloadbalancers.Rule
+	for _, rule := range r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.AdditionalRules {
+		backendPort := rule.FrontendPort
+		if rule.BackendPort != nil {
+			backendPort = *rule.BackendPort
+		}
+		probe, err := r.probe(rule.Probe, backendPort, fmt.Sprintf("load balancing rule %s of cluster %s", rule.Name, r.scope.Name()))
+		if err != nil {
+			return nil, err
+		}
+		lbRule := publicloadbalancers.Rule{
+			Name:         rule.Name,
+			Protocol:     network.TransportProtocol(rule.Protocol),
+			FrontendPort: rule.FrontendPort,
+			BackendPort:  backendPort,
+			Probe:        probe,
+		}
+		if rule.BackendPool == infrav1.BackendPoolNode {
+			lbRule.BackendPoolName = azure.NodeBackendPoolName
+		}
+		rules = append(rules, lbRule)
+	}
+	return rules, nil
+}
+
+// probe returns the load balancer health probe of a HealthProbe, which probes the default port unless it sets one.
+// The description of the owner of the probe is used in errors.
+func (r *azureClusterReconciler) probe(healthProbe infrav1.HealthProbe, defaultPort int32, owner string) (*network.ProbePropertiesFormat, error) {
+	protocol := healthProbe.Protocol
+	if protocol == "" {
+		protocol = infrav1.ProbeProtocolTCP
+	}
+	switch protocol {
+	case infrav1.ProbeProtocolTCP:
+		if healthProbe.RequestPath != "" {
+			return nil, errors.Errorf("%s health probe of %s cannot have a request path", protocol, owner)
+		}
+	case infrav1.ProbeProtocolHTTP, infrav1.ProbeProtocolHTTPS:
+		if healthProbe.RequestPath == "" {
+			return nil, errors.Errorf("%s health probe of %s requires a request path", protocol, owner)
+		}
+	default:
+		return nil, errors.Errorf("invalid health probe protocol %s for %s, must be %s, %s or %s",
+			protocol, owner, infrav1.ProbeProtocolTCP, infrav1.ProbeProtocolHTTP, infrav1.ProbeProtocolHTTPS)
+	}
+	if protocol == infrav1.ProbeProtocolHTTPS && r.scope.LoadBalancerSKU() != infrav1.SKUStandard {
+		return nil, errors.Errorf("%s load balancers cannot use %s health probes, only %s load balancers support them",
+			r.scope.LoadBalancerSKU(), protocol, infrav1.SKUStandard)
+	}
+
+	probe := &network.ProbePropertiesFormat{
+		Protocol:          network.ProbeProtocol(protocol),
+		Port:              to.Int32Ptr(defaultPort),
+		IntervalInSeconds: to.Int32Ptr(15),
+		NumberOfProbes:    to.Int32Ptr(4),
+	}
+	if healthProbe.Port != nil {
+		probe.Port = healthProbe.Port
+	}
+	if healthProbe.RequestPath != "" {
+		probe.RequestPath = to.StringPtr(healthProbe.RequestPath)
+	}
+	if healthProbe.IntervalSeconds != nil {
+		probe.IntervalInSeconds = healthProbe.IntervalSeconds
+	}
+	if healthProbe.NumberOfProbes != nil {
+		probe.NumberOfProbes = healthProbe.NumberOfProbes
+	}
+	return probe, nil
+}
+
+// updateAPIServerIPStatus records the address allocated to the public IP of the API server in the cluster status. The
+// DNS name is the FQDN of the public IP, which is empty when the public IP has no DNS label.
+func (r *azureClusterReconciler) updateAPIServerIPStatus(publicIPSpec *publicips.Spec) error {
+	result, err := r.publicIPSvc.Get(r.scope.Context, publicIPSpec)
+	if err != nil {
+		return err
+	}
+	publicIP, ok := result.(network.PublicIPAddress)
+	if !ok {
+		return errors.Errorf("%T is not a network.PublicIPAddress", result)
+	}
+	apiServerIP := r.scope.Network().APIServerIP
+	apiServerIP.ID = to.String(publicIP.ID)
+	apiServerIP.IPAddress = ""
+	apiServerIP.DNSName = ""
+	if publicIP.PublicIPAddressPropertiesFormat != nil {
+		apiServerIP.IPAddress = to.String(publicIP.IPAddress)
+		if publicIP.DNSSettings != nil {
+			apiServerIP.DNSName = to.String(publicIP.DNSSettings.Fqdn)
+		}
+	}
+	r.scope.Network().APIServerIP = apiServerIP
+	return nil
+}
+
+// reconcileOutboundIPs creates the additional outbound public IPs of the public load balancer and records them in the
+// cluster status, so they can be released once they are no longer needed. It returns the names of the IPs the public
+// load balancer has to use.
+func (r *azureClusterReconciler) reconcileOutboundIPs() ([]string, error) {
+	names := []string{}
+	for i := 1; i < int(r.scope.OutboundPublicIPCount()); i++ {
+		name := azure.GenerateOutboundPublicIPName(r.scope.Network().APIServerIP.Name, i)
+		publicIPSpec := &publicips.Spec{
+			Name:    name,
+			DNSName: strings.ToLower(name),
+			SKU:     network.PublicIPAddressSkuName(r.scope.LoadBalancerSKU()),
+			Zones:   r.scope.AzureCluster.Spec.NetworkSpec.APIServerLB.FrontendZones,
+		}
+		if err := r.publicIPSvc.Reconcile(r.scope.Context, publicIPSpec); err != nil {
+			return nil, err
+		}
+		if !hasPublicIP(r.scope.Network().OutboundIPs, name) {
+			r.scope.Network().OutboundIPs = append(r.scope.Network().OutboundIPs, infrav1.PublicIP{Name: name})
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// releaseOutboundIPs deletes the outbound public IPs in the cluster status that are not in use anymore.
+// It has to run after the public load balancer stopped using them.
+func (r *azureClusterReconciler) releaseOutboundIPs(names []string) error {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	inUse := []infrav1.PublicIP{}
+	for _, ip := range r.scope.Network().OutboundIPs {
+		if wanted[ip.Name] {
+			inUse = append(inUse, ip)
+			continue
+		}
+		if err := r.publicIPSvc.Delete(r.scope.Context, &publicips.Spec{Name: ip.Name}); err != nil {
+			return errors.Wrapf(err, "failed to delete outbound public ip %s", ip.Name)
+		}
+	}
+	r.scope.Network().OutboundIPs = inUse
 	return nil
 }
 
-// Delete reconciles all the services in pre determined order
+// reconcileConcurrently runs the given functions concurrently and waits for all of them to return. Its error
+// aggregates the errors of all the functions, so that a failure does not hide the others.
+func reconcileConcurrently(fns ...func() error) error {
+	var g errgroup.Group
+	errs := make([]error, len(fns))
+	for i, fn := range fns {
+		i, fn := i, fn
+		g.Go(func() error {
+			errs[i] = fn()
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return kerrors.NewAggregate(errs)
+}
+
+func hasPublicIP(ips []infrav1.PublicIP, name string) bool {
+	for _, ip := range ips {
+		if ip.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete reconciles all the services in pre determined order. The load balancers and the placement groups of the
+// virtual machines are deleted before the network, the resource group is deleted last.
 func (r *azureClusterReconciler) Delete() error {
 	if r.scope.Vnet().ResourceGroup == "" {
 		r.scope.Vnet().ResourceGroup = r.scope.ResourceGroup()
 	}
 	if r.scope.Vnet().Name == "" {
-		r.scope.Vnet().Name = azure.GenerateVnetName(r.scope.Name())
+		r.scope.Vnet().Name = r.scope.VnetName()
 	}
 
 	if err := r.deleteLB(); err != nil {
 		return errors.Wrap(err, "failed to delete load balancer")
 	}
 
+	if err := r.deletePlacementGroups(); err != nil {
+		return err
+	}
+
+	// the VPN gateway is in the GatewaySubnet, the vnet can only be deleted once the gateway is gone.
+	if r.scope.VPNGateway() != nil {
+		gatewaySpec := r.vpnGatewaySpec()
+		if err := r.vpnGatewaySvc.Delete(r.scope.Context, gatewaySpec); err != nil {
+			return errors.Wrapf(err, "failed to delete VPN gateway %s for cluster %s", gatewaySpec.Name, r.scope.Name())
+		}
+	}
+
+	// the vnet can only be deleted once the private DNS zone is no longer linked to it.
+	if r.scope.PrivateDNSZone() != nil {
+		zoneSpec := r.privateDNSZoneSpec()
+		if err := r.privateDNSSvc.Delete(r.scope.Context, zoneSpec); err != nil {
+			return errors.Wrapf(err, "failed to delete private DNS zone %s for cluster %s", zoneSpec.Name, r.scope.Name())
+		}
+	}
+
+	if err := r.deleteNetwork(); err != nil {
+		return err
+	}
+
+	// a referenced DDoS protection plan is not managed by the cluster, only the plan created with it is deleted.
+	if plan := r.scope.Vnet().DdosProtectionPlan; plan != nil && plan.ID == "" {
+		if plan.Name == "" {
+			plan.Name = r.scope.DdosProtectionPlanName()
+		}
+		if err := r.ddosPlanSvc.Delete(r.scope.Context, &ddosprotectionplans.Spec{Name: plan.Name}); err != nil {
+			return errors.Wrapf(err, "failed to delete DDoS protection plan %s for cluster %s", plan.Name, r.scope.Name())
+		}
+	}
+
+	groupSpec := &groups.Spec{
+		Name:     r.scope.ResourceGroup(),
+		Location: r.scope.Location(),
+	}
+	if err := r.groupsSvc.Delete(r.scope.Context, groupSpec); err != nil {
+		if !azure.ResourceNotFound(err) {
+			return errors.Wrapf(err, "failed to delete resource group for cluster %s", r.scope.Name())
+		}
+	}
+
+	return nil
+}
+
+// deleteNetwork deletes the subnets, which are dissociated from their security group and route table first, then the
+// NAT gateways, route table and security groups, and finally the vnet peerings and the vnet. A subnet or a vnet which
+// network interfaces still use fails the deletion with a ResourceInUseError, so that it is retried once they are gone.
+func (r *azureClusterReconciler) deleteNetwork() error {
 	if err := r.deleteSubnets(); err != nil {
 		return errors.Wrap(err, "failed to delete subnets")
 	}
 
+	if err := r.deleteNatGateways(); err != nil {
+		return errors.Wrap(err, "failed to delete NAT gateways")
+	}
+
 	rtSpec := &routetables.Spec{
-		Name: azure.GenerateNodeRouteTableName(r.scope.Name()),
+		Name: r.scope.NodeRouteTableName(),
 	}
 	if err := r.routeTableSvc.Delete(r.scope.Context, rtSpec); err != nil {
 		if !azure.ResourceNotFound(err) {
-			return errors.Wrapf(err, "failed to delete route table %s for cluster %s", azure.GenerateNodeRouteTableName(r.scope.Name()), r.scope.Name())
+			return errors.Wrapf(err, "failed to delete route table %s for cluster %s", r.scope.NodeRouteTableName(), r.scope.Name())
 		}
 	}
 
@@ -243,6 +841,10 @@ func (r *azureClusterReconciler) Delete() error {
 		return errors.Wrap(err, "failed to delete network security group")
 	}
 
+	if err := r.deleteVnetPeerings(); err != nil {
+		return errors.Wrap(err, "failed to delete vnet peerings")
+	}
+
 	vnetSpec := &virtualnetworks.Spec{
 		ResourceGroup: r.scope.Vnet().ResourceGroup,
 		Name:          r.scope.Vnet().Name,
@@ -253,42 +855,217 @@ func (r *azureClusterReconciler) Delete() error {
 		}
 	}
 
-	if err := r.groupsSvc.Delete(r.scope.Context, nil); err != nil {
+	return nil
+}
+
+// deletePlacementGroups deletes the availability set of the control plane, and then the proximity placement group of
+// the cluster which the availability set may be placed in. Both are deleted once the virtual machines are gone.
+func (r *azureClusterReconciler) deletePlacementGroups() error {
+	asSpec := &availabilitysets.Spec{
+		Name: r.scope.ControlPlaneAvailabilitySetName(),
+	}
+	if err := r.availabilitySetSvc.Delete(r.scope.Context, asSpec); err != nil {
+		return errors.Wrapf(err, "failed to delete availability set %s for cluster %s", asSpec.Name, r.scope.Name())
+	}
+
+	// the proximity placement group is deleted even without a scope, in case the scope was set to None after its creation.
+	ppgSpec := &proximityplacementgroups.Spec{
+		Name: r.scope.ProximityPlacementGroupName(),
+	}
+	if err := r.ppgSvc.Delete(r.scope.Context, ppgSpec); err != nil {
+		return errors.Wrapf(err, "failed to delete proximity placement group %s for cluster %s", ppgSpec.Name, r.scope.Name())
+	}
+	return nil
+}
+
+func (r *azureClusterReconciler) deleteLB() error {
+	if !r.scope.IsAPIServerInternal() {
+		if err := r.deletePublicLB(); err != nil {
+			return err
+		}
+	}
+
+	// the private endpoint is deleted even when it is not configured, in case it was removed after its creation.
+	privateEndpointSpec := &privateendpoints.Spec{
+		Name:                   r.scope.PrivateEndpointName(),
+		PrivateLinkServiceName: r.scope.PrivateLinkServiceName(),
+	}
+	if err := r.privateEndpointSvc.Delete(r.scope.Context, privateEndpointSpec); err != nil {
+		return errors.Wrapf(err, "failed to delete API server private endpoint for cluster %s", r.scope.Name())
+	}
+
+	internalLBSpec := &internalloadbalancers.Spec{
+		Name: r.scope.InternalLBName(),
+	}
+	if err := r.internalLBSvc.Delete(r.scope.Context, internalLBSpec); err != nil {
 		if !azure.ResourceNotFound(err) {
-			return errors.Wrapf(err, "failed to delete resource group for cluster %s", r.scope.Name())
+			return errors.Wrapf(err, "failed to internal load balancer %s for cluster %s", r.scope.InternalLBName(), r.scope.Name())
 		}
 	}
 
 	return nil
 }
 
-func (r *azureClusterReconciler) deleteLB() error {
+func (r *azureClusterReconciler) deletePublicLB() error {
 	publicLBSpec := &publicloadbalancers.Spec{
-		Name: azure.GeneratePublicLBName(r.scope.Name()),
+		Name: r.scope.PublicLBName(),
 	}
 	if err := r.publicLBSvc.Delete(r.scope.Context, publicLBSpec); err != nil {
 		if !azure.ResourceNotFound(err) {
-			return errors.Wrapf(err, "failed to delete lb %s for cluster %s", azure.GeneratePublicLBName(r.scope.Name()), r.scope.Name())
+			return errors.Wrapf(err, "failed to delete lb %s for cluster %s", r.scope.PublicLBName(), r.scope.Name())
 		}
 	}
 	publicIPSpec := &publicips.Spec{
-		Name: r.scope.Network().APIServerIP.Name,
+		Name:              r.scope.Network().APIServerIP.Name,
+		ExternallyManaged: r.scope.IsAPIServerPublicIPExternallyManaged(),
 	}
 	if err := r.publicIPSvc.Delete(r.scope.Context, publicIPSpec); err != nil {
 		if !azure.ResourceNotFound(err) {
 			return errors.Wrapf(err, "failed to delete public ip %s for cluster %s", r.scope.Network().APIServerIP.Name, r.scope.Name())
 		}
 	}
+	if err := r.releaseOutboundIPs(nil); err != nil {
+		return errors.Wrapf(err, "failed to delete outbound public ips for cluster %s", r.scope.Name())
+	}
 
-	internalLBSpec := &internalloadbalancers.Spec{
-		Name: azure.GenerateInternalLBName(r.scope.Name()),
+	return nil
+}
+
+// setSubnetDefaults defaults the role, name, CIDR block, security group and route table of the cluster subnets. Subnets
+// without a security group use the shared security group of the cluster if any, or the security group of their role
+// otherwise. Subnets without a route table use the node route table of the cluster.
+// A cluster without subnets gets a control plane subnet and a node subnet. Subnets without a role are node subnets,
+// except for the first one when no subnet has the control plane role. Only the first subnet of each role can default
+// its name and CIDR block, any additional subnet has to set them.
+func (r *azureClusterReconciler) setSubnetDefaults() error {
+	if len(r.scope.Subnets()) == 0 {
+		r.scope.AzureCluster.Spec.NetworkSpec.Subnets = infrav1.Subnets{&infrav1.SubnetSpec{}, &infrav1.SubnetSpec{}}
 	}
-	if err := r.internalLBSvc.Delete(r.scope.Context, internalLBSpec); err != nil {
-		if !azure.ResourceNotFound(err) {
-			return errors.Wrapf(err, "failed to internal load balancer %s for cluster %s", azure.GenerateInternalLBName(r.scope.Name()), r.scope.Name())
+
+	cpSubnet := r.scope.ControlPlaneSubnet()
+	if cpSubnet.Role == "" {
+		cpSubnet.Role = infrav1.SubnetControlPlane
+	}
+	if cpSubnet.Role != infrav1.SubnetControlPlane {
+		cpSubnet = &infrav1.SubnetSpec{Role: infrav1.SubnetControlPlane}
+		r.scope.AzureCluster.Spec.NetworkSpec.Subnets = append(infrav1.Subnets{cpSubnet}, r.scope.Subnets()...)
+	}
+	for _, subnet := range r.scope.Subnets() {
+		if subnet.Role == "" {
+			subnet.Role = infrav1.SubnetNode
 		}
 	}
+	nodeSubnet := r.scope.NodeSubnet()
+	if nodeSubnet == nil || nodeSubnet.Role != infrav1.SubnetNode {
+		nodeSubnet = &infrav1.SubnetSpec{Role: infrav1.SubnetNode}
+		r.scope.AzureCluster.Spec.NetworkSpec.Subnets = append(r.scope.AzureCluster.Spec.NetworkSpec.Subnets, nodeSubnet)
+	}
 
+	if cpSubnet.Name == "" {
+		cpSubnet.Name = r.scope.ControlPlaneSubnetName()
+	}
+	if len(cpSubnet.CIDRs()) == 0 {
+		cpSubnet.CidrBlock = azure.DefaultControlPlaneSubnetCIDR
+	}
+	if nodeSubnet.Name == "" {
+		nodeSubnet.Name = r.scope.NodeSubnetName()
+	}
+	if len(nodeSubnet.CIDRs()) == 0 {
+		nodeSubnet.CidrBlock = azure.DefaultNodeSubnetCIDR
+	}
+
+	names := make(map[string]bool)
+	for i, subnet := range r.scope.Subnets() {
+		if subnet.Name == "" {
+			return errors.Errorf("%s subnet %d has no name", subnet.Role, i)
+		}
+		if names[subnet.Name] {
+			return errors.Errorf("subnet %s is defined more than once", subnet.Name)
+		}
+		names[subnet.Name] = true
+		if len(subnet.CIDRs()) == 0 {
+			return errors.Errorf("%s subnet %s has no CIDR block", subnet.Role, subnet.Name)
+		}
+		if subnet.NatGateway != nil {
+			if subnet.Role != infrav1.SubnetNode {
+				return errors.Errorf("%s subnet %s cannot use a NAT gateway, NAT gateways are only supported on node subnets", subnet.Role, subnet.Name)
+			}
+			if subnet.NatGateway.Name == "" {
+				subnet.NatGateway.Name = r.scope.NodeNatGatewayName()
+			}
+		}
+		if subnet.SecurityGroup.Name == "" {
+			if sharedSGName := r.scope.SharedSecurityGroupName(); sharedSGName != "" {
+				subnet.SecurityGroup.Name = sharedSGName
+			} else if subnet.Role == infrav1.SubnetControlPlane {
+				subnet.SecurityGroup.Name = r.scope.ControlPlaneSecurityGroupName()
+			} else {
+				subnet.SecurityGroup.Name = r.scope.NodeSecurityGroupName()
+			}
+		}
+		if subnet.RouteTable.Name == "" {
+			subnet.RouteTable.Name = r.scope.NodeRouteTableName()
+		}
+	}
+	return nil
+}
+
+// natGatewaySpecs returns the specs of the NAT gateways used by the cluster subnets, subnets can share a NAT gateway.
+func (r *azureClusterReconciler) natGatewaySpecs() []*natgateways.Spec {
+	var specs []*natgateways.Spec
+	seen := make(map[string]bool)
+	for _, subnet := range r.scope.Subnets() {
+		if subnet.NatGateway == nil || subnet.NatGateway.Name == "" || seen[subnet.NatGateway.Name] {
+			continue
+		}
+		seen[subnet.NatGateway.Name] = true
+		specs = append(specs, &natgateways.Spec{
+			Name:         subnet.NatGateway.Name,
+			PublicIPName: azure.GenerateNatGatewayIPName(subnet.NatGateway.Name),
+		})
+	}
+	return specs
+}
+
+func (r *azureClusterReconciler) deleteNatGateways() error {
+	for _, natGatewaySpec := range r.natGatewaySpecs() {
+		if err := r.natGatewaySvc.Delete(r.scope.Context, natGatewaySpec); err != nil {
+			if !azure.ResourceNotFound(err) {
+				return errors.Wrapf(err, "failed to delete NAT gateway %s for cluster %s", natGatewaySpec.Name, r.scope.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// vnetPeeringSpecs returns the specs of the peerings of the cluster vnet. A peering without a name is named after
+// the cluster and the remote vnet, the last segment of the remote vnet ID.
+func (r *azureClusterReconciler) vnetPeeringSpecs() []*vnetpeerings.Spec {
+	peerings := r.scope.AzureCluster.Spec.NetworkSpec.VnetPeerings
+	specs := make([]*vnetpeerings.Spec, 0, len(peerings))
+	for i := range peerings {
+		peering := &peerings[i]
+		if peering.Name == "" {
+			remoteVnetName := peering.RemoteVnetID[strings.LastIndex(peering.RemoteVnetID, "/")+1:]
+			peering.Name = azure.GenerateVnetPeeringName(r.scope.Name(), remoteVnetName)
+		}
+		specs = append(specs, &vnetpeerings.Spec{
+			Name:                  peering.Name,
+			VnetName:              r.scope.Vnet().Name,
+			RemoteVnetID:          peering.RemoteVnetID,
+			AllowForwardedTraffic: peering.AllowForwardedTraffic,
+			AllowGatewayTransit:   peering.AllowGatewayTransit,
+		})
+	}
+	return specs
+}
+
+func (r *azureClusterReconciler) deleteVnetPeerings() error {
+	for _, peeringSpec := range r.vnetPeeringSpecs() {
+		if err := r.vnetPeeringSvc.Delete(r.scope.Context, peeringSpec); err != nil {
+			return errors.Wrapf(err, "failed to delete vnet peering %s for cluster %s", peeringSpec.Name, r.scope.Name())
+		}
+	}
 	return nil
 }
 
@@ -308,33 +1085,61 @@ func (r *azureClusterReconciler) deleteSubnets() error {
 }
 
 func (r *azureClusterReconciler) deleteNSG() error {
-	sgSpec := &securitygroups.Spec{
-		Name: azure.GenerateNodeSecurityGroupName(r.scope.Name()),
+	sgNames := []string{
+		r.scope.NodeSecurityGroupName(),
+		r.scope.ControlPlaneSecurityGroupName(),
 	}
-	if err := r.securityGroupSvc.Delete(r.scope.Context, sgSpec); err != nil {
-		if !azure.ResourceNotFound(err) {
-			return errors.Wrapf(err, "failed to delete security group %s for cluster %s", azure.GenerateNodeSecurityGroupName(r.scope.Name()), r.scope.Name())
+	// in custom vnet mode the security groups of the subnets are not managed by the cluster.
+	if r.scope.Vnet().IsManaged(r.scope.Name()) {
+		for _, subnet := range r.scope.Subnets() {
+			sgNames = append(sgNames, subnet.SecurityGroup.Name)
 		}
 	}
-	sgSpec = &securitygroups.Spec{
-		Name: azure.GenerateControlPlaneSecurityGroupName(r.scope.Name()),
-	}
-	if err := r.securityGroupSvc.Delete(r.scope.Context, sgSpec); err != nil {
-		if !azure.ResourceNotFound(err) {
-			return errors.Wrapf(err, "failed to delete security group %s for cluster %s", azure.GenerateControlPlaneSecurityGroupName(r.scope.Name()), r.scope.Name())
+
+	deleted := make(map[string]bool)
+	for _, sgName := range sgNames {
+		if sgName == "" || deleted[sgName] {
+			continue
+		}
+		sgSpec := &securitygroups.Spec{
+			Name: sgName,
 		}
+		if err := r.securityGroupSvc.Delete(r.scope.Context, sgSpec); err != nil {
+			if !azure.ResourceNotFound(err) {
+				return errors.Wrapf(err, "failed to delete security group %s for cluster %s", sgName, r.scope.Name())
+			}
+		}
+		deleted[sgName] = true
 	}
 
 	return nil
 }
 
+// updateSecurityGroupsStatus reports the security rules currently applied to the cluster security groups in the AzureCluster status.
+func (r *azureClusterReconciler) updateSecurityGroupsStatus(controlPlaneSGName, nodeSGName string) error {
+	securityGroups := map[infrav1.SecurityGroupRole]infrav1.SecurityGroup{}
+	for role, name := range map[infrav1.SecurityGroupRole]string{
+		infrav1.SecurityGroupControlPlane: controlPlaneSGName,
+		infrav1.SecurityGroupNode:         nodeSGName,
+	} {
+		rules, err := r.securityGroupSvc.ListSecurityRules(r.scope.Context, name)
+		if err != nil {
+			return err
+		}
+		securityGroups[role] = infrav1.SecurityGroup{
+			Name:          name,
+			SecurityRules: rules,
+		}
+	}
+	r.scope.AzureCluster.Status.Network.SecurityGroups = securityGroups
+	return nil
+}
+
 // CreateOrUpdateNetworkAPIServerIP creates or updates public ip name and dns name
 func (r *azureClusterReconciler) createOrUpdateNetworkAPIServerIP() {
 	if r.scope.Network().APIServerIP.Name == "" {
-		h := fnv.New32a()
-		h.Write([]byte(fmt.Sprintf("%s/%s/%s", r.scope.SubscriptionID, r.scope.ResourceGroup(), r.scope.Name())))
-		r.scope.Network().APIServerIP.Name = azure.GeneratePublicIPName(r.scope.Name(), fmt.Sprintf("%x", h.Sum32()))
+		r.scope.Network().APIServerIP.Name = r.scope.APIServerPublicIPName()
 	}
 
-	r.scope.Network().APIServerIP.DNSName = azure.GenerateFQDN(r.scope.Network().APIServerIP.Name, r.scope.Location())
+	r.scope.Network().APIServerIP.DNSName = azure.GenerateFQDN(r.scope.APIServerDNSLabel(), r.scope.Location())
 }
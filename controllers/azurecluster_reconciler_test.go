@@ -0,0 +1,1179 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/klogr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/mocks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilitysets"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/backendpools"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/internalloadbalancers"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/privatednszones"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/privateendpoints"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/proximityplacementgroups"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicloadbalancers"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/routetables"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/securitygroups"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/securitygroups/mock_securitygroups"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/subnets"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualnetworks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/vnetpeerings"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+)
+
+func TestSetSubnetDefaults(t *testing.T) {
+	defaultControlPlaneSubnet := &infrav1.SubnetSpec{
+		Role:          infrav1.SubnetControlPlane,
+		Name:          "my-cluster-controlplane-subnet",
+		CidrBlock:     "10.0.0.0/16",
+		SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-controlplane-nsg"},
+		RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+	}
+	defaultNodeSubnet := &infrav1.SubnetSpec{
+		Role:          infrav1.SubnetNode,
+		Name:          "my-cluster-node-subnet",
+		CidrBlock:     "10.1.0.0/16",
+		SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-node-nsg"},
+		RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+	}
+
+	testcases := []struct {
+		name                string
+		subnets             infrav1.Subnets
+		sharedSecurityGroup *infrav1.SharedSecurityGroupSpec
+		expected            infrav1.Subnets
+		expectedError       string
+	}{
+		{
+			name:     "no subnets",
+			subnets:  nil,
+			expected: infrav1.Subnets{defaultControlPlaneSubnet, defaultNodeSubnet},
+		},
+		{
+			name:     "two subnets without roles",
+			subnets:  infrav1.Subnets{{}, {}},
+			expected: infrav1.Subnets{defaultControlPlaneSubnet, defaultNodeSubnet},
+		},
+		{
+			name:     "only a control plane subnet",
+			subnets:  infrav1.Subnets{{Role: infrav1.SubnetControlPlane}},
+			expected: infrav1.Subnets{defaultControlPlaneSubnet, defaultNodeSubnet},
+		},
+		{
+			name:     "only a node subnet",
+			subnets:  infrav1.Subnets{{Role: infrav1.SubnetNode}},
+			expected: infrav1.Subnets{defaultControlPlaneSubnet, defaultNodeSubnet},
+		},
+		{
+			name: "multiple node subnets",
+			subnets: infrav1.Subnets{
+				{},
+				{},
+				{Name: "my-subnet", CidrBlock: "10.2.0.0/16", SecurityGroup: infrav1.SecurityGroup{Name: "my-nsg"}},
+			},
+			expected: infrav1.Subnets{
+				defaultControlPlaneSubnet,
+				defaultNodeSubnet,
+				{Role: infrav1.SubnetNode, Name: "my-subnet", CidrBlock: "10.2.0.0/16", SecurityGroup: infrav1.SecurityGroup{Name: "my-nsg"}, RouteTable: infrav1.RouteTable{Name: "my-cluster-node-routetable"}},
+			},
+		},
+		{
+			name: "dual-stack subnets keep their CIDR blocks",
+			subnets: infrav1.Subnets{
+				{CIDRBlocks: []string{"10.0.0.0/16", "fd00::/64"}},
+				{CIDRBlocks: []string{"10.1.0.0/16", "fd00:0:0:1::/64"}},
+			},
+			expected: infrav1.Subnets{
+				{
+					Role:          infrav1.SubnetControlPlane,
+					Name:          "my-cluster-controlplane-subnet",
+					CIDRBlocks:    []string{"10.0.0.0/16", "fd00::/64"},
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-controlplane-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+				},
+				{
+					Role:          infrav1.SubnetNode,
+					Name:          "my-cluster-node-subnet",
+					CIDRBlocks:    []string{"10.1.0.0/16", "fd00:0:0:1::/64"},
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-node-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+				},
+			},
+		},
+		{
+			name:    "node subnet with a NAT gateway",
+			subnets: infrav1.Subnets{{}, {NatGateway: &infrav1.NatGateway{}}},
+			expected: infrav1.Subnets{
+				defaultControlPlaneSubnet,
+				{
+					Role:          infrav1.SubnetNode,
+					Name:          "my-cluster-node-subnet",
+					CidrBlock:     "10.1.0.0/16",
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-node-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+					NatGateway:    &infrav1.NatGateway{Name: "my-cluster-node-natgw"},
+				},
+			},
+		},
+		{
+			name:                "subnets share the security group of the cluster",
+			subnets:             infrav1.Subnets{{}, {}, {Name: "my-subnet", CidrBlock: "10.2.0.0/16", SecurityGroup: infrav1.SecurityGroup{Name: "my-nsg"}}},
+			sharedSecurityGroup: &infrav1.SharedSecurityGroupSpec{},
+			expected: infrav1.Subnets{
+				{
+					Role:          infrav1.SubnetControlPlane,
+					Name:          "my-cluster-controlplane-subnet",
+					CidrBlock:     "10.0.0.0/16",
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+				},
+				{
+					Role:          infrav1.SubnetNode,
+					Name:          "my-cluster-node-subnet",
+					CidrBlock:     "10.1.0.0/16",
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+				},
+				{Role: infrav1.SubnetNode, Name: "my-subnet", CidrBlock: "10.2.0.0/16", SecurityGroup: infrav1.SecurityGroup{Name: "my-nsg"}, RouteTable: infrav1.RouteTable{Name: "my-cluster-node-routetable"}},
+			},
+		},
+		{
+			name: "subnets reference their own security group and route table",
+			subnets: infrav1.Subnets{
+				{SecurityGroup: infrav1.SecurityGroup{Name: "my-cp-nsg"}, RouteTable: infrav1.RouteTable{Name: "my-cp-rt"}},
+				{SecurityGroup: infrav1.SecurityGroup{Name: "my-node-nsg"}},
+			},
+			expected: infrav1.Subnets{
+				{
+					Role:          infrav1.SubnetControlPlane,
+					Name:          "my-cluster-controlplane-subnet",
+					CidrBlock:     "10.0.0.0/16",
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-cp-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cp-rt"},
+				},
+				{
+					Role:          infrav1.SubnetNode,
+					Name:          "my-cluster-node-subnet",
+					CidrBlock:     "10.1.0.0/16",
+					SecurityGroup: infrav1.SecurityGroup{Name: "my-node-nsg"},
+					RouteTable:    infrav1.RouteTable{Name: "my-cluster-node-routetable"},
+				},
+			},
+		},
+		{
+			name:          "control plane subnet with a NAT gateway",
+			subnets:       infrav1.Subnets{{NatGateway: &infrav1.NatGateway{}}, {}},
+			expectedError: "control-plane subnet my-cluster-controlplane-subnet cannot use a NAT gateway, NAT gateways are only supported on node subnets",
+		},
+		{
+			name:          "additional node subnet without a name",
+			subnets:       infrav1.Subnets{{}, {}, {CidrBlock: "10.2.0.0/16"}},
+			expectedError: "node subnet 2 has no name",
+		},
+		{
+			name:          "additional node subnet without a CIDR block",
+			subnets:       infrav1.Subnets{{}, {}, {Name: "my-subnet"}},
+			expectedError: "node subnet my-subnet has no CIDR block",
+		},
+		{
+			name:          "duplicate subnet names",
+			subnets:       infrav1.Subnets{{}, {}, {Name: "my-cluster-node-subnet", CidrBlock: "10.2.0.0/16"}},
+			expectedError: "subnet my-cluster-node-subnet is defined more than once",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							NetworkSpec: infrav1.NetworkSpec{Subnets: tc.subnets, SharedSecurityGroup: tc.sharedSecurityGroup},
+						},
+					},
+				},
+			}
+
+			err := r.setSubnetDefaults()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(r.scope.Subnets(), tc.expected) {
+				t.Errorf("expected subnets %+v, got %+v", tc.expected, r.scope.Subnets())
+			}
+		})
+	}
+}
+
+func TestReconcileOutboundIPs(t *testing.T) {
+	testcases := []struct {
+		name          string
+		count         *int32
+		existing      []infrav1.PublicIP
+		expect        func(m *mocks.MockGetterServiceMockRecorder)
+		expectedNames []string
+	}{
+		{
+			name:          "default count uses only the api server ip",
+			expect:        func(m *mocks.MockGetterServiceMockRecorder) {},
+			expectedNames: []string{},
+		},
+		{
+			name:  "additional ips are created",
+			count: to.Int32Ptr(3),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip-outbound-1", DNSName: "my-ip-outbound-1", SKU: network.PublicIPAddressSkuNameStandard})
+				m.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip-outbound-2", DNSName: "my-ip-outbound-2", SKU: network.PublicIPAddressSkuNameStandard})
+			},
+			expectedNames: []string{"my-ip-outbound-1", "my-ip-outbound-2"},
+		},
+		{
+			name:     "surplus ips are released",
+			count:    to.Int32Ptr(2),
+			existing: []infrav1.PublicIP{{Name: "my-ip-outbound-1"}, {Name: "my-ip-outbound-2"}},
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip-outbound-1", DNSName: "my-ip-outbound-1", SKU: network.PublicIPAddressSkuNameStandard})
+				m.Delete(gomock.Any(), &publicips.Spec{Name: "my-ip-outbound-2"})
+			},
+			expectedNames: []string{"my-ip-outbound-1"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			publicIPMock := mocks.NewMockGetterService(mockCtrl)
+			tc.expect(publicIPMock.EXPECT())
+
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							NetworkSpec: infrav1.NetworkSpec{OutboundPublicIPCount: tc.count},
+						},
+						Status: infrav1.AzureClusterStatus{
+							Network: infrav1.Network{
+								APIServerIP: infrav1.PublicIP{Name: "my-ip"},
+								OutboundIPs: tc.existing,
+							},
+						},
+					},
+					Context: context.TODO(),
+				},
+				publicIPSvc: publicIPMock,
+			}
+
+			names, err := r.reconcileOutboundIPs()
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(names, tc.expectedNames) {
+				t.Errorf("expected outbound ips %v, got %v", tc.expectedNames, names)
+			}
+			if err := r.releaseOutboundIPs(names); err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if len(r.scope.Network().OutboundIPs) != len(tc.expectedNames) {
+				t.Errorf("expected %d outbound ips in status, got %+v", len(tc.expectedNames), r.scope.Network().OutboundIPs)
+			}
+		})
+	}
+}
+
+func TestReconcileLoadBalancers(t *testing.T) {
+	testcases := []struct {
+		name                string
+		lbType              infrav1.LoadBalancerType
+		dnsLabel            string
+		sku                 infrav1.SKU
+		outboundIPCount     *int32
+		privateEndpoint     *infrav1.PrivateEndpointSpec
+		expect              func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder)
+		expectedAPIServerIP infrav1.PublicIP
+		expectedError       string
+	}{
+		{
+			name: "public api server",
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+				internalLB.Reconcile(gomock.Any(), gomock.Any())
+				publicIP.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-ip", SKU: network.PublicIPAddressSkuNameStandard})
+				publicIP.Get(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-ip", SKU: network.PublicIPAddressSkuNameStandard}).Return(network.PublicIPAddress{
+					ID: to.StringPtr("my-ip-id"),
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						IPAddress: to.StringPtr("20.0.0.1"),
+						DNSSettings: &network.PublicIPAddressDNSSettings{
+							Fqdn: to.StringPtr("my-ip.eastus.cloudapp.azure.com"),
+						},
+					},
+				}, nil)
+				publicLB.Reconcile(gomock.Any(), &publicloadbalancers.Spec{
+					Name:                  "my-cluster-public-lb",
+					PublicIPName:          "my-ip",
+					OutboundPublicIPNames: []string{},
+					SKU:                   network.LoadBalancerSkuNameStandard,
+				})
+			},
+			expectedAPIServerIP: infrav1.PublicIP{
+				ID:        "my-ip-id",
+				Name:      "my-ip",
+				IPAddress: "20.0.0.1",
+				DNSName:   "my-ip.eastus.cloudapp.azure.com",
+			},
+		},
+		{
+			name: "public api server without a dns label",
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+				internalLB.Reconcile(gomock.Any(), gomock.Any())
+				publicIP.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-ip", SKU: network.PublicIPAddressSkuNameStandard})
+				publicIP.Get(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-ip", SKU: network.PublicIPAddressSkuNameStandard}).Return(network.PublicIPAddress{
+					ID: to.StringPtr("my-ip-id"),
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						IPAddress: to.StringPtr("20.0.0.1"),
+					},
+				}, nil)
+				publicLB.Reconcile(gomock.Any(), gomock.Any())
+			},
+			expectedAPIServerIP: infrav1.PublicIP{
+				ID:        "my-ip-id",
+				Name:      "my-ip",
+				IPAddress: "20.0.0.1",
+			},
+		},
+		{
+			name:     "public api server with a custom dns label",
+			dnsLabel: "my-api",
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+				internalLB.Reconcile(gomock.Any(), gomock.Any())
+				publicIP.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-api", SKU: network.PublicIPAddressSkuNameStandard})
+				publicIP.Get(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-api", SKU: network.PublicIPAddressSkuNameStandard}).Return(network.PublicIPAddress{
+					ID: to.StringPtr("my-ip-id"),
+					PublicIPAddressPropertiesFormat: &network.PublicIPAddressPropertiesFormat{
+						IPAddress: to.StringPtr("20.0.0.1"),
+						DNSSettings: &network.PublicIPAddressDNSSettings{
+							DomainNameLabel: to.StringPtr("my-api"),
+							Fqdn:            to.StringPtr("my-api.eastus.cloudapp.azure.com"),
+						},
+					},
+				}, nil)
+				publicLB.Reconcile(gomock.Any(), gomock.Any())
+			},
+			expectedAPIServerIP: infrav1.PublicIP{
+				ID:        "my-ip-id",
+				Name:      "my-ip",
+				IPAddress: "20.0.0.1",
+				DNSName:   "my-api.eastus.cloudapp.azure.com",
+			},
+		},
+		{
+			name: "basic load balancers and public ip",
+			sku:  infrav1.SKUBasic,
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+				internalLB.Reconcile(gomock.Any(), &internalloadbalancers.Spec{
+					Name:       "my-cluster-internal-lb",
+					SubnetName: "my-cluster-controlplane-subnet",
+					SubnetCidr: "10.0.0.0/16",
+					VnetName:   "my-vnet",
+					SKU:        network.LoadBalancerSkuNameBasic,
+				})
+				publicIP.Reconcile(gomock.Any(), &publicips.Spec{Name: "my-ip", DNSName: "my-ip", SKU: network.PublicIPAddressSkuNameBasic})
+				publicIP.Get(gomock.Any(), gomock.Any()).Return(network.PublicIPAddress{}, nil)
+				publicLB.Reconcile(gomock.Any(), &publicloadbalancers.Spec{
+					Name:                  "my-cluster-public-lb",
+					PublicIPName:          "my-ip",
+					OutboundPublicIPNames: []string{},
+					SKU:                   network.LoadBalancerSkuNameBasic,
+				})
+			},
+			expectedAPIServerIP: infrav1.PublicIP{Name: "my-ip"},
+		},
+		{
+			name:            "basic load balancer with additional outbound public ips",
+			sku:             infrav1.SKUBasic,
+			outboundIPCount: to.Int32Ptr(2),
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+			},
+			expectedAPIServerIP: infrav1.PublicIP{Name: "my-ip"},
+			expectedError:       "Basic load balancers cannot use 2 outbound public ips, only Standard load balancers support additional outbound public ips",
+		},
+		{
+			name:   "internal api server",
+			lbType: infrav1.LoadBalancerTypeInternal,
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+				internalLB.Reconcile(gomock.Any(), &internalloadbalancers.Spec{
+					Name:       "my-cluster-internal-lb",
+					SubnetName: "my-cluster-controlplane-subnet",
+					SubnetCidr: "10.0.0.0/16",
+					VnetName:   "my-vnet",
+					SKU:        network.LoadBalancerSkuNameStandard,
+				})
+			},
+			expectedAPIServerIP: infrav1.PublicIP{Name: "my-ip"},
+		},
+		{
+			name:            "internal api server with a private endpoint",
+			lbType:          infrav1.LoadBalancerTypeInternal,
+			privateEndpoint: &infrav1.PrivateEndpointSpec{SubnetID: "clients-subnet-id"},
+			expect: func(internalLB, publicLB, privateEndpoint *mocks.MockServiceMockRecorder, publicIP *mocks.MockGetterServiceMockRecorder) {
+				gomock.InOrder(
+					internalLB.Reconcile(gomock.Any(), gomock.Any()),
+					privateEndpoint.Reconcile(gomock.Any(), &privateendpoints.Spec{
+						Name:                   "my-cluster-apiserver-pe",
+						SubnetID:               "clients-subnet-id",
+						PrivateLinkServiceName: "my-cluster-apiserver-pls",
+						LoadBalancerName:       "my-cluster-internal-lb",
+					}),
+				)
+			},
+			expectedAPIServerIP: infrav1.PublicIP{Name: "my-ip"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			internalLBMock := mocks.NewMockService(mockCtrl)
+			publicIPMock := mocks.NewMockGetterService(mockCtrl)
+			publicLBMock := mocks.NewMockService(mockCtrl)
+			privateEndpointMock := mocks.NewMockService(mockCtrl)
+			tc.expect(internalLBMock.EXPECT(), publicLBMock.EXPECT(), privateEndpointMock.EXPECT(), publicIPMock.EXPECT())
+
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							NetworkSpec: infrav1.NetworkSpec{
+								Vnet: infrav1.VnetSpec{Name: "my-vnet"},
+								Subnets: infrav1.Subnets{{
+									Name:      "my-cluster-controlplane-subnet",
+									Role:      infrav1.SubnetControlPlane,
+									CidrBlock: "10.0.0.0/16",
+								}},
+								APIServerLB:              infrav1.LoadBalancerSpec{Type: tc.lbType, DNSLabel: tc.dnsLabel, SKU: tc.sku},
+								OutboundPublicIPCount:    tc.outboundIPCount,
+								APIServerPrivateEndpoint: tc.privateEndpoint,
+							},
+						},
+						Status: infrav1.AzureClusterStatus{
+							Network: infrav1.Network{APIServerIP: infrav1.PublicIP{Name: "my-ip"}},
+						},
+					},
+					Context: context.TODO(),
+				},
+				internalLBSvc:      internalLBMock,
+				publicIPSvc:        publicIPMock,
+				publicLBSvc:        publicLBMock,
+				privateEndpointSvc: privateEndpointMock,
+			}
+
+			err := r.reconcileLoadBalancers()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(r.scope.Network().APIServerIP, tc.expectedAPIServerIP) {
+				t.Errorf("expected api server ip %+v, got %+v", tc.expectedAPIServerIP, r.scope.Network().APIServerIP)
+			}
+		})
+	}
+}
+
+func TestBackendPoolSpecs(t *testing.T) {
+	machine := func(name string, controlPlane bool) clusterv1.Machine {
+		m := clusterv1.Machine{
+			ObjectMeta: v1.ObjectMeta{Name: name, Labels: map[string]string{}},
+			Spec: clusterv1.MachineSpec{
+				InfrastructureRef: corev1.ObjectReference{Kind: "AzureMachine", Name: name + "-azure"},
+			},
+		}
+		if controlPlane {
+			m.Labels[clusterv1.MachineControlPlaneLabelName] = "true"
+		}
+		return m
+	}
+	machines := []clusterv1.Machine{
+		machine("cp-0", true),
+		machine("node-0", false),
+		machine("node-1", false),
+		{ObjectMeta: v1.ObjectMeta{Name: "other"}, Spec: clusterv1.MachineSpec{InfrastructureRef: corev1.ObjectReference{Kind: "OtherMachine", Name: "other"}}},
+	}
+
+	testcases := []struct {
+		name         string
+		apiServerLB  infrav1.LoadBalancerSpec
+		expectedSpec []*backendpools.Spec
+	}{
+		{
+			name: "public API server",
+			expectedSpec: []*backendpools.Spec{
+				{LoadBalancerName: "my-cluster-internal-lb", BackendPoolName: azure.ControlPlaneInternalBackendPoolName, NetworkInterfaceNames: []string{"cp-0-azure-nic"}},
+				{LoadBalancerName: "my-cluster-public-lb", BackendPoolName: azure.ControlPlaneBackendPoolName, NetworkInterfaceNames: []string{"cp-0-azure-nic"}},
+				{LoadBalancerName: "my-cluster-public-lb", BackendPoolName: azure.NodeBackendPoolName, NetworkInterfaceNames: []string{"node-0-azure-nic", "node-1-azure-nic"}},
+			},
+		},
+		{
+			name:        "internal API server",
+			apiServerLB: infrav1.LoadBalancerSpec{Type: infrav1.LoadBalancerTypeInternal},
+			expectedSpec: []*backendpools.Spec{
+				{LoadBalancerName: "my-cluster-internal-lb", BackendPoolName: azure.ControlPlaneInternalBackendPoolName, NetworkInterfaceNames: []string{"cp-0-azure-nic"}},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Logger:  klogr.New(),
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							NetworkSpec: infrav1.NetworkSpec{APIServerLB: tc.apiServerLB},
+						},
+					},
+				},
+			}
+			specs := r.backendPoolSpecs(machines)
+			if !reflect.DeepEqual(specs, tc.expectedSpec) {
+				t.Errorf("expected backend pools %+v, got %+v", tc.expectedSpec, specs)
+			}
+		})
+	}
+}
+
+func TestPrivateDNSRecords(t *testing.T) {
+	machines := []clusterv1.Machine{
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "cp-0"},
+			Status: clusterv1.MachineStatus{
+				NodeRef: &corev1.ObjectReference{Name: "my-cluster-cp-0"},
+				Addresses: clusterv1.MachineAddresses{
+					{Type: clusterv1.MachineExternalIP, Address: "20.0.0.1"},
+					{Type: clusterv1.MachineInternalIP, Address: "10.0.0.4"},
+				},
+			},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "node-0"},
+			Status: clusterv1.MachineStatus{
+				NodeRef:   &corev1.ObjectReference{Name: "my-cluster-node-0"},
+				Addresses: clusterv1.MachineAddresses{{Type: clusterv1.MachineInternalIP, Address: "10.1.0.4"}},
+			},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "node-1"},
+			Status: clusterv1.MachineStatus{
+				Addresses: clusterv1.MachineAddresses{{Type: clusterv1.MachineInternalIP, Address: "10.1.0.5"}},
+			},
+		},
+	}
+	expected := []privatednszones.Record{
+		{Hostname: "my-cluster-cp-0", IP: "10.0.0.4"},
+		{Hostname: "my-cluster-node-0", IP: "10.1.0.4"},
+	}
+	if records := privateDNSRecords(machines); !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected records %+v, got %+v", expected, records)
+	}
+}
+
+func TestReconcileNetwork(t *testing.T) {
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+	testcases := []struct {
+		name          string
+		expect        func(vnet, routeTable, natGateway, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder)
+		expectedError string
+	}{
+		{
+			name: "subnets wait for the vnet and the resources they are associated with",
+			expect: func(vnet, routeTable, natGateway, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				vnetReconcile := vnet.Reconcile(gomock.Any(), gomock.Any())
+
+				// the route table and the NAT gateway each wait for the other to start, which only succeeds when they
+				// run concurrently.
+				routeTableStarted := make(chan struct{})
+				natGatewayStarted := make(chan struct{})
+				routeTableReconcile := routeTable.Reconcile(gomock.Any(), gomock.Any()).After(vnetReconcile).
+					DoAndReturn(func(context.Context, interface{}) error {
+						close(routeTableStarted)
+						return waitFor(natGatewayStarted)
+					})
+				natGatewayReconcile := natGateway.Reconcile(gomock.Any(), gomock.Any()).After(vnetReconcile).
+					DoAndReturn(func(context.Context, interface{}) error {
+						close(natGatewayStarted)
+						return waitFor(routeTableStarted)
+					})
+
+				var sgCreates []*gomock.Call
+				for _, name := range []string{"my-cluster-controlplane-nsg", "my-cluster-node-nsg"} {
+					sgGet := sg.Get(gomock.Any(), "my-rg", name).Return(network.SecurityGroup{}, notFound).After(vnetReconcile)
+					sgCreates = append(sgCreates, sg.CreateOrUpdate(gomock.Any(), "my-rg", name, gomock.Any()).After(sgGet))
+				}
+				sg.List(gomock.Any(), "my-rg", gomock.Any()).Return([]network.SecurityRule{}, nil).Times(2)
+
+				subnets.Reconcile(gomock.Any(), gomock.Any()).Times(2).
+					After(routeTableReconcile).
+					After(natGatewayReconcile).
+					After(sgCreates[0]).
+					After(sgCreates[1])
+			},
+		},
+		{
+			name: "failures of independent resources are all reported and the subnets are not reconciled",
+			expect: func(vnet, routeTable, natGateway, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				vnet.Reconcile(gomock.Any(), gomock.Any())
+				routeTable.Reconcile(gomock.Any(), gomock.Any()).Return(errors.New("route table conflict"))
+				natGateway.Reconcile(gomock.Any(), gomock.Any()).Return(errors.New("NAT gateway conflict"))
+				sg.Get(gomock.Any(), "my-rg", gomock.Any()).Return(network.SecurityGroup{}, notFound).Times(2)
+				sg.CreateOrUpdate(gomock.Any(), "my-rg", gomock.Any(), gomock.Any()).Times(2)
+				sg.List(gomock.Any(), "my-rg", gomock.Any()).Return([]network.SecurityRule{}, nil).Times(2)
+			},
+			expectedError: "[failed to reconcile node route table for cluster my-cluster: route table conflict, " +
+				"failed to reconcile NAT gateway my-cluster-node-natgw for cluster my-cluster: NAT gateway conflict]",
+		},
+		{
+			name: "nothing is reconciled in the vnet until it is",
+			expect: func(vnet, routeTable, natGateway, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				vnet.Reconcile(gomock.Any(), gomock.Any()).Return(errors.New("conflict"))
+			},
+			expectedError: "failed to reconcile virtual network for cluster my-cluster: conflict",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			vnetMock := mocks.NewMockService(mockCtrl)
+			routeTableMock := mocks.NewMockService(mockCtrl)
+			natGatewayMock := mocks.NewMockService(mockCtrl)
+			subnetsMock := mocks.NewMockService(mockCtrl)
+			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
+			tc.expect(vnetMock.EXPECT(), routeTableMock.EXPECT(), natGatewayMock.EXPECT(), subnetsMock.EXPECT(), sgMock.EXPECT())
+
+			clusterScope := &scope.ClusterScope{
+				Logger:  klogr.New(),
+				Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg", CidrBlock: "10.0.0.0/8"},
+							Subnets: infrav1.Subnets{
+								{
+									Name:          "my-cluster-controlplane-subnet",
+									Role:          infrav1.SubnetControlPlane,
+									CidrBlock:     "10.0.0.0/16",
+									SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-controlplane-nsg"},
+								},
+								{
+									Name:          "my-cluster-node-subnet",
+									Role:          infrav1.SubnetNode,
+									CidrBlock:     "10.1.0.0/16",
+									SecurityGroup: infrav1.SecurityGroup{Name: "my-cluster-node-nsg"},
+									NatGateway:    &infrav1.NatGateway{Name: "my-cluster-node-natgw"},
+								},
+							},
+						},
+					},
+				},
+				Context: context.TODO(),
+			}
+			r := &azureClusterReconciler{
+				scope:            clusterScope,
+				vnetSvc:          vnetMock,
+				securityGroupSvc: &securitygroups.Service{Scope: clusterScope, Client: sgMock},
+				routeTableSvc:    routeTableMock,
+				natGatewaySvc:    natGatewayMock,
+				subnetsSvc:       subnetsMock,
+			}
+
+			err := r.reconcileNetwork()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestReconcileSharedSecurityGroup(t *testing.T) {
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	sgMock := mock_securitygroups.NewMockClient(mockCtrl)
+
+	var ruleNames []string
+	sgMock.EXPECT().Get(gomock.Any(), "my-rg", "my-cluster-nsg").Return(network.SecurityGroup{}, notFound)
+	sgMock.EXPECT().CreateOrUpdate(gomock.Any(), "my-rg", "my-cluster-nsg", gomock.Any()).
+		DoAndReturn(func(_ context.Context, _, _ string, sg network.SecurityGroup) error {
+			for _, rule := range *sg.SecurityRules {
+				ruleNames = append(ruleNames, to.String(rule.Name))
+			}
+			return nil
+		})
+	sgMock.EXPECT().List(gomock.Any(), "my-rg", "my-cluster-nsg").Return([]network.SecurityRule{}, nil).Times(2)
+
+	kubeletRule := &infrav1.SecurityRule{Name: "allow_kubelet", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 210, DestinationPorts: to.StringPtr("10250")}
+	clusterScope := &scope.ClusterScope{
+		Logger:  klogr.New(),
+		Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+		AzureCluster: &infrav1.AzureCluster{
+			Spec: infrav1.AzureClusterSpec{
+				ResourceGroup: "my-rg",
+				NetworkSpec: infrav1.NetworkSpec{
+					Vnet:                infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+					SharedSecurityGroup: &infrav1.SharedSecurityGroupSpec{},
+					Subnets: infrav1.Subnets{
+						{
+							Name: "my-cluster-controlplane-subnet",
+							Role: infrav1.SubnetControlPlane,
+							SecurityGroup: infrav1.SecurityGroup{
+								Name:          "my-cluster-nsg",
+								SecurityRules: infrav1.SecurityRules{kubeletRule},
+							},
+						},
+						{
+							Name: "my-cluster-node-subnet",
+							Role: infrav1.SubnetNode,
+							SecurityGroup: infrav1.SecurityGroup{
+								Name: "my-cluster-nsg",
+								SecurityRules: infrav1.SecurityRules{
+									kubeletRule,
+									{Name: "allow_nodeports", Protocol: infrav1.SecurityGroupProtocolTCP, Priority: 220, DestinationPorts: to.StringPtr("30000-32767")},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Context: context.TODO(),
+	}
+	r := &azureClusterReconciler{
+		scope:            clusterScope,
+		securityGroupSvc: &securitygroups.Service{Scope: clusterScope, Client: sgMock},
+	}
+
+	if err := r.reconcileSecurityGroups(); err != nil {
+		t.Fatalf("got an unexpected error: %v", err)
+	}
+	expected := []string{"allow_ssh", "allow_6443", "allow_kubelet", "allow_nodeports"}
+	if !reflect.DeepEqual(ruleNames, expected) {
+		t.Errorf("expected security rules %v, got %v", expected, ruleNames)
+	}
+}
+
+func TestReconcileConditions(t *testing.T) {
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+
+	testcases := []struct {
+		name                       string
+		expect                     func(groups, vnet, routeTable, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder)
+		expectedNetworkReady       *infrav1.Condition
+		expectedLoadBalancersReady *infrav1.Condition
+	}{
+		{
+			name: "network failure",
+			expect: func(groups, vnet, routeTable, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				groups.Reconcile(gomock.Any(), gomock.Any()).Return(errors.New("conflict"))
+			},
+			expectedNetworkReady: &infrav1.Condition{
+				Type:    infrav1.NetworkReadyCondition,
+				Status:  "False",
+				Reason:  infrav1.NetworkReconcileFailedReason,
+				Message: "failed to reconcile resource group for cluster my-cluster: conflict",
+			},
+		},
+		{
+			name: "load balancer failure",
+			expect: func(groups, vnet, routeTable, subnets *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				groups.Reconcile(gomock.Any(), gomock.Any())
+				vnet.Reconcile(gomock.Any(), gomock.Any())
+				routeTable.Reconcile(gomock.Any(), gomock.Any())
+				sg.Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(network.SecurityGroup{}, notFound).Times(2)
+				sg.CreateOrUpdate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(2)
+				sg.List(gomock.Any(), gomock.Any(), gomock.Any()).Return([]network.SecurityRule{}, nil).Times(2)
+				subnets.Reconcile(gomock.Any(), gomock.Any()).Times(2)
+			},
+			expectedNetworkReady: &infrav1.Condition{Type: infrav1.NetworkReadyCondition, Status: "True"},
+			expectedLoadBalancersReady: &infrav1.Condition{
+				Type:    infrav1.LoadBalancersReadyCondition,
+				Status:  "False",
+				Reason:  infrav1.LoadBalancerReconcileFailedReason,
+				Message: "Basic load balancers cannot use 2 outbound public ips, only Standard load balancers support additional outbound public ips",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			groupsMock := mocks.NewMockService(mockCtrl)
+			vnetMock := mocks.NewMockService(mockCtrl)
+			routeTableMock := mocks.NewMockService(mockCtrl)
+			subnetsMock := mocks.NewMockService(mockCtrl)
+			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
+			tc.expect(groupsMock.EXPECT(), vnetMock.EXPECT(), routeTableMock.EXPECT(), subnetsMock.EXPECT(), sgMock.EXPECT())
+
+			clusterScope := &scope.ClusterScope{
+				Logger:  klogr.New(),
+				Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							APIServerLB:           infrav1.LoadBalancerSpec{Type: infrav1.LoadBalancerTypeInternal, SKU: infrav1.SKUBasic},
+							OutboundPublicIPCount: to.Int32Ptr(2),
+						},
+					},
+				},
+				Context: context.TODO(),
+			}
+			r := &azureClusterReconciler{
+				scope:            clusterScope,
+				groupsSvc:        groupsMock,
+				vnetSvc:          vnetMock,
+				securityGroupSvc: &securitygroups.Service{Scope: clusterScope, Client: sgMock},
+				routeTableSvc:    routeTableMock,
+				subnetsSvc:       subnetsMock,
+			}
+
+			if err := r.Reconcile(); err == nil {
+				t.Fatal("expected an error")
+			}
+			conditions := clusterScope.AzureCluster.Status.Conditions
+			for _, expected := range []*infrav1.Condition{tc.expectedNetworkReady, tc.expectedLoadBalancersReady} {
+				if expected == nil {
+					continue
+				}
+				actual := conditions.Get(expected.Type)
+				if actual == nil {
+					t.Fatalf("expected a %s condition, got %+v", expected.Type, conditions)
+				}
+				if actual.LastTransitionTime.IsZero() {
+					t.Errorf("expected the %s condition to have a last transition time", expected.Type)
+				}
+				actual.LastTransitionTime = v1.Time{}
+				if !reflect.DeepEqual(actual, expected) {
+					t.Errorf("expected condition %+v, got %+v", expected, actual)
+				}
+			}
+			if tc.expectedLoadBalancersReady == nil && conditions.Get(infrav1.LoadBalancersReadyCondition) != nil {
+				t.Errorf("expected no %s condition, got %+v", infrav1.LoadBalancersReadyCondition, conditions)
+			}
+		})
+	}
+}
+
+// waitFor waits for the channel to be closed, it fails after a while so that a test does not hang.
+func waitFor(c <-chan struct{}) error {
+	select {
+	case <-c:
+		return nil
+	case <-time.After(5 * time.Second):
+		return errors.New("timed out")
+	}
+}
+
+func TestDeletePlacementGroups(t *testing.T) {
+	testcases := []struct {
+		name          string
+		expect        func(availabilitySet, ppg *mocks.MockServiceMockRecorder)
+		expectedError string
+	}{
+		{
+			name: "availability set is deleted before the proximity placement group",
+			expect: func(availabilitySet, ppg *mocks.MockServiceMockRecorder) {
+				gomock.InOrder(
+					availabilitySet.Delete(gomock.Any(), &availabilitysets.Spec{Name: "my-cluster-controlplane-as"}),
+					ppg.Delete(gomock.Any(), &proximityplacementgroups.Spec{Name: "my-cluster-ppg"}),
+				)
+			},
+		},
+		{
+			name: "proximity placement group is kept when the availability set cannot be deleted",
+			expect: func(availabilitySet, ppg *mocks.MockServiceMockRecorder) {
+				availabilitySet.Delete(gomock.Any(), &availabilitysets.Spec{Name: "my-cluster-controlplane-as"}).
+					Return(errors.New("conflict"))
+			},
+			expectedError: "failed to delete availability set my-cluster-controlplane-as for cluster my-cluster: conflict",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			availabilitySetMock := mocks.NewMockService(mockCtrl)
+			ppgMock := mocks.NewMockService(mockCtrl)
+			tc.expect(availabilitySetMock.EXPECT(), ppgMock.EXPECT())
+
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Cluster:      &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{},
+					Context:      context.TODO(),
+				},
+				availabilitySetSvc: availabilitySetMock,
+				ppgSvc:             ppgMock,
+			}
+
+			err := r.deletePlacementGroups()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteVnetPeerings(t *testing.T) {
+	hubVnetID := "/subscriptions/123/resourceGroups/hub-rg/providers/Microsoft.Network/virtualNetworks/hub-vnet"
+	sharedVnetID := "/subscriptions/123/resourceGroups/shared-rg/providers/Microsoft.Network/virtualNetworks/shared-vnet"
+
+	testcases := []struct {
+		name          string
+		expect        func(m *mocks.MockServiceMockRecorder)
+		expectedError string
+	}{
+		{
+			name: "peerings are deleted, unnamed peerings get a default name",
+			expect: func(m *mocks.MockServiceMockRecorder) {
+				m.Delete(gomock.Any(), &vnetpeerings.Spec{
+					Name:                  "my-cluster-to-hub-vnet",
+					VnetName:              "my-vnet",
+					RemoteVnetID:          hubVnetID,
+					AllowForwardedTraffic: true,
+				})
+				m.Delete(gomock.Any(), &vnetpeerings.Spec{Name: "to-shared", VnetName: "my-vnet", RemoteVnetID: sharedVnetID})
+			},
+		},
+		{
+			name: "peering deletion fails",
+			expect: func(m *mocks.MockServiceMockRecorder) {
+				m.Delete(gomock.Any(), gomock.Any()).Return(errors.New("conflict"))
+			},
+			expectedError: "failed to delete vnet peering my-cluster-to-hub-vnet for cluster my-cluster: conflict",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			peeringMock := mocks.NewMockService(mockCtrl)
+			tc.expect(peeringMock.EXPECT())
+
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							NetworkSpec: infrav1.NetworkSpec{
+								Vnet: infrav1.VnetSpec{Name: "my-vnet"},
+								VnetPeerings: []infrav1.VnetPeeringSpec{
+									{RemoteVnetID: hubVnetID, AllowForwardedTraffic: true},
+									{Name: "to-shared", RemoteVnetID: sharedVnetID},
+								},
+							},
+						},
+					},
+					Context: context.TODO(),
+				},
+				vnetPeeringSvc: peeringMock,
+			}
+
+			err := r.deleteVnetPeerings()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteNetwork(t *testing.T) {
+	inUse := azure.NewResourceInUseError("subnet", "my-cluster-node-subnet", "my-nic-ipconfig")
+
+	testcases := []struct {
+		name          string
+		expect        func(vnet, routeTable, subnet *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder)
+		expectedError string
+	}{
+		{
+			name: "subnets are deleted before their security groups and route table, the vnet is deleted last",
+			expect: func(vnet, routeTable, subnet *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				gomock.InOrder(
+					subnet.Delete(gomock.Any(), &subnets.Spec{Name: "my-cluster-controlplane-subnet", VnetName: "my-vnet"}),
+					subnet.Delete(gomock.Any(), &subnets.Spec{Name: "my-cluster-node-subnet", VnetName: "my-vnet"}),
+					routeTable.Delete(gomock.Any(), &routetables.Spec{Name: "my-cluster-node-routetable"}),
+					sg.Delete(gomock.Any(), "my-rg", "my-cluster-node-nsg"),
+					sg.Delete(gomock.Any(), "my-rg", "my-cluster-controlplane-nsg"),
+					vnet.Delete(gomock.Any(), &virtualnetworks.Spec{ResourceGroup: "my-rg", Name: "my-vnet"}),
+				)
+			},
+		},
+		{
+			name: "nothing else is deleted while network interfaces use a subnet",
+			expect: func(vnet, routeTable, subnet *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				subnet.Delete(gomock.Any(), &subnets.Spec{Name: "my-cluster-controlplane-subnet", VnetName: "my-vnet"})
+				subnet.Delete(gomock.Any(), &subnets.Spec{Name: "my-cluster-node-subnet", VnetName: "my-vnet"}).Return(inUse)
+			},
+			expectedError: "failed to delete subnets: failed to delete my-cluster-node-subnet subnet for cluster my-cluster: " +
+				"subnet my-cluster-node-subnet is still in use by my-nic-ipconfig",
+		},
+		{
+			name: "vnet still in use once the subnets are deleted",
+			expect: func(vnet, routeTable, subnet *mocks.MockServiceMockRecorder, sg *mock_securitygroups.MockClientMockRecorder) {
+				subnet.Delete(gomock.Any(), gomock.Any()).Times(2)
+				routeTable.Delete(gomock.Any(), gomock.Any())
+				sg.Delete(gomock.Any(), "my-rg", gomock.Any()).Times(2)
+				vnet.Delete(gomock.Any(), gomock.Any()).Return(azure.NewResourceInUseError("vnet", "my-vnet", "my-nic-ipconfig"))
+			},
+			expectedError: "failed to delete virtual network my-vnet for cluster my-cluster: vnet my-vnet is still in use by my-nic-ipconfig",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			vnetMock := mocks.NewMockService(mockCtrl)
+			routeTableMock := mocks.NewMockService(mockCtrl)
+			subnetsMock := mocks.NewMockService(mockCtrl)
+			sgMock := mock_securitygroups.NewMockClient(mockCtrl)
+			tc.expect(vnetMock.EXPECT(), routeTableMock.EXPECT(), subnetsMock.EXPECT(), sgMock.EXPECT())
+
+			clusterScope := &scope.ClusterScope{
+				Logger:  klogr.New(),
+				Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+				AzureCluster: &infrav1.AzureCluster{
+					Spec: infrav1.AzureClusterSpec{
+						ResourceGroup: "my-rg",
+						NetworkSpec: infrav1.NetworkSpec{
+							Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+							Subnets: infrav1.Subnets{
+								{Name: "my-cluster-controlplane-subnet", Role: infrav1.SubnetControlPlane},
+								{Name: "my-cluster-node-subnet", Role: infrav1.SubnetNode},
+							},
+						},
+					},
+				},
+				Context: context.TODO(),
+			}
+			r := &azureClusterReconciler{
+				scope:            clusterScope,
+				vnetSvc:          vnetMock,
+				securityGroupSvc: &securitygroups.Service{Scope: clusterScope, Client: sgMock},
+				routeTableSvc:    routeTableMock,
+				subnetsSvc:       subnetsMock,
+			}
+
+			err := r.deleteNetwork()
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Fatalf("expected error %q, got %v", tc.expectedError, err)
+				}
+				if !azure.IsResourceInUseError(err) {
+					t.Fatalf("expected a resource in use error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateNetworkAPIServerIP(t *testing.T) {
+	testcases := []struct {
+		name            string
+		dnsLabel        string
+		expectedDNSName string
+	}{
+		{
+			name:            "generated dns label",
+			expectedDNSName: "my-cluster-d8263fca.eastus.cloudapp.azure.com",
+		},
+		{
+			name:            "custom dns label",
+			dnsLabel:        "my-api",
+			expectedDNSName: "my-api.eastus.cloudapp.azure.com",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &azureClusterReconciler{
+				scope: &scope.ClusterScope{
+					AzureClients: scope.AzureClients{SubscriptionID: "123"},
+					Cluster:      &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &infrav1.AzureCluster{
+						Spec: infrav1.AzureClusterSpec{
+							ResourceGroup: "my-rg",
+							Location:      "eastus",
+							NetworkSpec: infrav1.NetworkSpec{
+								APIServerLB: infrav1.LoadBalancerSpec{DNSLabel: tc.dnsLabel},
+							},
+						},
+					},
+				},
+			}
+
+			r.createOrUpdateNetworkAPIServerIP()
+			if dnsName := r.scope.Network().APIServerIP.DNSName; dnsName != tc.expectedDNSName {
+				t.Errorf("expected dns name %q, got %q", tc.expectedDNSName, dnsName)
+			}
+			generated := r.scope.Network().APIServerIP.Name
+			r.createOrUpdateNetworkAPIServerIP()
+			if r.scope.Network().APIServerIP.Name != generated {
+				t.Errorf("expected public ip name %q to be stable, got %q", generated, r.scope.Network().APIServerIP.Name)
+			}
+		})
+	}
+}
@@ -19,12 +19,14 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/record"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
@@ -40,11 +42,21 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// vmCreationPollInterval is the delay before a reconcile polls the creation of a VM in progress again.
+const vmCreationPollInterval = 15 * time.Second
+
 // AzureMachineReconciler reconciles a AzureMachine object
 type AzureMachineReconciler struct {
 	client.Client
 	Log      logr.Logger
 	Recorder record.EventRecorder
+	// AzureClients holds the rate limit of the requests to the Azure API.
+	AzureClients scope.AzureClients
+	// DryRun makes the reconciler log the changes it would make to the Azure resources, without making them.
+	DryRun bool
+
+	// newWorkloadClusterClient replaces the client of the workload cluster in tests.
+	newWorkloadClusterClient func(*clusterv1.Cluster) (typedcorev1.CoreV1Interface, error)
 }
 
 func (r *AzureMachineReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
@@ -68,6 +80,7 @@ func (r *AzureMachineReconciler) SetupWithManager(mgr ctrl.Manager, options cont
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azuremachines/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines;machines/status,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 
 func (r *AzureMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
 	ctx := context.TODO()
@@ -119,10 +132,12 @@ func (r *AzureMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, ret
 
 	// Create the cluster scope
 	clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+		AzureClients: r.AzureClients,
 		Client:       r.Client,
 		Logger:       logger,
 		Cluster:      cluster,
 		AzureCluster: azureCluster,
+		DryRun:       r.DryRun,
 	})
 	if err != nil {
 		return reconcile.Result{}, err
@@ -143,6 +158,11 @@ func (r *AzureMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, ret
 
 	// Always close the scope when exiting this function so we can persist any AzureMachine changes.
 	defer func() {
+		logPlannedActions(clusterScope)
+		// the status of the AzureMachine would describe a VM which was not changed.
+		if clusterScope.IsDryRun() {
+			return
+		}
 		if err := machineScope.Close(); err != nil && reterr == nil {
 			reterr = err
 		}
@@ -150,11 +170,13 @@ func (r *AzureMachineReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, ret
 
 	// Handle deleted machines
 	if !azureMachine.ObjectMeta.DeletionTimestamp.IsZero() {
-		return r.reconcileDelete(machineScope, clusterScope)
+		result, err := r.reconcileDelete(machineScope, clusterScope)
+		return requeueOnError(logger, result, err)
 	}
 
 	// Handle non-deleted machines
-	return r.reconcileNormal(ctx, machineScope, clusterScope)
+	result, err := r.reconcileNormal(ctx, machineScope, clusterScope)
+	return requeueOnError(logger, result, err)
 }
 
 // findVM queries the Azure APIs and retrieves the VM if it exists, returns nil otherwise.
@@ -183,14 +205,25 @@ func (r *AzureMachineReconciler) reconcileNormal(ctx context.Context, machineSco
 		machineScope.AzureMachine.Finalizers = append(machineScope.AzureMachine.Finalizers, infrav1.MachineFinalizer)
 	}
 
+	conditions := &machineScope.AzureMachine.Status.Conditions
 	if !machineScope.Cluster.Status.InfrastructureReady {
 		machineScope.Info("Cluster infrastructure is not ready yet")
+		conditions.MarkFalse(infrav1.VMProvisionedCondition, infrav1.WaitingForClusterInfrastructureReason, "")
 		return reconcile.Result{}, nil
 	}
 
 	// Make sure bootstrap data is available and populated.
 	if machineScope.Machine.Spec.Bootstrap.Data == nil {
 		machineScope.Info("Bootstrap data is not yet available")
+		conditions.MarkFalse(infrav1.VMProvisionedCondition, infrav1.WaitingForBootstrapDataReason, "")
+		return reconcile.Result{}, nil
+	}
+
+	if errs := r.validateSpec(machineScope); len(errs) > 0 {
+		agg := kerrors.NewAggregate(errs)
+		r.Recorder.Eventf(machineScope.AzureMachine, corev1.EventTypeWarning, "InvalidConfiguration", "Invalid configuration: %s", agg.Error())
+		machineScope.SetErrorReason(capierrors.InvalidConfigurationMachineError)
+		machineScope.SetErrorMessage(agg)
 		return reconcile.Result{}, nil
 	}
 
@@ -198,6 +231,10 @@ func (r *AzureMachineReconciler) reconcileNormal(ctx context.Context, machineSco
 
 	// Get or create the virtual machine.
 	vm, err := r.getOrCreate(machineScope, ams)
+	if azure.IsOperationNotDoneError(err) {
+		machineScope.Info("Waiting for the VM to be created")
+		return reconcile.Result{RequeueAfter: vmCreationPollInterval}, nil
+	}
 	if err != nil {
 		return reconcile.Result{}, err
 	}
@@ -223,8 +260,14 @@ func (r *AzureMachineReconciler) reconcileNormal(ctx context.Context, machineSco
 	// Make sure Spec.ProviderID is always set.
 	machineScope.SetProviderID(fmt.Sprintf("azure:////%s", vm.ID))
 
+	if err := ams.reconcilePowerState(vm); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	// Proceed to reconcile the AzureMachine state.
 	machineScope.SetVMState(vm.State)
+	machineScope.SetVMPowerState(vm.PowerState)
+	setVMConditions(machineScope, vm.State, vm.PowerState)
 
 	// TODO(vincepri): Remove this annotation when clusterctl is no longer relevant.
 	machineScope.SetAnnotation("cluster-api-provider-azure", "true")
@@ -243,13 +286,20 @@ func (r *AzureMachineReconciler) reconcileNormal(ctx context.Context, machineSco
 	}
 
 	if err := ams.reconcileNetworkInterface(azure.GenerateNICName(machineScope.Name())); err != nil {
-		return reconcile.Result{}, errors.Errorf("failed to reconcile NIC: %+v", err)
+		return reconcile.Result{}, errors.Wrap(err, "failed to reconcile NIC")
+	}
+
+	// Extensions can only be installed on a running virtual machine.
+	if vm.State == infrav1.VMStateSucceeded && !machineScope.AzureMachine.Spec.Deallocated {
+		if err := ams.reconcileVMExtensions(); err != nil {
+			return reconcile.Result{}, errors.Wrap(err, "failed to reconcile VM extensions")
+		}
 	}
 
 	// Ensure that the tags are correct.
 	err = r.reconcileTags(machineScope, clusterScope, machineScope.AdditionalTags())
 	if err != nil {
-		return reconcile.Result{}, errors.Errorf("failed to ensure tags: %+v", err)
+		return reconcile.Result{}, errors.Wrap(err, "failed to ensure tags")
 	}
 
 	return reconcile.Result{}, nil
@@ -264,7 +314,12 @@ func (r *AzureMachineReconciler) getOrCreate(scope *scope.MachineScope, ams *azu
 	if vm == nil {
 		// Create a new AzureMachine VM if we couldn't find a running VM.
 		vm, err = ams.Create()
+		if azure.IsOperationNotDoneError(err) {
+			scope.AzureMachine.Status.Conditions.MarkFalse(infrav1.VMProvisionedCondition, infrav1.VMProvisioningReason, "VM is being created")
+			return nil, err
+		}
 		if err != nil {
+			scope.AzureMachine.Status.Conditions.MarkFalse(infrav1.VMProvisionedCondition, infrav1.VMProvisioningFailedReason, err.Error())
 			return nil, errors.Wrapf(err, "failed to create AzureMachine VM")
 		}
 	}
@@ -272,9 +327,40 @@ func (r *AzureMachineReconciler) getOrCreate(scope *scope.MachineScope, ams *azu
 	return vm, nil
 }
 
+// setVMConditions sets the VMProvisioned and VMRunning conditions of the machine from the provisioning state and the
+// power state of its virtual machine. A provisioned virtual machine is running unless Azure reports another power
+// state, such as stopped or deallocated.
+func setVMConditions(machineScope *scope.MachineScope, state infrav1.VMState, powerState infrav1.VMPowerState) {
+	conditions := &machineScope.AzureMachine.Status.Conditions
+	switch state {
+	case infrav1.VMStateSucceeded:
+		conditions.MarkTrue(infrav1.VMProvisionedCondition)
+		if powerState != "" && powerState != infrav1.VMPowerStateRunning {
+			conditions.MarkFalse(infrav1.VMRunningCondition, infrav1.VMNotRunningReason, fmt.Sprintf("VM is %s", powerState))
+			return
+		}
+		conditions.MarkTrue(infrav1.VMRunningCondition)
+		return
+	case infrav1.VMStateCreating, infrav1.VMStateUpdating:
+		conditions.MarkFalse(infrav1.VMProvisionedCondition, infrav1.VMProvisioningReason, fmt.Sprintf("VM is %s", state))
+	default:
+		conditions.MarkFalse(infrav1.VMProvisionedCondition, infrav1.VMProvisioningFailedReason, fmt.Sprintf("Azure VM state %q is unexpected", state))
+	}
+	conditions.MarkFalse(infrav1.VMRunningCondition, infrav1.VMNotRunningReason, fmt.Sprintf("VM state is %s", state))
+}
+
 func (r *AzureMachineReconciler) reconcileDelete(machineScope *scope.MachineScope, clusterScope *scope.ClusterScope) (_ reconcile.Result, reterr error) {
 	machineScope.Info("Handling deleted AzureMachine")
 
+	delay, err := r.nodeDrainDelay(machineScope, time.Now())
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "failed to check whether the node of machine %s is drained", machineScope.Name())
+	}
+	if delay > 0 {
+		machineScope.Info("Waiting for the node to be drained before deleting the VM", "node", machineScope.Machine.Status.NodeRef.Name)
+		return reconcile.Result{RequeueAfter: delay}, nil
+	}
+
 	if err := newAzureMachineService(machineScope, clusterScope).Delete(); err != nil {
 		return reconcile.Result{}, errors.Wrapf(err, "error deleting AzureCluster %s/%s", clusterScope.Namespace(), clusterScope.Name())
 	}
@@ -288,6 +374,49 @@ func (r *AzureMachineReconciler) reconcileDelete(machineScope *scope.MachineScop
 	return reconcile.Result{}, nil
 }
 
+// validateSpec checks that the AzureMachine spec is valid for the role of the machine, that its OS disk fits the
+// default image and that its data disks have unique names and LUNs, and returns a slice of errors representing
+// invalid configuration.
+func (r *AzureMachineReconciler) validateSpec(machineScope *scope.MachineScope) (errs []error) {
+	if machineScope.IsControlPlane() && machineScope.AzureMachine.Spec.SpotVMOptions != nil {
+		errs = append(errs, errors.Errorf("control plane machine %s cannot be a spot virtual machine", machineScope.Name()))
+	}
+
+	// The Azure image API does not expose the OS disk size of custom images, Azure rejects those that are too small.
+	osDiskSizeGB := machineScope.AzureMachine.Spec.OSDisk.DiskSizeGB
+	if machineScope.AzureMachine.Spec.Image == nil && osDiskSizeGB > 0 && osDiskSizeGB < azure.DefaultImageOSDiskSizeGB {
+		errs = append(errs, errors.Errorf("OS disk of machine %s is %d GB, the default image needs at least %d GB", machineScope.Name(), osDiskSizeGB, azure.DefaultImageOSDiskSizeGB))
+	}
+
+	if nics := machineScope.AzureMachine.Spec.NetworkInterfaces; len(nics) > 0 {
+		primaries := 0
+		for i, nic := range nics {
+			if nic.Primary {
+				primaries++
+			} else if nic.SubnetName == "" {
+				errs = append(errs, errors.Errorf("secondary network interface %d of machine %s requires a subnet", i, machineScope.Name()))
+			}
+		}
+		if primaries != 1 {
+			errs = append(errs, errors.Errorf("machine %s has %d primary network interfaces, exactly one network interface must be primary", machineScope.Name(), primaries))
+		}
+	}
+
+	nameSuffixes := make(map[string]bool)
+	luns := make(map[int32]bool)
+	for _, disk := range machineScope.AzureMachine.Spec.DataDisks {
+		if nameSuffixes[disk.NameSuffix] {
+			errs = append(errs, errors.Errorf("data disk name suffix %s of machine %s is not unique", disk.NameSuffix, machineScope.Name()))
+		}
+		nameSuffixes[disk.NameSuffix] = true
+		if luns[disk.Lun] {
+			errs = append(errs, errors.Errorf("data disk LUN %d of machine %s is not unique", disk.Lun, machineScope.Name()))
+		}
+		luns[disk.Lun] = true
+	}
+	return errs
+}
+
 // validateUpdate checks that no immutable fields have been updated and
 // returns a slice of errors representing attempts to change immutable state.
 func (r *AzureMachineReconciler) validateUpdate(spec *infrav1.AzureMachineSpec, i *infrav1.VM) (errs []error) {
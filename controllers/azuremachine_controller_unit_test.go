@@ -17,13 +17,23 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/klog/klogr"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/mocks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/resourceskus"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachines"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -109,3 +119,226 @@ func TestAzureMachineReconciler_AzureClusterToAzureMachines(t *testing.T) {
 		t.Fatalf("Expected 2 but found %d requests", len(initObjects))
 	}
 }
+
+func TestAzureMachineReconciler_ValidateSpec(t *testing.T) {
+	cases := []struct {
+		name          string
+		controlPlane  bool
+		spotVMOptions *infrav1.SpotVMOptions
+		dataDisks     []infrav1.DataDisk
+		nics          []infrav1.NetworkInterface
+		osDisk        infrav1.OSDisk
+		image         *infrav1.Image
+		expectedError string
+	}{
+		{
+			name:          "spot node machine",
+			spotVMOptions: &infrav1.SpotVMOptions{},
+		},
+		{
+			name:         "regular control plane machine",
+			controlPlane: true,
+		},
+		{
+			name:          "spot control plane machine",
+			controlPlane:  true,
+			spotVMOptions: &infrav1.SpotVMOptions{},
+			expectedError: "control plane machine my-machine cannot be a spot virtual machine",
+		},
+		{
+			name: "data disks with unique LUNs",
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "etcd", DiskSizeGB: 256, Lun: 0},
+				{NameSuffix: "data", DiskSizeGB: 1024, Lun: 1},
+			},
+		},
+		{
+			name: "data disks with the same LUN",
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "etcd", DiskSizeGB: 256, Lun: 0},
+				{NameSuffix: "data", DiskSizeGB: 1024, Lun: 0},
+			},
+			expectedError: "data disk LUN 0 of machine my-machine is not unique",
+		},
+		{
+			name: "data disks with the same name suffix",
+			dataDisks: []infrav1.DataDisk{
+				{NameSuffix: "data", DiskSizeGB: 256, Lun: 0},
+				{NameSuffix: "data", DiskSizeGB: 1024, Lun: 1},
+			},
+			expectedError: "data disk name suffix data of machine my-machine is not unique",
+		},
+		{
+			name: "two network interfaces",
+			nics: []infrav1.NetworkInterface{
+				{Primary: true},
+				{SubnetName: "my-appliance-subnet"},
+			},
+		},
+		{
+			name: "no primary network interface",
+			nics: []infrav1.NetworkInterface{
+				{SubnetName: "my-node-subnet"},
+				{SubnetName: "my-appliance-subnet"},
+			},
+			expectedError: "machine my-machine has 0 primary network interfaces, exactly one network interface must be primary",
+		},
+		{
+			name: "two primary network interfaces",
+			nics: []infrav1.NetworkInterface{
+				{Primary: true},
+				{SubnetName: "my-appliance-subnet", Primary: true},
+			},
+			expectedError: "machine my-machine has 2 primary network interfaces, exactly one network interface must be primary",
+		},
+		{
+			name: "secondary network interface without a subnet",
+			nics: []infrav1.NetworkInterface{
+				{Primary: true},
+				{},
+			},
+			expectedError: "secondary network interface 1 of machine my-machine requires a subnet",
+		},
+		{
+			name:   "OS disk larger than the default image",
+			osDisk: infrav1.OSDisk{DiskSizeGB: 128},
+		},
+		{
+			name:          "OS disk smaller than the default image",
+			osDisk:        infrav1.OSDisk{DiskSizeGB: 16},
+			expectedError: "OS disk of machine my-machine is 16 GB, the default image needs at least 30 GB",
+		},
+		{
+			name:   "OS disk of a custom image",
+			osDisk: infrav1.OSDisk{DiskSizeGB: 16},
+			image:  &infrav1.Image{ID: to.StringPtr("my-image")},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			machine := newMachine("my-cluster", "my-machine")
+			if c.controlPlane {
+				machine.Labels[clusterv1.MachineControlPlaneLabelName] = "true"
+			}
+			machineScope := &scope.MachineScope{
+				Machine: machine,
+				AzureMachine: &infrav1.AzureMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "my-machine"},
+					Spec: infrav1.AzureMachineSpec{
+						SpotVMOptions:     c.spotVMOptions,
+						DataDisks:         c.dataDisks,
+						NetworkInterfaces: c.nics,
+						OSDisk:            c.osDisk,
+						Image:             c.image,
+					},
+				},
+			}
+
+			errs := (&AzureMachineReconciler{}).validateSpec(machineScope)
+			if c.expectedError == "" {
+				if len(errs) > 0 {
+					t.Fatalf("got unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Error() != c.expectedError {
+				t.Fatalf("expected error %q, got %v", c.expectedError, errs)
+			}
+		})
+	}
+}
+
+func TestAzureMachineReconciler_GetOrCreateConditions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	resourceSkusMock := mocks.NewMockGetterService(mockCtrl)
+	resourceSkusMock.EXPECT().Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).
+		Return(compute.ResourceSku{}, errors.New("VM size Standard_D2s_v3 is not available in location eastus"))
+	clusterVMsMock := mocks.NewMockListerService(mockCtrl)
+	clusterVMsMock.EXPECT().List(gomock.Any()).Return([]virtualmachines.ClusterVM{}, nil)
+
+	machineScope := &scope.MachineScope{
+		AzureCluster: &infrav1.AzureCluster{Spec: infrav1.AzureClusterSpec{Location: "eastus"}},
+		AzureMachine: &infrav1.AzureMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-machine"},
+			Spec:       infrav1.AzureMachineSpec{VMSize: "Standard_D2s_v3"},
+		},
+	}
+	ams := &azureMachineService{
+		machineScope:    machineScope,
+		clusterScope:    &scope.ClusterScope{Logger: klogr.New(), Context: context.TODO()},
+		resourceSkusSvc: resourceSkusMock,
+		clusterVMsSvc:   clusterVMsMock,
+	}
+
+	if _, err := (&AzureMachineReconciler{}).getOrCreate(machineScope, ams); err == nil {
+		t.Fatal("expected an error")
+	}
+	condition := machineScope.AzureMachine.Status.Conditions.Get(infrav1.VMProvisionedCondition)
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != infrav1.VMProvisioningFailedReason {
+		t.Fatalf("expected a False %s condition with the reason %s, got %+v", infrav1.VMProvisionedCondition, infrav1.VMProvisioningFailedReason, condition)
+	}
+	expectedMessage := "invalid VM size of machine my-machine: failed to get resource sku for VM size Standard_D2s_v3: VM size Standard_D2s_v3 is not available in location eastus"
+	if condition.Message != expectedMessage {
+		t.Errorf("expected message %q, got %q", expectedMessage, condition.Message)
+	}
+}
+
+func TestAzureMachineService_CreateVirtualMachinePollsCreation(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	future := &infrav1.Future{Type: infrav1.PutFuture, ResourceGroup: "my-rg", Name: "my-machine", Data: "{}"}
+	vmSpec := &virtualmachines.Spec{Name: "my-machine"}
+
+	// Azure returns the VM being created, which must not prevent polling its creation.
+	virtualMachinesMock := mocks.NewMockGetterService(mockCtrl)
+	virtualMachinesMock.EXPECT().Get(gomock.Any(), vmSpec).Return(&infrav1.VM{State: infrav1.VMStateCreating}, nil)
+	virtualMachinesMock.EXPECT().Reconcile(gomock.Any(), vmSpec).Return(azure.NewOperationNotDoneError(future))
+
+	machineScope := &scope.MachineScope{
+		AzureMachine: &infrav1.AzureMachine{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-machine"},
+			Status:     infrav1.AzureMachineStatus{LongRunningOperationState: future},
+		},
+	}
+	ams := &azureMachineService{
+		machineScope:       machineScope,
+		clusterScope:       &scope.ClusterScope{Logger: klogr.New(), Context: context.TODO()},
+		virtualMachinesSvc: virtualMachinesMock,
+	}
+
+	if _, err := ams.createVirtualMachine("my-machine-nic", nil); !azure.IsOperationNotDoneError(err) {
+		t.Fatalf("expected the creation of the VM to be in progress, got %v", err)
+	}
+}
+
+func TestSetVMConditions(t *testing.T) {
+	cases := []struct {
+		state               infrav1.VMState
+		powerState          infrav1.VMPowerState
+		expectedProvisioned v1.ConditionStatus
+		expectedReason      string
+		expectedRunning     v1.ConditionStatus
+	}{
+		{state: infrav1.VMStateSucceeded, powerState: infrav1.VMPowerStateRunning, expectedProvisioned: v1.ConditionTrue, expectedRunning: v1.ConditionTrue},
+		{state: infrav1.VMStateSucceeded, expectedProvisioned: v1.ConditionTrue, expectedRunning: v1.ConditionTrue},
+		{state: infrav1.VMStateSucceeded, powerState: infrav1.VMPowerStateDeallocated, expectedProvisioned: v1.ConditionTrue, expectedRunning: v1.ConditionFalse},
+		{state: infrav1.VMStateSucceeded, powerState: infrav1.VMPowerStateStopped, expectedProvisioned: v1.ConditionTrue, expectedRunning: v1.ConditionFalse},
+		{state: infrav1.VMStateCreating, powerState: infrav1.VMPowerStateStarting, expectedProvisioned: v1.ConditionFalse, expectedReason: infrav1.VMProvisioningReason, expectedRunning: v1.ConditionFalse},
+		{state: infrav1.VMStateFailed, expectedProvisioned: v1.ConditionFalse, expectedReason: infrav1.VMProvisioningFailedReason, expectedRunning: v1.ConditionFalse},
+	}
+	for _, c := range cases {
+		t.Run(string(c.state)+"/"+string(c.powerState), func(t *testing.T) {
+			machineScope := &scope.MachineScope{AzureMachine: &infrav1.AzureMachine{}}
+			setVMConditions(machineScope, c.state, c.powerState)
+			conditions := machineScope.AzureMachine.Status.Conditions
+			provisioned, running := conditions.Get(infrav1.VMProvisionedCondition), conditions.Get(infrav1.VMRunningCondition)
+			if provisioned == nil || provisioned.Status != c.expectedProvisioned || provisioned.Reason != c.expectedReason {
+				t.Errorf("expected a %s %s condition with the reason %q, got %+v", c.expectedProvisioned, infrav1.VMProvisionedCondition, c.expectedReason, provisioned)
+			}
+			if running == nil || running.Status != c.expectedRunning {
+				t.Errorf("expected a %s %s condition, got %+v", c.expectedRunning, infrav1.VMRunningCondition, running)
+			}
+		})
+	}
+}
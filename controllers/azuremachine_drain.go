@@ -0,0 +1,136 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/cluster-api/controllers/remote"
+)
+
+const (
+	// defaultNodeDrainTimeout is how long the deletion of a VM waits for the node of its machine to be drained, unless
+	// the AzureMachine sets its own timeout.
+	defaultNodeDrainTimeout = 10 * time.Minute
+	// nodeDrainPollInterval is the delay before a reconcile checks again whether the node of a deleted machine is
+	// drained.
+	nodeDrainPollInterval = 20 * time.Second
+	// mirrorPodAnnotation marks the mirror pods of the static pods of a node, which are not evicted by a drain.
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+)
+
+// nodeDrainDelay returns how long the deletion of the VM of a deleted AzureMachine still waits for the node of the
+// machine to be drained, or 0 once the VM can be deleted. Cluster API drains the node when the Machine is deleted, so
+// the VM is deleted as soon as the node is cordoned and only has pods of daemon sets, mirror pods and completed pods
+// left, or no longer exists, and at the latest once the node drain timeout has elapsed. A machine without a node, or
+// whose node is excluded from draining, doesn't wait.
+func (r *AzureMachineReconciler) nodeDrainDelay(machineScope *scope.MachineScope, now time.Time) (time.Duration, error) {
+	nodeRef := machineScope.Machine.Status.NodeRef
+	if nodeRef == nil {
+		return 0, nil
+	}
+	if _, excluded := machineScope.Machine.Annotations[clusterv1.ExcludeNodeDrainingAnnotation]; excluded {
+		return 0, nil
+	}
+
+	timeout := defaultNodeDrainTimeout
+	if machineScope.AzureMachine.Spec.NodeDrainTimeout != nil {
+		timeout = machineScope.AzureMachine.Spec.NodeDrainTimeout.Duration
+	}
+	remaining := timeout - now.Sub(machineScope.AzureMachine.DeletionTimestamp.Time)
+	if remaining <= 0 {
+		if timeout > 0 {
+			machineScope.Info("Node drain timed out, deleting the VM", "node", nodeRef.Name, "timeout", timeout)
+		}
+		return 0, nil
+	}
+
+	coreV1, err := r.workloadClusterCoreV1(machineScope.Cluster)
+	if err != nil {
+		// the workload cluster may already be gone, Cluster API doesn't drain the node either then.
+		machineScope.Info("Cannot reach the workload cluster, deleting the VM without waiting for the node to be drained",
+			"node", nodeRef.Name, "error", err.Error())
+		return 0, nil
+	}
+	drained, err := nodeDrained(coreV1, nodeRef.Name)
+	if err != nil {
+		return 0, err
+	}
+	if drained {
+		return 0, nil
+	}
+	if remaining > nodeDrainPollInterval {
+		return nodeDrainPollInterval, nil
+	}
+	return remaining, nil
+}
+
+// nodeDrained returns true if the node is cordoned and only has pods which are not evicted by a drain left, or if the
+// node no longer exists.
+func nodeDrained(coreV1 typedcorev1.CoreV1Interface, nodeName string) (bool, error) {
+	node, err := coreV1.Nodes().Get(nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get node %s", nodeName)
+	}
+	if !node.Spec.Unschedulable {
+		return false, nil
+	}
+
+	pods, err := coreV1.Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list the pods of node %s", nodeName)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != nodeName || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, mirror := pod.Annotations[mirrorPodAnnotation]; mirror {
+			continue
+		}
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+			continue
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// workloadClusterCoreV1 returns a client of the core API of the workload cluster, built from its kubeconfig secret.
+func (r *AzureMachineReconciler) workloadClusterCoreV1(cluster *clusterv1.Cluster) (typedcorev1.CoreV1Interface, error) {
+	if r.newWorkloadClusterClient != nil {
+		return r.newWorkloadClusterClient(cluster)
+	}
+	c, err := remote.NewClusterClient(r.Client, cluster)
+	if err != nil {
+		return nil, err
+	}
+	return c.CoreV1()
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/klogr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+)
+
+func TestNodeDrainDelay(t *testing.T) {
+	deleted := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	node := func(unschedulable bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-node"},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		}
+	}
+	pod := func(name string, mutate func(*corev1.Pod)) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "my-node"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if mutate != nil {
+			mutate(p)
+		}
+		return p
+	}
+	daemonSetPod := pod("my-daemonset-pod", func(p *corev1.Pod) {
+		p.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "my-daemonset", Controller: to.BoolPtr(true)}}
+	})
+	mirrorPod := pod("my-static-pod", func(p *corev1.Pod) {
+		p.Annotations = map[string]string{"kubernetes.io/config.mirror": "hash"}
+	})
+
+	testcases := []struct {
+		name             string
+		noNodeRef        bool
+		excluded         bool
+		nodeDrainTimeout *metav1.Duration
+		sinceDeletion    time.Duration
+		objects          []runtime.Object
+		clusterClientErr error
+		expectedDelay    time.Duration
+	}{
+		{
+			name:          "machine without a node is deleted right away",
+			noNodeRef:     true,
+			expectedDelay: 0,
+		},
+		{
+			name:          "node excluded from draining is deleted right away",
+			excluded:      true,
+			objects:       []runtime.Object{node(false)},
+			expectedDelay: 0,
+		},
+		{
+			name:          "deletion is deferred until the node is cordoned",
+			sinceDeletion: time.Minute,
+			objects:       []runtime.Object{node(false)},
+			expectedDelay: nodeDrainPollInterval,
+		},
+		{
+			name:          "deletion is deferred while pods are being evicted",
+			sinceDeletion: time.Minute,
+			objects:       []runtime.Object{node(true), pod("my-pod", nil), daemonSetPod},
+			expectedDelay: nodeDrainPollInterval,
+		},
+		{
+			name:          "drained node only has daemon set, mirror and completed pods",
+			sinceDeletion: time.Minute,
+			objects: []runtime.Object{node(true), daemonSetPod, mirrorPod,
+				pod("my-job-pod", func(p *corev1.Pod) { p.Status.Phase = corev1.PodSucceeded })},
+			expectedDelay: 0,
+		},
+		{
+			name:          "pods of other nodes are ignored",
+			sinceDeletion: time.Minute,
+			objects: []runtime.Object{node(true),
+				pod("my-other-pod", func(p *corev1.Pod) { p.Spec.NodeName = "my-other-node" })},
+			expectedDelay: 0,
+		},
+		{
+			name:          "deleted node is drained",
+			sinceDeletion: time.Minute,
+			expectedDelay: 0,
+		},
+		{
+			name:          "deletion waits no longer than the remaining timeout",
+			sinceDeletion: defaultNodeDrainTimeout - 5*time.Second,
+			objects:       []runtime.Object{node(false)},
+			expectedDelay: 5 * time.Second,
+		},
+		{
+			name:          "timeout elapsed",
+			sinceDeletion: defaultNodeDrainTimeout,
+			objects:       []runtime.Object{node(false)},
+			expectedDelay: 0,
+		},
+		{
+			name:             "custom timeout elapsed",
+			nodeDrainTimeout: &metav1.Duration{Duration: time.Minute},
+			sinceDeletion:    2 * time.Minute,
+			objects:          []runtime.Object{node(false)},
+			expectedDelay:    0,
+		},
+		{
+			name:             "zero timeout doesn't wait",
+			nodeDrainTimeout: &metav1.Duration{},
+			objects:          []runtime.Object{node(false)},
+			expectedDelay:    0,
+		},
+		{
+			name:             "unreachable workload cluster doesn't wait",
+			sinceDeletion:    time.Minute,
+			clusterClientErr: errors.New("kubeconfig secret not found"),
+			expectedDelay:    0,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "my-machine"}}
+			if !tc.noNodeRef {
+				machine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "my-node"}
+			}
+			if tc.excluded {
+				machine.Annotations = map[string]string{clusterv1.ExcludeNodeDrainingAnnotation: ""}
+			}
+			machineScope := &scope.MachineScope{
+				Logger:  klogr.New(),
+				Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"}},
+				Machine: machine,
+				AzureMachine: &infrav1.AzureMachine{
+					ObjectMeta: metav1.ObjectMeta{Name: "my-machine", DeletionTimestamp: &metav1.Time{Time: deleted}},
+					Spec:       infrav1.AzureMachineSpec{NodeDrainTimeout: tc.nodeDrainTimeout},
+				},
+			}
+			r := &AzureMachineReconciler{
+				newWorkloadClusterClient: func(*clusterv1.Cluster) (typedcorev1.CoreV1Interface, error) {
+					if tc.clusterClientErr != nil {
+						return nil, tc.clusterClientErr
+					}
+					return fake.NewSimpleClientset(tc.objects...).CoreV1(), nil
+				},
+			}
+
+			delay, err := r.nodeDrainDelay(machineScope, deleted.Add(tc.sinceDeletion))
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if delay != tc.expectedDelay {
+				t.Errorf("expected a delay of %s, got %s", tc.expectedDelay, delay)
+			}
+		})
+	}
+}
+
+func TestReconcileDeleteDefersUntilNodeDrained(t *testing.T) {
+	machineScope := &scope.MachineScope{
+		Logger:  klogr.New(),
+		Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"}},
+		Machine: &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-machine"},
+			Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Kind: "Node", Name: "my-node"}},
+		},
+		AzureMachine: &infrav1.AzureMachine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "my-machine",
+				DeletionTimestamp: &metav1.Time{Time: time.Now()},
+				Finalizers:        []string{infrav1.MachineFinalizer},
+			},
+		},
+	}
+	r := &AzureMachineReconciler{
+		newWorkloadClusterClient: func(*clusterv1.Cluster) (typedcorev1.CoreV1Interface, error) {
+			return fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "my-node"}}).CoreV1(), nil
+		},
+	}
+
+	// the VM is not deleted while the node is not drained, the cluster scope is only needed to delete it.
+	result, err := r.reconcileDelete(machineScope, nil)
+	if err != nil {
+		t.Fatalf("got an unexpected error: %v", err)
+	}
+	if result.RequeueAfter != nodeDrainPollInterval {
+		t.Errorf("expected a requeue after %s, got %+v", nodeDrainPollInterval, result)
+	}
+	if len(machineScope.AzureMachine.Finalizers) != 1 {
+		t.Errorf("expected the finalizer to be kept, got %v", machineScope.AzureMachine.Finalizers)
+	}
+}
@@ -18,21 +18,30 @@ package controllers
 
 import (
 	"encoding/base64"
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/marketplaceordering/mgmt/2015-06-01/marketplaceordering"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	resourcefeatures "github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2015-12-01/features"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/pkg/errors"
 	"k8s.io/klog"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilitysets"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilityzones"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/disks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/features"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/inboundnatrules"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/marketplaceagreements"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/networkinterfaces"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/proximityplacementgroups"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/resourceskus"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachineextensions"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachines"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
@@ -42,44 +51,75 @@ import (
 const (
 	// DefaultBootstrapTokenTTL default ttl for bootstrap token
 	DefaultBootstrapTokenTTL = 10 * time.Minute
+
+	// encryptionAtHostFeatureNamespace and encryptionAtHostFeatureName identify the preview feature that has to be
+	// registered in the subscription for encryption at host.
+	encryptionAtHostFeatureNamespace = "Microsoft.Compute"
+	encryptionAtHostFeatureName      = "EncryptionAtHost"
 )
 
 // azureMachineService are list of services required by cluster actuator, easy to create a fake
 // TODO: We should decide if we want to keep this
 type azureMachineService struct {
-	machineScope          *scope.MachineScope
-	clusterScope          *scope.ClusterScope
-	availabilityZonesSvc  azure.GetterService
-	networkInterfacesSvc  azure.Service
-	publicIPSvc           azure.GetterService
-	virtualMachinesSvc    azure.GetterService
-	virtualMachinesExtSvc azure.GetterService
-	disksSvc              azure.GetterService
+	machineScope             *scope.MachineScope
+	clusterScope             *scope.ClusterScope
+	availabilityZonesSvc     azure.GetterService
+	availabilitySetsSvc      azure.GetterService
+	marketplaceAgreementsSvc azure.GetterService
+	networkInterfacesSvc     azure.Service
+	ppgSvc                   azure.GetterService
+	publicIPSvc              azure.GetterService
+	resourceSkusSvc          azure.GetterService
+	virtualMachinesSvc       azure.GetterService
+	vmPowerSvc               azure.PowerService
+	clusterVMsSvc            azure.ListerService
+	virtualMachinesExtSvc    azure.GetterService
+	disksSvc                 azure.GetterService
+	inboundNatRulesSvc       azure.GetterService
+	featuresSvc              azure.GetterService
 }
 
 // newAzureMachineService populates all the services based on input scope
 func newAzureMachineService(machineScope *scope.MachineScope, clusterScope *scope.ClusterScope) *azureMachineService {
+	virtualMachinesSvc := virtualmachines.NewService(clusterScope, machineScope)
 	return &azureMachineService{
-		machineScope:          machineScope,
-		clusterScope:          clusterScope,
-		availabilityZonesSvc:  availabilityzones.NewService(clusterScope),
-		networkInterfacesSvc:  networkinterfaces.NewService(clusterScope),
-		publicIPSvc:           publicips.NewService(clusterScope),
-		virtualMachinesSvc:    virtualmachines.NewService(clusterScope, machineScope),
-		virtualMachinesExtSvc: virtualmachineextensions.NewService(clusterScope),
-		disksSvc:              disks.NewService(clusterScope),
+		machineScope:             machineScope,
+		clusterScope:             clusterScope,
+		availabilityZonesSvc:     availabilityzones.NewService(clusterScope),
+		availabilitySetsSvc:      availabilitysets.NewService(clusterScope),
+		marketplaceAgreementsSvc: marketplaceagreements.NewService(clusterScope),
+		networkInterfacesSvc:     networkinterfaces.NewService(clusterScope),
+		ppgSvc:                   proximityplacementgroups.NewService(clusterScope),
+		publicIPSvc:              publicips.NewService(clusterScope),
+		resourceSkusSvc:          resourceskus.NewService(clusterScope),
+		virtualMachinesSvc:       virtualMachinesSvc,
+		vmPowerSvc:               virtualMachinesSvc,
+		clusterVMsSvc:            virtualMachinesSvc,
+		virtualMachinesExtSvc:    virtualmachineextensions.NewService(clusterScope),
+		disksSvc:                 disks.NewService(clusterScope),
+		inboundNatRulesSvc:       inboundnatrules.NewService(clusterScope),
+		featuresSvc:              features.NewService(clusterScope),
 	}
 }
 
 // Create creates machine if and only if machine exists, handled by cluster-api
 func (s *azureMachineService) Create() (*infrav1.VM, error) {
+	if err := s.validateVMSize(); err != nil {
+		return nil, errors.Wrapf(err, "invalid VM size of machine %s", s.machineScope.Name())
+	}
+
 	nicName := azure.GenerateNICName(s.machineScope.Name())
 	nicErr := s.reconcileNetworkInterface(nicName)
 	if nicErr != nil {
 		return nil, errors.Wrapf(nicErr, "failed to create nic %s for machine %s", nicName, s.machineScope.Name())
 	}
 
-	vm, vmErr := s.createVirtualMachine(nicName)
+	secondaryNICNames, nicErr := s.reconcileSecondaryNetworkInterfaces()
+	if nicErr != nil {
+		return nil, errors.Wrapf(nicErr, "failed to create secondary nics for machine %s", s.machineScope.Name())
+	}
+
+	vm, vmErr := s.createVirtualMachine(nicName, secondaryNICNames)
 	if vmErr != nil {
 		return nil, errors.Wrapf(vmErr, "failed to create vm %s ", s.machineScope.Name())
 	}
@@ -87,51 +127,99 @@ func (s *azureMachineService) Create() (*infrav1.VM, error) {
 	return vm, nil
 }
 
-// Delete reconciles all the services in pre determined order
+// Delete deletes the resources of the machine in dependency order: the VM first, then the network interfaces it
+// used and the inbound NAT rule of the primary network interface, then the public IP and the disks. A resource that
+// is already gone does not abort the cleanup of the others.
 func (s *azureMachineService) Delete() error {
 	vmSpec := &virtualmachines.Spec{
 		Name: s.machineScope.Name(),
 	}
-
-	err := s.virtualMachinesSvc.Delete(s.clusterScope.Context, vmSpec)
-	if err != nil {
+	if err := s.deleteResource(s.virtualMachinesSvc, vmSpec); err != nil {
 		return errors.Wrapf(err, "failed to delete machine")
 	}
 
 	networkInterfaceSpec := &networkinterfaces.Spec{
 		Name:     azure.GenerateNICName(s.machineScope.Name()),
-		VnetName: azure.GenerateVnetName(s.clusterScope.Name()),
+		VnetName: s.clusterScope.VnetName(),
 	}
-
-	err = s.networkInterfacesSvc.Delete(s.clusterScope.Context, networkInterfaceSpec)
-	if err != nil {
+	if err := s.deleteResource(s.networkInterfacesSvc, networkInterfaceSpec); err != nil {
 		return errors.Wrapf(err, "Unable to delete network interface")
 	}
 
+	if s.machineScope.IsControlPlane() && !s.clusterScope.IsAPIServerInternal() {
+		natRuleSpec := &inboundnatrules.Spec{
+			Name:             s.machineScope.Name(),
+			LoadBalancerName: s.clusterScope.PublicLBName(),
+		}
+		if err := s.deleteResource(s.inboundNatRulesSvc, natRuleSpec); err != nil {
+			return errors.Wrapf(err, "Unable to delete inbound NAT rule %s", natRuleSpec.Name)
+		}
+	}
+
+	for i, nic := range s.machineScope.AzureMachine.Spec.NetworkInterfaces {
+		if nic.Primary {
+			continue
+		}
+		secondaryNICSpec := &networkinterfaces.Spec{
+			Name:     azure.GenerateSecondaryNICName(s.machineScope.Name(), i),
+			VnetName: s.clusterScope.VnetName(),
+		}
+		if err := s.deleteResource(s.networkInterfacesSvc, secondaryNICSpec); err != nil {
+			return errors.Wrapf(err, "Unable to delete secondary network interface %s", secondaryNICSpec.Name)
+		}
+	}
+
 	publicIPSpec := &publicips.Spec{
 		Name: azure.GenerateNICName(s.machineScope.Name()) + "-public-ip",
 	}
-
-	err = s.publicIPSvc.Delete(s.clusterScope.Context, publicIPSpec)
-	if err != nil {
+	if err := s.deleteResource(s.publicIPSvc, publicIPSpec); err != nil {
 		return errors.Wrap(err, "unable to delete publicIP")
 	}
 
 	OSDiskSpec := &disks.Spec{
 		Name: azure.GenerateOSDiskName(s.machineScope.Name()),
 	}
-	err = s.disksSvc.Delete(s.clusterScope.Context, OSDiskSpec)
-	if err != nil {
+	if err := s.deleteResource(s.disksSvc, OSDiskSpec); err != nil {
 		return errors.Wrapf(err, "Failed to delete OS disk of machine %s", s.machineScope.Name())
 	}
 
+	for _, disk := range s.machineScope.AzureMachine.Spec.DataDisks {
+		// Existing disks attached to the machine outlive it.
+		if disk.ManagedDiskID != "" {
+			continue
+		}
+		dataDiskSpec := &disks.Spec{
+			Name: azure.GenerateDataDiskName(s.machineScope.Name(), disk.NameSuffix),
+		}
+		if err := s.deleteResource(s.disksSvc, dataDiskSpec); err != nil {
+			return errors.Wrapf(err, "Failed to delete data disk %s of machine %s", disk.NameSuffix, s.machineScope.Name())
+		}
+	}
+
 	return nil
 }
 
+// deleteResource deletes a resource of the machine with a service, ignoring that the resource is not found.
+func (s *azureMachineService) deleteResource(svc azure.Service, spec interface{}) error {
+	err := svc.Delete(s.clusterScope.Context, spec)
+	if err != nil && azure.ResourceNotFound(err) {
+		klog.V(2).Infof("resource of machine %s already deleted: %v", s.machineScope.Name(), err)
+		return nil
+	}
+	return err
+}
+
 func (s *azureMachineService) VMIfExists(id *string) (*infrav1.VM, error) {
 	if id == nil {
-		s.clusterScope.Info("VM does not have an id")
-		return nil, nil
+		stray, err := s.hasStrayVM()
+		if err != nil {
+			return nil, err
+		}
+		if !stray {
+			s.clusterScope.Info("VM does not have an id")
+			return nil, nil
+		}
+		s.clusterScope.Info("Adopting the VM of the machine, which does not have an id", "machine", s.machineScope.Name())
 	}
 
 	vmSpec := &virtualmachines.Spec{
@@ -157,12 +245,47 @@ func (s *azureMachineService) VMIfExists(id *string) (*infrav1.VM, error) {
 	return vm, nil
 }
 
-// getVirtualMachineZone gets a random availability zones from available set,
-// this will hopefully be an input from upstream machinesets so all the vms are balanced
+// hasStrayVM returns true if the cluster owns a VM named after the machine although the machine does not have its id,
+// for example because the id was not persisted after the VM was created. Such a VM is adopted by the machine rather
+// than created again. The VMs are not listed while the creation of the VM of the machine is in progress.
+func (s *azureMachineService) hasStrayVM() (bool, error) {
+	if s.machineScope.GetLongRunningOperationState() != nil {
+		return false, nil
+	}
+	list, err := s.clusterVMsSvc.List(s.clusterScope.Context)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list the VMs of the cluster")
+	}
+	vms, ok := list.([]virtualmachines.ClusterVM)
+	if !ok {
+		return false, errors.New("returned incorrect vm list interface")
+	}
+	for _, vm := range vms {
+		if vm.Name == s.machineScope.Name() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getVirtualMachineZone returns the availability zone to create the virtual machine in.
+// A machine with a failure domain is placed in that zone, which has to be available for its VM size in the
+// cluster location. Other machines use the first available zone, if any.
 func (s *azureMachineService) getVirtualMachineZone() (string, error) {
 	vmName := s.machineScope.AzureMachine.Name
 	vmSize := s.machineScope.AzureMachine.Spec.VMSize
-	location := s.machineScope.AzureMachine.Spec.Location
+	location := s.machineScope.Location()
+	failureDomain := s.machineScope.FailureDomain()
+
+	if !s.isAvailabilityZoneSupported() {
+		if failureDomain != "" {
+			return "", azure.NewTerminalError(errors.Errorf("availability zone %s of machine %s is not supported, location %s has no availability zones", failureDomain, vmName, location))
+		}
+		return "", nil
+	}
+	if s.machineScope.AzureMachine.Spec.AvailabilityZone.Enabled != nil && !*s.machineScope.AzureMachine.Spec.AvailabilityZone.Enabled {
+		return "", nil
+	}
 
 	zonesSpec := &availabilityzones.Spec{
 		VMSize: vmSize,
@@ -171,45 +294,92 @@ func (s *azureMachineService) getVirtualMachineZone() (string, error) {
 	if err != nil {
 		return "", errors.Wrapf(err, "failed to check availability zones for %s in region %s", vmSize, location)
 	}
-	if zonesInterface == nil {
-		// if its nil, probably means no zones found
-		return "", nil
+	var zones []string
+	if zonesInterface != nil {
+		var ok bool
+		zones, ok = zonesInterface.([]string)
+		if !ok {
+			return "", errors.New("availability zones Get returned invalid interface")
+		}
 	}
-	zones, ok := zonesInterface.([]string)
-	if !ok {
-		return "", errors.New("availability zones Get returned invalid interface")
+
+	if failureDomain == "" {
+		if len(zones) == 0 {
+			return "", nil
+		}
+		klog.Infof("Selecting first available AZ as no availability zone was set for VM size %s in location %s", vmSize, location)
+		klog.Infof("Selected availability zone %s for %s", zones[0], vmName)
+		return zones[0], nil
 	}
 
-	if len(zones) <= 0 {
+	for _, zone := range zones {
+		if zone == failureDomain {
+			klog.Infof("Selected availability zone %s for %s", zone, vmName)
+			return zone, nil
+		}
+	}
+	return "", azure.NewTerminalError(errors.Errorf("availability zone %s of machine %s is not supported for VM size %s in location %s, supported zones are %v", failureDomain, vmName, vmSize, location, zones))
+}
+
+// getAvailabilitySetID returns the ID of the availability set of a control plane virtual machine that is not placed
+// in an availability zone, creating the set in the proximity placement group of the virtual machine if needed. Other
+// virtual machines are not placed in an availability set.
+func (s *azureMachineService) getAvailabilitySetID(zone, ppgID string) (string, error) {
+	if zone != "" || !s.machineScope.IsControlPlane() {
 		return "", nil
 	}
 
-	var zone string
-	var selectedZone string
-	if s.machineScope.AzureMachine.Spec.AvailabilityZone.ID != nil {
-		zone = *s.machineScope.AzureMachine.Spec.AvailabilityZone.ID
+	asSpec := &availabilitysets.Spec{
+		Name:                      s.clusterScope.ControlPlaneAvailabilitySetName(),
+		Role:                      infrav1.ControlPlane,
+		ProximityPlacementGroupID: ppgID,
+	}
+	if err := s.availabilitySetsSvc.Reconcile(s.clusterScope.Context, asSpec); err != nil {
+		return "", err
+	}
+	asInterface, err := s.availabilitySetsSvc.Get(s.clusterScope.Context, asSpec)
+	if err != nil {
+		return "", err
 	}
+	availabilitySet, ok := asInterface.(compute.AvailabilitySet)
+	if !ok {
+		return "", errors.New("availability set Get returned invalid interface")
+	}
+	return to.String(availabilitySet.ID), nil
+}
 
-	if zone != "" {
-		for _, allowedZone := range zones {
-			if allowedZone == zone {
-				selectedZone = zone
-				break
-			}
+// getProximityPlacementGroupID returns the ID of the proximity placement group of the cluster for the virtual machines
+// placed in it, and an empty ID for the others. The cluster reconciler creates the proximity placement group.
+func (s *azureMachineService) getProximityPlacementGroupID() (string, error) {
+	switch s.clusterScope.ProximityPlacementGroupScope() {
+	case infrav1.ProximityPlacementGroupAll:
+	case infrav1.ProximityPlacementGroupControlPlane:
+		if !s.machineScope.IsControlPlane() {
+			return "", nil
 		}
-	} else {
-		klog.Infof("Selecting first available AZ as no availability zone was set or user-provided availability zone is not supported for VM size %s in location %s", vmSize, location)
-		selectedZone = zones[0]
+	default:
+		return "", nil
 	}
 
-	klog.Infof("Selected availability zone %s for %s", selectedZone, vmName)
-
-	return selectedZone, nil
+	ppgSpec := &proximityplacementgroups.Spec{
+		Name: s.clusterScope.ProximityPlacementGroupName(),
+	}
+	ppgInterface, err := s.ppgSvc.Get(s.clusterScope.Context, ppgSpec)
+	if err != nil {
+		return "", err
+	}
+	ppg, ok := ppgInterface.(compute.ProximityPlacementGroup)
+	if !ok {
+		return "", errors.New("proximity placement group Get returned invalid interface")
+	}
+	return to.String(ppg.ID), nil
 }
 
 func (s *azureMachineService) reconcilePublicIP(publicIPName string) error {
 	publicIPSpec := &publicips.Spec{
-		Name: publicIPName,
+		Name:    publicIPName,
+		DNSName: strings.ToLower(publicIPName),
+		SKU:     network.PublicIPAddressSkuName(s.clusterScope.LoadBalancerSKU()),
 	}
 	err := s.publicIPSvc.Reconcile(s.clusterScope.Context, publicIPSpec)
 	if err != nil {
@@ -219,10 +389,214 @@ func (s *azureMachineService) reconcilePublicIP(publicIPName string) error {
 	return nil
 }
 
+// primaryNetworkInterface returns the primary network interface in the machine spec, or nil if the machine spec has no
+// network interfaces.
+func (s *azureMachineService) primaryNetworkInterface() *infrav1.NetworkInterface {
+	for i, nic := range s.machineScope.AzureMachine.Spec.NetworkInterfaces {
+		if nic.Primary {
+			return &s.machineScope.AzureMachine.Spec.NetworkInterfaces[i]
+		}
+	}
+	return nil
+}
+
+// getSubnetName returns the name of the subnet selected in the machine spec or for its primary network interface, or
+// the name of the default subnet for the machine role if none is selected.
+func (s *azureMachineService) getSubnetName(defaultSubnet *infrav1.SubnetSpec) (string, error) {
+	subnetName := s.machineScope.AzureMachine.Spec.SubnetName
+	if primaryNIC := s.primaryNetworkInterface(); subnetName == "" && primaryNIC != nil {
+		subnetName = primaryNIC.SubnetName
+	}
+	if subnetName == "" {
+		return defaultSubnet.Name, nil
+	}
+
+	subnet := s.clusterScope.Subnet(subnetName)
+	if subnet == nil {
+		// not terminal, the subnets of the cluster may not have been reconciled yet.
+		return "", errors.Errorf("subnet %s of machine %s does not exist in cluster %s", subnetName, s.machineScope.Name(), s.clusterScope.Name())
+	}
+	if string(subnet.Role) != s.machineScope.Role() {
+		return "", azure.NewTerminalError(errors.Errorf("subnet %s has role %s, machine %s has role %s", subnetName, subnet.Role, s.machineScope.Name(), s.machineScope.Role()))
+	}
+	return subnet.Name, nil
+}
+
+// getResourceSku returns the resource SKU of the VM size of the machine in the cluster location.
+func (s *azureMachineService) getResourceSku() (compute.ResourceSku, error) {
+	vmSize := s.machineScope.AzureMachine.Spec.VMSize
+	skuSpec := &resourceskus.Spec{
+		VMSize: vmSize,
+	}
+	skuInterface, err := s.resourceSkusSvc.Get(s.clusterScope.Context, skuSpec)
+	if err != nil {
+		return compute.ResourceSku{}, errors.Wrapf(err, "failed to get resource sku for VM size %s", vmSize)
+	}
+	sku, ok := skuInterface.(compute.ResourceSku)
+	if !ok {
+		return compute.ResourceSku{}, errors.New("resource sku Get returned invalid interface")
+	}
+	return sku, nil
+}
+
+// validateVMSize checks that the VM size of the machine is offered in the cluster location, and in the availability
+// zone of the machine if it has one, before any resource of the machine is created.
+func (s *azureMachineService) validateVMSize() error {
+	sku, err := s.getResourceSku()
+	if err != nil {
+		return err
+	}
+	zone := s.machineScope.FailureDomain()
+	if zone != "" && !resourceskus.HasZone(sku, s.machineScope.Location(), zone) {
+		return azure.NewTerminalError(errors.Errorf("VM size %s is not available in availability zone %s of location %s", s.machineScope.AzureMachine.Spec.VMSize, zone, s.machineScope.Location()))
+	}
+	return nil
+}
+
+// validateAcceleratedNetworking checks that the VM size of a machine with accelerated networking supports it in the
+// cluster location.
+func (s *azureMachineService) validateAcceleratedNetworking() error {
+	if !to.Bool(s.machineScope.AzureMachine.Spec.AcceleratedNetworking) {
+		return nil
+	}
+
+	vmSize := s.machineScope.AzureMachine.Spec.VMSize
+	sku, err := s.getResourceSku()
+	if err != nil {
+		return err
+	}
+	if !resourceskus.HasCapability(sku, resourceskus.AcceleratedNetworking) {
+		return azure.NewTerminalError(errors.Errorf("accelerated networking of machine %s is not supported for VM size %s in location %s", s.machineScope.Name(), vmSize, s.machineScope.Location()))
+	}
+	return nil
+}
+
+// validateEphemeralOSDisk checks that the VM size of a machine with an ephemeral OS disk has a cache large enough
+// for the disk.
+func (s *azureMachineService) validateEphemeralOSDisk() error {
+	osDisk := s.machineScope.AzureMachine.Spec.OSDisk
+	if osDisk.DiffDiskSettings == nil {
+		return nil
+	}
+
+	vmSize := s.machineScope.AzureMachine.Spec.VMSize
+	sku, err := s.getResourceSku()
+	if err != nil {
+		return err
+	}
+	value, _ := resourceskus.GetCapability(sku, resourceskus.CachedDiskBytes)
+	cachedDiskBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || cachedDiskBytes < int64(osDisk.DiskSizeGB)<<30 {
+		return azure.NewTerminalError(errors.Errorf("ephemeral OS disk of machine %s needs %d GB, VM size %s does not have a large enough cache", s.machineScope.Name(), osDisk.DiskSizeGB, vmSize))
+	}
+	return nil
+}
+
+// validateWriteAccelerator checks that the VM size of a machine with write accelerator data disks supports write
+// accelerator on that many disks.
+func (s *azureMachineService) validateWriteAccelerator() error {
+	var disks int64
+	for _, disk := range s.machineScope.AzureMachine.Spec.DataDisks {
+		if to.Bool(disk.WriteAcceleratorEnabled) {
+			disks++
+		}
+	}
+	if disks == 0 {
+		return nil
+	}
+
+	vmSize := s.machineScope.AzureMachine.Spec.VMSize
+	sku, err := s.getResourceSku()
+	if err != nil {
+		return err
+	}
+	value, _ := resourceskus.GetCapability(sku, resourceskus.MaxWriteAcceleratorDisksAllowed)
+	maxDisks, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxDisks < disks {
+		return azure.NewTerminalError(errors.Errorf("machine %s has %d data disks with write accelerator, VM size %s does not support write accelerator on that many disks", s.machineScope.Name(), disks, vmSize))
+	}
+	return nil
+}
+
+// validateEncryptionAtHost checks that the encryption at host feature is registered in the subscription of a machine
+// with encryption at host, and that its VM size supports it in the cluster location.
+func (s *azureMachineService) validateEncryptionAtHost() error {
+	if !to.Bool(s.machineScope.AzureMachine.Spec.EncryptionAtHost) {
+		return nil
+	}
+
+	featureSpec := &features.Spec{
+		Namespace: encryptionAtHostFeatureNamespace,
+		Name:      encryptionAtHostFeatureName,
+	}
+	featureInterface, err := s.featuresSvc.Get(s.clusterScope.Context, featureSpec)
+	if err != nil {
+		return err
+	}
+	feature, ok := featureInterface.(resourcefeatures.Result)
+	if !ok {
+		return errors.New("feature Get returned invalid interface")
+	}
+	if !features.IsRegistered(feature) {
+		return errors.Errorf("encryption at host of machine %s requires the feature %s/%s registered in subscription %s, register it with: az feature register --namespace %s --name %s",
+			s.machineScope.Name(), featureSpec.Namespace, featureSpec.Name, s.clusterScope.SubscriptionID, featureSpec.Namespace, featureSpec.Name)
+	}
+
+	vmSize := s.machineScope.AzureMachine.Spec.VMSize
+	sku, err := s.getResourceSku()
+	if err != nil {
+		return err
+	}
+	if !resourceskus.HasCapability(sku, resourceskus.EncryptionAtHost) {
+		return azure.NewTerminalError(errors.Errorf("encryption at host of machine %s is not supported for VM size %s in location %s", s.machineScope.Name(), vmSize, s.machineScope.Location()))
+	}
+	return nil
+}
+
+// validateImagePlanTerms checks that the marketplace terms of the purchase plan of a third-party image are accepted
+// in the subscription, Azure fails to create virtual machines using the image otherwise.
+func (s *azureMachineService) validateImagePlanTerms(image infrav1.Image) error {
+	if image.Plan == nil {
+		return nil
+	}
+
+	plan := image.Plan
+	agreementSpec := &marketplaceagreements.Spec{
+		Publisher: plan.Publisher,
+		Offer:     plan.Offer,
+		Plan:      plan.Name,
+	}
+	termsInterface, err := s.marketplaceAgreementsSvc.Get(s.clusterScope.Context, agreementSpec)
+	if err != nil {
+		return err
+	}
+	terms, ok := termsInterface.(marketplaceordering.AgreementTerms)
+	if !ok {
+		return errors.New("marketplace agreement Get returned invalid interface")
+	}
+	if terms.AgreementProperties == nil || !to.Bool(terms.Accepted) {
+		return errors.Errorf("marketplace terms of plan %s of offer %s by publisher %s are not accepted in subscription %s, accept them with: az vm image terms accept --publisher %s --offer %s --plan %s",
+			plan.Name, plan.Offer, plan.Publisher, s.clusterScope.SubscriptionID, plan.Publisher, plan.Offer, plan.Name)
+	}
+	return nil
+}
+
 func (s *azureMachineService) reconcileNetworkInterface(nicName string) error {
+	if err := s.validateAcceleratedNetworking(); err != nil {
+		return err
+	}
+
 	networkInterfaceSpec := &networkinterfaces.Spec{
-		Name:     nicName,
-		VnetName: s.clusterScope.Vnet().Name,
+		Name:                  nicName,
+		VnetName:              s.clusterScope.Vnet().Name,
+		AcceleratedNetworking: s.machineScope.AzureMachine.Spec.AcceleratedNetworking,
+	}
+	if primaryNIC := s.primaryNetworkInterface(); primaryNIC != nil {
+		networkInterfaceSpec.PrivateIPAllocationMethod = network.IPAllocationMethod(primaryNIC.PrivateIPAllocationMethod)
+		networkInterfaceSpec.StaticIPAddress = primaryNIC.PrivateIPAddress
+		networkInterfaceSpec.ApplicationSecurityGroups = primaryNIC.ApplicationSecurityGroups
+		networkInterfaceSpec.EnableIPForwarding = primaryNIC.EnableIPForwarding
+		networkInterfaceSpec.DNSServers = primaryNIC.DNSServers
 	}
 
 	if s.machineScope.AzureMachine.Spec.AllocatePublicIP == true {
@@ -236,20 +610,28 @@ func (s *azureMachineService) reconcileNetworkInterface(nicName string) error {
 
 	switch role := s.machineScope.Role(); role {
 	case infrav1.Node:
-		networkInterfaceSpec.SubnetName = s.clusterScope.NodeSubnet().Name
+		subnetName, err := s.getSubnetName(s.clusterScope.NodeSubnet())
+		if err != nil {
+			return err
+		}
+		networkInterfaceSpec.SubnetName = subnetName
+		networkInterfaceSpec.BackendAddressPoolID = s.getNodeBackendPoolID()
 	case infrav1.ControlPlane:
-		// TODO: Come up with a better way to determine the control plane NAT rule
-		natRuleString := strings.TrimPrefix(nicName, fmt.Sprintf("%s-controlplane-", s.clusterScope.Name()))
-		natRuleString = strings.TrimSuffix(natRuleString, "-nic")
-		natRule, err := strconv.Atoi(natRuleString)
+		subnetName, err := s.getSubnetName(s.clusterScope.ControlPlaneSubnet())
 		if err != nil {
-			return errors.Wrap(err, "unable to determine NAT rule for control plane network interface")
+			return err
 		}
 
-		networkInterfaceSpec.NatRule = natRule
-		networkInterfaceSpec.SubnetName = s.clusterScope.ControlPlaneSubnet().Name
-		networkInterfaceSpec.PublicLoadBalancerName = azure.GeneratePublicLBName(s.clusterScope.Name())
-		networkInterfaceSpec.InternalLoadBalancerName = azure.GenerateInternalLBName(s.clusterScope.Name())
+		networkInterfaceSpec.SubnetName = subnetName
+		if !s.clusterScope.IsAPIServerInternal() {
+			natRuleName, err := s.reconcileInboundNatRule()
+			if err != nil {
+				return err
+			}
+			networkInterfaceSpec.PublicLoadBalancerName = s.clusterScope.PublicLBName()
+			networkInterfaceSpec.InboundNatRuleName = natRuleName
+		}
+		networkInterfaceSpec.InternalLoadBalancerName = s.clusterScope.InternalLBName()
 	default:
 		return errors.Errorf("unknown value %s for label `set` on machine %s, skipping machine creation", role, s.machineScope.Name())
 	}
@@ -262,7 +644,112 @@ func (s *azureMachineService) reconcileNetworkInterface(nicName string) error {
 	return err
 }
 
-func (s *azureMachineService) createVirtualMachine(nicName string) (*infrav1.VM, error) {
+// reconcileInboundNatRule creates the inbound NAT rule of the public load balancer from a frontend port unique to a
+// control plane machine to its SSH port, records the frontend port in the machine status, and returns the name of the
+// rule.
+func (s *azureMachineService) reconcileInboundNatRule() (string, error) {
+	natRuleSpec := &inboundnatrules.Spec{
+		Name:             s.machineScope.Name(),
+		LoadBalancerName: s.clusterScope.PublicLBName(),
+	}
+	if err := s.inboundNatRulesSvc.Reconcile(s.clusterScope.Context, natRuleSpec); err != nil {
+		return "", errors.Wrapf(err, "failed to reconcile inbound NAT rule of machine %s", s.machineScope.Name())
+	}
+	natRuleInterface, err := s.inboundNatRulesSvc.Get(s.clusterScope.Context, natRuleSpec)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get inbound NAT rule of machine %s", s.machineScope.Name())
+	}
+	natRule, ok := natRuleInterface.(network.InboundNatRule)
+	if !ok {
+		return "", errors.New("inbound NAT rule Get returned invalid interface")
+	}
+	if natRule.InboundNatRulePropertiesFormat != nil && natRule.FrontendPort != nil {
+		s.machineScope.SetSSHPort(*natRule.FrontendPort)
+	}
+	return natRuleSpec.Name, nil
+}
+
+// getNodeBackendPoolID returns the ID of the node backend pool of the public load balancer for the primary network
+// interface of a node, or an empty ID for the control plane machines and when the cluster has no public load balancer.
+// Deleting the network interface removes it from the backend pool.
+func (s *azureMachineService) getNodeBackendPoolID() string {
+	if s.machineScope.Role() != infrav1.Node || s.clusterScope.IsAPIServerInternal() {
+		return ""
+	}
+	return azure.GenerateBackendAddressPoolID(s.clusterScope.SubscriptionID, s.clusterScope.ResourceGroup(), s.clusterScope.PublicLBName(), azure.NodeBackendPoolName)
+}
+
+// reconcileSecondaryNetworkInterfaces creates the network interfaces of the machine other than its primary one, and
+// returns their names. Unlike the primary network interface, they can be in a subnet of any role and are neither in
+// the backend pools of the load balancers nor have a public IP.
+func (s *azureMachineService) reconcileSecondaryNetworkInterfaces() ([]string, error) {
+	var names []string
+	for i, nic := range s.machineScope.AzureMachine.Spec.NetworkInterfaces {
+		if nic.Primary {
+			continue
+		}
+		if s.clusterScope.Subnet(nic.SubnetName) == nil {
+			// not terminal, the subnets of the cluster may not have been reconciled yet.
+			return nil, errors.Errorf("subnet %s of secondary network interface %d of machine %s does not exist in cluster %s", nic.SubnetName, i, s.machineScope.Name(), s.clusterScope.Name())
+		}
+		networkInterfaceSpec := &networkinterfaces.Spec{
+			Name:                      azure.GenerateSecondaryNICName(s.machineScope.Name(), i),
+			VnetName:                  s.clusterScope.Vnet().Name,
+			SubnetName:                nic.SubnetName,
+			PrivateIPAllocationMethod: network.IPAllocationMethod(nic.PrivateIPAllocationMethod),
+			StaticIPAddress:           nic.PrivateIPAddress,
+			ApplicationSecurityGroups: nic.ApplicationSecurityGroups,
+			EnableIPForwarding:        nic.EnableIPForwarding,
+			DNSServers:                nic.DNSServers,
+		}
+		if err := s.networkInterfacesSvc.Reconcile(s.clusterScope.Context, networkInterfaceSpec); err != nil {
+			return nil, errors.Wrapf(err, "unable to create VM network interface %s", networkInterfaceSpec.Name)
+		}
+		names = append(names, networkInterfaceSpec.Name)
+	}
+	return names, nil
+}
+
+// reconcileVMExtensions installs the VM extensions of the machine on its virtual machine. Deleting the virtual machine
+// deletes its extensions.
+func (s *azureMachineService) reconcileVMExtensions() error {
+	for _, extension := range s.machineScope.AzureMachine.Spec.VMExtensions {
+		vmExtSpec := &virtualmachineextensions.Spec{
+			Name:      extension.Name,
+			VMName:    s.machineScope.Name(),
+			Publisher: extension.Publisher,
+			Type:      extension.Type,
+			Version:   extension.Version,
+			Settings:  extension.Settings,
+		}
+		if err := s.virtualMachinesExtSvc.Reconcile(s.clusterScope.Context, vmExtSpec); err != nil {
+			return errors.Wrapf(err, "failed to reconcile vm extension %s of machine %s", extension.Name, s.machineScope.Name())
+		}
+	}
+	return nil
+}
+
+// reconcilePowerState deallocates the virtual machine of the machine while its spec asks for it, and starts it again
+// once the spec no longer does. The power state of the virtual machine is updated to the one it transitioned to.
+func (s *azureMachineService) reconcilePowerState(vm *infrav1.VM) error {
+	vmSpec := &virtualmachines.Spec{Name: s.machineScope.Name()}
+	deallocated := s.machineScope.AzureMachine.Spec.Deallocated
+	switch {
+	case deallocated && vm.PowerState != infrav1.VMPowerStateDeallocated:
+		if err := s.vmPowerSvc.Deallocate(s.clusterScope.Context, vmSpec); err != nil {
+			return errors.Wrapf(err, "failed to deallocate vm of machine %s", s.machineScope.Name())
+		}
+		vm.PowerState = infrav1.VMPowerStateDeallocated
+	case !deallocated && vm.PowerState == infrav1.VMPowerStateDeallocated:
+		if err := s.vmPowerSvc.Start(s.clusterScope.Context, vmSpec); err != nil {
+			return errors.Wrapf(err, "failed to start vm of machine %s", s.machineScope.Name())
+		}
+		vm.PowerState = infrav1.VMPowerStateRunning
+	}
+	return nil
+}
+
+func (s *azureMachineService) createVirtualMachine(nicName string, secondaryNICNames []string) (*infrav1.VM, error) {
 	var vm *infrav1.VM
 	decoded, err := base64.StdEncoding.DecodeString(s.machineScope.AzureMachine.Spec.SSHPublicKey)
 	if err != nil {
@@ -274,25 +761,38 @@ func (s *azureMachineService) createVirtualMachine(nicName string) (*infrav1.VM,
 	}
 
 	vmInterface, err := s.virtualMachinesSvc.Get(s.clusterScope.Context, vmSpec)
-	if err != nil && vmInterface == nil {
-		var vmZone string
+	if s.machineScope.GetLongRunningOperationState() != nil {
+		// Azure already returns the VM while it is being created, so the creation started by a previous reconcile is
+		// polled until it completes.
+		if err := s.virtualMachinesSvc.Reconcile(s.clusterScope.Context, vmSpec); err != nil {
+			return nil, errors.Wrapf(err, "failed to create or get machine")
+		}
+	} else if err != nil && vmInterface == nil {
+		vmZone, err := s.getVirtualMachineZone()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get availability zone")
+		}
 
-		azSupported := s.isAvailabilityZoneSupported()
+		ppgID, err := s.getProximityPlacementGroupID()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get proximity placement group")
+		}
 
-		if azSupported {
-			useAZ := true
+		availabilitySetID, err := s.getAvailabilitySetID(vmZone, ppgID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get availability set")
+		}
 
-			if s.machineScope.AzureMachine.Spec.AvailabilityZone.Enabled != nil {
-				useAZ = *s.machineScope.AzureMachine.Spec.AvailabilityZone.Enabled
-			}
+		if err := s.validateEphemeralOSDisk(); err != nil {
+			return nil, err
+		}
 
-			if useAZ {
-				var zoneErr error
-				vmZone, zoneErr = s.getVirtualMachineZone()
-				if zoneErr != nil {
-					return nil, errors.Wrap(zoneErr, "failed to get availability zone")
-				}
-			}
+		if err := s.validateWriteAccelerator(); err != nil {
+			return nil, err
+		}
+
+		if err := s.validateEncryptionAtHost(); err != nil {
+			return nil, err
 		}
 
 		image, err := getVMImage(s.machineScope)
@@ -300,15 +800,36 @@ func (s *azureMachineService) createVirtualMachine(nicName string) (*infrav1.VM,
 			return nil, errors.Wrap(err, "failed to get VM image")
 		}
 
+		if err := s.validateImagePlanTerms(image); err != nil {
+			return nil, err
+		}
+
+		bootstrapData, err := s.machineScope.GetBootstrapData()
+		if err != nil {
+			return nil, err
+		}
+
 		vmSpec = &virtualmachines.Spec{
-			Name:       s.machineScope.Name(),
-			NICName:    nicName,
-			SSHKeyData: string(decoded),
-			Size:       s.machineScope.AzureMachine.Spec.VMSize,
-			OSDisk:     s.machineScope.AzureMachine.Spec.OSDisk,
-			Image:      image,
-			CustomData: *s.machineScope.Machine.Spec.Bootstrap.Data,
-			Zone:       vmZone,
+			Name:                      s.machineScope.Name(),
+			NICName:                   nicName,
+			SecondaryNICNames:         secondaryNICNames,
+			SSHKeyData:                string(decoded),
+			AdminUsername:             s.machineScope.AzureMachine.Spec.AdminUsername,
+			ComputerName:              s.machineScope.AzureMachine.Spec.ComputerName,
+			Size:                      s.machineScope.AzureMachine.Spec.VMSize,
+			OSType:                    getOSType(s.machineScope),
+			OSDisk:                    s.machineScope.AzureMachine.Spec.OSDisk,
+			DataDisks:                 s.machineScope.AzureMachine.Spec.DataDisks,
+			Image:                     image,
+			CustomData:                bootstrapData,
+			Zone:                      vmZone,
+			AvailabilitySetID:         availabilitySetID,
+			ProximityPlacementGroupID: ppgID,
+			SpotVMOptions:             s.machineScope.AzureMachine.Spec.SpotVMOptions,
+			Identity:                  s.machineScope.AzureMachine.Spec.Identity,
+			UserAssignedIdentities:    s.machineScope.AzureMachine.Spec.UserAssignedIdentities,
+			Diagnostics:               s.machineScope.AzureMachine.Spec.Diagnostics,
+			EncryptionAtHost:          s.machineScope.AzureMachine.Spec.EncryptionAtHost,
 		}
 
 		err = s.virtualMachinesSvc.Reconcile(s.clusterScope.Context, vmSpec)
@@ -380,5 +901,17 @@ func getVMImage(scope *scope.MachineScope) (infrav1.Image, error) {
 	if scope.AzureMachine.Spec.Image != nil {
 		return *scope.AzureMachine.Spec.Image, nil
 	}
+	if getOSType(scope) == infrav1.WindowsOSType {
+		return azure.GetDefaultWindowsImage(to.String(scope.Machine.Spec.Version))
+	}
 	return azure.GetDefaultUbuntuImage(to.String(scope.Machine.Spec.Version))
 }
+
+// getOSType returns the OS type of the machine, which defaults to the OS type of its OS disk for machines that were not
+// defaulted by the webhook.
+func getOSType(scope *scope.MachineScope) infrav1.OSType {
+	if scope.AzureMachine.Spec.OSType != "" {
+		return scope.AzureMachine.Spec.OSType
+	}
+	return infrav1.OSType(scope.AzureMachine.Spec.OSDisk.OSType)
+}
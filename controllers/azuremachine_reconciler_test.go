@@ -17,12 +17,36 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"net/http"
+	"reflect"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/azure-sdk-for-go/services/marketplaceordering/mgmt/2015-06-01/marketplaceordering"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2019-06-01/network"
+	resourcefeatures "github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2015-12-01/features"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
 	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/mocks"
 	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilitysets"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/availabilityzones"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/disks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/features"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/inboundnatrules"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/marketplaceagreements"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/networkinterfaces"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/proximityplacementgroups"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/publicips"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/resourceskus"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachineextensions"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/virtualmachines"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -145,3 +169,1376 @@ func TestIsAvailabilityZoneSupported(t *testing.T) {
 		}
 	}
 }
+
+func TestGetSubnetName(t *testing.T) {
+	cluster := &v1alpha2.AzureCluster{
+		Spec: v1alpha2.AzureClusterSpec{
+			NetworkSpec: v1alpha2.NetworkSpec{
+				Subnets: v1alpha2.Subnets{
+					{Role: v1alpha2.SubnetControlPlane, Name: "controlplane-subnet"},
+					{Role: v1alpha2.SubnetNode, Name: "node-subnet"},
+					{Role: v1alpha2.SubnetNode, Name: "node-subnet-2"},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		name          string
+		subnetName    string
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "default subnet",
+			expected: "node-subnet",
+		},
+		{
+			name:       "selected subnet",
+			subnetName: "node-subnet-2",
+			expected:   "node-subnet-2",
+		},
+		{
+			name:          "missing subnet",
+			subnetName:    "missing-subnet",
+			expectedError: "subnet missing-subnet of machine machine-0 does not exist in cluster my-cluster",
+		},
+		{
+			name:          "subnet with another role",
+			subnetName:    "controlplane-subnet",
+			expectedError: "subnet controlplane-subnet has role control-plane, machine machine-0 has role node",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+					},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec:       v1alpha2.AzureMachineSpec{SubnetName: c.subnetName},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster:      &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: cluster,
+				},
+			}
+
+			actual, err := s.getSubnetName(s.clusterScope.NodeSubnet())
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("expected subnet %s, got %s", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetVirtualMachineZone(t *testing.T) {
+	cases := []struct {
+		name          string
+		location      string
+		zone          *string
+		enabled       *bool
+		expect        func(m *mocks.MockGetterServiceMockRecorder)
+		expected      string
+		expectedError string
+	}{
+		{
+			name:     "first available zone",
+			location: "westus2",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &availabilityzones.Spec{VMSize: "Standard_D2s_v3"}).Return([]string{"1", "2", "3"}, nil)
+			},
+			expected: "1",
+		},
+		{
+			name:     "requested zone",
+			location: "westus2",
+			zone:     to.StringPtr("2"),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &availabilityzones.Spec{VMSize: "Standard_D2s_v3"}).Return([]string{"1", "2", "3"}, nil)
+			},
+			expected: "2",
+		},
+		{
+			name:     "requested zone is not available for the vm size",
+			location: "westus2",
+			zone:     to.StringPtr("3"),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &availabilityzones.Spec{VMSize: "Standard_D2s_v3"}).Return([]string{"1", "2"}, nil)
+			},
+			expectedError: "availability zone 3 of machine machine-0 is not supported for VM size Standard_D2s_v3 in location westus2, supported zones are [1 2]",
+		},
+		{
+			name:          "requested zone in a location without zones",
+			location:      "westus",
+			zone:          to.StringPtr("1"),
+			expect:        func(m *mocks.MockGetterServiceMockRecorder) {},
+			expectedError: "availability zone 1 of machine machine-0 is not supported, location westus has no availability zones",
+		},
+		{
+			name:     "vm size without zones",
+			location: "westus2",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &availabilityzones.Spec{VMSize: "Standard_D2s_v3"}).Return([]string{}, nil)
+			},
+		},
+		{
+			name:     "location without zones",
+			location: "westus",
+			expect:   func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:     "zones disabled",
+			location: "westus2",
+			enabled:  to.BoolPtr(false),
+			expect:   func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			zonesMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(zonesMock.EXPECT())
+
+			cluster := &v1alpha2.AzureCluster{Spec: v1alpha2.AzureClusterSpec{Location: c.location}}
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Logger: log.Log.Logger,
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							VMSize:           "Standard_D2s_v3",
+							AvailabilityZone: v1alpha2.AvailabilityZone{ID: c.zone, Enabled: c.enabled},
+						},
+					},
+					AzureCluster: cluster,
+				},
+				clusterScope: &scope.ClusterScope{
+					AzureCluster: cluster,
+					Context:      context.TODO(),
+				},
+				availabilityZonesSvc: zonesMock,
+			}
+
+			actual, err := s.getVirtualMachineZone()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("expected zone %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetAvailabilitySetID(t *testing.T) {
+	cases := []struct {
+		name         string
+		zone         string
+		ppgID        string
+		controlPlane bool
+		expect       func(m *mocks.MockGetterServiceMockRecorder)
+		expected     string
+	}{
+		{
+			name:         "control plane machine without a zone",
+			controlPlane: true,
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				asSpec := &availabilitysets.Spec{Name: "my-cluster-controlplane-as", Role: "control-plane"}
+				gomock.InOrder(
+					m.Reconcile(gomock.Any(), asSpec),
+					m.Get(gomock.Any(), asSpec).Return(compute.AvailabilitySet{ID: to.StringPtr("my-as-id")}, nil),
+				)
+			},
+			expected: "my-as-id",
+		},
+		{
+			name:         "control plane machine in a proximity placement group",
+			ppgID:        "my-ppg-id",
+			controlPlane: true,
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				asSpec := &availabilitysets.Spec{Name: "my-cluster-controlplane-as", Role: "control-plane", ProximityPlacementGroupID: "my-ppg-id"}
+				gomock.InOrder(
+					m.Reconcile(gomock.Any(), asSpec),
+					m.Get(gomock.Any(), asSpec).Return(compute.AvailabilitySet{ID: to.StringPtr("my-as-id")}, nil),
+				)
+			},
+			expected: "my-as-id",
+		},
+		{
+			name:         "control plane machine in a zone",
+			zone:         "1",
+			controlPlane: true,
+			expect:       func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:   "node machine without a zone",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			availabilitySetsMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(availabilitySetsMock.EXPECT())
+
+			labels := map[string]string{}
+			if c.controlPlane {
+				labels[clusterv1.MachineControlPlaneLabelName] = "true"
+			}
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0", Labels: labels},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					Context: context.TODO(),
+				},
+				availabilitySetsSvc: availabilitySetsMock,
+			}
+
+			actual, err := s.getAvailabilitySetID(c.zone, c.ppgID)
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("expected availability set %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetProximityPlacementGroupID(t *testing.T) {
+	cases := []struct {
+		name         string
+		ppgScope     v1alpha2.ProximityPlacementGroupScope
+		controlPlane bool
+		expect       func(m *mocks.MockGetterServiceMockRecorder)
+		expected     string
+	}{
+		{
+			name:         "no proximity placement group by default",
+			controlPlane: true,
+			expect:       func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:         "control plane machine in the control plane proximity placement group",
+			ppgScope:     v1alpha2.ProximityPlacementGroupControlPlane,
+			controlPlane: true,
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &proximityplacementgroups.Spec{Name: "my-cluster-ppg"}).
+					Return(compute.ProximityPlacementGroup{ID: to.StringPtr("my-ppg-id")}, nil)
+			},
+			expected: "my-ppg-id",
+		},
+		{
+			name:     "node machine outside of the control plane proximity placement group",
+			ppgScope: v1alpha2.ProximityPlacementGroupControlPlane,
+			expect:   func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:     "node machine in the proximity placement group of all the machines",
+			ppgScope: v1alpha2.ProximityPlacementGroupAll,
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &proximityplacementgroups.Spec{Name: "my-cluster-ppg"}).
+					Return(compute.ProximityPlacementGroup{ID: to.StringPtr("my-ppg-id")}, nil)
+			},
+			expected: "my-ppg-id",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			ppgMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(ppgMock.EXPECT())
+
+			labels := map[string]string{}
+			if c.controlPlane {
+				labels[clusterv1.MachineControlPlaneLabelName] = "true"
+			}
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0", Labels: labels},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &v1alpha2.AzureCluster{
+						Spec: v1alpha2.AzureClusterSpec{ProximityPlacementGroup: c.ppgScope},
+					},
+					Context: context.TODO(),
+				},
+				ppgSvc: ppgMock,
+			}
+
+			actual, err := s.getProximityPlacementGroupID()
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if actual != c.expected {
+				t.Errorf("expected proximity placement group %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReconcileInboundNatRule(t *testing.T) {
+	cases := []struct {
+		name         string
+		machineName  string
+		frontendPort int32
+	}{
+		{
+			name:         "first control plane machine",
+			machineName:  "my-machine-0",
+			frontendPort: 22,
+		},
+		{
+			name:         "second control plane machine",
+			machineName:  "my-machine-1",
+			frontendPort: 2201,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			inboundNatRulesMock := mocks.NewMockGetterService(mockCtrl)
+			natRuleSpec := &inboundnatrules.Spec{Name: c.machineName, LoadBalancerName: "my-cluster-public-lb"}
+			inboundNatRulesMock.EXPECT().Reconcile(gomock.Any(), natRuleSpec)
+			inboundNatRulesMock.EXPECT().Get(gomock.Any(), natRuleSpec).Return(network.InboundNatRule{
+				Name:                           to.StringPtr(c.machineName),
+				InboundNatRulePropertiesFormat: &network.InboundNatRulePropertiesFormat{FrontendPort: to.Int32Ptr(c.frontendPort)},
+			}, nil)
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine:      &clusterv1.Machine{ObjectMeta: v1.ObjectMeta{Name: c.machineName}},
+					AzureMachine: &v1alpha2.AzureMachine{ObjectMeta: v1.ObjectMeta{Name: c.machineName}},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster:      &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &v1alpha2.AzureCluster{},
+					Context:      context.TODO(),
+				},
+				inboundNatRulesSvc: inboundNatRulesMock,
+			}
+
+			natRuleName, err := s.reconcileInboundNatRule()
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if natRuleName != c.machineName {
+				t.Errorf("expected inbound NAT rule %q, got %q", c.machineName, natRuleName)
+			}
+			if sshPort := s.machineScope.AzureMachine.Status.SSHPort; sshPort == nil || *sshPort != c.frontendPort {
+				t.Errorf("expected SSH port %d in the machine status, got %v", c.frontendPort, sshPort)
+			}
+		})
+	}
+}
+
+func TestReconcileVMExtensions(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	vmExtensionsMock := mocks.NewMockGetterService(mockCtrl)
+	gomock.InOrder(
+		vmExtensionsMock.EXPECT().Reconcile(gomock.Any(), &virtualmachineextensions.Spec{
+			Name:      "my-script",
+			VMName:    "my-machine",
+			Publisher: "Microsoft.Azure.Extensions",
+			Type:      "CustomScript",
+			Version:   "2.1",
+			Settings:  map[string]string{"commandToExecute": "echo bootstrapped"},
+		}),
+		vmExtensionsMock.EXPECT().Reconcile(gomock.Any(), &virtualmachineextensions.Spec{
+			Name:      "my-monitoring",
+			VMName:    "my-machine",
+			Publisher: "Microsoft.Azure.Monitor",
+			Type:      "AzureMonitorLinuxAgent",
+			Version:   "1.5",
+		}),
+	)
+
+	s := azureMachineService{
+		machineScope: &scope.MachineScope{
+			Machine: &clusterv1.Machine{ObjectMeta: v1.ObjectMeta{Name: "my-machine"}},
+			AzureMachine: &v1alpha2.AzureMachine{
+				ObjectMeta: v1.ObjectMeta{Name: "my-machine"},
+				Spec: v1alpha2.AzureMachineSpec{
+					VMExtensions: []v1alpha2.VMExtension{
+						{
+							Name:      "my-script",
+							Publisher: "Microsoft.Azure.Extensions",
+							Type:      "CustomScript",
+							Version:   "2.1",
+							Settings:  map[string]string{"commandToExecute": "echo bootstrapped"},
+						},
+						{
+							Name:      "my-monitoring",
+							Publisher: "Microsoft.Azure.Monitor",
+							Type:      "AzureMonitorLinuxAgent",
+							Version:   "1.5",
+						},
+					},
+				},
+			},
+		},
+		clusterScope: &scope.ClusterScope{
+			Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+			Context: context.TODO(),
+		},
+		virtualMachinesExtSvc: vmExtensionsMock,
+	}
+
+	if err := s.reconcileVMExtensions(); err != nil {
+		t.Fatalf("got an unexpected error: %v", err)
+	}
+}
+
+func TestReconcilePowerState(t *testing.T) {
+	vmSpec := &virtualmachines.Spec{Name: "my-machine"}
+
+	cases := []struct {
+		name               string
+		deallocated        bool
+		powerState         v1alpha2.VMPowerState
+		expect             func(m *mocks.MockPowerServiceMockRecorder)
+		expectedPowerState v1alpha2.VMPowerState
+		expectedError      string
+	}{
+		{
+			name:               "deallocate a running vm when requested",
+			deallocated:        true,
+			powerState:         v1alpha2.VMPowerStateRunning,
+			expect:             func(m *mocks.MockPowerServiceMockRecorder) { m.Deallocate(gomock.Any(), vmSpec) },
+			expectedPowerState: v1alpha2.VMPowerStateDeallocated,
+		},
+		{
+			name:               "start a deallocated vm once no longer requested",
+			powerState:         v1alpha2.VMPowerStateDeallocated,
+			expect:             func(m *mocks.MockPowerServiceMockRecorder) { m.Start(gomock.Any(), vmSpec) },
+			expectedPowerState: v1alpha2.VMPowerStateRunning,
+		},
+		{
+			name:               "keep a deallocated vm deallocated",
+			deallocated:        true,
+			powerState:         v1alpha2.VMPowerStateDeallocated,
+			expect:             func(m *mocks.MockPowerServiceMockRecorder) {},
+			expectedPowerState: v1alpha2.VMPowerStateDeallocated,
+		},
+		{
+			name:               "keep a running vm running",
+			powerState:         v1alpha2.VMPowerStateRunning,
+			expect:             func(m *mocks.MockPowerServiceMockRecorder) {},
+			expectedPowerState: v1alpha2.VMPowerStateRunning,
+		},
+		{
+			name:        "fail to deallocate the vm",
+			deallocated: true,
+			powerState:  v1alpha2.VMPowerStateRunning,
+			expect: func(m *mocks.MockPowerServiceMockRecorder) {
+				m.Deallocate(gomock.Any(), vmSpec).Return(errors.New("boom"))
+			},
+			expectedPowerState: v1alpha2.VMPowerStateRunning,
+			expectedError:      "failed to deallocate vm of machine my-machine: boom",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			powerMock := mocks.NewMockPowerService(mockCtrl)
+			c.expect(powerMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{ObjectMeta: v1.ObjectMeta{Name: "my-machine"}},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "my-machine"},
+						Spec:       v1alpha2.AzureMachineSpec{Deallocated: c.deallocated},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					Context: context.TODO(),
+				},
+				vmPowerSvc: powerMock,
+			}
+
+			vm := &v1alpha2.VM{PowerState: c.powerState}
+			err := s.reconcilePowerState(vm)
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if vm.PowerState != c.expectedPowerState {
+				t.Errorf("expected power state %q, got %q", c.expectedPowerState, vm.PowerState)
+			}
+		})
+	}
+}
+
+func TestVMIfExists(t *testing.T) {
+	vmSpec := &virtualmachines.Spec{Name: "my-machine"}
+	vm := &v1alpha2.VM{ID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/virtualMachines/my-machine", Name: "my-machine"}
+
+	cases := []struct {
+		name          string
+		id            *string
+		creating      bool
+		expect        func(vms *mocks.MockGetterServiceMockRecorder, clusterVMs *mocks.MockListerServiceMockRecorder)
+		expectedVM    *v1alpha2.VM
+		expectedError string
+	}{
+		{
+			name: "get the vm of a machine with an id",
+			id:   to.StringPtr(vm.ID),
+			expect: func(vms *mocks.MockGetterServiceMockRecorder, clusterVMs *mocks.MockListerServiceMockRecorder) {
+				vms.Get(gomock.Any(), vmSpec).Return(vm, nil)
+			},
+			expectedVM: vm,
+		},
+		{
+			name: "adopt the vm the cluster owns for a machine without an id",
+			expect: func(vms *mocks.MockGetterServiceMockRecorder, clusterVMs *mocks.MockListerServiceMockRecorder) {
+				clusterVMs.List(gomock.Any()).Return([]virtualmachines.ClusterVM{
+					{Name: "other-machine", PowerState: v1alpha2.VMPowerStateRunning},
+					{Name: "my-machine", PowerState: v1alpha2.VMPowerStateRunning},
+				}, nil)
+				vms.Get(gomock.Any(), vmSpec).Return(vm, nil)
+			},
+			expectedVM: vm,
+		},
+		{
+			name: "no vm for a machine without an id when the cluster owns none for it",
+			expect: func(vms *mocks.MockGetterServiceMockRecorder, clusterVMs *mocks.MockListerServiceMockRecorder) {
+				clusterVMs.List(gomock.Any()).Return([]virtualmachines.ClusterVM{
+					{Name: "other-machine", PowerState: v1alpha2.VMPowerStateRunning},
+				}, nil)
+			},
+		},
+		{
+			name:     "no vm is listed while the vm of the machine is being created",
+			creating: true,
+			expect:   func(vms *mocks.MockGetterServiceMockRecorder, clusterVMs *mocks.MockListerServiceMockRecorder) {},
+		},
+		{
+			name: "fail to list the vms of the cluster",
+			expect: func(vms *mocks.MockGetterServiceMockRecorder, clusterVMs *mocks.MockListerServiceMockRecorder) {
+				clusterVMs.List(gomock.Any()).Return(nil, errors.New("boom"))
+			},
+			expectedError: "failed to list the VMs of the cluster: boom",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			vmsMock := mocks.NewMockGetterService(mockCtrl)
+			clusterVMsMock := mocks.NewMockListerService(mockCtrl)
+			c.expect(vmsMock.EXPECT(), clusterVMsMock.EXPECT())
+
+			azureMachine := &v1alpha2.AzureMachine{ObjectMeta: v1.ObjectMeta{Name: "my-machine"}}
+			if c.creating {
+				azureMachine.Status.LongRunningOperationState = &v1alpha2.Future{Type: v1alpha2.PutFuture, Name: "my-machine"}
+			}
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{AzureMachine: azureMachine},
+				clusterScope: &scope.ClusterScope{
+					Logger:  log.Log.Logger,
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					Context: context.TODO(),
+				},
+				virtualMachinesSvc: vmsMock,
+				clusterVMsSvc:      clusterVMsMock,
+			}
+
+			got, err := s.VMIfExists(c.id)
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.expectedVM) {
+				t.Errorf("expected vm %+v, got %+v", c.expectedVM, got)
+			}
+		})
+	}
+}
+
+func TestGetNodeBackendPoolID(t *testing.T) {
+	cases := []struct {
+		name         string
+		lbType       v1alpha2.LoadBalancerType
+		controlPlane bool
+		expected     string
+	}{
+		{
+			name:     "node machine in the node backend pool of the public load balancer",
+			expected: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/loadBalancers/my-cluster-public-lb/backendAddressPools/node-backEndPool",
+		},
+		{
+			name:         "control plane machine outside of the node backend pool",
+			controlPlane: true,
+		},
+		{
+			name:   "node machine of a cluster without a public load balancer",
+			lbType: v1alpha2.LoadBalancerTypeInternal,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			labels := map[string]string{}
+			if c.controlPlane {
+				labels[clusterv1.MachineControlPlaneLabelName] = "true"
+			}
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0", Labels: labels},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					AzureClients: scope.AzureClients{SubscriptionID: "123"},
+					Cluster:      &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &v1alpha2.AzureCluster{
+						Spec: v1alpha2.AzureClusterSpec{
+							ResourceGroup: "my-rg",
+							NetworkSpec: v1alpha2.NetworkSpec{
+								APIServerLB: v1alpha2.LoadBalancerSpec{Type: c.lbType},
+							},
+						},
+					},
+				},
+			}
+
+			if actual := s.getNodeBackendPoolID(); actual != c.expected {
+				t.Errorf("expected backend pool %q, got %q", c.expected, actual)
+			}
+		})
+	}
+}
+
+func TestReconcileSecondaryNetworkInterfaces(t *testing.T) {
+	cases := []struct {
+		name          string
+		nics          []v1alpha2.NetworkInterface
+		expect        func(m *mocks.MockServiceMockRecorder)
+		expected      []string
+		expectedError string
+	}{
+		{
+			name:   "single network interface",
+			expect: func(m *mocks.MockServiceMockRecorder) {},
+		},
+		{
+			name: "two network interfaces",
+			nics: []v1alpha2.NetworkInterface{
+				{Primary: true},
+				{SubnetName: "my-appliance-subnet"},
+			},
+			expect: func(m *mocks.MockServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), &networkinterfaces.Spec{
+					Name:       "my-machine-nic-1",
+					VnetName:   "my-vnet",
+					SubnetName: "my-appliance-subnet",
+				})
+			},
+			expected: []string{"my-machine-nic-1"},
+		},
+		{
+			name: "network interface with a static private IP",
+			nics: []v1alpha2.NetworkInterface{
+				{Primary: true},
+				{SubnetName: "my-appliance-subnet", PrivateIPAllocationMethod: v1alpha2.StaticIPAllocationMethod, PrivateIPAddress: "10.1.0.10"},
+			},
+			expect: func(m *mocks.MockServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), &networkinterfaces.Spec{
+					Name:                      "my-machine-nic-1",
+					VnetName:                  "my-vnet",
+					SubnetName:                "my-appliance-subnet",
+					PrivateIPAllocationMethod: network.Static,
+					StaticIPAddress:           "10.1.0.10",
+				})
+			},
+			expected: []string{"my-machine-nic-1"},
+		},
+		{
+			name: "subnet that does not exist",
+			nics: []v1alpha2.NetworkInterface{
+				{Primary: true},
+				{SubnetName: "my-other-subnet"},
+			},
+			expect:        func(m *mocks.MockServiceMockRecorder) {},
+			expectedError: "subnet my-other-subnet of secondary network interface 1 of machine my-machine does not exist in cluster my-cluster",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			networkInterfacesMock := mocks.NewMockService(mockCtrl)
+			c.expect(networkInterfacesMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{ObjectMeta: v1.ObjectMeta{Name: "my-machine"}},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "my-machine"},
+						Spec:       v1alpha2.AzureMachineSpec{NetworkInterfaces: c.nics},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster: &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &v1alpha2.AzureCluster{
+						Spec: v1alpha2.AzureClusterSpec{
+							NetworkSpec: v1alpha2.NetworkSpec{
+								Vnet:    v1alpha2.VnetSpec{Name: "my-vnet"},
+								Subnets: v1alpha2.Subnets{{Name: "my-node-subnet"}, {Name: "my-appliance-subnet"}},
+							},
+						},
+					},
+					Context: context.TODO(),
+				},
+				networkInterfacesSvc: networkInterfacesMock,
+			}
+
+			names, err := s.reconcileSecondaryNetworkInterfaces()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(names, c.expected) {
+				t.Errorf("expected secondary network interfaces %v, got %v", c.expected, names)
+			}
+		})
+	}
+}
+
+func TestValidateVMSize(t *testing.T) {
+	cases := []struct {
+		name          string
+		zone          *string
+		expect        func(m *mocks.MockGetterServiceMockRecorder)
+		expectedError string
+	}{
+		{
+			name: "VM size available",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{Name: to.StringPtr("Standard_D2s_v3")}, nil)
+			},
+		},
+		{
+			name: "VM size not available",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{},
+					errors.New("VM size Standard_D2s_v3 is not available in location eastus, available VM sizes include Standard_D2s_v4"))
+			},
+			expectedError: "failed to get resource sku for VM size Standard_D2s_v3: VM size Standard_D2s_v3 is not available in location eastus, available VM sizes include Standard_D2s_v4",
+		},
+		{
+			name: "VM size available in the zone",
+			zone: to.StringPtr("2"),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Name:         to.StringPtr("Standard_D2s_v3"),
+					LocationInfo: &[]compute.ResourceSkuLocationInfo{{Location: to.StringPtr("eastus"), Zones: &[]string{"1", "2", "3"}}},
+				}, nil)
+			},
+		},
+		{
+			name: "VM size not available in the zone",
+			zone: to.StringPtr("3"),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Name:         to.StringPtr("Standard_D2s_v3"),
+					LocationInfo: &[]compute.ResourceSkuLocationInfo{{Location: to.StringPtr("eastus"), Zones: &[]string{"1", "2"}}},
+				}, nil)
+			},
+			expectedError: "VM size Standard_D2s_v3 is not available in availability zone 3 of location eastus",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			resourceSkusMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(resourceSkusMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					AzureCluster: &v1alpha2.AzureCluster{
+						Spec: v1alpha2.AzureClusterSpec{Location: "eastus"},
+					},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							VMSize:           "Standard_D2s_v3",
+							AvailabilityZone: v1alpha2.AvailabilityZone{ID: c.zone},
+						},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Context: context.TODO(),
+				},
+				resourceSkusSvc: resourceSkusMock,
+			}
+
+			err := s.validateVMSize()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateAcceleratedNetworking(t *testing.T) {
+	cases := []struct {
+		name                  string
+		acceleratedNetworking *bool
+		expect                func(m *mocks.MockGetterServiceMockRecorder)
+		expectedError         string
+	}{
+		{
+			name:   "accelerated networking not requested",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:                  "accelerated networking supported",
+			acceleratedNetworking: to.BoolPtr(true),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.AcceleratedNetworking), Value: to.StringPtr("True")},
+					},
+				}, nil)
+			},
+		},
+		{
+			name:                  "accelerated networking not supported",
+			acceleratedNetworking: to.BoolPtr(true),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.AcceleratedNetworking), Value: to.StringPtr("False")},
+					},
+				}, nil)
+			},
+			expectedError: "accelerated networking of machine machine-0 is not supported for VM size Standard_D2s_v3 in location eastus",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			resourceSkusMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(resourceSkusMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					AzureCluster: &v1alpha2.AzureCluster{
+						Spec: v1alpha2.AzureClusterSpec{Location: "eastus"},
+					},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							VMSize:                "Standard_D2s_v3",
+							AcceleratedNetworking: c.acceleratedNetworking,
+						},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Context: context.TODO(),
+				},
+				resourceSkusSvc: resourceSkusMock,
+			}
+
+			err := s.validateAcceleratedNetworking()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateEphemeralOSDisk(t *testing.T) {
+	cases := []struct {
+		name             string
+		diffDiskSettings *v1alpha2.DiffDiskSettings
+		expect           func(m *mocks.MockGetterServiceMockRecorder)
+		expectedError    string
+	}{
+		{
+			name:   "managed OS disk",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:             "ephemeral OS disk fits in the cache",
+			diffDiskSettings: &v1alpha2.DiffDiskSettings{Option: "Local"},
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.CachedDiskBytes), Value: to.StringPtr("53687091200")},
+					},
+				}, nil)
+			},
+		},
+		{
+			name:             "ephemeral OS disk larger than the cache",
+			diffDiskSettings: &v1alpha2.DiffDiskSettings{Option: "Local"},
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.CachedDiskBytes), Value: to.StringPtr("21474836480")},
+					},
+				}, nil)
+			},
+			expectedError: "ephemeral OS disk of machine machine-0 needs 30 GB, VM size Standard_D2s_v3 does not have a large enough cache",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			resourceSkusMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(resourceSkusMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							VMSize: "Standard_D2s_v3",
+							OSDisk: v1alpha2.OSDisk{
+								OSType:           "Linux",
+								DiskSizeGB:       30,
+								ManagedDisk:      v1alpha2.ManagedDisk{StorageAccountType: "Standard_LRS"},
+								DiffDiskSettings: c.diffDiskSettings,
+							},
+						},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Context: context.TODO(),
+				},
+				resourceSkusSvc: resourceSkusMock,
+			}
+
+			err := s.validateEphemeralOSDisk()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateWriteAccelerator(t *testing.T) {
+	premium := &v1alpha2.ManagedDisk{StorageAccountType: "Premium_LRS"}
+	cases := []struct {
+		name          string
+		dataDisks     []v1alpha2.DataDisk
+		expect        func(m *mocks.MockGetterServiceMockRecorder)
+		expectedError string
+	}{
+		{
+			name:      "no write accelerator",
+			dataDisks: []v1alpha2.DataDisk{{NameSuffix: "log", DiskSizeGB: 512, ManagedDisk: premium}},
+			expect:    func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:      "write accelerator supported by the VM size",
+			dataDisks: []v1alpha2.DataDisk{{NameSuffix: "log", DiskSizeGB: 512, ManagedDisk: premium, WriteAcceleratorEnabled: to.BoolPtr(true)}},
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_M64s"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.MaxWriteAcceleratorDisksAllowed), Value: to.StringPtr("8")},
+					},
+				}, nil)
+			},
+		},
+		{
+			name:      "write accelerator not supported by the VM size",
+			dataDisks: []v1alpha2.DataDisk{{NameSuffix: "log", DiskSizeGB: 512, ManagedDisk: premium, WriteAcceleratorEnabled: to.BoolPtr(true)}},
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_M64s"}).Return(compute.ResourceSku{}, nil)
+			},
+			expectedError: "machine machine-0 has 1 data disks with write accelerator, VM size Standard_M64s does not support write accelerator on that many disks",
+		},
+		{
+			name: "more write accelerator disks than the VM size supports",
+			dataDisks: []v1alpha2.DataDisk{
+				{NameSuffix: "log", DiskSizeGB: 512, ManagedDisk: premium, WriteAcceleratorEnabled: to.BoolPtr(true)},
+				{NameSuffix: "log2", DiskSizeGB: 512, Lun: 1, ManagedDisk: premium, WriteAcceleratorEnabled: to.BoolPtr(true)},
+			},
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_M64s"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.MaxWriteAcceleratorDisksAllowed), Value: to.StringPtr("1")},
+					},
+				}, nil)
+			},
+			expectedError: "machine machine-0 has 2 data disks with write accelerator, VM size Standard_M64s does not support write accelerator on that many disks",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			resourceSkusMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(resourceSkusMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							VMSize:    "Standard_M64s",
+							DataDisks: c.dataDisks,
+						},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Context: context.TODO(),
+				},
+				resourceSkusSvc: resourceSkusMock,
+			}
+
+			err := s.validateWriteAccelerator()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateImagePlanTerms(t *testing.T) {
+	plan := &v1alpha2.ImagePlan{Publisher: "test-publisher", Offer: "test-offer", Name: "test-sku"}
+	agreementSpec := &marketplaceagreements.Spec{Publisher: "test-publisher", Offer: "test-offer", Plan: "test-sku"}
+
+	cases := []struct {
+		name          string
+		plan          *v1alpha2.ImagePlan
+		expect        func(m *mocks.MockGetterServiceMockRecorder)
+		expectedError string
+	}{
+		{
+			name:   "image without a plan",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name: "image plan with accepted terms",
+			plan: plan,
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), agreementSpec).Return(marketplaceordering.AgreementTerms{
+					AgreementProperties: &marketplaceordering.AgreementProperties{Accepted: to.BoolPtr(true)},
+				}, nil)
+			},
+		},
+		{
+			name: "image plan without accepted terms",
+			plan: plan,
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), agreementSpec).Return(marketplaceordering.AgreementTerms{
+					AgreementProperties: &marketplaceordering.AgreementProperties{Accepted: to.BoolPtr(false)},
+				}, nil)
+			},
+			expectedError: "marketplace terms of plan test-sku of offer test-offer by publisher test-publisher are not accepted in subscription 123, " +
+				"accept them with: az vm image terms accept --publisher test-publisher --offer test-offer --plan test-sku",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			agreementsMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(agreementsMock.EXPECT())
+
+			s := azureMachineService{
+				clusterScope: &scope.ClusterScope{
+					AzureClients: scope.AzureClients{SubscriptionID: "123"},
+					Context:      context.TODO(),
+				},
+				marketplaceAgreementsSvc: agreementsMock,
+			}
+
+			err := s.validateImagePlanTerms(v1alpha2.Image{Plan: c.plan})
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateEncryptionAtHost(t *testing.T) {
+	featureSpec := &features.Spec{Namespace: "Microsoft.Compute", Name: "EncryptionAtHost"}
+	registered := resourcefeatures.Result{Properties: &resourcefeatures.Properties{State: to.StringPtr("Registered")}}
+
+	cases := []struct {
+		name             string
+		encryptionAtHost *bool
+		expectFeatures   func(m *mocks.MockGetterServiceMockRecorder)
+		expectSkus       func(m *mocks.MockGetterServiceMockRecorder)
+		expectedError    string
+	}{
+		{
+			name:             "encryption at host not requested",
+			encryptionAtHost: to.BoolPtr(false),
+			expectFeatures:   func(m *mocks.MockGetterServiceMockRecorder) {},
+			expectSkus:       func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name:             "encryption at host supported",
+			encryptionAtHost: to.BoolPtr(true),
+			expectFeatures: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), featureSpec).Return(registered, nil)
+			},
+			expectSkus: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{
+					Capabilities: &[]compute.ResourceSkuCapabilities{
+						{Name: to.StringPtr(resourceskus.EncryptionAtHost), Value: to.StringPtr("True")},
+					},
+				}, nil)
+			},
+		},
+		{
+			name:             "encryption at host feature not registered",
+			encryptionAtHost: to.BoolPtr(true),
+			expectFeatures: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), featureSpec).Return(resourcefeatures.Result{
+					Properties: &resourcefeatures.Properties{State: to.StringPtr("NotRegistered")},
+				}, nil)
+			},
+			expectSkus: func(m *mocks.MockGetterServiceMockRecorder) {},
+			expectedError: "encryption at host of machine machine-0 requires the feature Microsoft.Compute/EncryptionAtHost registered in subscription 123, " +
+				"register it with: az feature register --namespace Microsoft.Compute --name EncryptionAtHost",
+		},
+		{
+			name:             "encryption at host not supported by the VM size",
+			encryptionAtHost: to.BoolPtr(true),
+			expectFeatures: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), featureSpec).Return(registered, nil)
+			},
+			expectSkus: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Get(gomock.Any(), &resourceskus.Spec{VMSize: "Standard_D2s_v3"}).Return(compute.ResourceSku{}, nil)
+			},
+			expectedError: "encryption at host of machine machine-0 is not supported for VM size Standard_D2s_v3 in location eastus",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			featuresMock := mocks.NewMockGetterService(mockCtrl)
+			resourceSkusMock := mocks.NewMockGetterService(mockCtrl)
+			c.expectFeatures(featuresMock.EXPECT())
+			c.expectSkus(resourceSkusMock.EXPECT())
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					AzureCluster: &v1alpha2.AzureCluster{
+						Spec: v1alpha2.AzureClusterSpec{Location: "eastus"},
+					},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							VMSize:           "Standard_D2s_v3",
+							EncryptionAtHost: c.encryptionAtHost,
+						},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					AzureClients: scope.AzureClients{SubscriptionID: "123"},
+					Context:      context.TODO(),
+				},
+				featuresSvc:     featuresMock,
+				resourceSkusSvc: resourceSkusMock,
+			}
+
+			err := s.validateEncryptionAtHost()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	notFound := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 404}, "Not found")
+	vmSpec := &virtualmachines.Spec{Name: "my-machine-0"}
+	nicSpec := &networkinterfaces.Spec{Name: azure.GenerateNICName("my-machine-0"), VnetName: "my-cluster-vnet"}
+	natRuleSpec := &inboundnatrules.Spec{Name: "my-machine-0", LoadBalancerName: "my-cluster-public-lb"}
+	secondaryNICSpec := &networkinterfaces.Spec{Name: azure.GenerateSecondaryNICName("my-machine-0", 1), VnetName: "my-cluster-vnet"}
+	publicIPSpec := &publicips.Spec{Name: azure.GenerateNICName("my-machine-0") + "-public-ip"}
+	osDiskSpec := &disks.Spec{Name: azure.GenerateOSDiskName("my-machine-0")}
+	dataDiskSpec := &disks.Spec{Name: azure.GenerateDataDiskName("my-machine-0", "etcd")}
+
+	cases := []struct {
+		name          string
+		expect        func(vm, nic, natRule, publicIP, disk *mocks.MockGetterService) []*gomock.Call
+		expectedError string
+	}{
+		{
+			name: "delete all the resources of the machine",
+			expect: func(vm, nic, natRule, publicIP, disk *mocks.MockGetterService) []*gomock.Call {
+				return []*gomock.Call{
+					vm.EXPECT().Delete(gomock.Any(), vmSpec),
+					nic.EXPECT().Delete(gomock.Any(), nicSpec),
+					natRule.EXPECT().Delete(gomock.Any(), natRuleSpec),
+					nic.EXPECT().Delete(gomock.Any(), secondaryNICSpec),
+					publicIP.EXPECT().Delete(gomock.Any(), publicIPSpec),
+					disk.EXPECT().Delete(gomock.Any(), osDiskSpec),
+					disk.EXPECT().Delete(gomock.Any(), dataDiskSpec),
+				}
+			},
+		},
+		{
+			name: "missing network interface does not abort the cleanup",
+			expect: func(vm, nic, natRule, publicIP, disk *mocks.MockGetterService) []*gomock.Call {
+				return []*gomock.Call{
+					vm.EXPECT().Delete(gomock.Any(), vmSpec),
+					nic.EXPECT().Delete(gomock.Any(), nicSpec).Return(errors.Wrap(notFound, "failed to delete network interface")),
+					natRule.EXPECT().Delete(gomock.Any(), natRuleSpec),
+					nic.EXPECT().Delete(gomock.Any(), secondaryNICSpec).Return(notFound),
+					publicIP.EXPECT().Delete(gomock.Any(), publicIPSpec),
+					disk.EXPECT().Delete(gomock.Any(), osDiskSpec),
+					disk.EXPECT().Delete(gomock.Any(), dataDiskSpec),
+				}
+			},
+		},
+		{
+			name: "fail to delete the vm",
+			expect: func(vm, nic, natRule, publicIP, disk *mocks.MockGetterService) []*gomock.Call {
+				return []*gomock.Call{
+					vm.EXPECT().Delete(gomock.Any(), vmSpec).
+						Return(autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: 500}, "Internal Server Error")),
+				}
+			},
+			expectedError: "failed to delete machine: #: Internal Server Error: StatusCode=500",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			vmMock := mocks.NewMockGetterService(mockCtrl)
+			nicMock := mocks.NewMockGetterService(mockCtrl)
+			natRuleMock := mocks.NewMockGetterService(mockCtrl)
+			publicIPMock := mocks.NewMockGetterService(mockCtrl)
+			diskMock := mocks.NewMockGetterService(mockCtrl)
+			gomock.InOrder(c.expect(vmMock, nicMock, natRuleMock, publicIPMock, diskMock)...)
+
+			s := azureMachineService{
+				machineScope: &scope.MachineScope{
+					Machine: &clusterv1.Machine{ObjectMeta: v1.ObjectMeta{
+						Name:   "my-machine-0",
+						Labels: map[string]string{clusterv1.MachineControlPlaneLabelName: "true"},
+					}},
+					AzureMachine: &v1alpha2.AzureMachine{
+						ObjectMeta: v1.ObjectMeta{Name: "my-machine-0"},
+						Spec: v1alpha2.AzureMachineSpec{
+							NetworkInterfaces: []v1alpha2.NetworkInterface{{Primary: true}, {}},
+							DataDisks: []v1alpha2.DataDisk{
+								{NameSuffix: "etcd", DiskSizeGB: 256},
+								{NameSuffix: "shared", ManagedDiskID: "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Compute/disks/shared", Lun: 1},
+							},
+						},
+					},
+				},
+				clusterScope: &scope.ClusterScope{
+					Cluster:      &clusterv1.Cluster{ObjectMeta: v1.ObjectMeta{Name: "my-cluster"}},
+					AzureCluster: &v1alpha2.AzureCluster{},
+					Context:      context.TODO(),
+				},
+				virtualMachinesSvc:   vmMock,
+				networkInterfacesSvc: nicMock,
+				inboundNatRulesSvc:   natRuleMock,
+				publicIPSvc:          publicIPMock,
+				disksSvc:             diskMock,
+			}
+
+			err := s.Delete()
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+		})
+	}
+}
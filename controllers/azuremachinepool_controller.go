@@ -0,0 +1,199 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api/util"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// AzureMachinePoolReconciler reconciles a AzureMachinePool object
+type AzureMachinePoolReconciler struct {
+	client.Client
+	Log logr.Logger
+	// AzureClients holds the rate limit of the requests to the Azure API.
+	AzureClients scope.AzureClients
+	// DryRun makes the reconciler log the changes it would make to the Azure resources, without making them.
+	DryRun bool
+}
+
+func (r *AzureMachinePoolReconciler) SetupWithManager(mgr ctrl.Manager, options controller.Options) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(options).
+		For(&infrav1.AzureMachinePool{}).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azuremachinepools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azuremachinepools/status,verbs=get;update;patch
+
+func (r *AzureMachinePoolReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
+	ctx := context.TODO()
+	logger := r.Log.WithValues("namespace", req.Namespace, "azureMachinePool", req.Name)
+
+	// Fetch the AzureMachinePool.
+	azureMachinePool := &infrav1.AzureMachinePool{}
+	err := r.Get(ctx, req.NamespacedName, azureMachinePool)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	// Fetch the Cluster.
+	cluster, err := util.GetClusterFromMetadata(ctx, r.Client, azureMachinePool.ObjectMeta)
+	if err != nil {
+		logger.Info("AzureMachinePool is missing cluster label or cluster does not exist")
+		return reconcile.Result{}, nil
+	}
+
+	logger = logger.WithValues("cluster", cluster.Name)
+
+	azureCluster := &infrav1.AzureCluster{}
+
+	azureClusterName := client.ObjectKey{
+		Namespace: azureMachinePool.Namespace,
+		Name:      cluster.Spec.InfrastructureRef.Name,
+	}
+	if err := r.Client.Get(ctx, azureClusterName, azureCluster); err != nil {
+		logger.Info("AzureCluster is not available yet")
+		return reconcile.Result{}, nil
+	}
+
+	logger = logger.WithValues("azureCluster", azureCluster.Name)
+
+	// Create the cluster scope
+	clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+		AzureClients: r.AzureClients,
+		Client:       r.Client,
+		Logger:       logger,
+		Cluster:      cluster,
+		AzureCluster: azureCluster,
+		DryRun:       r.DryRun,
+	})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Create the machine pool scope
+	machinePoolScope, err := scope.NewMachinePoolScope(scope.MachinePoolScopeParams{
+		Logger:           logger,
+		Client:           r.Client,
+		Cluster:          cluster,
+		AzureCluster:     azureCluster,
+		AzureMachinePool: azureMachinePool,
+	})
+	if err != nil {
+		return reconcile.Result{}, errors.Errorf("failed to create scope: %+v", err)
+	}
+
+	// Always close the scope when exiting this function so we can persist any AzureMachinePool changes.
+	defer func() {
+		logPlannedActions(clusterScope)
+		// the status of the AzureMachinePool would describe a scale set which was not changed.
+		if clusterScope.IsDryRun() {
+			return
+		}
+		if err := machinePoolScope.Close(); err != nil && reterr == nil {
+			reterr = err
+		}
+	}()
+
+	ams := newAzureMachinePoolService(machinePoolScope, clusterScope)
+
+	// Handle deleted machine pools
+	if !azureMachinePool.ObjectMeta.DeletionTimestamp.IsZero() {
+		result, err := r.reconcileDelete(machinePoolScope, ams)
+		return requeueOnError(logger, result, err)
+	}
+
+	// Handle non-deleted machine pools
+	result, err := r.reconcileNormal(machinePoolScope, ams)
+	return requeueOnError(logger, result, err)
+}
+
+// reconcileNormal creates the scale set of the machine pool once the infrastructure of the cluster is ready and
+// the bootstrap data of the pool is available, scales it to the desired number of instances, and reports its
+// provisioning state and its number of instances in the status of the pool.
+func (r *AzureMachinePoolReconciler) reconcileNormal(machinePoolScope *scope.MachinePoolScope, ams *azureMachinePoolService) (reconcile.Result, error) {
+	machinePoolScope.Info("Reconciling AzureMachinePool")
+
+	// If the AzureMachinePool doesn't have our finalizer, add it.
+	if !util.Contains(machinePoolScope.AzureMachinePool.Finalizers, infrav1.MachinePoolFinalizer) {
+		machinePoolScope.AzureMachinePool.Finalizers = append(machinePoolScope.AzureMachinePool.Finalizers, infrav1.MachinePoolFinalizer)
+	}
+
+	if !machinePoolScope.Cluster.Status.InfrastructureReady {
+		machinePoolScope.Info("Cluster infrastructure is not ready yet")
+		return reconcile.Result{}, nil
+	}
+
+	// Make sure bootstrap data is available and populated.
+	if machinePoolScope.AzureMachinePool.Spec.BootstrapData == nil {
+		machinePoolScope.Info("Bootstrap data is not yet available")
+		return reconcile.Result{}, nil
+	}
+
+	scaleSet, err := ams.Reconcile()
+	if err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "failed to reconcile scale set of machine pool %s", machinePoolScope.Name())
+	}
+
+	var state infrav1.VMState
+	if scaleSet.VirtualMachineScaleSetProperties != nil {
+		state = infrav1.VMState(to.String(scaleSet.ProvisioningState))
+	}
+	var replicas int32
+	if scaleSet.Sku != nil {
+		replicas = int32(to.Int64(scaleSet.Sku.Capacity))
+	}
+	machinePoolScope.SetProvisioningState(state)
+	machinePoolScope.SetReplicas(replicas)
+
+	ready := state == infrav1.VMStateSucceeded && replicas == machinePoolScope.Replicas()
+	machinePoolScope.SetReady(ready)
+	if !ready {
+		machinePoolScope.Info("Waiting for the scale set to be provisioned", "provisioningState", state, "replicas", replicas)
+		return reconcile.Result{RequeueAfter: vmCreationPollInterval}, nil
+	}
+	return reconcile.Result{}, nil
+}
+
+// reconcileDelete deletes the scale set of the machine pool, with all its instances, before removing the finalizer
+// of the pool.
+func (r *AzureMachinePoolReconciler) reconcileDelete(machinePoolScope *scope.MachinePoolScope, ams *azureMachinePoolService) (reconcile.Result, error) {
+	machinePoolScope.Info("Handling deleted AzureMachinePool")
+
+	if err := ams.Delete(); err != nil {
+		return reconcile.Result{}, errors.Wrapf(err, "failed to delete scale set of machine pool %s", machinePoolScope.Name())
+	}
+
+	machinePoolScope.AzureMachinePool.Finalizers = util.Filter(machinePoolScope.AzureMachinePool.Finalizers, infrav1.MachinePoolFinalizer)
+	return reconcile.Result{}, nil
+}
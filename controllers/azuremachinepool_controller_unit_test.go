@@ -0,0 +1,250 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/klogr"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/mocks"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/scalesets"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
+	"sigs.k8s.io/cluster-api/util"
+)
+
+// newTestMachinePoolService returns the service of a machine pool with the replicas of a cluster whose infrastructure
+// is ready, reconciling its scale set with the scale sets service.
+func newTestMachinePoolService(replicas *int32, scaleSetsSvc *mocks.MockGetterService) *azureMachinePoolService {
+	azureCluster := &infrav1.AzureCluster{
+		Spec: infrav1.AzureClusterSpec{
+			ResourceGroup: "my-rg",
+			Location:      "eastus",
+			NetworkSpec: infrav1.NetworkSpec{
+				Vnet: infrav1.VnetSpec{Name: "my-vnet", ResourceGroup: "my-rg"},
+				Subnets: infrav1.Subnets{
+					{Role: infrav1.SubnetControlPlane, Name: "controlplane-subnet"},
+					{Role: infrav1.SubnetNode, Name: "node-subnet"},
+				},
+			},
+		},
+	}
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cluster"},
+		Status:     clusterv1.ClusterStatus{InfrastructureReady: true},
+	}
+	return &azureMachinePoolService{
+		machinePoolScope: &scope.MachinePoolScope{
+			Logger:       klogr.New(),
+			Cluster:      cluster,
+			AzureCluster: azureCluster,
+			AzureMachinePool: &infrav1.AzureMachinePool{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-pool", Namespace: "default"},
+				Spec: infrav1.AzureMachinePoolSpec{
+					Replicas:      replicas,
+					VMSize:        "Standard_D2s_v3",
+					Image:         &infrav1.Image{ID: to.StringPtr("my-image")},
+					SSHPublicKey:  base64.StdEncoding.EncodeToString([]byte("ssh-rsa AAAA")),
+					BootstrapData: to.StringPtr("Ym9vdHN0cmFw"),
+				},
+			},
+		},
+		clusterScope: &scope.ClusterScope{
+			Logger:       klogr.New(),
+			AzureClients: scope.AzureClients{SubscriptionID: "123"},
+			Cluster:      cluster,
+			AzureCluster: azureCluster,
+			Context:      context.TODO(),
+		},
+		scaleSetsSvc: scaleSetsSvc,
+	}
+}
+
+func TestAzureMachinePoolReconciler_ReconcileNormal(t *testing.T) {
+	expectedSpec := func(capacity int64) *scalesets.Spec {
+		return &scalesets.Spec{
+			Name:       "my-pool",
+			Capacity:   capacity,
+			Size:       "Standard_D2s_v3",
+			Image:      infrav1.Image{ID: to.StringPtr("my-image")},
+			SubnetID:   "/subscriptions/123/resourceGroups/my-rg/providers/Microsoft.Network/virtualNetworks/my-vnet/subnets/node-subnet",
+			SSHKeyData: "ssh-rsa AAAA",
+			CustomData: "Ym9vdHN0cmFw",
+			Role:       infrav1.Node,
+		}
+	}
+	scaleSet := func(state string, capacity int64) compute.VirtualMachineScaleSet {
+		return compute.VirtualMachineScaleSet{
+			Sku:                              &compute.Sku{Name: to.StringPtr("Standard_D2s_v3"), Capacity: to.Int64Ptr(capacity)},
+			VirtualMachineScaleSetProperties: &compute.VirtualMachineScaleSetProperties{ProvisioningState: to.StringPtr(state)},
+		}
+	}
+
+	cases := []struct {
+		name             string
+		replicas         *int32
+		noBootstrapData  bool
+		expect           func(m *mocks.MockGetterServiceMockRecorder)
+		expectedReady    bool
+		expectedReplicas int32
+		expectedRequeue  time.Duration
+		expectedError    string
+	}{
+		{
+			name: "create a scale set with one instance by default",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), expectedSpec(1))
+				m.Get(gomock.Any(), &scalesets.Spec{Name: "my-pool"}).Return(scaleSet("Succeeded", 1), nil)
+			},
+			expectedReady:    true,
+			expectedReplicas: 1,
+		},
+		{
+			name:     "scale up the scale set",
+			replicas: to.Int32Ptr(5),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), expectedSpec(5))
+				m.Get(gomock.Any(), &scalesets.Spec{Name: "my-pool"}).Return(scaleSet("Succeeded", 5), nil)
+			},
+			expectedReady:    true,
+			expectedReplicas: 5,
+		},
+		{
+			name:     "scale down the scale set to no instances",
+			replicas: to.Int32Ptr(0),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), expectedSpec(0))
+				m.Get(gomock.Any(), &scalesets.Spec{Name: "my-pool"}).Return(scaleSet("Succeeded", 0), nil)
+			},
+			expectedReady:    true,
+			expectedReplicas: 0,
+		},
+		{
+			name:     "wait for the scale set to be scaled",
+			replicas: to.Int32Ptr(3),
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), expectedSpec(3))
+				m.Get(gomock.Any(), &scalesets.Spec{Name: "my-pool"}).Return(scaleSet("Updating", 2), nil)
+			},
+			expectedReplicas: 2,
+			expectedRequeue:  vmCreationPollInterval,
+		},
+		{
+			name:            "wait for the bootstrap data",
+			noBootstrapData: true,
+			expect:          func(m *mocks.MockGetterServiceMockRecorder) {},
+		},
+		{
+			name: "fail to reconcile the scale set",
+			expect: func(m *mocks.MockGetterServiceMockRecorder) {
+				m.Reconcile(gomock.Any(), expectedSpec(1)).Return(errors.New("boom"))
+			},
+			expectedError: "failed to reconcile scale set of machine pool my-pool: boom",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			scaleSetsMock := mocks.NewMockGetterService(mockCtrl)
+			c.expect(scaleSetsMock.EXPECT())
+
+			ams := newTestMachinePoolService(c.replicas, scaleSetsMock)
+			azureMachinePool := ams.machinePoolScope.AzureMachinePool
+			if c.noBootstrapData {
+				azureMachinePool.Spec.BootstrapData = nil
+			}
+
+			result, err := (&AzureMachinePoolReconciler{}).reconcileNormal(ams.machinePoolScope, ams)
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if !util.Contains(azureMachinePool.Finalizers, infrav1.MachinePoolFinalizer) {
+				t.Errorf("expected the finalizer %s, got %v", infrav1.MachinePoolFinalizer, azureMachinePool.Finalizers)
+			}
+			if result.RequeueAfter != c.expectedRequeue {
+				t.Errorf("expected a requeue after %s, got %s", c.expectedRequeue, result.RequeueAfter)
+			}
+			if azureMachinePool.Status.Ready != c.expectedReady {
+				t.Errorf("expected ready %t, got %t", c.expectedReady, azureMachinePool.Status.Ready)
+			}
+			if azureMachinePool.Status.Replicas != c.expectedReplicas {
+				t.Errorf("expected %d replicas, got %d", c.expectedReplicas, azureMachinePool.Status.Replicas)
+			}
+		})
+	}
+}
+
+func TestAzureMachinePoolReconciler_ReconcileDelete(t *testing.T) {
+	cases := []struct {
+		name              string
+		deleteErr         error
+		expectedFinalizer bool
+		expectedError     string
+	}{
+		{
+			name: "delete the scale set and remove the finalizer",
+		},
+		{
+			name:              "keep the finalizer when the scale set cannot be deleted",
+			deleteErr:         errors.New("boom"),
+			expectedFinalizer: true,
+			expectedError:     "failed to delete scale set of machine pool my-pool: boom",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+			scaleSetsMock := mocks.NewMockGetterService(mockCtrl)
+			scaleSetsMock.EXPECT().Delete(gomock.Any(), &scalesets.Spec{Name: "my-pool"}).Return(c.deleteErr)
+
+			ams := newTestMachinePoolService(nil, scaleSetsMock)
+			azureMachinePool := ams.machinePoolScope.AzureMachinePool
+			azureMachinePool.Finalizers = []string{infrav1.MachinePoolFinalizer}
+
+			_, err := (&AzureMachinePoolReconciler{}).reconcileDelete(ams.machinePoolScope, ams)
+			if c.expectedError != "" {
+				if err == nil || err.Error() != c.expectedError {
+					t.Fatalf("expected error %q, got %v", c.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("got an unexpected error: %v", err)
+			}
+			if finalizer := util.Contains(azureMachinePool.Finalizers, infrav1.MachinePoolFinalizer); finalizer != c.expectedFinalizer {
+				t.Errorf("expected the finalizer %t, got %v", c.expectedFinalizer, azureMachinePool.Finalizers)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2020-06-01/compute"
+	"github.com/pkg/errors"
+	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/services/scalesets"
+)
+
+// azureMachinePoolService are the services required by the AzureMachinePool reconciler.
+type azureMachinePoolService struct {
+	machinePoolScope *scope.MachinePoolScope
+	clusterScope     *scope.ClusterScope
+	scaleSetsSvc     azure.GetterService
+}
+
+// newAzureMachinePoolService populates all the services based on input scope
+func newAzureMachinePoolService(machinePoolScope *scope.MachinePoolScope, clusterScope *scope.ClusterScope) *azureMachinePoolService {
+	return &azureMachinePoolService{
+		machinePoolScope: machinePoolScope,
+		clusterScope:     clusterScope,
+		scaleSetsSvc:     scalesets.NewService(clusterScope),
+	}
+}
+
+// Reconcile creates the scale set of the machine pool, or scales it to the desired number of instances, and returns
+// the scale set.
+func (s *azureMachinePoolService) Reconcile() (compute.VirtualMachineScaleSet, error) {
+	ssSpec, err := s.scaleSetSpec()
+	if err != nil {
+		return compute.VirtualMachineScaleSet{}, err
+	}
+	if err := s.scaleSetsSvc.Reconcile(s.clusterScope.Context, ssSpec); err != nil {
+		return compute.VirtualMachineScaleSet{}, err
+	}
+
+	result, err := s.scaleSetsSvc.Get(s.clusterScope.Context, &scalesets.Spec{Name: ssSpec.Name})
+	if err != nil {
+		return compute.VirtualMachineScaleSet{}, errors.Wrap(err, "failed to get scale set")
+	}
+	scaleSet, ok := result.(compute.VirtualMachineScaleSet)
+	if !ok {
+		return compute.VirtualMachineScaleSet{}, errors.New("returned incorrect scale set interface")
+	}
+	return scaleSet, nil
+}
+
+// Delete deletes the scale set of the machine pool, with all its instances.
+func (s *azureMachinePoolService) Delete() error {
+	return s.scaleSetsSvc.Delete(s.clusterScope.Context, &scalesets.Spec{Name: s.machinePoolScope.Name()})
+}
+
+// scaleSetSpec returns the spec of the scale set of the machine pool, whose nodes are in the node subnet of the
+// cluster.
+func (s *azureMachinePoolService) scaleSetSpec() (*scalesets.Spec, error) {
+	spec := s.machinePoolScope.AzureMachinePool.Spec
+	decoded, err := base64.StdEncoding.DecodeString(spec.SSHPublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode ssh public key")
+	}
+
+	image, err := s.image()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get VM image")
+	}
+
+	bootstrapData, err := s.machinePoolScope.GetBootstrapData()
+	if err != nil {
+		return nil, err
+	}
+
+	subnet := s.clusterScope.NodeSubnet()
+	if subnet == nil {
+		return nil, errors.Errorf("cluster %s has no node subnet", s.clusterScope.Name())
+	}
+
+	return &scalesets.Spec{
+		Name:           s.machinePoolScope.Name(),
+		Capacity:       int64(s.machinePoolScope.Replicas()),
+		Size:           spec.VMSize,
+		Image:          image,
+		SubnetID:       azure.GenerateSubnetID(s.clusterScope.SubscriptionID, s.clusterScope.Vnet().ResourceGroup, s.clusterScope.Vnet().Name, subnet.Name),
+		SSHKeyData:     string(decoded),
+		CustomData:     bootstrapData,
+		Role:           infrav1.Node,
+		AdditionalTags: s.machinePoolScope.AdditionalTags(),
+		SpotVMOptions:  spec.SpotVMOptions,
+	}, nil
+}
+
+// image returns the image of the instances of the machine pool, the Ubuntu image of its Kubernetes version by default.
+func (s *azureMachinePoolService) image() (infrav1.Image, error) {
+	if image := s.machinePoolScope.AzureMachinePool.Spec.Image; image != nil {
+		return *image, nil
+	}
+	return azure.GetDefaultUbuntuImage(s.machinePoolScope.Version())
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// terminalErrorRequeueInterval is the delay before a reconcile that failed with a terminal error is retried. Retrying
+// can't help until the spec of the resource is changed, which triggers a reconcile on its own, so it is long.
+const terminalErrorRequeueInterval = 10 * time.Minute
+
+// requeueOnError returns the result of a reconcile that returned the result and the error, requeuing it depending on
+// the error:
+//   - a terminal error, such as an invalid configuration, is logged and the reconcile is requeued after
+//     terminalErrorRequeueInterval.
+//   - a transient error whose response has a Retry-After header, such as a throttled request, is logged and the
+//     reconcile is requeued after the delay Azure asked for.
+//   - any other error is returned, so the controller requeues the reconcile with its exponential backoff, quickly at
+//     first.
+func requeueOnError(log logr.Logger, result reconcile.Result, err error) (reconcile.Result, error) {
+	if err == nil {
+		return result, nil
+	}
+	if azure.IsTerminalError(err) {
+		log.Error(err, "Reconcile failed with a terminal error, waiting for a spec change", "requeueAfter", terminalErrorRequeueInterval)
+		return reconcile.Result{RequeueAfter: terminalErrorRequeueInterval}, nil
+	}
+	if azure.IsTransientError(err) {
+		if delay, ok := azure.RetryAfter(err); ok && delay > 0 {
+			log.Error(err, "Reconcile failed with a transient error", "requeueAfter", delay)
+			return reconcile.Result{RequeueAfter: delay}, nil
+		}
+	}
+	return reconcile.Result{}, err
+}
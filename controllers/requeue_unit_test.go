@@ -0,0 +1,102 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	autorestazure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"k8s.io/klog/klogr"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRequeueOnError(t *testing.T) {
+	throttled := autorest.NewErrorWithResponse("", "", &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"30"}},
+	}, "Too Many Requests")
+	serverError := autorest.NewErrorWithResponse("", "", &http.Response{StatusCode: http.StatusInternalServerError}, "Internal Server Error")
+	invalidParameter := autorest.NewErrorWithError(&autorestazure.RequestError{
+		ServiceError: &autorestazure.ServiceError{Code: "InvalidParameter"},
+	}, "", "", &http.Response{StatusCode: http.StatusBadRequest}, "Bad Request")
+	notProvisioned := autorest.NewErrorWithError(&autorestazure.RequestError{
+		ServiceError: &autorestazure.ServiceError{Code: "ReferencedResourceNotProvisioned"},
+	}, "", "", &http.Response{StatusCode: http.StatusBadRequest}, "Bad Request")
+	notAvailable := azure.NewTerminalError(errors.New("VM size Standard_D2s_v3 is not available in location westus"))
+
+	testcases := []struct {
+		name           string
+		result         reconcile.Result
+		err            error
+		expectedResult reconcile.Result
+		expectError    bool
+	}{
+		{
+			name:           "success keeps the result",
+			result:         reconcile.Result{RequeueAfter: vpnGatewayPollInterval},
+			expectedResult: reconcile.Result{RequeueAfter: vpnGatewayPollInterval},
+		},
+		{
+			name:           "throttled request is requeued after the delay asked by Azure",
+			err:            errors.Wrap(throttled, "failed to create vnet"),
+			expectedResult: reconcile.Result{RequeueAfter: 30 * time.Second},
+		},
+		{
+			name:        "server error is returned for the exponential backoff",
+			err:         errors.Wrap(serverError, "failed to create vnet"),
+			expectError: true,
+		},
+		{
+			name:           "terminal error is requeued slowly",
+			err:            errors.Wrap(notAvailable, "failed to create AzureMachine VM"),
+			expectedResult: reconcile.Result{RequeueAfter: terminalErrorRequeueInterval},
+		},
+		{
+			name:           "invalid request is requeued slowly",
+			err:            errors.Wrap(invalidParameter, "failed to create vnet"),
+			expectedResult: reconcile.Result{RequeueAfter: terminalErrorRequeueInterval},
+		},
+		{
+			name:        "bad request that goes away on its own is returned for the exponential backoff",
+			err:         errors.Wrap(notProvisioned, "failed to create subnet"),
+			expectError: true,
+		},
+		{
+			name:        "other error is returned for the exponential backoff",
+			err:         errors.New("failed to patch AzureMachine"),
+			expectError: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			result, err := requeueOnError(klogr.New(), tc.result, tc.err)
+			if tc.expectError {
+				g.Expect(err).To(gomega.Equal(tc.err))
+			} else {
+				g.Expect(err).NotTo(gomega.HaveOccurred())
+			}
+			g.Expect(result).To(gomega.Equal(tc.expectedResult))
+		})
+	}
+}
@@ -101,6 +101,10 @@ export AZURE_RESOURCE_GROUP
 AZURE_LOCATION=${AZURE_LOCATION:?}
 export AZURE_LOCATION
 
+# One of AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud or AzureGermanCloud.
+AZURE_ENVIRONMENT=${AZURE_ENVIRONMENT:-AzurePublicCloud}
+export AZURE_ENVIRONMENT
+
 # Azure Credentials.
 SSH_KEY_FILE=${OUTPUT_DIR}/sshkey
 rm -f "${SSH_KEY_FILE}" 2>/dev/null
@@ -113,6 +117,7 @@ export AZURE_SUBSCRIPTION_ID_B64="$(echo -n "$AZURE_SUBSCRIPTION_ID" | base64 |
 export AZURE_TENANT_ID_B64="$(echo -n "$AZURE_TENANT_ID" | base64 | tr -d '\n')"
 export AZURE_CLIENT_ID_B64="$(echo -n "$AZURE_CLIENT_ID" | base64 | tr -d '\n')"
 export AZURE_CLIENT_SECRET_B64="$(echo -n "$AZURE_CLIENT_SECRET" | base64 | tr -d '\n')"
+export AZURE_ENVIRONMENT_B64="$(echo -n "$AZURE_ENVIRONMENT" | base64 | tr -d '\n')"
 
 # Generate cluster resources.
 kustomize build "${SOURCE_DIR}/cluster" | envsubst > "${CLUSTER_GENERATED_FILE}"
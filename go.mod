@@ -3,10 +3,10 @@ module sigs.k8s.io/cluster-api-provider-azure
 go 1.12
 
 require (
-	github.com/Azure/azure-sdk-for-go v34.2.0+incompatible
-	github.com/Azure/go-autorest/autorest v0.9.2
-	github.com/Azure/go-autorest/autorest/azure/auth v0.4.0
-	github.com/Azure/go-autorest/autorest/to v0.3.0
+	github.com/Azure/azure-sdk-for-go v46.4.0+incompatible
+	github.com/Azure/go-autorest/autorest v0.11.4
+	github.com/Azure/go-autorest/autorest/azure/auth v0.5.1
+	github.com/Azure/go-autorest/autorest/to v0.4.0
 	github.com/Azure/go-autorest/autorest/validation v0.2.0 // indirect
 	github.com/blang/semver v3.5.0+incompatible
 	github.com/go-logr/logr v0.1.0
@@ -16,8 +16,12 @@ require (
 	github.com/onsi/gomega v1.7.0
 	github.com/pelletier/go-toml v1.6.0
 	github.com/pkg/errors v0.8.1
-	golang.org/x/crypto v0.0.0-20190911031432-227b76d455e7
+	github.com/prometheus/client_golang v1.0.0
+	github.com/prometheus/client_model v0.0.0-20190129233127-fd36f4220a90
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20190909003024-a7b16738d86b
+	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	k8s.io/api v0.0.0-20190918195907-bd6ac527cfd2
 	k8s.io/apimachinery v0.0.0-20190817020851-f2f3a405f61d
 	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
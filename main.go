@@ -28,6 +28,8 @@ import (
 	"k8s.io/klog"
 	"k8s.io/klog/klogr"
 	infrav1 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha2"
+	azure "sigs.k8s.io/cluster-api-provider-azure/cloud"
+	"sigs.k8s.io/cluster-api-provider-azure/cloud/scope"
 	"sigs.k8s.io/cluster-api-provider-azure/controllers"
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1alpha2"
 	"sigs.k8s.io/cluster-api/util/record"
@@ -59,6 +61,11 @@ func main() {
 		azureClusterConcurrency int
 		azureMachineConcurrency int
 		syncPeriod              time.Duration
+		webhookPort             int
+		azureAPIQPS             float64
+		azureAPIBurst           int
+		dryRun                  bool
+		enableMachinePools      bool
 	)
 
 	flag.StringVar(
@@ -107,6 +114,36 @@ func main() {
 		"The minimum interval at which watched resources are reconciled (e.g. 15m)",
 	)
 
+	flag.IntVar(&webhookPort,
+		"webhook-port",
+		0,
+		"Port the webhook server serves the validating webhooks at, the webhooks are disabled unless set (e.g. 443)",
+	)
+
+	flag.Float64Var(&azureAPIQPS,
+		"azure-api-qps",
+		azure.DefaultQPS,
+		"Maximum rate of the requests to the Azure API of a subscription, in requests per second, beyond which requests are delayed",
+	)
+
+	flag.IntVar(&azureAPIBurst,
+		"azure-api-burst",
+		azure.DefaultBurst,
+		"Maximum number of requests to the Azure API of a subscription sent at once without waiting for the rate",
+	)
+
+	flag.BoolVar(&dryRun,
+		"dry-run",
+		false,
+		"Log the changes the controllers would make to the Azure resources, without making them nor updating the status of the AzureClusters and AzureMachines",
+	)
+
+	flag.BoolVar(&enableMachinePools,
+		"enable-machine-pools",
+		false,
+		"Enable the experimental AzureMachinePool controller, which backs pools of worker nodes with VM scale sets",
+	)
+
 	flag.Parse()
 
 	if watchNamespace != "" {
@@ -129,6 +166,7 @@ func main() {
 		LeaderElectionID:   "controller-leader-election-capz",
 		SyncPeriod:         &syncPeriod,
 		Namespace:          watchNamespace,
+		Port:               webhookPort,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -138,20 +176,46 @@ func main() {
 	// Initialize event recorder.
 	record.InitFromRecorder(mgr.GetEventRecorderFor("azure-controller"))
 
+	azureClients := scope.AzureClients{QPS: float32(azureAPIQPS), Burst: azureAPIBurst}
 	if err = (&controllers.AzureMachineReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("AzureMachine"),
+		Client:       mgr.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("AzureMachine"),
+		AzureClients: azureClients,
+		DryRun:       dryRun,
 	}).SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: azureMachineConcurrency}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AzureMachine")
 		os.Exit(1)
 	}
 	if err = (&controllers.AzureClusterReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("AzureCluster"),
+		Client:       mgr.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("AzureCluster"),
+		AzureClients: azureClients,
+		DryRun:       dryRun,
 	}).SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: azureClusterConcurrency}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AzureCluster")
 		os.Exit(1)
 	}
+	if enableMachinePools {
+		if err = (&controllers.AzureMachinePoolReconciler{
+			Client:       mgr.GetClient(),
+			Log:          ctrl.Log.WithName("controllers").WithName("AzureMachinePool"),
+			AzureClients: azureClients,
+			DryRun:       dryRun,
+		}).SetupWithManager(mgr, controller.Options{MaxConcurrentReconciles: azureMachineConcurrency}); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "AzureMachinePool")
+			os.Exit(1)
+		}
+	}
+	if webhookPort != 0 {
+		if err = (&infrav1.AzureCluster{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AzureCluster")
+			os.Exit(1)
+		}
+		if err = (&infrav1.AzureMachine{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "AzureMachine")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")